@@ -0,0 +1,398 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/transport/v3/deadline"
+)
+
+const (
+	bondHeaderLength          = 4 + 8 + 8
+	bondReadBacklog           = 128
+	defaultBondReorderWindow  = 64
+	defaultBondReorderTimeout = 50 * time.Millisecond
+
+	// bondIdleTimeout is how long the receive state of a remote is kept after its last
+	// packet. The sequence numbers to an address are kept twice as long after the last
+	// packet sent to it, so the other end has forgotten them once they restart at 0
+	bondIdleTimeout   = time.Minute
+	bondSweepInterval = 10 * time.Second
+)
+
+// bondMagic starts every frame of a BondedConn
+var bondMagic = []byte("BOND") //nolint:gochecknoglobals
+
+// BondMode controls how a BondedConn spreads packets over its paths
+type BondMode int
+
+const (
+	// BondStripe sends every packet on one path, taking turns. This is the default
+	BondStripe BondMode = iota
+	// BondDuplicate sends every packet on all paths, the receiver drops the duplicates
+	BondDuplicate
+)
+
+// BondConfig configures a BondedConn
+type BondConfig struct {
+	// Mode controls how packets are spread over the paths. Defaults to BondStripe
+	Mode BondMode
+	// ReorderWindow is the number of packets held back to wait for a missing one.
+	// Defaults to 64
+	ReorderWindow int
+	// ReorderTimeout is how long packets are held back to wait for a missing one.
+	// Defaults to 50 milliseconds
+	ReorderTimeout time.Duration
+}
+
+// BondedConn is an experimental net.PacketConn that bonds several paths, e.g. the relayed
+// conns of allocations on two TURN servers, or over UDP and TCP to one server, for
+// resilience on lossy access networks. Packets are framed with a sequence number and put
+// back in order on receive, so both ends must use a BondedConn. The remote end may bond a
+// single path, it answers on all the paths it received packets from.
+type BondedConn struct {
+	paths  []net.PacketConn
+	config BondConfig
+	id     uint64
+
+	lock      sync.Mutex
+	next      int
+	seqs      map[string]*bondSeq
+	remotes   map[uint64]*bondRemote
+	lastSweep time.Time
+
+	packets      chan bondPacket
+	closed       chan struct{}
+	closeOnce    sync.Once
+	readDeadline *deadline.Deadline
+}
+
+type bondPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+type bondRoute struct {
+	path int
+	addr net.Addr
+}
+
+// bondSeq is the send state of the BondedConn to an address
+type bondSeq struct {
+	next     uint64
+	lastSent time.Time
+}
+
+// bondRemote is the receive state of the BondedConn at the other end
+type bondRemote struct {
+	// addr is the address of the first route, it is returned by ReadFrom
+	addr     net.Addr
+	routes   []bondRoute
+	next     uint64
+	held     map[uint64][]byte
+	timer    *time.Timer
+	lastSeen time.Time
+}
+
+// NewBondedConn bonds paths into one net.PacketConn. The BondedConn owns the paths
+func NewBondedConn(paths []net.PacketConn, config BondConfig) (*BondedConn, error) {
+	if len(paths) == 0 {
+		return nil, errBondNoPaths
+	}
+	if config.ReorderWindow == 0 {
+		config.ReorderWindow = defaultBondReorderWindow
+	}
+	if config.ReorderTimeout == 0 {
+		config.ReorderTimeout = defaultBondReorderTimeout
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	b := &BondedConn{
+		paths:        append([]net.PacketConn{}, paths...),
+		config:       config,
+		id:           binary.BigEndian.Uint64(id),
+		seqs:         map[string]*bondSeq{},
+		remotes:      map[uint64]*bondRemote{},
+		lastSweep:    time.Now(),
+		packets:      make(chan bondPacket, bondReadBacklog),
+		closed:       make(chan struct{}),
+		readDeadline: deadline.New(),
+	}
+	for i := range b.paths {
+		go b.readLoop(i)
+	}
+
+	return b, nil
+}
+
+func (b *BondedConn) readLoop(path int) {
+	buf := make([]byte, maxDataBufferSize)
+	for {
+		n, addr, err := b.paths[path].ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if n < bondHeaderLength || !bytes.Equal(buf[:4], bondMagic) {
+			continue
+		}
+
+		id := binary.BigEndian.Uint64(buf[4:12])
+		seq := binary.BigEndian.Uint64(buf[12:20])
+		b.receive(id, seq, append([]byte{}, buf[bondHeaderLength:n]...), bondRoute{path: path, addr: addr})
+	}
+}
+
+func (b *BondedConn) receive(id, seq uint64, data []byte, route bondRoute) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	now := time.Now()
+	b.sweep(now)
+
+	remote, ok := b.remotes[id]
+	if !ok {
+		remote = &bondRemote{addr: route.addr, held: map[uint64][]byte{}}
+		b.remotes[id] = remote
+	}
+	remote.addRoute(route)
+	remote.lastSeen = now
+
+	// Sequence numbers start at 0, the first packets may arrive on another path late
+	if _, ok := remote.held[seq]; ok || seq < remote.next {
+		return // Duplicate or too late
+	}
+	remote.held[seq] = data
+
+	b.flush(remote)
+	if len(remote.held) > b.config.ReorderWindow {
+		b.skip(remote)
+	}
+	b.startReorderTimer(remote)
+}
+
+// startReorderTimer skips the missing packets of remote after ReorderTimeout if packets
+// are held back. The timer is started again while packets are left after a skip, e.g.
+// after the next gap
+func (b *BondedConn) startReorderTimer(remote *bondRemote) {
+	if len(remote.held) == 0 || remote.timer != nil {
+		return
+	}
+
+	remote.timer = time.AfterFunc(b.config.ReorderTimeout, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		remote.timer = nil
+		select {
+		case <-b.closed:
+			return
+		default:
+		}
+
+		b.skip(remote)
+		b.startReorderTimer(remote)
+	})
+}
+
+// flush delivers the packets of remote that are in order
+func (b *BondedConn) flush(remote *bondRemote) {
+	for {
+		data, ok := remote.held[remote.next]
+		if !ok {
+			break
+		}
+		delete(remote.held, remote.next)
+		remote.next++
+
+		select {
+		case b.packets <- bondPacket{data: data, addr: remote.addr}:
+		default: // Drop, the BondedConn is not read fast enough
+		}
+	}
+
+	if len(remote.held) == 0 && remote.timer != nil {
+		remote.timer.Stop()
+		remote.timer = nil
+	}
+}
+
+// skip gives up waiting for the missing packets of remote before the first one held back
+func (b *BondedConn) skip(remote *bondRemote) {
+	if len(remote.held) == 0 {
+		return
+	}
+
+	first := true
+	for seq := range remote.held {
+		if first || seq < remote.next {
+			remote.next, first = seq, false
+		}
+	}
+	b.flush(remote)
+}
+
+// sweep removes the remotes and the sequence numbers that are idle, at most every
+// bondSweepInterval
+func (b *BondedConn) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < bondSweepInterval {
+		return
+	}
+	b.lastSweep = now
+
+	for id, remote := range b.remotes {
+		if now.Sub(remote.lastSeen) >= bondIdleTimeout {
+			if remote.timer != nil {
+				remote.timer.Stop()
+			}
+			delete(b.remotes, id)
+		}
+	}
+	for addr, seq := range b.seqs {
+		if now.Sub(seq.lastSent) >= 2*bondIdleTimeout {
+			delete(b.seqs, addr)
+		}
+	}
+}
+
+func (r *bondRemote) addRoute(route bondRoute) {
+	for _, known := range r.routes {
+		if known.path == route.path && known.addr.String() == route.addr.String() {
+			return
+		}
+	}
+	r.routes = append(r.routes, route)
+}
+
+// routes returns the routes to addr. Remotes are known by the address ReadFrom returned
+// for them, packets to other addresses are sent on all paths
+func (b *BondedConn) routes(addr net.Addr) []bondRoute {
+	for _, remote := range b.remotes {
+		if remote.addr.String() == addr.String() {
+			return remote.routes
+		}
+	}
+
+	routes := make([]bondRoute, len(b.paths))
+	for i := range b.paths {
+		routes[i] = bondRoute{path: i, addr: addr}
+	}
+	return routes
+}
+
+// ReadFrom reads the next packet in order
+func (b *BondedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case packet := <-b.packets:
+		return copy(p, packet.data), packet.addr, nil
+	case <-b.readDeadline.Done():
+		return 0, nil, b.readDeadline.Err()
+	case <-b.closed:
+		return 0, nil, errBondClosed
+	}
+}
+
+// WriteTo sends p to addr on one or all paths, depending on the BondMode
+func (b *BondedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	b.lock.Lock()
+	now := time.Now()
+	b.sweep(now)
+
+	state, ok := b.seqs[addr.String()]
+	if !ok {
+		state = &bondSeq{}
+		b.seqs[addr.String()] = state
+	}
+	seq := state.next
+	state.next++
+	state.lastSent = now
+
+	routes := b.routes(addr)
+	if b.config.Mode == BondStripe {
+		routes = []bondRoute{routes[b.next%len(routes)]}
+		b.next++
+	}
+	b.lock.Unlock()
+
+	frame := make([]byte, bondHeaderLength+len(p))
+	copy(frame, bondMagic)
+	binary.BigEndian.PutUint64(frame[4:12], b.id)
+	binary.BigEndian.PutUint64(frame[12:20], seq)
+	copy(frame[bondHeaderLength:], p)
+
+	// Sending fails only if no path is left
+	var err error
+	sent := false
+	for _, route := range routes {
+		if _, writeErr := b.paths[route.path].WriteTo(frame, route.addr); writeErr != nil {
+			err = writeErr
+			continue
+		}
+		sent = true
+	}
+	if !sent {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Close closes all paths
+func (b *BondedConn) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		close(b.closed)
+
+		b.lock.Lock()
+		for _, remote := range b.remotes {
+			if remote.timer != nil {
+				remote.timer.Stop()
+			}
+		}
+		b.lock.Unlock()
+
+		for _, path := range b.paths {
+			if closeErr := path.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	})
+	return err
+}
+
+// LocalAddr returns the local address of the first path
+func (b *BondedConn) LocalAddr() net.Addr {
+	return b.paths[0].LocalAddr()
+}
+
+// SetDeadline sets the read and write deadlines
+func (b *BondedConn) SetDeadline(t time.Time) error {
+	b.readDeadline.Set(t)
+	return b.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline
+func (b *BondedConn) SetReadDeadline(t time.Time) error {
+	b.readDeadline.Set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline of all paths
+func (b *BondedConn) SetWriteDeadline(t time.Time) error {
+	for _, path := range b.paths {
+		if err := path.SetWriteDeadline(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/transport/v3/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listenBond(t *testing.T, paths int, config BondConfig) *BondedConn {
+	t.Helper()
+
+	conns := make([]net.PacketConn, paths)
+	for i := range conns {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		conns[i] = conn
+	}
+
+	b, err := NewBondedConn(conns, config)
+	require.NoError(t, err)
+	return b
+}
+
+func readBond(t *testing.T, b *BondedConn) (string, net.Addr) {
+	t.Helper()
+
+	buf := make([]byte, 1500)
+	require.NoError(t, b.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, addr, err := b.ReadFrom(buf)
+	require.NoError(t, err)
+	require.NoError(t, b.SetReadDeadline(time.Time{}))
+	return string(buf[:n]), addr
+}
+
+func TestBondedConnReorder(t *testing.T) {
+	b := listenBond(t, 1, BondConfig{ReorderWindow: 2, ReorderTimeout: 50 * time.Millisecond})
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	route := bondRoute{path: 0, addr: addr}
+
+	b.receive(1, 0, []byte("0"), route)
+	b.receive(1, 2, []byte("2"), route)
+	b.receive(1, 1, []byte("1"), route)
+	b.receive(1, 1, []byte("1"), route)
+	b.receive(1, 0, []byte("0"), route)
+	for _, expected := range []string{"0", "1", "2"} {
+		data, from := readBond(t, b)
+		assert.Equal(t, expected, data)
+		assert.Equal(t, addr, from)
+	}
+
+	t.Run("window", func(t *testing.T) {
+		b.receive(1, 4, []byte("4"), route)
+		b.receive(1, 5, []byte("5"), route)
+		b.receive(1, 6, []byte("6"), route)
+		for _, expected := range []string{"4", "5", "6"} {
+			data, _ := readBond(t, b)
+			assert.Equal(t, expected, data)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		b.receive(1, 8, []byte("8"), route)
+		data, _ := readBond(t, b)
+		assert.Equal(t, "8", data)
+
+		b.receive(1, 7, []byte("7"), route)
+		b.receive(1, 9, []byte("9"), route)
+		data, _ = readBond(t, b)
+		assert.Equal(t, "9", data)
+	})
+
+	t.Run("gaps", func(t *testing.T) {
+		// 10 and 12 are lost, every gap is skipped after the timeout
+		b.receive(1, 11, []byte("11"), route)
+		b.receive(1, 13, []byte("13"), route)
+		for _, expected := range []string{"11", "13"} {
+			data, _ := readBond(t, b)
+			assert.Equal(t, expected, data)
+		}
+
+		b.lock.Lock()
+		assert.Empty(t, b.remotes[1].held)
+		assert.Nil(t, b.remotes[1].timer)
+		b.lock.Unlock()
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		_, err := b.WriteTo([]byte("a"), addr)
+		assert.NoError(t, err)
+
+		b.lock.Lock()
+		defer b.lock.Unlock()
+
+		// Idle remotes are removed, their sequence numbers are kept longer
+		now := time.Now()
+		b.sweep(now.Add(bondIdleTimeout))
+		assert.Empty(t, b.remotes)
+		assert.Len(t, b.seqs, 1)
+		b.sweep(now.Add(2 * bondIdleTimeout))
+		assert.Empty(t, b.seqs)
+	})
+
+	assert.NoError(t, b.Close())
+	_, _, err := b.ReadFrom(make([]byte, 10))
+	assert.ErrorIs(t, err, errBondClosed)
+
+	_, err = NewBondedConn(nil, BondConfig{})
+	assert.ErrorIs(t, err, errBondNoPaths)
+}
+
+func TestBondedConn(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, mode := range []BondMode{BondStripe, BondDuplicate} {
+		mode := mode
+		t.Run(fmt.Sprint(mode), func(t *testing.T) {
+			client := listenBond(t, 2, BondConfig{Mode: mode})
+			peer := listenBond(t, 1, BondConfig{Mode: mode})
+
+			for i := 0; i < 4; i++ {
+				_, err := client.WriteTo([]byte(fmt.Sprint(i)), peer.LocalAddr())
+				require.NoError(t, err)
+			}
+
+			// The packets arrive once and in order, from the address of the first path
+			var clientAddr net.Addr
+			for i := 0; i < 4; i++ {
+				data, from := readBond(t, peer)
+				assert.Equal(t, fmt.Sprint(i), data)
+				if clientAddr == nil {
+					clientAddr = from
+				}
+				assert.Equal(t, clientAddr.String(), from.String())
+			}
+
+			// The peer answers on both paths
+			for i := 0; i < 4; i++ {
+				_, err := peer.WriteTo([]byte(fmt.Sprint(i)), clientAddr)
+				require.NoError(t, err)
+			}
+			for i := 0; i < 4; i++ {
+				data, from := readBond(t, client)
+				assert.Equal(t, fmt.Sprint(i), data)
+				assert.Equal(t, peer.LocalAddr().String(), from.String())
+			}
+
+			peer.lock.Lock()
+			for _, remote := range peer.remotes {
+				assert.Len(t, remote.routes, 2)
+			}
+			peer.lock.Unlock()
+
+			assert.NoError(t, client.Close())
+			assert.NoError(t, peer.Close())
+		})
+	}
+}
+
+func TestBondedConnRelay(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	// Two allocations stand in for two servers
+	var clients []*Client
+	var conns, relayConns []net.PacketConn
+	for i := 0; i < 2; i++ {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       "user",
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		relayConn, err := client.Allocate()
+		require.NoError(t, err)
+
+		clients = append(clients, client)
+		conns = append(conns, conn)
+		relayConns = append(relayConns, relayConn)
+	}
+
+	bonded, err := NewBondedConn(relayConns, BondConfig{Mode: BondDuplicate})
+	require.NoError(t, err)
+	peer := listenBond(t, 1, BondConfig{})
+
+	_, err = bonded.WriteTo([]byte("hello"), peer.LocalAddr())
+	require.NoError(t, err)
+	data, from := readBond(t, peer)
+	assert.Equal(t, "hello", data)
+
+	_, err = peer.WriteTo([]byte("world"), from)
+	require.NoError(t, err)
+	data, _ = readBond(t, bonded)
+	assert.Equal(t, "world", data)
+
+	assert.NoError(t, bonded.Close())
+	assert.NoError(t, peer.Close())
+	for i := range clients {
+		clients[i].Close()
+		assert.NoError(t, conns[i].Close())
+	}
+	assert.NoError(t, server.Close())
+}
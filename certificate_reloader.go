@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// CertificateReloader serves a TLS certificate loaded from a PEM encoded certificate and key
+// file, and loads it again when either file changes. Use GetCertificate as
+// tls.Config.GetCertificate. Only new TLS handshakes see a reloaded certificate, established
+// connections and the allocations created over them are not affected.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	lock    sync.Mutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// NewCertificateReloader creates a CertificateReloader and loads the certificate
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload loads the certificate and key files. The previous certificate is kept if they
+// can't be loaded
+func (r *CertificateReloader) Reload() error {
+	certMod, keyMod, err := r.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cert = &cert
+	r.certMod = certMod
+	r.keyMod = keyMod
+
+	return nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the certificate or
+// key file was modified since it was loaded
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certMod, keyMod, err := r.modTimes()
+
+	r.lock.Lock()
+	changed := err == nil && (!certMod.Equal(r.certMod) || !keyMod.Equal(r.keyMod))
+	cert := r.cert
+	r.lock.Unlock()
+
+	// A failed reload, e.g. while only one of the files is replaced, keeps the
+	// previous certificate and is retried on the next handshake
+	if changed && r.Reload() == nil {
+		r.lock.Lock()
+		cert = r.cert
+		r.lock.Unlock()
+	}
+
+	return cert, nil
+}
+
+func (r *CertificateReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
@@ -0,0 +1,263 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun/v2"
+	"github.com/pion/transport/v3/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeCertificate writes a self-signed certificate with the serial number to certFile
+// and keyFile, and sets their modification time to modTime
+func writeCertificate(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+func TestServerTLSCertificateReload(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	dir, err := ioutil.TempDir("", "turn")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	}()
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := tcpListener.Addr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener: tcpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+				CertFile: certFile,
+				KeyFile:  keyFile,
+			},
+		},
+		Realm:         "pion.ly",
+		LoggerFactory: loggerFactory,
+	})
+	require.NoError(t, err)
+
+	dial := func(serial int64) *tls.Conn {
+		conn, dialErr := tls.Dial("tcp4", serverAddr, &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec
+		})
+		require.NoError(t, dialErr)
+		assert.Equal(t, big.NewInt(serial), conn.ConnectionState().PeerCertificates[0].SerialNumber)
+
+		return conn
+	}
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	conn := dial(1)
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           NewSTUNConn(conn),
+		Username:       "user",
+		Password:       "pass",
+		LoggerFactory:  loggerFactory,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	relay := func(msg string) {
+		_, writeErr := relayConn.WriteTo([]byte(msg), peer.LocalAddr())
+		require.NoError(t, writeErr)
+
+		buf := make([]byte, 64)
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, msg, string(buf[:n]))
+	}
+	relay("before")
+
+	// New connections get the rotated certificate, the existing allocation keeps working
+	writeCertificate(t, certFile, keyFile, 2, time.Now().Add(time.Hour))
+	assert.NoError(t, dial(2).Close())
+	relay("after")
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestListenerConfigTLSValidation(t *testing.T) {
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, tcpListener.Close())
+	}()
+
+	for name, cfg := range map[string]ListenerConfig{
+		"MissingKeyFile":  {CertFile: "server.crt"},
+		"Datagram":        {TLSConfig: &tls.Config{MinVersion: tls.VersionTLS12}, Datagram: true},
+		"ResumptionNoTLS": {SessionResumption: &TLSSessionResumption{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg.Listener = tcpListener
+			cfg.RelayAddressGenerator = &RelayAddressGeneratorNone{Address: "127.0.0.1"}
+			assert.Error(t, cfg.validate())
+		})
+	}
+
+	_, err = NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return nil, false
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener:              tcpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"},
+				CertFile:              "missing.crt",
+				KeyFile:               "missing.key",
+			},
+		},
+	})
+	assert.Error(t, err)
+}
+
+func TestServerTLSSessionResumption(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	dir, err := ioutil.TempDir("", "turn")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, os.RemoveAll(dir))
+	}()
+
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+
+	ticketKey := [32]byte{1, 2, 3}
+	newListener := func(resumption *TLSSessionResumption) (ListenerConfig, string) {
+		tcpListener, listenErr := net.Listen("tcp4", "127.0.0.1:0")
+		require.NoError(t, listenErr)
+
+		return ListenerConfig{
+			Listener:              tcpListener,
+			RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"},
+			CertFile:              certFile,
+			KeyFile:               keyFile,
+			SessionResumption:     resumption,
+		}, tcpListener.Addr().String()
+	}
+
+	shared1, shared1Addr := newListener(&TLSSessionResumption{TicketKeys: [][32]byte{ticketKey}})
+	shared2, shared2Addr := newListener(&TLSSessionResumption{TicketKeys: [][32]byte{ticketKey}})
+	disabled, disabledAddr := newListener(&TLSSessionResumption{Disabled: true})
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return nil, false
+		},
+		ListenerConfigs: []ListenerConfig{shared1, shared2, disabled},
+		LoggerFactory:   logging.NewDefaultLoggerFactory(),
+	})
+	require.NoError(t, err)
+
+	// dial sends a Binding request, the response carries the TLS 1.3 session ticket
+	dial := func(addr string, cache tls.ClientSessionCache) bool {
+		conn, dialErr := tls.Dial("tcp4", addr, &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec
+			ClientSessionCache: cache,
+		})
+		require.NoError(t, dialErr)
+
+		msg, buildErr := stun.Build(stun.TransactionID, stun.BindingRequest, stun.Fingerprint)
+		require.NoError(t, buildErr)
+		_, writeErr := conn.Write(msg.Raw)
+		require.NoError(t, writeErr)
+
+		buf := make([]byte, 1500)
+		_, readErr := conn.Read(buf)
+		require.NoError(t, readErr)
+
+		resumed := conn.ConnectionState().DidResume
+		assert.NoError(t, conn.Close())
+		return resumed
+	}
+
+	cache := tls.NewLRUClientSessionCache(8)
+	assert.False(t, dial(shared1Addr, cache))
+	assert.True(t, dial(shared1Addr, cache))
+
+	// Listeners sharing ticket keys resume each other's sessions
+	assert.True(t, dial(shared2Addr, cache))
+
+	disabledCache := tls.NewLRUClientSessionCache(8)
+	assert.False(t, dial(disabledAddr, disabledCache))
+	assert.False(t, dial(disabledAddr, disabledCache))
+
+	assert.NoError(t, server.Close())
+}
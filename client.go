@@ -4,6 +4,8 @@
 package turn
 
 import (
+	"context"
+	"crypto/tls"
 	b64 "encoding/base64"
 	"fmt"
 	"math"
@@ -16,7 +18,10 @@ import (
 	"github.com/pion/transport/v3"
 	"github.com/pion/transport/v3/stdnet"
 	"github.com/pion/turn/v3/internal/client"
+	"github.com/pion/turn/v3/internal/ipnet"
 	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/internal/server"
+	"github.com/pion/turn/v3/tracing"
 )
 
 const (
@@ -35,6 +40,16 @@ const (
 // 6: 31500 ms  +32000
 // -: 63500 ms  failed
 
+// RequestedAddressFamily is the address family of the relayed transport address requested by
+// the Client, see RFC 6156
+type RequestedAddressFamily = proto.RequestedAddressFamily
+
+// Address families of relayed transport addresses
+const (
+	RequestedAddressFamilyIPv4 = proto.RequestedFamilyIPv4
+	RequestedAddressFamilyIPv6 = proto.RequestedFamilyIPv6
+)
+
 // ClientConfig is a bag of config parameters for Client.
 type ClientConfig struct {
 	STUNServerAddr string // STUN server address (e.g. "stun.abc.com:3478")
@@ -44,23 +59,238 @@ type ClientConfig struct {
 	Realm          string
 	Software       string
 	RTO            time.Duration
-	Conn           net.PacketConn // Listening socket (net.PacketConn)
+	Conn           net.PacketConn // Listening socket (net.PacketConn), or a connected *net.UDPConn
 	Net            transport.Net
 	LoggerFactory  logging.LoggerFactory
+
+	// TransactionIDGenerator, if set, provides the transaction ID of every STUN message sent
+	// by the client instead of crypto/rand, e.g. for deterministic tests or to encode shard
+	// hints. Generated IDs must still look random unless InsecureTransactionIDs is set.
+	TransactionIDGenerator func() ([stun.TransactionIDSize]byte, error)
+
+	// InsecureTransactionIDs disables the validation of the IDs returned by
+	// TransactionIDGenerator. This must only be used for testing.
+	InsecureTransactionIDs bool
+
+	// OnUnpermittedData, if set, enables a diagnostic mode for misbehaving servers: Data
+	// indications from peers the client never created a permission for are passed to this
+	// callback instead of being delivered to the relayed connection. When unset such data is
+	// delivered as usual.
+	OnUnpermittedData func(from net.Addr, data []byte)
+
+	// RequestedAddressFamily selects the address family of the relayed transport address
+	// of allocations. If unset no REQUESTED-ADDRESS-FAMILY is sent and the server relays
+	// over IPv4. Servers that can't relay over the family answer with a 440 (Address
+	// Family not Supported) error.
+	RequestedAddressFamily RequestedAddressFamily
+
+	// Mobility requests RFC 8016 mobility for allocations. The server grants a
+	// MOBILITY-TICKET that keeps the allocation when the local address changes, call
+	// Rehome with a connection bound to the new address to move the allocation. Servers
+	// without mobility support answer with a 405 (Mobility Forbidden) error.
+	Mobility bool
+
+	// OAuth authenticates with RFC 7635 third-party authorization instead of Username and
+	// Password. The access token is sent with every authenticated request.
+	OAuth *OAuthCredentials
+
+	// CredentialProvider enables the renewal of expiring credentials. It is called
+	// CredentialRenewalMargin before the credential the client uses expires, and returns
+	// the username and password the allocations are refreshed with from then on. Username
+	// and Password are used until the first renewal. Not used with OAuth.
+	CredentialProvider func() (username, password string, err error)
+
+	// CredentialExpiry returns the expiry of the credential of username, and false if it
+	// doesn't expire. Defaults to the timestamp of usernames formatted as timestamp or
+	// timestamp:username, as generated by GenerateLongTermTURNRESTCredentials.
+	CredentialExpiry func(username string) (time.Time, bool)
+
+	// CredentialRenewalMargin is how long before the expiry of the credential new
+	// credentials are fetched. Defaults to 1 minute.
+	CredentialRenewalMargin time.Duration
+
+	// OnCredentialRenewalFailed, if set, is called when CredentialProvider fails or the
+	// server rejects the new credentials. Renewal is retried every 10 seconds until the
+	// current credential expires.
+	OnCredentialRenewalFailed func(err error)
+
+	// ResolveAddr resolves STUNServerAddr, TURNServerAddr and peer addresses passed to the
+	// client or its allocations that aren't a *net.UDPAddr or *net.TCPAddr, with network
+	// "udp4" or "udp6" for the servers and "udp" for peers. Use it if Conn wraps a connection
+	// that isn't a socket, e.g. a NWConnection passed from Swift in an Apple Network Extension,
+	// and the Go resolver can't be used. Defaults to Net.ResolveUDPAddr.
+	ResolveAddr func(network, address string) (*net.UDPAddr, error)
+
+	// Resolver, if set, looks up the host names of the server and peer addresses unless
+	// ResolveAddr is set, e.g. a *net.Resolver with a custom Dial for split-horizon DNS or
+	// DNS over HTTPS. Server addresses without a port, e.g. "turn.example.com", are looked
+	// up with the _stun._udp and _turn._udp SRV records with Resolver, or net.DefaultResolver
+	// if unset, and fall back to port 3478.
+	Resolver Resolver
+
+	// LocalAddr is the IP or IP:port the client binds its socket to if Conn is nil, e.g. on
+	// multi-homed hosts where the default route isn't the media network. The socket is
+	// closed with the client.
+	LocalAddr string
+
+	// Interface is the name of the network interface the client binds its socket to if Conn
+	// is nil. The socket is bound to the first address of the interface unless LocalAddr is
+	// set, on Linux it is also bound to the interface with SO_BINDTODEVICE, which requires
+	// CAP_NET_RAW on older kernels.
+	Interface string
+
+	// IPv6Only resolves STUNServerAddr and TURNServerAddr to IPv6 addresses, falling back to
+	// IPv4 addresses synthesized with the NAT64 prefix for servers without one. It is
+	// enabled automatically if Conn is bound to an IPv6 address, or to the unspecified
+	// address on a host without IPv4 connectivity.
+	IPv6Only bool
+
+	// NAT64Prefix is the /96 prefix IPv4 addresses are synthesized with on IPv6-only
+	// networks, e.g. 64:ff9b::/96. It is discovered with RFC 7050 if unset. Peer addresses
+	// within the prefix are translated to IPv4 for allocations with an IPv4 relayed address,
+	// so v4 peers learned through DNS64 can be reached.
+	NAT64Prefix *net.IPNet
+
+	// AffinityToken is the AFFINITY-TOKEN to send with the first Allocate request, e.g.
+	// one returned by AffinityToken before reconnecting, so a load balancer routes the
+	// client to the server holding its allocation. Replaced by the token of the server.
+	AffinityToken []byte
+
+	// Lifetime is the LIFETIME requested for allocations with every Allocate and Refresh
+	// request. The server may grant less, see GrantedLifetime. If unset the server grants
+	// its default lifetime, which is requested again with every Refresh.
+	Lifetime time.Duration
+
+	// RefreshInterval returns how long after each Allocate or Refresh response allocations
+	// are refreshed, for the lifetime granted with it. Defaults to half of the granted
+	// lifetime, at most 5 minutes.
+	RefreshInterval func(granted time.Duration) time.Duration
+
+	// EagerConnectivityChecks sends STUN Binding requests, e.g. the connectivity checks of
+	// ICE, to peers without a permission right away instead of after the CreatePermission
+	// transaction. The server drops the checks that arrive before the permission, which
+	// ICE retransmits anyway, and the first check isn't delayed by a relay round trip.
+	EagerConnectivityChecks bool
+
+	// DisableChannelBindings never binds channels to peers, all data is relayed in Send and
+	// Data indications instead of ChannelData, e.g. behind middleboxes that mishandle
+	// ChannelData. Indications cost at least 32 bytes more per datagram. PreauthorizePeers
+	// then only creates permissions, and SetChannelKeepalive fails.
+	DisableChannelBindings bool
+
+	// ChannelUpgradePackets and ChannelUpgradeBytes delay binding a channel to a peer until
+	// the flow to it is sustained: data is sent in Send indications until as many datagrams
+	// or bytes were sent to the peer within ChannelUpgradeWindow, so short flows such as a
+	// single connectivity check don't cost a ChannelBind transaction. A channel is bound
+	// with the first datagram if both are unset.
+	ChannelUpgradePackets int
+	ChannelUpgradeBytes   int
+
+	// ChannelUpgradeWindow is the duration ChannelUpgradePackets and ChannelUpgradeBytes
+	// are counted in, from the first datagram to the peer. Defaults to 10 seconds.
+	ChannelUpgradeWindow time.Duration
+
+	// Tracer, if set, traces Allocate, Refresh, CreatePermission and ChannelBind
+	// transactions with their username, realm and result code. See package tracing.
+	Tracer tracing.Tracer
+
+	// DSCP, between 0 and 63, marks the packets the client sends to the server, including
+	// those of connections passed to Rehome, e.g. 46 (Expedited Forwarding) for voice.
+	// Connections that can't be marked, e.g. on platforms other than Linux, are logged and
+	// left unmarked. Unmarked if 0.
+	DSCP int
+
+	// FailoverServerAddrs are TURN servers, in order of preference, the client fails over
+	// to when TURNServerAddr stops answering. The allocation created by Allocate is then
+	// created again on the next server that answers, and its permissions and channel
+	// bindings are restored there. The relayed net.PacketConn keeps working with a new
+	// LocalAddr, which must be signaled to the peers again, see OnFailover. After the last
+	// server the client wraps around to TURNServerAddr. TCP allocations don't fail over.
+	FailoverServerAddrs []string
+
+	// FailoverProbeInterval is the interval at which the client sends Binding requests to
+	// the current TURN server if FailoverServerAddrs is set, so an unresponsive server is
+	// noticed before the next Refresh. Any request that isn't answered after all
+	// retransmissions, see RTO, triggers the failover. Defaults to 15 seconds.
+	FailoverProbeInterval time.Duration
+
+	// OnFailover, if set, is called after the client failed over to another TURN server,
+	// or failed to.
+	OnFailover func(Failover)
+
+	// TURNServerURI is a turn: URI of RFC 7065, e.g. "turn:example.com", used instead of
+	// TURNServerAddr. It is resolved with ResolveServerURI and Resolver. The client relays
+	// over UDP, so the first server reached over plain UDP is used as TURN server, and the
+	// other ones are failed over to ahead of FailoverServerAddrs. If it is a turns: URI, has
+	// transport=tcp, or TCP, TLSConfig or DTLSHandshaker is set, the client connects to the
+	// first of its servers over TCP, TLS or DTLS instead.
+	TURNServerURI string
+
+	// TCP, if set and Conn is nil, connects the client to TURNServerAddr over TCP, port 3478
+	// if it has none, instead of listening on a UDP socket, after TLS and DTLS if TLSConfig
+	// or DTLSHandshaker is set too. STUN messages and ChannelData, padded to 4 bytes, are
+	// framed on the stream by their length, see STUNConn. The connection is closed with
+	// the client. FailoverServerAddrs, LocalAddr, Interface and DSCP aren't supported over
+	// TCP.
+	TCP bool
+
+	// TLSConfig, if set and Conn is nil, connects the client to TURNServerAddr over TLS,
+	// port 5349 if it has none, instead of listening on a UDP socket. STUN messages and
+	// ChannelData are framed on the stream, see STUNConn. ServerName defaults to the host
+	// of TURNServerAddr or TURNServerURI, which the certificate of the server is verified
+	// against. The connection is closed with the client. FailoverServerAddrs, LocalAddr,
+	// Interface and DSCP aren't supported over TLS.
+	TLSConfig *tls.Config
+
+	// DTLSHandshaker, if set, connects the client to TURNServerAddr over DTLS, port 5349
+	// if it has none, on Conn or the UDP socket the client listens on, after TLS if
+	// TLSConfig is set too. DTLS records from the server are demultiplexed from the rest
+	// of the traffic of the socket, e.g. to STUNServerAddr. The server name passed to it
+	// is the host of TURNServerAddr or TURNServerURI. FailoverServerAddrs aren't supported
+	// over DTLS.
+	DTLSHandshaker DTLSHandshaker
+
+	// OnTransportDown, if set, is called when Conn is a connected *net.UDPConn, e.g.
+	// returned by net.DialUDP, and an ICMP error such as port unreachable was received on
+	// it. The socket keeps being used, OnTransportUp is called once it receives from the
+	// server again. TURNServerAddr defaults to the remote address of connected sockets.
+	OnTransportDown func(err error)
+
+	// OnTransportUp, if set, is called when a connected Conn receives from the server again
+	// after OnTransportDown. The allocation is refreshed right away, the server may have
+	// lost it.
+	OnTransportUp func()
+
+	// HealthCheckInterval, if set, is the interval at which the client checks the health
+	// of its allocation by sending a Binding request to the TURN server, so a dead relay
+	// path is noticed even while the application sends no data or only to peers that don't
+	// answer. The result of every check is passed to OnAllocationHealth. Disabled if 0.
+	HealthCheckInterval time.Duration
+
+	// HealthCheckRefresh sends a Refresh, which also refreshes the permissions, instead of
+	// a Binding request, so the check also fails if the server lost the allocation, e.g.
+	// after a restart.
+	HealthCheckRefresh bool
+
+	// OnAllocationHealth, if set, is called with the result of every health check, see
+	// HealthCheckInterval.
+	OnAllocationHealth func(AllocationHealth)
 }
 
 // Client is a STUN server client
 type Client struct {
-	conn           net.PacketConn // Read-only
+	conn           net.PacketConn // Protected by mutex ***
+	ownsConn       bool           // Protected by mutex ***
 	net            transport.Net  // Read-only
 	stunServerAddr net.Addr       // Read-only
-	turnServerAddr net.Addr       // Read-only
+	turnServerAddr net.Addr       // Protected by mutex ***
 
-	username      stun.Username          // Read-only
-	password      string                 // Read-only
+	username      stun.Username          // Protected by mutex ***
+	password      string                 // Protected by mutex ***
 	realm         stun.Realm             // Read-only
-	integrity     stun.MessageIntegrity  // Read-only
+	integrity     stun.MessageIntegrity  // Protected by mutex ***
 	software      stun.Software          // Read-only
+	transactionID stun.Setter            // Read-only
 	trMap         *client.TransactionMap // Thread-safe
 	rto           time.Duration          // Read-only
 	relayedConn   *client.UDPConn        // Protected by mutex ***
@@ -70,6 +300,44 @@ type Client struct {
 	mutex         sync.RWMutex           // Thread-safe
 	mutexTrMap    sync.Mutex             // Thread-safe
 	log           logging.LeveledLogger  // Read-only
+
+	onUnpermittedData      func(from net.Addr, data []byte)  // Read-only
+	requestedAddressFamily RequestedAddressFamily            // Read-only
+	mobility               bool                              // Read-only
+	accessToken            proto.AccessToken                 // Read-only
+	affinityToken          proto.AffinityToken               // Protected by mutex ***
+	relayIdentity          proto.RelayIdentity               // Protected by mutex ***
+	listening              bool                              // Protected by mutex ***
+	tracer                 tracing.Tracer                    // Read-only
+	lifetime               time.Duration                     // Read-only
+	refreshInterval        func(time.Duration) time.Duration // Read-only
+	eagerChecks            bool                              // Read-only
+	noChannelBindings      bool                              // Read-only
+	channelUpgrade         client.ChannelUpgrade             // Read-only
+
+	credentialProvider        func() (string, string, error) // Read-only
+	credentialExpiry          func(string) (time.Time, bool) // Read-only
+	credentialRenewalMargin   time.Duration                  // Read-only
+	onCredentialRenewalFailed func(error)                    // Read-only
+	resolveAddr               client.AddrResolver            // Read-only
+	nat64Prefix               *net.IPNet                     // Read-only
+	dscp                      int                            // Read-only
+	credentialTimer           *time.Timer                    // Protected by mutex ***
+	closed                    bool                           // Protected by mutex ***
+
+	turnServerAddrs       []net.Addr     // Read-only
+	failoverProbeInterval time.Duration  // Read-only
+	onFailover            func(Failover) // Read-only
+	failoverTimer         *time.Timer    // Protected by mutex ***
+
+	onTransportDownHandler func(err error) // Read-only
+	onTransportUpHandler   func()          // Read-only
+
+	healthCheckInterval time.Duration          // Read-only
+	healthCheckRefresh  bool                   // Read-only
+	onAllocationHealth  func(AllocationHealth) // Read-only
+	healthTimer         *time.Timer            // Protected by mutex ***
+	healthFailures      int                    // Protected by mutex ***
 }
 
 // NewClient returns a new Client instance. listeningAddress is the address and port to listen on, default "0.0.0.0:0"
@@ -81,7 +349,15 @@ func NewClient(config *ClientConfig) (*Client, error) {
 
 	log := loggerFactory.NewLogger("turnc")
 
-	if config.Conn == nil {
+	transportCandidates, err := clientTransportCandidates(config)
+	if err != nil {
+		return nil, err
+	}
+	if transportCandidates != nil && len(config.FailoverServerAddrs) > 0 {
+		return nil, errFailoverUnsupported
+	}
+
+	if config.Conn == nil && config.LocalAddr == "" && config.Interface == "" && transportCandidates == nil {
 		return nil, errNilConn
 	}
 
@@ -98,46 +374,170 @@ func NewClient(config *ClientConfig) (*Client, error) {
 		config.Net = n
 	}
 
+	if config.NAT64Prefix != nil && !ipnet.IsNAT64Prefix(config.NAT64Prefix) {
+		return nil, fmt.Errorf("%w: %s", errNAT64PrefixInvalid, config.NAT64Prefix)
+	}
+
+	if config.DSCP < 0 || config.DSCP > ipnet.MaxDSCP {
+		return nil, fmt.Errorf("%w: %d", errDSCPInvalid, config.DSCP)
+	}
+
+	turnServerAddr, failoverServerAddrs := config.TURNServerAddr, config.FailoverServerAddrs
+	if config.TURNServerURI != "" && transportCandidates == nil {
+		addrs, err := udpServerAddrs(config.Resolver, config.TURNServerURI)
+		if err != nil {
+			return nil, err
+		}
+		turnServerAddr = addrs[0]
+		failoverServerAddrs = append(addrs[1:], failoverServerAddrs...)
+
+		log.Debugf("Resolved TURN server URI %s to %v", config.TURNServerURI, addrs)
+	}
+
+	conn, ownsConn := config.Conn, false
+	switch {
+	case transportCandidates != nil:
+		var serverAddr net.Addr
+		if conn, serverAddr, err = dialClientTransport(config, transportCandidates); err != nil {
+			return nil, err
+		}
+		ownsConn = true
+		turnServerAddr = serverAddr.String()
+
+		log.Debugf("Connected to TURN server %s", serverAddr)
+	case conn == nil:
+		if conn, err = listenClientConn(config); err != nil {
+			return nil, err
+		}
+		ownsConn = true
+
+		log.Debugf("Listening on %s", conn.LocalAddr())
+	}
+	setClientDSCP(conn, config.DSCP, log)
+
+	resolveAddr := config.ResolveAddr
+	if resolveAddr == nil && config.Resolver != nil {
+		resolveAddr = resolverAddrResolver(config.Resolver)
+	}
+	if resolveAddr == nil {
+		resolveAddr = config.Net.ResolveUDPAddr
+	}
+
+	serverResolver := newServerResolver(config, conn, resolveAddr)
 	var stunServ, turnServ net.Addr
-	var err error
 
 	if len(config.STUNServerAddr) > 0 {
-		stunServ, err = config.Net.ResolveUDPAddr("udp4", config.STUNServerAddr)
+		stunServ, err = serverResolver.resolve("stun", config.STUNServerAddr)
 		if err != nil {
+			closeOwnedConn(conn, ownsConn)
 			return nil, err
 		}
 
 		log.Debugf("Resolved STUN server %s to %s", config.STUNServerAddr, stunServ)
 	}
 
-	if len(config.TURNServerAddr) > 0 {
-		turnServ, err = config.Net.ResolveUDPAddr("udp4", config.TURNServerAddr)
+	if len(turnServerAddr) > 0 {
+		turnServ, err = serverResolver.resolve("turn", turnServerAddr)
 		if err != nil {
+			closeOwnedConn(conn, ownsConn)
 			return nil, err
 		}
 
-		log.Debugf("Resolved TURN server %s to %s", config.TURNServerAddr, turnServ)
+		log.Debugf("Resolved TURN server %s to %s", turnServerAddr, turnServ)
+	}
+
+	if udpConn, ok := conn.(*net.UDPConn); ok && turnServ == nil && udpConn.RemoteAddr() != nil {
+		turnServ = udpConn.RemoteAddr()
+	}
+
+	turnServs := []net.Addr{}
+	if turnServ != nil {
+		turnServs = append(turnServs, turnServ)
+	}
+	for _, addr := range failoverServerAddrs {
+		serv, resolveErr := serverResolver.resolve("turn", addr)
+		if resolveErr != nil {
+			closeOwnedConn(conn, ownsConn)
+			return nil, resolveErr
+		}
+		turnServs = append(turnServs, serv)
+
+		log.Debugf("Resolved failover TURN server %s to %s", addr, serv)
 	}
 
 	c := &Client{
-		conn:           config.Conn,
+		conn:           conn,
+		ownsConn:       ownsConn,
 		stunServerAddr: stunServ,
 		turnServerAddr: turnServ,
 		username:       stun.NewUsername(config.Username),
 		password:       config.Password,
 		realm:          stun.NewRealm(config.Realm),
 		software:       stun.NewSoftware(config.Software),
+		transactionID:  client.NewTransactionIDSetter(config.TransactionIDGenerator, !config.InsecureTransactionIDs),
 		trMap:          client.NewTransactionMap(),
 		net:            config.Net,
 		rto:            rto,
 		log:            log,
+
+		onUnpermittedData:      config.OnUnpermittedData,
+		requestedAddressFamily: config.RequestedAddressFamily,
+		mobility:               config.Mobility,
+		affinityToken:          append(proto.AffinityToken{}, config.AffinityToken...),
+		tracer:                 config.Tracer,
+		lifetime:               config.Lifetime,
+		refreshInterval:        config.RefreshInterval,
+		eagerChecks:            config.EagerConnectivityChecks,
+		noChannelBindings:      config.DisableChannelBindings,
+		dscp:                   config.DSCP,
+		channelUpgrade: client.ChannelUpgrade{
+			Packets: config.ChannelUpgradePackets,
+			Bytes:   config.ChannelUpgradeBytes,
+			Window:  config.ChannelUpgradeWindow,
+		},
+	}
+
+	c.credentialProvider = config.CredentialProvider
+	c.credentialExpiry = config.CredentialExpiry
+	if c.credentialExpiry == nil {
+		c.credentialExpiry = server.TimeWindowedCredentialExpiry
+	}
+	c.credentialRenewalMargin = config.CredentialRenewalMargin
+	if c.credentialRenewalMargin == 0 {
+		c.credentialRenewalMargin = defaultCredentialRenewalMargin
+	}
+	c.onCredentialRenewalFailed = config.OnCredentialRenewalFailed
+	c.resolveAddr = resolveAddr
+	c.nat64Prefix = serverResolver.nat64Prefix
+	c.turnServerAddrs = turnServs
+	c.failoverProbeInterval = config.FailoverProbeInterval
+	if c.failoverProbeInterval == 0 {
+		c.failoverProbeInterval = defaultFailoverProbeInterval
+	}
+	c.onFailover = config.OnFailover
+	c.onTransportDownHandler = config.OnTransportDown
+	c.onTransportUpHandler = config.OnTransportUp
+	c.healthCheckInterval = config.HealthCheckInterval
+	c.healthCheckRefresh = config.HealthCheckRefresh
+	c.onAllocationHealth = config.OnAllocationHealth
+	c.conn = c.connectedConn(conn)
+
+	if config.OAuth != nil {
+		c.credentialProvider = nil
+		c.username = stun.NewUsername(config.OAuth.KeyID)
+		c.integrity = stun.MessageIntegrity(config.OAuth.MACKey)
+		c.accessToken = proto.AccessToken(config.OAuth.AccessToken)
 	}
 
 	return c, nil
 }
 
-// TURNServerAddr return the TURN server address
+// TURNServerAddr return the TURN server address, the one the client failed over to if
+// FailoverServerAddrs is set
 func (c *Client) TURNServerAddr() net.Addr {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	return c.turnServerAddr
 }
 
@@ -148,6 +548,9 @@ func (c *Client) STUNServerAddr() net.Addr {
 
 // Username returns username
 func (c *Client) Username() stun.Username {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
 	return c.username
 }
 
@@ -156,9 +559,43 @@ func (c *Client) Realm() stun.Realm {
 	return c.realm
 }
 
+// AffinityToken returns the AFFINITY-TOKEN of the server, nil if it didn't send one. Pass
+// it as ClientConfig.AffinityToken to get routed back to the same server
+func (c *Client) AffinityToken() []byte {
+	return append([]byte(nil), c.getAffinityToken()...)
+}
+
+// RelayIdentity returns the RELAY-IDENTITY the server sent with the last allocation,
+// identifying the region, point of presence and node of the relay. It is zero if the
+// server didn't send one
+func (c *Client) RelayIdentity() RelayIdentity {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.relayIdentity
+}
+
+// RequestedLifetime returns the lifetime requested for allocations, 0 if the client
+// requests the default lifetime of the server
+func (c *Client) RequestedLifetime() time.Duration {
+	return c.lifetime
+}
+
+// GrantedLifetime returns the lifetime the server granted the allocation of the client
+// with the last Allocate or Refresh response, 0 if the client has no allocation
+func (c *Client) GrantedLifetime() time.Duration {
+	if relayedConn := c.relayedUDPConn(); relayedConn != nil {
+		return relayedConn.GrantedLifetime()
+	}
+	if allocation := c.getTCPAllocation(); allocation != nil {
+		return allocation.GrantedLifetime()
+	}
+	return 0
+}
+
 // WriteTo sends data to the specified destination using the base socket.
 func (c *Client) WriteTo(data []byte, to net.Addr) (int, error) {
-	return c.conn.WriteTo(data, to)
+	return c.packetConn().WriteTo(data, to)
 }
 
 // Listen will have this client start listening on the conn provided via the config.
@@ -169,41 +606,135 @@ func (c *Client) Listen() error {
 		return fmt.Errorf("%w: %s", errAlreadyListening, err.Error())
 	}
 
-	go func() {
-		buf := make([]byte, maxDataBufferSize)
-		for {
-			n, from, err := c.conn.ReadFrom(buf)
-			if err != nil {
-				c.log.Debugf("Failed to read: %s. Exiting loop", err)
-				break
-			}
+	c.mutex.Lock()
+	c.listening = true
+	conn := c.conn
+	c.mutex.Unlock()
 
-			_, err = c.HandleInbound(buf[:n], from)
-			if err != nil {
-				c.log.Debugf("Failed to handle inbound message: %s. Exiting loop", err)
-				break
-			}
+	go c.readLoop(conn)
+
+	return nil
+}
+
+// readLoop handles the data read from conn until reading fails or the client is
+// rehomed to another conn
+func (c *Client) readLoop(conn net.PacketConn) {
+	buf := make([]byte, maxDataBufferSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			c.log.Debugf("Failed to read: %s. Exiting loop", err)
+			break
+		}
+
+		_, err = c.HandleInbound(buf[:n], from)
+		if err != nil {
+			c.log.Debugf("Failed to handle inbound message: %s. Exiting loop", err)
+			break
+		}
+
+		if c.packetConn() != conn {
+			c.log.Debug("Rehomed to another conn. Exiting loop")
+			return
 		}
+	}
 
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == conn {
+		c.listening = false
 		c.listenTryLock.Unlock()
-	}()
+	}
+}
 
-	return nil
+// Rehome switches the client to conn after the local address changed, e.g. when moving
+// from Wi-Fi to LTE. The allocation, which must have been created with Mobility, is
+// refreshed from conn right away so the server moves it along with its permissions and
+// channel bindings. The relayed net.PacketConn keeps working. If the client was listening
+// it listens on conn afterwards. The previous conn is not closed, close it once Rehome
+// returns to stop reading from it. A conn the client created from LocalAddr or Interface is
+// closed by Rehome.
+func (c *Client) Rehome(conn net.PacketConn) error {
+	if conn == nil {
+		return errNilConn
+	}
+
+	relayedConn := c.relayedUDPConn()
+	if relayedConn == nil {
+		return errNoAllocation
+	}
+
+	setClientDSCP(conn, c.dscp, c.log)
+	conn = c.connectedConn(conn)
+
+	c.mutex.Lock()
+	previous, ownsPrevious := c.conn, c.ownsConn
+	c.conn, c.ownsConn = conn, false
+	listening := c.listening
+	c.mutex.Unlock()
+
+	if listening {
+		go c.readLoop(conn)
+	}
+
+	err := relayedConn.Rehome()
+	closeOwnedConn(previous, ownsPrevious)
+
+	return err
 }
 
 // Close closes this client
 func (c *Client) Close() {
-	c.mutexTrMap.Lock()
-	defer c.mutexTrMap.Unlock()
+	c.mutex.Lock()
+	c.closed = true
+	if c.credentialTimer != nil {
+		c.credentialTimer.Stop()
+	}
+	if c.failoverTimer != nil {
+		c.failoverTimer.Stop()
+	}
+	if c.healthTimer != nil {
+		c.healthTimer.Stop()
+	}
+	c.mutex.Unlock()
 
+	c.mutexTrMap.Lock()
 	c.trMap.CloseAndDeleteAll()
+	c.mutexTrMap.Unlock()
+
+	c.mutex.RLock()
+	conn, ownsConn := c.conn, c.ownsConn
+	c.mutex.RUnlock()
+
+	if ownsConn {
+		if err := conn.Close(); err != nil {
+			c.log.Warnf("Failed to close conn: %s", err)
+		}
+	}
+}
+
+// setClientDSCP marks the packets sent on conn with dscp, see ClientConfig.DSCP
+func setClientDSCP(conn net.PacketConn, dscp int, log logging.LeveledLogger) {
+	if dscp == 0 {
+		return
+	}
+
+	if err := ipnet.SetDSCP(conn, dscp); err != nil {
+		log.Warnf("Failed to set DSCP %d on %s: %v", dscp, conn.LocalAddr(), err)
+	}
+}
+
+func closeOwnedConn(conn net.PacketConn, ownsConn bool) {
+	if ownsConn {
+		_ = conn.Close()
+	}
 }
 
 // TransactionID & Base64: https://play.golang.org/p/EEgmJDI971P
 
 // SendBindingRequestTo sends a new STUN request to the given transport address
 func (c *Client) SendBindingRequestTo(to net.Addr) (net.Addr, error) {
-	attrs := []stun.Setter{stun.TransactionID, stun.BindingRequest}
+	attrs := []stun.Setter{c.transactionID, stun.BindingRequest}
 	if len(c.software) > 0 {
 		attrs = append(attrs, c.software)
 	}
@@ -217,8 +748,18 @@ func (c *Client) SendBindingRequestTo(to net.Addr) (net.Addr, error) {
 		return nil, err
 	}
 
+	res := trRes.Msg
+	if isUnauthorized(res) {
+		if res, err = c.answerBindingChallenge(attrs, res, to); err != nil {
+			return nil, err
+		}
+	}
+	if res.Type.Class == stun.ClassErrorResponse {
+		return nil, proto.NewResponseError(res)
+	}
+
 	var reflAddr stun.XORMappedAddress
-	if err := reflAddr.GetFrom(trRes.Msg); err != nil {
+	if err := reflAddr.GetFrom(res); err != nil {
 		return nil, err
 	}
 
@@ -228,6 +769,44 @@ func (c *Client) SendBindingRequestTo(to net.Addr) (net.Addr, error) {
 	}, nil
 }
 
+// answerBindingChallenge sends the Binding request again, authenticated with the realm and
+// nonce of challenge, to servers that require authenticated Binding requests
+func (c *Client) answerBindingChallenge(setters []stun.Setter, challenge *stun.Message, to net.Addr) (*stun.Message, error) {
+	var nonce stun.Nonce
+	var realm stun.Realm
+	if err := nonce.GetFrom(challenge); err != nil {
+		return nil, err
+	}
+	if err := realm.GetFrom(challenge); err != nil {
+		return nil, err
+	}
+
+	c.mutex.RLock()
+	username, integrity := c.username, c.integrity
+	if len(c.accessToken) == 0 {
+		integrity = stun.NewLongTermIntegrity(username.String(), realm.String(), c.password)
+	}
+	c.mutex.RUnlock()
+
+	msg, err := stun.Build(append(setters,
+		username,
+		c.accessToken,
+		realm,
+		nonce,
+		integrity,
+		stun.Fingerprint,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	trRes, err := c.PerformTransaction(msg, to, false)
+	if err != nil {
+		return nil, err
+	}
+	return trRes.Msg, nil
+}
+
 // SendBindingRequest sends a new STUN request to the STUN server
 func (c *Client) SendBindingRequest() (net.Addr, error) {
 	if c.stunServerAddr == nil {
@@ -236,78 +815,136 @@ func (c *Client) SendBindingRequest() (net.Addr, error) {
 	return c.SendBindingRequestTo(c.stunServerAddr)
 }
 
-func (c *Client) sendAllocateRequest(protocol proto.Protocol) (proto.RelayedAddress, proto.Lifetime, stun.Nonce, error) {
+// isUnauthorized returns true if res is a 401 (Unauthorized) error
+func isUnauthorized(res *stun.Message) bool {
+	var code stun.ErrorCodeAttribute
+	return res.Type.Class == stun.ClassErrorResponse &&
+		code.GetFrom(res) == nil && code.Code == stun.CodeUnauthorized
+}
+
+// isStaleNonce returns true if res is a 438 (Stale Nonce) error
+func isStaleNonce(res *stun.Message) bool {
+	var code stun.ErrorCodeAttribute
+	return res.Type.Class == stun.ClassErrorResponse &&
+		code.GetFrom(res) == nil && code.Code == stun.CodeStaleNonce
+}
+
+// isRealmChallenge returns true if res is a 401 (Unauthorized) error with another realm
+// than the one the request was authenticated with
+func (c *Client) isRealmChallenge(res *stun.Message) bool {
+	var realm stun.Realm
+	return isUnauthorized(res) && realm.GetFrom(res) == nil && realm.String() != c.realm.String()
+}
+
+func (c *Client) sendAllocateRequest(server net.Addr, protocol proto.Protocol) (proto.RelayedAddress, proto.Lifetime, stun.Nonce, proto.MobilityTicket, error) {
 	var relayed proto.RelayedAddress
 	var lifetime proto.Lifetime
 	var nonce stun.Nonce
+	var ticket proto.MobilityTicket
 
-	msg, err := stun.Build(
-		stun.TransactionID,
+	setters := []stun.Setter{
+		c.transactionID,
 		stun.NewType(stun.MethodAllocate, stun.ClassRequest),
 		proto.RequestedTransport{Protocol: protocol},
-		stun.Fingerprint,
-	)
-	if err != nil {
-		return relayed, lifetime, nonce, err
 	}
-
-	trRes, err := c.PerformTransaction(msg, c.turnServerAddr, false)
-	if err != nil {
-		return relayed, lifetime, nonce, err
+	if c.requestedAddressFamily != 0 {
+		setters = append(setters, c.requestedAddressFamily)
 	}
-
-	res := trRes.Msg
-
-	// Anonymous allocate failed, trying to authenticate.
-	if err = nonce.GetFrom(res); err != nil {
-		return relayed, lifetime, nonce, err
+	if c.lifetime != 0 {
+		setters = append(setters, proto.Lifetime{Duration: c.lifetime})
 	}
-	if err = c.realm.GetFrom(res); err != nil {
-		return relayed, lifetime, nonce, err
+	if c.mobility {
+		setters = append(setters, proto.MobilityTicket{})
 	}
-	c.realm = append([]byte(nil), c.realm...)
-	c.integrity = stun.NewLongTermIntegrity(
-		c.username.String(), c.realm.String(), c.password,
-	)
-	// Trying to authorize.
-	msg, err = stun.Build(
-		stun.TransactionID,
-		stun.NewType(stun.MethodAllocate, stun.ClassRequest),
-		proto.RequestedTransport{Protocol: protocol},
-		&c.username,
-		&c.realm,
-		&nonce,
-		&c.integrity,
-		stun.Fingerprint,
-	)
+
+	msg, err := stun.Build(append(setters, c.getAffinityToken(), stun.Fingerprint)...)
 	if err != nil {
-		return relayed, lifetime, nonce, err
+		return relayed, lifetime, nonce, ticket, err
 	}
 
-	trRes, err = c.PerformTransaction(msg, c.turnServerAddr, false)
+	trRes, err := c.PerformTransaction(msg, server, false)
 	if err != nil {
-		return relayed, lifetime, nonce, err
+		return relayed, lifetime, nonce, ticket, err
 	}
-	res = trRes.Msg
 
-	if res.Type.Class == stun.ClassErrorResponse {
-		var code stun.ErrorCodeAttribute
-		if err = code.GetFrom(res); err == nil {
-			return relayed, lifetime, nonce, fmt.Errorf("%s (error %s)", res.Type, code) //nolint:goerr113
+	res := trRes.Msg
+
+	// Anonymous allocate failed, trying to authenticate. Servers with a realm per
+	// username challenge again with the realm of the username, and servers that rotated
+	// their nonce in the meantime answer with a 438 (Stale Nonce) error, which is tried
+	// once with the new nonce
+	for attempt := 0; ; attempt++ {
+		if err = nonce.GetFrom(res); err != nil {
+			return relayed, lifetime, nonce, ticket, err
+		}
+		if err = c.realm.GetFrom(res); err != nil {
+			return relayed, lifetime, nonce, ticket, err
 		}
-		return relayed, lifetime, nonce, fmt.Errorf("%s", res.Type) //nolint:goerr113
+		c.realm = append([]byte(nil), c.realm...)
+		c.updateAffinityToken(res)
+		c.mutex.Lock()
+		if len(c.accessToken) == 0 {
+			c.integrity = stun.NewLongTermIntegrity(
+				c.username.String(), c.realm.String(), c.password,
+			)
+		}
+		username, integrity := c.username, c.integrity
+		c.mutex.Unlock()
+		// Trying to authorize.
+		msg, err = stun.Build(append(setters,
+			username,
+			c.accessToken,
+			c.getAffinityToken(),
+			&c.realm,
+			&nonce,
+			integrity,
+			stun.Fingerprint,
+		)...)
+		if err != nil {
+			return relayed, lifetime, nonce, ticket, err
+		}
+
+		trRes, err = c.PerformTransaction(msg, server, false)
+		if err != nil {
+			return relayed, lifetime, nonce, ticket, err
+		}
+		res = trRes.Msg
+
+		if attempt == 0 && (c.isRealmChallenge(res) || isStaleNonce(res)) {
+			continue
+		}
+		if res.Type.Class == stun.ClassErrorResponse {
+			return relayed, lifetime, nonce, ticket, proto.NewResponseError(res)
+		}
+		break
 	}
+	c.updateAffinityToken(res)
 
 	// Getting relayed addresses from response.
 	if err := relayed.GetFrom(res); err != nil {
-		return relayed, lifetime, nonce, err
+		return relayed, lifetime, nonce, ticket, err
 	}
 
 	// Getting lifetime from response
 	if err := lifetime.GetFrom(res); err != nil {
-		return relayed, lifetime, nonce, err
+		return relayed, lifetime, nonce, ticket, err
+	}
+
+	if c.mobility {
+		if err := ticket.GetFrom(res); err != nil {
+			return relayed, lifetime, nonce, ticket, err
+		}
+		ticket = append(proto.MobilityTicket{}, ticket...)
 	}
-	return relayed, lifetime, nonce, nil
+
+	// RELAY-IDENTITY is informational, a malformed one is ignored
+	var identity proto.RelayIdentity
+	_ = identity.GetFrom(res)
+	c.mutex.Lock()
+	c.relayIdentity = identity
+	c.mutex.Unlock()
+
+	return relayed, lifetime, nonce, ticket, nil
 }
 
 // Allocate sends a TURN allocation request to the given transport address
@@ -322,29 +959,48 @@ func (c *Client) Allocate() (net.PacketConn, error) {
 		return nil, fmt.Errorf("%w: %s", errAlreadyAllocated, relayedConn.LocalAddr().String())
 	}
 
-	relayed, lifetime, nonce, err := c.sendAllocateRequest(proto.ProtoUDP)
+	server := c.TURNServerAddr()
+	relayed, lifetime, nonce, ticket, err := c.sendAllocateRequest(server, proto.ProtoUDP)
 	if err != nil {
 		return nil, err
 	}
 
+	username, integrity := c.credentials()
 	relayedAddr := &net.UDPAddr{
 		IP:   relayed.IP,
 		Port: relayed.Port,
 	}
 
 	relayedConn = client.NewUDPConn(&client.AllocationConfig{
-		Client:      c,
-		RelayedAddr: relayedAddr,
-		ServerAddr:  c.turnServerAddr,
-		Realm:       c.realm,
-		Username:    c.username,
-		Integrity:   c.integrity,
-		Nonce:       nonce,
-		Lifetime:    lifetime.Duration,
-		Net:         c.net,
-		Log:         c.log,
+		Client:        c,
+		RelayedAddr:   relayedAddr,
+		ServerAddr:    server,
+		Realm:         c.realm,
+		Username:      username,
+		Integrity:     integrity,
+		Nonce:         nonce,
+		Lifetime:      lifetime.Duration,
+		Net:           c.net,
+		Log:           c.log,
+		TransactionID: c.transactionID,
+
+		RequestedLifetime: c.lifetime,
+		RefreshInterval:   c.refreshInterval,
+
+		MobilityTicket: ticket,
+		AccessToken:    c.accessToken,
+		AffinityToken:  c.getAffinityToken(),
+		ResolveAddr:    c.resolveAddr,
+		NAT64Prefix:    c.nat64Prefix,
+
+		EagerConnectivityChecks: c.eagerChecks,
+		DisableChannelBindings:  c.noChannelBindings,
+		ChannelUpgrade:          c.channelUpgrade,
 	})
 	c.setRelayedUDPConn(relayedConn)
+	c.scheduleCredentialRenewal()
+	c.scheduleFailoverProbe()
+	c.scheduleHealthCheck()
 
 	return relayedConn, nil
 }
@@ -361,34 +1017,78 @@ func (c *Client) AllocateTCP() (*client.TCPAllocation, error) {
 		return nil, fmt.Errorf("%w: %s", errAlreadyAllocated, allocation.Addr())
 	}
 
-	relayed, lifetime, nonce, err := c.sendAllocateRequest(proto.ProtoTCP)
+	server := c.TURNServerAddr()
+	relayed, lifetime, nonce, ticket, err := c.sendAllocateRequest(server, proto.ProtoTCP)
 	if err != nil {
 		return nil, err
 	}
 
+	username, integrity := c.credentials()
 	relayedAddr := &net.TCPAddr{
 		IP:   relayed.IP,
 		Port: relayed.Port,
 	}
 
 	allocation = client.NewTCPAllocation(&client.AllocationConfig{
-		Client:      c,
-		RelayedAddr: relayedAddr,
-		ServerAddr:  c.turnServerAddr,
-		Realm:       c.realm,
-		Username:    c.username,
-		Integrity:   c.integrity,
-		Nonce:       nonce,
-		Lifetime:    lifetime.Duration,
-		Net:         c.net,
-		Log:         c.log,
+		Client:        c,
+		RelayedAddr:   relayedAddr,
+		ServerAddr:    server,
+		Realm:         c.realm,
+		Username:      username,
+		Integrity:     integrity,
+		Nonce:         nonce,
+		Lifetime:      lifetime.Duration,
+		Net:           c.net,
+		Log:           c.log,
+		TransactionID: c.transactionID,
+
+		RequestedLifetime: c.lifetime,
+		RefreshInterval:   c.refreshInterval,
+
+		MobilityTicket: ticket,
+		AccessToken:    c.accessToken,
+		AffinityToken:  c.getAffinityToken(),
+		ResolveAddr:    c.resolveAddr,
+		NAT64Prefix:    c.nat64Prefix,
 	})
 
 	c.setTCPAllocation(allocation)
+	c.scheduleCredentialRenewal()
+	c.scheduleHealthCheck()
 
 	return allocation, nil
 }
 
+// DialTCP opens a TCP connection to peerAddr relayed by the TURN server as described in
+// https://datatracker.ietf.org/doc/html/rfc6062#section-4.3. It sends a Connect request on the
+// control connection and binds the resulting connection ID to a new TCP connection to the server
+// with ConnectionBind. A TCP allocation is created with AllocateTCP if the client has none yet.
+func (c *Client) DialTCP(peerAddr net.Addr) (net.Conn, error) {
+	allocation := c.getTCPAllocation()
+	if allocation == nil {
+		var err error
+		if allocation, err = c.AllocateTCP(); err != nil {
+			return nil, err
+		}
+	}
+
+	peerIP, peerPort, err := ipnet.AddrIPPort(peerAddr)
+	if err != nil {
+		udpAddr, resolveErr := c.resolveAddr("udp", peerAddr.String())
+		if resolveErr != nil {
+			return nil, err
+		}
+		peerIP, peerPort = udpAddr.IP, udpAddr.Port
+	}
+
+	conn, err := allocation.DialTCP("tcp", nil, &net.TCPAddr{IP: peerIP, Port: peerPort})
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
+
 // CreatePermission Issues a CreatePermission request for the supplied addresses
 // as described in https://datatracker.ietf.org/doc/html/rfc5766#section-9
 func (c *Client) CreatePermission(addrs ...net.Addr) error {
@@ -406,10 +1106,55 @@ func (c *Client) CreatePermission(addrs ...net.Addr) error {
 	return nil
 }
 
+// PreauthorizePeers creates the permissions of peers in a single CreatePermission
+// transaction as soon as they are known, e.g. the remote candidates of ICE, so the first
+// packets exchanged with them don't wait for a permission. If bindChannels is set,
+// channels are bound to the peers too. Only supported for UDP allocations
+func (c *Client) PreauthorizePeers(peers []net.Addr, bindChannels bool) error {
+	relayedConn := c.relayedUDPConn()
+	if relayedConn == nil {
+		return errNoUDPAllocation
+	}
+
+	return relayedConn.PreauthorizePeers(peers, bindChannels)
+}
+
+// SetChannelKeepalive sends empty ChannelData messages to peer whenever the application
+// didn't send it anything for interval, keeping the NAT mappings in front of the peer
+// open while the application is silent. peer must have a channel binding, which is
+// created when the relayed conn first writes to it. Keepalives are disabled by default,
+// an interval of 0 disables them again
+func (c *Client) SetChannelKeepalive(peer net.Addr, interval time.Duration) error {
+	relayedConn := c.relayedUDPConn()
+	if relayedConn == nil {
+		return errNoUDPAllocation
+	}
+
+	return relayedConn.SetChannelKeepalive(peer, interval)
+}
+
 // PerformTransaction performs STUN transaction
 func (c *Client) PerformTransaction(msg *stun.Message, to net.Addr, ignoreResult bool) (client.TransactionResult,
 	error,
 ) {
+	if c.tracer != nil && tracing.Traced(msg.Type.Method) {
+		_, span := c.tracer.Start(context.Background(), tracing.SpanName("client", msg.Type.Method))
+		defer span.End()
+
+		tracing.SetRequestAttributes(span, msg)
+		span.SetAttribute(tracing.AttributePeerAddr, to.String())
+
+		res, err := c.performTransaction(msg, to, ignoreResult)
+		if res.Msg != nil {
+			tracing.SetResultAttributes(span, res.Msg)
+		}
+		return res, err
+	}
+
+	return c.performTransaction(msg, to, ignoreResult)
+}
+
+func (c *Client) performTransaction(msg *stun.Message, to net.Addr, ignoreResult bool) (client.TransactionResult, error) {
 	trKey := b64.StdEncoding.EncodeToString(msg.TransactionID[:])
 
 	raw := make([]byte, len(msg.Raw))
@@ -423,10 +1168,16 @@ func (c *Client) PerformTransaction(msg *stun.Message, to net.Addr, ignoreResult
 		IgnoreResult: ignoreResult,
 	})
 
+	c.mutexTrMap.Lock()
+	if _, ok := c.trMap.Find(trKey); ok {
+		c.mutexTrMap.Unlock()
+		return client.TransactionResult{}, fmt.Errorf("%w: %s", errDuplicateTransactionID, trKey)
+	}
 	c.trMap.Insert(trKey, tr)
+	c.mutexTrMap.Unlock()
 
 	c.log.Tracef("Start %s transaction %s to %s", msg.Type, trKey, tr.To.String())
-	_, err := c.conn.WriteTo(tr.Raw, to)
+	_, err := c.packetConn().WriteTo(tr.Raw, to)
 	if err != nil {
 		return client.TransactionResult{}, err
 	}
@@ -531,7 +1282,13 @@ func (c *Client) handleSTUNMessage(data []byte, from net.Addr) error {
 				c.log.Debug("No relayed conn allocated")
 				return nil // Silently discard
 			}
-			relayedConn.HandleInbound(data, from)
+
+			if c.onUnpermittedData != nil && !relayedConn.HasPermission(from) {
+				c.log.Warnf("Data indication received from %s without a permission", from.String())
+				c.onUnpermittedData(from, data)
+				return nil
+			}
+			relayedConn.HandleDataIndication(data, from)
 		case stun.MethodConnectionAttempt:
 			var peerAddr proto.PeerAddress
 			if err := peerAddr.GetFrom(msg); err != nil {
@@ -610,14 +1367,12 @@ func (c *Client) handleChannelData(data []byte) error {
 		return nil // Silently discard
 	}
 
-	addr, ok := relayedConn.FindAddrByChannelNumber(uint16(chData.Number))
+	addr, ok := relayedConn.HandleChannelData(uint16(chData.Number), chData.Data)
 	if !ok {
 		return fmt.Errorf("%w: %d", errChannelBindNotFound, int(chData.Number))
 	}
 
 	c.log.Tracef("Channel data received from %s (ch=%d)", addr.String(), int(chData.Number))
-
-	relayedConn.HandleInbound(chData.Data, addr)
 	return nil
 }
 
@@ -633,6 +1388,7 @@ func (c *Client) onRtxTimeout(trKey string, nRtx int) {
 	if nRtx == maxRtxCount {
 		// All retransmissions failed
 		c.trMap.Delete(trKey)
+		c.onServerUnresponsive(tr.To)
 		if !tr.WriteResult(client.TransactionResult{
 			Err: fmt.Errorf("%w %s", errAllRetransmissionsFailed, trKey),
 		}) {
@@ -643,7 +1399,7 @@ func (c *Client) onRtxTimeout(trKey string, nRtx int) {
 
 	c.log.Tracef("Retransmitting transaction %s to %s (nRtx=%d)",
 		trKey, tr.To.String(), nRtx)
-	_, err := c.conn.WriteTo(tr.Raw, tr.To)
+	_, err := c.packetConn().WriteTo(tr.Raw, tr.To)
 	if err != nil {
 		c.trMap.Delete(trKey)
 		if !tr.WriteResult(client.TransactionResult{
@@ -656,6 +1412,13 @@ func (c *Client) onRtxTimeout(trKey string, nRtx int) {
 	tr.StartRtxTimer(c.onRtxTimeout)
 }
 
+func (c *Client) packetConn() net.PacketConn {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.conn
+}
+
 func (c *Client) setRelayedUDPConn(conn *client.UDPConn) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -683,3 +1446,23 @@ func (c *Client) getTCPAllocation() *client.TCPAllocation {
 
 	return c.tcpAllocation
 }
+
+func (c *Client) getAffinityToken() proto.AffinityToken {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.affinityToken
+}
+
+// updateAffinityToken stores the AFFINITY-TOKEN of res, if it has one
+func (c *Client) updateAffinityToken(res *stun.Message) {
+	var token proto.AffinityToken
+	if token.GetFrom(res) != nil || len(token) == 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.affinityToken = append(proto.AffinityToken{}, token...)
+}
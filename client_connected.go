@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+)
+
+// connectedUDPConn adapts a connected *net.UDPConn, e.g. returned by net.DialUDP, to the
+// client. Connected sockets fail WriteTo, so datagrams to the remote address are written
+// with Write. They also report the ICMP errors of earlier datagrams, e.g. a port
+// unreachable while the server restarts, on the next read or write. These are reported
+// as transport down and skipped, the socket keeps working once the server is reachable
+// again
+type connectedUDPConn struct {
+	*net.UDPConn
+	remoteAddr net.Addr
+	down       int32 // Accessed atomically
+
+	onDown func(err error)
+	onUp   func()
+}
+
+// connectedConn returns conn adapted with connectedUDPConn if it is a connected UDP socket
+func (c *Client) connectedConn(conn net.PacketConn) net.PacketConn {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok || udpConn.RemoteAddr() == nil {
+		return conn
+	}
+
+	c.log.Debugf("Using connected socket to %s", udpConn.RemoteAddr())
+
+	return &connectedUDPConn{
+		UDPConn:    udpConn,
+		remoteAddr: udpConn.RemoteAddr(),
+		onDown:     c.onTransportDown,
+		onUp:       c.onTransportUp,
+	}
+}
+
+// WriteTo writes p to the remote address, addr must be the remote address
+func (c *connectedUDPConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if !isSameAddr(addr, c.remoteAddr) {
+		return 0, fmt.Errorf("%w: %s", errConnectedConnDestination, addr)
+	}
+
+	n, err := c.Write(p)
+	if isICMPError(err) {
+		// The error is the one of an earlier datagram, p wasn't sent
+		c.setDown(err)
+		n, err = c.Write(p)
+	}
+	return n, err
+}
+
+// ReadFrom reads the next datagram from the remote address, skipping ICMP errors
+func (c *connectedUDPConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, err := c.Read(p)
+		if isICMPError(err) {
+			c.setDown(err)
+			continue
+		}
+		if err == nil && atomic.CompareAndSwapInt32(&c.down, 1, 0) {
+			c.onUp()
+		}
+		return n, c.remoteAddr, err
+	}
+}
+
+func (c *connectedUDPConn) setDown(err error) {
+	if atomic.CompareAndSwapInt32(&c.down, 0, 1) {
+		c.onDown(err)
+	}
+}
+
+// isICMPError returns true if err reports an ICMP error received by a connected socket
+func isICMPError(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH)
+}
+
+func (c *Client) onTransportDown(err error) {
+	c.log.Warnf("Transport to %s is down: %s", c.TURNServerAddr(), err)
+	if c.onTransportDownHandler != nil {
+		c.onTransportDownHandler(err)
+	}
+}
+
+func (c *Client) onTransportUp() {
+	c.log.Infof("Transport to %s is up again", c.TURNServerAddr())
+
+	// The server may have lost the allocation, e.g. if it restarted, refresh it right away
+	if relayedConn := c.relayedUDPConn(); relayedConn != nil {
+		go func() {
+			if err := relayedConn.Refresh(); err != nil {
+				c.log.Warnf("Failed to refresh allocation after transport recovered: %s", err)
+			}
+		}()
+	}
+	if c.onTransportUpHandler != nil {
+		c.onTransportUpHandler()
+	}
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientConnectedConn(t *testing.T) {
+	newServer := func(addr string) (*Server, *net.UDPAddr) {
+		udpListener, err := net.ListenPacket("udp4", addr)
+		require.NoError(t, err)
+
+		server, err := NewServer(ServerConfig{
+			AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+				return GenerateAuthKey(username, realm, "pass"), true
+			},
+			PacketConnConfigs: []PacketConnConfig{
+				{
+					PacketConn: udpListener,
+					RelayAddressGenerator: &RelayAddressGeneratorStatic{
+						RelayAddress: net.ParseIP("127.0.0.1"),
+						Address:      "127.0.0.1",
+					},
+				},
+			},
+			Realm: "pion.ly",
+		})
+		require.NoError(t, err)
+
+		return server, udpListener.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+	}
+	server, serverAddr := newServer("127.0.0.1:0")
+
+	conn, err := net.DialUDP("udp4", nil, serverAddr)
+	require.NoError(t, err)
+
+	down, up := make(chan error, 1), make(chan struct{}, 1)
+	client, err := NewClient(&ClientConfig{
+		Conn:     conn,
+		RTO:      5 * time.Millisecond,
+		Username: "foo",
+		Password: "pass",
+		OnTransportDown: func(err error) {
+			down <- err
+		},
+		OnTransportUp: func() {
+			up <- struct{}{}
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+	assert.Equal(t, serverAddr.String(), client.TURNServerAddr().String())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = relayConn.WriteTo([]byte("Hello"), peer.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 64)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", string(buf[:n]))
+
+	// The connected socket can't write to other addresses
+	_, err = client.SendBindingRequestTo(peer.LocalAddr())
+	assert.ErrorIs(t, err, errConnectedConnDestination)
+
+	// The port of the server is unreachable once it is closed
+	require.NoError(t, server.Close())
+	_, err = client.SendBindingRequestTo(client.TURNServerAddr())
+	assert.Error(t, err)
+
+	select {
+	case err = <-down:
+		assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "OnTransportDown not called")
+	}
+
+	// The socket is used again once the server is back
+	server, _ = newServer(serverAddr.String())
+	_, err = client.SendBindingRequestTo(client.TURNServerAddr())
+	assert.NoError(t, err)
+
+	select {
+	case <-up:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "OnTransportUp not called")
+	}
+
+	require.NoError(t, relayConn.Close())
+	client.Close()
+	require.NoError(t, conn.Close())
+	require.NoError(t, peer.Close())
+	require.NoError(t, server.Close())
+}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"time"
+
+	"github.com/pion/stun/v2"
+)
+
+const (
+	defaultCredentialRenewalMargin = time.Minute
+	credentialRenewalRetryInterval = 10 * time.Second
+)
+
+// CredentialExpiry returns the expiry of the credential the client currently uses, and
+// false if it doesn't expire
+func (c *Client) CredentialExpiry() (time.Time, bool) {
+	return c.credentialExpiry(c.Username().String())
+}
+
+func (c *Client) credentials() (stun.Username, stun.MessageIntegrity) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.username, c.integrity
+}
+
+// scheduleCredentialRenewal starts the timer for the renewal of the current credential if
+// it expires and a CredentialProvider is set
+func (c *Client) scheduleCredentialRenewal() {
+	if c.credentialProvider == nil {
+		return
+	}
+
+	expiry, ok := c.CredentialExpiry()
+	if !ok {
+		return
+	}
+
+	c.startCredentialTimer(time.Until(expiry.Add(-c.credentialRenewalMargin)))
+}
+
+func (c *Client) startCredentialTimer(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return
+	}
+	if c.credentialTimer != nil {
+		c.credentialTimer.Stop()
+	}
+	c.credentialTimer = time.AfterFunc(d, c.renewCredentials)
+}
+
+func (c *Client) renewCredentials() {
+	expiry, expires := c.CredentialExpiry()
+
+	username, password, err := c.credentialProvider()
+	if err == nil {
+		err = c.applyCredentials(username, password)
+	}
+
+	if err != nil {
+		c.log.Warnf("Failed to renew credentials: %s", err)
+		if c.onCredentialRenewalFailed != nil {
+			c.onCredentialRenewalFailed(err)
+		}
+		if expires && time.Now().Add(credentialRenewalRetryInterval).Before(expiry) {
+			c.startCredentialTimer(credentialRenewalRetryInterval)
+		}
+		return
+	}
+
+	c.log.Debugf("Renewed credentials, new username %q", username)
+	c.scheduleCredentialRenewal()
+}
+
+// applyCredentials replaces the credentials of the client and refreshes its allocations
+// with them
+func (c *Client) applyCredentials(username, password string) error {
+	c.mutex.Lock()
+	c.username = stun.NewUsername(username)
+	c.password = password
+	c.integrity = stun.NewLongTermIntegrity(username, c.realm.String(), password)
+	newUsername, integrity := c.username, c.integrity
+	c.mutex.Unlock()
+
+	var err error
+	if relayedConn := c.relayedUDPConn(); relayedConn != nil {
+		err = relayedConn.SetCredentials(newUsername, integrity)
+	}
+	if allocation := c.getTCPAllocation(); allocation != nil {
+		if tcpErr := allocation.SetCredentials(newUsername, integrity); err == nil {
+			err = tcpErr
+		}
+	}
+
+	return err
+}
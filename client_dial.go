@@ -0,0 +1,226 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// Dialer contains options for connecting to a TURN server and allocating on it with Dial.
+// The zero value is a valid configuration
+type Dialer struct {
+	// TLSConfig is used for turns: URIs over TCP. ServerName defaults to the host of the
+	// URI, also for servers found with SRV and NAPTR records.
+	TLSConfig *tls.Config
+
+	// DTLSHandshaker connects to turns: URIs over UDP with DTLS, with the host of the URI
+	// as server name. turns: URIs over UDP aren't supported if unset.
+	DTLSHandshaker DTLSHandshaker
+
+	// Resolver looks up the servers of the URI, see ResolveServerURI. Defaults to
+	// net.DefaultResolver.
+	Resolver Resolver
+
+	// LoggerFactory is passed to the Client. Defaults to logging.NewDefaultLoggerFactory.
+	LoggerFactory logging.LoggerFactory
+
+	// Proxy returns the URL of the proxy servers are reached through, or nil to connect
+	// directly, e.g. http.ProxyURL. It is called with an https request to the server.
+	// HTTP proxies tunnel TCP with a CONNECT request and authenticate with the user info of
+	// the URL with Basic authentication, Client.TURNServerAddr is then the address of the
+	// proxy. SOCKS5 proxies, with a socks5 or socks5h URL, tunnel TCP with CONNECT and UDP
+	// with UDP ASSOCIATE, and authenticate with the user info with RFC 1929. Defaults to
+	// http.ProxyFromEnvironment, which honors HTTPS_PROXY and NO_PROXY.
+	Proxy func(*http.Request) (*url.URL, error)
+}
+
+// Dial connects to the TURN server of uri with the zero Dialer, see Dialer.Dial
+func Dial(ctx context.Context, uri, username, password string) (net.PacketConn, net.Addr, error) {
+	var d Dialer
+	return d.Dial(ctx, uri, username, password)
+}
+
+// Dial connects to the TURN server of uri, a turn: or turns: URI such as
+// "turns:turn.example.com?transport=tcp", and allocates a relayed transport address on it
+// with the long-term credential of username and password. The servers uri resolves to are
+// tried in order until one allocates. It returns the relayed connection and its relayed
+// address, closing the connection also closes the Client and the connection to the server.
+// turns: URIs over UDP require DTLSHandshaker
+func (d *Dialer) Dial(ctx context.Context, uri, username, password string) (net.PacketConn, net.Addr, error) {
+	candidates, err := ResolveServerURI(ctx, d.Resolver, uri)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, candidate := range candidates {
+		var relayConn net.PacketConn
+		if relayConn, err = d.dialCandidate(ctx, candidate, username, password); err == nil {
+			return relayConn, relayConn.LocalAddr(), nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	return nil, nil, err
+}
+
+// dialCandidate connects to candidate and allocates on it
+func (d *Dialer) dialCandidate(ctx context.Context, candidate ServerCandidate, username, password string) (net.PacketConn, error) {
+	conn, serverAddr, err := d.dialTransport(ctx, candidate)
+	if err != nil {
+		return nil, err
+	}
+
+	loggerFactory := d.LoggerFactory
+	if loggerFactory == nil {
+		loggerFactory = logging.NewDefaultLoggerFactory()
+	}
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		TURNServerAddr: serverAddr.String(),
+		Username:       username,
+		Password:       password,
+		LoggerFactory:  loggerFactory,
+	})
+	if err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+
+	// Allocate can't be canceled, closing the connection unblocks it
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	if err = client.Listen(); err != nil {
+		client.Close()
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+	relayConn, err := client.Allocate()
+	if err != nil {
+		client.Close()
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+
+	return &dialedConn{PacketConn: relayConn, client: client, conn: conn}, nil
+}
+
+// dialTransport connects to candidate, over a UDP socket, secured with DTLS for turns:
+// URIs, or a TCP connection wrapped with STUNConn, directly or through the proxy, and
+// returns the connection and the address of the server
+func (d *Dialer) dialTransport(ctx context.Context, candidate ServerCandidate) (net.PacketConn, net.Addr, error) {
+	if candidate.Proto == stun.ProtoTypeUDP && candidate.Secure && d.DTLSHandshaker == nil {
+		return nil, nil, fmt.Errorf("%w: %s", errDTLSUnsupported, candidate.Address)
+	}
+
+	proxyURL, err := d.proxyURL(candidate.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+	// HTTP proxies only tunnel TCP
+	if candidate.Proto == stun.ProtoTypeUDP && !isSOCKSProxy(proxyURL) {
+		proxyURL = nil
+	}
+
+	// The host of the server is resolved by HTTP and socks5h proxies for TCP
+	var addr net.Addr
+	address := candidate.Address
+	if proxyURL == nil || proxyURL.Scheme == "socks5" || candidate.Proto == stun.ProtoTypeUDP {
+		resolver := d.Resolver
+		if resolver == nil {
+			resolver = net.DefaultResolver
+		}
+		udpAddr, resolveErr := resolverAddrResolver(resolver)("udp", candidate.Address)
+		if resolveErr != nil {
+			return nil, nil, resolveErr
+		}
+		addr, address = udpAddr, udpAddr.String()
+	}
+
+	var dialer net.Dialer
+	if candidate.Proto == stun.ProtoTypeUDP {
+		var packetConn net.PacketConn
+		switch {
+		case proxyURL != nil:
+			packetConn, err = listenSOCKSUDP(ctx, &dialer, proxyURL)
+		case candidate.Secure:
+			// The DTLS records are written with WriteTo, which connected sockets refuse
+			packetConn, err = net.ListenUDP("udp", nil)
+		default:
+			packetConn, err = net.DialUDP("udp", nil, addr.(*net.UDPAddr)) //nolint:forcetypeassert
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if candidate.Secure {
+			if packetConn, err = dialDTLS(ctx, packetConn, true, addr, candidate.serverName(), d.DTLSHandshaker); err != nil {
+				return nil, nil, err
+			}
+		}
+		return packetConn, addr, nil
+	}
+
+	var conn net.Conn
+	switch {
+	case isSOCKSProxy(proxyURL):
+		conn, err = dialSOCKS(ctx, &dialer, proxyURL, address)
+	case proxyURL != nil:
+		conn, err = dialProxy(ctx, &dialer, proxyURL, address)
+	default:
+		conn, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if addr == nil {
+		addr = conn.RemoteAddr()
+	}
+
+	if candidate.Secure {
+		config := &tls.Config{MinVersion: tls.VersionTLS12}
+		if d.TLSConfig != nil {
+			config = d.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = candidate.serverName()
+		}
+
+		tlsConn := tls.Client(conn, config)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, nil, allocation.JoinErrors(err, conn.Close())
+		}
+		conn = tlsConn
+	}
+
+	return NewSTUNConn(conn), addr, nil
+}
+
+// dialedConn is the relayed connection returned by Dial, it closes the Client and the
+// connection to the server with the relayed connection
+type dialedConn struct {
+	net.PacketConn
+	client *Client
+	conn   net.PacketConn
+}
+
+func (c *dialedConn) Close() error {
+	err := c.PacketConn.Close()
+	c.client.Close()
+	return allocation.JoinErrors(err, c.conn.Close())
+}
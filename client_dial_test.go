@@ -0,0 +1,419 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDial(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Nothing listens on the port of the closed listener
+	closedListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closedListener.Addr().(*net.TCPAddr) //nolint:forcetypeassert
+	require.NoError(t, closedListener.Close())
+
+	relayAddressGenerator := &RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP("127.0.0.1"),
+		Address:      "127.0.0.1",
+	}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{PacketConn: udpListener, RelayAddressGenerator: relayAddressGenerator},
+		},
+		ListenerConfigs: []ListenerConfig{
+			{Listener: tcpListener, RelayAddressGenerator: relayAddressGenerator},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, server.Close())
+	}()
+
+	dialer := &Dialer{
+		Resolver: &fakeResolver{
+			hosts: map[string][]net.IPAddr{
+				"turn.example.com": {{IP: net.ParseIP("127.0.0.1")}},
+			},
+			srvs: map[string][]*net.SRV{
+				"_turn._udp.turn.example.com": {
+					{Target: "turn.example.com.", Port: uint16(udpListener.LocalAddr().(*net.UDPAddr).Port)}, //nolint:forcetypeassert
+				},
+				"_turn._tcp.turn.example.com": {
+					{Target: "turn.example.com.", Port: uint16(closedAddr.Port), Priority: 1},
+					{Target: "turn.example.com.", Port: uint16(tcpListener.Addr().(*net.TCPAddr).Port), Priority: 2}, //nolint:forcetypeassert
+				},
+			},
+		},
+	}
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, peer.Close())
+	}()
+
+	for _, uri := range []string{
+		"turn:turn.example.com",
+		"turn:turn.example.com?transport=tcp",
+	} {
+		t.Run(uri, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			relayConn, relayedAddr, err := dialer.Dial(ctx, uri, "foo", "pass")
+			require.NoError(t, err)
+			assert.Equal(t, relayConn.LocalAddr(), relayedAddr)
+
+			_, err = relayConn.WriteTo([]byte("Hello"), peer.LocalAddr())
+			require.NoError(t, err)
+
+			buf := make([]byte, 64)
+			require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, from, err := peer.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "Hello", string(buf[:n]))
+			assert.Equal(t, relayedAddr.String(), from.String())
+
+			assert.NoError(t, relayConn.Close())
+		})
+	}
+
+	t.Run("DTLS", func(t *testing.T) {
+		_, _, err := dialer.Dial(context.Background(), "turns:127.0.0.1:5349?transport=udp", "foo", "pass")
+		assert.ErrorIs(t, err, errDTLSUnsupported)
+	})
+}
+
+func TestDialProxy(t *testing.T) {
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener: tcpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, server.Close())
+	}()
+
+	// The proxy tunnels CONNECT requests for turn.example.com to the server
+	proxyListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, proxyListener.Close())
+	}()
+
+	serverPort := strconv.Itoa(tcpListener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert
+	go func() {
+		for {
+			conn, acceptErr := proxyListener.Accept()
+			if acceptErr != nil {
+				return
+			}
+
+			req, readErr := http.ReadRequest(bufio.NewReader(conn))
+			if readErr != nil {
+				_ = conn.Close()
+				continue
+			}
+			if req.Method != http.MethodConnect || req.Host != "turn.example.com:"+serverPort {
+				_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+				_ = conn.Close()
+				continue
+			}
+			if username, password, ok := parseProxyAuthorization(req.Header.Get("Proxy-Authorization")); !ok || username != "proxyuser" || password != "proxypass" {
+				_, _ = conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+				_ = conn.Close()
+				continue
+			}
+
+			serverConn, dialErr := net.Dial("tcp4", tcpListener.Addr().String())
+			if dialErr != nil {
+				_ = conn.Close()
+				continue
+			}
+			_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+			go func() {
+				_, _ = io.Copy(serverConn, conn)
+				_ = serverConn.Close()
+			}()
+			go func() {
+				_, _ = io.Copy(conn, serverConn)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	proxyAddr := proxyListener.Addr().String()
+	uri := "turn:turn.example.com:" + serverPort + "?transport=tcp"
+
+	t.Run("Authenticated", func(t *testing.T) {
+		dialer := &Dialer{Proxy: http.ProxyURL(&url.URL{
+			Scheme: "http",
+			User:   url.UserPassword("proxyuser", "proxypass"),
+			Host:   proxyAddr,
+		})}
+
+		relayConn, relayedAddr, err := dialer.Dial(context.Background(), uri, "foo", "pass")
+		require.NoError(t, err)
+		assert.NotNil(t, relayedAddr)
+		assert.NoError(t, relayConn.Close())
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		dialer := &Dialer{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: proxyAddr})}
+
+		_, _, err := dialer.Dial(context.Background(), uri, "foo", "pass")
+		assert.ErrorIs(t, err, errProxyConnect)
+	})
+}
+
+func TestDialSOCKS(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	relayAddressGenerator := &RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP("127.0.0.1"),
+		Address:      "127.0.0.1",
+	}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{PacketConn: udpListener, RelayAddressGenerator: relayAddressGenerator},
+		},
+		ListenerConfigs: []ListenerConfig{
+			{Listener: tcpListener, RelayAddressGenerator: relayAddressGenerator},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, server.Close())
+	}()
+
+	proxyListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, proxyListener.Close())
+	}()
+	go func() {
+		for {
+			conn, acceptErr := proxyListener.Accept()
+			if acceptErr != nil {
+				return
+			}
+			go serveSOCKS(conn, "proxyuser", "proxypass")
+		}
+	}()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, peer.Close())
+	}()
+
+	dialer := &Dialer{Proxy: http.ProxyURL(&url.URL{
+		Scheme: "socks5h",
+		User:   url.UserPassword("proxyuser", "proxypass"),
+		Host:   proxyListener.Addr().String(),
+	})}
+
+	for _, uri := range []string{
+		"turn:" + udpListener.LocalAddr().String(),
+		"turn:" + tcpListener.Addr().String() + "?transport=tcp",
+	} {
+		t.Run(uri, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			relayConn, relayedAddr, err := dialer.Dial(ctx, uri, "foo", "pass")
+			require.NoError(t, err)
+
+			_, err = relayConn.WriteTo([]byte("Hello"), peer.LocalAddr())
+			require.NoError(t, err)
+
+			buf := make([]byte, 64)
+			require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, from, err := peer.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "Hello", string(buf[:n]))
+			assert.Equal(t, relayedAddr.String(), from.String())
+
+			_, err = peer.WriteTo([]byte("World"), from)
+			require.NoError(t, err)
+			require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, from, err = relayConn.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, "World", string(buf[:n]))
+			assert.Equal(t, peer.LocalAddr().String(), from.String())
+
+			assert.NoError(t, relayConn.Close())
+		})
+	}
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		dialer := &Dialer{Proxy: http.ProxyURL(&url.URL{
+			Scheme: "socks5",
+			User:   url.UserPassword("proxyuser", "wrong"),
+			Host:   proxyListener.Addr().String(),
+		})}
+
+		_, _, err := dialer.Dial(context.Background(), "turn:"+tcpListener.Addr().String()+"?transport=tcp", "foo", "pass")
+		assert.ErrorIs(t, err, errSOCKSAuth)
+	})
+}
+
+// serveSOCKS serves the CONNECT and UDP ASSOCIATE requests of a SOCKS5 client on conn,
+// authenticated with username and password
+func serveSOCKS(conn net.Conn, username, password string) {
+	defer conn.Close() //nolint:errcheck
+
+	// Only username and password authentication is offered
+	methods := make([]byte, 2)
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, methods[1])); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{socksVersion, socksAuthPassword}); err != nil {
+		return
+	}
+
+	credentials := make([][]byte, 2)
+	for i := range credentials {
+		length := make([]byte, 2)
+		if _, err := io.ReadFull(conn, length[i:]); err != nil {
+			return
+		}
+		credentials[i] = make([]byte, length[1])
+		if _, err := io.ReadFull(conn, credentials[i]); err != nil {
+			return
+		}
+	}
+	if string(credentials[0]) != username || string(credentials[1]) != password {
+		_, _ = conn.Write([]byte{socksAuthVersion, 1})
+		return
+	}
+	if _, err := conn.Write([]byte{socksAuthVersion, 0}); err != nil {
+		return
+	}
+
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	host, port, err := readSOCKSAddr(conn)
+	if err != nil {
+		return
+	}
+
+	switch header[1] {
+	case socksCmdConnect:
+		target, err := net.Dial("tcp4", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			_, _ = conn.Write([]byte{socksVersion, 5, 0, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer target.Close() //nolint:errcheck
+
+		reply, _ := appendSOCKSAddr([]byte{socksVersion, socksReplySucceeded, 0}, target.LocalAddr().String())
+		if _, err = conn.Write(reply); err != nil {
+			return
+		}
+		go func() {
+			_, _ = io.Copy(target, conn)
+			_ = target.Close()
+		}()
+		_, _ = io.Copy(conn, target)
+
+	case socksCmdUDPAssociate:
+		relay, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return
+		}
+		defer relay.Close() //nolint:errcheck
+
+		// The relay address is unspecified, to be replaced by the address of the proxy
+		reply, _ := appendSOCKSAddr([]byte{socksVersion, socksReplySucceeded, 0},
+			net.JoinHostPort("0.0.0.0", strconv.Itoa(relay.LocalAddr().(*net.UDPAddr).Port))) //nolint:forcetypeassert
+		if _, err = conn.Write(reply); err != nil {
+			return
+		}
+
+		// Datagrams from the port of the request are from the client
+		client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+		go func() {
+			buf := make([]byte, 1600)
+			for {
+				n, from, err := relay.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				if from.Port != client.Port {
+					datagram, _ := appendSOCKSAddr([]byte{0, 0, 0}, from.String())
+					_, _ = relay.WriteTo(append(datagram, buf[:n]...), client)
+					continue
+				}
+
+				reader := bytes.NewReader(buf[3:n])
+				host, port, err := readSOCKSAddr(reader)
+				if err != nil {
+					continue
+				}
+				_, _ = relay.WriteTo(buf[n-reader.Len():n], &net.UDPAddr{IP: net.ParseIP(host), Port: port})
+			}
+		}()
+
+		// The association ends with the control connection
+		_, _ = io.Copy(io.Discard, conn)
+	}
+}
+
+// parseProxyAuthorization returns the credentials of a Basic Proxy-Authorization header
+func parseProxyAuthorization(header string) (username, password string, ok bool) {
+	req := &http.Request{Header: http.Header{"Authorization": {header}}}
+	return req.BasicAuth()
+}
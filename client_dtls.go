@@ -0,0 +1,81 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"net"
+
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// DTLSHandshaker performs the DTLS handshake as client on conn, which carries the DTLS
+// records exchanged with the TURN server over UDP, and returns the DTLS connection. Every
+// read and write of the returned connection must be a single datagram. The certificate of
+// the server must be verified against serverName. Wrap a DTLS client that retransmits lost
+// handshake flights itself and returns once ctx is done, e.g. dtls.ClientWithContext of
+// github.com/pion/dtls with a Config whose ServerName is serverName
+type DTLSHandshaker func(ctx context.Context, conn net.Conn, serverName string) (net.Conn, error)
+
+// dtlsClientConn is the connection of a Client to a TURN server over DTLS. The DTLS
+// records sent by the server are demultiplexed from the rest of the traffic of the socket,
+// e.g. STUN Binding responses of STUN servers, and the datagrams exchanged with the server
+// are relayed through the DTLS connection
+type dtlsClientConn struct {
+	*dtlsDemuxPacketConn
+	dtlsConn   net.Conn
+	serverAddr net.Addr
+}
+
+// dialDTLS connects to serverAddr with DTLS on conn. The DTLS records of other addresses
+// are dropped, retransmissions of the handshake flights of the server are passed to the
+// DTLS connection even after the handshake. conn is closed with the returned connection if
+// ownsConn is set, also if the handshake fails
+func dialDTLS(ctx context.Context, conn net.PacketConn, ownsConn bool, serverAddr net.Addr, serverName string, handshake DTLSHandshaker) (*dtlsClientConn, error) {
+	d := newDTLSDemux(conn)
+	d.keepConn = !ownsConn
+	d.listenerDone = true
+	recordConn := d.newConn(serverAddr)
+	d.conns[serverAddr.String()] = recordConn
+	go d.readLoop()
+
+	c := &dtlsClientConn{dtlsDemuxPacketConn: &dtlsDemuxPacketConn{d}, serverAddr: serverAddr}
+	dtlsConn, err := handshake(ctx, recordConn, serverName)
+	if err != nil {
+		return nil, allocation.JoinErrors(err, recordConn.Close(), c.dtlsDemuxPacketConn.Close())
+	}
+	c.dtlsConn = dtlsConn
+	go c.readLoop()
+
+	return c, nil
+}
+
+// readLoop passes the datagrams received on the DTLS connection to ReadFrom
+func (c *dtlsClientConn) readLoop() {
+	buf := make([]byte, dtlsDemuxMaxPacketSize)
+	for {
+		n, err := c.dtlsConn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		select {
+		case c.packets <- dtlsDemuxPacket{data: append([]byte{}, buf[:n]...), addr: c.serverAddr}:
+		default: // Drop, the PacketConn is not read fast enough
+		}
+	}
+}
+
+// WriteTo sends p on the DTLS connection if addr is the TURN server
+func (c *dtlsClientConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if addr.String() == c.serverAddr.String() {
+		return c.dtlsConn.Write(p)
+	}
+
+	return c.dtlsDemuxPacketConn.WriteTo(p, addr)
+}
+
+func (c *dtlsClientConn) Close() error {
+	return allocation.JoinErrors(c.dtlsConn.Close(), c.dtlsDemuxPacketConn.Close())
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/transport/v3/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The handshake of the fake DTLS layer is a single hello record of the client, which is
+// retransmitted until the server answers with its hello record
+const (
+	fakeDTLSHandshakeContentType = 22
+	fakeDTLSClientHello          = 1
+	fakeDTLSServerHello          = 2
+)
+
+var errFakeDTLSServerName = errors.New("unexpected server name")
+
+// fakeDTLSHandshaker is the client side of the fake DTLS handshake
+func fakeDTLSHandshaker(serverName string) DTLSHandshaker {
+	return func(ctx context.Context, conn net.Conn, name string) (net.Conn, error) {
+		if name != serverName {
+			return nil, errFakeDTLSServerName
+		}
+
+		buf := make([]byte, 64)
+		for ctx.Err() == nil {
+			if _, err := conn.Write([]byte{fakeDTLSHandshakeContentType, fakeDTLSClientHello}); err != nil {
+				return nil, err
+			}
+			if err := conn.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+				return nil, err
+			}
+			if n, err := conn.Read(buf); err == nil && n == 2 && buf[0] == fakeDTLSHandshakeContentType && buf[1] == fakeDTLSServerHello {
+				return &fakeDTLSConn{conn}, conn.SetReadDeadline(time.Time{})
+			}
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// fakeDTLSHandshakeListener is the server side of the fake DTLS handshake. It drops the
+// first hello of every client, like a lost datagram
+type fakeDTLSHandshakeListener struct {
+	net.Listener
+	droppedHellos int32 // Accessed atomically
+}
+
+func (l *fakeDTLSHandshakeListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 64)
+	dropped := false
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n != 2 || buf[0] != fakeDTLSHandshakeContentType || buf[1] != fakeDTLSClientHello {
+			continue
+		}
+		if !dropped {
+			dropped = true
+			atomic.AddInt32(&l.droppedHellos, 1)
+			continue
+		}
+
+		if _, err = conn.Write([]byte{fakeDTLSHandshakeContentType, fakeDTLSServerHello}); err != nil {
+			return nil, err
+		}
+		return &fakeDTLSConn{conn}, nil
+	}
+}
+
+func TestClientDTLS(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// The server shares its port between TURN over UDP and TURN over DTLS
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+	port := strconv.Itoa(udpListener.LocalAddr().(*net.UDPAddr).Port) //nolint:forcetypeassert
+
+	packetConn, dtlsListener := DemuxDTLS(udpListener)
+	handshakeListener := &fakeDTLSHandshakeListener{Listener: dtlsListener}
+	relayAddressGenerator := &RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP("127.0.0.1"),
+		Address:      "127.0.0.1",
+	}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{PacketConn: packetConn, RelayAddressGenerator: relayAddressGenerator},
+		},
+		ListenerConfigs: []ListenerConfig{
+			{Listener: handshakeListener, RelayAddressGenerator: relayAddressGenerator, Datagram: true},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	resolver := &fakeResolver{
+		hosts: map[string][]net.IPAddr{
+			"turn.example.com": {{IP: net.ParseIP("127.0.0.1")}},
+		},
+	}
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	relay := func(t *testing.T, relayConn net.PacketConn) {
+		t.Helper()
+
+		buf := make([]byte, 64)
+		for _, data := range []string{"Hello", "World", "!"} {
+			_, err := relayConn.WriteTo([]byte(data), peer.LocalAddr())
+			require.NoError(t, err)
+
+			require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, from, err := peer.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, data, string(buf[:n]))
+
+			_, err = peer.WriteTo(buf[:n], from)
+			require.NoError(t, err)
+			require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, from, err = relayConn.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, data, string(buf[:n]))
+			assert.Equal(t, peer.LocalAddr().String(), from.String())
+		}
+	}
+
+	t.Run("Client", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			Conn:           conn,
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: "turn.example.com:" + port,
+			Resolver:       resolver,
+			DTLSHandshaker: fakeDTLSHandshaker("turn.example.com"),
+			Username:       "foo",
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&handshakeListener.droppedHellos))
+
+		// Plain STUN shares the socket with DTLS
+		mappedAddr, err := client.SendBindingRequest()
+		require.NoError(t, err)
+		assert.Equal(t, conn.LocalAddr().String(), mappedAddr.String())
+
+		relayConn, err := client.Allocate()
+		require.NoError(t, err)
+		relay(t, relayConn)
+
+		stats := server.AllocationStats()
+		require.Len(t, stats, 1)
+		assert.Equal(t, ClientTransportDTLS, stats[0].ClientTransport)
+
+		assert.NoError(t, relayConn.Close())
+		client.Close()
+		assert.NoError(t, conn.Close())
+	})
+
+	t.Run("Dial", func(t *testing.T) {
+		dialer := &Dialer{Resolver: resolver, DTLSHandshaker: fakeDTLSHandshaker("turn.example.com")}
+		relayConn, _, err := dialer.Dial(context.Background(), "turns:turn.example.com:"+port+"?transport=udp", "foo", "pass")
+		require.NoError(t, err)
+		relay(t, relayConn)
+		assert.NoError(t, relayConn.Close())
+	})
+
+	_, err = NewClient(&ClientConfig{
+		TURNServerAddr: "turn.example.com:" + port,
+		Resolver:       resolver,
+		DTLSHandshaker: fakeDTLSHandshaker("other.example.com"),
+	})
+	assert.ErrorIs(t, err, errFakeDTLSServerName)
+
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
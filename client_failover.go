@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"net"
+	"time"
+
+	"github.com/pion/turn/v3/internal/client"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+const defaultFailoverProbeInterval = 15 * time.Second
+
+// Failover describes the move of the allocation of a Client to another TURN server after
+// the current one stopped answering, see ClientConfig.FailoverServerAddrs
+type Failover struct {
+	// From is the TURN server that stopped answering
+	From net.Addr
+
+	// To is the TURN server the allocation was created on, and RelayedAddr its new relayed
+	// transport address. Both are nil if no other server could allocate, the client stays
+	// on From and fails over again when the next request to From fails
+	To          net.Addr
+	RelayedAddr net.Addr
+
+	// Err is the error of the last server that couldn't allocate if To is nil, or the
+	// error restoring the permissions and channel bindings on To
+	Err error
+}
+
+// scheduleFailoverProbe starts probing the TURN server with Binding requests if the client
+// has servers to fail over to
+func (c *Client) scheduleFailoverProbe() {
+	if len(c.turnServerAddrs) < 2 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed || c.failoverTimer != nil {
+		return
+	}
+	c.failoverTimer = time.AfterFunc(c.failoverProbeInterval, c.probeTURNServer)
+}
+
+// probeTURNServer sends a Binding request to the TURN server. onRtxTimeout fails over if it
+// isn't answered
+func (c *Client) probeTURNServer() {
+	if c.relayedUDPConn() != nil {
+		if _, err := c.SendBindingRequestTo(c.TURNServerAddr()); err != nil {
+			c.log.Debugf("Failed to probe TURN server: %s", err)
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.closed {
+		c.failoverTimer.Reset(c.failoverProbeInterval)
+	}
+}
+
+// onServerUnresponsive fails over in the background if to, to which a request failed after
+// all retransmissions, is the current TURN server
+func (c *Client) onServerUnresponsive(to net.Addr) {
+	if len(c.turnServerAddrs) < 2 || !isSameAddr(to, c.TURNServerAddr()) {
+		return
+	}
+
+	go c.failover(to)
+}
+
+// failover moves the allocation from the unresponsive server from to the next server that
+// allocates, in the order of ClientConfig.FailoverServerAddrs
+func (c *Client) failover(from net.Addr) {
+	// Allocate or another failover is in progress
+	if err := c.allocTryLock.Lock(); err != nil {
+		return
+	}
+	defer c.allocTryLock.Unlock()
+
+	c.mutex.Lock()
+	relayedConn, closed := c.relayedConn, c.closed
+	if relayedConn == nil || closed || !isSameAddr(from, c.turnServerAddr) {
+		c.mutex.Unlock()
+		return
+	}
+	// The AFFINITY-TOKEN of from means nothing to the other servers
+	c.affinityToken = nil
+	c.mutex.Unlock()
+
+	c.log.Warnf("TURN server %s is unresponsive, failing over", from)
+
+	event := Failover{From: from}
+	for _, to := range c.failoverCandidates(from) {
+		relayedAddr, err := c.failoverTo(relayedConn, to)
+		if relayedAddr == nil {
+			c.log.Warnf("Failed to allocate on TURN server %s: %s", to, err)
+			event.Err = err
+			continue
+		}
+
+		c.log.Infof("Failed over from TURN server %s to %s", from, to)
+		event = Failover{From: from, To: to, RelayedAddr: relayedAddr, Err: err}
+		break
+	}
+
+	if c.onFailover != nil {
+		c.onFailover(event)
+	}
+}
+
+// failoverCandidates returns the TURN servers after from, wrapping around
+func (c *Client) failoverCandidates(from net.Addr) []net.Addr {
+	start := 0
+	for i, addr := range c.turnServerAddrs {
+		if isSameAddr(addr, from) {
+			start = i + 1
+			break
+		}
+	}
+
+	candidates := []net.Addr{}
+	for i := 0; i < len(c.turnServerAddrs); i++ {
+		addr := c.turnServerAddrs[(start+i)%len(c.turnServerAddrs)]
+		if !isSameAddr(addr, from) {
+			candidates = append(candidates, addr)
+		}
+	}
+	return candidates
+}
+
+// failoverTo allocates on server and moves relayedConn there. The returned relayed address
+// is nil if the allocation failed
+func (c *Client) failoverTo(relayedConn *client.UDPConn, server net.Addr) (net.Addr, error) {
+	relayed, lifetime, nonce, ticket, err := c.sendAllocateRequest(server, proto.ProtoUDP)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.turnServerAddr = server
+	c.mutex.Unlock()
+
+	username, integrity := c.credentials()
+	relayedAddr := &net.UDPAddr{
+		IP:   relayed.IP,
+		Port: relayed.Port,
+	}
+	return relayedAddr, relayedConn.Failover(&client.AllocationConfig{
+		RelayedAddr:    relayedAddr,
+		ServerAddr:     server,
+		Realm:          c.realm,
+		Username:       username,
+		Integrity:      integrity,
+		Nonce:          nonce,
+		Lifetime:       lifetime.Duration,
+		MobilityTicket: ticket,
+		AffinityToken:  c.getAffinityToken(),
+	})
+}
+
+func isSameAddr(a, b net.Addr) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
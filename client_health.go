@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"time"
+)
+
+// AllocationHealth is the result of a health check of the allocation of a Client, see
+// ClientConfig.HealthCheckInterval
+type AllocationHealth struct {
+	// Healthy is set if the TURN server answered the check
+	Healthy bool
+
+	// RTT is the time the server took to answer the check, including retransmissions
+	RTT time.Duration
+
+	// Failures is the number of consecutive checks that failed, 0 if Healthy
+	Failures int
+
+	// Err is the error of the check if it failed, e.g. a transaction timeout if the
+	// server didn't answer, or an error response to a Refresh if the server lost the
+	// allocation
+	Err error
+}
+
+// scheduleHealthCheck starts checking the health of the allocation if HealthCheckInterval
+// is set
+func (c *Client) scheduleHealthCheck() {
+	if c.healthCheckInterval <= 0 {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed || c.healthTimer != nil {
+		return
+	}
+	c.healthTimer = time.AfterFunc(c.healthCheckInterval, c.checkHealth)
+}
+
+// checkHealth sends a Binding request, or a Refresh if HealthCheckRefresh is set, to the
+// TURN server and reports the result to OnAllocationHealth
+func (c *Client) checkHealth() {
+	if health, ok := c.probeAllocation(); ok && c.onAllocationHealth != nil {
+		c.onAllocationHealth(health)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.closed {
+		c.healthTimer.Reset(c.healthCheckInterval)
+	}
+}
+
+// probeAllocation checks the allocation, ok is false if the client has none
+func (c *Client) probeAllocation() (health AllocationHealth, ok bool) {
+	var refresh func() error
+	if relayedConn := c.relayedUDPConn(); relayedConn != nil {
+		refresh = relayedConn.Refresh
+	} else if tcpAlloc := c.getTCPAllocation(); tcpAlloc != nil {
+		refresh = tcpAlloc.Refresh
+	} else {
+		return health, false
+	}
+
+	start := time.Now()
+	var err error
+	if c.healthCheckRefresh {
+		err = refresh()
+	} else {
+		_, err = c.SendBindingRequestTo(c.TURNServerAddr())
+	}
+	health.RTT = time.Since(start)
+
+	c.mutex.Lock()
+	if err != nil {
+		c.healthFailures++
+	} else {
+		c.healthFailures = 0
+	}
+	health.Failures = c.healthFailures
+	c.mutex.Unlock()
+
+	health.Healthy, health.Err = err == nil, err
+	if err != nil {
+		c.log.Warnf("Health check of TURN server %s failed: %s", c.TURNServerAddr(), err)
+	}
+
+	return health, true
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"net"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/turn/v3/internal/client"
+	"github.com/pion/turn/v3/internal/ipnet"
+)
+
+// nat64DiscoveryAddr is the well-known name only resolving to IPv6 addresses through
+// DNS64, with the IPv4 addresses 192.0.0.170 and 192.0.0.171 embedded. See RFC 7050
+const nat64DiscoveryAddr = "ipv4only.arpa:0"
+
+// serverResolver resolves the STUN and TURN server addresses for the address family the
+// client can reach
+type serverResolver struct {
+	resolveAddr client.AddrResolver
+	resolver    Resolver
+	ipv6Only    bool
+
+	nat64Prefix     *net.IPNet
+	nat64Discovered bool
+}
+
+func newServerResolver(config *ClientConfig, conn net.PacketConn, resolveAddr client.AddrResolver) *serverResolver {
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	return &serverResolver{
+		resolveAddr:     resolveAddr,
+		resolver:        resolver,
+		ipv6Only:        config.IPv6Only || isIPv6OnlyConn(conn, config.Net),
+		nat64Prefix:     config.NAT64Prefix,
+		nat64Discovered: config.NAT64Prefix != nil,
+	}
+}
+
+// resolve resolves address with network "udp4", or "udp6" if the client is IPv6-only. IPv6-only
+// clients fall back to the IPv4 address of servers without an IPv6 address, synthesized
+// with the NAT64 prefix if there is one. Addresses without a port are looked up with the
+// SRV records of service
+func (r *serverResolver) resolve(service, address string) (*net.UDPAddr, error) {
+	address = lookupServerSRV(r.resolver, service, address)
+
+	if !r.ipv6Only {
+		return r.resolveAddr("udp4", address)
+	}
+
+	if addr, err := r.resolveAddr("udp6", address); err == nil {
+		return addr, nil
+	}
+
+	addr, err := r.resolveAddr("udp4", address)
+	if err != nil {
+		return nil, err
+	}
+
+	if !r.nat64Discovered {
+		r.nat64Prefix = discoverNAT64Prefix(r.resolveAddr)
+		r.nat64Discovered = true
+	}
+	if r.nat64Prefix == nil {
+		return addr, nil
+	}
+
+	return &net.UDPAddr{IP: ipnet.SynthesizeNAT64(r.nat64Prefix, addr.IP), Port: addr.Port}, nil
+}
+
+// discoverNAT64Prefix returns the /96 NAT64 prefix of the network, or nil if there is none
+func discoverNAT64Prefix(resolveAddr client.AddrResolver) *net.IPNet {
+	addr, err := resolveAddr("udp6", nat64DiscoveryAddr)
+	if err != nil || addr.IP.To4() != nil || len(addr.IP) != net.IPv6len {
+		return nil
+	}
+
+	prefix := &net.IPNet{IP: make(net.IP, net.IPv6len), Mask: net.CIDRMask(ipnet.NAT64PrefixLength, 128)}
+	copy(prefix.IP, addr.IP[:12])
+
+	for _, wellKnown := range []net.IP{net.IPv4(192, 0, 0, 170), net.IPv4(192, 0, 0, 171)} {
+		if ip, ok := ipnet.ExtractNAT64(prefix, addr.IP); ok && ip.Equal(wellKnown) {
+			return prefix
+		}
+	}
+
+	return nil
+}
+
+// isIPv6OnlyConn returns true if conn is bound to an IPv6 address, or to the unspecified
+// address of a host without IPv4 connectivity
+func isIPv6OnlyConn(conn net.PacketConn, n transport.Net) bool {
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok || addr.IP == nil || addr.IP.To4() != nil {
+		return false
+	}
+
+	if !addr.IP.IsUnspecified() {
+		return true
+	}
+
+	ifaces, err := n.Interfaces()
+	if err != nil {
+		return false
+	}
+
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				return false
+			}
+		}
+	}
+
+	return true
+}
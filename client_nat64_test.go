@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v3/internal/ipnet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nat64Conn is an IPv6-only client socket behind a NAT64 gateway with the well-known
+// prefix, that translates the addresses of all IPv4 hosts to the IPv6 loopback address
+type nat64Conn struct {
+	net.PacketConn
+	prefix *net.IPNet
+}
+
+func (c *nat64Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, errTODO
+	}
+
+	if _, ok := ipnet.ExtractNAT64(c.prefix, udpAddr.IP); !ok {
+		return 0, errTODO
+	}
+
+	return c.PacketConn.WriteTo(p, &net.UDPAddr{IP: net.IPv6loopback, Port: udpAddr.Port})
+}
+
+func (c *nat64Conn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		addr = &net.UDPAddr{IP: ipnet.SynthesizeNAT64(c.prefix, net.IPv4(192, 0, 2, 1)), Port: udpAddr.Port}
+	}
+
+	return n, addr, err
+}
+
+func TestClientNAT64(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %s", err)
+	}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, prefix, err := net.ParseCIDR("64:ff9b::/96")
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	require.NoError(t, err)
+
+	// turn.example only has an A record, DNS64 synthesizes ipv4only.arpa
+	serverPort := udpListener.LocalAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+	serverAddr := net.JoinHostPort("turn.example", strconv.Itoa(serverPort))
+	client, err := NewClient(&ClientConfig{
+		Conn:           &nat64Conn{PacketConn: conn, prefix: prefix},
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       "foo",
+		Password:       "pass",
+		ResolveAddr: func(network, address string) (*net.UDPAddr, error) {
+			switch {
+			case network == "udp4" && address == serverAddr:
+				return &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: serverPort}, nil
+			case network == "udp6" && address == nat64DiscoveryAddr:
+				return &net.UDPAddr{IP: net.ParseIP("64:ff9b::c000:aa")}, nil
+			}
+			return nil, errTODO
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "[64:ff9b::c000:201]:"+strconv.Itoa(serverPort), client.turnServerAddr.String())
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// A peer address learned through DNS64 is reached over the IPv4 relayed address
+	peerAddr := peer.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+	_, err = relayConn.WriteTo([]byte("nat64"), &net.UDPAddr{IP: ipnet.SynthesizeNAT64(prefix, peerAddr.IP), Port: peerAddr.Port})
+	require.NoError(t, err)
+
+	buf := make([]byte, 64)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "nat64", string(buf[:n]))
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientNAT64PrefixValidation(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+
+	_, prefix, err := net.ParseCIDR("2001:db8::/64")
+	require.NoError(t, err)
+
+	_, err = NewClient(&ClientConfig{Conn: conn, NAT64Prefix: prefix})
+	assert.ErrorIs(t, err, errNAT64PrefixInvalid)
+}
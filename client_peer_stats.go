@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"github.com/pion/turn/v3/internal/client"
+)
+
+// PeerDelivery is how the TURN server relays the data of a peer to the client
+type PeerDelivery = client.Delivery
+
+const (
+	// PeerDeliveryIndication is data relayed in Data indications, before a channel is bound
+	PeerDeliveryIndication = client.DeliveryIndication
+	// PeerDeliveryChannel is data relayed in ChannelData messages
+	PeerDeliveryChannel = client.DeliveryChannel
+)
+
+// PeerStats is the data received from a peer of the allocation, see Client.PeerStats
+type PeerStats = client.PeerStats
+
+// PeerStats returns the data received from the peers the relayed net.PacketConn returned
+// by Allocate wrote to, with the way the server currently relays their data. After a
+// channel is bound to a peer the server switches from Data indications to ChannelData,
+// Data indications of the peer received after its first ChannelData were reordered on
+// the way and are dropped, so the data of the peer is still read in order. Returns nil
+// if there is no allocation, and nothing for peers if DisableChannelBindings is set
+func (c *Client) PeerStats() []PeerStats {
+	relayedConn := c.relayedUDPConn()
+	if relayedConn == nil {
+		return nil
+	}
+
+	return relayedConn.PeerStats()
+}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// proxyURL returns the URL of the HTTP proxy to reach the TURN server at address through,
+// nil to connect directly. The proxy is looked up for an https URL of address, so
+// HTTPS_PROXY and NO_PROXY apply with the default http.ProxyFromEnvironment
+func (d *Dialer) proxyURL(address string) (*url.URL, error) {
+	proxy := d.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	return proxy(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+}
+
+// dialProxy connects to address through the HTTP proxy at proxyURL with a CONNECT request,
+// authenticated with the user info of proxyURL if set. Proxies with an https URL are
+// connected to over TLS
+func dialProxy(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, address string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{
+			ServerName: proxyURL.Hostname(),
+			MinVersion: tls.VersionTLS12,
+		})
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, allocation.JoinErrors(err, conn.Close())
+		}
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+	if err = req.Write(conn); err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+	if err = res.Body.Close(); err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, allocation.JoinErrors(fmt.Errorf("%w: %s", errProxyConnect, res.Status), conn.Close())
+	}
+
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+
+	// The server doesn't send before the client, but don't lose what the reader buffered
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn read through reader
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
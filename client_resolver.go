@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/client"
+)
+
+// Resolver looks up host names for the Client, e.g. for split-horizon DNS, DNS over HTTPS
+// or test fixtures. *net.Resolver implements it
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (cname string, addrs []*net.SRV, err error)
+}
+
+// resolverAddrResolver returns an AddrResolver resolving the host names of addresses with
+// resolver. The first address of the family of network is used
+func resolverAddrResolver(resolver Resolver) client.AddrResolver {
+	return func(network, address string) (*net.UDPAddr, error) {
+		host, portStr, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port < 0 || port > 0xffff {
+			return nil, fmt.Errorf("%w: %s", errInvalidPort, address)
+		}
+
+		if ip := net.ParseIP(host); ip != nil {
+			return &net.UDPAddr{IP: ip, Port: port}, nil
+		}
+
+		addrs, err := resolver.LookupIPAddr(context.Background(), host)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			isIPv4 := addr.IP.To4() != nil
+			if network == "udp" || (network == "udp4") == isIPv4 {
+				return &net.UDPAddr{IP: addr.IP, Port: port, Zone: addr.Zone}, nil
+			}
+		}
+
+		return nil, fmt.Errorf("%w: %s %s", errNoAddressOfNetwork, network, host)
+	}
+}
+
+// lookupServerSRV returns the host:port of address, which is looked up with the SRV
+// records of service, e.g. _turn._udp.example.com, if address has no port. Servers without
+// SRV records are reached at the default port 3478, see RFC 8489 Section 8
+func lookupServerSRV(resolver Resolver, service, address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+
+	_, srvs, err := resolver.LookupSRV(context.Background(), service, "udp", address)
+	if err == nil && len(srvs) != 0 {
+		return net.JoinHostPort(srvs[0].Target, strconv.Itoa(int(srvs[0].Port)))
+	}
+
+	return net.JoinHostPort(address, strconv.Itoa(stun.DefaultPort))
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolver resolves the host names of hosts and the SRV records of srvs, keyed by
+// _service._proto.name
+type fakeResolver struct {
+	hosts map[string][]net.IPAddr
+	srvs  map[string][]*net.SRV
+}
+
+func (r *fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	key := name
+	if service != "" || proto != "" {
+		key = "_" + service + "._" + proto + "." + name
+	}
+	if srvs, ok := r.srvs[key]; ok {
+		return key, srvs, nil
+	}
+	return "", nil, &net.DNSError{Err: "no such host", Name: key, IsNotFound: true}
+}
+
+func TestClientResolver(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]net.IPAddr{
+			"turn.example.com":  {{IP: net.ParseIP("2001:db8::1")}, {IP: net.ParseIP("192.0.2.1")}},
+			"turn1.example.com": {{IP: net.ParseIP("192.0.2.2")}},
+			"stun.example.com":  {{IP: net.ParseIP("192.0.2.3")}},
+			"peer.example.com":  {{IP: net.ParseIP("192.0.2.4")}},
+		},
+		srvs: map[string][]*net.SRV{
+			"_turn._udp.example.com": {{Target: "turn1.example.com", Port: 3479}},
+		},
+	}
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+
+	newClient := func(stunAddr, turnAddr string) *Client {
+		client, clientErr := NewClient(&ClientConfig{
+			Conn:           conn,
+			STUNServerAddr: stunAddr,
+			TURNServerAddr: turnAddr,
+			Resolver:       resolver,
+		})
+		require.NoError(t, clientErr)
+		return client
+	}
+
+	// Host names are resolved to the address of the family of the client
+	client := newClient("stun.example.com:3478", "turn.example.com:3478")
+	assert.Equal(t, "192.0.2.3:3478", client.STUNServerAddr().String())
+	assert.Equal(t, "192.0.2.1:3478", client.TURNServerAddr().String())
+	resolved, err := client.resolveAddr("udp", "peer.example.com:1234")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.4:1234", resolved.String())
+	client.Close()
+
+	// Addresses without a port are looked up with SRV records, or use the default port
+	client = newClient("stun.example.com", "example.com")
+	assert.Equal(t, "192.0.2.3:3478", client.STUNServerAddr().String())
+	assert.Equal(t, "192.0.2.2:3479", client.TURNServerAddr().String())
+	client.Close()
+
+	_, err = NewClient(&ClientConfig{
+		Conn:           conn,
+		TURNServerAddr: "unknown.example.com:3478",
+		Resolver:       resolver,
+	})
+	assert.Error(t, err)
+}
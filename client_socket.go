@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/stdnet"
+)
+
+// listenClientConn creates the socket of a Client without ClientConfig.Conn, bound to
+// LocalAddr and Interface
+func listenClientConn(config *ClientConfig) (net.PacketConn, error) {
+	host, port := config.LocalAddr, "0"
+	if h, p, err := net.SplitHostPort(config.LocalAddr); err == nil {
+		host, port = h, p
+	}
+
+	if host == "" && config.Interface != "" {
+		ip, err := interfaceIP(config.Net, config.Interface)
+		if err != nil {
+			return nil, err
+		}
+		host = ip.String()
+	}
+
+	// The servers are resolved as udp4 unless a local IPv6 address is selected
+	network := "udp4"
+	if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		network = "udp6"
+	}
+	address := net.JoinHostPort(host, port)
+
+	if config.Interface == "" {
+		return config.Net.ListenPacket(network, address)
+	}
+
+	// SO_BINDTODEVICE also restricts the routes the socket uses to the interface. It needs
+	// the real network stack, other transport.Nets only bind to the address of the interface
+	if _, ok := config.Net.(*stdnet.Net); !ok {
+		return config.Net.ListenPacket(network, address)
+	}
+
+	listenConfig := net.ListenConfig{Control: bindToDeviceControl(config.Interface)}
+	return listenConfig.ListenPacket(context.Background(), network, address)
+}
+
+// interfaceIP returns the first IPv4 address of the named interface, or its first IPv6
+// address if it has none
+func interfaceIP(n transport.Net, name string) (net.IP, error) {
+	ips, err := interfaceIPs(n, name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			return ip, nil
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("%w: %s", errInterfaceNoAddress, name)
+	}
+
+	return ips[0], nil
+}
+
+// interfaceIPs returns the addresses of the named interface, except link-local ones
+func interfaceIPs(n transport.Net, name string) ([]net.IP, error) {
+	iface, err := n.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := []net.IP{}
+	for _, addr := range addrs {
+		var ip net.IP
+		switch addr := addr.(type) {
+		case *net.IPNet:
+			ip = addr.IP
+		case *net.IPAddr:
+			ip = addr.IP
+		}
+
+		if ip != nil && !ip.IsLinkLocalUnicast() {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips, nil
+}
@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"syscall"
+)
+
+// bindToDeviceControl binds sockets to the named interface with SO_BINDTODEVICE
+func bindToDeviceControl(device string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = syscall.BindToDevice(int(fd), device)
+		}); err != nil {
+			return err
+		}
+
+		return sockErr
+	}
+}
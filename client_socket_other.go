@@ -0,0 +1,17 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package turn
+
+import (
+	"syscall"
+)
+
+// bindToDeviceControl returns nil, SO_BINDTODEVICE is Linux only. Sockets are still bound
+// to the address of the interface
+func bindToDeviceControl(string) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
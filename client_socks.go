@@ -0,0 +1,290 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// SOCKS5 constants, RFC 1928 and RFC 1929
+const (
+	socksVersion         = 0x05
+	socksAuthNone        = 0x00
+	socksAuthPassword    = 0x02
+	socksAuthVersion     = 0x01
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+	socksAddrIPv4        = 0x01
+	socksAddrDomain      = 0x03
+	socksAddrIPv6        = 0x04
+	socksReplySucceeded  = 0x00
+	socksDefaultPort     = "1080"
+)
+
+// isSOCKSProxy returns true if proxyURL is a SOCKS5 proxy. With socks5h the proxy resolves
+// the host of the server, with socks5 the client does
+func isSOCKSProxy(proxyURL *url.URL) bool {
+	return proxyURL != nil && (proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h")
+}
+
+// socksHandshake connects to the SOCKS5 proxy at proxyURL and authenticates with the user
+// info of proxyURL if set
+func socksHandshake(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), socksDefaultPort)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, allocation.JoinErrors(err, conn.Close())
+		}
+	}
+
+	method := byte(socksAuthNone)
+	if proxyURL.User != nil {
+		method = socksAuthPassword
+	}
+	if _, err = conn.Write([]byte{socksVersion, 1, method}); err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+	if reply[0] != socksVersion || reply[1] != method {
+		return nil, allocation.JoinErrors(fmt.Errorf("%w: method %d", errSOCKSAuth, reply[1]), conn.Close())
+	}
+
+	if method == socksAuthPassword {
+		username := proxyURL.User.Username()
+		password, _ := proxyURL.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return nil, allocation.JoinErrors(errSOCKSAuth, conn.Close())
+		}
+
+		req := []byte{socksAuthVersion, byte(len(username))}
+		req = append(req, username...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err = conn.Write(req); err != nil {
+			return nil, allocation.JoinErrors(err, conn.Close())
+		}
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			return nil, allocation.JoinErrors(err, conn.Close())
+		}
+		if reply[1] != 0 {
+			return nil, allocation.JoinErrors(errSOCKSAuth, conn.Close())
+		}
+	}
+
+	return conn, nil
+}
+
+// socksRequest sends the request cmd for address on conn, and returns the address bound by
+// the proxy
+func socksRequest(conn net.Conn, cmd byte, address string) (*net.UDPAddr, error) {
+	req := []byte{socksVersion, cmd, 0}
+	req, err := appendSOCKSAddr(req, address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.Write(req); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 3)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	if header[0] != socksVersion || header[1] != socksReplySucceeded {
+		return nil, fmt.Errorf("%w: reply %d", errSOCKSRequest, header[1])
+	}
+
+	host, port, err := readSOCKSAddr(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.UDPAddr{IP: net.ParseIP(host), Port: port}, nil
+}
+
+// appendSOCKSAddr appends address, an IP or host name and a port, to b
+func appendSOCKSAddr(b []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("%w: %s", errInvalidPort, address)
+	}
+
+	switch ip := net.ParseIP(host); {
+	case ip.To4() != nil:
+		b = append(append(b, socksAddrIPv4), ip.To4()...)
+	case ip != nil:
+		b = append(append(b, socksAddrIPv6), ip.To16()...)
+	case len(host) <= 255:
+		b = append(append(b, socksAddrDomain, byte(len(host))), host...)
+	default:
+		return nil, fmt.Errorf("%w: %s", errSOCKSRequest, host)
+	}
+
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// readSOCKSAddr reads an address as encoded by appendSOCKSAddr from r
+func readSOCKSAddr(r io.Reader) (string, int, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", 0, err
+	}
+
+	var host []byte
+	switch atyp[0] {
+	case socksAddrIPv4:
+		host = make([]byte, net.IPv4len)
+	case socksAddrIPv6:
+		host = make([]byte, net.IPv6len)
+	case socksAddrDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", 0, err
+		}
+		host = make([]byte, length[0])
+	default:
+		return "", 0, fmt.Errorf("%w: address type %d", errSOCKSRequest, atyp[0])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, host); err != nil {
+		return "", 0, err
+	}
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", 0, err
+	}
+
+	if atyp[0] == socksAddrDomain {
+		return string(host), int(binary.BigEndian.Uint16(port)), nil
+	}
+	return net.IP(host).String(), int(binary.BigEndian.Uint16(port)), nil
+}
+
+// dialSOCKS connects to address through the SOCKS5 proxy at proxyURL with a CONNECT request
+func dialSOCKS(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, address string) (net.Conn, error) {
+	conn, err := socksHandshake(ctx, dialer, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = socksRequest(conn, socksCmdConnect, address); err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return nil, allocation.JoinErrors(err, conn.Close())
+	}
+
+	return conn, nil
+}
+
+// listenSOCKSUDP creates a UDP association on the SOCKS5 proxy at proxyURL, and returns a
+// net.PacketConn relaying datagrams through it
+func listenSOCKSUDP(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL) (net.PacketConn, error) {
+	control, err := socksHandshake(ctx, dialer, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, allocation.JoinErrors(err, control.Close())
+	}
+
+	// The proxy may restrict the association to the address the datagrams are sent from,
+	// of which only the port is known before sending
+	port := udpConn.LocalAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+	relay, err := socksRequest(control, socksCmdUDPAssociate, net.JoinHostPort("0.0.0.0", strconv.Itoa(port)))
+	if err == nil {
+		err = control.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		return nil, allocation.JoinErrors(err, udpConn.Close(), control.Close())
+	}
+
+	// Proxies answer with the unspecified address to relay on the address they were
+	// connected to
+	if relay.IP == nil || relay.IP.IsUnspecified() {
+		relay.IP = control.RemoteAddr().(*net.TCPAddr).IP //nolint:forcetypeassert
+	}
+
+	return &socksPacketConn{UDPConn: udpConn, control: control, relay: relay}, nil
+}
+
+// socksPacketConn sends and receives datagrams through the UDP association of a SOCKS5
+// proxy, which ends when control is closed
+type socksPacketConn struct {
+	*net.UDPConn
+	control net.Conn
+	relay   *net.UDPAddr
+
+	closeOnce sync.Once
+}
+
+func (c *socksPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	datagram, err := appendSOCKSAddr([]byte{0, 0, 0}, addr.String())
+	if err != nil {
+		return 0, err
+	}
+	if _, err = c.UDPConn.WriteTo(append(datagram, p...), c.relay); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// ReadFrom reads the next datagram relayed by the proxy into p, after its header was
+// removed. Datagrams larger than p minus the header are truncated
+func (c *socksPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, from, err := c.UDPConn.ReadFrom(p)
+		if err != nil {
+			return 0, nil, err
+		}
+		// Fragmented datagrams and datagrams from others than the proxy are dropped
+		if !isSameAddr(from, c.relay) || n < 4 || p[2] != 0 {
+			continue
+		}
+
+		reader := bytes.NewReader(p[3:n])
+		host, port, err := readSOCKSAddr(reader)
+		if err != nil {
+			continue
+		}
+
+		return copy(p, p[n-reader.Len():n]), &net.UDPAddr{IP: net.ParseIP(host), Port: port}, nil
+	}
+}
+
+func (c *socksPacketConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		err = allocation.JoinErrors(c.UDPConn.Close(), c.control.Close())
+	})
+
+	return err
+}
@@ -7,13 +7,18 @@
 package turn
 
 import (
+	"errors"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/pion/logging"
 	"github.com/pion/stun/v2"
 	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/metrics"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -116,6 +121,31 @@ func TestClientWithSTUN(t *testing.T) {
 		assert.Error(t, err, "should fail")
 	})
 
+	t.Run("TransactionIDGenerator", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, err)
+
+		c, err := NewClient(&ClientConfig{
+			Conn: conn,
+			TransactionIDGenerator: func() ([stun.TransactionIDSize]byte, error) {
+				return [stun.TransactionIDSize]byte{}, nil
+			},
+			LoggerFactory: loggerFactory,
+		})
+		assert.NoError(t, err)
+
+		to, err := net.ResolveUDPAddr("udp4", "127.0.0.1:9")
+		assert.NoError(t, err)
+
+		// Predictable IDs are rejected before anything is sent
+		_, err = c.SendBindingRequestTo(to)
+		assert.Error(t, err)
+		assert.Equal(t, 0, c.trMap.Size())
+
+		c.Close()
+		assert.NoError(t, conn.Close())
+	})
+
 	t.Run("SendBindingRequestTo timeout", func(t *testing.T) {
 		c, pc, ok := createListeningTestClient(t, loggerFactory)
 		if !ok {
@@ -186,6 +216,95 @@ func TestClientNonceExpiration(t *testing.T) {
 	assert.NoError(t, server.Close())
 }
 
+func TestClientUnpermittedData(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "0.0.0.0:3478")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "0.0.0.0",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	require.NoError(t, err)
+
+	type unpermittedData struct {
+		from net.Addr
+		data []byte
+	}
+	unpermittedCh := make(chan unpermittedData, 1)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: "127.0.0.1:3478",
+		TURNServerAddr: "127.0.0.1:3478",
+		Username:       "foo",
+		Password:       "pass",
+		OnUnpermittedData: func(from net.Addr, data []byte) {
+			unpermittedCh <- unpermittedData{from, append([]byte{}, data...)}
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	allocation, err := client.Allocate()
+	require.NoError(t, err)
+
+	permittedPeer := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 5000}
+	unpermittedPeer := &net.UDPAddr{IP: net.ParseIP("127.0.0.3"), Port: 5000}
+	require.NoError(t, client.CreatePermission(permittedPeer))
+
+	// Simulate a server that relays data from peers without a permission
+	dataIndication := func(from *net.UDPAddr, data string) []byte {
+		msg, err := stun.Build(
+			stun.TransactionID,
+			stun.NewType(stun.MethodData, stun.ClassIndication),
+			&proto.PeerAddress{IP: from.IP, Port: from.Port},
+			proto.Data(data),
+		)
+		require.NoError(t, err)
+		return msg.Raw
+	}
+
+	_, err = client.HandleInbound(dataIndication(unpermittedPeer, "unpermitted"), client.TURNServerAddr())
+	assert.NoError(t, err)
+	select {
+	case d := <-unpermittedCh:
+		assert.Equal(t, unpermittedPeer.String(), d.from.String())
+		assert.Equal(t, "unpermitted", string(d.data))
+	case <-time.After(time.Second):
+		assert.Fail(t, "OnUnpermittedData was not called")
+	}
+
+	_, err = client.HandleInbound(dataIndication(permittedPeer, "permitted"), client.TURNServerAddr())
+	assert.NoError(t, err)
+
+	buf := make([]byte, 64)
+	assert.NoError(t, allocation.SetReadDeadline(time.Now().Add(time.Second)))
+	n, from, err := allocation.ReadFrom(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "permitted", string(buf[:n]))
+	assert.Equal(t, permittedPeer.String(), from.String())
+	assert.Empty(t, unpermittedCh)
+
+	// Shutdown
+	assert.NoError(t, allocation.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
 // Create a TCP-based allocation and verify allocation can be created
 func TestTCPClient(t *testing.T) {
 	// Setup server
@@ -203,6 +322,7 @@ func TestTCPClient(t *testing.T) {
 					RelayAddress: net.ParseIP("127.0.0.1"),
 					Address:      "0.0.0.0",
 				},
+				TCPAllocations: true,
 			},
 		},
 		Realm: "pion.ly",
@@ -256,3 +376,1329 @@ func TestTCPClient(t *testing.T) {
 	require.NoError(t, conn.Close())
 	require.NoError(t, server.Close())
 }
+
+func TestClientMobility(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:    "pion.ly",
+		Mobility: true,
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       "foo",
+		Password:       "pass",
+		Mobility:       true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	relay := func(msg string) {
+		_, writeErr := relayConn.WriteTo([]byte(msg), peer.LocalAddr())
+		require.NoError(t, writeErr)
+
+		buf := make([]byte, 64)
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, msg, string(buf[:n]))
+
+		_, writeErr = peer.WriteTo([]byte(msg), relayConn.LocalAddr())
+		require.NoError(t, writeErr)
+
+		require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr = relayConn.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, msg, string(buf[:n]))
+	}
+	relay("before")
+
+	// Move to a new local address, the allocation and its permission are kept
+	newConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, client.Rehome(newConn))
+	assert.NoError(t, conn.Close())
+	assert.Equal(t, 1, server.AllocationCount())
+
+	relay("after")
+
+	// Every move presents the ticket of the previous Refresh response
+	lastConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, client.Rehome(lastConn))
+	assert.NoError(t, newConn.Close())
+	relay("again")
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, lastConn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientMobilityForbidden(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       "foo",
+		Password:       "pass",
+		Mobility:       true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	_, err = client.Allocate()
+	assert.True(t, HasErrorCode(err, CodeMobilityForbidden))
+
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientCredentialRenewal(t *testing.T) {
+	const sharedSecret = "HELLO_WORLD"
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: LongTermTURNRESTAuthHandler(sharedSecret, nil),
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:                  "pion.ly",
+		CredentialExpiryPolicy: &CredentialExpiryPolicy{},
+	})
+	require.NoError(t, err)
+
+	// Expires one second after the renewal margin, so renewal starts right away
+	username, password, err := GenerateLongTermTURNRESTCredentials(sharedSecret, "user", time.Minute+time.Second)
+	require.NoError(t, err)
+
+	renewed := make(chan string, 1)
+	failed := make(chan error, 1)
+	fail := false
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       username,
+		Password:       password,
+		CredentialProvider: func() (string, string, error) {
+			if fail {
+				return "", "", errTODO
+			}
+			u, p, genErr := GenerateLongTermTURNRESTCredentials(sharedSecret, "user", time.Hour)
+			renewed <- u
+			return u, p, genErr
+		},
+		OnCredentialRenewalFailed: func(err error) {
+			failed <- err
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	expiry, ok := client.CredentialExpiry()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), expiry, 2*time.Second)
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	select {
+	case newUsername := <-renewed:
+		assert.Eventually(t, func() bool {
+			return client.Username().String() == newUsername
+		}, time.Second, 10*time.Millisecond)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "credentials were not renewed")
+	}
+	assert.Empty(t, failed)
+
+	expiry, ok = client.CredentialExpiry()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiry, 2*time.Second)
+
+	// The allocation is refreshed with the renewed credentials
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, err = relayConn.WriteTo([]byte("renewed"), peer.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 64)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "renewed", string(buf[:n]))
+
+	// Renewal failures are reported
+	fail = true
+	client.renewCredentials()
+	assert.ErrorIs(t, <-failed, errTODO)
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
+
+// wrappedAddr is an address type other than *net.UDPAddr, like the addresses of conns
+// wrapped from Apple's Network framework
+type wrappedAddr string
+
+func (a wrappedAddr) Network() string { return "nw" }
+func (a wrappedAddr) String() string  { return string(a) }
+
+// wrappedConn is connected to the server and reports it with a wrappedAddr
+type wrappedConn struct {
+	net.PacketConn
+	server net.Addr
+}
+
+func (c *wrappedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, _, err := c.PacketConn.ReadFrom(p)
+	return n, wrappedAddr("turn.example:3478"), err
+}
+
+func (c *wrappedConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return c.PacketConn.WriteTo(p, c.server)
+}
+
+func (c *wrappedConn) LocalAddr() net.Addr {
+	return wrappedAddr(c.PacketConn.LocalAddr().String())
+}
+
+func TestClientWrappedConn(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	resolved := map[string]*net.UDPAddr{
+		"turn.example:3478": udpListener.LocalAddr().(*net.UDPAddr), //nolint:forcetypeassert
+		"peer.example:5000": peer.LocalAddr().(*net.UDPAddr),        //nolint:forcetypeassert
+	}
+	client, err := NewClient(&ClientConfig{
+		Conn:           &wrappedConn{PacketConn: conn, server: udpListener.LocalAddr()},
+		STUNServerAddr: "turn.example:3478",
+		TURNServerAddr: "turn.example:3478",
+		Username:       "foo",
+		Password:       "pass",
+		ResolveAddr: func(network, address string) (*net.UDPAddr, error) {
+			if addr, ok := resolved[address]; ok {
+				return addr, nil
+			}
+			return nil, errTODO
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// Peers can be passed with address types other than *net.UDPAddr too
+	_, err = relayConn.WriteTo([]byte("wrapped"), wrappedAddr("peer.example:5000"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 64)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "wrapped", string(buf[:n]))
+
+	_, err = relayConn.WriteTo([]byte("unresolved"), wrappedAddr("unknown.example:5000"))
+	assert.Error(t, err)
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientLocalAddr(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	loopback := ""
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+
+	for name, config := range map[string]ClientConfig{
+		"LocalAddr": {LocalAddr: "127.0.0.1"},
+		"Interface": {Interface: loopback},
+	} {
+		config := config
+		t.Run(name, func(t *testing.T) {
+			if config.Interface == "" && config.LocalAddr == "" {
+				t.Skip("no loopback interface")
+			}
+
+			config.STUNServerAddr = udpListener.LocalAddr().String()
+			config.TURNServerAddr = udpListener.LocalAddr().String()
+			config.Username = "foo"
+			config.Password = "pass"
+
+			client, err := NewClient(&config)
+			if err != nil && config.Interface != "" {
+				t.Skipf("can't bind to %s: %s", config.Interface, err)
+			}
+			require.NoError(t, err)
+			require.NoError(t, client.Listen())
+
+			reflexiveAddr, err := client.SendBindingRequest()
+			require.NoError(t, err)
+			udpAddr, ok := reflexiveAddr.(*net.UDPAddr)
+			require.True(t, ok)
+			assert.True(t, udpAddr.IP.Equal(net.ParseIP("127.0.0.1")))
+
+			relayConn, err := client.Allocate()
+			require.NoError(t, err)
+			assert.NoError(t, relayConn.Close())
+
+			// The socket the client created is closed with it
+			client.Close()
+			_, err = client.packetConn().WriteTo([]byte("closed"), udpListener.LocalAddr())
+			assert.Error(t, err)
+		})
+	}
+
+	// Without Conn, LocalAddr or Interface there is no socket
+	_, err = NewClient(&ClientConfig{})
+	assert.ErrorIs(t, err, errNilConn)
+
+	assert.NoError(t, server.Close())
+}
+
+// affinityConn records the AFFINITY-TOKEN of the requests the server reads
+type affinityConn struct {
+	net.PacketConn
+
+	lock   sync.Mutex
+	tokens map[stun.Method][]string
+}
+
+func (c *affinityConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil && stun.IsMessage(p[:n]) {
+		m := &stun.Message{Raw: append([]byte{}, p[:n]...)}
+		var token proto.AffinityToken
+		if m.Decode() == nil && m.Type.Class == stun.ClassRequest {
+			_ = token.GetFrom(m)
+			c.lock.Lock()
+			c.tokens[m.Type.Method] = append(c.tokens[m.Type.Method], string(token))
+			c.lock.Unlock()
+		}
+	}
+	return n, addr, err
+}
+
+func (c *affinityConn) requestTokens(method stun.Method) []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]string{}, c.tokens[method]...)
+}
+
+func TestClientAffinityToken(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+	sniffer := &affinityConn{PacketConn: udpListener, tokens: map[stun.Method][]string{}}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: sniffer,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:         "pion.ly",
+		AffinityToken: []byte("node-1"),
+	})
+	require.NoError(t, err)
+
+	allocate := func(token []byte) *Client {
+		conn, listenErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, listenErr)
+
+		client, clientErr := NewClient(&ClientConfig{
+			Conn:           conn,
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Username:       "foo",
+			Password:       "pass",
+			AffinityToken:  token,
+		})
+		require.NoError(t, clientErr)
+		require.NoError(t, client.Listen())
+
+		relayConn, allocateErr := client.Allocate()
+		require.NoError(t, allocateErr)
+
+		// CreatePermission and the Refresh deleting the allocation carry the token too
+		_, writeErr := relayConn.WriteTo([]byte("hello"), &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000})
+		require.NoError(t, writeErr)
+		assert.NoError(t, relayConn.Close())
+		client.Close()
+		assert.NoError(t, conn.Close())
+
+		return client
+	}
+
+	// The token is learned from the 401 (Unauthorized) response
+	client := allocate(nil)
+	assert.Equal(t, []byte("node-1"), client.AffinityToken())
+	assert.Equal(t, []string{"", "node-1"}, sniffer.requestTokens(stun.MethodAllocate))
+	assert.Equal(t, []string{"node-1"}, sniffer.requestTokens(stun.MethodCreatePermission))
+	assert.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"node-1"}, sniffer.requestTokens(stun.MethodRefresh))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A reconnecting client presents it with its first request
+	allocate(client.AffinityToken())
+	assert.Equal(t, []string{"", "node-1", "node-1", "node-1"}, sniffer.requestTokens(stun.MethodAllocate))
+
+	assert.NoError(t, server.Close())
+}
+
+func TestClientRelayIdentity(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs: []PacketConnConfig{{PacketConn: udpListener, RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"}}},
+		RelayIdentity:     RelayIdentity{Node: strings.Repeat("a", 256)},
+	})
+	assert.ErrorIs(t, err, errRelayIdentityInvalid)
+
+	identity := RelayIdentity{Region: "eu-west", PoP: "ams1", Node: "turn-3"}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:         "pion.ly",
+		RelayIdentity: identity,
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       "foo",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	// The identity is learned with the allocation
+	assert.True(t, client.RelayIdentity().IsZero())
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, identity, client.RelayIdentity())
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientChannelKeepalive(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: udpListener.LocalAddr().String(),
+		TURNServerAddr: udpListener.LocalAddr().String(),
+		Username:       "foo",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	assert.Error(t, client.SetChannelKeepalive(peer.LocalAddr(), time.Second))
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// The peer has no channel binding until the first write
+	assert.Error(t, client.SetChannelKeepalive(peer.LocalAddr(), time.Second))
+
+	_, err = relayConn.WriteTo([]byte("hello"), peer.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	n, _, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+
+	require.NoError(t, client.SetChannelKeepalive(peer.LocalAddr(), 50*time.Millisecond))
+
+	// The idle binding sends empty datagrams to the peer
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err = peer.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	assert.NoError(t, client.SetChannelKeepalive(peer.LocalAddr(), 0))
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientDisableChannelBindings(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	m := metrics.New()
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:   "pion.ly",
+		Metrics: m,
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:                   conn,
+		TURNServerAddr:         udpListener.LocalAddr().String(),
+		Username:               "foo",
+		Password:               "pass",
+		DisableChannelBindings: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Only the permission is created
+	require.NoError(t, client.PreauthorizePeers([]net.Addr{peer.LocalAddr()}, true))
+
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		_, err = relayConn.WriteTo([]byte("ping"), peer.LocalAddr())
+		require.NoError(t, err)
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, from, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, "ping", string(buf[:n]))
+
+		_, err = peer.WriteTo([]byte("pong"), from)
+		require.NoError(t, err)
+		require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr = relayConn.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, "pong", string(buf[:n]))
+	}
+
+	assert.Error(t, client.SetChannelKeepalive(peer.LocalAddr(), time.Second))
+
+	s := m.Snapshot()
+	assert.Equal(t, uint64(0), s.ChannelBinds)
+	assert.Equal(t, [2]uint64{3, 3}, s.RelayedPackets)
+	assert.Equal(t, [2]uint64{3, 3}, s.RelayedIndications)
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientChannelUpgrade(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	m := metrics.New()
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:   "pion.ly",
+		Metrics: m,
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:                  conn,
+		TURNServerAddr:        udpListener.LocalAddr().String(),
+		Username:              "foo",
+		Password:              "pass",
+		ChannelUpgradePackets: 3,
+		ChannelUpgradeWindow:  time.Minute,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ping := func() {
+		buf := make([]byte, 64)
+		_, err = relayConn.WriteTo([]byte("ping"), peer.LocalAddr())
+		require.NoError(t, err)
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, "ping", string(buf[:n]))
+	}
+
+	// The flow stays on indications below the threshold
+	ping()
+	ping()
+	assert.Equal(t, uint64(0), m.Snapshot().ChannelBinds)
+
+	// The third datagram upgrades the flow to a channel
+	ping()
+	assert.Eventually(t, func() bool {
+		return m.Snapshot().ChannelBinds == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	ping()
+	assert.Equal(t, uint64(1), m.Snapshot().ChannelBinds)
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientDeliverySwitchover(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// The first datagram only creates the permission, the second one binds the channel
+	client, err := NewClient(&ClientConfig{
+		Conn:                  conn,
+		TURNServerAddr:        udpListener.LocalAddr().String(),
+		Username:              "foo",
+		Password:              "pass",
+		ChannelUpgradePackets: 2,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+	assert.Nil(t, client.PeerStats())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = relayConn.WriteTo([]byte("hello"), peer.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, relayedAddr, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+
+	// The peer keeps sending while the channel is bound
+	const count = 300
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < count; i++ {
+			if _, writeErr := peer.WriteTo([]byte{byte(i >> 8), byte(i)}, relayedAddr); writeErr != nil {
+				return
+			}
+			if i == count/3 {
+				_, _ = relayConn.WriteTo([]byte("bind"), peer.LocalAddr())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	// Data is read in order and once, across the switch from Data indications to
+	// ChannelData
+	last := -1
+	require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	for last < count-1 {
+		n, _, readErr := relayConn.ReadFrom(buf)
+		require.NoError(t, readErr)
+		require.Equal(t, 2, n)
+		seq := int(buf[0])<<8 | int(buf[1])
+		require.Greater(t, seq, last)
+		last = seq
+	}
+	wg.Wait()
+
+	stats := client.PeerStats()
+	require.Len(t, stats, 1)
+	assert.Equal(t, peer.LocalAddr().String(), stats[0].Peer.String())
+	assert.Equal(t, PeerDeliveryChannel, stats[0].Delivery)
+	assert.NotZero(t, stats[0].ChannelNumber)
+	assert.GreaterOrEqual(t, stats[0].Indications, uint64(count/3))
+	assert.NotZero(t, stats[0].ChannelData)
+	assert.Equal(t, uint64(count), stats[0].Indications+stats[0].ChannelData+stats[0].Stale)
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientHealthCheck(t *testing.T) {
+	for name, refresh := range map[string]bool{"Binding": false, "Refresh": true} {
+		refresh := refresh
+		t.Run(name, func(t *testing.T) {
+			udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			require.NoError(t, err)
+
+			server, err := NewServer(ServerConfig{
+				AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+					return GenerateAuthKey(username, realm, "pass"), true
+				},
+				PacketConnConfigs: []PacketConnConfig{
+					{
+						PacketConn: udpListener,
+						RelayAddressGenerator: &RelayAddressGeneratorStatic{
+							RelayAddress: net.ParseIP("127.0.0.1"),
+							Address:      "127.0.0.1",
+						},
+					},
+				},
+				Realm: "pion.ly",
+			})
+			require.NoError(t, err)
+
+			conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			require.NoError(t, err)
+
+			health := make(chan AllocationHealth, 16)
+			client, err := NewClient(&ClientConfig{
+				Conn:                conn,
+				TURNServerAddr:      udpListener.LocalAddr().String(),
+				Username:            "foo",
+				Password:            "pass",
+				RTO:                 5 * time.Millisecond,
+				HealthCheckInterval: 20 * time.Millisecond,
+				HealthCheckRefresh:  refresh,
+				OnAllocationHealth: func(h AllocationHealth) {
+					select {
+					case health <- h:
+					default:
+					}
+				},
+			})
+			require.NoError(t, err)
+			require.NoError(t, client.Listen())
+
+			relayConn, err := client.Allocate()
+			require.NoError(t, err)
+
+			h := <-health
+			assert.True(t, h.Healthy)
+			assert.NoError(t, h.Err)
+			assert.Equal(t, 0, h.Failures)
+			assert.Greater(t, h.RTT, time.Duration(0))
+
+			// The relay path dies with the server
+			assert.NoError(t, server.Close())
+			for h.Healthy {
+				h = <-health
+			}
+			assert.Error(t, h.Err)
+			assert.Equal(t, 1, h.Failures)
+			h = <-health
+			assert.False(t, h.Healthy)
+			assert.Equal(t, 2, h.Failures)
+
+			assert.NoError(t, relayConn.Close())
+			client.Close()
+			assert.NoError(t, conn.Close())
+		})
+	}
+}
+
+var errNonceRotated = errors.New("nonce rotated")
+
+// rotatedNonces is a NonceGenerator whose next nonces are rejected as stale, as if the
+// server rotated its nonces in the meantime
+type rotatedNonces struct {
+	next  uint32 // Accessed atomically
+	stale int32  // Accessed atomically
+}
+
+func (r *rotatedNonces) Generate() (string, error) {
+	return "nonce-" + strings.Repeat("x", int(atomic.AddUint32(&r.next, 1)%8)), nil
+}
+
+func (r *rotatedNonces) Validate(string) error {
+	if atomic.AddInt32(&r.stale, -1) >= 0 {
+		return errNonceRotated
+	}
+	atomic.StoreInt32(&r.stale, 0)
+	return nil
+}
+
+func TestClientStaleNonce(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	m := metrics.New()
+	nonces := &rotatedNonces{}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:          "pion.ly",
+		NonceGenerator: nonces,
+		Metrics:        m,
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		TURNServerAddr: udpListener.LocalAddr().String(),
+		Username:       "foo",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	peerA := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	peerB := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 5000}
+
+	// Every request type is retried once with the new nonce
+	atomic.StoreInt32(&nonces.stale, 1)
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	atomic.StoreInt32(&nonces.stale, 1)
+	assert.NoError(t, client.CreatePermission(peerA))
+
+	atomic.StoreInt32(&nonces.stale, 1)
+	assert.NoError(t, client.PreauthorizePeers([]net.Addr{peerA}, true))
+
+	atomic.StoreInt32(&nonces.stale, 1)
+	assert.NoError(t, client.relayedUDPConn().Refresh())
+	assert.Equal(t, uint64(4), m.Snapshot().StaleNonces)
+
+	// A nonce that is stale again isn't retried a second time
+	atomic.StoreInt32(&nonces.stale, 2)
+	err = client.CreatePermission(peerB)
+	assert.True(t, IsStaleNonce(err), err)
+	assert.Equal(t, uint64(6), m.Snapshot().StaleNonces)
+	assert.NoError(t, client.CreatePermission(peerB))
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientLifetime(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var refreshes uint64
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: udpListener.LocalAddr().String(),
+		TURNServerAddr: udpListener.LocalAddr().String(),
+		Username:       "foo",
+		Password:       "pass",
+		Lifetime:       30 * time.Second,
+		RefreshInterval: func(granted time.Duration) time.Duration {
+			assert.Equal(t, 30*time.Second, granted)
+			atomic.AddUint64(&refreshes, 1)
+			return 50 * time.Millisecond
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	assert.Equal(t, 30*time.Second, client.RequestedLifetime())
+	assert.Equal(t, time.Duration(0), client.GrantedLifetime())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// The server grants the requested lifetime instead of its default of 10 minutes
+	assert.Equal(t, 30*time.Second, client.GrantedLifetime())
+
+	// The allocation is refreshed at the interval of the policy
+	assert.Eventually(t, func() bool {
+		return atomic.LoadUint64(&refreshes) >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 30*time.Second, client.GrantedLifetime())
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientPreauthorizePeers(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+	sniffer := &affinityConn{PacketConn: udpListener, tokens: map[stun.Method][]string{}}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: sniffer,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       "foo",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, client.PreauthorizePeers([]net.Addr{peer.LocalAddr()}, false), errNoUDPAllocation)
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// The candidates of two IPs are permitted with one request, and get a channel each
+	peers := []net.Addr{
+		peer.LocalAddr(),
+		&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000},
+		&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 5000},
+	}
+	require.NoError(t, client.PreauthorizePeers(peers, true))
+	assert.Len(t, sniffer.requestTokens(stun.MethodCreatePermission), 1)
+	assert.Len(t, sniffer.requestTokens(stun.MethodChannelBind), 3)
+
+	// Nothing is requested for peers that are already authorized
+	require.NoError(t, client.PreauthorizePeers(peers, true))
+	assert.Len(t, sniffer.requestTokens(stun.MethodCreatePermission), 1)
+	assert.Len(t, sniffer.requestTokens(stun.MethodChannelBind), 3)
+
+	// The peer reaches the client before the client sent it anything
+	_, err = peer.WriteTo([]byte("hello"), relayConn.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 64)
+	require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, from, err := relayConn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	assert.Equal(t, peer.LocalAddr().String(), from.String())
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestClientFailover(t *testing.T) {
+	newServer := func() (*Server, string) {
+		udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		server, err := NewServer(ServerConfig{
+			AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+				return GenerateAuthKey(username, realm, "pass"), true
+			},
+			PacketConnConfigs: []PacketConnConfig{
+				{
+					PacketConn: udpListener,
+					RelayAddressGenerator: &RelayAddressGeneratorStatic{
+						RelayAddress: net.ParseIP("127.0.0.1"),
+						Address:      "127.0.0.1",
+					},
+				},
+			},
+			Realm: "pion.ly",
+		})
+		require.NoError(t, err)
+
+		return server, udpListener.LocalAddr().String()
+	}
+	primary, primaryAddr := newServer()
+	backup, backupAddr := newServer()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	failovers := make(chan Failover, 1)
+	client, err := NewClient(&ClientConfig{
+		Conn:                  conn,
+		TURNServerAddr:        primaryAddr,
+		FailoverServerAddrs:   []string{backupAddr},
+		FailoverProbeInterval: 50 * time.Millisecond,
+		RTO:                   5 * time.Millisecond,
+		Username:              "foo",
+		Password:              "pass",
+		OnFailover: func(event Failover) {
+			failovers <- event
+		},
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	relay := func(msg string) {
+		_, writeErr := relayConn.WriteTo([]byte(msg), peer.LocalAddr())
+		require.NoError(t, writeErr)
+
+		buf := make([]byte, 64)
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, msg, string(buf[:n]))
+
+		_, writeErr = peer.WriteTo([]byte(msg), relayConn.LocalAddr())
+		require.NoError(t, writeErr)
+
+		require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr = relayConn.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, msg, string(buf[:n]))
+	}
+	relay("before")
+
+	// The probes of the closed primary time out, the allocation moves to the backup
+	// with its permission, so the peer reaches the new relayed address right away
+	assert.NoError(t, primary.Close())
+	var event Failover
+	select {
+	case event = <-failovers:
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "no failover")
+	}
+	assert.NoError(t, event.Err)
+	assert.Equal(t, primaryAddr, event.From.String())
+	assert.Equal(t, backupAddr, event.To.String())
+	assert.Equal(t, event.RelayedAddr, relayConn.LocalAddr())
+	assert.Equal(t, backupAddr, client.TURNServerAddr().String())
+	assert.Equal(t, 1, backup.AllocationCount())
+
+	relay("after")
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, backup.Close())
+}
+
+func TestClientAuthenticatedBinding(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+				AuthenticateBinding: true,
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	newClient := func(password string) (*Client, net.PacketConn) {
+		conn, listenErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, listenErr)
+
+		client, clientErr := NewClient(&ClientConfig{
+			Conn:           conn,
+			STUNServerAddr: serverAddr,
+			Username:       "foo",
+			Password:       password,
+		})
+		require.NoError(t, clientErr)
+		require.NoError(t, client.Listen())
+
+		return client, conn
+	}
+
+	// The client answers the challenge with its credentials
+	client, conn := newClient("pass")
+	mappedAddr, err := client.SendBindingRequest()
+	require.NoError(t, err)
+	assert.Equal(t, conn.LocalAddr().String(), mappedAddr.String())
+	client.Close()
+	assert.NoError(t, conn.Close())
+
+	// Binding requests with wrong credentials are rejected
+	client, conn = newClient("wrong")
+	_, err = client.SendBindingRequest()
+	var resErr *proto.ResponseError
+	require.ErrorAs(t, err, &resErr)
+	assert.Equal(t, stun.CodeBadRequest, resErr.Code)
+	client.Close()
+	assert.NoError(t, conn.Close())
+
+	assert.NoError(t, server.Close())
+}
@@ -0,0 +1,164 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// transportDialTimeout bounds the connect and the TLS or DTLS handshake with each server
+const transportDialTimeout = 10 * time.Second
+
+// clientTransportCandidates returns the servers the client connects to itself over TCP,
+// TLS or DTLS, nil if it relays over plain UDP: the servers of TURNServerURI over these
+// transports if it is a turns: URI, has transport=tcp, or TCP, TLSConfig or DTLSHandshaker
+// is set, otherwise TURNServerAddr if one of them is set. TCP and TLS require the client to
+// connect itself, DTLS also works on Conn
+func clientTransportCandidates(config *ClientConfig) ([]ServerCandidate, error) {
+	withStream, withDTLS := config.Conn == nil, config.DTLSHandshaker != nil
+	if !withStream && !withDTLS {
+		return nil, nil
+	}
+
+	if config.TURNServerURI != "" {
+		uri, err := stun.ParseURI(config.TURNServerURI)
+		if err != nil {
+			return nil, err
+		}
+		withTCP := config.TCP || (!uri.IsSecure() && uri.Proto == stun.ProtoTypeTCP)
+		if !uri.IsSecure() && !withTCP && config.TLSConfig == nil && config.DTLSHandshaker == nil {
+			return nil, nil
+		}
+
+		usable := func(candidate ServerCandidate) bool {
+			switch {
+			case candidate.Proto == stun.ProtoTypeTCP && candidate.Secure:
+				return withStream
+			case candidate.Proto == stun.ProtoTypeTCP:
+				return withStream && withTCP
+			default:
+				return withDTLS && candidate.Secure
+			}
+		}
+
+		candidates, err := ResolveServerURI(context.Background(), config.Resolver, config.TURNServerURI)
+		if err != nil {
+			return nil, err
+		}
+		transportCandidates := []ServerCandidate{}
+		for _, candidate := range candidates {
+			if usable(candidate) {
+				transportCandidates = append(transportCandidates, candidate)
+			}
+		}
+		if len(transportCandidates) == 0 {
+			return nil, fmt.Errorf("%w: %s", errNoUsableServer, config.TURNServerURI)
+		}
+
+		return transportCandidates, nil
+	}
+
+	if config.TURNServerAddr == "" {
+		return nil, nil
+	}
+
+	// The port defaults to the one of the transport
+	host, port, err := net.SplitHostPort(config.TURNServerAddr)
+	if err != nil {
+		host, port = config.TURNServerAddr, ""
+	}
+	candidate := func(proto stun.ProtoType, secure bool, defaultPort int) ServerCandidate {
+		candidatePort := port
+		if candidatePort == "" {
+			candidatePort = strconv.Itoa(defaultPort)
+		}
+		return ServerCandidate{Address: net.JoinHostPort(host, candidatePort), Proto: proto, Secure: secure, ServerName: host}
+	}
+
+	candidates := []ServerCandidate{}
+	if config.TLSConfig != nil && withStream {
+		candidates = append(candidates, candidate(stun.ProtoTypeTCP, true, stun.DefaultTLSPort))
+	}
+	if withDTLS {
+		candidates = append(candidates, candidate(stun.ProtoTypeUDP, true, stun.DefaultTLSPort))
+	}
+	if config.TCP && withStream {
+		candidates = append(candidates, candidate(stun.ProtoTypeTCP, false, stun.DefaultPort))
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	return candidates, nil
+}
+
+// dialClientTransport connects to the first of candidates that completes the TCP connect
+// and TLS or DTLS handshake, and returns the connection and the address of the server. TCP
+// and TLS connections frame STUN messages and ChannelData on the stream. DTLS runs on Conn,
+// or a socket bound like the one the client listens on otherwise
+func dialClientTransport(config *ClientConfig, candidates []ServerCandidate) (net.PacketConn, net.Addr, error) {
+	dialer := &Dialer{
+		TLSConfig:     config.TLSConfig,
+		Resolver:      config.Resolver,
+		LoggerFactory: config.LoggerFactory,
+		// The client connects directly, servers behind a proxy are reached with Dial
+		Proxy: func(*http.Request) (*url.URL, error) { return nil, nil },
+	}
+
+	var errs []error
+	for _, candidate := range candidates {
+		ctx, cancel := context.WithTimeout(context.Background(), transportDialTimeout)
+		var conn net.PacketConn
+		var addr net.Addr
+		var err error
+		if candidate.Proto == stun.ProtoTypeTCP {
+			conn, addr, err = dialer.dialTransport(ctx, candidate)
+		} else {
+			conn, addr, err = dialClientDTLS(ctx, config, candidate)
+		}
+		cancel()
+		if err == nil {
+			return conn, addr, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", candidate.Address, err))
+	}
+
+	return nil, nil, allocation.JoinErrors(errs...)
+}
+
+// dialClientDTLS connects to candidate with DTLS on Conn, or a socket it listens on
+func dialClientDTLS(ctx context.Context, config *ClientConfig, candidate ServerCandidate) (net.PacketConn, net.Addr, error) {
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addr, err := resolverAddrResolver(resolver)("udp", candidate.Address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, ownsConn := config.Conn, false
+	if conn == nil {
+		if conn, err = listenClientConn(config); err != nil {
+			return nil, nil, err
+		}
+		ownsConn = true
+	}
+
+	dtlsConn, err := dialDTLS(ctx, conn, ownsConn, addr, candidate.serverName(), config.DTLSHandshaker)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dtlsConn, addr, nil
+}
@@ -0,0 +1,241 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientTLS(t *testing.T) {
+	// The certificate of the server is only valid for turn.example.com
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"turn.example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener: tls.NewListener(tcpListener, &tls.Config{
+					MinVersion:   tls.VersionTLS12,
+					Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+				}),
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, server.Close())
+	}()
+
+	port := strconv.Itoa(tcpListener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert
+	resolver := &fakeResolver{
+		hosts: map[string][]net.IPAddr{
+			"turn.example.com":  {{IP: net.ParseIP("127.0.0.1")}},
+			"other.example.com": {{IP: net.ParseIP("127.0.0.1")}},
+		},
+	}
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, peer.Close())
+	}()
+
+	for name, config := range map[string]ClientConfig{
+		"URI": {TURNServerURI: "turns:turn.example.com:" + port + "?transport=tcp"},
+		"Addr": {
+			TURNServerAddr: "127.0.0.1:" + port,
+			TLSConfig:      &tls.Config{MinVersion: tls.VersionTLS12, ServerName: "turn.example.com"},
+		},
+	} {
+		config := config
+		t.Run(name, func(t *testing.T) {
+			if config.TLSConfig == nil {
+				config.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+			}
+			config.TLSConfig.RootCAs = roots
+			config.Resolver = resolver
+			config.Username, config.Password = "foo", "pass"
+
+			client, err := NewClient(&config)
+			require.NoError(t, err)
+			defer client.Close()
+			assert.Equal(t, "127.0.0.1:"+port, client.TURNServerAddr().String())
+			require.NoError(t, client.Listen())
+
+			relayConn, err := client.Allocate()
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, relayConn.Close())
+			}()
+
+			// Data of lengths that aren't a multiple of 4 is padded in ChannelData on the
+			// stream, the first message is sent in a Send indication until the channel is bound
+			buf := make([]byte, 64)
+			for _, data := range []string{"Hello", "Hello!", "Hi", "Hello, World"} {
+				_, err = relayConn.WriteTo([]byte(data), peer.LocalAddr())
+				require.NoError(t, err)
+
+				require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+				n, from, err := peer.ReadFrom(buf)
+				require.NoError(t, err)
+				assert.Equal(t, data, string(buf[:n]))
+
+				_, err = peer.WriteTo(buf[:n], from)
+				require.NoError(t, err)
+				require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+				n, from, err = relayConn.ReadFrom(buf)
+				require.NoError(t, err)
+				assert.Equal(t, data, string(buf[:n]))
+				assert.Equal(t, peer.LocalAddr().String(), from.String())
+			}
+		})
+	}
+
+	// The certificate is verified against the host of the URI
+	_, err = NewClient(&ClientConfig{
+		TURNServerURI: "turns:other.example.com:" + port + "?transport=tcp",
+		Resolver:      resolver,
+		TLSConfig:     &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: roots},
+	})
+	var hostnameErr x509.HostnameError
+	assert.ErrorAs(t, err, &hostnameErr)
+
+	_, err = NewClient(&ClientConfig{
+		TURNServerURI: "turn:turn.example.com:" + port + "?transport=udp",
+		Resolver:      resolver,
+		TLSConfig:     &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: roots},
+	})
+	assert.ErrorIs(t, err, errNoUsableServer)
+
+	_, err = NewClient(&ClientConfig{
+		TURNServerAddr:      "127.0.0.1:" + port,
+		FailoverServerAddrs: []string{"127.0.0.1:3478"},
+		TLSConfig:           &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: roots},
+	})
+	assert.ErrorIs(t, err, errFailoverUnsupported)
+}
+
+func TestClientTCP(t *testing.T) {
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener: tcpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, server.Close())
+	}()
+
+	port := strconv.Itoa(tcpListener.Addr().(*net.TCPAddr).Port) //nolint:forcetypeassert
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, peer.Close())
+	}()
+
+	for name, config := range map[string]ClientConfig{
+		"Addr": {TURNServerAddr: "127.0.0.1:" + port, TCP: true},
+		"URI": {
+			TURNServerURI: "turn:turn.example.com:" + port + "?transport=tcp",
+			Resolver: &fakeResolver{
+				hosts: map[string][]net.IPAddr{"turn.example.com": {{IP: net.ParseIP("127.0.0.1")}}},
+			},
+		},
+	} {
+		config := config
+		t.Run(name, func(t *testing.T) {
+			config.Username, config.Password = "foo", "pass"
+			client, err := NewClient(&config)
+			require.NoError(t, err)
+			defer client.Close()
+			require.NoError(t, client.Listen())
+
+			relayConn, err := client.Allocate()
+			require.NoError(t, err)
+			defer func() {
+				assert.NoError(t, relayConn.Close())
+			}()
+
+			// ChannelData of every padding length, sent back to back so the server and the
+			// client receive frames coalesced into single reads
+			buf := make([]byte, 1500)
+			sizes := []int{1, 2, 3, 4, 5, 1200}
+			for i := 0; i < 2; i++ {
+				for _, size := range sizes {
+					_, err = relayConn.WriteTo(make([]byte, size), peer.LocalAddr())
+					require.NoError(t, err)
+				}
+
+				var from net.Addr
+				for _, size := range sizes {
+					require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+					var n int
+					n, from, err = peer.ReadFrom(buf)
+					require.NoError(t, err)
+					assert.Equal(t, size, n)
+				}
+
+				for _, size := range sizes {
+					_, err = peer.WriteTo(make([]byte, size), from)
+					require.NoError(t, err)
+				}
+				for _, size := range sizes {
+					require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+					n, _, err := relayConn.ReadFrom(buf)
+					require.NoError(t, err)
+					assert.Equal(t, size, n)
+				}
+			}
+		})
+	}
+}
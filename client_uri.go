@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pion/stun/v2"
+)
+
+// NAPTR is a NAPTR record, RFC 3403
+type NAPTR struct {
+	Order       uint16
+	Preference  uint16
+	Flags       string
+	Service     string
+	Replacement string
+}
+
+// NAPTRResolver is a Resolver that also looks up NAPTR records, for the S-NAPTR resolution
+// of TURN URIs of RFC 5928. The Go resolver can't look up NAPTR records, URIs are resolved
+// with SRV records only without it
+type NAPTRResolver interface {
+	Resolver
+	LookupNAPTR(ctx context.Context, name string) ([]*NAPTR, error)
+}
+
+// ServerCandidate is a TURN server a URI resolved to
+type ServerCandidate struct {
+	// Address is the host:port of the server, the host still needs to be resolved to an IP
+	// address
+	Address string
+	// Proto is the transport to reach the server with
+	Proto stun.ProtoType
+	// Secure is set if the transport is secured with TLS, or DTLS for UDP
+	Secure bool
+	// ServerName is the host of the URI, the name the certificate of the server is
+	// verified against, RFC 7065 Section 3. Not the host of Address, which SRV and NAPTR
+	// records may point anywhere
+	ServerName string
+}
+
+// serverName returns ServerName, the host of Address if unset
+func (c ServerCandidate) serverName() string {
+	if c.ServerName != "" {
+		return c.ServerName
+	}
+
+	host, _, _ := net.SplitHostPort(c.Address)
+	return host
+}
+
+// naptrRelayServices are the S-NAPTR application protocols of TURN, RFC 5928 Section 4
+var naptrRelayServices = map[string]ServerCandidate{ //nolint:gochecknoglobals
+	"turn.udp":  {Proto: stun.ProtoTypeUDP},
+	"turn.tcp":  {Proto: stun.ProtoTypeTCP},
+	"turn.tls":  {Proto: stun.ProtoTypeTCP, Secure: true},
+	"turn.dtls": {Proto: stun.ProtoTypeUDP, Secure: true},
+}
+
+// ResolveServerURI resolves a turn: or turns: URI, RFC 7065, to the TURN servers it names in
+// order of preference, following RFC 5928. URIs with a port or an IP address name a single
+// server. Otherwise the transports of the host are looked up with NAPTR records if
+// resolver is a NAPTRResolver and the URI has no transport parameter, then the servers of
+// each transport with SRV records. Hosts without any record name a single server at the
+// default port. resolver defaults to net.DefaultResolver
+func ResolveServerURI(ctx context.Context, resolver Resolver, rawURI string) ([]ServerCandidate, error) {
+	uri, err := stun.ParseURI(rawURI)
+	if err != nil {
+		return nil, err
+	}
+	if uri.Scheme != stun.SchemeTypeTURN && uri.Scheme != stun.SchemeTypeTURNS {
+		return nil, fmt.Errorf("%w: %s", errNotTURNURI, rawURI)
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+
+	// ParseURI adds the default port to URIs without one
+	parsed, err := url.Parse(rawURI)
+	if err != nil {
+		return nil, err
+	}
+	_, _, portErr := net.SplitHostPort(parsed.Opaque)
+	hasTransport := parsed.Query().Get("transport") != ""
+
+	secure := uri.IsSecure()
+	defaultCandidate := ServerCandidate{
+		Address:    net.JoinHostPort(uri.Host, strconv.Itoa(uri.Port)),
+		Proto:      uri.Proto,
+		Secure:     secure,
+		ServerName: uri.Host,
+	}
+	if portErr == nil || net.ParseIP(uri.Host) != nil {
+		return []ServerCandidate{defaultCandidate}, nil
+	}
+
+	candidates := []ServerCandidate{}
+	if naptrResolver, ok := resolver.(NAPTRResolver); ok && !hasTransport {
+		candidates = lookupNAPTRCandidates(ctx, naptrResolver, uri.Host, secure)
+	}
+
+	if len(candidates) == 0 {
+		protos := []stun.ProtoType{stun.ProtoTypeUDP, stun.ProtoTypeTCP}
+		if hasTransport {
+			protos = []stun.ProtoType{uri.Proto}
+		} else if secure {
+			protos = []stun.ProtoType{stun.ProtoTypeTCP, stun.ProtoTypeUDP}
+		}
+
+		service := uri.Scheme.String()
+		for _, proto := range protos {
+			srvs := lookupSRV(ctx, resolver, service, proto.String(), uri.Host)
+			candidates = append(candidates, srvCandidates(srvs, ServerCandidate{Proto: proto, Secure: secure})...)
+		}
+	}
+
+	if len(candidates) == 0 {
+		candidates = append(candidates, defaultCandidate)
+	}
+	for i := range candidates {
+		candidates[i].ServerName = defaultCandidate.ServerName
+	}
+
+	return candidates, nil
+}
+
+// lookupNAPTRCandidates returns the servers of the RELAY NAPTR records of host with a
+// secure transport if secure is set, an insecure one otherwise
+func lookupNAPTRCandidates(ctx context.Context, resolver NAPTRResolver, host string, secure bool) []ServerCandidate {
+	records, err := resolver.LookupNAPTR(ctx, host)
+	if err != nil {
+		return nil
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Order != records[j].Order {
+			return records[i].Order < records[j].Order
+		}
+		return records[i].Preference < records[j].Preference
+	})
+
+	candidates := []ServerCandidate{}
+	for _, record := range records {
+		parts := strings.SplitN(strings.ToLower(record.Service), ":", 2)
+		if len(parts) != 2 || parts[0] != "relay" {
+			continue
+		}
+		transport, ok := naptrRelayServices[parts[1]]
+		if !ok || transport.Secure != secure {
+			continue
+		}
+
+		replacement := strings.TrimSuffix(record.Replacement, ".")
+		switch strings.ToLower(record.Flags) {
+		case "s":
+			srvs := lookupSRV(ctx, resolver, "", "", replacement)
+			candidates = append(candidates, srvCandidates(srvs, transport)...)
+		case "a":
+			port := stun.DefaultPort
+			if secure {
+				port = stun.DefaultTLSPort
+			}
+			transport.Address = net.JoinHostPort(replacement, strconv.Itoa(port))
+			candidates = append(candidates, transport)
+		}
+	}
+
+	return candidates
+}
+
+// lookupSRV returns the SRV records of _service._proto.name, or of name if service and
+// proto are empty, ordered by priority
+func lookupSRV(ctx context.Context, resolver Resolver, service, proto, name string) []*net.SRV {
+	_, srvs, err := resolver.LookupSRV(ctx, service, proto, name)
+	if err != nil {
+		return nil
+	}
+	sort.SliceStable(srvs, func(i, j int) bool {
+		return srvs[i].Priority < srvs[j].Priority
+	})
+
+	return srvs
+}
+
+// srvCandidates returns the servers of srvs with the transport of template
+func srvCandidates(srvs []*net.SRV, template ServerCandidate) []ServerCandidate {
+	candidates := make([]ServerCandidate, 0, len(srvs))
+	for _, srv := range srvs {
+		candidate := template
+		candidate.Address = net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
+
+// udpServerAddrs returns the addresses of the TURN servers over plain UDP uri resolves to
+func udpServerAddrs(resolver Resolver, uri string) ([]string, error) {
+	candidates, err := ResolveServerURI(context.Background(), resolver, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []string{}
+	for _, candidate := range candidates {
+		if candidate.Proto == stun.ProtoTypeUDP && !candidate.Secure {
+			addrs = append(addrs, candidate.Address)
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%w: %s", errNoUDPServer, uri)
+	}
+
+	return addrs, nil
+}
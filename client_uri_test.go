@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pion/stun/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNAPTRResolver struct {
+	*fakeResolver
+	naptrs map[string][]*NAPTR
+}
+
+func (r *fakeNAPTRResolver) LookupNAPTR(_ context.Context, name string) ([]*NAPTR, error) {
+	if records, ok := r.naptrs[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestResolveServerURI(t *testing.T) {
+	resolver := &fakeResolver{
+		hosts: map[string][]net.IPAddr{
+			"udp1.example.com": {{IP: net.ParseIP("192.0.2.1")}},
+			"udp2.example.com": {{IP: net.ParseIP("192.0.2.2")}},
+		},
+		srvs: map[string][]*net.SRV{
+			"_turn._udp.example.com": {
+				{Target: "udp2.example.com.", Port: 3479, Priority: 20},
+				{Target: "udp1.example.com.", Port: 3478, Priority: 10},
+			},
+			"_turn._tcp.example.com":  {{Target: "tcp.example.com.", Port: 3478}},
+			"_turns._tcp.example.com": {{Target: "tls.example.com.", Port: 5349}},
+			"_turn._udp.naptr.example.com": {
+				{Target: "naptr-udp.example.com.", Port: 3478},
+			},
+			"_turns._tcp.naptr.example.com": {
+				{Target: "naptr-tls.example.com.", Port: 443},
+			},
+		},
+	}
+	naptrResolver := &fakeNAPTRResolver{
+		fakeResolver: resolver,
+		naptrs: map[string][]*NAPTR{
+			"naptr.example.com": {
+				{Order: 10, Preference: 20, Flags: "s", Service: "RELAY:turn.udp", Replacement: "_turn._udp.naptr.example.com."},
+				{Order: 10, Preference: 10, Flags: "s", Service: "RELAY:turn.tls", Replacement: "_turns._tcp.naptr.example.com."},
+				{Order: 20, Preference: 10, Flags: "a", Service: "RELAY:turn.tcp", Replacement: "naptr-tcp.example.com."},
+				{Order: 20, Preference: 20, Flags: "s", Service: "SIP:sip.udp", Replacement: "_sip._udp.naptr.example.com."},
+			},
+		},
+	}
+
+	udp := func(address, serverName string) ServerCandidate {
+		return ServerCandidate{Address: address, Proto: stun.ProtoTypeUDP, ServerName: serverName}
+	}
+	tcp := func(address string, secure bool, serverName string) ServerCandidate {
+		return ServerCandidate{Address: address, Proto: stun.ProtoTypeTCP, Secure: secure, ServerName: serverName}
+	}
+
+	for _, test := range []struct {
+		name       string
+		resolver   Resolver
+		uri        string
+		candidates []ServerCandidate
+	}{
+		{"Port", resolver, "turn:example.com:3480", []ServerCandidate{udp("example.com:3480", "example.com")}},
+		{"IP", resolver, "turn:192.0.2.9?transport=tcp", []ServerCandidate{tcp("192.0.2.9:3478", false, "192.0.2.9")}},
+		{"SRV", resolver, "turn:example.com", []ServerCandidate{
+			udp("udp1.example.com:3478", "example.com"), udp("udp2.example.com:3479", "example.com"), tcp("tcp.example.com:3478", false, "example.com"),
+		}},
+		{"SRV transport", resolver, "turn:example.com?transport=tcp", []ServerCandidate{tcp("tcp.example.com:3478", false, "example.com")}},
+		{"SRV secure", resolver, "turns:example.com", []ServerCandidate{tcp("tls.example.com:5349", true, "example.com")}},
+		{"No records", resolver, "turns:other.example.com", []ServerCandidate{tcp("other.example.com:5349", true, "other.example.com")}},
+		{"NAPTR", naptrResolver, "turn:naptr.example.com", []ServerCandidate{
+			udp("naptr-udp.example.com:3478", "naptr.example.com"), tcp("naptr-tcp.example.com:3478", false, "naptr.example.com"),
+		}},
+		{"NAPTR secure", naptrResolver, "turns:naptr.example.com", []ServerCandidate{tcp("naptr-tls.example.com:443", true, "naptr.example.com")}},
+		{"NAPTR skipped with transport", naptrResolver, "turn:naptr.example.com?transport=udp", []ServerCandidate{
+			udp("naptr-udp.example.com:3478", "naptr.example.com"),
+		}},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			candidates, err := ResolveServerURI(context.Background(), test.resolver, test.uri)
+			require.NoError(t, err)
+			assert.Equal(t, test.candidates, candidates)
+		})
+	}
+
+	_, err := ResolveServerURI(context.Background(), resolver, "stun:example.com")
+	assert.ErrorIs(t, err, errNotTURNURI)
+
+	// The client uses the servers over UDP in order
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	client, err := NewClient(&ClientConfig{
+		Conn:          conn,
+		TURNServerURI: "turn:example.com",
+		Resolver:      resolver,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:3478", client.TURNServerAddr().String())
+	assert.Equal(t, []string{"192.0.2.1:3478", "192.0.2.2:3479"}, addrStrings(client.turnServerAddrs))
+	client.Close()
+
+	_, err = NewClient(&ClientConfig{
+		Conn:          conn,
+		TURNServerURI: "turns:example.com",
+		Resolver:      resolver,
+	})
+	assert.ErrorIs(t, err, errNoUDPServer)
+	assert.NoError(t, conn.Close())
+}
+
+func addrStrings(addrs []net.Addr) []string {
+	strs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+	return strs
+}
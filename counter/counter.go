@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package counter provides the counters behind ServerConfig.UserQuota and
+// ServerConfig.RateLimiter. By default every server counts on its own. Set
+// ServerConfig.Counters to a Store shared by all servers of a cluster, e.g. Redis, so the
+// limits hold across the cluster instead of per server.
+package counter
+
+import (
+	"sync"
+	"time"
+)
+
+// Store is a set of named counters and of named sets whose members expire on their own
+type Store interface {
+	// Add adds delta to the counter key and returns its new value. Counters that don't
+	// exist start at 0. If ttl isn't 0 the counter is removed ttl after the last Add.
+	Add(key string, delta int64, ttl time.Duration) (int64, error)
+	// AddMember adds member to the set key, or renews it, and returns the number of
+	// members. The member expires ttl after the last AddMember. Callers use the same ttl
+	// for all members of a set.
+	AddMember(key, member string, ttl time.Duration) (int64, error)
+	// RemoveMember removes member from the set key and returns the number of members left
+	RemoveMember(key, member string) (int64, error)
+	// Members returns the number of members of the set key
+	Members(key string) (int64, error)
+}
+
+const memorySweepInterval = time.Minute
+
+type memoryCounter struct {
+	value   int64
+	expires time.Time
+}
+
+// Memory is a Store of a single process
+type Memory struct {
+	lock      sync.Mutex
+	counters  map[string]*memoryCounter
+	sets      map[string]map[string]time.Time // Expiry per member
+	lastSweep time.Time
+}
+
+// NewMemory creates a Memory
+func NewMemory() *Memory {
+	return &Memory{
+		counters:  map[string]*memoryCounter{},
+		sets:      map[string]map[string]time.Time{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Add adds delta to the counter key and returns its new value
+func (m *Memory) Add(key string, delta int64, ttl time.Duration) (int64, error) {
+	return m.add(key, delta, ttl, time.Now()), nil
+}
+
+func (m *Memory) add(key string, delta int64, ttl time.Duration, now time.Time) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if now.Sub(m.lastSweep) >= memorySweepInterval {
+		m.sweep(now)
+	}
+
+	c, ok := m.counters[key]
+	if !ok || c.expired(now) {
+		c = &memoryCounter{}
+		m.counters[key] = c
+	}
+
+	c.value += delta
+	if ttl != 0 {
+		c.expires = now.Add(ttl)
+	}
+
+	return c.value
+}
+
+// AddMember adds member to the set key, or renews it, and returns the number of members
+func (m *Memory) AddMember(key, member string, ttl time.Duration) (int64, error) {
+	return m.addMember(key, member, ttl, time.Now()), nil
+}
+
+// RemoveMember removes member from the set key and returns the number of members left
+func (m *Memory) RemoveMember(key, member string) (int64, error) {
+	return m.removeMember(key, member, time.Now()), nil
+}
+
+// Members returns the number of members of the set key
+func (m *Memory) Members(key string) (int64, error) {
+	return m.members(key, time.Now()), nil
+}
+
+func (m *Memory) addMember(key, member string, ttl time.Duration, now time.Time) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if now.Sub(m.lastSweep) >= memorySweepInterval {
+		m.sweep(now)
+	}
+
+	set, ok := m.sets[key]
+	if !ok {
+		set = map[string]time.Time{}
+		m.sets[key] = set
+	}
+	set[member] = now.Add(ttl)
+
+	return m.count(key, now)
+}
+
+func (m *Memory) removeMember(key, member string, now time.Time) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.sets[key], member)
+	return m.count(key, now)
+}
+
+func (m *Memory) members(key string, now time.Time) int64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.count(key, now)
+}
+
+// count removes the expired members of the set key and returns the number left
+func (m *Memory) count(key string, now time.Time) int64 {
+	set := m.sets[key]
+	for member, expires := range set {
+		if !now.Before(expires) {
+			delete(set, member)
+		}
+	}
+	if len(set) == 0 {
+		delete(m.sets, key)
+	}
+
+	return int64(len(set))
+}
+
+// sweep removes the expired counters and set members
+func (m *Memory) sweep(now time.Time) {
+	for key, c := range m.counters {
+		if c.expired(now) {
+			delete(m.counters, key)
+		}
+	}
+	for key := range m.sets {
+		m.count(key, now)
+	}
+	m.lastSweep = now
+}
+
+func (c *memoryCounter) expired(now time.Time) bool {
+	return !c.expires.IsZero() && !now.Before(c.expires)
+}
@@ -0,0 +1,251 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package counter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory()
+	now := time.Now()
+
+	assert.Equal(t, int64(1), m.add("a", 1, 0, now))
+	assert.Equal(t, int64(3), m.add("a", 2, 0, now))
+	assert.Equal(t, int64(2), m.add("a", -1, 0, now))
+	assert.Equal(t, int64(-1), m.add("b", -1, 0, now))
+
+	t.Run("expiry", func(t *testing.T) {
+		assert.Equal(t, int64(1), m.add("c", 1, time.Second, now))
+		assert.Equal(t, int64(2), m.add("c", 1, time.Second, now.Add(900*time.Millisecond)))
+		// The ttl restarts with every Add
+		assert.Equal(t, int64(3), m.add("c", 1, time.Second, now.Add(1800*time.Millisecond)))
+		assert.Equal(t, int64(1), m.add("c", 1, time.Second, now.Add(3*time.Second)))
+	})
+
+	t.Run("members", func(t *testing.T) {
+		assert.Equal(t, int64(1), m.addMember("s", "a", time.Second, now))
+		assert.Equal(t, int64(2), m.addMember("s", "b", time.Second, now))
+		// Adding a member again renews it
+		assert.Equal(t, int64(2), m.addMember("s", "a", time.Second, now.Add(900*time.Millisecond)))
+		assert.Equal(t, int64(1), m.members("s", now.Add(1500*time.Millisecond)))
+		assert.Equal(t, int64(0), m.removeMember("s", "a", now.Add(1500*time.Millisecond)))
+		assert.NotContains(t, m.sets, "s")
+	})
+
+	t.Run("sweep", func(t *testing.T) {
+		m.add("d", 1, time.Second, now)
+		m.add("e", 1, time.Hour, now)
+		m.addMember("g", "a", time.Second, now)
+		m.add("f", 1, 0, now.Add(2*memorySweepInterval))
+		assert.NotContains(t, m.counters, "d")
+		assert.Contains(t, m.counters, "e")
+		assert.Contains(t, m.counters, "a")
+		assert.NotContains(t, m.sets, "g")
+	})
+}
+
+// fakeRedis answers AUTH, SELECT, INCRBY, PEXPIRE and the sorted set commands of Redis
+// like a Redis server
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	lock     sync.Mutex
+	counters map[string]int64
+	sets     map[string]map[string]int64
+	ttls     map[string]string
+	commands []string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	f := &fakeRedis{listener: listener, password: password, counters: map[string]int64{}, sets: map[string]map[string]int64{}, ttls: map[string]string{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close() //nolint:errcheck
+
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+
+		f.lock.Lock()
+		f.commands = append(f.commands, args[0])
+		var reply string
+		switch {
+		case args[0] == "AUTH":
+			authenticated = args[1] == f.password
+			reply = "+OK\r\n"
+			if !authenticated {
+				reply = "-WRONGPASS invalid password\r\n"
+			}
+		case !authenticated:
+			reply = "-NOAUTH Authentication required.\r\n"
+		case args[0] == "SELECT":
+			reply = "+OK\r\n"
+		case args[0] == "INCRBY":
+			delta, _ := strconv.ParseInt(args[2], 10, 64)
+			f.counters[args[1]] += delta
+			reply = fmt.Sprintf(":%d\r\n", f.counters[args[1]])
+		case args[0] == "PEXPIRE":
+			f.ttls[args[1]] = args[2]
+			reply = ":1\r\n"
+		case args[0] == "ZADD":
+			if f.sets[args[1]] == nil {
+				f.sets[args[1]] = map[string]int64{}
+			}
+			f.sets[args[1]][args[3]], _ = strconv.ParseInt(args[2], 10, 64)
+			reply = ":1\r\n"
+		case args[0] == "ZREM":
+			delete(f.sets[args[1]], args[2])
+			reply = ":1\r\n"
+		case args[0] == "ZREMRANGEBYSCORE":
+			maxScore, _ := strconv.ParseInt(args[3], 10, 64)
+			for member, score := range f.sets[args[1]] {
+				if score <= maxScore {
+					delete(f.sets[args[1]], member)
+				}
+			}
+			reply = ":1\r\n"
+		case args[0] == "ZCARD":
+			reply = fmt.Sprintf(":%d\r\n", len(f.sets[args[1]]))
+		case args[0] == "ZCOUNT":
+			minScore, _ := strconv.ParseInt(strings.TrimPrefix(args[2], "("), 10, 64)
+			count := 0
+			for _, score := range f.sets[args[1]] {
+				if score > minScore {
+					count++
+				}
+			}
+			reply = fmt.Sprintf(":%d\r\n", count)
+		default:
+			reply = "-ERR unknown command\r\n"
+		}
+		f.lock.Unlock()
+
+		if _, err = io.WriteString(conn, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		if _, err = reader.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+
+	return args, nil
+}
+
+func TestRedis(t *testing.T) {
+	f := newFakeRedis(t, "secret")
+	defer f.listener.Close() //nolint:errcheck
+
+	r := NewRedis(RedisConfig{Address: f.listener.Addr().String(), Password: "secret", DB: 2})
+
+	value, err := r.Add("a", 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), value)
+
+	value, err = r.Add("a", -1, 1500*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), value)
+
+	f.lock.Lock()
+	assert.Equal(t, []string{"AUTH", "SELECT", "INCRBY", "INCRBY", "PEXPIRE"}, f.commands)
+	assert.Equal(t, int64(1), f.counters["turn:a"])
+	assert.Equal(t, "1500", f.ttls["turn:a"])
+	f.lock.Unlock()
+
+	t.Run("members", func(t *testing.T) {
+		count, err := r.AddMember("s", "a", time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+		count, err = r.AddMember("s", "b", time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), count)
+
+		// Members of servers that stopped renewing them expire
+		f.lock.Lock()
+		f.sets["turn:s"]["b"] = time.Now().Add(-time.Second).UnixMilli()
+		assert.Equal(t, "3600000", f.ttls["turn:s"])
+		f.lock.Unlock()
+		count, err = r.Members("s")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+
+		count, err = r.RemoveMember("s", "a")
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), count)
+	})
+
+	assert.NoError(t, r.Close())
+	_, err = r.Add("a", 1, 0)
+	assert.ErrorIs(t, err, ErrClosed)
+
+	t.Run("error reply", func(t *testing.T) {
+		r := NewRedis(RedisConfig{Address: f.listener.Addr().String(), Password: "wrong"})
+		defer r.Close() //nolint:errcheck
+
+		_, err := r.Add("a", 1, 0)
+		assert.ErrorIs(t, err, ErrRedisReply)
+		assert.Nil(t, r.conn)
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		require.NoError(t, listener.Close())
+
+		r := NewRedis(RedisConfig{Address: listener.Addr().String()})
+		_, err = r.Add("a", 1, 0)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,15 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package counter
+
+import "errors"
+
+var (
+	// ErrRedisReply is returned by Redis.Add if the server answered with an error
+	ErrRedisReply = errors.New("counter: redis error reply")
+	// ErrRedisProtocol is returned by Redis.Add if the reply of the server can't be parsed
+	ErrRedisProtocol = errors.New("counter: invalid redis reply")
+	// ErrClosed is returned by Redis.Add after Close
+	ErrClosed = errors.New("counter: store closed")
+)
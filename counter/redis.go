@@ -0,0 +1,262 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package counter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisPrefix  = "turn:"
+	defaultRedisTimeout = time.Second
+)
+
+// RedisConfig configures a Redis
+type RedisConfig struct {
+	// Address is the host:port of the Redis server
+	Address string
+	// Password is sent with AUTH if not empty
+	Password string
+	// DB is the database selected with SELECT if not 0
+	DB int
+	// Prefix is prepended to all keys. Defaults to "turn:"
+	Prefix string
+	// Timeout is the timeout of connecting and of every Add. Defaults to 1 second
+	Timeout time.Duration
+	// Dial connects to the Redis server, e.g. with TLS. Defaults to net.Dialer
+	Dial func(network, address string, timeout time.Duration) (net.Conn, error)
+}
+
+// Redis is a Store in a Redis server, shared by all servers that use it. Counters are
+// changed with INCRBY and expire with PEXPIRE, sets are sorted sets. It keeps a single connection, which is
+// reconnected on the next Add after it failed.
+type Redis struct {
+	config RedisConfig
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	closed bool
+}
+
+// NewRedis creates a Redis. It connects on the first Add
+func NewRedis(config RedisConfig) *Redis {
+	if config.Prefix == "" {
+		config.Prefix = defaultRedisPrefix
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultRedisTimeout
+	}
+	if config.Dial == nil {
+		config.Dial = func(network, address string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout(network, address, timeout)
+		}
+	}
+
+	return &Redis{config: config}
+}
+
+// Add adds delta to the counter key and returns its new value
+func (r *Redis) Add(key string, delta int64, ttl time.Duration) (int64, error) {
+	key = r.config.Prefix + key
+	commands := [][]string{{"INCRBY", key, strconv.FormatInt(delta, 10)}}
+	if ttl != 0 {
+		commands = append(commands, []string{"PEXPIRE", key, strconv.FormatInt(ttl.Milliseconds(), 10)})
+	}
+
+	return r.run(0, commands...)
+}
+
+// AddMember adds member to the set key, or renews it, and returns the number of members.
+// Sets are sorted sets scored by the expiry of their members in Unix milliseconds of the
+// clock of the server that added them, the clocks of the servers should be synchronized
+func (r *Redis) AddMember(key, member string, ttl time.Duration) (int64, error) {
+	key, now := r.config.Prefix+key, time.Now()
+	return r.run(3,
+		[]string{"ZADD", key, strconv.FormatInt(now.Add(ttl).UnixMilli(), 10), member},
+		r.expireMembers(key, now),
+		[]string{"PEXPIRE", key, strconv.FormatInt(ttl.Milliseconds(), 10)},
+		[]string{"ZCARD", key})
+}
+
+// RemoveMember removes member from the set key and returns the number of members left
+func (r *Redis) RemoveMember(key, member string) (int64, error) {
+	key = r.config.Prefix + key
+	return r.run(2, []string{"ZREM", key, member}, r.expireMembers(key, time.Now()), []string{"ZCARD", key})
+}
+
+// Members returns the number of members of the set key
+func (r *Redis) Members(key string) (int64, error) {
+	return r.run(0, []string{"ZCOUNT", r.config.Prefix + key, "(" + strconv.FormatInt(time.Now().UnixMilli(), 10), "+inf"})
+}
+
+// expireMembers returns the command that removes the members of the set key that expired
+// before now
+func (r *Redis) expireMembers(key string, now time.Time) []string {
+	return []string{"ZREMRANGEBYSCORE", key, "-inf", strconv.FormatInt(now.UnixMilli(), 10)}
+}
+
+// run sends commands in a pipeline and returns the integer reply of the command at index
+// reply
+func (r *Redis) run(reply int, commands ...[]string) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return 0, ErrClosed
+	}
+
+	value, err := r.runLocked(reply, commands...)
+	if err != nil && r.conn != nil {
+		// The connection is in an unknown state, replies could be left unread
+		_ = r.conn.Close()
+		r.conn = nil
+	}
+
+	return value, err
+}
+
+func (r *Redis) runLocked(reply int, commands ...[]string) (int64, error) {
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := r.conn.SetDeadline(time.Now().Add(r.config.Timeout)); err != nil {
+		return 0, err
+	}
+
+	replies, err := r.do(commands...)
+	if err != nil {
+		return 0, err
+	}
+
+	value, ok := replies[reply].(int64)
+	if !ok {
+		return 0, fmt.Errorf("%w: %s returned %v", ErrRedisProtocol, commands[reply][0], replies[reply])
+	}
+
+	return value, nil
+}
+
+func (r *Redis) connect() error {
+	conn, err := r.config.Dial("tcp", r.config.Address, r.config.Timeout)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.reader = bufio.NewReader(conn)
+
+	if err = conn.SetDeadline(time.Now().Add(r.config.Timeout)); err != nil {
+		return err
+	}
+
+	var commands [][]string
+	if r.config.Password != "" {
+		commands = append(commands, []string{"AUTH", r.config.Password})
+	}
+	if r.config.DB != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(r.config.DB)})
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+
+	_, err = r.do(commands...)
+	return err
+}
+
+// do sends commands in a pipeline and reads their replies. The first error reply is
+// returned as error
+func (r *Redis) do(commands ...[]string) ([]interface{}, error) {
+	var b strings.Builder
+	for _, args := range commands {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := io.WriteString(r.conn, b.String()); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, 0, len(commands))
+	var replyErr error
+	for range commands {
+		reply, err := r.readReply()
+		if err != nil {
+			return nil, err
+		}
+		if err, ok := reply.(error); ok && replyErr == nil {
+			replyErr = err
+		}
+		replies = append(replies, reply)
+	}
+
+	return replies, replyErr
+}
+
+// readReply reads a simple string, error, integer or bulk string reply. Error replies are
+// returned as reply, not as error
+func (r *Redis) readReply() (interface{}, error) {
+	line, err := r.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("%w: %q", ErrRedisProtocol, line)
+	}
+
+	kind, value := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return value, nil
+	case '-':
+		return fmt.Errorf("%w: %s", ErrRedisReply, value), nil
+	case ':':
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrRedisProtocol, line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q", ErrRedisProtocol, line)
+		}
+		if n < 0 {
+			return nil, nil //nolint:nilnil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r.reader, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrRedisProtocol, line)
+	}
+}
+
+// Close closes the connection to the Redis server
+func (r *Redis) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+	if r.conn == nil {
+		return nil
+	}
+
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"net"
+	"time"
+)
+
+// DatagramConn wraps a message oriented net.Conn, e.g. a DTLS connection, and
+// implements net.PacketConn. Unlike STUNConn every Read is returned as one packet,
+// so ChannelData messages are not padded, see RFC 7350 Section 4.2
+type DatagramConn struct {
+	nextConn net.Conn
+}
+
+// ReadFrom implements ReadFrom from net.PacketConn
+func (d *DatagramConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	n, err = d.nextConn.Read(p)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return n, d.nextConn.RemoteAddr(), nil
+}
+
+// WriteTo implements WriteTo from net.PacketConn
+func (d *DatagramConn) WriteTo(p []byte, _ net.Addr) (n int, err error) {
+	return d.nextConn.Write(p)
+}
+
+// Close implements Close from net.PacketConn
+func (d *DatagramConn) Close() error {
+	return d.nextConn.Close()
+}
+
+// LocalAddr implements LocalAddr from net.PacketConn
+func (d *DatagramConn) LocalAddr() net.Addr {
+	return d.nextConn.LocalAddr()
+}
+
+// SetDeadline implements SetDeadline from net.PacketConn
+func (d *DatagramConn) SetDeadline(t time.Time) error {
+	return d.nextConn.SetDeadline(t)
+}
+
+// SetReadDeadline implements SetReadDeadline from net.PacketConn
+func (d *DatagramConn) SetReadDeadline(t time.Time) error {
+	return d.nextConn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline implements SetWriteDeadline from net.PacketConn
+func (d *DatagramConn) SetWriteDeadline(t time.Time) error {
+	return d.nextConn.SetWriteDeadline(t)
+}
+
+// NewDatagramConn creates a DatagramConn
+func NewDatagramConn(nextConn net.Conn) *DatagramConn {
+	return &DatagramConn{nextConn: nextConn}
+}
@@ -0,0 +1,281 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/transport/v3/deadline"
+	"github.com/pion/transport/v3/packetio"
+)
+
+const (
+	dtlsDemuxMaxPacketSize = 65535
+	dtlsDemuxAcceptBacklog = 128
+	dtlsDemuxReadBacklog   = 128
+	dtlsDemuxMaxBufferSize = 1024 * 1024
+)
+
+var errDTLSDemuxClosed = errors.New("turn: DTLS demux closed")
+
+// isDTLSRecord returns true if b starts with a DTLS record header. The first byte of
+// DTLS records is in the range 20-63, while STUN messages start with 0-3 and
+// ChannelData with 64-79, see RFC 7983 Section 7
+func isDTLSRecord(b []byte) bool {
+	return len(b) > 0 && b[0] >= 20 && b[0] <= 63
+}
+
+// DemuxDTLS splits a net.PacketConn between plain STUN/TURN traffic and DTLS records,
+// so a single UDP port can serve TURN over UDP and TURN over DTLS.
+//
+// Plain STUN and ChannelData packets are returned by the net.PacketConn, which can be used
+// in a PacketConnConfig. The net.Listener returns a connection for each remote address that
+// sent DTLS records. Wrap it with a DTLS server, e.g. dtls.NewListener from
+// github.com/pion/dtls, and use the result in a ListenerConfig with Datagram set.
+// conn is closed once both the net.PacketConn and the net.Listener are closed.
+func DemuxDTLS(conn net.PacketConn) (net.PacketConn, net.Listener) {
+	d := newDTLSDemux(conn)
+	go d.readLoop()
+
+	return &dtlsDemuxPacketConn{d}, &dtlsDemuxListener{d}
+}
+
+func newDTLSDemux(conn net.PacketConn) *dtlsDemux {
+	return &dtlsDemux{
+		conn:         conn,
+		packets:      make(chan dtlsDemuxPacket, dtlsDemuxReadBacklog),
+		acceptCh:     make(chan *dtlsDemuxConn, dtlsDemuxAcceptBacklog),
+		conns:        map[string]*dtlsDemuxConn{},
+		closed:       make(chan struct{}),
+		readDeadline: deadline.New(),
+	}
+}
+
+type dtlsDemuxPacket struct {
+	data []byte
+	addr net.Addr
+}
+
+type dtlsDemux struct {
+	conn     net.PacketConn
+	packets  chan dtlsDemuxPacket
+	acceptCh chan *dtlsDemuxConn
+
+	lock           sync.Mutex
+	conns          map[string]*dtlsDemuxConn
+	packetConnDone bool
+	listenerDone   bool
+	keepConn       bool // conn isn't closed with the demux
+
+	closed       chan struct{}
+	closeOnce    sync.Once
+	readDeadline *deadline.Deadline
+}
+
+func (d *dtlsDemux) readLoop() {
+	defer d.close()
+
+	buf := make([]byte, dtlsDemuxMaxPacketSize)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		data := append([]byte{}, buf[:n]...)
+
+		if !isDTLSRecord(data) {
+			select {
+			case d.packets <- dtlsDemuxPacket{data: data, addr: addr}:
+			default: // Drop, the PacketConn is not read fast enough
+			}
+			continue
+		}
+
+		if c := d.getConn(addr); c != nil {
+			_, _ = c.buffer.Write(data)
+		}
+	}
+}
+
+// getConn returns the connection for addr, creating it if the listener is still open
+func (d *dtlsDemux) getConn(addr net.Addr) *dtlsDemuxConn {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if c, ok := d.conns[addr.String()]; ok {
+		return c
+	} else if d.listenerDone {
+		return nil
+	}
+
+	c := d.newConn(addr)
+	select {
+	case d.acceptCh <- c:
+		d.conns[addr.String()] = c
+		return c
+	default: // Drop, too many connections are waiting to be accepted
+		return nil
+	}
+}
+
+func (d *dtlsDemux) newConn(addr net.Addr) *dtlsDemuxConn {
+	c := &dtlsDemuxConn{
+		demux:  d,
+		rAddr:  addr,
+		buffer: packetio.NewBuffer(),
+	}
+	c.buffer.SetLimitSize(dtlsDemuxMaxBufferSize)
+
+	return c
+}
+
+func (d *dtlsDemux) removeConn(addr net.Addr) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.conns, addr.String())
+}
+
+// closeHalf marks the PacketConn or the Listener as closed and closes the
+// underlying net.PacketConn once both are
+func (d *dtlsDemux) closeHalf(packetConn bool) error {
+	d.lock.Lock()
+	if packetConn {
+		d.packetConnDone = true
+	} else {
+		d.listenerDone = true
+	}
+	done := d.packetConnDone && d.listenerDone
+	d.lock.Unlock()
+
+	if !done {
+		return nil
+	}
+
+	d.close()
+	if d.keepConn {
+		return nil
+	}
+	return d.conn.Close()
+}
+
+func (d *dtlsDemux) close() {
+	d.closeOnce.Do(func() {
+		close(d.closed)
+
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		for _, c := range d.conns {
+			_ = c.buffer.Close()
+		}
+	})
+}
+
+// dtlsDemuxPacketConn returns the plain STUN and ChannelData packets
+type dtlsDemuxPacketConn struct {
+	*dtlsDemux
+}
+
+func (p *dtlsDemuxPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case packet := <-p.packets:
+		return copy(b, packet.data), packet.addr, nil
+	case <-p.readDeadline.Done():
+		return 0, nil, p.readDeadline.Err()
+	case <-p.closed:
+		return 0, nil, errDTLSDemuxClosed
+	}
+}
+
+func (p *dtlsDemuxPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return p.conn.WriteTo(b, addr)
+}
+
+func (p *dtlsDemuxPacketConn) Close() error {
+	return p.closeHalf(true)
+}
+
+func (p *dtlsDemuxPacketConn) LocalAddr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+func (p *dtlsDemuxPacketConn) SetDeadline(t time.Time) error {
+	p.readDeadline.Set(t)
+	return p.conn.SetWriteDeadline(t)
+}
+
+func (p *dtlsDemuxPacketConn) SetReadDeadline(t time.Time) error {
+	p.readDeadline.Set(t)
+	return nil
+}
+
+func (p *dtlsDemuxPacketConn) SetWriteDeadline(t time.Time) error {
+	return p.conn.SetWriteDeadline(t)
+}
+
+// dtlsDemuxListener accepts a connection for each remote address sending DTLS records
+type dtlsDemuxListener struct {
+	*dtlsDemux
+}
+
+func (l *dtlsDemuxListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.acceptCh:
+		return c, nil
+	case <-l.closed:
+		return nil, errDTLSDemuxClosed
+	}
+}
+
+func (l *dtlsDemuxListener) Close() error {
+	return l.closeHalf(false)
+}
+
+func (l *dtlsDemuxListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// dtlsDemuxConn carries the DTLS records of a single remote address
+type dtlsDemuxConn struct {
+	demux  *dtlsDemux
+	rAddr  net.Addr
+	buffer *packetio.Buffer
+}
+
+func (c *dtlsDemuxConn) Read(b []byte) (int, error) {
+	return c.buffer.Read(b)
+}
+
+func (c *dtlsDemuxConn) Write(b []byte) (int, error) {
+	return c.demux.conn.WriteTo(b, c.rAddr)
+}
+
+func (c *dtlsDemuxConn) Close() error {
+	c.demux.removeConn(c.rAddr)
+	return c.buffer.Close()
+}
+
+func (c *dtlsDemuxConn) LocalAddr() net.Addr {
+	return c.demux.conn.LocalAddr()
+}
+
+func (c *dtlsDemuxConn) RemoteAddr() net.Addr {
+	return c.rAddr
+}
+
+func (c *dtlsDemuxConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *dtlsDemuxConn) SetReadDeadline(t time.Time) error {
+	return c.buffer.SetReadDeadline(t)
+}
+
+func (c *dtlsDemuxConn) SetWriteDeadline(time.Time) error {
+	// Writes go straight to the shared net.PacketConn and don't block
+	return nil
+}
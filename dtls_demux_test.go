@@ -0,0 +1,177 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/v3/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDTLSContentType marks the "records" of the fake DTLS layer below. It is the
+// content type of DTLS application data records
+const fakeDTLSContentType = 23
+
+// fakeDTLSConn stands in for a DTLS connection, it prefixes every message with
+// fakeDTLSContentType instead of encrypting it. Other records, e.g. retransmissions of
+// the handshake, are skipped
+type fakeDTLSConn struct {
+	net.Conn
+}
+
+func (c *fakeDTLSConn) Read(b []byte) (int, error) {
+	buf := make([]byte, len(b)+1)
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 && buf[0] == fakeDTLSContentType {
+			return copy(b, buf[1:n]), nil
+		}
+	}
+}
+
+func (c *fakeDTLSConn) Write(b []byte) (int, error) {
+	if _, err := c.Conn.Write(append([]byte{fakeDTLSContentType}, b...)); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+type fakeDTLSListener struct {
+	net.Listener
+}
+
+func (l *fakeDTLSListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeDTLSConn{conn}, nil
+}
+
+// fakeDTLSPacketConn is the client side of fakeDTLSConn
+type fakeDTLSPacketConn struct {
+	net.PacketConn
+}
+
+func (c *fakeDTLSPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+1)
+	n, addr, err := c.PacketConn.ReadFrom(buf)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return copy(b, buf[1:n]), addr, nil
+}
+
+func (c *fakeDTLSPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if _, err := c.PacketConn.WriteTo(append([]byte{fakeDTLSContentType}, b...), addr); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func TestDemuxDTLS(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	packetConn, dtlsListener := DemuxDTLS(udpListener)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: packetConn,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener: &fakeDTLSListener{dtlsListener},
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+				Datagram: true,
+			},
+		},
+		Realm:         "pion.ly",
+		LoggerFactory: loggerFactory,
+	})
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	for _, dtls := range []bool{false, true} {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		clientConn := conn
+		if dtls {
+			clientConn = &fakeDTLSPacketConn{conn}
+		}
+
+		turnClient, err := NewClient(&ClientConfig{
+			Conn:           clientConn,
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Username:       "user",
+			Password:       "pass",
+			LoggerFactory:  loggerFactory,
+		})
+		require.NoError(t, err)
+		require.NoError(t, turnClient.Listen())
+
+		relayConn, err := turnClient.Allocate()
+		require.NoError(t, err)
+
+		// The second write is sent as ChannelData, which is not padded over DTLS
+		for _, msg := range []string{"hello", "world"} {
+			_, err = relayConn.WriteTo([]byte(msg), peer.LocalAddr())
+			require.NoError(t, err)
+
+			buf := make([]byte, 64)
+			require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, _, err := peer.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, msg, string(buf[:n]))
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		assert.NoError(t, relayConn.Close())
+		turnClient.Close()
+		assert.NoError(t, conn.Close())
+	}
+
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"errors"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// STUN and TURN error codes as carried in the ERROR-CODE attribute. These are
+// the codes sent by the Server and reported by the Client through ResponseError.
+const (
+	// RFC 5389
+	CodeTryAlternate     = stun.CodeTryAlternate     // 300 Try Alternate
+	CodeBadRequest       = stun.CodeBadRequest       // 400 Bad Request
+	CodeUnauthorized     = stun.CodeUnauthorized     // 401 Unauthorized
+	CodeUnknownAttribute = stun.CodeUnknownAttribute // 420 Unknown Attribute
+	CodeStaleNonce       = stun.CodeStaleNonce       // 438 Stale Nonce
+	CodeServerError      = stun.CodeServerError      // 500 Server Error
+
+	// RFC 5766
+	CodeForbidden                    = stun.CodeForbidden             // 403 Forbidden
+	CodeAllocationMismatch           = stun.CodeAllocMismatch         // 437 Allocation Mismatch
+	CodeWrongCredentials             = stun.CodeWrongCredentials      // 441 Wrong Credentials
+	CodeUnsupportedTransportProtocol = stun.CodeUnsupportedTransProto // 442 Unsupported Transport Protocol
+	CodeAllocationQuotaReached       = stun.CodeAllocQuotaReached     // 486 Allocation Quota Reached
+	CodeInsufficientCapacity         = stun.CodeInsufficientCapacity  // 508 Insufficient Capacity
+
+	// RFC 6062
+	CodeConnectionAlreadyExists    = stun.CodeConnAlreadyExists    // 446 Connection Already Exists
+	CodeConnectionTimeoutOrFailure = stun.CodeConnTimeoutOrFailure // 447 Connection Timeout or Failure
+
+	// RFC 6156
+	CodeAddressFamilyNotSupported = stun.CodeAddrFamilyNotSupported // 440 Address Family not Supported
+	CodePeerAddressFamilyMismatch = stun.CodePeerAddrFamilyMismatch // 443 Peer Address Family Mismatch
+
+	// RFC 8016
+	CodeMobilityForbidden = proto.CodeMobilityForbidden // 405 Mobility Forbidden
+)
+
+// ResponseError is returned by the Client when the TURN server answers a request
+// with an error response. Use errors.As or ErrorCode to inspect it.
+type ResponseError = proto.ResponseError
+
+// ErrorCode returns the STUN/TURN error code carried by err, if err is or wraps
+// a ResponseError with an ERROR-CODE attribute
+func ErrorCode(err error) (stun.ErrorCode, bool) {
+	var resErr *ResponseError
+	if !errors.As(err, &resErr) || resErr.Code == 0 {
+		return 0, false
+	}
+
+	return resErr.Code, true
+}
+
+// HasErrorCode reports whether err carries the STUN/TURN error code
+func HasErrorCode(err error, code stun.ErrorCode) bool {
+	c, ok := ErrorCode(err)
+	return ok && c == code
+}
+
+// IsTryAlternate reports whether err is a 300 (Try Alternate) error response
+func IsTryAlternate(err error) bool {
+	return HasErrorCode(err, CodeTryAlternate)
+}
+
+// IsUnauthorized reports whether err is a 401 (Unauthorized) error response
+func IsUnauthorized(err error) bool {
+	return HasErrorCode(err, CodeUnauthorized)
+}
+
+// IsForbidden reports whether err is a 403 (Forbidden) error response
+func IsForbidden(err error) bool {
+	return HasErrorCode(err, CodeForbidden)
+}
+
+// IsAllocationMismatch reports whether err is a 437 (Allocation Mismatch) error response
+func IsAllocationMismatch(err error) bool {
+	return HasErrorCode(err, CodeAllocationMismatch)
+}
+
+// IsStaleNonce reports whether err is a 438 (Stale Nonce) error response
+func IsStaleNonce(err error) bool {
+	return HasErrorCode(err, CodeStaleNonce)
+}
+
+// IsAllocationQuotaReached reports whether err is a 486 (Allocation Quota Reached) error response
+func IsAllocationQuotaReached(err error) bool {
+	return HasErrorCode(err, CodeAllocationQuotaReached)
+}
+
+// IsInsufficientCapacity reports whether err is a 508 (Insufficient Capacity) error response
+func IsInsufficientCapacity(err error) bool {
+	return HasErrorCode(err, CodeInsufficientCapacity)
+}
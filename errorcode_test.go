@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pion/stun/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCode(t *testing.T) {
+	errResponse := func(code stun.ErrorCode) error {
+		m, err := stun.Build(
+			stun.TransactionID,
+			stun.NewType(stun.MethodCreatePermission, stun.ClassErrorResponse),
+			&stun.ErrorCodeAttribute{Code: code},
+		)
+		assert.NoError(t, err)
+
+		return fmt.Errorf("%w: wrapped", &ResponseError{Type: m.Type, Code: code})
+	}
+
+	t.Run("Wrapped", func(t *testing.T) {
+		err := errResponse(CodeForbidden)
+
+		code, ok := ErrorCode(err)
+		assert.True(t, ok)
+		assert.Equal(t, CodeForbidden, code)
+		assert.True(t, IsForbidden(err))
+		assert.False(t, IsUnauthorized(err))
+	})
+
+	t.Run("Helpers", func(t *testing.T) {
+		for code, is := range map[stun.ErrorCode]func(error) bool{
+			CodeTryAlternate:           IsTryAlternate,
+			CodeUnauthorized:           IsUnauthorized,
+			CodeForbidden:              IsForbidden,
+			CodeAllocationMismatch:     IsAllocationMismatch,
+			CodeStaleNonce:             IsStaleNonce,
+			CodeAllocationQuotaReached: IsAllocationQuotaReached,
+			CodeInsufficientCapacity:   IsInsufficientCapacity,
+		} {
+			assert.True(t, is(errResponse(code)), "code %d", code)
+		}
+	})
+
+	t.Run("NoCode", func(t *testing.T) {
+		_, ok := ErrorCode(errors.New("plain")) //nolint:goerr113
+		assert.False(t, ok)
+
+		_, ok = ErrorCode(&ResponseError{})
+		assert.False(t, ok)
+		assert.False(t, IsForbidden(nil))
+	})
+}
@@ -3,29 +3,108 @@
 
 package turn
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// ErrAddressFamilyNotSupported is returned by a RelayAddressGenerator that can't allocate a
+// relay of the address family of the network it is called with, e.g. "udp6". The Server
+// answers the Allocate request with a 440 (Address Family not Supported) error.
+var ErrAddressFamilyNotSupported = allocation.ErrAddressFamilyNotSupported
+
+// ErrAllocationNotFound is returned by Server.DeleteAllocation if no allocation has the key
+var ErrAllocationNotFound = errors.New("turn: allocation not found")
+
+// ErrListenerNotFound is returned by Server.RemoveListener if no listener has the address
+var ErrListenerNotFound = errors.New("turn: listener not found")
 
 var (
-	errRelayAddressInvalid           = errors.New("turn: RelayAddress must be valid IP to use RelayAddressGeneratorStatic")
-	errNoAvailableConns              = errors.New("turn: PacketConnConfigs and ConnConfigs are empty, unable to proceed")
-	errConnUnset                     = errors.New("turn: PacketConnConfig must have a non-nil Conn")
-	errListenerUnset                 = errors.New("turn: ListenerConfig must have a non-nil Listener")
-	errListeningAddressInvalid       = errors.New("turn: RelayAddressGenerator has invalid ListeningAddress")
-	errRelayAddressGeneratorUnset    = errors.New("turn: RelayAddressGenerator in RelayConfig is unset")
-	errMaxRetriesExceeded            = errors.New("turn: max retries exceeded")
-	errMaxPortNotZero                = errors.New("turn: MaxPort must be not 0")
-	errMinPortNotZero                = errors.New("turn: MaxPort must be not 0")
-	errNilConn                       = errors.New("turn: conn cannot not be nil")
-	errTODO                          = errors.New("turn: TODO")
-	errAlreadyListening              = errors.New("turn: already listening")
-	errFailedToClose                 = errors.New("turn: Server failed to close")
-	errFailedToRetransmitTransaction = errors.New("turn: failed to retransmit transaction")
-	errAllRetransmissionsFailed      = errors.New("all retransmissions failed for")
-	errChannelBindNotFound           = errors.New("no binding found for channel")
-	errSTUNServerAddressNotSet       = errors.New("STUN server address is not set for the client")
-	errOneAllocateOnly               = errors.New("only one Allocate() caller is allowed")
-	errAlreadyAllocated              = errors.New("already allocated")
-	errNonSTUNMessage                = errors.New("non-STUN message from STUN server")
-	errFailedToDecodeSTUN            = errors.New("failed to decode STUN message")
-	errUnexpectedSTUNRequestMessage  = errors.New("unexpected STUN request message")
+	errRelayAddressInvalid                 = errors.New("turn: RelayAddress must be valid IP to use RelayAddressGeneratorStatic")
+	errNoAvailableConns                    = errors.New("turn: PacketConnConfigs and ConnConfigs are empty, unable to proceed")
+	errConnUnset                           = errors.New("turn: PacketConnConfig must have a non-nil Conn")
+	errListenerUnset                       = errors.New("turn: ListenerConfig must have a non-nil Listener")
+	errListeningAddressInvalid             = errors.New("turn: RelayAddressGenerator has invalid ListeningAddress")
+	errRelayAddressGeneratorUnset          = errors.New("turn: RelayAddressGenerator in RelayConfig is unset")
+	errTCPRelayAddressGeneratorUnsupported = errors.New("turn: TCPAllocations requires a RelayAddressGenerator that implements TCPRelayAddressGenerator")
+	errTCPAllocationsOverDatagram          = errors.New("turn: TCPAllocations can't be enabled for a Datagram listener")
+	errTLSKeyPairIncomplete                = errors.New("turn: ListenerConfig must set both CertFile and KeyFile")
+	errTLSOverDatagram                     = errors.New("turn: TLS can't be enabled for a Datagram listener")
+	errSessionResumptionWithoutTLS         = errors.New("turn: SessionResumption requires TLSConfig or CertFile")
+	errProxyProtocolOverDatagram           = errors.New("turn: ProxyProtocol can't be enabled for a Datagram listener")
+	errProxyHeaderInvalid                  = errors.New("turn: invalid PROXY protocol v2 header")
+	errMaxRetriesExceeded                  = errors.New("turn: max retries exceeded")
+	errInterfaceNoAddress                  = errors.New("turn: interface has no usable address")
+	errInterfaceUnset                      = errors.New("turn: RelayAddressGeneratorInterface must set Interface")
+	errRelayAddressesUnset                 = errors.New("turn: RelayAddressGeneratorMulti must set Addresses")
+	errRelayPortRangeInvalid               = errors.New("turn: invalid relay port range")
+	errNoSyscallConn                       = errors.New("turn: relay socket has no file descriptor")
+	errAddressMappingsUnset                = errors.New("turn: RelayAddressGeneratorNAT must set Mappings")
+	errAddressMappingInvalid               = errors.New("turn: AddressMapping must set PublicIP and PrivateIP")
+	errNoAddressMapping                    = errors.New("turn: no AddressMapping for listener")
+	errPortInUse                           = errors.New("turn: port in use")
+	errPortsExhausted                      = errors.New("turn: no free port")
+	errOnDemandConnClosed                  = errors.New("turn: relay socket closed")
+	errNAT64PrefixInvalid                  = errors.New("turn: NAT64Prefix must be an IPv6 /96 prefix")
+	errPermissionTimeoutInvalid            = errors.New("turn: PermissionTimeout must be between 1 second and 1 hour")
+	errPeerPortRangeInvalid                = errors.New("turn: invalid peer port range")
+	errNonceLifetimeInvalid                = errors.New("turn: NonceLifetime must be at least 1 second")
+	errRelayBufferSizeInvalid              = errors.New("turn: RelayBufferSize must not be negative")
+	errDSCPInvalid                         = errors.New("turn: DSCP must be between 0 and 63")
+	errEchoPeerInvalid                     = errors.New("turn: EchoPeer must have an IP and a port")
+	errRelayIdentityInvalid                = errors.New("turn: RelayIdentity fields must be at most 255 bytes")
+	errChallengeCacheTTLInvalid            = errors.New("turn: ChallengeCacheTTL must be less than half of NonceLifetime")
+	errRealmConfigInvalid                  = errors.New("turn: RealmConfig must set UsernameSuffix and Realm")
+	errCPUAffinityUnsupported              = errors.New("turn: CPUs is only supported on Linux")
+	errCPUInvalid                          = errors.New("turn: invalid CPU")
+	errReusePortUnsupported                = errors.New("turn: SO_REUSEPORT sharding is only supported on Linux")
+	errShardsInvalid                       = errors.New("turn: invalid number of shards")
+	errSocketBufferSizeInvalid             = errors.New("turn: socket buffer sizes must not be negative")
+	errSocketBuffersUnsupported            = errors.New("turn: socket buffer sizes of listeners are only supported on Linux")
+	errDualStackGeneratorUnset             = errors.New("turn: RelayAddressGeneratorDualStack must set IPv4 and IPv6")
+	errRelayDeviceUnset                    = errors.New("turn: RelayAddressGeneratorDevice must set Device")
+	errBindToDeviceUnsupported             = errors.New("turn: binding relay sockets to a device is only supported on Linux")
+	errEvenPortUnsupported                 = errors.New("turn: RelayAddressGenerator does not support even ports")
+	errMaxPortNotZero                      = errors.New("turn: MaxPort must be not 0")
+	errMinPortNotZero                      = errors.New("turn: MaxPort must be not 0")
+	errNilConn                             = errors.New("turn: conn cannot not be nil")
+	errTODO                                = errors.New("turn: TODO")
+	errAlreadyListening                    = errors.New("turn: already listening")
+	errFailedToClose                       = errors.New("turn: Server failed to close")
+	errServerClosed                        = errors.New("turn: Server is closed")
+	errFailedToRetransmitTransaction       = errors.New("turn: failed to retransmit transaction")
+	errAllRetransmissionsFailed            = errors.New("all retransmissions failed for")
+	errChannelBindNotFound                 = errors.New("no binding found for channel")
+	errSTUNServerAddressNotSet             = errors.New("STUN server address is not set for the client")
+	errOneAllocateOnly                     = errors.New("only one Allocate() caller is allowed")
+	errAlreadyAllocated                    = errors.New("already allocated")
+	errNonSTUNMessage                      = errors.New("non-STUN message from STUN server")
+	errFailedToDecodeSTUN                  = errors.New("failed to decode STUN message")
+	errUnexpectedSTUNRequestMessage        = errors.New("unexpected STUN request message")
+	errDuplicateTransactionID              = errors.New("transaction ID is already in use")
+	errNoAllocation                        = errors.New("no allocation to rehome")
+	errNoUDPAllocation                     = errors.New("turn: no UDP allocation")
+	errPingTimeout                         = errors.New("turn: ping timed out")
+	errPingerClosed                        = errors.New("turn: Pinger is closed")
+	errBondNoPaths                         = errors.New("turn: BondedConn needs at least one path")
+	errBondClosed                          = errors.New("turn: BondedConn is closed")
+	errCredentialExpired                   = errors.New("turn: credential expired")
+	errCredentialLifetimeTooLong           = errors.New("turn: credential lifetime exceeds MaxLifetime")
+	errAccessTokenInvalid                  = errors.New("turn: invalid access token")
+	errAccessTokenKeyInvalid               = errors.New("turn: access token key must be 16 or 32 bytes")
+	errInvalidPort                         = errors.New("turn: invalid port")
+	errNoAddressOfNetwork                  = errors.New("turn: no address of network")
+	errNotTURNURI                          = errors.New("turn: not a turn: or turns: URI")
+	errNoUDPServer                         = errors.New("turn: URI names no TURN server over UDP")
+	errConnectedConnDestination            = errors.New("turn: connected socket can't write to address")
+	errDTLSUnsupported                     = errors.New("turn: turns: URIs over UDP are not supported without a DTLSHandshaker")
+	errProxyConnect                        = errors.New("turn: proxy refused CONNECT")
+	errSendBufferRetryInvalid              = errors.New("turn: SendBufferRetry must not be negative")
+	errSOCKSAuth                           = errors.New("turn: SOCKS5 proxy authentication failed")
+	errSOCKSRequest                        = errors.New("turn: SOCKS5 proxy refused request")
+	errReplicationEventInvalid             = errors.New("turn: invalid replication event")
+	errMaxRelayedPacketSizeInvalid         = errors.New("turn: MaxRelayedPacketSize must not be negative")
+	errNoUsableServer                      = errors.New("turn: URI names no TURN server over a transport the client can use")
+	errFailoverUnsupported                 = errors.New("turn: FailoverServerAddrs are only supported over UDP")
 )
@@ -14,38 +14,29 @@ import (
 	"regexp"
 	"strconv"
 	"syscall"
+
 	"github.com/pion/logging"
-	"github.com/pion/stun/v2"
 	"github.com/pion/turn/v3"
+	"github.com/pion/turn/v3/inspect"
 )
 
-// stunLogger wraps a PacketConn and prints incoming/outgoing STUN packets
+// stunLogger wraps a PacketConn and prints incoming/outgoing STUN and ChannelData packets
 // This pattern could be used to capture/inspect/modify data as well
 type stunLogger struct {
 	net.PacketConn
 }
 
 func (s *stunLogger) WriteTo(p []byte, addr net.Addr) (n int, err error) {
-	if n, err = s.PacketConn.WriteTo(p, addr); err == nil && stun.IsMessage(p) {
-		msg := &stun.Message{Raw: p}
-		if err = msg.Decode(); err != nil {
-			return
-		}
-
-		fmt.Printf("Outbound STUN: %s \n", msg.String())
+	if n, err = s.PacketConn.WriteTo(p, addr); err == nil && inspect.Classify(p) != inspect.KindUnknown {
+		fmt.Printf("Outbound to %s: %s\n", addr, inspect.Format(p))
 	}
 
 	return
 }
 
 func (s *stunLogger) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
-	if n, addr, err = s.PacketConn.ReadFrom(p); err == nil && stun.IsMessage(p) {
-		msg := &stun.Message{Raw: p}
-		if err = msg.Decode(); err != nil {
-			return
-		}
-
-		fmt.Printf("Inbound STUN: %s \n", msg.String())
+	if n, addr, err = s.PacketConn.ReadFrom(p); err == nil && inspect.Classify(p[:n]) != inspect.KindUnknown {
+		fmt.Printf("Inbound from %s: %s\n", addr, inspect.Format(p[:n]))
 	}
 
 	return
@@ -58,9 +49,9 @@ func main() {
 	authSecret := flag.String("authSecret", "", "Shared secret for the Long Term Credential Mechanism")
 	realm := flag.String("realm", "pion.ly", "Realm (defaults to \"pion.ly\")")
 	listenIP := flag.String("listen-ip", "0.0.0.0", "IP Address of the interface that Pionturn will listen.")
-	port := flag.Int("port", 3478, "Listening port.")	
+	port := flag.Int("port", 3478, "Listening port.")
 	minPort := flag.Int("min_port", 50000, "Minimuim UDP Port")
-	maxPort := flag.Int("max_port", 55000, "Maximuim UDP Port")	
+	maxPort := flag.Int("max_port", 55000, "Maximuim UDP Port")
 	flag.Parse()
 
 	if net.ParseIP(*listenIP) == nil {
@@ -69,13 +60,13 @@ func main() {
 
 	if *minPort <= 0 || *maxPort <= 0 || *minPort > *maxPort {
 		log.Fatalf("UDP range: bad range")
-	}	
+	}
 
 	if len(*hostName) == 0 || len(*publicIP) == 0 {
 		log.Fatalf("'public-ip' or 'host-name' is required")
 	} else if len(*users) == 0 && len(*authSecret) == 0 {
 		log.Fatalf("'users' or 'authSecret' is required")
-	}	
+	}
 
 	// Create a UDP listener to pass into pion/turn
 	// pion/turn itself doesn't allocate any UDP sockets, but lets the user pass them in
@@ -87,34 +78,33 @@ func main() {
 
 	// Cache -users flag for easy lookup later
 	// If passwords are stored they should be saved to your DB hashed using turn.GenerateAuthKey
-	
+
 	usersMap := map[string][]byte{}
-	
+
 	for _, kv := range regexp.MustCompile(`(\w+)=(\w+)`).FindAllStringSubmatch(*users, -1) {
 		usersMap[kv[1]] = turn.GenerateAuthKey(kv[1], *realm, kv[2])
 	}
-	
-	
-	if len(*authSecret) > 0 {	
+
+	if len(*authSecret) > 0 {
 		logger := logging.NewDefaultLeveledLoggerForScope("lt-creds", logging.LogLevelTrace, os.Stdout)
-	
+
 		s, err := turn.NewServer(turn.ServerConfig{
-			Realm: *realm,		
+			Realm:       *realm,
 			AuthHandler: turn.LongTermTURNRESTAuthHandler(*authSecret, logger),
 			PacketConnConfigs: []turn.PacketConnConfig{
 				{
 					PacketConn: &stunLogger{udpListener},
 					RelayAddressGenerator: &turn.RelayAddressGeneratorPortRange{
-						HostName: 	  *hostName, 
-						PublicIP: 	  *publicIP,
-						Address:      *listenIP,              // But actually be listening on every interface
-						MinPort:      uint16(*minPort),
-						MaxPort:      uint16(*maxPort),
-					},					
+						HostName: *hostName,
+						PublicIP: *publicIP,
+						Address:  *listenIP, // But actually be listening on every interface
+						MinPort:  uint16(*minPort),
+						MaxPort:  uint16(*maxPort),
+					},
 				},
 			},
 		})
-		
+
 		if err != nil {
 			log.Panic(err)
 		}
@@ -126,12 +116,12 @@ func main() {
 
 		if err = s.Close(); err != nil {
 			log.Panic(err)
-		}	
-		
+		}
+
 	} else {
-		
+
 		s, err := turn.NewServer(turn.ServerConfig{
-			Realm: *realm,		
+			Realm: *realm,
 			AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
 				if key, ok := usersMap[username]; ok {
 					return key, true
@@ -142,16 +132,16 @@ func main() {
 				{
 					PacketConn: &stunLogger{udpListener},
 					RelayAddressGenerator: &turn.RelayAddressGeneratorPortRange{
-						HostName: 	  *hostName, 
-						PublicIP: 	  *publicIP,
-						Address:      *listenIP,              // But actually be listening on every interface
-						MinPort:      uint16(*minPort),
-						MaxPort:      uint16(*maxPort),
-					},					
+						HostName: *hostName,
+						PublicIP: *publicIP,
+						Address:  *listenIP, // But actually be listening on every interface
+						MinPort:  uint16(*minPort),
+						MaxPort:  uint16(*maxPort),
+					},
 				},
 			},
 		})
-		
+
 		if err != nil {
 			log.Panic(err)
 		}
@@ -163,6 +153,6 @@ func main() {
 
 		if err = s.Close(); err != nil {
 			log.Panic(err)
-		}		
+		}
 	}
 }
@@ -62,10 +62,11 @@ func main() {
 			{
 				PacketConn: udpListener,
 				RelayAddressGenerator: &turn.RelayAddressGeneratorPortRange{
-					RelayAddress: net.ParseIP(*publicIP), // Claim that we are listening on IP passed by user (This should be your Public IP)
-					Address:      "0.0.0.0",              // But actually be listening on every interface
-					MinPort:      50000,
-					MaxPort:      55000,
+					HostName: *publicIP, // Claim that we are listening on IP passed by user (This should be your Public IP)
+					PublicIP: *publicIP,
+					Address:  "0.0.0.0", // But actually be listening on every interface
+					MinPort:  50000,
+					MaxPort:  55000,
 				},
 			},
 		},
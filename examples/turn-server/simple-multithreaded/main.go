@@ -7,7 +7,6 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"log"
 	"net"
@@ -18,7 +17,6 @@ import (
 	"syscall"
 
 	"github.com/pion/turn/v3"
-	"golang.org/x/sys/unix"
 )
 
 func main() {
@@ -35,11 +33,6 @@ func main() {
 		log.Fatalf("'users' is required")
 	}
 
-	addr, err := net.ResolveUDPAddr("udp", "0.0.0.0:"+strconv.Itoa(*port))
-	if err != nil {
-		log.Fatalf("Failed to parse server address: %s", err)
-	}
-
 	// Cache -users flag for easy lookup later
 	// If passwords are stored they should be saved to your DB hashed using turn.GenerateAuthKey
 	usersMap := map[string][]byte{}
@@ -48,41 +41,21 @@ func main() {
 	}
 
 	// Create `numThreads` UDP listeners to pass into pion/turn
-	// pion/turn itself doesn't allocate any UDP sockets, but lets the user pass them in
-	// this allows us to add logging, storage or modify inbound/outbound traffic
 	// UDP listeners share the same local address:port with setting SO_REUSEPORT and the kernel
 	// will load-balance received packets per the IP 5-tuple
-	listenerConfig := &net.ListenConfig{
-		Control: func(network, address string, conn syscall.RawConn) error {
-			var operr error
-			if err = conn.Control(func(fd uintptr) {
-				operr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, unix.SO_REUSEPORT, 1)
-			}); err != nil {
-				return err
-			}
-
-			return operr
+	// Every listener is served by its own goroutine with its own allocations
+	packetConnConfigs, err := turn.ReusePortPacketConnConfigs("udp4", "0.0.0.0:"+strconv.Itoa(*port), *threadNum, turn.PacketConnConfig{
+		RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+			RelayAddress: net.ParseIP(*publicIP), // Claim that we are listening on IP passed by user
+			Address:      "0.0.0.0",              // But actually be listening on every interface
 		},
+	})
+	if err != nil {
+		log.Fatalf("Failed to allocate UDP listeners: %s", err)
 	}
 
-	relayAddressGenerator := &turn.RelayAddressGeneratorStatic{
-		RelayAddress: net.ParseIP(*publicIP), // Claim that we are listening on IP passed by user
-		Address:      "0.0.0.0",              // But actually be listening on every interface
-	}
-
-	packetConnConfigs := make([]turn.PacketConnConfig, *threadNum)
-	for i := 0; i < *threadNum; i++ {
-		conn, listErr := listenerConfig.ListenPacket(context.Background(), addr.Network(), addr.String())
-		if listErr != nil {
-			log.Fatalf("Failed to allocate UDP listener at %s:%s", addr.Network(), addr.String())
-		}
-
-		packetConnConfigs[i] = turn.PacketConnConfig{
-			PacketConn:            conn,
-			RelayAddressGenerator: relayAddressGenerator,
-		}
-
-		log.Printf("Server %d listening on %s\n", i, conn.LocalAddr().String())
+	for i, cfg := range packetConnConfigs {
+		log.Printf("Server %d listening on %s\n", i, cfg.PacketConn.LocalAddr().String())
 	}
 
 	s, err := turn.NewServer(turn.ServerConfig{
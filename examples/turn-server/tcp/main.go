@@ -64,6 +64,8 @@ func main() {
 					RelayAddress: net.ParseIP(*publicIP),
 					Address:      "0.0.0.0",
 				},
+				// Let clients relay TCP connections to peers (RFC 6062)
+				TCPAllocations: true,
 			},
 		},
 	})
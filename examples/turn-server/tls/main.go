@@ -33,19 +33,10 @@ func main() {
 		log.Fatalf("'users' is required")
 	}
 
-	cer, err := tls.LoadX509KeyPair(*certFile, *keyFile)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	// Create a TLS listener to pass into pion/turn
-	// pion/turn itself doesn't allocate any TLS listeners, but lets the user pass them in
-	// this allows us to add logging, storage or modify inbound/outbound traffic
-	tlsListener, err := tls.Listen("tcp4", "0.0.0.0:"+strconv.Itoa(*port), &tls.Config{
-		MinVersion:   tls.VersionTLS12,
-		Certificates: []tls.Certificate{cer},
-	})
+	// Create a TCP listener to pass into pion/turn
+	// pion/turn wraps it with TLS, and loads the certificate again when the files change so
+	// it can be rotated without a restart
+	tcpListener, err := net.Listen("tcp4", "0.0.0.0:"+strconv.Itoa(*port))
 	if err != nil {
 		log.Println(err)
 		return
@@ -72,11 +63,16 @@ func main() {
 		// ListenerConfig is a list of Listeners and the configuration around them
 		ListenerConfigs: []turn.ListenerConfig{
 			{
-				Listener: tlsListener,
+				Listener: tcpListener,
 				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
 					RelayAddress: net.ParseIP(*publicIP),
 					Address:      "0.0.0.0",
 				},
+				TLSConfig: &tls.Config{
+					MinVersion: tls.VersionTLS12,
+				},
+				CertFile: *certFile,
+				KeyFile:  *keyFile,
 			},
 		},
 	})
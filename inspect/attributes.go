@@ -0,0 +1,100 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package inspect
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// maxRawValueLength is the number of bytes of an attribute that are printed
+// when the attribute type is not known
+const maxRawValueLength = 32
+
+// formatAttribute decodes a single attribute of m. The getters of stun and proto
+// only look at the first attribute of a type, so a is decoded from a message
+// that contains nothing else. XOR addresses also need the transaction ID.
+func formatAttribute(m *stun.Message, a stun.RawAttribute) string {
+	single := &stun.Message{
+		TransactionID: m.TransactionID,
+		Attributes:    stun.Attributes{a},
+	}
+
+	value, err := decodeAttribute(single, a)
+	if err != nil {
+		return fmt.Sprintf("invalid (%v)", err)
+	}
+
+	return value
+}
+
+//nolint:cyclop
+func decodeAttribute(m *stun.Message, a stun.RawAttribute) (string, error) {
+	switch a.Type {
+	case stun.AttrXORPeerAddress:
+		var addr proto.PeerAddress
+		err := addr.GetFrom(m)
+		return addr.String(), err
+	case stun.AttrXORRelayedAddress:
+		var addr proto.RelayedAddress
+		err := addr.GetFrom(m)
+		return addr.String(), err
+	case stun.AttrXORMappedAddress:
+		var addr stun.XORMappedAddress
+		err := addr.GetFrom(m)
+		return addr.String(), err
+	case stun.AttrMappedAddress:
+		var addr stun.MappedAddress
+		err := addr.GetFrom(m)
+		return addr.String(), err
+	case stun.AttrAlternateServer:
+		var addr stun.MappedAddress
+		err := addr.GetFromAs(m, stun.AttrAlternateServer)
+		return addr.String(), err
+	case stun.AttrLifetime:
+		var l proto.Lifetime
+		err := l.GetFrom(m)
+		return l.Duration.String(), err
+	case stun.AttrChannelNumber:
+		var n proto.ChannelNumber
+		err := n.GetFrom(m)
+		return fmt.Sprintf("0x%04x", uint16(n)), err
+	case stun.AttrRequestedTransport:
+		var t proto.RequestedTransport
+		err := t.GetFrom(m)
+		return t.Protocol.String(), err
+	case stun.AttrRequestedAddressFamily:
+		var f proto.RequestedAddressFamily
+		err := f.GetFrom(m)
+		return f.String(), err
+	case stun.AttrEvenPort:
+		var p proto.EvenPort
+		err := p.GetFrom(m)
+		return p.String(), err
+	case stun.AttrConnectionID:
+		var c proto.ConnectionID
+		err := c.GetFrom(m)
+		return strconv.FormatUint(uint64(c), 10), err
+	case stun.AttrErrorCode:
+		var c stun.ErrorCodeAttribute
+		err := c.GetFrom(m)
+		return c.String(), err
+	case stun.AttrData:
+		return fmt.Sprintf("%d bytes", len(a.Value)), nil
+	case stun.AttrDontFragment:
+		return "set", nil
+	case stun.AttrUsername, stun.AttrRealm, stun.AttrNonce, stun.AttrSoftware:
+		return strconv.Quote(string(a.Value)), nil
+	case stun.AttrReservationToken, stun.AttrMessageIntegrity, stun.AttrFingerprint:
+		return fmt.Sprintf("%x", a.Value), nil
+	default:
+		if len(a.Value) > maxRawValueLength {
+			return fmt.Sprintf("%x... (%d bytes)", a.Value[:maxRawValueLength], len(a.Value)), nil
+		}
+		return fmt.Sprintf("%x", a.Value), nil
+	}
+}
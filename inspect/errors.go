@@ -0,0 +1,12 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package inspect
+
+import "errors"
+
+var (
+	// ErrUnknownDatagram is returned by Decode for datagrams that are neither
+	// STUN messages nor ChannelData messages.
+	ErrUnknownDatagram = errors.New("inspect: datagram is neither STUN nor ChannelData")
+)
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package inspect classifies and pretty-prints datagrams exchanged between TURN
+// clients and servers. It is intended for logging and debugging tools and is not
+// used on the relaying path.
+package inspect
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// Kind is the kind of a datagram
+type Kind int
+
+const (
+	// KindUnknown is a datagram that is neither STUN nor ChannelData
+	KindUnknown Kind = iota
+	// KindSTUN is a STUN message, including all TURN methods
+	KindSTUN
+	// KindChannelData is a TURN ChannelData message, see RFC 5766 Section 11.4
+	KindChannelData
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindSTUN:
+		return "STUN"
+	case KindChannelData:
+		return "ChannelData"
+	default:
+		return "Unknown"
+	}
+}
+
+// Classify returns the Kind of the datagram b without fully decoding it
+func Classify(b []byte) Kind {
+	switch {
+	case proto.IsChannelData(b):
+		return KindChannelData
+	case stun.IsMessage(b):
+		return KindSTUN
+	default:
+		return KindUnknown
+	}
+}
+
+// Attribute is a decoded STUN attribute
+type Attribute struct {
+	Type   stun.AttrType
+	Length int
+	// Value is a human readable representation of the attribute value
+	Value string
+}
+
+func (a Attribute) String() string {
+	return fmt.Sprintf("%s: %s", a.Type, a.Value)
+}
+
+// Packet is a decoded datagram
+type Packet struct {
+	Kind Kind
+	Raw  []byte
+
+	// Set for KindSTUN
+	Message    *stun.Message
+	Attributes []Attribute
+
+	// Set for KindChannelData
+	ChannelNumber uint16
+	Length        int    // Value of the length field
+	Payload       []byte // Sub slice of Raw
+}
+
+// Decode classifies and decodes the datagram b. The returned Packet references
+// b, so b must not be modified while the Packet is in use.
+func Decode(b []byte) (*Packet, error) {
+	p := &Packet{Kind: Classify(b), Raw: b}
+
+	switch p.Kind {
+	case KindChannelData:
+		c := &proto.ChannelData{Raw: b}
+		if err := c.Decode(); err != nil {
+			return nil, err
+		}
+		p.ChannelNumber = uint16(c.Number)
+		p.Length = c.Length
+		p.Payload = c.Data
+	case KindSTUN:
+		m := &stun.Message{Raw: b}
+		if err := m.Decode(); err != nil {
+			return nil, err
+		}
+		p.Message = m
+		p.Length = int(m.Length)
+		p.Attributes = make([]Attribute, 0, len(m.Attributes))
+		for _, a := range m.Attributes {
+			p.Attributes = append(p.Attributes, Attribute{
+				Type:   a.Type,
+				Length: int(a.Length),
+				Value:  formatAttribute(m, a),
+			})
+		}
+	default:
+		return nil, ErrUnknownDatagram
+	}
+
+	return p, nil
+}
+
+// String returns a multi-line description of the packet. The first line is a
+// summary, STUN attributes follow on indented lines.
+func (p *Packet) String() string {
+	switch p.Kind {
+	case KindChannelData:
+		return fmt.Sprintf("ChannelData channel=0x%04x length=%d", p.ChannelNumber, p.Length)
+	case KindSTUN:
+		var b strings.Builder
+		fmt.Fprintf(&b, "STUN %s length=%d id=%x", p.Message.Type, p.Length, p.Message.TransactionID)
+		for _, a := range p.Attributes {
+			fmt.Fprintf(&b, "\n  %s", a)
+		}
+		return b.String()
+	default:
+		return fmt.Sprintf("Unknown length=%d", len(p.Raw))
+	}
+}
+
+// Format returns the description of the datagram b. Unlike Decode it never
+// fails, datagrams that can't be decoded are described along with the error.
+func Format(b []byte) string {
+	p, err := Decode(b)
+	if err != nil {
+		return fmt.Sprintf("%s length=%d (%v)", Classify(b), len(b), err)
+	}
+
+	return p.String()
+}
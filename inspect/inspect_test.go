@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package inspect
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	c := &proto.ChannelData{Number: proto.MinChannelNumber, Data: []byte("hello")}
+	c.Encode()
+
+	assert.Equal(t, KindChannelData, Classify(c.Raw))
+	assert.Equal(t, KindSTUN, Classify(stun.MustBuild(stun.TransactionID, stun.BindingRequest).Raw))
+	assert.Equal(t, KindUnknown, Classify([]byte{0xff, 0x00}))
+	assert.Equal(t, KindUnknown, Classify(nil))
+}
+
+func TestDecode(t *testing.T) {
+	t.Run("STUN", func(t *testing.T) {
+		m := stun.MustBuild(stun.TransactionID,
+			stun.NewType(stun.MethodCreatePermission, stun.ClassRequest),
+			&proto.PeerAddress{IP: net.IPv4(10, 0, 0, 1), Port: 1000},
+			&proto.PeerAddress{IP: net.IPv4(10, 0, 0, 2), Port: 2000},
+			proto.Lifetime{Duration: time.Minute},
+			proto.RequestedTransport{Protocol: proto.ProtoUDP},
+			stun.NewUsername("user"),
+			stun.Fingerprint,
+		)
+
+		p, err := Decode(m.Raw)
+		assert.NoError(t, err)
+		assert.Equal(t, KindSTUN, p.Kind)
+		assert.Equal(t, m.Type, p.Message.Type)
+		assert.Len(t, p.Attributes, 6)
+		assert.Equal(t, "10.0.0.1:1000", p.Attributes[0].Value)
+		assert.Equal(t, "10.0.0.2:2000", p.Attributes[1].Value, "repeated attributes are decoded individually")
+		assert.Equal(t, "1m0s", p.Attributes[2].Value)
+		assert.Equal(t, "UDP", p.Attributes[3].Value)
+		assert.Equal(t, `"user"`, p.Attributes[4].Value)
+
+		s := p.String()
+		assert.True(t, strings.HasPrefix(s, "STUN CreatePermission request"), s)
+		assert.Contains(t, s, "\n  XOR-PEER-ADDRESS: 10.0.0.2:2000")
+	})
+
+	t.Run("ErrorResponse", func(t *testing.T) {
+		m := stun.MustBuild(stun.TransactionID,
+			stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse),
+			stun.CodeAllocQuotaReached,
+		)
+
+		p, err := Decode(m.Raw)
+		assert.NoError(t, err)
+		assert.Equal(t, "486: Allocation Quota Reached", p.Attributes[0].Value)
+	})
+
+	t.Run("ChannelData", func(t *testing.T) {
+		c := &proto.ChannelData{Number: proto.MinChannelNumber + 1, Data: []byte("hello")}
+		c.Encode()
+
+		p, err := Decode(c.Raw)
+		assert.NoError(t, err)
+		assert.Equal(t, KindChannelData, p.Kind)
+		assert.Equal(t, uint16(0x4001), p.ChannelNumber)
+		assert.Equal(t, 5, p.Length)
+		assert.Equal(t, []byte("hello"), p.Payload, "padding is stripped")
+		assert.Equal(t, "ChannelData channel=0x4001 length=5", p.String())
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := Decode([]byte{0xff, 0x00, 0x00})
+		assert.ErrorIs(t, err, ErrUnknownDatagram)
+		assert.Contains(t, Format([]byte{0xff, 0x00, 0x00}), "Unknown length=3")
+	})
+
+	t.Run("InvalidAttribute", func(t *testing.T) {
+		m := stun.New()
+		m.Type = stun.NewType(stun.MethodChannelBind, stun.ClassRequest)
+		m.WriteHeader()
+		m.Add(stun.AttrChannelNumber, []byte{0x40})
+
+		p, err := Decode(m.Raw)
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(p.Attributes[0].Value, "invalid"), p.Attributes[0].Value)
+	})
+}
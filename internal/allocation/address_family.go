@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// AddressFamily returns the address family of ip as used by REQUESTED-ADDRESS-FAMILY
+func AddressFamily(ip net.IP) proto.RequestedAddressFamily {
+	if ip.To4() != nil {
+		return proto.RequestedFamilyIPv4
+	}
+
+	return proto.RequestedFamilyIPv6
+}
+
+// network returns the network passed to the relay address generator for a relay
+// of the given protocol and address family, e.g. "udp6"
+func network(protocol Protocol, family proto.RequestedAddressFamily) string {
+	n := "udp"
+	if protocol == TCP {
+		n = "tcp"
+	}
+
+	if family == proto.RequestedFamilyIPv6 {
+		return n + "6"
+	}
+	return n + "4"
+}
+
+// MatchesAddressFamily returns true if ip has the address family of the relayed transport
+// address, or of the additional one. Peers of a different family can't be reached, see RFC
+// 6156 Section 5
+func (a *Allocation) MatchesAddressFamily(ip net.IP) bool {
+	family := AddressFamily(ip)
+	return family == a.AddressFamily || (a.AdditionalRelaySocket != nil && family == proto.RequestedFamilyIPv6)
+}
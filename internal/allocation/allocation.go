@@ -6,6 +6,8 @@ package allocation
 
 import (
 	"net"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -14,6 +16,8 @@ import (
 	"github.com/pion/stun/v2"
 	"github.com/pion/turn/v3/internal/ipnet"
 	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/pion/turn/v3/offload"
 )
 
 type allocationResponse struct {
@@ -26,13 +30,47 @@ type allocationResponse struct {
 type Allocation struct {
 	RelayAddr           net.Addr
 	Protocol            Protocol
+	AddressFamily       proto.RequestedAddressFamily
+	PermissionTimeout   time.Duration // Lifetime of permissions, defaults to 5 minutes
+	Listener            string        // Name of the listener the client connected to
+	ClientTransport     Transport
 	TurnSocket          net.PacketConn
 	RelaySocket         net.PacketConn
+	RelayListener       net.Listener // Set instead of RelaySocket for TCP allocations
 	fiveTuple           *FiveTuple
 	permissionsLock     sync.RWMutex
 	permissions         map[string]*Permission
 	channelBindingsLock sync.RWMutex
 	channelBindings     []*ChannelBind
+	tcpConnectionsLock  sync.RWMutex
+	tcpConnections      map[proto.ConnectionID]*TCPConnection
+	tcpConnecting       map[string]struct{} // Peers Connect is dialing, protected by tcpConnectionsLock
+	lastPermissionsLock sync.Mutex
+	lastPermissions     string
+	lastPermissionsTime time.Time
+	clientLock          sync.RWMutex // Protects fiveTuple and TurnSocket, see Manager.MoveAllocation
+	mobilityTicket      string       // Protected by Manager.lock
+	previousTicket      string       // Protected by Manager.lock, see Manager.RenewMobilityTicket
+	credentialsLock     sync.Mutex
+	username            string
+	key                 []byte
+	reauthRequired      bool
+	integrity           *proto.Integrity
+	cachedCredentials   *cachedCredentials
+	sessionPolicy       SessionPolicy
+	dontFragmentLock    sync.Mutex
+	dontFragment        bool
+	bandwidthLock       sync.RWMutex
+	bandwidthLimiters   []*BandwidthLimiter
+	bandwidthRelease    func()
+	metrics             *metrics.Metrics
+	stats               *allocationStats
+	scheduler           *Scheduler
+	sendBufferRetry     SendBufferRetry
+	offload             offload.Offloader
+	echoPeer            *net.UDPAddr
+	dscp                int32 // Accessed atomically
+	maxPacketSize       int32 // Accessed atomically
 	lifetimeTimer       *time.Timer
 	closed              chan interface{}
 	log                 logging.LeveledLogger
@@ -42,16 +80,24 @@ type Allocation struct {
 	// cache for response lost and client retry to implement 'stateless stack approach'
 	// See: https://datatracker.ietf.org/doc/html/rfc5766#section-6.2
 	responseCache atomic.Value // *allocationResponse
+
+	// AdditionalRelaySocket and AdditionalRelayAddr are the IPv6 relay of an allocation
+	// requested with ADDITIONAL-ADDRESS-FAMILY, RFC 8656 Section 7.2. Nil otherwise
+	AdditionalRelaySocket net.PacketConn
+	AdditionalRelayAddr   net.Addr
 }
 
 // NewAllocation creates a new instance of NewAllocation.
 func NewAllocation(turnSocket net.PacketConn, fiveTuple *FiveTuple, log logging.LeveledLogger) *Allocation {
 	return &Allocation{
-		TurnSocket:  turnSocket,
-		fiveTuple:   fiveTuple,
-		permissions: make(map[string]*Permission, 64),
-		closed:      make(chan interface{}),
-		log:         log,
+		AddressFamily:  proto.RequestedFamilyIPv4,
+		TurnSocket:     turnSocket,
+		fiveTuple:      fiveTuple,
+		permissions:    make(map[string]*Permission, 64),
+		tcpConnections: map[proto.ConnectionID]*TCPConnection{},
+		stats:          &allocationStats{},
+		closed:         make(chan interface{}),
+		log:            log,
 	}
 }
 
@@ -72,7 +118,8 @@ func (a *Allocation) AddPermission(p *Permission) {
 	a.permissionsLock.RUnlock()
 
 	if ok {
-		existedPermission.refresh(permissionTimeout)
+		existedPermission.addPort(p.Addr)
+		existedPermission.refresh(a.permissionTimeout())
 		return
 	}
 
@@ -81,7 +128,62 @@ func (a *Allocation) AddPermission(p *Permission) {
 	a.permissions[fingerprint] = p
 	a.permissionsLock.Unlock()
 
-	p.start(permissionTimeout)
+	p.start(a.permissionTimeout())
+}
+
+func (a *Allocation) permissionTimeout() time.Duration {
+	if a.PermissionTimeout > 0 {
+		return a.PermissionTimeout
+	}
+
+	return permissionTimeout
+}
+
+// SetLastPermissions records peers as the peer set of the last CreatePermission request
+func (a *Allocation) SetLastPermissions(peers []net.Addr) {
+	a.lastPermissionsLock.Lock()
+	defer a.lastPermissionsLock.Unlock()
+
+	a.lastPermissions = permissionsKey(peers)
+	a.lastPermissionsTime = time.Now()
+}
+
+// RefreshPermissions refreshes the permissions for peers and returns true if the last
+// CreatePermission request was for the identical peer set less than window ago and all
+// of its permissions still exist. Otherwise nothing is changed and the request has to be
+// handled in full
+func (a *Allocation) RefreshPermissions(peers []net.Addr, window time.Duration) bool {
+	a.lastPermissionsLock.Lock()
+	defer a.lastPermissionsLock.Unlock()
+
+	if a.lastPermissions != permissionsKey(peers) || time.Since(a.lastPermissionsTime) >= window {
+		return false
+	}
+
+	permissions := make([]*Permission, 0, len(peers))
+	for _, peer := range peers {
+		p := a.GetPermission(peer)
+		if p == nil {
+			return false
+		}
+		permissions = append(permissions, p)
+	}
+
+	for _, p := range permissions {
+		p.refresh(a.permissionTimeout())
+	}
+	return true
+}
+
+// permissionsKey identifies a set of peer addresses independent of their order
+func permissionsKey(peers []net.Addr) string {
+	keys := make([]string, 0, len(peers))
+	for _, peer := range peers {
+		keys = append(keys, peer.String())
+	}
+	sort.Strings(keys)
+
+	return strings.Join(keys, ",")
 }
 
 // RemovePermission removes the net.Addr's fingerprint from the allocation's permissions
@@ -110,6 +212,7 @@ func (a *Allocation) AddChannelBind(c *ChannelBind, lifetime time.Duration) erro
 		c.allocation = a
 		a.channelBindings = append(a.channelBindings, c)
 		c.start(lifetime)
+		a.addOffload(c)
 
 		// Channel binds also refresh permissions.
 		a.AddPermission(NewPermission(c.Peer, a.log))
@@ -129,8 +232,9 @@ func (a *Allocation) RemoveChannelBind(number proto.ChannelNumber) bool {
 	defer a.channelBindingsLock.Unlock()
 
 	for i := len(a.channelBindings) - 1; i >= 0; i-- {
-		if a.channelBindings[i].Number == number {
+		if c := a.channelBindings[i]; c.Number == number {
 			a.channelBindings = append(a.channelBindings[:i], a.channelBindings[i+1:]...)
+			a.removeOffload(c)
 			return true
 		}
 	}
@@ -165,8 +269,9 @@ func (a *Allocation) GetChannelByAddr(addr net.Addr) *ChannelBind {
 // Refresh updates the allocations lifetime
 func (a *Allocation) Refresh(lifetime time.Duration) {
 	if !a.lifetimeTimer.Reset(lifetime) {
-		a.log.Errorf("Failed to reset allocation timer for %v", a.fiveTuple)
+		a.log.Errorf("Failed to reset allocation timer for %v", a.getFiveTuple())
 	}
+	a.setExpires(lifetime)
 }
 
 // SetResponseCache cache allocation response for retransmit allocation request
@@ -187,9 +292,39 @@ func (a *Allocation) GetResponseCache() (id [stun.TransactionIDSize]byte, attrs
 
 // Close closes the allocation
 func (a *Allocation) Close() error {
+	if !a.stop() {
+		return nil
+	}
+
+	return a.closeRelay()
+}
+
+// closeRelay closes the relay socket, or the relay listener and TCP connections, of a
+func (a *Allocation) closeRelay() error {
+	if a.RelayListener != nil {
+		a.tcpConnectionsLock.RLock()
+		connections := make([]*TCPConnection, 0, len(a.tcpConnections))
+		for _, c := range a.tcpConnections {
+			connections = append(connections, c)
+		}
+		a.tcpConnectionsLock.RUnlock()
+
+		for _, c := range connections {
+			_ = c.Close()
+		}
+
+		return a.RelayListener.Close()
+	}
+
+	return a.closeRelaySockets()
+}
+
+// stop marks the allocation as closed and stops all timers, but leaves the relay
+// socket open. It returns false if the allocation was already stopped
+func (a *Allocation) stop() bool {
 	select {
 	case <-a.closed:
-		return nil
+		return false
 	default:
 	}
 	close(a.closed)
@@ -205,10 +340,16 @@ func (a *Allocation) Close() error {
 	a.channelBindingsLock.RLock()
 	for _, c := range a.channelBindings {
 		c.lifetimeTimer.Stop()
+		a.removeOffload(c)
 	}
 	a.channelBindingsLock.RUnlock()
 
-	return a.RelaySocket.Close()
+	a.releaseBandwidthLimiters()
+	if a.scheduler != nil {
+		a.scheduler.Remove(a)
+	}
+
+	return true
 }
 
 //  https://tools.ietf.org/html/rfc5766#section-10.3
@@ -233,54 +374,89 @@ func (a *Allocation) Close() error {
 
 const rtpMTU = 1600
 
-func (a *Allocation) packetHandler(m *Manager) {
-	buffer := make([]byte, rtpMTU)
+func (a *Allocation) packetHandler(m *Manager, relaySocket net.PacketConn) {
+	// Datagrams are read after the room of the ChannelData header, so ChannelData is
+	// encoded in place. Neither path logs per datagram, formatting the arguments allocates
+	// even if they aren't logged
+	buffer := m.bufferPool.Get()
+	defer m.bufferPool.Put(buffer)
+	payload := (*buffer)[bufferHeadroom : bufferHeadroom+m.bufferPool.Size()]
+
+	dataIndication := &stun.Message{}
+	dataIndicationType := stun.NewType(stun.MethodData, stun.ClassIndication)
 
 	for {
-		n, srcAddr, err := a.RelaySocket.ReadFrom(buffer)
+		n, srcAddr, err := relaySocket.ReadFrom(payload)
 		if err != nil {
-			m.DeleteAllocation(a.fiveTuple)
+			select {
+			case <-a.closed:
+			default:
+				m.DeleteAllocation(a.getFiveTuple())
+			}
 			return
 		}
 
-		a.log.Debugf("Relay socket %s received %d bytes from %s",
-			a.RelaySocket.LocalAddr().String(),
-			n,
-			srcAddr.String())
+		select {
+		case <-a.closed:
+			m.handleExpiredPacket(a, srcAddr, n)
+			continue
+		default:
+		}
 
 		if channel := a.GetChannelByAddr(srcAddr); channel != nil {
-			channelData := &proto.ChannelData{
-				Data:   buffer[:n],
+			if !a.AllowPacketSize(n, metrics.DirectionToClient) || !a.AllowRelay(n) {
+				continue
+			}
+
+			channelData := proto.ChannelData{
+				Raw:    (*buffer)[:bufferHeadroom+n],
 				Number: channel.Number,
 			}
-			channelData.Encode()
+			channelData.EncodeInPlace()
 
-			if _, err = a.TurnSocket.WriteTo(channelData.Raw, a.fiveTuple.SrcAddr); err != nil {
+			if _, err = a.writeToClient(channelData.Raw); err != nil {
 				a.log.Errorf("Failed to send ChannelData from allocation %v %v", srcAddr, err)
+			} else {
+				a.CountRelayed(metrics.DirectionToClient, n)
+			}
+		} else if m.permitsPeer(a, srcAddr) {
+			if !a.AllowPacketSize(n, metrics.DirectionToClient) || !a.AllowRelay(n) {
+				continue
 			}
-		} else if p := a.GetPermission(srcAddr); p != nil {
+
 			udpAddr, ok := srcAddr.(*net.UDPAddr)
 			if !ok {
 				a.log.Errorf("Failed to send DataIndication from allocation %v %v", srcAddr, err)
 				return
 			}
 
-			peerAddressAttr := proto.PeerAddress{IP: udpAddr.IP, Port: udpAddr.Port}
-			dataAttr := proto.Data(buffer[:n])
-
-			msg, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodData, stun.ClassIndication), peerAddressAttr, dataAttr)
-			if err != nil {
+			if err = buildDataIndication(dataIndication, dataIndicationType, udpAddr, payload[:n]); err != nil {
 				a.log.Errorf("Failed to send DataIndication from allocation %v %v", srcAddr, err)
 				return
 			}
-			a.log.Debugf("Relaying message from %s to client at %s",
-				srcAddr.String(),
-				a.fiveTuple.SrcAddr.String())
-			if _, err = a.TurnSocket.WriteTo(msg.Raw, a.fiveTuple.SrcAddr); err != nil {
+			if _, err = a.writeToClient(dataIndication.Raw); err != nil {
 				a.log.Errorf("Failed to send DataIndication from allocation %v %v", srcAddr, err)
+			} else {
+				a.CountRelayed(metrics.DirectionToClient, n)
+				a.CountRelayedIndication(metrics.DirectionToClient)
 			}
 		} else {
 			a.log.Infof("No Permission or Channel exists for %v on allocation %v", srcAddr, a.RelayAddr.String())
 		}
 	}
 }
+
+// buildDataIndication builds the Data indication of data from peer into m, reusing the
+// buffers of m. It is stun.Build without the setters, which allocate when boxed
+func buildDataIndication(m *stun.Message, t stun.MessageType, peer *net.UDPAddr, data []byte) error {
+	m.Reset()
+	m.WriteHeader()
+	if err := m.NewTransactionID(); err != nil {
+		return err
+	}
+	m.SetType(t)
+	if err := (proto.PeerAddress{IP: peer.IP, Port: peer.Port}).AddTo(m); err != nil {
+		return err
+	}
+	return proto.Data(data).AddTo(m)
+}
@@ -7,9 +7,13 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/logging"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/pion/turn/v3/offload"
 )
 
 // ManagerConfig a bag of config params for Manager.
@@ -18,6 +22,71 @@ type ManagerConfig struct {
 	AllocatePacketConn func(network string, requestedPort int) (net.PacketConn, net.Addr, error)
 	AllocateConn       func(network string, requestedPort int) (net.Conn, net.Addr, error)
 	PermissionHandler  func(sourceAddr net.Addr, peerIP net.IP) bool
+
+	// AllocateEvenPacketConn selects the even ports of EVEN-PORT requests. Random ports
+	// are tried until one is even if nil
+	AllocateEvenPacketConn func(network string) (net.PacketConn, net.Addr, error)
+
+	// AllocateListener and DialPeer are used for RFC 6062 TCP allocations. If AllocateListener
+	// is nil TCP allocations are disabled
+	AllocateListener func(network string, requestedPort int) (net.Listener, net.Addr, error)
+	DialPeer         func(network string, localAddr, peerAddr net.Addr) (net.Conn, error)
+
+	// PermissionMode sets how inbound peer traffic is matched against permissions
+	PermissionMode PermissionMode
+
+	// ExpiredPolicy and ExpiredGracePeriod control how peer traffic for expired
+	// allocations is handled. ExpiredGracePeriod defaults to DefaultExpiredGracePeriod
+	ExpiredPolicy      ExpiredPolicy
+	ExpiredGracePeriod time.Duration
+
+	// PermissionTimeout is the lifetime of permissions. Defaults to 5 minutes
+	PermissionTimeout time.Duration
+
+	// Listener and ClientTransport describe where clients of the allocations connect,
+	// see Allocation.Listener
+	Listener        string
+	ClientTransport Transport
+
+	// PeerPorts are the peer ports relayed traffic may be sent to. All ports if empty
+	PeerPorts []PortRange
+
+	// Metrics counts the allocations and the traffic they relay. Disabled if nil
+	Metrics *metrics.Metrics
+
+	// Scheduler sends the datagrams relayed by UDP allocations fairly. Sent immediately
+	// if nil
+	Scheduler *Scheduler
+
+	// BufferPool provides the buffers the allocations read relayed datagrams into. A pool
+	// of 1600 byte buffers is created if nil
+	BufferPool *BufferPool
+
+	// ChannelOffload forwards the ChannelData of the channels of UDP clients outside of
+	// the server. Relayed by the allocations if nil
+	ChannelOffload offload.Offloader
+
+	// DSCP marks the packets the allocations relay to peers. Unmarked if 0
+	DSCP int
+
+	// EchoPeer is a peer address the allocations echo datagrams from the client on, instead
+	// of relaying them. Disabled if nil
+	EchoPeer *net.UDPAddr
+
+	// Events are called when allocations are deleted. Optional
+	Events *Events
+
+	// SendBufferRetry retries the datagrams relayed to peers that the OS refused because the
+	// send buffer of the relay socket was full. Not retried if zero
+	SendBufferRetry SendBufferRetry
+
+	// MaxPacketSize caps the size of the datagrams relayed by UDP allocations, see
+	// Allocation.SetMaxPacketSize. Unlimited if 0
+	MaxPacketSize int
+
+	// Standby holds the allocations replicated from another server, which are restored
+	// with RestoreAllocation. Optional
+	Standby *Standby
 }
 
 type reservation struct {
@@ -27,15 +96,46 @@ type reservation struct {
 
 // Manager is used to hold active allocations
 type Manager struct {
+	// Accessed atomically, first for 64-bit alignment
+	expiredPackets      uint64
+	portMismatchPackets uint64
+	handlers            int64
+
 	lock sync.RWMutex
 	log  logging.LeveledLogger
 
 	allocations  map[string]*Allocation
 	reservations []*reservation
-
-	allocatePacketConn func(network string, requestedPort int) (net.PacketConn, net.Addr, error)
-	allocateConn       func(network string, requestedPort int) (net.Conn, net.Addr, error)
-	permissionHandler  func(sourceAddr net.Addr, peerIP net.IP) bool
+	expired      map[*Allocation]struct{}
+	closing      []*Allocation
+	timers       map[*time.Timer]struct{}
+
+	tcpConnections  map[proto.ConnectionID]*TCPConnection
+	mobilityTickets map[string]*Allocation
+
+	allocatePacketConn     func(network string, requestedPort int) (net.PacketConn, net.Addr, error)
+	allocateEvenPacketConn func(network string) (net.PacketConn, net.Addr, error)
+	allocateConn           func(network string, requestedPort int) (net.Conn, net.Addr, error)
+	permissionHandler      func(sourceAddr net.Addr, peerIP net.IP) bool
+	allocateListener       func(network string, requestedPort int) (net.Listener, net.Addr, error)
+	dialPeer               func(network string, localAddr, peerAddr net.Addr) (net.Conn, error)
+	permissionMode         PermissionMode
+	expiredPolicy          ExpiredPolicy
+	expiredGracePeriod     time.Duration
+	permissionTimeout      time.Duration
+	listener               string
+	clientTransport        Transport
+	peerPorts              []PortRange
+	metrics                *metrics.Metrics
+	scheduler              *Scheduler
+	bufferPool             *BufferPool
+	channelOffload         offload.Offloader
+	dscp                   int
+	echoPeer               *net.UDPAddr
+	events                 *Events
+	sendBufferRetry        SendBufferRetry
+	maxPacketSize          int
+	standby                *Standby
 }
 
 // NewManager creates a new instance of Manager.
@@ -47,22 +147,62 @@ func NewManager(config ManagerConfig) (*Manager, error) {
 		return nil, errAllocateConnMustBeSet
 	case config.LeveledLogger == nil:
 		return nil, errLeveledLoggerMustBeSet
+	case config.AllocateListener != nil && config.DialPeer == nil:
+		return nil, errDialPeerMustBeSet
+	}
+
+	expiredGracePeriod := config.ExpiredGracePeriod
+	if expiredGracePeriod == 0 {
+		expiredGracePeriod = DefaultExpiredGracePeriod
+	}
+
+	bufferPool := config.BufferPool
+	if bufferPool == nil {
+		bufferPool = NewBufferPool(rtpMTU)
 	}
 
 	return &Manager{
-		log:                config.LeveledLogger,
-		allocations:        make(map[string]*Allocation, 64),
-		allocatePacketConn: config.AllocatePacketConn,
-		allocateConn:       config.AllocateConn,
-		permissionHandler:  config.PermissionHandler,
+		log:                    config.LeveledLogger,
+		allocations:            make(map[string]*Allocation, 64),
+		expired:                map[*Allocation]struct{}{},
+		timers:                 map[*time.Timer]struct{}{},
+		tcpConnections:         map[proto.ConnectionID]*TCPConnection{},
+		mobilityTickets:        map[string]*Allocation{},
+		allocatePacketConn:     config.AllocatePacketConn,
+		allocateEvenPacketConn: config.AllocateEvenPacketConn,
+		allocateConn:           config.AllocateConn,
+		permissionHandler:      config.PermissionHandler,
+		allocateListener:       config.AllocateListener,
+		dialPeer:               config.DialPeer,
+		permissionMode:         config.PermissionMode,
+		expiredPolicy:          config.ExpiredPolicy,
+		expiredGracePeriod:     expiredGracePeriod,
+		permissionTimeout:      config.PermissionTimeout,
+		listener:               config.Listener,
+		clientTransport:        config.ClientTransport,
+		peerPorts:              config.PeerPorts,
+		metrics:                config.Metrics,
+		scheduler:              config.Scheduler,
+		bufferPool:             bufferPool,
+		channelOffload:         config.ChannelOffload,
+		dscp:                   config.DSCP,
+		echoPeer:               config.EchoPeer,
+		events:                 config.Events,
+		sendBufferRetry:        config.SendBufferRetry,
+		maxPacketSize:          config.MaxPacketSize,
+		standby:                config.Standby,
 	}, nil
 }
 
 // GetAllocation fetches the allocation matching the passed FiveTuple
 func (m *Manager) GetAllocation(fiveTuple *FiveTuple) *Allocation {
+	// The fingerprint is built on the stack, it is looked up for every relayed packet
+	var buf [fingerprintSize]byte
+	fingerprint := fiveTuple.AppendFingerprint(buf[:0])
+
 	m.lock.RLock()
 	defer m.lock.RUnlock()
-	return m.allocations[fiveTuple.Fingerprint()]
+	return m.allocations[string(fingerprint)]
 }
 
 // AllocationCount returns the number of existing allocations
@@ -72,59 +212,180 @@ func (m *Manager) AllocationCount() int {
 	return len(m.allocations)
 }
 
+// Allocations returns the existing allocations
+func (m *Manager) Allocations() []*Allocation {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	allocations := make([]*Allocation, 0, len(m.allocations))
+	for _, a := range m.allocations {
+		allocations = append(allocations, a)
+	}
+	return allocations
+}
+
+// UsernameAllocationCount returns the number of existing allocations created by username
+func (m *Manager) UsernameAllocationCount(username string) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	count := 0
+	for _, a := range m.allocations {
+		if a.Username() == username {
+			count++
+		}
+	}
+
+	return count
+}
+
+// RequireReauthentication requires every allocation created by username to present new
+// credentials on its next request. It returns the number of allocations affected
+func (m *Manager) RequireReauthentication(username string) int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	count := 0
+	for _, a := range m.allocations {
+		if a.Username() == username {
+			a.RequireReauthentication()
+			count++
+		}
+	}
+
+	return count
+}
+
 // Close closes the manager and closes all allocations it manages
 func (m *Manager) Close() error {
-	m.lock.Lock()
-	defer m.lock.Unlock()
+	m.DeleteAllocations()
+	return m.CloseRelaySockets()
+}
 
-	for _, a := range m.allocations {
-		if err := a.Close(); err != nil {
-			return err
+// DeleteAllocations deletes all allocations, stopping their timers and emitting their
+// deletion events, and stops the timers of the manager. The relay sockets stay open until
+// CloseRelaySockets, so the server can delete the allocations of all of its listeners
+// before any relay socket is closed
+func (m *Manager) DeleteAllocations() {
+	m.lock.Lock()
+	m.stopTimers()
+
+	deleted := make([]*Allocation, 0, len(m.allocations))
+	for fingerprint, a := range m.allocations {
+		delete(m.allocations, fingerprint)
+		m.removeMobilityTicket(a)
+		m.metrics.AllocationDeleted()
+		if a.stop() {
+			deleted = append(deleted, a)
 		}
 	}
-	return nil
+	m.closing = append(m.closing, deleted...)
+
+	// Expired allocations were deleted already, only their relay sockets are open
+	for a := range m.expired {
+		delete(m.expired, a)
+		m.closing = append(m.closing, a)
+	}
+	m.lock.Unlock()
+
+	for _, a := range deleted {
+		m.events.AllocationDeleted(a)
+	}
 }
 
-// CreateAllocation creates a new allocation and starts relaying
-func (m *Manager) CreateAllocation(fiveTuple *FiveTuple, turnSocket net.PacketConn, requestedPort int, lifetime time.Duration) (*Allocation, error) {
-	switch {
-	case fiveTuple == nil:
-		return nil, errNilFiveTuple
-	case fiveTuple.SrcAddr == nil:
-		return nil, errNilFiveTupleSrcAddr
-	case fiveTuple.DstAddr == nil:
-		return nil, errNilFiveTupleDstAddr
-	case turnSocket == nil:
-		return nil, errNilTurnSocket
-	case lifetime == 0:
-		return nil, errLifetimeZero
+// CloseRelaySockets closes the relay sockets and TCP connections of the allocations deleted
+// by DeleteAllocations. It closes all of them, and returns their errors joined
+func (m *Manager) CloseRelaySockets() error {
+	m.lock.Lock()
+	closing := m.closing
+	m.closing = nil
+	m.lock.Unlock()
+
+	errs := make([]error, 0, len(closing))
+	for _, a := range closing {
+		errs = append(errs, a.closeRelay())
 	}
 
-	if a := m.GetAllocation(fiveTuple); a != nil {
-		return nil, fmt.Errorf("%w: %v", errDupeFiveTuple, fiveTuple)
+	return JoinErrors(errs...)
+}
+
+// CreateAllocation creates a new allocation with a relayed transport address of the given
+// address family and starts relaying
+func (m *Manager) CreateAllocation(fiveTuple *FiveTuple, turnSocket net.PacketConn, family proto.RequestedAddressFamily, requestedPort int, lifetime time.Duration) (*Allocation, error) {
+	a, _, err := m.createAllocation(fiveTuple, turnSocket, family, requestedPort, lifetime, false)
+	return a, err
+}
+
+func (m *Manager) createAllocation(fiveTuple *FiveTuple, turnSocket net.PacketConn, family proto.RequestedAddressFamily, requestedPort int, lifetime time.Duration, dualStack bool) (*Allocation, error, error) { //nolint:revive,stylecheck
+	if err := m.validateAllocation(fiveTuple, turnSocket, lifetime); err != nil {
+		return nil, nil, err
 	}
 	a := NewAllocation(turnSocket, fiveTuple, m.log)
-
-	conn, relayAddr, err := m.allocatePacketConn("udp4", requestedPort)
+	a.AddressFamily = family
+	a.PermissionTimeout = m.permissionTimeout
+	a.Listener = m.listener
+	a.ClientTransport = m.clientTransport
+	a.metrics = m.metrics
+	a.scheduler = m.scheduler
+	a.sendBufferRetry = m.sendBufferRetry
+	a.offload = m.channelOffload
+	a.echoPeer = m.echoPeer
+	a.maxPacketSize = int32(m.maxPacketSize)
+
+	conn, relayAddr, err := m.allocatePacketConn(network(UDP, family), requestedPort)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	a.RelaySocket = conn
 	a.RelayAddr = relayAddr
 
+	var additionalErr error
+	if dualStack {
+		additionalErr = m.allocateAdditionalRelay(a)
+	}
+	m.setDefaultDSCP(a)
+
 	m.log.Debugf("Listening on relay address: %s", a.RelayAddr.String())
 
 	a.lifetimeTimer = time.AfterFunc(lifetime, func() {
-		m.DeleteAllocation(a.fiveTuple)
+		m.expireAllocation(a)
 	})
+	a.setExpires(lifetime)
 
 	m.lock.Lock()
 	m.allocations[fiveTuple.Fingerprint()] = a
 	m.lock.Unlock()
+	m.metrics.AllocationCreated()
 
-	go a.packetHandler(m)
-	return a, nil
+	for _, relaySocket := range a.relaySockets() {
+		relaySocket := relaySocket
+		m.goHandler(func() {
+			a.packetHandler(m, relaySocket)
+		})
+	}
+	return a, additionalErr, nil
+}
+
+func (m *Manager) validateAllocation(fiveTuple *FiveTuple, turnSocket net.PacketConn, lifetime time.Duration) error {
+	switch {
+	case fiveTuple == nil:
+		return errNilFiveTuple
+	case fiveTuple.SrcAddr == nil:
+		return errNilFiveTupleSrcAddr
+	case fiveTuple.DstAddr == nil:
+		return errNilFiveTupleDstAddr
+	case turnSocket == nil:
+		return errNilTurnSocket
+	case lifetime == 0:
+		return errLifetimeZero
+	}
+
+	if a := m.GetAllocation(fiveTuple); a != nil {
+		return fmt.Errorf("%w: %v", errDupeFiveTuple, fiveTuple)
+	}
+
+	return nil
 }
 
 // DeleteAllocation removes an allocation
@@ -134,11 +395,16 @@ func (m *Manager) DeleteAllocation(fiveTuple *FiveTuple) {
 	m.lock.Lock()
 	allocation := m.allocations[fingerprint]
 	delete(m.allocations, fingerprint)
+	if allocation != nil {
+		m.removeMobilityTicket(allocation)
+	}
 	m.lock.Unlock()
 
 	if allocation == nil {
 		return
 	}
+	m.metrics.AllocationDeleted()
+	m.events.AllocationDeleted(allocation)
 
 	if err := allocation.Close(); err != nil {
 		m.log.Errorf("Failed to close allocation: %v", err)
@@ -147,7 +413,7 @@ func (m *Manager) DeleteAllocation(fiveTuple *FiveTuple) {
 
 // CreateReservation stores the reservation for the token+port
 func (m *Manager) CreateReservation(reservationToken string, port int) {
-	time.AfterFunc(30*time.Second, func() {
+	m.afterFunc(30*time.Second, func() {
 		m.lock.Lock()
 		defer m.lock.Unlock()
 		for i := len(m.reservations) - 1; i >= 0; i-- {
@@ -179,10 +445,26 @@ func (m *Manager) GetReservation(reservationToken string) (int, bool) {
 	return 0, false
 }
 
-// GetRandomEvenPort returns a random un-allocated udp4 port
-func (m *Manager) GetRandomEvenPort() (int, error) {
+// GetRandomEvenPort returns a random un-allocated UDP port of the given address family
+func (m *Manager) GetRandomEvenPort(family proto.RequestedAddressFamily) (int, error) {
+	if m.allocateEvenPacketConn != nil {
+		conn, addr, err := m.allocateEvenPacketConn(network(UDP, family))
+		if err != nil {
+			return 0, err
+		}
+		if err = conn.Close(); err != nil {
+			return 0, err
+		}
+
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			return 0, errFailedToCastUDPAddr
+		}
+		return udpAddr.Port, nil
+	}
+
 	for i := 0; i < 128; i++ {
-		conn, addr, err := m.allocatePacketConn("udp4", 0)
+		conn, addr, err := m.allocatePacketConn(network(UDP, family), 0)
 		if err != nil {
 			return 0, err
 		}
@@ -202,6 +484,11 @@ func (m *Manager) GetRandomEvenPort() (int, error) {
 	return 0, errFailedToAllocateEvenPort
 }
 
+// Listener returns the name of the listener the Manager creates allocations for
+func (m *Manager) Listener() string {
+	return m.listener
+}
+
 // GrantPermission handles permission requests by calling the permission handler callback
 // associated with the TURN server listener socket
 func (m *Manager) GrantPermission(sourceAddr net.Addr, peerIP net.IP) error {
@@ -216,3 +503,25 @@ func (m *Manager) GrantPermission(sourceAddr net.Addr, peerIP net.IP) error {
 
 	return errAdminProhibited
 }
+
+// PortMismatchPackets returns the number of inbound peer packets and connections whose
+// IP address has a permission, but whose port was not part of any request that created
+// or refreshed it. These are relayed with PermissionModeIP and dropped with PermissionModeIPPort
+func (m *Manager) PortMismatchPackets() uint64 {
+	return atomic.LoadUint64(&m.portMismatchPackets)
+}
+
+// permitsPeer returns true if inbound traffic from addr may be relayed to the client of a
+func (m *Manager) permitsPeer(a *Allocation, addr net.Addr) bool {
+	p := a.GetPermission(addr)
+	if p == nil {
+		return false
+	}
+
+	if !p.hasPort(addr) {
+		atomic.AddUint64(&m.portMismatchPackets, 1)
+		return m.permissionMode != PermissionModeIPPort
+	}
+
+	return true
+}
@@ -31,6 +31,12 @@ func TestManager(t *testing.T) {
 		{"AllocationTimeout", subTestAllocationTimeout},
 		{"Close", subTestManagerClose},
 		{"GetRandomEvenPort", subTestGetRandomEvenPort},
+		{"ExpiredPolicy", subTestExpiredPolicy},
+		{"Resources", subTestManagerResources},
+		{"DeleteAllocations", subTestManagerDeleteAllocations},
+		{"CreateDualStackAllocation", subTestCreateDualStackAllocation},
+		{"MobilityTicket", subTestMobilityTicket},
+		{"ConnectConcurrent", subTestConnectConcurrent},
 	}
 
 	network := "udp4"
@@ -52,13 +58,13 @@ func subTestCreateInvalidAllocation(t *testing.T, turnSocket net.PacketConn) {
 	m, err := newTestManager()
 	assert.NoError(t, err)
 
-	if a, err := m.CreateAllocation(nil, turnSocket, 0, proto.DefaultLifetime); a != nil || err == nil {
+	if a, err := m.CreateAllocation(nil, turnSocket, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime); a != nil || err == nil {
 		t.Errorf("Illegally created allocation with nil FiveTuple")
 	}
-	if a, err := m.CreateAllocation(randomFiveTuple(), nil, 0, proto.DefaultLifetime); a != nil || err == nil {
+	if a, err := m.CreateAllocation(randomFiveTuple(), nil, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime); a != nil || err == nil {
 		t.Errorf("Illegally created allocation with nil turnSocket")
 	}
-	if a, err := m.CreateAllocation(randomFiveTuple(), turnSocket, 0, 0); a != nil || err == nil {
+	if a, err := m.CreateAllocation(randomFiveTuple(), turnSocket, proto.RequestedFamilyIPv4, 0, 0); a != nil || err == nil {
 		t.Errorf("Illegally created allocation with 0 lifetime")
 	}
 }
@@ -69,7 +75,7 @@ func subTestCreateAllocation(t *testing.T, turnSocket net.PacketConn) {
 	assert.NoError(t, err)
 
 	fiveTuple := randomFiveTuple()
-	if a, err := m.CreateAllocation(fiveTuple, turnSocket, 0, proto.DefaultLifetime); a == nil || err != nil {
+	if a, err := m.CreateAllocation(fiveTuple, turnSocket, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime); a == nil || err != nil {
 		t.Errorf("Failed to create allocation %v %v", a, err)
 	}
 
@@ -84,11 +90,11 @@ func subTestCreateAllocationDuplicateFiveTuple(t *testing.T, turnSocket net.Pack
 	assert.NoError(t, err)
 
 	fiveTuple := randomFiveTuple()
-	if a, err := m.CreateAllocation(fiveTuple, turnSocket, 0, proto.DefaultLifetime); a == nil || err != nil {
+	if a, err := m.CreateAllocation(fiveTuple, turnSocket, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime); a == nil || err != nil {
 		t.Errorf("Failed to create allocation %v %v", a, err)
 	}
 
-	if a, err := m.CreateAllocation(fiveTuple, turnSocket, 0, proto.DefaultLifetime); a != nil || err == nil {
+	if a, err := m.CreateAllocation(fiveTuple, turnSocket, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime); a != nil || err == nil {
 		t.Errorf("Was able to create allocation with same FiveTuple twice")
 	}
 }
@@ -98,7 +104,7 @@ func subTestDeleteAllocation(t *testing.T, turnSocket net.PacketConn) {
 	assert.NoError(t, err)
 
 	fiveTuple := randomFiveTuple()
-	if a, err := m.CreateAllocation(fiveTuple, turnSocket, 0, proto.DefaultLifetime); a == nil || err != nil {
+	if a, err := m.CreateAllocation(fiveTuple, turnSocket, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime); a == nil || err != nil {
 		t.Errorf("Failed to create allocation %v %v", a, err)
 	}
 
@@ -123,7 +129,7 @@ func subTestAllocationTimeout(t *testing.T, turnSocket net.PacketConn) {
 	for index := range allocations {
 		fiveTuple := randomFiveTuple()
 
-		a, err := m.CreateAllocation(fiveTuple, turnSocket, 0, lifetime)
+		a, err := m.CreateAllocation(fiveTuple, turnSocket, proto.RequestedFamilyIPv4, 0, lifetime)
 		if err != nil {
 			t.Errorf("Failed to create allocation with %v", fiveTuple)
 		}
@@ -147,9 +153,9 @@ func subTestManagerClose(t *testing.T, turnSocket net.PacketConn) {
 
 	allocations := make([]*Allocation, 2)
 
-	a1, _ := m.CreateAllocation(randomFiveTuple(), turnSocket, 0, time.Second)
+	a1, _ := m.CreateAllocation(randomFiveTuple(), turnSocket, proto.RequestedFamilyIPv4, 0, time.Second)
 	allocations[0] = a1
-	a2, _ := m.CreateAllocation(randomFiveTuple(), turnSocket, 0, time.Minute)
+	a2, _ := m.CreateAllocation(randomFiveTuple(), turnSocket, proto.RequestedFamilyIPv4, 0, time.Minute)
 	allocations[1] = a2
 
 	// Make a1 timeout
@@ -166,6 +172,253 @@ func subTestManagerClose(t *testing.T, turnSocket net.PacketConn) {
 	}
 }
 
+// Test that late peer packets are handled according to the ExpiredPolicy
+func subTestExpiredPolicy(t *testing.T, turnSocket net.PacketConn) {
+	for _, policy := range []ExpiredPolicy{ExpiredPolicyDrop, ExpiredPolicyCount} {
+		m, err := newTestManager()
+		assert.NoError(t, err)
+		m.expiredPolicy = policy
+		m.expiredGracePeriod = time.Second
+
+		fiveTuple := randomFiveTuple()
+		a, err := m.CreateAllocation(fiveTuple, turnSocket, proto.RequestedFamilyIPv4, 0, 100*time.Millisecond)
+		assert.NoError(t, err)
+
+		time.Sleep(300 * time.Millisecond)
+		assert.Nil(t, m.GetAllocation(fiveTuple), "allocation should be removed once expired")
+
+		peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, err)
+		relayAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: a.RelaySocket.LocalAddr().(*net.UDPAddr).Port}
+		_, err = peer.WriteTo([]byte("late"), relayAddr)
+		assert.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+		if policy == ExpiredPolicyCount {
+			assert.Equal(t, uint64(1), m.ExpiredPackets())
+		} else {
+			assert.Equal(t, uint64(0), m.ExpiredPackets())
+		}
+
+		// Relay socket is closed once the grace period is over
+		time.Sleep(time.Second)
+		assert.True(t, isClose(a.RelaySocket))
+		assert.NoError(t, peer.Close())
+		assert.NoError(t, m.Close())
+	}
+
+	// Default policy closes the relay socket right away
+	m, err := newTestManager()
+	assert.NoError(t, err)
+
+	a, err := m.CreateAllocation(randomFiveTuple(), turnSocket, proto.RequestedFamilyIPv4, 0, 100*time.Millisecond)
+	assert.NoError(t, err)
+
+	time.Sleep(300 * time.Millisecond)
+	assert.True(t, isClose(a.RelaySocket))
+}
+
+// Test that the resources of the manager are released by Close
+func subTestManagerResources(t *testing.T, turnSocket net.PacketConn) {
+	m, err := newTestManager()
+	assert.NoError(t, err)
+	assert.True(t, m.Resources().IsZero())
+
+	_, err = m.CreateAllocation(randomFiveTuple(), turnSocket, proto.RequestedFamilyIPv4, 0, time.Minute)
+	assert.NoError(t, err)
+	m.CreateReservation("token", 50000)
+	assert.Equal(t, Resources{Allocations: 1, Handlers: 1, Timers: 1}, m.Resources())
+
+	assert.NoError(t, m.Close())
+	assert.Eventually(t, func() bool {
+		return m.Resources().IsZero()
+	}, time.Second, 10*time.Millisecond, "%+v", m.Resources())
+}
+
+// Test that DeleteAllocations leaves the relay sockets open for CloseRelaySockets
+func subTestManagerDeleteAllocations(t *testing.T, turnSocket net.PacketConn) {
+	m, err := newTestManager()
+	assert.NoError(t, err)
+
+	deleted := 0
+	m.events = &Events{OnAllocationDeleted: func(Info) {
+		deleted++
+	}}
+
+	allocations := []*Allocation{}
+	for i := 0; i < 2; i++ {
+		a, createErr := m.CreateAllocation(randomFiveTuple(), turnSocket, proto.RequestedFamilyIPv4, 0, time.Minute)
+		assert.NoError(t, createErr)
+		allocations = append(allocations, a)
+	}
+
+	m.DeleteAllocations()
+	assert.Equal(t, 2, deleted)
+	assert.Equal(t, 0, m.AllocationCount())
+	for _, a := range allocations {
+		_, err = a.RelaySocket.WriteTo([]byte("open"), turnSocket.LocalAddr())
+		assert.NoError(t, err, "relay socket should be open")
+	}
+
+	assert.NoError(t, m.CloseRelaySockets())
+	for _, a := range allocations {
+		assert.True(t, isClose(a.RelaySocket))
+	}
+
+	// Closing again neither emits events nor fails
+	assert.NoError(t, m.Close())
+	assert.Equal(t, 2, deleted)
+}
+
+// Test that a dual-stack allocation relays IPv6 peers through its additional relay
+func subTestCreateDualStackAllocation(t *testing.T, turnSocket net.PacketConn) {
+	ipv6 := true
+	m, err := NewManager(ManagerConfig{
+		LeveledLogger: logging.NewDefaultLoggerFactory().NewLogger("test"),
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			address := "127.0.0.1:0"
+			if network == "udp6" {
+				if !ipv6 {
+					return nil, nil, ErrAddressFamilyNotSupported
+				}
+				address = "[::1]:0"
+			}
+
+			conn, err := net.ListenPacket(network, address)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) { return nil, nil, nil },
+	})
+	assert.NoError(t, err)
+
+	a, additionalErr, err := m.CreateDualStackAllocation(randomFiveTuple(), turnSocket, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.NoError(t, additionalErr)
+	assert.Equal(t, proto.RequestedFamilyIPv4, a.AddressFamily)
+	assert.Equal(t, a.AdditionalRelaySocket.LocalAddr(), a.AdditionalRelayAddr)
+
+	peer4 := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	peer6 := &net.UDPAddr{IP: net.ParseIP("::1"), Port: 5000}
+	assert.True(t, a.MatchesAddressFamily(peer4.IP))
+	assert.True(t, a.MatchesAddressFamily(peer6.IP))
+	assert.Equal(t, a.RelaySocket, a.relaySocketFor(peer4))
+	assert.Equal(t, a.AdditionalRelaySocket, a.relaySocketFor(peer6))
+
+	// Without IPv6 the allocation only has the IPv4 relay
+	ipv6 = false
+	b, additionalErr, err := m.CreateDualStackAllocation(randomFiveTuple(), turnSocket, 0, time.Minute)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, additionalErr, ErrAddressFamilyNotSupported)
+	assert.Nil(t, b.AdditionalRelaySocket)
+	assert.False(t, b.MatchesAddressFamily(peer6.IP))
+
+	assert.NoError(t, m.Close())
+	assert.True(t, isClose(a.RelaySocket))
+	assert.True(t, isClose(a.AdditionalRelaySocket))
+	assert.True(t, isClose(b.RelaySocket))
+}
+
+func subTestMobilityTicket(t *testing.T, turnSocket net.PacketConn) {
+	m, err := newTestManager()
+	assert.NoError(t, err)
+
+	a, err := m.CreateAllocation(randomFiveTuple(), turnSocket, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime)
+	assert.NoError(t, err)
+
+	first, err := m.CreateMobilityTicket(a)
+	assert.NoError(t, err)
+
+	// Every renewal issues a new ticket
+	second, err := m.RenewMobilityTicket(a, first)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+
+	// The client may not have received the new ticket, the old one renews it again
+	third, err := m.RenewMobilityTicket(a, first)
+	assert.NoError(t, err)
+	assert.False(t, m.HasMobilityTicket(a, second))
+
+	// Once the client presents the new ticket the old one is invalid
+	fourth, err := m.RenewMobilityTicket(a, third)
+	assert.NoError(t, err)
+	assert.False(t, m.HasMobilityTicket(a, first))
+	_, err = m.RenewMobilityTicket(a, first)
+	assert.ErrorIs(t, err, ErrMobilityTicketInvalid)
+	_, err = m.MoveAllocation(first, randomFiveTuple(), turnSocket, "")
+	assert.ErrorIs(t, err, ErrMobilityTicketInvalid)
+	assert.True(t, m.HasMobilityTicket(a, third))
+	assert.True(t, m.HasMobilityTicket(a, fourth))
+
+	// Deleting the allocation invalidates its tickets
+	m.DeleteAllocation(a.getFiveTuple())
+	assert.Empty(t, m.mobilityTickets)
+}
+
+func subTestConnectConcurrent(t *testing.T, turnSocket net.PacketConn) {
+	peerListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, peerListener.Close())
+	}()
+	unreachable := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	m, err := NewManager(ManagerConfig{
+		LeveledLogger:      logging.NewDefaultLoggerFactory().NewLogger("test"),
+		AllocatePacketConn: func(string, int) (net.PacketConn, net.Addr, error) { return nil, nil, nil },
+		AllocateConn:       func(string, int) (net.Conn, net.Addr, error) { return nil, nil, nil },
+		AllocateListener: func(network string, requestedPort int) (net.Listener, net.Addr, error) {
+			listener, listenErr := net.Listen(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return listener, listener.Addr(), nil
+		},
+		DialPeer: func(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+			if peerAddr.String() == peerListener.Addr().String() {
+				dialing <- struct{}{}
+				<-release
+			}
+
+			return net.Dial(network, peerAddr.String())
+		},
+	})
+	assert.NoError(t, err)
+
+	a, err := m.CreateTCPAllocation(randomFiveTuple(), turnSocket, proto.RequestedFamilyIPv4, proto.DefaultLifetime)
+	assert.NoError(t, err)
+
+	// A second Connect to the peer while the first one is dialing it fails
+	connected := make(chan error, 1)
+	go func() {
+		_, connectErr := m.Connect(a, peerListener.Addr())
+		connected <- connectErr
+	}()
+	<-dialing
+
+	_, err = m.Connect(a, peerListener.Addr())
+	assert.ErrorIs(t, err, ErrConnectionAlreadyExists)
+
+	close(release)
+	assert.NoError(t, <-connected)
+	_, err = m.Connect(a, peerListener.Addr())
+	assert.ErrorIs(t, err, ErrConnectionAlreadyExists)
+
+	// A failed dial releases the peer
+	for i := 0; i < 2; i++ {
+		_, err = m.Connect(a, unreachable)
+		assert.ErrorIs(t, err, ErrConnectionFailed)
+	}
+
+	assert.NoError(t, m.Close())
+}
+
 func randomFiveTuple() *FiveTuple {
 	/* #nosec */
 	return &FiveTuple{
@@ -201,7 +454,7 @@ func subTestGetRandomEvenPort(t *testing.T, _ net.PacketConn) {
 	m, err := newTestManager()
 	assert.NoError(t, err)
 
-	port, err := m.GetRandomEvenPort()
+	port, err := m.GetRandomEvenPort(proto.RequestedFamilyIPv4)
 	assert.NoError(t, err)
 	assert.True(t, port > 0)
 	assert.True(t, port%2 == 0)
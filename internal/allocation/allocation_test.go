@@ -27,6 +27,7 @@ func TestAllocation(t *testing.T) {
 		{"GetPermission", subTestGetPermission},
 		{"AddPermission", subTestAddPermission},
 		{"RemovePermission", subTestRemovePermission},
+		{"PermissionTimeout", subTestPermissionTimeout},
 		{"AddChannelBind", subTestAddChannelBind},
 		{"GetChannelByNumber", subTestGetChannelByNumber},
 		{"GetChannelByAddr", subTestGetChannelByAddr},
@@ -34,6 +35,7 @@ func TestAllocation(t *testing.T) {
 		{"Refresh", subTestAllocationRefresh},
 		{"Close", subTestAllocationClose},
 		{"packetHandler", subTestPacketHandler},
+		{"PermissionMode", subTestPermissionMode},
 		{"ResponseCache", subTestResponseCache},
 	}
 
@@ -106,6 +108,22 @@ func subTestAddPermission(t *testing.T) {
 	assert.Equal(t, p, foundPermission)
 }
 
+func subTestPermissionTimeout(t *testing.T) {
+	a := NewAllocation(nil, nil, nil)
+	a.PermissionTimeout = 50 * time.Millisecond
+
+	addr, err := net.ResolveUDPAddr("udp", "127.0.0.1:3478")
+	if err != nil {
+		t.Fatalf("failed to resolve: %s", err)
+	}
+
+	a.AddPermission(&Permission{Addr: addr})
+	assert.NotNil(t, a.GetPermission(addr))
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Nil(t, a.GetPermission(addr), "Permission should expire after PermissionTimeout")
+}
+
 func subTestRemovePermission(t *testing.T) {
 	a := NewAllocation(nil, nil, nil)
 
@@ -292,7 +310,7 @@ func subTestPacketHandler(t *testing.T) {
 	a, err := m.CreateAllocation(&FiveTuple{
 		SrcAddr: clientListener.LocalAddr(),
 		DstAddr: turnSocket.LocalAddr(),
-	}, turnSocket, 0, proto.DefaultLifetime)
+	}, turnSocket, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime)
 
 	assert.Nil(t, err, "should succeed")
 
@@ -356,6 +374,80 @@ func subTestPacketHandler(t *testing.T) {
 	_ = peerListener2.Close()
 }
 
+func subTestPermissionMode(t *testing.T) {
+	for _, mode := range []PermissionMode{PermissionModeIP, PermissionModeIPPort} {
+		m, err := newTestManager()
+		assert.NoError(t, err)
+		m.permissionMode = mode
+
+		turnSocket, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, err)
+
+		clientListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, err)
+
+		dataCh := make(chan []byte, 4)
+		go func() {
+			buffer := make([]byte, rtpMTU)
+			for {
+				n, _, err2 := clientListener.ReadFrom(buffer)
+				if err2 != nil {
+					return
+				}
+
+				dataCh <- append([]byte{}, buffer[:n]...)
+			}
+		}()
+
+		a, err := m.CreateAllocation(&FiveTuple{
+			SrcAddr: clientListener.LocalAddr(),
+			DstAddr: turnSocket.LocalAddr(),
+		}, turnSocket, proto.RequestedFamilyIPv4, 0, proto.DefaultLifetime)
+		assert.NoError(t, err)
+
+		permittedPeer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, err)
+
+		otherPortPeer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, err)
+
+		a.AddPermission(NewPermission(permittedPeer.LocalAddr(), m.log))
+
+		_, port, _ := ipnet.AddrIPPort(a.RelaySocket.LocalAddr())
+		relayAddr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}
+
+		readData := func() string {
+			select {
+			case raw := <-dataCh:
+				var msg stun.Message
+				assert.NoError(t, stun.Decode(raw, &msg))
+
+				var data proto.Data
+				assert.NoError(t, data.GetFrom(&msg))
+				return string(data)
+			case <-time.After(time.Second):
+				return ""
+			}
+		}
+
+		_, err = otherPortPeer.WriteTo([]byte("other port"), relayAddr)
+		assert.NoError(t, err)
+		_, err = permittedPeer.WriteTo([]byte("permitted"), relayAddr)
+		assert.NoError(t, err)
+
+		if mode == PermissionModeIP {
+			assert.Equal(t, "other port", readData())
+		}
+		assert.Equal(t, "permitted", readData())
+		assert.Equal(t, uint64(1), m.PortMismatchPackets())
+
+		assert.NoError(t, m.Close())
+		assert.NoError(t, clientListener.Close())
+		assert.NoError(t, permittedPeer.Close())
+		assert.NoError(t, otherPortPeer.Close())
+	}
+}
+
 func subTestResponseCache(t *testing.T) {
 	a := NewAllocation(nil, nil, nil)
 	transactionID := [stun.TransactionIDSize]byte{1, 2, 3}
@@ -370,3 +462,22 @@ func subTestResponseCache(t *testing.T) {
 	assert.Equal(t, transactionID, cacheID)
 	assert.Equal(t, responseAttrs, cacheAttr)
 }
+
+func TestSessionPolicy(t *testing.T) {
+	_, network, err := net.ParseCIDR("10.0.0.0/8")
+	assert.NoError(t, err)
+
+	policy := SessionPolicy{MaxLifetime: time.Minute, AllowedPeers: []*net.IPNet{network}}
+	assert.Equal(t, time.Minute, policy.CapLifetime(time.Hour))
+	assert.Equal(t, time.Second, policy.CapLifetime(time.Second))
+	assert.True(t, policy.AllowsPeer(&net.UDPAddr{IP: net.ParseIP("10.1.2.3"), Port: 5000}))
+	assert.False(t, policy.AllowsPeer(&net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5000}))
+
+	var defaults SessionPolicy
+	assert.Equal(t, time.Hour, defaults.CapLifetime(time.Hour))
+	assert.True(t, defaults.AllowsPeer(&net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5000}))
+
+	a := NewAllocation(nil, nil, nil)
+	a.SetSessionPolicy(policy)
+	assert.Equal(t, policy, a.SessionPolicy())
+}
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v3/metrics"
+)
+
+// BandwidthLimiter is a token bucket limiting relayed bytes per second. The bucket holds
+// one second worth of bytes, a packet larger than that passes if the bucket is full.
+// A BandwidthLimiter may be shared by several allocations, e.g. all allocations of a user
+type BandwidthLimiter struct {
+	rate float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBandwidthLimiter creates a BandwidthLimiter for bytesPerSecond
+func NewBandwidthLimiter(bytesPerSecond int) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// BytesPerSecond returns the limit
+func (l *BandwidthLimiter) BytesPerSecond() int {
+	return int(l.rate)
+}
+
+// Allow takes n bytes from the bucket and returns false, taking nothing, if there aren't
+// enough. Used for datagrams, which are dropped over the limit
+func (l *BandwidthLimiter) Allow(n int) bool {
+	return l.allow(n, time.Now())
+}
+
+func (l *BandwidthLimiter) allow(n int, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.refill(now)
+	if l.tokens < float64(n) && l.tokens < l.rate {
+		return false
+	}
+	l.tokens -= float64(n)
+
+	return true
+}
+
+// Reserve takes n bytes from the bucket and returns how long to wait until they are
+// available. Used for streams, which are slowed down to the limit
+func (l *BandwidthLimiter) Reserve(n int) time.Duration {
+	return l.reserve(n, time.Now())
+}
+
+func (l *BandwidthLimiter) reserve(n int, now time.Time) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.refill(now)
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+func (l *BandwidthLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+}
+
+// SetBandwidthLimiters limits the bytes relayed by the allocation in both directions with
+// limiters. release, if not nil, is called once the allocation is closed
+func (a *Allocation) SetBandwidthLimiters(release func(), limiters ...*BandwidthLimiter) {
+	a.bandwidthLock.Lock()
+	defer a.bandwidthLock.Unlock()
+
+	a.bandwidthLimiters = limiters
+	a.bandwidthRelease = release
+}
+
+// AllowRelay returns false if relaying a datagram of n bytes exceeds a bandwidth limit of
+// the allocation
+func (a *Allocation) AllowRelay(n int) bool {
+	a.bandwidthLock.RLock()
+	defer a.bandwidthLock.RUnlock()
+
+	for _, l := range a.bandwidthLimiters {
+		if !l.Allow(n) {
+			a.log.Tracef("Dropped %d bytes over the bandwidth limit of %v", n, a.RelayAddr)
+			return false
+		}
+	}
+
+	return true
+}
+
+// waitRelay blocks until n bytes may be relayed on a TCP connection of the allocation
+func (a *Allocation) waitRelay(n int) {
+	a.bandwidthLock.RLock()
+	var wait time.Duration
+	for _, l := range a.bandwidthLimiters {
+		if d := l.Reserve(n); d > wait {
+			wait = d
+		}
+	}
+	a.bandwidthLock.RUnlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+func (a *Allocation) releaseBandwidthLimiters() {
+	a.bandwidthLock.Lock()
+	release := a.bandwidthRelease
+	a.bandwidthLimiters, a.bandwidthRelease = nil, nil
+	a.bandwidthLock.Unlock()
+
+	if release != nil {
+		release()
+	}
+}
+
+// relayReader returns r limited to the bandwidth limits of the allocation, counting the
+// bytes read as relayed in direction. r is returned as is without limits and metrics, so
+// io.Copy can still splice
+func (a *Allocation) relayReader(r io.Reader, direction metrics.Direction) io.Reader {
+	a.bandwidthLock.RLock()
+	defer a.bandwidthLock.RUnlock()
+
+	if len(a.bandwidthLimiters) == 0 && a.metrics == nil {
+		return r
+	}
+
+	return &relayReader{Reader: r, allocation: a, direction: direction}
+}
+
+// relayReader limits and counts the bytes read from a TCP connection of an allocation
+type relayReader struct {
+	io.Reader
+	allocation *Allocation
+	direction  metrics.Direction
+}
+
+func (r *relayReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.allocation.waitRelay(n)
+		r.allocation.metrics.RelayedStream(r.direction, n)
+	}
+
+	return n, err
+}
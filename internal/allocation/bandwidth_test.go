@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package allocation
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBandwidthLimiter(t *testing.T) {
+	t.Run("Allow", func(t *testing.T) {
+		l := NewBandwidthLimiter(1000)
+		now := l.last
+
+		assert.True(t, l.allow(600, now))
+		assert.False(t, l.allow(600, now))
+		assert.True(t, l.allow(400, now))
+
+		// Bytes are refilled at the limit
+		now = now.Add(500 * time.Millisecond)
+		assert.True(t, l.allow(500, now))
+		assert.False(t, l.allow(1, now))
+
+		// Packets larger than the bucket pass if it is full
+		now = now.Add(time.Hour)
+		assert.True(t, l.allow(1500, now))
+		assert.False(t, l.allow(1, now.Add(400*time.Millisecond)))
+	})
+
+	t.Run("Reserve", func(t *testing.T) {
+		l := NewBandwidthLimiter(1000)
+		now := l.last
+
+		assert.Equal(t, time.Duration(0), l.reserve(1000, now))
+		assert.Equal(t, 500*time.Millisecond, l.reserve(500, now))
+		assert.Equal(t, time.Second, l.reserve(500, now))
+	})
+}
+
+func TestAllocationBandwidthLimiters(t *testing.T) {
+	a := NewAllocation(nil, nil, logging.NewDefaultLoggerFactory().NewLogger("test"))
+	a.RelayAddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+
+	// Unlimited until limiters are set, readers aren't wrapped then
+	reader := bytes.NewReader([]byte("data"))
+	assert.True(t, a.AllowRelay(1<<20))
+	assert.Equal(t, reader, a.relayReader(reader, metrics.DirectionToPeer))
+
+	released := 0
+	allocationLimiter, userLimiter := NewBandwidthLimiter(1000), NewBandwidthLimiter(100)
+	a.SetBandwidthLimiters(func() { released++ }, allocationLimiter, userLimiter)
+	assert.True(t, a.AllowRelay(100))
+	assert.False(t, a.AllowRelay(100))
+
+	data, err := ioutil.ReadAll(a.relayReader(reader, metrics.DirectionToPeer))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	a.releaseBandwidthLimiters()
+	a.releaseBandwidthLimiters()
+	assert.Equal(t, 1, released)
+	assert.True(t, a.AllowRelay(1<<20))
+}
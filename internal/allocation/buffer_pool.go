@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"sync"
+
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+const (
+	// bufferHeadroom is the room kept in front of the datagrams read into the buffers of a
+	// BufferPool, so the ChannelData header can be written in place
+	bufferHeadroom = proto.ChannelDataHeaderSize
+
+	// bufferOverhead is the most a datagram grows by when it is relayed to the client: the
+	// header of a Data indication, its XOR-PEER-ADDRESS of an IPv6 peer and the padding of
+	// its DATA
+	bufferOverhead = stunHeaderSize + 4 + 20 + 4 + proto.ChannelDataPadding
+
+	stunHeaderSize = 20
+)
+
+// BufferPool recycles the buffers datagrams are relayed in, so relaying doesn't allocate
+// per datagram. The buffers of a BufferPool hold datagrams of up to Size bytes, plus the
+// headers and padding they are relayed with.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewBufferPool creates a BufferPool of buffers for datagrams of size bytes. size defaults
+// to 1600 bytes if 0 or negative
+func NewBufferPool(size int) *BufferPool {
+	if size <= 0 {
+		size = rtpMTU
+	}
+
+	p := &BufferPool{size: size}
+	p.pool.New = func() interface{} {
+		b := make([]byte, size+bufferOverhead)
+		return &b
+	}
+	return p
+}
+
+// Size returns the size of the largest datagram the buffers hold
+func (p *BufferPool) Size() int {
+	return p.size
+}
+
+// Get returns a buffer of the pool
+func (p *BufferPool) Get() *[]byte {
+	return p.pool.Get().(*[]byte) //nolint:forcetypeassert
+}
+
+// Put returns b to the pool. b must not be used afterwards
+func (p *BufferPool) Put(b *[]byte) {
+	*b = (*b)[:cap(*b)]
+	p.pool.Put(b)
+}
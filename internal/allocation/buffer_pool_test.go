@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package allocation
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferPool(t *testing.T) {
+	t.Run("Size", func(t *testing.T) {
+		assert.Equal(t, rtpMTU, NewBufferPool(0).Size())
+		assert.Equal(t, rtpMTU, NewBufferPool(-1).Size())
+
+		p := NewBufferPool(9000)
+		assert.Equal(t, 9000, p.Size())
+
+		b := p.Get()
+		assert.Equal(t, 9000+bufferOverhead, len(*b))
+
+		// Buffers are returned whole, whatever they were resliced to
+		*b = (*b)[:10]
+		p.Put(b)
+		assert.Equal(t, 9000+bufferOverhead, len(*b))
+	})
+
+	t.Run("DataIndication", func(t *testing.T) {
+		p := NewBufferPool(100)
+		peer := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}
+		data := make([]byte, p.Size())
+		m := &stun.Message{}
+		indication := stun.NewType(stun.MethodData, stun.ClassIndication)
+
+		// A Data indication of the largest datagram fits the buffers
+		assert.NoError(t, buildDataIndication(m, indication, peer, data))
+		assert.Equal(t, p.Size()+bufferOverhead, len(m.Raw)+proto.ChannelDataPadding)
+
+		// and is built without allocating once m has grown
+		allocs := testing.AllocsPerRun(10, func() {
+			assert.NoError(t, buildDataIndication(m, indication, peer, data[:10]))
+		})
+		assert.Zero(t, allocs)
+
+		decoded := &stun.Message{Raw: append([]byte{}, m.Raw...)}
+		assert.NoError(t, decoded.Decode())
+		assert.Equal(t, indication, decoded.Type)
+
+		var peerAddr proto.PeerAddress
+		assert.NoError(t, peerAddr.GetFrom(decoded))
+		assert.Equal(t, peer.String(), (&net.UDPAddr{IP: peerAddr.IP, Port: peerAddr.Port}).String())
+
+		var decodedData proto.Data
+		assert.NoError(t, decodedData.GetFrom(decoded))
+		assert.Equal(t, data[:10], []byte(decodedData))
+	})
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// SetCredentials records the username and key the allocation was created with
+func (a *Allocation) SetCredentials(username string, key []byte) {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	a.username = username
+	a.key = append([]byte{}, key...)
+}
+
+// Username returns the username the allocation was created with
+func (a *Allocation) Username() string {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	return a.username
+}
+
+// RequireReauthentication makes the next Reauthenticate fail unless it presents a
+// key other than the one the allocation currently holds
+func (a *Allocation) RequireReauthentication() {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	a.reauthRequired = true
+	a.cachedCredentials = nil
+}
+
+// ReauthenticationRequired returns true if the allocation has to present a new key before
+// its data is relayed again
+func (a *Allocation) ReauthenticationRequired() bool {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	return a.reauthRequired
+}
+
+// Reauthenticate checks the key of a request on the allocation. It returns false if
+// re-authentication was required and key is the key the allocation holds. Otherwise
+// key replaces it and the requirement is cleared
+func (a *Allocation) Reauthenticate(key []byte) bool {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	if a.reauthRequired && bytes.Equal(a.key, key) {
+		return false
+	}
+
+	a.key = append([]byte{}, key...)
+	a.reauthRequired = false
+
+	return true
+}
+
+// Integrity returns the proto.Integrity of key. It is kept with the allocation, so the
+// HMAC key is only precomputed again when the client authenticates with another key
+func (a *Allocation) Integrity(key []byte) *proto.Integrity {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	if a.integrity == nil || !bytes.Equal(a.integrity.Key(), key) {
+		a.integrity = proto.NewIntegrity(key)
+	}
+	return a.integrity
+}
+
+// cachedCredentials are the credentials the requests of an allocation were last
+// authenticated with
+type cachedCredentials struct {
+	username   string
+	realm      string
+	generation uint64
+	integrity  *proto.Integrity
+	expires    time.Time
+}
+
+// CacheCredentials remembers that username and realm authenticated with the key of
+// integrity, until generation changes, re-authentication is required or the credential
+// expires. A zero expires never expires
+func (a *Allocation) CacheCredentials(username, realm string, generation uint64, integrity *proto.Integrity, expires time.Time) {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	a.cachedCredentials = &cachedCredentials{username: username, realm: realm, generation: generation, integrity: integrity, expires: expires}
+}
+
+// CachedCredentials returns the proto.Integrity cached for username and realm in
+// generation, nil if there is none or the credential expired before now
+func (a *Allocation) CachedCredentials(username, realm string, generation uint64, now time.Time) *proto.Integrity {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	c := a.cachedCredentials
+	if c == nil || c.username != username || c.realm != realm || c.generation != generation {
+		return nil
+	} else if !c.expires.IsZero() && c.expires.Before(now) {
+		return nil
+	}
+	return c.integrity
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+
+	"github.com/pion/turn/v3/metrics"
+)
+
+// EnableDontFragment sets the DF bit on all datagrams the allocation relays to peers, for
+// an Allocate request with DONT-FRAGMENT. RFC 5766 Section 6.2
+func (a *Allocation) EnableDontFragment() error {
+	if a.RelaySocket == nil {
+		return ErrDontFragmentUnsupported
+	}
+
+	a.dontFragmentLock.Lock()
+	defer a.dontFragmentLock.Unlock()
+
+	for _, relaySocket := range a.relaySockets() {
+		if _, err := setDontFragment(relaySocket); err != nil {
+			return err
+		}
+	}
+	a.dontFragment = true
+
+	return nil
+}
+
+// WriteToDontFragment relays p to the peer with the DF bit set, for a Send indication with
+// DONT-FRAGMENT. RFC 5766 Section 10.2
+//
+// Unless DONT-FRAGMENT was enabled for the whole allocation the DF bit is only set on the
+// relay socket for the duration of the write. Datagrams relayed concurrently from
+// ChannelData may be sent with the DF bit set too.
+func (a *Allocation) WriteToDontFragment(p []byte, addr net.Addr) (int, error) {
+	if a.RelaySocket == nil {
+		return 0, ErrDontFragmentUnsupported
+	}
+	if a.isEchoPeer(addr) {
+		return a.echo(p, a.echoPeer)
+	}
+
+	relaySocket := a.relaySocketFor(addr)
+
+	a.dontFragmentLock.Lock()
+	defer a.dontFragmentLock.Unlock()
+
+	if a.dontFragment {
+		return a.writeTo(relaySocket, p, addr, metrics.DirectionToPeer)
+	}
+
+	restore, err := setDontFragment(relaySocket)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := a.writeTo(relaySocket, p, addr, metrics.DirectionToPeer)
+	if restoreErr := restore(); err == nil {
+		err = restoreErr
+	}
+
+	return n, err
+}
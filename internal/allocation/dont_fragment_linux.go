@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the DF bit on all datagrams sent on conn with IP_PMTUDISC_DO, and
+// returns a func restoring the previous setting
+func setDontFragment(conn net.PacketConn) (func() error, error) {
+	syscallConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, ErrDontFragmentUnsupported
+	}
+
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	level, option, value := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		level, option, value = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO
+	}
+
+	var previous int
+	var sockErr error
+	if err = rawConn.Control(func(fd uintptr) {
+		if previous, sockErr = syscall.GetsockoptInt(int(fd), level, option); sockErr == nil {
+			sockErr = syscall.SetsockoptInt(int(fd), level, option, value)
+		}
+	}); err != nil {
+		return nil, err
+	} else if sockErr != nil {
+		return nil, sockErr
+	}
+
+	return func() error {
+		if err := rawConn.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), level, option, previous)
+		}); err != nil {
+			return err
+		}
+
+		return sockErr
+	}, nil
+}
@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mtuDiscover(t *testing.T, conn *net.UDPConn) int {
+	rawConn, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var value int
+	var sockErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER)
+	}))
+	require.NoError(t, sockErr)
+
+	return value
+}
+
+func TestDontFragment(t *testing.T) {
+	relaySocket, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, relaySocket.Close())
+	}()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, peer.Close())
+	}()
+
+	a := &Allocation{RelaySocket: relaySocket}
+	previous := mtuDiscover(t, relaySocket)
+	require.NotEqual(t, syscall.IP_PMTUDISC_DO, previous)
+
+	// A single write restores the previous setting
+	n, err := a.WriteToDontFragment([]byte("df"), peer.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, previous, mtuDiscover(t, relaySocket))
+
+	assert.NoError(t, a.EnableDontFragment())
+	assert.Equal(t, syscall.IP_PMTUDISC_DO, mtuDiscover(t, relaySocket))
+
+	_, err = a.WriteToDontFragment([]byte("df"), peer.LocalAddr())
+	assert.NoError(t, err)
+	assert.Equal(t, syscall.IP_PMTUDISC_DO, mtuDiscover(t, relaySocket))
+
+	buf := make([]byte, 8)
+	for i := 0; i < 2; i++ {
+		n, _, err = peer.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "df", string(buf[:n]))
+	}
+}
+
+func TestDontFragmentUnsupported(t *testing.T) {
+	a := &Allocation{}
+	assert.ErrorIs(t, a.EnableDontFragment(), ErrDontFragmentUnsupported)
+
+	_, err := a.WriteToDontFragment([]byte("df"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000})
+	assert.ErrorIs(t, err, ErrDontFragmentUnsupported)
+}
@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package allocation
+
+import "net"
+
+// setDontFragment is only implemented on Linux
+func setDontFragment(net.PacketConn) (func() error, error) {
+	return nil, ErrDontFragmentUnsupported
+}
@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"sync/atomic"
+
+	"github.com/pion/turn/v3/internal/ipnet"
+)
+
+// SetDSCP marks the packets the allocation relays to peers with dscp: the datagrams of the
+// relay socket of UDP allocations, and the peer connections of TCP allocations
+func (a *Allocation) SetDSCP(dscp int) error {
+	var err error
+	for _, relaySocket := range a.relaySockets() {
+		if err = ipnet.SetDSCP(relaySocket, dscp); err != nil {
+			return err
+		}
+	}
+	if a.RelayListener != nil {
+		// Accepted peer connections inherit the DSCP of the listener
+		if err = ipnet.SetDSCP(a.RelayListener, dscp); err != nil {
+			return err
+		}
+	}
+
+	atomic.StoreInt32(&a.dscp, int32(dscp))
+
+	a.tcpConnectionsLock.RLock()
+	defer a.tcpConnectionsLock.RUnlock()
+	for _, c := range a.tcpConnections {
+		if err = ipnet.SetDSCP(c.peerConn, dscp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DSCP returns the DSCP of the packets the allocation relays to peers, 0 if unmarked
+func (a *Allocation) DSCP() int {
+	return int(atomic.LoadInt32(&a.dscp))
+}
+
+// setDefaultDSCP marks a with the DSCP of the manager, if set
+func (m *Manager) setDefaultDSCP(a *Allocation) {
+	if m.dscp == 0 {
+		return
+	}
+
+	if err := a.SetDSCP(m.dscp); err != nil {
+		m.log.Warnf("Failed to set DSCP %d on relay %v: %v", m.dscp, a.RelayAddr, err)
+	}
+}
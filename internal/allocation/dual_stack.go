@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+	"time"
+
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// CreateDualStackAllocation creates a new allocation with an IPv4 relayed transport
+// address and an additional IPv6 one, for an Allocate request with
+// ADDITIONAL-ADDRESS-FAMILY, and starts relaying. RFC 8656 Section 7.2
+//
+// The allocation is created without the IPv6 relay if it can't be allocated, additionalErr
+// is its error then
+func (m *Manager) CreateDualStackAllocation(fiveTuple *FiveTuple, turnSocket net.PacketConn, requestedPort int, lifetime time.Duration) (a *Allocation, additionalErr error, err error) { //nolint:revive,stylecheck
+	return m.createAllocation(fiveTuple, turnSocket, proto.RequestedFamilyIPv4, requestedPort, lifetime, true)
+}
+
+// allocateAdditionalRelay allocates the IPv6 relay of a
+func (m *Manager) allocateAdditionalRelay(a *Allocation) error {
+	conn, relayAddr, err := m.allocatePacketConn(network(UDP, proto.RequestedFamilyIPv6), 0)
+	if err != nil {
+		return err
+	}
+
+	a.AdditionalRelaySocket = conn
+	a.AdditionalRelayAddr = relayAddr
+	m.log.Debugf("Listening on additional relay address: %s", relayAddr)
+
+	return nil
+}
+
+// relaySockets returns the relay sockets of a UDP allocation, none for TCP allocations
+func (a *Allocation) relaySockets() []net.PacketConn {
+	switch {
+	case a.RelaySocket == nil:
+		return nil
+	case a.AdditionalRelaySocket == nil:
+		return []net.PacketConn{a.RelaySocket}
+	default:
+		return []net.PacketConn{a.RelaySocket, a.AdditionalRelaySocket}
+	}
+}
+
+// relaySocketFor returns the relay socket of the address family of peer
+func (a *Allocation) relaySocketFor(peer net.Addr) net.PacketConn {
+	if a.AdditionalRelaySocket != nil {
+		if udpAddr, ok := peer.(*net.UDPAddr); ok && AddressFamily(udpAddr.IP) == proto.RequestedFamilyIPv6 {
+			return a.AdditionalRelaySocket
+		}
+	}
+
+	return a.RelaySocket
+}
+
+// closeRelaySockets closes the relay sockets of a UDP allocation
+func (a *Allocation) closeRelaySockets() error {
+	errs := []error{}
+	for _, relaySocket := range a.relaySockets() {
+		errs = append(errs, relaySocket.Close())
+	}
+
+	return JoinErrors(errs...)
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/metrics"
+)
+
+// isEchoPeer returns true if addr is the echo peer of the allocation
+func (a *Allocation) isEchoPeer(addr net.Addr) bool {
+	if a.echoPeer == nil {
+		return false
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	return ok && udpAddr.Port == a.echoPeer.Port && udpAddr.IP.Equal(a.echoPeer.IP)
+}
+
+// echo sends p back to the client as if the echo peer relayed it, as ChannelData if a
+// channel is bound to the echo peer and in a Data indication otherwise. It allocates for
+// every datagram, unlike the relay of peers
+func (a *Allocation) echo(p []byte, peer *net.UDPAddr) (int, error) {
+	if !a.AllowRelay(len(p)) {
+		return len(p), nil
+	}
+
+	var raw []byte
+	channel := a.GetChannelByAddr(peer)
+	if channel != nil {
+		channelData := proto.ChannelData{
+			Raw:    make([]byte, bufferHeadroom+len(p)),
+			Number: channel.Number,
+		}
+		copy(channelData.Raw[bufferHeadroom:], p)
+		channelData.EncodeInPlace()
+		raw = channelData.Raw
+	} else {
+		dataIndication := &stun.Message{}
+		if err := buildDataIndication(dataIndication, stun.NewType(stun.MethodData, stun.ClassIndication), peer, p); err != nil {
+			return 0, err
+		}
+		raw = dataIndication.Raw
+	}
+
+	if _, err := a.writeToClient(raw); err != nil {
+		return 0, err
+	}
+	a.CountRelayed(metrics.DirectionToClient, len(p))
+	if channel == nil {
+		a.CountRelayedIndication(metrics.DirectionToClient)
+	}
+
+	return len(p), nil
+}
@@ -5,6 +5,18 @@ package allocation
 
 import "errors"
 
+// ErrMobilityTicketInvalid is returned by Manager.MoveAllocation if no allocation holds the
+// mobility ticket
+var ErrMobilityTicketInvalid = errors.New("allocations: invalid MOBILITY-TICKET")
+
+// ErrDontFragmentUnsupported is returned if the DF bit can't be set on the relay socket of
+// an allocation, because the platform or the net.PacketConn doesn't support it
+var ErrDontFragmentUnsupported = errors.New("allocations: DONT-FRAGMENT is not supported")
+
+// ErrUsernameMismatch is returned by Manager.RestoreAllocation if the replicated allocation
+// belongs to another username than the request restoring it
+var ErrUsernameMismatch = errors.New("allocations: username doesn't match the replicated allocation")
+
 var (
 	errAllocatePacketConnMustBeSet = errors.New("AllocatePacketConn must be set")
 	errAllocateConnMustBeSet       = errors.New("AllocateConn must be set")
@@ -19,4 +31,22 @@ var (
 	errFailedToCastUDPAddr         = errors.New("failed to cast net.Addr to *net.UDPAddr")
 	errFailedToAllocateEvenPort    = errors.New("failed to allocate an even port")
 	errAdminProhibited             = errors.New("permission request administratively prohibited")
+	errDialPeerMustBeSet           = errors.New("DialPeer must be set if AllocateListener is set")
+	errTCPAllocationsDisabled      = errors.New("TCP allocations are disabled")
+	errNotTCPAllocation            = errors.New("allocation is not a TCP allocation")
+	errAllocationClosed            = errors.New("allocation is closed")
+	errRelayAddressMismatch        = errors.New("replicated allocation restored on another relayed address")
 )
+
+// Errors reported by Connect and BindTCPConnection, checked with errors.Is by the server
+// to pick the error code of the response
+var (
+	ErrConnectionAlreadyExists = errors.New("connection to peer already exists")
+	ErrConnectionFailed        = errors.New("connection to peer failed")
+	ErrNoSuchTCPConnection     = errors.New("no such connection")
+)
+
+// ErrAddressFamilyNotSupported is returned by relay address generators that can't allocate
+// relays of the requested address family. The server answers with 440 (Address Family not
+// Supported), see RFC 6156 Section 4.2
+var ErrAddressFamilyNotSupported = errors.New("address family not supported")
@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+	"time"
+)
+
+// Info identifies an allocation in lifecycle events
+type Info struct {
+	// Username is the USERNAME the allocation was created with
+	Username string
+	// FiveTuple is the client address, server address and transport protocol of the
+	// allocation
+	FiveTuple FiveTuple
+	// RelayAddr is the relayed transport address of the allocation
+	RelayAddr net.Addr
+	// Listener is the name of the listener the client connected to
+	Listener string
+}
+
+// Info returns the identity of the allocation
+func (a *Allocation) Info() Info {
+	return Info{
+		Username:  a.Username(),
+		FiveTuple: *a.getFiveTuple(),
+		RelayAddr: a.RelayAddr,
+		Listener:  a.Listener,
+	}
+}
+
+// Events are the callbacks for the lifecycle events of allocations. All callbacks are
+// optional. The methods of a nil Events do nothing
+type Events struct {
+	// OnAllocationCreated is called when an Allocate request created an allocation
+	OnAllocationCreated func(info Info)
+	// OnAllocationRefreshed is called when a Refresh request extended the lifetime of an
+	// allocation
+	OnAllocationRefreshed func(info Info, lifetime time.Duration)
+	// OnAllocationDeleted is called when an allocation is deleted, expires or is closed
+	// with the server
+	OnAllocationDeleted func(info Info)
+	// OnPermissionCreated is called when a CreatePermission or ChannelBind request
+	// installed a permission for a peer that had none
+	OnPermissionCreated func(info Info, peer net.Addr)
+	// OnChannelBound is called when a ChannelBind request bound a new channel
+	OnChannelBound func(info Info, peer net.Addr, channel uint16)
+}
+
+// AllocationCreated calls OnAllocationCreated for a
+func (e *Events) AllocationCreated(a *Allocation) {
+	if e != nil && e.OnAllocationCreated != nil {
+		e.OnAllocationCreated(a.Info())
+	}
+}
+
+// AllocationRefreshed calls OnAllocationRefreshed for a
+func (e *Events) AllocationRefreshed(a *Allocation, lifetime time.Duration) {
+	if e != nil && e.OnAllocationRefreshed != nil {
+		e.OnAllocationRefreshed(a.Info(), lifetime)
+	}
+}
+
+// AllocationDeleted calls OnAllocationDeleted for a
+func (e *Events) AllocationDeleted(a *Allocation) {
+	if e != nil && e.OnAllocationDeleted != nil {
+		e.OnAllocationDeleted(a.Info())
+	}
+}
+
+// PermissionCreated calls OnPermissionCreated for a
+func (e *Events) PermissionCreated(a *Allocation, peer net.Addr) {
+	if e != nil && e.OnPermissionCreated != nil {
+		e.OnPermissionCreated(a.Info(), peer)
+	}
+}
+
+// ChannelBound calls OnChannelBound for a
+func (e *Events) ChannelBound(a *Allocation, peer net.Addr, channel uint16) {
+	if e != nil && e.OnChannelBound != nil {
+		e.OnChannelBound(a.Info(), peer, channel)
+	}
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ExpiredPolicy controls what happens to peer traffic that arrives on the relay
+// socket of an allocation after its lifetime has expired
+type ExpiredPolicy int
+
+const (
+	// ExpiredPolicyUnreachable closes the relay socket as soon as the allocation
+	// expires, so the operating system answers late peer packets with an ICMP
+	// port unreachable
+	ExpiredPolicyUnreachable ExpiredPolicy = iota
+	// ExpiredPolicyDrop keeps the relay socket open for the grace period and
+	// silently drops late peer packets
+	ExpiredPolicyDrop
+	// ExpiredPolicyCount is like ExpiredPolicyDrop, but late peer packets are
+	// counted and logged
+	ExpiredPolicyCount
+)
+
+// DefaultExpiredGracePeriod is for how long the relay socket of an expired
+// allocation is kept open if the ExpiredPolicy requires it
+const DefaultExpiredGracePeriod = 30 * time.Second
+
+// ExpiredPackets returns the number of peer packets that arrived on the relay socket
+// of an expired allocation. Packets are only counted with ExpiredPolicyCount
+func (m *Manager) ExpiredPackets() uint64 {
+	return atomic.LoadUint64(&m.expiredPackets)
+}
+
+// expireAllocation is called when the lifetime of a expires
+func (m *Manager) expireAllocation(a *Allocation) {
+	fingerprint := a.getFiveTuple().Fingerprint()
+
+	m.lock.Lock()
+	if m.allocations[fingerprint] != a {
+		m.lock.Unlock()
+		return
+	}
+	delete(m.allocations, fingerprint)
+	m.removeMobilityTicket(a)
+	m.metrics.AllocationDeleted()
+	defer m.events.AllocationDeleted(a)
+
+	// TCP allocations have no relay socket that peers could still send to
+	if m.expiredPolicy == ExpiredPolicyUnreachable || a.RelaySocket == nil {
+		m.lock.Unlock()
+		if err := a.Close(); err != nil {
+			m.log.Errorf("Failed to close allocation: %v", err)
+		}
+		return
+	}
+
+	// Keep reading from the relay socket until the grace period is over,
+	// packetHandler discards everything once the allocation is closed
+	m.expired[a] = struct{}{}
+	m.lock.Unlock()
+
+	a.stop()
+	m.log.Debugf("Allocation %v expired, keeping relay socket %s open for %v", a.getFiveTuple(), a.RelayAddr, m.expiredGracePeriod)
+
+	m.afterFunc(m.expiredGracePeriod, func() {
+		m.lock.Lock()
+		_, ok := m.expired[a]
+		delete(m.expired, a)
+		m.lock.Unlock()
+
+		if !ok {
+			return
+		}
+
+		if err := a.closeRelaySockets(); err != nil {
+			m.log.Errorf("Failed to close relay socket of expired allocation: %v", err)
+		}
+	})
+}
+
+// handleExpiredPacket is called for every packet received after a was closed
+func (m *Manager) handleExpiredPacket(a *Allocation, srcAddr net.Addr, n int) {
+	if m.expiredPolicy != ExpiredPolicyCount {
+		return
+	}
+
+	atomic.AddUint64(&m.expiredPackets, 1)
+	m.log.Debugf("Dropped %d bytes from %v on relay socket %s of expired allocation %v", n, srcAddr, a.RelayAddr, a.getFiveTuple())
+}
@@ -4,8 +4,10 @@
 package allocation
 
 import (
-	"fmt"
 	"net"
+	"strconv"
+
+	"github.com/pion/turn/v3/internal/ipnet"
 )
 
 // Protocol is an enum for relay protocol
@@ -33,7 +35,20 @@ func (f *FiveTuple) Equal(b *FiveTuple) bool {
 	return f.Fingerprint() == b.Fingerprint()
 }
 
+// fingerprintSize fits the Fingerprint of FiveTuples of IPv6 addresses
+const fingerprintSize = 128
+
 // Fingerprint is the identity of a FiveTuple
 func (f *FiveTuple) Fingerprint() string {
-	return fmt.Sprintf("%d_%s_%s", f.Protocol, f.SrcAddr.String(), f.DstAddr.String())
+	return string(f.AppendFingerprint(make([]byte, 0, fingerprintSize)))
+}
+
+// AppendFingerprint appends the Fingerprint of the FiveTuple to b. Maps keyed by
+// Fingerprint can be looked up with string(AppendFingerprint(buf[:0])) without allocating
+func (f *FiveTuple) AppendFingerprint(b []byte) []byte {
+	b = strconv.AppendUint(b, uint64(f.Protocol), 10)
+	b = append(b, '_')
+	b = ipnet.AppendAddr(b, f.SrcAddr)
+	b = append(b, '_')
+	return ipnet.AppendAddr(b, f.DstAddr)
 }
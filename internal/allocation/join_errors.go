@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"errors"
+	"strings"
+)
+
+// JoinErrors is errors.Join, which isn't available before Go 1.20. It returns nil if all
+// errs are nil, the error message has the message of every error on its own line
+func JoinErrors(errs ...error) error {
+	joined := &joinedError{}
+	for _, err := range errs {
+		if err != nil {
+			joined.errs = append(joined.errs, err)
+		}
+	}
+
+	if len(joined.errs) == 0 {
+		return nil
+	}
+	return joined
+}
+
+type joinedError struct {
+	errs []error
+}
+
+func (e *joinedError) Error() string {
+	messages := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		messages[i] = err.Error()
+	}
+
+	return strings.Join(messages, "\n")
+}
+
+// Unwrap returns the joined errors, for errors.Is and errors.As of Go 1.20 and later
+func (e *joinedError) Unwrap() []error {
+	return e.errs
+}
+
+// Is matches the joined errors with errors.Is before Go 1.20
+func (e *joinedError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
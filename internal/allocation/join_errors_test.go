@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinErrors(t *testing.T) {
+	assert.NoError(t, JoinErrors())
+	assert.NoError(t, JoinErrors(nil, nil))
+
+	errA, errB := errors.New("a"), errors.New("b")
+	err := JoinErrors(errA, nil, errB)
+	assert.Equal(t, "a\nb", err.Error())
+	assert.True(t, errors.Is(err, errA))
+	assert.True(t, errors.Is(err, errB))
+	assert.False(t, errors.Is(err, ErrMobilityTicketInvalid))
+}
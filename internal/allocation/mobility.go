@@ -0,0 +1,143 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+const mobilityTicketSize = 16
+
+// client returns the 5-tuple of the allocation and the socket its client is reached on.
+// Both change when the allocation is moved with a mobility ticket
+func (a *Allocation) client() (*FiveTuple, net.PacketConn) {
+	a.clientLock.RLock()
+	defer a.clientLock.RUnlock()
+
+	return a.fiveTuple, a.TurnSocket
+}
+
+func (a *Allocation) getFiveTuple() *FiveTuple {
+	fiveTuple, _ := a.client()
+	return fiveTuple
+}
+
+// CreateMobilityTicket creates a new RFC 8016 mobility ticket for the allocation, e.g. for
+// every success response to an Allocate request. The client may not have received the
+// ticket it replaces, which stays valid until it presents the new one
+func (m *Manager) CreateMobilityTicket(a *Allocation) (proto.MobilityTicket, error) {
+	ticket := make([]byte, mobilityTicketSize)
+	if _, err := rand.Read(ticket); err != nil {
+		return nil, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.replaceMobilityTicket(a, a.mobilityTicket, string(ticket))
+	return ticket, nil
+}
+
+// RenewMobilityTicket replaces the mobility ticket of the allocation presented by a
+// Refresh request with a new one for its success response. A client that presents the
+// latest ticket has received it and the older one is no longer valid. A client that
+// presents the older one, because the response with the latest was lost, keeps it valid
+// until it presents a new one. It returns ErrMobilityTicketInvalid if ticket is neither.
+func (m *Manager) RenewMobilityTicket(a *Allocation, ticket proto.MobilityTicket) (proto.MobilityTicket, error) {
+	renewed := make([]byte, mobilityTicketSize)
+	if _, err := rand.Read(renewed); err != nil {
+		return nil, err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	switch {
+	case len(ticket) == 0:
+		return nil, ErrMobilityTicketInvalid
+	case string(ticket) == a.mobilityTicket:
+		m.replaceMobilityTicket(a, a.mobilityTicket, string(renewed))
+	case string(ticket) == a.previousTicket:
+		m.replaceMobilityTicket(a, a.previousTicket, string(renewed))
+	default:
+		return nil, ErrMobilityTicketInvalid
+	}
+
+	return renewed, nil
+}
+
+// replaceMobilityTicket makes ticket the mobility ticket of a and previous the only older
+// one that is still valid, m.lock must be held
+func (m *Manager) replaceMobilityTicket(a *Allocation, previous, ticket string) {
+	m.removeMobilityTicket(a)
+
+	a.mobilityTicket, a.previousTicket = ticket, previous
+	m.mobilityTickets[ticket] = a
+	if previous != "" {
+		m.mobilityTickets[previous] = a
+	}
+}
+
+// HasMobilityTicket returns true if ticket is a valid mobility ticket of the allocation
+func (m *Manager) HasMobilityTicket(a *Allocation, ticket proto.MobilityTicket) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return len(ticket) != 0 && (string(ticket) == a.mobilityTicket || string(ticket) == a.previousTicket)
+}
+
+// MoveAllocation moves the allocation holding the mobility ticket to fiveTuple, so a client
+// whose address changed keeps its allocation. Data for the client is sent on turnSocket
+// afterwards. It returns ErrMobilityTicketInvalid if no allocation holds the ticket, or if
+// it was created by a user other than username.
+func (m *Manager) MoveAllocation(ticket proto.MobilityTicket, fiveTuple *FiveTuple, turnSocket net.PacketConn, username string) (*Allocation, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	a, ok := m.mobilityTickets[string(ticket)]
+	if !ok || a.Username() != username {
+		return nil, ErrMobilityTicketInvalid
+	}
+
+	fingerprint := a.getFiveTuple().Fingerprint()
+	if fingerprint == fiveTuple.Fingerprint() {
+		return a, nil
+	} else if _, ok := m.allocations[fiveTuple.Fingerprint()]; ok {
+		return nil, fmt.Errorf("%w: %v", errDupeFiveTuple, fiveTuple)
+	}
+
+	delete(m.allocations, fingerprint)
+	m.allocations[fiveTuple.Fingerprint()] = a
+
+	// The offloaded channels are forwarded to the new 5-tuple
+	channels := a.channels()
+	for _, c := range channels {
+		a.removeOffload(c)
+	}
+
+	a.clientLock.Lock()
+	a.fiveTuple = fiveTuple
+	a.TurnSocket = turnSocket
+	a.clientLock.Unlock()
+
+	for _, c := range channels {
+		a.addOffload(c)
+	}
+
+	return a, nil
+}
+
+// removeMobilityTicket invalidates the tickets of a, m.lock must be held
+func (m *Manager) removeMobilityTicket(a *Allocation) {
+	if a.mobilityTicket != "" {
+		delete(m.mobilityTickets, a.mobilityTicket)
+	}
+	if a.previousTicket != "" {
+		delete(m.mobilityTickets, a.previousTicket)
+	}
+}
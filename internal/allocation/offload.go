@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+
+	"github.com/pion/turn/v3/offload"
+)
+
+// offloadRoute returns the Route of the channel c of a, and false if its ChannelData
+// can't be forwarded outside of the server: only plain UDP clients of UDP relays are
+// offloaded, DTLS clients need their ChannelData encrypted
+func (a *Allocation) offloadRoute(c *ChannelBind) (offload.Route, bool) {
+	// The echo peer only exists in the server
+	if a.offload == nil || a.ClientTransport != TransportUDP || a.RelaySocket == nil || a.isEchoPeer(c.Peer) {
+		return offload.Route{}, false
+	}
+
+	fiveTuple := a.getFiveTuple()
+	client, clientOK := fiveTuple.SrcAddr.(*net.UDPAddr)
+	server, serverOK := fiveTuple.DstAddr.(*net.UDPAddr)
+	relay, relayOK := a.relaySocketFor(c.Peer).LocalAddr().(*net.UDPAddr)
+	peer, peerOK := c.Peer.(*net.UDPAddr)
+	if !clientOK || !serverOK || !relayOK || !peerOK {
+		return offload.Route{}, false
+	}
+
+	return offload.Route{
+		Client:  client,
+		Server:  server,
+		Relay:   relay,
+		Peer:    peer,
+		Channel: uint16(c.Number),
+	}, true
+}
+
+// addOffload starts forwarding the ChannelData of c outside of the server. Channels the
+// offloader rejects are relayed by the server
+func (a *Allocation) addOffload(c *ChannelBind) {
+	route, ok := a.offloadRoute(c)
+	if !ok {
+		return
+	}
+
+	if err := a.offload.Add(route); err != nil {
+		a.log.Debugf("Failed to offload %v, relaying it: %v", route, err)
+	}
+}
+
+// removeOffload stops forwarding the ChannelData of c outside of the server
+func (a *Allocation) removeOffload(c *ChannelBind) {
+	route, ok := a.offloadRoute(c)
+	if !ok {
+		return
+	}
+
+	if err := a.offload.Remove(route); err != nil {
+		a.log.Warnf("Failed to remove offloaded %v: %v", route, err)
+	}
+}
+
+// channels returns the channel bindings of a
+func (a *Allocation) channels() []*ChannelBind {
+	a.channelBindingsLock.RLock()
+	defer a.channelBindingsLock.RUnlock()
+
+	return append([]*ChannelBind{}, a.channelBindings...)
+}
@@ -0,0 +1,23 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+// activator is implemented by relay sockets that are only bound on demand
+type activator interface {
+	Activate() error
+}
+
+// ActivateRelay binds the relay socket if it is only bound on demand. It is called before
+// a permission is installed
+func (a *Allocation) ActivateRelay() error {
+	for _, relaySocket := range a.relaySockets() {
+		if onDemand, ok := relaySocket.(activator); ok {
+			if err := onDemand.Activate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"sync/atomic"
+
+	"github.com/pion/turn/v3/metrics"
+)
+
+// SetMaxPacketSize caps the size of the datagrams the allocation relays in both directions,
+// larger ones are dropped. Unlimited if 0
+func (a *Allocation) SetMaxPacketSize(size int) {
+	atomic.StoreInt32(&a.maxPacketSize, int32(size))
+}
+
+// AllowPacketSize returns false if a datagram of n bytes relayed in direction exceeds the
+// maximum packet size of the allocation, and counts it as dropped
+func (a *Allocation) AllowPacketSize(n int, direction metrics.Direction) bool {
+	maxPacketSize := atomic.LoadInt32(&a.maxPacketSize)
+	if maxPacketSize == 0 || n <= int(maxPacketSize) {
+		return true
+	}
+
+	atomic.AddUint64(&a.stats.oversizedDrops[direction], 1)
+	a.metrics.OversizedDrop(direction)
+
+	return false
+}
@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+// PortRange is an inclusive range of ports
+type PortRange struct {
+	Min uint16
+	Max uint16
+}
+
+// Contains returns true if port is within the range
+func (r PortRange) Contains(port int) bool {
+	return port >= int(r.Min) && port <= int(r.Max)
+}
+
+// PermitsPeerPort returns true if relayed traffic may be sent to port of a peer. All ports
+// are permitted if ManagerConfig.PeerPorts is empty
+func (m *Manager) PermitsPeerPort(port int) bool {
+	if len(m.peerPorts) == 0 {
+		return true
+	}
+
+	for _, r := range m.peerPorts {
+		if r.Contains(port) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -5,13 +5,28 @@ package allocation
 
 import (
 	"net"
+	"sync"
 	"time"
 
 	"github.com/pion/logging"
+	"github.com/pion/turn/v3/internal/ipnet"
 )
 
 const permissionTimeout = time.Duration(5) * time.Minute
 
+// PermissionMode controls how inbound peer traffic is matched against permissions
+type PermissionMode int
+
+const (
+	// PermissionModeIP matches permissions on the IP address of the peer only,
+	// as required by RFC 5766 Section 8
+	PermissionModeIP PermissionMode = iota
+	// PermissionModeIPPort also requires the port of the peer to match one of the
+	// XOR-PEER-ADDRESSes the permission was created or refreshed with. This is not
+	// standard behavior
+	PermissionModeIPPort
+)
+
 // Permission represents a TURN permission. TURN permissions mimic the address-restricted
 // filtering mechanism of NATs that comply with [RFC4787].
 // See: https://tools.ietf.org/html/rfc5766#section-2.3
@@ -20,14 +35,45 @@ type Permission struct {
 	allocation    *Allocation
 	lifetimeTimer *time.Timer
 	log           logging.LeveledLogger
+
+	portsLock sync.RWMutex
+	ports     map[int]struct{}
 }
 
 // NewPermission create a new Permission
 func NewPermission(addr net.Addr, log logging.LeveledLogger) *Permission {
-	return &Permission{
+	p := &Permission{
 		Addr: addr,
 		log:  log,
 	}
+	p.addPort(addr)
+
+	return p
+}
+
+// addPort records the port of addr as one the permission was requested for
+func (p *Permission) addPort(addr net.Addr) {
+	if _, port, err := ipnet.AddrIPPort(addr); err == nil {
+		p.portsLock.Lock()
+		if p.ports == nil {
+			p.ports = map[int]struct{}{}
+		}
+		p.ports[port] = struct{}{}
+		p.portsLock.Unlock()
+	}
+}
+
+// hasPort returns true if the permission was requested for the port of addr
+func (p *Permission) hasPort(addr net.Addr) bool {
+	_, port, err := ipnet.AddrIPPort(addr)
+	if err != nil {
+		return false
+	}
+
+	p.portsLock.RLock()
+	defer p.portsLock.RUnlock()
+	_, ok := p.ports[port]
+	return ok
 }
 
 func (p *Permission) start(lifetime time.Duration) {
@@ -38,6 +84,6 @@ func (p *Permission) start(lifetime time.Duration) {
 
 func (p *Permission) refresh(lifetime time.Duration) {
 	if !p.lifetimeTimer.Reset(lifetime) {
-		p.log.Errorf("Failed to reset permission timer for %v %v", p.Addr, p.allocation.fiveTuple)
+		p.log.Errorf("Failed to reset permission timer for %v %v", p.Addr, p.allocation.getFiveTuple())
 	}
 }
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Resources are the resources a Manager holds, all of them are released once it is closed
+// and its handlers returned
+type Resources struct {
+	// Allocations, including expired and deleted ones whose relay sockets are open
+	Allocations int
+	// TCPConnections are the peer connections of TCP allocations
+	TCPConnections int
+	// Handlers are the goroutines relaying from relay sockets and TCP connections
+	Handlers int
+	// Timers are the reservation and grace period timers of the manager. The timers of
+	// allocations are stopped with them
+	Timers int
+}
+
+// IsZero returns true if no resources are held
+func (r Resources) IsZero() bool {
+	return r == Resources{}
+}
+
+// Resources returns the resources the manager holds
+func (m *Manager) Resources() Resources {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return Resources{
+		Allocations:    len(m.allocations) + len(m.expired) + len(m.closing),
+		TCPConnections: len(m.tcpConnections),
+		Handlers:       int(atomic.LoadInt64(&m.handlers)),
+		Timers:         len(m.timers),
+	}
+}
+
+// goHandler runs f in a goroutine counted in Resources
+func (m *Manager) goHandler(f func()) {
+	atomic.AddInt64(&m.handlers, 1)
+	go func() {
+		defer atomic.AddInt64(&m.handlers, -1)
+		f()
+	}()
+}
+
+// afterFunc is time.AfterFunc for the timers of the manager, which are stopped by Close
+func (m *Manager) afterFunc(d time.Duration, f func()) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		m.lock.Lock()
+		_, ok := m.timers[timer]
+		delete(m.timers, timer)
+		m.lock.Unlock()
+
+		if ok {
+			f()
+		}
+	})
+	m.timers[timer] = struct{}{}
+}
+
+// stopTimers stops the timers of the manager, m.lock must be held
+func (m *Manager) stopTimers() {
+	for timer := range m.timers {
+		timer.Stop()
+		delete(m.timers, timer)
+	}
+}
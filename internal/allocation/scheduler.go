@@ -0,0 +1,258 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v3/metrics"
+)
+
+const (
+	defaultSchedulerQuantum   = 1500
+	defaultSchedulerQueueSize = 64
+)
+
+// SchedulerConfig configures a Scheduler
+type SchedulerConfig struct {
+	// BytesPerSecond is the egress capacity datagrams are paced to. If 0 datagrams are sent
+	// as fast as the sockets accept them
+	BytesPerSecond int
+	// Quantum is the number of bytes an allocation may send per round. Defaults to 1500
+	Quantum int
+	// QueueSize is the number of datagrams queued per allocation, further datagrams are
+	// dropped. Defaults to 64
+	QueueSize int
+}
+
+type scheduledDatagram struct {
+	allocation *Allocation
+	direction  metrics.Direction
+	conn       net.PacketConn
+	addr       net.Addr
+	data       []byte
+	buffer     *[]byte // The buffer of data if it is of the pool
+}
+
+type schedulerQueue struct {
+	allocation *Allocation
+	datagrams  []scheduledDatagram
+	deficit    int
+}
+
+// Scheduler sends the datagrams relayed by allocations with deficit round robin, so every
+// allocation gets an equal share of the egress when it is saturated, instead of the
+// allocations sending the most starving the others
+type Scheduler struct {
+	quantum   int
+	queueSize int
+	limiter   *BandwidthLimiter
+	pool      *BufferPool
+	log       logging.LeveledLogger
+
+	lock   sync.Mutex
+	queues map[*Allocation]*schedulerQueue
+	active []*schedulerQueue
+
+	wake   chan struct{}
+	closed chan struct{}
+	done   chan struct{}
+}
+
+// NewScheduler creates a Scheduler and starts sending. The queued datagrams are copied
+// to buffers of pool, a pool of 1600 byte buffers is created if nil
+func NewScheduler(config SchedulerConfig, pool *BufferPool, log logging.LeveledLogger) *Scheduler {
+	s := newScheduler(config, pool, log)
+	go s.run()
+	return s
+}
+
+func newScheduler(config SchedulerConfig, pool *BufferPool, log logging.LeveledLogger) *Scheduler {
+	if pool == nil {
+		pool = NewBufferPool(rtpMTU)
+	}
+
+	s := &Scheduler{
+		quantum:   config.Quantum,
+		queueSize: config.QueueSize,
+		pool:      pool,
+		log:       log,
+		queues:    map[*Allocation]*schedulerQueue{},
+		wake:      make(chan struct{}, 1),
+		closed:    make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if s.quantum <= 0 {
+		s.quantum = defaultSchedulerQuantum
+	}
+	if s.queueSize <= 0 {
+		s.queueSize = defaultSchedulerQueueSize
+	}
+	if config.BytesPerSecond > 0 {
+		s.limiter = NewBandwidthLimiter(config.BytesPerSecond)
+	}
+
+	return s
+}
+
+// Enqueue queues a copy of p to be sent to addr on conn for a in direction. It returns false
+// if the queue of a is full and p was dropped
+func (s *Scheduler) Enqueue(a *Allocation, conn net.PacketConn, p []byte, addr net.Addr, direction metrics.Direction) bool {
+	s.lock.Lock()
+	q, ok := s.queues[a]
+	if !ok {
+		q = &schedulerQueue{allocation: a}
+		s.queues[a] = q
+		s.active = append(s.active, q)
+	}
+	if len(q.datagrams) >= s.queueSize {
+		s.lock.Unlock()
+		return false
+	}
+	d := s.copy(conn, p, addr)
+	d.allocation, d.direction = a, direction
+	q.datagrams = append(q.datagrams, d)
+	s.lock.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// Remove drops the queued datagrams of a
+func (s *Scheduler) Remove(a *Allocation) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if q, ok := s.queues[a]; ok {
+		for _, d := range q.datagrams {
+			s.release(d)
+		}
+		q.datagrams = nil
+	}
+}
+
+// copy copies p to a buffer of the pool, or to a new one if it doesn't fit
+func (s *Scheduler) copy(conn net.PacketConn, p []byte, addr net.Addr) scheduledDatagram {
+	d := scheduledDatagram{conn: conn, addr: addr}
+	if len(p) > s.pool.Size()+bufferOverhead {
+		d.data = append([]byte{}, p...)
+		return d
+	}
+
+	d.buffer = s.pool.Get()
+	d.data = (*d.buffer)[:copy(*d.buffer, p)]
+	return d
+}
+
+// release returns the buffer of d to the pool
+func (s *Scheduler) release(d scheduledDatagram) {
+	if d.buffer != nil {
+		s.pool.Put(d.buffer)
+	}
+}
+
+// Close stops sending, queued datagrams are dropped
+func (s *Scheduler) Close() {
+	select {
+	case <-s.closed:
+		return
+	default:
+	}
+	close(s.closed)
+	<-s.done
+}
+
+func (s *Scheduler) run() {
+	defer close(s.done)
+
+	for {
+		d, ok := s.next()
+		if !ok {
+			select {
+			case <-s.wake:
+				continue
+			case <-s.closed:
+				return
+			}
+		}
+
+		if s.limiter != nil {
+			if wait := s.limiter.Reserve(len(d.data)); wait > 0 {
+				select {
+				case <-time.After(wait):
+				case <-s.closed:
+					return
+				}
+			}
+		}
+
+		if _, err := d.allocation.writeTo(d.conn, d.data, d.addr, d.direction); err != nil {
+			s.log.Debugf("Failed to send scheduled datagram to %v: %v", d.addr, err)
+		}
+		s.release(d)
+	}
+}
+
+// next returns the next datagram in deficit round robin order, and false if none are queued
+func (s *Scheduler) next() (scheduledDatagram, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for len(s.active) > 0 {
+		q := s.active[0]
+		if len(q.datagrams) == 0 {
+			s.active = s.active[1:]
+			delete(s.queues, q.allocation)
+			continue
+		}
+
+		if d := q.datagrams[0]; len(d.data) <= q.deficit {
+			q.datagrams[0] = scheduledDatagram{}
+			q.datagrams = q.datagrams[1:]
+			q.deficit -= len(d.data)
+			return d, true
+		}
+
+		// The allocation used up its quantum, it continues in the next round
+		q.deficit += s.quantum
+		s.active = append(s.active[1:], q)
+	}
+
+	return scheduledDatagram{}, false
+}
+
+// WriteToPeer sends p to a peer on the relay socket, through the Scheduler of the
+// manager if it has one. Datagrams dropped by the Scheduler count as sent. Datagrams to
+// the echo peer are sent back to the client
+func (a *Allocation) WriteToPeer(p []byte, addr net.Addr) (int, error) {
+	if a.isEchoPeer(addr) {
+		return a.echo(p, a.echoPeer)
+	}
+	return a.relay(a.relaySocketFor(addr), p, addr, metrics.DirectionToPeer)
+}
+
+// writeToClient sends p to the client on its socket, like WriteToPeer
+func (a *Allocation) writeToClient(p []byte) (int, error) {
+	fiveTuple, turnSocket := a.client()
+	return a.relay(turnSocket, p, fiveTuple.SrcAddr, metrics.DirectionToClient)
+}
+
+func (a *Allocation) relay(conn net.PacketConn, p []byte, addr net.Addr, direction metrics.Direction) (int, error) {
+	if a.scheduler == nil {
+		return a.writeTo(conn, p, addr, direction)
+	}
+
+	if !a.scheduler.Enqueue(a, conn, p, addr, direction) {
+		a.log.Tracef("Dropped %d bytes to %v, the send queue of %v is full", len(p), addr, a.RelayAddr)
+	}
+
+	return len(p), nil
+}
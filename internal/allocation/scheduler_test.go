@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package allocation
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScheduler(t *testing.T) {
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+
+	t.Run("DeficitRoundRobin", func(t *testing.T) {
+		s := newScheduler(SchedulerConfig{Quantum: 1000, QueueSize: 4}, nil, log)
+		video, audio := &Allocation{}, &Allocation{}
+
+		// The video allocation queues large datagrams first, until its queue is full
+		for i := 0; i < 4; i++ {
+			assert.True(t, s.Enqueue(video, nil, make([]byte, 1000), addr, metrics.DirectionToPeer))
+		}
+		assert.False(t, s.Enqueue(video, nil, make([]byte, 1000), addr, metrics.DirectionToPeer))
+		for i := 0; i < 4; i++ {
+			assert.True(t, s.Enqueue(audio, nil, make([]byte, 250), addr, metrics.DirectionToPeer))
+		}
+
+		// Both get 1000 bytes per round
+		sizes := []int{}
+		for {
+			d, ok := s.next()
+			if !ok {
+				break
+			}
+			sizes = append(sizes, len(d.data))
+		}
+		assert.Equal(t, []int{1000, 250, 250, 250, 250, 1000, 1000, 1000}, sizes)
+		assert.Empty(t, s.queues)
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		s := newScheduler(SchedulerConfig{}, nil, log)
+		a := &Allocation{}
+
+		assert.True(t, s.Enqueue(a, nil, []byte("data"), addr, metrics.DirectionToPeer))
+		s.Remove(a)
+		_, ok := s.next()
+		assert.False(t, ok)
+	})
+
+	t.Run("Send", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		s := NewScheduler(SchedulerConfig{BytesPerSecond: 1 << 20}, nil, log)
+		p := []byte("data")
+		assert.True(t, s.Enqueue(&Allocation{}, conn, p, peer.LocalAddr(), metrics.DirectionToPeer))
+		p[0] = 'D' // Datagrams are copied
+
+		buf := make([]byte, 100)
+		assert.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, from, err := peer.ReadFrom(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, "data", string(buf[:n]))
+		assert.Equal(t, conn.LocalAddr().String(), from.String())
+
+		s.Close()
+		s.Close()
+		assert.NoError(t, conn.Close())
+		assert.NoError(t, peer.Close())
+	})
+}
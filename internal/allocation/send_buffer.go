@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/pion/turn/v3/metrics"
+)
+
+// SendBufferRetry retries the datagrams the OS refused because the send buffer of the socket
+// was full, e.g. under bursts. The zero value doesn't retry
+type SendBufferRetry struct {
+	// Attempts is the number of times a refused datagram is sent again
+	Attempts int
+	// Interval is the pause before every attempt, so the kernel can drain the buffer
+	Interval time.Duration
+}
+
+// WriteTo writes p to addr on conn, and sends it again while the send buffer of conn is full
+// as configured by r
+func (r SendBufferRetry) WriteTo(conn net.PacketConn, p []byte, addr net.Addr) (int, error) {
+	n, err := conn.WriteTo(p, addr)
+	for i := 0; i < r.Attempts && IsSendBufferFull(err); i++ {
+		time.Sleep(r.Interval)
+		n, err = conn.WriteTo(p, addr)
+	}
+
+	return n, err
+}
+
+// IsSendBufferFull returns true if err reports that the datagram was dropped because the
+// send buffer of the socket or the queue of the interface was full
+func IsSendBufferFull(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EWOULDBLOCK) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// writeTo relays p to addr on conn in direction, retrying on a full send buffer, and counts
+// the datagram if it was dropped anyway. Datagrams to the client are retried by the
+// listener socket they are sent on
+func (a *Allocation) writeTo(conn net.PacketConn, p []byte, addr net.Addr, direction metrics.Direction) (int, error) {
+	retry := a.sendBufferRetry
+	if direction == metrics.DirectionToClient {
+		retry = SendBufferRetry{}
+	}
+
+	n, err := retry.WriteTo(conn, p, addr)
+	if IsSendBufferFull(err) {
+		atomic.AddUint64(&a.stats.sendBufferDrops[direction], 1)
+		a.metrics.SendBufferDrop(direction)
+	}
+
+	return n, err
+}
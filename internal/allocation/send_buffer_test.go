@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package allocation
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/pion/turn/v3/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// fullBufferConn fails the first full writes as if the send buffer was full
+type fullBufferConn struct {
+	net.PacketConn
+	full   int
+	writes int
+}
+
+func (c *fullBufferConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	c.writes++
+	if c.writes <= c.full {
+		return 0, &net.OpError{Op: "write", Net: "udp", Err: os.NewSyscallError("sendto", syscall.ENOBUFS)}
+	}
+	return len(p), nil
+}
+
+func TestSendBufferRetry(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+
+	t.Run("Retried", func(t *testing.T) {
+		conn := &fullBufferConn{full: 2}
+		n, err := SendBufferRetry{Attempts: 2}.WriteTo(conn, []byte("data"), addr)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, n)
+		assert.Equal(t, 3, conn.writes)
+	})
+
+	t.Run("Dropped", func(t *testing.T) {
+		m := metrics.New()
+		a := NewAllocation(nil, nil, nil)
+		a.metrics = m
+		a.sendBufferRetry = SendBufferRetry{Attempts: 1}
+
+		conn := &fullBufferConn{full: 3}
+		_, err := a.writeTo(conn, []byte("data"), addr, metrics.DirectionToPeer)
+		assert.True(t, IsSendBufferFull(err))
+		assert.Equal(t, 2, conn.writes)
+
+		// Datagrams to the client are retried by the listener socket
+		_, err = a.writeTo(conn, []byte("data"), addr, metrics.DirectionToClient)
+		assert.True(t, IsSendBufferFull(err))
+		assert.Equal(t, 3, conn.writes)
+
+		assert.Equal(t, [2]uint64{1, 1}, a.Traffic().SendBufferDrops)
+		assert.Equal(t, [2]uint64{1, 1}, m.Snapshot().SendBufferDrops)
+	})
+
+	assert.False(t, IsSendBufferFull(nil))
+	assert.False(t, IsSendBufferFull(syscall.ECONNREFUSED))
+	assert.True(t, IsSendBufferFull(syscall.EAGAIN))
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+	"time"
+
+	"github.com/pion/turn/v3/internal/ipnet"
+)
+
+// SessionPolicy is the policy an authentication handler returned for the allocation of a
+// user. Zero values leave the server defaults in place
+type SessionPolicy struct {
+	// MaxLifetime caps the lifetime granted by Allocate and Refresh requests
+	MaxLifetime time.Duration
+	// Quota is the number of allocations the user may hold
+	Quota int
+	// BytesPerSecond caps the bytes the allocation relays in both directions
+	BytesPerSecond int
+	// AllowedPeers are the networks of the peers permissions may be created for
+	AllowedPeers []*net.IPNet
+	// DSCP marks the packets the allocation relays to peers
+	DSCP int
+	// MaxPacketSize caps the size of the datagrams the allocation relays
+	MaxPacketSize int
+}
+
+// CapLifetime returns lifetime, capped to MaxLifetime
+func (p SessionPolicy) CapLifetime(lifetime time.Duration) time.Duration {
+	if p.MaxLifetime > 0 && lifetime > p.MaxLifetime {
+		return p.MaxLifetime
+	}
+	return lifetime
+}
+
+// AllowsPeer returns true if peer is in AllowedPeers, or if AllowedPeers is empty
+func (p SessionPolicy) AllowsPeer(peer net.Addr) bool {
+	if len(p.AllowedPeers) == 0 {
+		return true
+	}
+
+	ip, _, err := ipnet.AddrIPPort(peer)
+	if err != nil {
+		return false
+	}
+	for _, network := range p.AllowedPeers {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SetSessionPolicy records the policy of the allocation
+func (a *Allocation) SetSessionPolicy(policy SessionPolicy) {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	a.sessionPolicy = policy
+}
+
+// SessionPolicy returns the policy of the allocation
+func (a *Allocation) SessionPolicy() SessionPolicy {
+	a.credentialsLock.Lock()
+	defer a.credentialsLock.Unlock()
+
+	return a.sessionPolicy
+}
@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"io"
+	"net"
+
+	"github.com/pion/turn/v3/metrics"
+)
+
+// relayStream copies src to dst until either fails, limited to the bandwidth limits of the
+// allocation and counted as relayed in direction, and returns the bytes relayed. TCP
+// connections are spliced on Linux, so the data isn't copied through userspace
+func (a *Allocation) relayStream(dst, src net.Conn, direction metrics.Direction) int64 {
+	onChunk := func(n int) {
+		a.waitRelay(n)
+		a.metrics.RelayedStream(direction, n)
+	}
+	if n, ok := splice(dst, src, onChunk); ok {
+		return n
+	}
+
+	n, _ := io.Copy(dst, a.relayReader(src, direction))
+	return n
+}
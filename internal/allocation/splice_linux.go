@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// spliceChunkSize is the most moved with one splice, the default capacity of a pipe
+const spliceChunkSize = 1 << 16
+
+// splice moves src to dst through a pipe with splice(2) until either fails, calling
+// onChunk with the size of every chunk before it is written to dst. It returns the bytes
+// written, and false if src or dst aren't TCP connections and nothing was moved
+func splice(dst, src net.Conn, onChunk func(n int)) (int64, bool) {
+	srcConn, srcOK := src.(*net.TCPConn)
+	dstConn, dstOK := dst.(*net.TCPConn)
+	if !srcOK || !dstOK {
+		return 0, false
+	}
+
+	srcRaw, err := srcConn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	dstRaw, err := dstConn.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+
+	var pipe [2]int
+	if err = unix.Pipe2(pipe[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return 0, false
+	}
+	defer func() {
+		_ = unix.Close(pipe[0])
+		_ = unix.Close(pipe[1])
+	}()
+
+	var written int64
+	for {
+		// The pipe is empty, EAGAIN means src has no data
+		n, err := spliceWith(srcRaw.Read, func(fd int) (int64, error) {
+			return spliceFD(fd, pipe[1], spliceChunkSize)
+		})
+		if err != nil || n == 0 {
+			return written, true
+		}
+		onChunk(int(n))
+
+		for remaining := n; remaining > 0; {
+			m, err := spliceWith(dstRaw.Write, func(fd int) (int64, error) {
+				return spliceFD(pipe[0], fd, int(remaining))
+			})
+			if err != nil || m == 0 {
+				return written, true
+			}
+			remaining -= m
+			written += m
+		}
+	}
+}
+
+// spliceWith runs f with the fd of a socket through wait, the Read or Write of its
+// syscall.RawConn, so the poller waits until the socket is ready on EAGAIN
+func spliceWith(wait func(func(uintptr) bool) error, f func(fd int) (int64, error)) (int64, error) {
+	var n int64
+	var spliceErr error
+	if err := wait(func(fd uintptr) bool {
+		for {
+			n, spliceErr = f(int(fd))
+			if spliceErr != syscall.EINTR { //nolint:errorlint
+				return spliceErr != syscall.EAGAIN //nolint:errorlint
+			}
+		}
+	}); err != nil {
+		return 0, err
+	}
+
+	return n, spliceErr
+}
+
+func spliceFD(in, out, size int) (int64, error) {
+	n, err := unix.Splice(in, nil, out, nil, size, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+	return int64(n), err
+}
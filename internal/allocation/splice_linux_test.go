@@ -0,0 +1,85 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tcpPair returns both ends of a loopback TCP connection
+func tcpPair(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, listener.Close())
+	}()
+
+	dialed, err := net.DialTCP("tcp4", nil, listener.Addr().(*net.TCPAddr))
+	require.NoError(t, err)
+	accepted, err := listener.AcceptTCP()
+	require.NoError(t, err)
+
+	return dialed, accepted
+}
+
+func TestSplice(t *testing.T) {
+	client, dataConn := tcpPair(t)
+	peerConn, peer := tcpPair(t)
+	defer func() {
+		for _, c := range []net.Conn{client, dataConn, peerConn, peer} {
+			_ = c.Close()
+		}
+	}()
+
+	payload := make([]byte, 1<<20)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+
+	type result struct {
+		written, chunks int64
+		ok              bool
+	}
+	done := make(chan result)
+	go func() {
+		var chunks int64
+		written, ok := splice(peerConn, dataConn, func(n int) {
+			chunks += int64(n)
+		})
+		assert.NoError(t, peerConn.CloseWrite())
+		done <- result{written, chunks, ok}
+	}()
+
+	go func() {
+		_, writeErr := client.Write(payload)
+		assert.NoError(t, writeErr)
+		assert.NoError(t, client.CloseWrite())
+	}()
+
+	received, err := ioutil.ReadAll(peer)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(payload, received))
+
+	r := <-done
+	assert.True(t, r.ok)
+	assert.Equal(t, int64(len(payload)), r.written)
+	assert.Equal(t, int64(len(payload)), r.chunks)
+}
+
+func TestSpliceUnsupported(t *testing.T) {
+	a, b := net.Pipe()
+	defer func() {
+		assert.NoError(t, a.Close())
+		assert.NoError(t, b.Close())
+	}()
+
+	_, ok := splice(a, b, func(int) {})
+	assert.False(t, ok)
+}
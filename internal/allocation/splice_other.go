@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package allocation
+
+import "net"
+
+// splice is only implemented on Linux
+func splice(net.Conn, net.Conn, func(int)) (int64, bool) {
+	return 0, false
+}
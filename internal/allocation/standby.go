@@ -0,0 +1,226 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v3/internal/ipnet"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// standbyPruneInterval is how often the replicas that expired without a deletion are
+// removed from a Standby
+const standbyPruneInterval = time.Minute
+
+// Replica is the state of a UDP allocation of another server, as replicated to a Standby
+type Replica struct {
+	Username  string
+	FiveTuple FiveTuple
+	RelayAddr *net.UDPAddr
+	Expires   time.Time
+	// Permissions are the peers with a permission, channel peers included
+	Permissions []net.Addr
+	// Channels are the peers of the channel bindings by channel number
+	Channels map[proto.ChannelNumber]net.Addr
+}
+
+// Replica returns the state of the allocation to be replicated to a Standby. It returns
+// false for allocations that can't be restored, like RFC 6062 TCP allocations
+func (a *Allocation) Replica() (Replica, bool) {
+	relayAddr, ok := a.RelayAddr.(*net.UDPAddr)
+	if !ok || a.RelaySocket == nil {
+		return Replica{}, false
+	}
+
+	replica := Replica{
+		Username:  a.Username(),
+		FiveTuple: *a.getFiveTuple(),
+		RelayAddr: relayAddr,
+		Expires:   a.Expires(),
+		Channels:  map[proto.ChannelNumber]net.Addr{},
+	}
+
+	a.permissionsLock.RLock()
+	for _, p := range a.permissions {
+		replica.Permissions = append(replica.Permissions, p.Addr)
+	}
+	a.permissionsLock.RUnlock()
+
+	for _, c := range a.channels() {
+		replica.Channels[c.Number] = c.Peer
+	}
+
+	return replica, true
+}
+
+// Standby holds the allocations replicated from another server, e.g. the active node of a
+// VRRP or anycast pair. An allocation is restored by the Manager of the listener its
+// client reaches once the client sends to this server, so it survives a failover
+type Standby struct {
+	lock       sync.Mutex
+	replicas   map[string]*Replica
+	lastPruned time.Time
+}
+
+// NewStandby creates an empty Standby
+func NewStandby() *Standby {
+	return &Standby{
+		replicas:   map[string]*Replica{},
+		lastPruned: time.Now(),
+	}
+}
+
+// Created records a new allocation, replacing the replica of the same 5-tuple
+func (s *Standby) Created(replica Replica) {
+	if replica.Channels == nil {
+		replica.Channels = map[proto.ChannelNumber]net.Addr{}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.replicas[replica.FiveTuple.Fingerprint()] = &replica
+
+	// Replicas whose deletion was never received are dropped once expired
+	if now := time.Now(); now.Sub(s.lastPruned) >= standbyPruneInterval {
+		for fingerprint, r := range s.replicas {
+			if now.After(r.Expires) {
+				delete(s.replicas, fingerprint)
+			}
+		}
+		s.lastPruned = now
+	}
+}
+
+// Refreshed extends the lifetime of the replica of fiveTuple
+func (s *Standby) Refreshed(fiveTuple *FiveTuple, lifetime time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r, ok := s.replicas[fiveTuple.Fingerprint()]; ok {
+		r.Expires = time.Now().Add(lifetime)
+	}
+}
+
+// Deleted removes the replica of fiveTuple
+func (s *Standby) Deleted(fiveTuple *FiveTuple) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.replicas, fiveTuple.Fingerprint())
+}
+
+// PermissionCreated records a permission for peer on the replica of fiveTuple
+func (s *Standby) PermissionCreated(fiveTuple *FiveTuple, peer net.Addr) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r, ok := s.replicas[fiveTuple.Fingerprint()]; ok {
+		r.Permissions = append(r.Permissions, peer)
+	}
+}
+
+// ChannelBound records the channel bound to peer on the replica of fiveTuple
+func (s *Standby) ChannelBound(fiveTuple *FiveTuple, peer net.Addr, channel proto.ChannelNumber) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r, ok := s.replicas[fiveTuple.Fingerprint()]; ok {
+		r.Channels[channel] = peer
+	}
+}
+
+// Len returns the number of replicas
+func (s *Standby) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return len(s.replicas)
+}
+
+// take removes the replica of fiveTuple and returns it, nil if there is none or it expired.
+// A replica of another username than a non-empty username is kept and ErrUsernameMismatch
+// returned
+func (s *Standby) take(fiveTuple *FiveTuple, username string) (*Replica, error) {
+	if s == nil {
+		return nil, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.replicas) == 0 {
+		return nil, nil
+	}
+	fingerprint := fiveTuple.Fingerprint()
+	r, ok := s.replicas[fingerprint]
+	if !ok {
+		return nil, nil
+	} else if username != "" && username != r.Username {
+		return nil, fmt.Errorf("%w: %q instead of %q", ErrUsernameMismatch, username, r.Username)
+	}
+	delete(s.replicas, fingerprint)
+	if time.Now().After(r.Expires) {
+		return nil, nil
+	}
+
+	return r, nil
+}
+
+// RestoreAllocation restores the replicated allocation of fiveTuple from the Standby, on a
+// relay socket bound to its relayed address again. Data for the client is sent on
+// turnSocket and channels are bound for channelLifetime. It returns nil if there is no
+// replica, and an error if the relayed address can't be bound. Authenticated requests pass
+// their username, the replica of another username isn't restored for them and
+// ErrUsernameMismatch is returned. Indications and ChannelData pass an empty username
+func (m *Manager) RestoreAllocation(fiveTuple *FiveTuple, username string, turnSocket net.PacketConn, channelLifetime time.Duration) (*Allocation, error) {
+	r, err := m.standby.take(fiveTuple, username)
+	if r == nil {
+		return nil, err
+	}
+
+	family := proto.RequestedFamilyIPv4
+	if r.RelayAddr.IP.To4() == nil {
+		family = proto.RequestedFamilyIPv6
+	}
+
+	a, err := m.CreateAllocation(fiveTuple, turnSocket, family, r.RelayAddr.Port, time.Until(r.Expires))
+	if err != nil {
+		return nil, err
+	}
+
+	// Peers keep sending to the relayed address the client advertised, an allocation on
+	// another address would be useless
+	if !ipnet.AddrEqual(a.RelayAddr, r.RelayAddr) {
+		m.lock.Lock()
+		delete(m.allocations, fiveTuple.Fingerprint())
+		m.lock.Unlock()
+		m.metrics.AllocationDeleted()
+
+		return nil, JoinErrors(fmt.Errorf("%w: %v instead of %v", errRelayAddressMismatch, a.RelayAddr, r.RelayAddr), a.Close())
+	}
+
+	a.SetCredentials(r.Username, nil)
+	m.events.AllocationCreated(a)
+
+	for _, peer := range r.Permissions {
+		a.AddPermission(NewPermission(peer, m.log))
+		m.events.PermissionCreated(a, peer)
+	}
+	for number, peer := range r.Channels {
+		if err := a.AddChannelBind(NewChannelBind(number, peer, m.log), channelLifetime); err != nil {
+			m.log.Warnf("Failed to restore channel %x of %v: %v", uint16(number), fiveTuple, err)
+			continue
+		}
+		m.events.ChannelBound(a, peer, uint16(number))
+	}
+
+	m.log.Infof("Restored replicated allocation %v on %s", fiveTuple, a.RelayAddr)
+
+	return a, nil
+}
@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package allocation
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStandby(t *testing.T) {
+	turnSocket, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, turnSocket.Close())
+	}()
+
+	// honorPort binds the requested port, like a standby configured as the active server
+	newManager := func(standby *Standby, honorPort bool) *Manager {
+		m, err := NewManager(ManagerConfig{
+			LeveledLogger: logging.NewDefaultLoggerFactory().NewLogger("test"),
+			AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+				if !honorPort {
+					requestedPort = 0
+				}
+				conn, err := net.ListenPacket("udp4", "127.0.0.1:"+strconv.Itoa(requestedPort))
+				if err != nil {
+					return nil, nil, err
+				}
+
+				return conn, conn.LocalAddr(), nil
+			},
+			AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) { return nil, nil, nil },
+			Standby:      standby,
+		})
+		require.NoError(t, err)
+
+		return m
+	}
+
+	// The relayed address of the active server is free on this host
+	relaySocket, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	relayAddr := relaySocket.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+	require.NoError(t, relaySocket.Close())
+
+	peer := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 6000}
+	fiveTuple := randomFiveTuple()
+	replica := Replica{
+		Username:  "user",
+		FiveTuple: *fiveTuple,
+		RelayAddr: relayAddr,
+		Expires:   time.Now().Add(time.Minute),
+	}
+
+	t.Run("Restore", func(t *testing.T) {
+		standby := NewStandby()
+		m := newManager(standby, true)
+		defer func() {
+			assert.NoError(t, m.Close())
+		}()
+
+		standby.Created(replica)
+		standby.PermissionCreated(fiveTuple, peer)
+		standby.ChannelBound(fiveTuple, peer, proto.MinChannelNumber)
+		standby.Refreshed(fiveTuple, 2*time.Minute)
+		assert.Equal(t, 1, standby.Len())
+
+		a, err := m.RestoreAllocation(randomFiveTuple(), "user", turnSocket, time.Minute)
+		assert.NoError(t, err)
+		assert.Nil(t, a)
+
+		a, err = m.RestoreAllocation(fiveTuple, "user", turnSocket, time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, a)
+		assert.Equal(t, relayAddr.String(), a.RelayAddr.String())
+		assert.Equal(t, "user", a.Username())
+		assert.NotNil(t, a.GetPermission(peer))
+		assert.NotNil(t, a.GetChannelByNumber(proto.MinChannelNumber))
+		assert.WithinDuration(t, time.Now().Add(2*time.Minute), a.Expires(), time.Second)
+		assert.Equal(t, a, m.GetAllocation(fiveTuple))
+		assert.Equal(t, 0, standby.Len())
+
+		restored, ok := a.Replica()
+		assert.True(t, ok)
+		assert.Equal(t, []net.Addr{peer}, restored.Permissions)
+		assert.Equal(t, map[proto.ChannelNumber]net.Addr{proto.MinChannelNumber: peer}, restored.Channels)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		standby := NewStandby()
+		m := newManager(standby, true)
+		defer func() {
+			assert.NoError(t, m.Close())
+		}()
+
+		expired := replica
+		expired.Expires = time.Now().Add(-time.Second)
+		standby.Created(expired)
+
+		a, err := m.RestoreAllocation(fiveTuple, "user", turnSocket, time.Minute)
+		assert.NoError(t, err)
+		assert.Nil(t, a)
+	})
+
+	t.Run("UsernameMismatch", func(t *testing.T) {
+		standby := NewStandby()
+		m := newManager(standby, true)
+		defer func() {
+			assert.NoError(t, m.Close())
+		}()
+
+		// Another user presenting the 5-tuple doesn't take the allocation over
+		standby.Created(replica)
+		a, err := m.RestoreAllocation(fiveTuple, "other", turnSocket, time.Minute)
+		assert.ErrorIs(t, err, ErrUsernameMismatch)
+		assert.Nil(t, a)
+		assert.Equal(t, 1, standby.Len())
+		assert.Equal(t, 0, m.AllocationCount())
+
+		// Data of the client restores it without a username
+		a, err = m.RestoreAllocation(fiveTuple, "", turnSocket, time.Minute)
+		require.NoError(t, err)
+		require.NotNil(t, a)
+		assert.Equal(t, "user", a.Username())
+	})
+
+	t.Run("Deleted", func(t *testing.T) {
+		standby := NewStandby()
+		standby.Created(replica)
+		standby.Deleted(fiveTuple)
+		assert.Equal(t, 0, standby.Len())
+	})
+
+	t.Run("RelayAddressMismatch", func(t *testing.T) {
+		standby := NewStandby()
+		m := newManager(standby, false)
+		defer func() {
+			assert.NoError(t, m.Close())
+		}()
+
+		standby.Created(replica)
+		a, err := m.RestoreAllocation(fiveTuple, "user", turnSocket, time.Minute)
+		assert.ErrorIs(t, err, errRelayAddressMismatch)
+		assert.Nil(t, a)
+		assert.Equal(t, 0, m.AllocationCount())
+	})
+}
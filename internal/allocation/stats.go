@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/turn/v3/metrics"
+)
+
+// allocationStats are updated atomically. It is allocated separately from Allocation, which
+// keeps the 64-bit fields aligned on 32-bit platforms
+type allocationStats struct {
+	expires        int64 // Unix nanoseconds
+	relayedBytes   [2]uint64
+	relayedPackets [2]uint64
+
+	sendBufferDrops [2]uint64
+	oversizedDrops  [2]uint64
+}
+
+// Traffic counts the data relayed by an allocation
+type Traffic struct {
+	// Bytes and Packets are indexed by metrics.Direction. TCP connections of RFC 6062
+	// allocations only count bytes, once the connection is closed
+	Bytes   [2]uint64
+	Packets [2]uint64
+	// SendBufferDrops counts the datagrams dropped because the send buffer of the socket
+	// was full, see SendBufferRetry
+	SendBufferDrops [2]uint64
+	// OversizedDrops counts the datagrams dropped because they exceeded the maximum packet
+	// size of the allocation
+	OversizedDrops [2]uint64
+}
+
+// CountRelayed counts a datagram of n bytes relayed in direction, in the traffic of the
+// allocation and the metrics of the manager
+func (a *Allocation) CountRelayed(direction metrics.Direction, n int) {
+	atomic.AddUint64(&a.stats.relayedBytes[direction], uint64(n))
+	atomic.AddUint64(&a.stats.relayedPackets[direction], 1)
+	a.metrics.Relayed(direction, n)
+}
+
+// CountRelayedIndication counts a datagram relayed in direction that was carried in a Send
+// or Data indication rather than ChannelData, in the metrics of the manager
+func (a *Allocation) CountRelayedIndication(direction metrics.Direction) {
+	a.metrics.RelayedIndication(direction)
+}
+
+// countRelayedStream counts n bytes relayed in direction on a closed TCP connection
+func (a *Allocation) countRelayedStream(direction metrics.Direction, n int64) {
+	atomic.AddUint64(&a.stats.relayedBytes[direction], uint64(n))
+}
+
+// Traffic returns the data relayed by the allocation so far
+func (a *Allocation) Traffic() Traffic {
+	var t Traffic
+	for _, d := range []metrics.Direction{metrics.DirectionToPeer, metrics.DirectionToClient} {
+		t.Bytes[d] = atomic.LoadUint64(&a.stats.relayedBytes[d])
+		t.Packets[d] = atomic.LoadUint64(&a.stats.relayedPackets[d])
+		t.SendBufferDrops[d] = atomic.LoadUint64(&a.stats.sendBufferDrops[d])
+		t.OversizedDrops[d] = atomic.LoadUint64(&a.stats.oversizedDrops[d])
+	}
+
+	return t
+}
+
+// Expires returns when the lifetime of the allocation ends unless it is refreshed
+func (a *Allocation) Expires() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&a.stats.expires))
+}
+
+func (a *Allocation) setExpires(lifetime time.Duration) {
+	atomic.StoreInt64(&a.stats.expires, time.Now().Add(lifetime).UnixNano())
+}
@@ -0,0 +1,358 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/randutil"
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/ipnet"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/metrics"
+)
+
+// tcpConnectionBindTimeout is how long a peer connection waits for the client to
+// send the ConnectionBind request, see RFC 6062 Section 5.2 and 5.3
+const tcpConnectionBindTimeout = 30 * time.Second
+
+// TCPConnection is a connection between the relayed transport address of a TCP
+// allocation and a peer. Once the client binds a data connection to it with
+// ConnectionBind, data is relayed between the two connections as is.
+// See RFC 6062 Section 5
+type TCPConnection struct {
+	ID       proto.ConnectionID
+	PeerAddr net.Addr
+
+	allocation *Allocation
+	manager    *Manager
+	peerConn   net.Conn
+	bindTimer  *time.Timer
+	onClose    func()
+
+	lock      sync.Mutex
+	bound     bool
+	dataConn  net.Conn
+	closeOnce sync.Once
+}
+
+// Relay starts relaying between the peer and dataConn, the data connection from the
+// client. buffered is data that was already read from dataConn and is sent first
+func (c *TCPConnection) Relay(dataConn net.Conn, buffered []byte) {
+	c.lock.Lock()
+	c.dataConn = dataConn
+	c.lock.Unlock()
+
+	c.manager.goHandler(func() {
+		defer c.Close() //nolint:errcheck,gosec
+
+		if len(buffered) > 0 {
+			if _, err := c.peerConn.Write(buffered); err != nil {
+				return
+			}
+		}
+
+		n := c.allocation.relayStream(c.peerConn, dataConn, metrics.DirectionToPeer)
+		c.allocation.countRelayedStream(metrics.DirectionToPeer, int64(len(buffered))+n)
+	})
+
+	c.manager.goHandler(func() {
+		defer c.Close() //nolint:errcheck,gosec
+
+		n := c.allocation.relayStream(dataConn, c.peerConn, metrics.DirectionToClient)
+		c.allocation.countRelayedStream(metrics.DirectionToClient, n)
+	})
+}
+
+// Close closes the peer connection and, if bound, the data connection
+func (c *TCPConnection) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.bindTimer.Stop()
+		c.allocation.removeTCPConnection(c.ID)
+		c.onClose()
+
+		c.lock.Lock()
+		dataConn := c.dataConn
+		c.lock.Unlock()
+
+		if dataConn != nil {
+			_ = dataConn.Close()
+		}
+		err = c.peerConn.Close()
+	})
+
+	return err
+}
+
+// Allocation returns the TCP allocation the connection belongs to
+func (c *TCPConnection) Allocation() *Allocation {
+	return c.allocation
+}
+
+// bind marks the connection as bound, it returns false if it was already bound
+func (c *TCPConnection) bind() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.bound {
+		return false
+	}
+	c.bound = true
+	c.bindTimer.Stop()
+
+	return true
+}
+
+// GetTCPConnectionByAddr returns the connection of the allocation to addr
+func (a *Allocation) GetTCPConnectionByAddr(addr net.Addr) *TCPConnection {
+	a.tcpConnectionsLock.RLock()
+	defer a.tcpConnectionsLock.RUnlock()
+
+	for _, c := range a.tcpConnections {
+		if c.PeerAddr.String() == addr.String() {
+			return c
+		}
+	}
+	return nil
+}
+
+// reserveTCPPeer marks addr as being connected to, it returns false if the allocation
+// already has a connection to addr or another Connect is dialing it
+func (a *Allocation) reserveTCPPeer(addr net.Addr) bool {
+	a.tcpConnectionsLock.Lock()
+	defer a.tcpConnectionsLock.Unlock()
+
+	for _, c := range a.tcpConnections {
+		if c.PeerAddr.String() == addr.String() {
+			return false
+		}
+	}
+
+	if _, ok := a.tcpConnecting[addr.String()]; ok {
+		return false
+	}
+	if a.tcpConnecting == nil {
+		a.tcpConnecting = map[string]struct{}{}
+	}
+	a.tcpConnecting[addr.String()] = struct{}{}
+
+	return true
+}
+
+// releaseTCPPeer drops the reservation of reserveTCPPeer for addr
+func (a *Allocation) releaseTCPPeer(addr net.Addr) {
+	a.tcpConnectionsLock.Lock()
+	defer a.tcpConnectionsLock.Unlock()
+
+	delete(a.tcpConnecting, addr.String())
+}
+
+func (a *Allocation) removeTCPConnection(id proto.ConnectionID) {
+	a.tcpConnectionsLock.Lock()
+	defer a.tcpConnectionsLock.Unlock()
+
+	delete(a.tcpConnections, id)
+}
+
+// acceptHandler accepts peer connections on the relayed transport address of a TCP
+// allocation and announces them to the client. See RFC 6062 Section 5.3
+func (a *Allocation) acceptHandler(m *Manager) {
+	for {
+		conn, err := a.RelayListener.Accept()
+		if err != nil {
+			select {
+			case <-a.closed:
+			default:
+				m.DeleteAllocation(a.getFiveTuple())
+			}
+			return
+		}
+
+		peerIP, peerPort, err := ipnet.AddrIPPort(conn.RemoteAddr())
+		if err != nil || !m.permitsPeer(a, conn.RemoteAddr()) {
+			a.log.Infof("No Permission exists for %v on allocation %v", conn.RemoteAddr(), a.RelayAddr)
+			_ = conn.Close()
+			continue
+		}
+
+		c, err := m.addTCPConnection(a, conn)
+		if err != nil {
+			a.log.Errorf("Failed to add connection from %v to allocation %v: %v", conn.RemoteAddr(), a.RelayAddr, err)
+			_ = conn.Close()
+			continue
+		}
+
+		msg, err := stun.Build(
+			stun.TransactionID,
+			stun.NewType(stun.MethodConnectionAttempt, stun.ClassIndication),
+			c.ID,
+			&proto.PeerAddress{IP: peerIP, Port: peerPort},
+		)
+		if err != nil {
+			a.log.Errorf("Failed to build ConnectionAttempt for %v: %v", conn.RemoteAddr(), err)
+			_ = c.Close()
+			continue
+		}
+
+		fiveTuple, turnSocket := a.client()
+		if _, err = turnSocket.WriteTo(msg.Raw, fiveTuple.SrcAddr); err != nil {
+			a.log.Errorf("Failed to send ConnectionAttempt for %v: %v", conn.RemoteAddr(), err)
+			_ = c.Close()
+		}
+	}
+}
+
+// TCPAllocationsEnabled returns true if the Manager can create RFC 6062 TCP allocations
+func (m *Manager) TCPAllocationsEnabled() bool {
+	return m.allocateListener != nil
+}
+
+// CreateTCPAllocation creates a new RFC 6062 TCP allocation and starts accepting
+// peer connections on its relayed transport address
+func (m *Manager) CreateTCPAllocation(fiveTuple *FiveTuple, turnSocket net.PacketConn, family proto.RequestedAddressFamily, lifetime time.Duration) (*Allocation, error) {
+	if m.allocateListener == nil {
+		return nil, errTCPAllocationsDisabled
+	}
+
+	if err := m.validateAllocation(fiveTuple, turnSocket, lifetime); err != nil {
+		return nil, err
+	}
+	a := NewAllocation(turnSocket, fiveTuple, m.log)
+	a.Protocol = TCP
+	a.AddressFamily = family
+	a.PermissionTimeout = m.permissionTimeout
+	a.Listener = m.listener
+	a.ClientTransport = m.clientTransport
+	a.metrics = m.metrics
+
+	listener, relayAddr, err := m.allocateListener(network(TCP, family), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	a.RelayListener = listener
+	a.RelayAddr = relayAddr
+	m.setDefaultDSCP(a)
+
+	m.log.Debugf("Listening on TCP relay address: %s", a.RelayAddr.String())
+
+	a.lifetimeTimer = time.AfterFunc(lifetime, func() {
+		m.expireAllocation(a)
+	})
+	a.setExpires(lifetime)
+
+	m.lock.Lock()
+	m.allocations[fiveTuple.Fingerprint()] = a
+	m.lock.Unlock()
+	m.metrics.AllocationCreated()
+
+	m.goHandler(func() {
+		a.acceptHandler(m)
+	})
+	return a, nil
+}
+
+// Connect opens a connection from the relayed transport address of the TCP allocation a
+// to peer and returns its connection ID. See RFC 6062 Section 5.2
+func (m *Manager) Connect(a *Allocation, peer net.Addr) (proto.ConnectionID, error) {
+	if a.Protocol != TCP {
+		return 0, errNotTCPAllocation
+	}
+
+	// The peer stays reserved until the connection was added, so concurrent Connects to
+	// the same peer fail instead of dialing it twice
+	if !a.reserveTCPPeer(peer) {
+		return 0, fmt.Errorf("%w: %v", ErrConnectionAlreadyExists, peer)
+	}
+	defer a.releaseTCPPeer(peer)
+
+	conn, err := m.dialPeer(network(TCP, a.AddressFamily), a.RelayListener.Addr(), peer)
+	if err != nil {
+		return 0, fmt.Errorf("%w %v: %v", ErrConnectionFailed, peer, err) //nolint:errorlint
+	}
+	if dscp := a.DSCP(); dscp != 0 {
+		if err = ipnet.SetDSCP(conn, dscp); err != nil {
+			m.log.Warnf("Failed to set DSCP %d on connection to %v: %v", dscp, peer, err)
+		}
+	}
+
+	c, err := m.addTCPConnection(a, conn)
+	if err != nil {
+		_ = conn.Close()
+		return 0, err
+	}
+
+	return c.ID, nil
+}
+
+// BindTCPConnection claims the connection with the given ID for a ConnectionBind request.
+// The caller must call Relay or Close on the returned connection. See RFC 6062 Section 5.4
+func (m *Manager) BindTCPConnection(id proto.ConnectionID) (*TCPConnection, error) {
+	m.lock.RLock()
+	c, ok := m.tcpConnections[id]
+	m.lock.RUnlock()
+
+	if !ok || !c.bind() {
+		return nil, fmt.Errorf("%w: %d", ErrNoSuchTCPConnection, id)
+	}
+
+	return c, nil
+}
+
+func (m *Manager) addTCPConnection(a *Allocation, peerConn net.Conn) (*TCPConnection, error) {
+	c := &TCPConnection{
+		PeerAddr:   peerConn.RemoteAddr(),
+		allocation: a,
+		manager:    m,
+		peerConn:   peerConn,
+	}
+	c.onClose = func() {
+		m.lock.Lock()
+		delete(m.tcpConnections, c.ID)
+		m.lock.Unlock()
+	}
+
+	// If the client doesn't bind a data connection in time the peer connection is closed
+	c.bindTimer = time.AfterFunc(tcpConnectionBindTimeout, func() {
+		if c.bind() {
+			m.log.Debugf("No ConnectionBind for connection %d to %v, closing", c.ID, c.PeerAddr)
+			_ = c.Close()
+		}
+	})
+
+	m.lock.Lock()
+	for {
+		id, err := randutil.CryptoUint64()
+		if err != nil {
+			m.lock.Unlock()
+			c.bindTimer.Stop()
+			return nil, err
+		}
+
+		c.ID = proto.ConnectionID(id)
+		if _, ok := m.tcpConnections[c.ID]; !ok {
+			break
+		}
+	}
+	m.tcpConnections[c.ID] = c
+	m.lock.Unlock()
+
+	a.tcpConnectionsLock.Lock()
+	a.tcpConnections[c.ID] = c
+	a.tcpConnectionsLock.Unlock()
+
+	select {
+	case <-a.closed:
+		// Raced with the allocation closing, don't leak the connection
+		_ = c.Close()
+		return nil, errAllocationClosed
+	default:
+	}
+
+	return c, nil
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package allocation
+
+// Transport is the transport a client reached the server over
+type Transport uint8
+
+// Client transports
+const (
+	TransportUDP Transport = iota
+	TransportTCP
+	TransportTLS
+	TransportDTLS
+	TransportQUIC
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportUDP:
+		return "UDP"
+	case TransportTCP:
+		return "TCP"
+	case TransportTLS:
+		return "TLS"
+	case TransportDTLS:
+		return "DTLS"
+	case TransportQUIC:
+		return "QUIC"
+	default:
+		return "unknown"
+	}
+}
+
+func (p Protocol) String() string {
+	switch p {
+	case UDP:
+		return "UDP"
+	case TCP:
+		return "TCP"
+	default:
+		return "unknown"
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/pion/logging"
 	"github.com/pion/stun/v2"
 	"github.com/pion/transport/v3"
+	"github.com/pion/turn/v3/internal/ipnet"
 	"github.com/pion/turn/v3/internal/proto"
 )
 
@@ -25,58 +26,235 @@ type AllocationConfig struct {
 	Nonce       stun.Nonce
 	Username    stun.Username
 	Realm       stun.Realm
-	Lifetime    time.Duration
+	Lifetime    time.Duration // Granted by the server
 	Net         transport.Net
 	Log         logging.LeveledLogger
+
+	// RequestedLifetime is the LIFETIME requested with every Refresh. The granted
+	// lifetime is requested again if unset
+	RequestedLifetime time.Duration
+
+	// RefreshInterval returns the interval the allocation is refreshed at for the granted
+	// lifetime. Defaults to DefaultRefreshInterval
+	RefreshInterval func(granted time.Duration) time.Duration
+
+	// TransactionID is used to set the transaction ID of the requests sent for the
+	// allocation. Defaults to stun.TransactionID
+	TransactionID stun.Setter
+
+	// MobilityTicket is the RFC 8016 MOBILITY-TICKET the server granted the allocation.
+	// It is sent with every Refresh, so the allocation follows the client to a new address
+	MobilityTicket proto.MobilityTicket
+
+	// AccessToken is the RFC 7635 ACCESS-TOKEN sent with every authenticated request if
+	// the client uses third-party authorization
+	AccessToken proto.AccessToken
+
+	// AffinityToken is the AFFINITY-TOKEN of the server sent with every request, so load
+	// balancers route them to the server holding the allocation
+	AffinityToken proto.AffinityToken
+
+	// ResolveAddr resolves peer addresses of types other than *net.UDPAddr and
+	// *net.TCPAddr. Defaults to Net.ResolveUDPAddr
+	ResolveAddr AddrResolver
+
+	// NAT64Prefix, if set, translates peer addresses within the /96 prefix to the embedded
+	// IPv4 address if RelayedAddr is IPv4
+	NAT64Prefix *net.IPNet
+
+	// EagerConnectivityChecks sends STUN Binding requests to peers without a permission
+	// right away as Send indications, while the permission is created in the background.
+	// The server drops them until the permission is installed, which ICE tolerates as it
+	// retransmits its checks
+	EagerConnectivityChecks bool
+
+	// DisableChannelBindings relays all data in Send and Data indications, no channels are
+	// bound
+	DisableChannelBindings bool
+
+	// ChannelUpgrade is the traffic to a peer after which WriteTo binds a channel to it
+	ChannelUpgrade ChannelUpgrade
+}
+
+// maxRefreshInterval is the longest interval DefaultRefreshInterval refreshes at
+const maxRefreshInterval = 5 * time.Minute
+
+// DefaultRefreshInterval refreshes allocations after half of the granted lifetime, at most
+// every 5 minutes, so a lost Refresh is retried well before the allocation expires
+func DefaultRefreshInterval(granted time.Duration) time.Duration {
+	if interval := granted / 2; interval < maxRefreshInterval {
+		return interval
+	}
+	return maxRefreshInterval
+}
+
+// AddrResolver resolves the host:port form of an address to a *net.UDPAddr
+type AddrResolver func(network, address string) (*net.UDPAddr, error)
+
+// serverState is the state of an allocation specific to the server it was created on,
+// replaced when the client fails over to another server
+type serverState struct {
+	serverAddr     net.Addr
+	relayedAddr    net.Addr
+	realm          stun.Realm
+	mobilityTicket proto.MobilityTicket
+	affinityToken  proto.AffinityToken
+}
+
+func newServerState(config *AllocationConfig) serverState {
+	return serverState{
+		serverAddr:     config.ServerAddr,
+		relayedAddr:    config.RelayedAddr,
+		realm:          config.Realm,
+		mobilityTicket: config.MobilityTicket,
+		affinityToken:  config.AffinityToken,
+	}
 }
 
 type allocation struct {
-	client            Client                // Read-only
-	relayedAddr       net.Addr              // Read-only
-	serverAddr        net.Addr              // Read-only
-	permMap           *permissionMap        // Thread-safe
-	integrity         stun.MessageIntegrity // Read-only
-	username          stun.Username         // Read-only
-	realm             stun.Realm            // Read-only
-	_nonce            stun.Nonce            // Needs mutex x
-	_lifetime         time.Duration         // Needs mutex x
-	net               transport.Net         // Thread-safe
-	refreshAllocTimer *PeriodicTimer        // Thread-safe
-	refreshPermsTimer *PeriodicTimer        // Thread-safe
-	readTimer         *time.Timer           // Thread-safe
-	mutex             sync.RWMutex          // Thread-safe
-	log               logging.LeveledLogger // Read-only
-}
-
-func (a *allocation) setNonceFromMsg(msg *stun.Message) {
-	// Update nonce
+	client            Client                            // Read-only
+	_server           serverState                       // Needs mutex x
+	permMap           *permissionMap                    // Thread-safe
+	integrity         stun.MessageIntegrity             // Needs mutex x
+	username          stun.Username                     // Needs mutex x
+	_nonce            stun.Nonce                        // Needs mutex x
+	_lifetime         time.Duration                     // Needs mutex x
+	requestedLifetime time.Duration                     // Read-only
+	refreshInterval   func(time.Duration) time.Duration // Read-only
+	net               transport.Net                     // Thread-safe
+	refreshAllocTimer *PeriodicTimer                    // Thread-safe
+	refreshPermsTimer *PeriodicTimer                    // Thread-safe
+	readTimer         *time.Timer                       // Thread-safe
+	transactionID     stun.Setter                       // Read-only
+	accessToken       proto.AccessToken                 // Read-only
+	resolveAddr       AddrResolver                      // Read-only
+	nat64Prefix       *net.IPNet                        // Read-only
+	eagerChecks       bool                              // Read-only
+	noChannelBindings bool                              // Read-only
+	channelUpgrade    ChannelUpgrade                    // Read-only
+	mutex             sync.RWMutex                      // Thread-safe
+	log               logging.LeveledLogger             // Read-only
+}
+
+func (a *allocation) newTransactionID() stun.Setter {
+	if a.transactionID == nil {
+		return stun.TransactionID
+	}
+
+	return a.transactionID
+}
+
+// udpAddr returns the IP and port of a peer address as *net.UDPAddr. Addresses of other
+// types than *net.UDPAddr and *net.TCPAddr, e.g. of conns wrapped from Apple's Network
+// framework or of overlay networks, are resolved from their string form with the
+// AllocationConfig.ResolveAddr resolver. NAT64 addresses are translated to IPv4, see
+// AllocationConfig.NAT64Prefix
+func (a *allocation) udpAddr(addr net.Addr) (*net.UDPAddr, error) {
+	var udpAddr *net.UDPAddr
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		udpAddr = addr
+	case *net.TCPAddr:
+		udpAddr = &net.UDPAddr{IP: addr.IP, Port: addr.Port, Zone: addr.Zone}
+	case nil:
+		return nil, errUDPAddrCast
+	default:
+		resolveAddr := a.resolveAddr
+		if resolveAddr == nil {
+			resolveAddr = a.net.ResolveUDPAddr
+		}
+
+		var err error
+		if udpAddr, err = resolveAddr("udp", addr.String()); err != nil {
+			return nil, fmt.Errorf("%w: %s", errUDPAddrCast, err.Error())
+		}
+	}
+
+	if a.nat64Prefix == nil {
+		return udpAddr, nil
+	}
+
+	if relayIP, _, err := ipnet.AddrIPPort(a.server().relayedAddr); err != nil || relayIP.To4() == nil {
+		return udpAddr, nil
+	}
+
+	if ip, ok := ipnet.ExtractNAT64(a.nat64Prefix, udpAddr.IP); ok {
+		return &net.UDPAddr{IP: ip, Port: udpAddr.Port}, nil
+	}
+
+	return udpAddr, nil
+}
+
+// setNonceFromMsg takes the NONCE of the 438 (Stale Nonce) error msg, it returns false if
+// msg has none
+func (a *allocation) setNonceFromMsg(msg *stun.Message) bool {
 	var nonce stun.Nonce
-	if err := nonce.GetFrom(msg); err == nil {
-		a.setNonce(nonce)
-		a.log.Debug("Refresh allocation: 438, got new nonce.")
-	} else {
-		a.log.Warn("Refresh allocation: 438 but no nonce.")
+	if err := nonce.GetFrom(msg); err != nil {
+		a.log.Warnf("%s: 438 but no nonce", msg.Type)
+		return false
 	}
+
+	a.setNonce(nonce)
+	a.log.Debugf("%s: 438, got new nonce", msg.Type)
+	return true
 }
 
-func (a *allocation) refreshAllocation(lifetime time.Duration, dontWait bool) error {
-	msg, err := stun.Build(
-		stun.TransactionID,
-		stun.NewType(stun.MethodRefresh, stun.ClassRequest),
-		proto.Lifetime{Duration: lifetime},
-		a.username,
-		a.realm,
-		a.nonce(),
-		a.integrity,
-		stun.Fingerprint,
-	)
-	if err != nil {
-		return fmt.Errorf("%w: %s", errFailedToBuildRefreshRequest, err.Error())
+// isStaleNonce returns true if res is a 438 (Stale Nonce) error
+func isStaleNonce(res *stun.Message) bool {
+	var code stun.ErrorCodeAttribute
+	return res.Type.Class == stun.ClassErrorResponse &&
+		code.GetFrom(res) == nil && code.Code == stun.CodeStaleNonce
+}
+
+// performTransaction builds a request with build and performs it with the server. Servers
+// rotate their nonces, so a 438 (Stale Nonce) error is retried once with its NONCE: the
+// request is built again, with a new transaction ID and the MESSAGE-INTEGRITY computed
+// over the new nonce
+func (a *allocation) performTransaction(build func() (*stun.Message, error), dontWait bool) (TransactionResult, error) {
+	for attempt := 0; ; attempt++ {
+		msg, err := build()
+		if err != nil {
+			return TransactionResult{}, err
+		}
+
+		trRes, err := a.client.PerformTransaction(msg, a.server().serverAddr, dontWait)
+		if err != nil || dontWait || attempt > 0 || !isStaleNonce(trRes.Msg) || !a.setNonceFromMsg(trRes.Msg) {
+			return trRes, err
+		}
 	}
+}
 
+func (a *allocation) refreshAllocation(lifetime time.Duration, dontWait bool) error {
 	a.log.Debugf("Send refresh request (dontWait=%v)", dontWait)
-	trRes, err := a.client.PerformTransaction(msg, a.serverAddr, dontWait)
-	if err != nil {
+	trRes, err := a.performTransaction(func() (*stun.Message, error) {
+		setters := []stun.Setter{
+			a.newTransactionID(),
+			stun.NewType(stun.MethodRefresh, stun.ClassRequest),
+			proto.Lifetime{Duration: lifetime},
+		}
+		srv := a.server()
+		if len(srv.mobilityTicket) != 0 {
+			setters = append(setters, srv.mobilityTicket)
+		}
+
+		username, integrity := a.credentials()
+		msg, err := stun.Build(append(setters,
+			username,
+			a.accessToken,
+			srv.affinityToken,
+			srv.realm,
+			a.nonce(),
+			integrity,
+			stun.Fingerprint,
+		)...)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", errFailedToBuildRefreshRequest, err.Error())
+		}
+		return msg, nil
+	}, dontWait)
+	if errors.Is(err, errFailedToBuildRefreshRequest) {
+		return err
+	} else if err != nil {
 		return fmt.Errorf("%w: %s", errFailedToRefreshAllocation, err.Error())
 	}
 
@@ -89,15 +267,7 @@ func (a *allocation) refreshAllocation(lifetime time.Duration, dontWait bool) er
 
 	res := trRes.Msg
 	if res.Type.Class == stun.ClassErrorResponse {
-		var code stun.ErrorCodeAttribute
-		if err = code.GetFrom(res); err == nil {
-			if code.Code == stun.CodeStaleNonce {
-				a.setNonceFromMsg(res)
-				return errTryAgain
-			}
-			return err
-		}
-		return fmt.Errorf("%s", res.Type) //nolint:goerr113
+		return proto.NewResponseError(res)
 	}
 
 	// Getting lifetime from response
@@ -106,11 +276,94 @@ func (a *allocation) refreshAllocation(lifetime time.Duration, dontWait bool) er
 		return fmt.Errorf("%w: %s", errFailedToGetLifetime, err.Error())
 	}
 
+	// RFC 8016 Section 3.3: every success response carries a new MOBILITY-TICKET
+	var ticket proto.MobilityTicket
+	if ticket.GetFrom(res) == nil && len(ticket) != 0 {
+		a.setMobilityTicket(trRes.From, ticket)
+	}
+
 	a.setLifetime(updatedLifetime.Duration)
 	a.log.Debugf("Updated lifetime: %d seconds", int(a.lifetime().Seconds()))
+	if updatedLifetime.Duration > 0 && a.refreshAllocTimer != nil {
+		a.refreshAllocTimer.SetInterval(a.refreshIntervalOf(updatedLifetime.Duration))
+	}
 	return nil
 }
 
+// RequestedLifetime returns the lifetime requested for the allocation, 0 if the client
+// requests the lifetime granted by the server
+func (a *allocation) RequestedLifetime() time.Duration {
+	return a.requestedLifetime
+}
+
+// GrantedLifetime returns the lifetime the server granted with the last Allocate or
+// Refresh response
+func (a *allocation) GrantedLifetime() time.Duration {
+	return a.lifetime()
+}
+
+// RefreshInterval returns the interval the allocation is refreshed at
+func (a *allocation) RefreshInterval() time.Duration {
+	return a.refreshAllocTimer.Interval()
+}
+
+// refreshLifetime returns the lifetime to request with a Refresh
+func (a *allocation) refreshLifetime() time.Duration {
+	if a.requestedLifetime != 0 {
+		return a.requestedLifetime
+	}
+	return a.lifetime()
+}
+
+func (a *allocation) refreshIntervalOf(granted time.Duration) time.Duration {
+	if a.refreshInterval == nil {
+		return DefaultRefreshInterval(granted)
+	}
+	return a.refreshInterval(granted)
+}
+
+// newRefreshAllocTimer creates the timer refreshing the allocation at the interval of
+// the granted lifetime
+func (a *allocation) newRefreshAllocTimer() *PeriodicTimer {
+	return NewPeriodicTimer(
+		timerIDRefreshAlloc,
+		a.onRefreshTimers,
+		a.refreshIntervalOf(a.lifetime()),
+	)
+}
+
+// Rehome refreshes the allocation with its MOBILITY-TICKET after the local address of the
+// client changed, so the server moves the allocation to the new address right away
+// instead of on the next scheduled refresh
+func (a *allocation) Rehome() error {
+	if len(a.server().mobilityTicket) == 0 {
+		return errNoMobilityTicket
+	}
+
+	return a.refreshAllocation(a.refreshLifetime(), false)
+}
+
+// Refresh refreshes the allocation and its permissions right away instead of on the next
+// scheduled refresh, e.g. after the transport to the server was down
+func (a *allocation) Refresh() error {
+	if err := a.refreshAllocation(a.refreshLifetime(), false); err != nil {
+		return err
+	}
+
+	return a.refreshPermissions()
+}
+
+// SetCredentials replaces the credentials of the allocation, e.g. before they expire, and
+// refreshes the allocation with them right away so the server validates them
+func (a *allocation) SetCredentials(username stun.Username, integrity stun.MessageIntegrity) error {
+	a.mutex.Lock()
+	a.username = username
+	a.integrity = integrity
+	a.mutex.Unlock()
+
+	return a.refreshAllocation(a.refreshLifetime(), false)
+}
+
 func (a *allocation) refreshPermissions() error {
 	addrs := a.permMap.addrs()
 	if len(addrs) == 0 {
@@ -118,9 +371,6 @@ func (a *allocation) refreshPermissions() error {
 		return nil
 	}
 	if err := a.CreatePermissions(addrs...); err != nil {
-		if errors.Is(err, errTryAgain) {
-			return errTryAgain
-		}
 		a.log.Errorf("Fail to refresh permissions: %s", err)
 		return err
 	}
@@ -132,33 +382,35 @@ func (a *allocation) onRefreshTimers(id int) {
 	a.log.Debugf("Refresh timer %d expired", id)
 	switch id {
 	case timerIDRefreshAlloc:
-		var err error
-		lifetime := a.lifetime()
-		// Limit the max retries on errTryAgain to 3
-		// when stale nonce returns, sencond retry should succeed
-		for i := 0; i < maxRetryAttempts; i++ {
-			err = a.refreshAllocation(lifetime, false)
-			if !errors.Is(err, errTryAgain) {
-				break
-			}
-		}
-		if err != nil {
+		if err := a.refreshAllocation(a.refreshLifetime(), false); err != nil {
 			a.log.Warnf("Failed to refresh allocation: %s", err)
 		}
 	case timerIDRefreshPerms:
-		var err error
-		for i := 0; i < maxRetryAttempts; i++ {
-			err = a.refreshPermissions()
-			if !errors.Is(err, errTryAgain) {
-				break
-			}
-		}
-		if err != nil {
+		if err := a.refreshPermissions(); err != nil {
 			a.log.Warnf("Failed to refresh permissions: %s", err)
 		}
 	}
 }
 
+func (a *allocation) server() serverState {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a._server
+}
+
+// setMobilityTicket replaces the mobility ticket with the one issued by server, unless the
+// allocation failed over to another server meanwhile
+func (a *allocation) setMobilityTicket(server net.Addr, ticket proto.MobilityTicket) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if server != nil && a._server.serverAddr != nil && server.String() != a._server.serverAddr.String() {
+		return
+	}
+	a._server.mobilityTicket = append(proto.MobilityTicket{}, ticket...)
+}
+
 func (a *allocation) nonce() stun.Nonce {
 	a.mutex.RLock()
 	defer a.mutex.RUnlock()
@@ -174,6 +426,13 @@ func (a *allocation) setNonce(nonce stun.Nonce) {
 	a._nonce = nonce
 }
 
+func (a *allocation) credentials() (stun.Username, stun.MessageIntegrity) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	return a.username, a.integrity
+}
+
 func (a *allocation) lifetime() time.Duration {
 	a.mutex.RLock()
 	defer a.mutex.RUnlock()
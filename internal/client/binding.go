@@ -30,16 +30,37 @@ const (
 )
 
 type binding struct {
+	lastSent int64 // Thread-safe (atomic op), first for 64-bit alignment
+
+	// Data received from the peer, see PeerStats. Thread-safe (atomic op), 64-bit aligned
+	// after lastSent
+	inboundIndications uint64
+	inboundChannelData uint64
+	staleIndications   uint64
+	channelDataSeen    int32
+
 	number       uint16          // Read-only
 	st           bindingState    // Thread-safe (atomic op)
 	addr         net.Addr        // Read-only
 	mgr          *bindingManager // Read-only
 	muBind       sync.Mutex      // Thread-safe, for ChannelBind ops
 	_refreshedAt time.Time       // Protected by mutex
+	keepalive    *time.Timer     // Protected by mutex
+	keepaliveGen int             // Protected by mutex
 	mutex        sync.RWMutex    // Thread-safe
+
+	// Traffic counted towards the ChannelUpgrade, protected by mutex
+	upgradeStart   time.Time
+	upgradePackets int
+	upgradeBytes   int
 }
 
 func (b *binding) setState(state bindingState) {
+	if state != bindingStateReady && state != bindingStateRefresh {
+		// The server relays the data of the peer in Data indications again, e.g. after a
+		// failover, until the channel is bound
+		atomic.StoreInt32(&b.channelDataSeen, 0)
+	}
 	atomic.StoreInt32((*int32)(&b.st), int32(state))
 }
 
@@ -130,6 +151,7 @@ func (mgr *bindingManager) deleteByAddr(addr net.Addr) bool {
 
 	delete(mgr.addrMap, addr.String())
 	delete(mgr.chanMap, b.number)
+	b.setKeepalive(0, nil)
 	return true
 }
 
@@ -144,9 +166,22 @@ func (mgr *bindingManager) deleteByNumber(number uint16) bool {
 
 	delete(mgr.addrMap, b.addr.String())
 	delete(mgr.chanMap, number)
+	b.setKeepalive(0, nil)
 	return true
 }
 
+// all returns the bindings of the manager
+func (mgr *bindingManager) all() []*binding {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+
+	bindings := make([]*binding, 0, len(mgr.chanMap))
+	for _, b := range mgr.chanMap {
+		bindings = append(bindings, b)
+	}
+	return bindings
+}
+
 func (mgr *bindingManager) size() int {
 	mgr.mutex.RLock()
 	defer mgr.mutex.RUnlock()
@@ -5,7 +5,9 @@ package client
 
 import (
 	"net"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -76,3 +78,72 @@ func TestBindingManager(t *testing.T) {
 		assert.False(t, ok, "should fail")
 	})
 }
+
+func TestBindingKeepalive(t *testing.T) {
+	m := newBindingManager()
+	b := m.create(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000})
+
+	var sent int32
+	b.setKeepalive(50*time.Millisecond, func() {
+		atomic.AddInt32(&sent, 1)
+	})
+
+	// Nothing is sent while the application sends
+	for i := 0; i < 20; i++ {
+		b.markSent(time.Now())
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&sent))
+
+	// Idle bindings get keepalives
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&sent) >= 2
+	}, time.Second, 5*time.Millisecond)
+
+	// Deleting the binding stops them
+	assert.True(t, m.deleteByNumber(b.number))
+	stopped := atomic.LoadInt32(&sent)
+	time.Sleep(120 * time.Millisecond)
+	assert.Equal(t, stopped, atomic.LoadInt32(&sent))
+}
+
+func TestChannelUpgrade(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234}
+	now := time.Now()
+
+	t.Run("unset", func(t *testing.T) {
+		b := newBindingManager().create(addr)
+		assert.True(t, ChannelUpgrade{}.due(b, 100, now))
+	})
+
+	t.Run("packets", func(t *testing.T) {
+		b := newBindingManager().create(addr)
+		u := ChannelUpgrade{Packets: 3, Window: time.Second}
+		assert.False(t, u.due(b, 100, now))
+		assert.False(t, u.due(b, 100, now.Add(100*time.Millisecond)))
+		assert.True(t, u.due(b, 100, now.Add(200*time.Millisecond)))
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		b := newBindingManager().create(addr)
+		u := ChannelUpgrade{Packets: 100, Bytes: 1000, Window: time.Second}
+		assert.False(t, u.due(b, 600, now))
+		assert.True(t, u.due(b, 600, now))
+	})
+
+	t.Run("window", func(t *testing.T) {
+		b := newBindingManager().create(addr)
+		u := ChannelUpgrade{Packets: 2, Window: time.Second}
+		assert.False(t, u.due(b, 100, now))
+		// The window expired, the count restarts
+		assert.False(t, u.due(b, 100, now.Add(2*time.Second)))
+		assert.True(t, u.due(b, 100, now.Add(2500*time.Millisecond)))
+	})
+
+	t.Run("default window", func(t *testing.T) {
+		b := newBindingManager().create(addr)
+		u := ChannelUpgrade{Packets: 2}
+		assert.False(t, u.due(b, 100, now))
+		assert.True(t, u.due(b, 100, now.Add(defaultChannelUpgradeWindow-time.Second)))
+	})
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"time"
+)
+
+// defaultChannelUpgradeWindow is the window of ChannelUpgrade if Window is unset
+const defaultChannelUpgradeWindow = 10 * time.Second
+
+// ChannelUpgrade is the traffic to a peer after which a channel is bound to it. Until
+// then data is sent in Send indications, so flows of a few datagrams, e.g. a single
+// connectivity check, don't cost a ChannelBind transaction. A channel is bound with the
+// first datagram if Packets and Bytes are unset
+type ChannelUpgrade struct {
+	// Packets is the number of datagrams sent to the peer within Window
+	Packets int
+	// Bytes is the number of bytes sent to the peer within Window
+	Bytes int
+	// Window is the duration traffic is counted in, from the first datagram counted.
+	// Defaults to 10 seconds
+	Window time.Duration
+}
+
+// due counts a datagram of n bytes sent to the peer of b, and returns true once the
+// traffic within the window reached Packets or Bytes
+func (u ChannelUpgrade) due(b *binding, n int, now time.Time) bool {
+	if u.Packets <= 0 && u.Bytes <= 0 {
+		return true
+	}
+
+	window := u.Window
+	if window <= 0 {
+		window = defaultChannelUpgradeWindow
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if now.Sub(b.upgradeStart) > window {
+		b.upgradeStart, b.upgradePackets, b.upgradeBytes = now, 0, 0
+	}
+	b.upgradePackets++
+	b.upgradeBytes += n
+
+	return (u.Packets > 0 && b.upgradePackets >= u.Packets) || (u.Bytes > 0 && b.upgradeBytes >= u.Bytes)
+}
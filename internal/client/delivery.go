@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+)
+
+// Delivery is how the server relays the data of a peer to the client
+type Delivery int
+
+const (
+	// DeliveryIndication is data relayed in Data indications, before a channel is bound
+	DeliveryIndication Delivery = iota
+	// DeliveryChannel is data relayed in ChannelData messages
+	DeliveryChannel
+)
+
+func (d Delivery) String() string {
+	switch d {
+	case DeliveryIndication:
+		return "indication"
+	case DeliveryChannel:
+		return "channel"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerStats is the data received from a peer the UDPConn has a binding for, i.e. wrote to
+type PeerStats struct {
+	Peer net.Addr
+	// Delivery is how the server currently relays the data of Peer
+	Delivery Delivery
+	// ChannelNumber is the channel bound to Peer, 0 if none is
+	ChannelNumber uint16
+	// Indications and ChannelData count the datagrams delivered in either way
+	Indications uint64
+	ChannelData uint64
+	// Stale counts the Data indications dropped because they arrived after ChannelData of
+	// Peer, reordered on the way from the server
+	Stale uint64
+}
+
+// HandleDataIndication passes the data of a Data indication from peer in UDPConn. Once the
+// server relays the data of peer in ChannelData, it only sends Data indications again
+// after the channel expired, which the binding would have failed to refresh first. Data
+// indications received until then were sent before the channel was bound and reordered
+// with the first ChannelData, they are dropped so the data of peer isn't delivered out of
+// order. Returns false if the data was dropped
+func (c *UDPConn) HandleDataIndication(data []byte, from net.Addr) bool {
+	if b, ok := c.bindingMgr.findByAddr(from); ok {
+		if atomic.LoadInt32(&b.channelDataSeen) == 1 {
+			atomic.AddUint64(&b.staleIndications, 1)
+			c.log.Debugf("Dropped Data indication from %s received after ChannelData", from)
+			return false
+		}
+		atomic.AddUint64(&b.inboundIndications, 1)
+	}
+
+	c.HandleInbound(data, from)
+	return true
+}
+
+// HandleChannelData passes the data of a ChannelData message on channel number in UDPConn
+// and returns the peer the channel is bound to, or false if there is no such channel. The
+// ChannelData may arrive before the success response to the ChannelBind request
+func (c *UDPConn) HandleChannelData(number uint16, data []byte) (net.Addr, bool) {
+	b, ok := c.bindingMgr.findByNumber(number)
+	if !ok {
+		return nil, false
+	}
+
+	atomic.StoreInt32(&b.channelDataSeen, 1)
+	atomic.AddUint64(&b.inboundChannelData, 1)
+	c.HandleInbound(data, b.addr)
+	return b.addr, true
+}
+
+// PeerStats returns the data received from the peers the UDPConn has a binding for,
+// ordered by channel number
+func (c *UDPConn) PeerStats() []PeerStats {
+	bindings := c.bindingMgr.all()
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i].number < bindings[j].number })
+
+	stats := make([]PeerStats, 0, len(bindings))
+	for _, b := range bindings {
+		s := PeerStats{
+			Peer:        b.addr,
+			Indications: atomic.LoadUint64(&b.inboundIndications),
+			ChannelData: atomic.LoadUint64(&b.inboundChannelData),
+			Stale:       atomic.LoadUint64(&b.staleIndications),
+		}
+		if atomic.LoadInt32(&b.channelDataSeen) == 1 {
+			s.Delivery = DeliveryChannel
+		}
+		if st := b.state(); st == bindingStateReady || st == bindingStateRefresh {
+			s.ChannelNumber = b.number
+		}
+		stats = append(stats, s)
+	}
+	return stats
+}
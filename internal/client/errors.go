@@ -9,7 +9,6 @@ import (
 
 var (
 	errFake                                = errors.New("fake error")
-	errTryAgain                            = errors.New("try again")
 	errClosed                              = errors.New("use of closed network connection")
 	errTCPAddrCast                         = errors.New("addr is not a TCP address")
 	errUDPAddrCast                         = errors.New("addr is not a UDP address")
@@ -22,6 +21,10 @@ var (
 	errFailedToGetLifetime                 = errors.New("failed to get lifetime from refresh response")
 	errInvalidTURNAddress                  = errors.New("invalid TURN server address")
 	errUnexpectedSTUNRequestMessage        = errors.New("unexpected STUN request message")
+	errFailedToGenerateTransactionID       = errors.New("failed to generate transaction ID")
+	errInsecureTransactionID               = errors.New("transaction ID does not look random")
+	errNoMobilityTicket                    = errors.New("allocation has no MOBILITY-TICKET")
+	errNoChannelBinding                    = errors.New("no channel binding for peer")
 )
 
 type timeoutError struct {
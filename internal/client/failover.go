@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// Failover moves the allocation to another server, e.g. after the previous one stopped
+// answering, once the client allocated there. ServerAddr, RelayedAddr, Realm, Username,
+// Integrity, Nonce, Lifetime, MobilityTicket and AffinityToken are taken from config, the
+// other fields are ignored. The permissions and channel bindings of the allocation are then created again
+// on the new server, keeping their channel numbers, so the application can keep using the
+// conn with its new LocalAddr
+func (c *UDPConn) Failover(config *AllocationConfig) error {
+	c.mutex.Lock()
+	c._server = newServerState(config)
+	c.username = config.Username
+	c.integrity = config.Integrity
+	c._nonce = config.Nonce
+	c._lifetime = config.Lifetime
+	c.mutex.Unlock()
+
+	if config.Lifetime > 0 {
+		c.refreshAllocTimer.SetInterval(c.refreshIntervalOf(config.Lifetime))
+	}
+
+	if err := c.restorePermissions(); err != nil {
+		return err
+	}
+	return c.restoreBindings()
+}
+
+// restorePermissions creates all permissions of the allocation in one transaction
+func (c *UDPConn) restorePermissions() error {
+	addrs := c.permMap.addrs()
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	return c.CreatePermissions(addrs...)
+}
+
+// restoreBindings binds the channels of all bindings that were bound or being bound.
+// Idle bindings are bound on the next WriteTo as usual
+func (c *UDPConn) restoreBindings() error {
+	bindings := c.bindingMgr.all()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(bindings))
+	for _, b := range bindings {
+		b.muBind.Lock()
+		if b.state() == bindingStateIdle {
+			b.muBind.Unlock()
+			continue
+		}
+		b.setState(bindingStateRequest)
+		b.muBind.Unlock()
+
+		wg.Add(1)
+		go func(b *binding) {
+			defer wg.Done()
+
+			if err := c.bind(b); err != nil {
+				b.setState(bindingStateFailed)
+				errs <- err
+				return
+			}
+			b.setRefreshedAt(time.Now())
+			b.setState(bindingStateReady)
+		}(b)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// markSent records that the application sent data to the peer of the binding
+func (b *binding) markSent(at time.Time) {
+	atomic.StoreInt64(&b.lastSent, at.UnixNano())
+}
+
+// idle returns for how long nothing was sent to the peer of the binding
+func (b *binding) idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, atomic.LoadInt64(&b.lastSent)))
+}
+
+// setKeepalive calls send whenever nothing was sent on the binding for interval. It
+// replaces the previous keepalive, an interval of 0 stops it
+func (b *binding) setKeepalive(interval time.Duration, send func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.keepaliveGen++
+	if b.keepalive != nil {
+		b.keepalive.Stop()
+		b.keepalive = nil
+	}
+	if interval <= 0 {
+		return
+	}
+
+	gen := b.keepaliveGen
+	var fire func()
+	fire = func() {
+		wait := interval
+		if idle := b.idle(time.Now()); idle >= interval {
+			send()
+		} else {
+			wait = interval - idle
+		}
+
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		// The keepalive was replaced or stopped while sending
+		if b.keepaliveGen == gen {
+			b.keepalive = time.AfterFunc(wait, fire)
+		}
+	}
+	b.keepalive = time.AfterFunc(interval, fire)
+}
+
+// stopKeepalives stops the keepalives of all bindings
+func (mgr *bindingManager) stopKeepalives() {
+	mgr.mutex.RLock()
+	defer mgr.mutex.RUnlock()
+
+	for _, b := range mgr.chanMap {
+		b.setKeepalive(0, nil)
+	}
+}
+
+// SetChannelKeepalive sends an empty ChannelData message to the peer at addr whenever the
+// application didn't send it anything for interval, so NAT mappings in front of the peer
+// stay open while the application is silent, e.g. during silence suppression. The server
+// relays it as an empty UDP datagram. The peer must have a channel binding, which WriteTo
+// creates on the first datagram to it. An interval of 0 stops the keepalives
+func (c *UDPConn) SetChannelKeepalive(addr net.Addr, interval time.Duration) error {
+	udpAddr, err := c.udpAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	b, ok := c.bindingMgr.findByAddr(udpAddr)
+	if !ok {
+		return fmt.Errorf("%w: %s", errNoChannelBinding, addr)
+	}
+
+	b.setKeepalive(interval, func() {
+		c.sendKeepalive(b)
+	})
+
+	return nil
+}
+
+func (c *UDPConn) sendKeepalive(b *binding) {
+	if st := b.state(); st != bindingStateReady && st != bindingStateRefresh {
+		return
+	}
+
+	// Keepalives keep the channel binding alive too
+	c.refreshBinding(b)
+
+	b.markSent(time.Now())
+	if _, err := c.sendChannelData(nil, b.number); err != nil {
+		c.log.Debugf("Failed to send keepalive to %s: %s", b.addr, err)
+	}
+}
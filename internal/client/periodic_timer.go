@@ -39,13 +39,18 @@ func (t *PeriodicTimer) Start() bool {
 		return false
 	}
 
+	t.start()
+	return true
+}
+
+func (t *PeriodicTimer) start() {
 	cancelCh := make(chan struct{})
 
 	go func() {
 		canceling := false
 
 		for !canceling {
-			timer := time.NewTimer(t.interval)
+			timer := time.NewTimer(t.Interval())
 
 			select {
 			case <-timer.C:
@@ -60,8 +65,31 @@ func (t *PeriodicTimer) Start() bool {
 	t.stopFunc = func() {
 		close(cancelCh)
 	}
+}
 
-	return true
+// Interval returns the interval of the timer
+func (t *PeriodicTimer) Interval() time.Duration {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return t.interval
+}
+
+// SetInterval changes the interval of the timer. A running timer is restarted, so the
+// next timeout is interval from now.
+func (t *PeriodicTimer) SetInterval(interval time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.interval == interval {
+		return
+	}
+
+	t.interval = interval
+	if t.stopFunc != nil {
+		t.stopFunc()
+		t.start()
+	}
 }
 
 // Stop stops the timer.
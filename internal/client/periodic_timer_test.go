@@ -53,4 +53,22 @@ func TestPeriodicTimer(t *testing.T) {
 		time.Sleep(30 * time.Millisecond)
 		assert.False(t, rt.IsRunning(), "should not be running")
 	})
+	t.Run("set interval", func(t *testing.T) {
+		var nCbs uint64
+		rt := NewPeriodicTimer(5, func(int) {
+			atomic.AddUint64(&nCbs, 1)
+		}, time.Hour)
+
+		rt.SetInterval(time.Minute)
+		assert.Equal(t, time.Minute, rt.Interval())
+		assert.False(t, rt.IsRunning(), "should not be started by SetInterval")
+
+		assert.True(t, rt.Start())
+		rt.SetInterval(20 * time.Millisecond)
+		assert.True(t, rt.IsRunning(), "should be running")
+
+		time.Sleep(50 * time.Millisecond)
+		rt.Stop()
+		assert.Equal(t, 2, int(atomic.LoadUint64(&nCbs)), "should be called 2 times (actual: %d)", atomic.LoadUint64(&nCbs))
+	})
 }
@@ -19,9 +19,10 @@ const (
 )
 
 type permission struct {
-	addr  net.Addr
-	st    permState    // Thread-safe (atomic op)
-	mutex sync.RWMutex // Thread-safe
+	addr    net.Addr
+	st      permState    // Thread-safe (atomic op)
+	mutex   sync.RWMutex // Thread-safe
+	pending TryLock      // Thread-safe, held while the permission is created in the background
 }
 
 func (p *permission) setState(state permState) {
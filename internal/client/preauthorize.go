@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v3/internal/ipnet"
+)
+
+// PreauthorizePeers creates the permissions of peers the application expects traffic
+// with, e.g. the remote candidates of ICE, in a single CreatePermission transaction, so
+// neither the first packet to them nor the first one from them waits for a permission.
+// Peers that already have a permission are skipped. If bindChannels is set, channels are
+// bound to the peers too, so the first packets are sent as ChannelData.
+func (c *UDPConn) PreauthorizePeers(peers []net.Addr, bindChannels bool) error {
+	addrs := make([]net.Addr, 0, len(peers))
+	unpermitted := []net.Addr{}
+	seen := map[string]bool{}
+	for _, peer := range peers {
+		udpAddr, err := c.udpAddr(peer)
+		if err != nil {
+			return err
+		}
+		addrs = append(addrs, udpAddr)
+
+		key := ipnet.FingerprintAddr(udpAddr)
+		if _, ok := c.permMap.find(udpAddr); ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		unpermitted = append(unpermitted, udpAddr)
+	}
+
+	if len(unpermitted) != 0 {
+		if err := c.CreatePermissions(unpermitted...); err != nil {
+			return err
+		}
+	}
+
+	if !bindChannels || c.noChannelBindings {
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		b, ok := c.bindingMgr.findByAddr(addr)
+		if !ok {
+			b = c.bindingMgr.create(addr)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- c.bindIdle(b)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindIdle binds the channel of b unless it is already bound or being bound
+func (c *UDPConn) bindIdle(b *binding) error {
+	b.muBind.Lock()
+	if b.state() != bindingStateIdle {
+		b.muBind.Unlock()
+		return nil
+	}
+	b.setState(bindingStateRequest)
+	b.muBind.Unlock()
+
+	if err := c.bind(b); err != nil {
+		b.setState(bindingStateFailed)
+		return err
+	}
+
+	b.setRefreshedAt(time.Now())
+	b.setState(bindingStateReady)
+	return nil
+}
@@ -5,7 +5,6 @@ package client
 
 import (
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"math"
 	"net"
@@ -13,6 +12,7 @@ import (
 
 	"github.com/pion/stun/v2"
 	"github.com/pion/transport/v3"
+	"github.com/pion/turn/v3/internal/ipnet"
 	"github.com/pion/turn/v3/internal/proto"
 )
 
@@ -40,27 +40,27 @@ func NewTCPAllocation(config *AllocationConfig) *TCPAllocation {
 		connAttemptCh: make(chan *connectionAttempt, 10),
 		acceptTimer:   time.NewTimer(time.Duration(math.MaxInt64)),
 		allocation: allocation{
-			client:      config.Client,
-			relayedAddr: config.RelayedAddr,
-			serverAddr:  config.ServerAddr,
-			username:    config.Username,
-			realm:       config.Realm,
-			permMap:     newPermissionMap(),
-			integrity:   config.Integrity,
-			_nonce:      config.Nonce,
-			_lifetime:   config.Lifetime,
-			net:         config.Net,
-			log:         config.Log,
+			client:            config.Client,
+			_server:           newServerState(config),
+			username:          config.Username,
+			permMap:           newPermissionMap(),
+			integrity:         config.Integrity,
+			_nonce:            config.Nonce,
+			_lifetime:         config.Lifetime,
+			requestedLifetime: config.RequestedLifetime,
+			refreshInterval:   config.RefreshInterval,
+			net:               config.Net,
+			transactionID:     config.TransactionID,
+			accessToken:       config.AccessToken,
+			resolveAddr:       config.ResolveAddr,
+			nat64Prefix:       config.NAT64Prefix,
+			log:               config.Log,
 		},
 	}
 
 	a.log.Debugf("Initial lifetime: %d seconds", int(a.lifetime().Seconds()))
 
-	a.refreshAllocTimer = NewPeriodicTimer(
-		timerIDRefreshAlloc,
-		a.onRefreshTimers,
-		a.lifetime()/2,
-	)
+	a.refreshAllocTimer = a.newRefreshAllocTimer()
 
 	a.refreshPermsTimer = NewPeriodicTimer(
 		timerIDRefreshPerms,
@@ -80,24 +80,28 @@ func NewTCPAllocation(config *AllocationConfig) *TCPAllocation {
 
 // Connect sends a Connect request to the turn server and returns a chosen connection ID
 func (a *TCPAllocation) Connect(peer net.Addr) (proto.ConnectionID, error) {
-	setters := []stun.Setter{
-		stun.TransactionID,
-		stun.NewType(stun.MethodConnect, stun.ClassRequest),
-		addr2PeerAddress(peer),
-		a.username,
-		a.realm,
-		a.nonce(),
-		a.integrity,
-		stun.Fingerprint,
-	}
-
-	msg, err := stun.Build(setters...)
+	peerAddr, err := a.peerAddress(peer)
 	if err != nil {
 		return 0, err
 	}
 
 	a.log.Debugf("Send connect request (peer=%v)", peer)
-	trRes, err := a.client.PerformTransaction(msg, a.serverAddr, false)
+	trRes, err := a.performTransaction(func() (*stun.Message, error) {
+		srv := a.server()
+		username, integrity := a.credentials()
+		return stun.Build(
+			a.newTransactionID(),
+			stun.NewType(stun.MethodConnect, stun.ClassRequest),
+			peerAddr,
+			username,
+			a.accessToken,
+			srv.affinityToken,
+			srv.realm,
+			a.nonce(),
+			integrity,
+			stun.Fingerprint,
+		)
+	}, false)
 	if err != nil {
 		return 0, err
 	}
@@ -105,12 +109,7 @@ func (a *TCPAllocation) Connect(peer net.Addr) (proto.ConnectionID, error) {
 	res := trRes.Msg
 
 	if res.Type.Class == stun.ClassErrorResponse {
-		var code stun.ErrorCodeAttribute
-		if err = code.GetFrom(res); err == nil {
-			return 0, fmt.Errorf("%s (error %s)", res.Type, code) //nolint:goerr113
-		}
-
-		return 0, fmt.Errorf("%s", res.Type) //nolint:goerr113
+		return 0, proto.NewResponseError(res)
 	}
 
 	var cid proto.ConnectionID
@@ -145,15 +144,16 @@ func (a *TCPAllocation) DialWithConn(conn net.Conn, network, rAddrStr string) (*
 
 // DialTCP acts like Dial for TCP networks.
 func (a *TCPAllocation) DialTCP(network string, lAddr, rAddr *net.TCPAddr) (*TCPConn, error) {
-	var rAddrServer *net.TCPAddr
-	if addr, ok := a.serverAddr.(*net.TCPAddr); ok {
-		rAddrServer = &net.TCPAddr{
-			IP:   addr.IP,
-			Port: addr.Port,
-		}
-	} else {
+	// The client resolves the server address as UDP even if the control
+	// connection is a stream, data connections go to the same IP and port
+	serverIP, serverPort, err := ipnet.AddrIPPort(a.server().serverAddr)
+	if err != nil {
 		return nil, errInvalidTURNAddress
 	}
+	rAddrServer := &net.TCPAddr{
+		IP:   serverIP,
+		Port: serverPort,
+	}
 
 	conn, err := a.net.DialTCP(network, lAddr, rAddrServer)
 	if err != nil {
@@ -179,12 +179,7 @@ func (a *TCPAllocation) DialTCPWithConn(conn net.Conn, _ string, rAddr *net.TCPA
 		a.permMap.insert(rAddr, perm)
 	}
 
-	for i := 0; i < maxRetryAttempts; i++ {
-		if err = a.createPermission(perm, rAddr); !errors.Is(err, errTryAgain) {
-			break
-		}
-	}
-	if err != nil {
+	if err = a.createPermission(perm, rAddr); err != nil {
 		return nil, err
 	}
 
@@ -215,14 +210,18 @@ func (a *TCPAllocation) DialTCPWithConn(conn net.Conn, _ string, rAddr *net.TCPA
 
 // BindConnection associates the provided connection
 func (a *TCPAllocation) BindConnection(dataConn *TCPConn, cid proto.ConnectionID) error {
+	srv := a.server()
+	username, integrity := a.credentials()
 	msg, err := stun.Build(
-		stun.TransactionID,
+		a.newTransactionID(),
 		stun.NewType(stun.MethodConnectionBind, stun.ClassRequest),
 		cid,
-		a.username,
-		a.realm,
+		username,
+		a.accessToken,
+		srv.affinityToken,
+		srv.realm,
 		a.nonce(),
-		a.integrity,
+		integrity,
 		stun.Fingerprint,
 	)
 	if err != nil {
@@ -263,11 +262,7 @@ func (a *TCPAllocation) BindConnection(dataConn *TCPConn, cid proto.ConnectionID
 
 	switch res.Type.Class {
 	case stun.ClassErrorResponse:
-		var code stun.ErrorCodeAttribute
-		if err = code.GetFrom(res); err == nil {
-			return fmt.Errorf("%s (error %s)", res.Type, code) //nolint:goerr113
-		}
-		return fmt.Errorf("%s", res.Type) //nolint:goerr113
+		return proto.NewResponseError(res)
 	case stun.ClassSuccessResponse:
 		a.log.Debug("Successful connectionBind request")
 		return nil
@@ -283,7 +278,7 @@ func (a *TCPAllocation) Accept() (net.Conn, error) {
 
 // AcceptTCP accepts the next incoming call and returns the new connection.
 func (a *TCPAllocation) AcceptTCP() (transport.TCPConn, error) {
-	addr, err := net.ResolveTCPAddr("tcp4", a.serverAddr.String())
+	addr, err := net.ResolveTCPAddr("tcp4", a.server().serverAddr.String())
 	if err != nil {
 		return nil, err
 	}
@@ -352,13 +347,13 @@ func (a *TCPAllocation) Close() error {
 	a.refreshAllocTimer.Stop()
 	a.refreshPermsTimer.Stop()
 
-	a.client.OnDeallocated(a.relayedAddr)
+	a.client.OnDeallocated(a.server().relayedAddr)
 	return a.refreshAllocation(0, true /* dontWait=true */)
 }
 
 // Addr returns the relayed address of the allocation
 func (a *TCPAllocation) Addr() net.Addr {
-	return a.relayedAddr
+	return a.server().relayedAddr
 }
 
 // HandleConnectionAttempt is called by the TURN client
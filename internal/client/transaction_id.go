@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"fmt"
+
+	"github.com/pion/stun/v2"
+)
+
+// minTransactionIDDistinctBytes is the minimum number of distinct byte values a
+// validated transaction ID must contain. A uniformly random 96 bit ID has ~11.7 on
+// average, so this only rejects counters, constants and other obviously predictable
+// values while leaving room for a few bytes of application defined hints.
+const minTransactionIDDistinctBytes = 6
+
+// TransactionIDGenerator returns the transaction ID for a new STUN message
+type TransactionIDGenerator func() ([stun.TransactionIDSize]byte, error)
+
+type transactionIDSetter struct {
+	generate TransactionIDGenerator
+	validate bool
+}
+
+// NewTransactionIDSetter returns a stun.Setter that sets the transaction ID of a message
+// to the value returned by generate. If validate is true, IDs that do not look like they
+// come from a random source are rejected with ValidateTransactionID.
+// A nil generate returns stun.TransactionID, which uses crypto/rand.
+func NewTransactionIDSetter(generate TransactionIDGenerator, validate bool) stun.Setter {
+	if generate == nil {
+		return stun.TransactionID
+	}
+
+	return &transactionIDSetter{
+		generate: generate,
+		validate: validate,
+	}
+}
+
+// AddTo sets the transaction ID of m
+func (s *transactionIDSetter) AddTo(m *stun.Message) error {
+	id, err := s.generate()
+	if err != nil {
+		return fmt.Errorf("%w: %s", errFailedToGenerateTransactionID, err.Error())
+	}
+
+	if s.validate {
+		if err := ValidateTransactionID(id); err != nil {
+			return err
+		}
+	}
+
+	m.TransactionID = id
+	m.WriteTransactionID()
+	return nil
+}
+
+// ValidateTransactionID checks that id is suitable as a STUN transaction ID. RFC 5389
+// requires the transaction ID to be uniformly and randomly chosen, which can't be proven
+// for a single value, so this rejects IDs that are clearly not random.
+func ValidateTransactionID(id [stun.TransactionIDSize]byte) error {
+	seen := map[byte]struct{}{}
+	for _, b := range id {
+		seen[b] = struct{}{}
+	}
+
+	if len(seen) < minTransactionIDDistinctBytes {
+		return fmt.Errorf("%w: %x", errInsecureTransactionID, id)
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"testing"
+
+	"github.com/pion/stun/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionIDSetter(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		assert.Equal(t, stun.TransactionID, NewTransactionIDSetter(nil, true))
+	})
+
+	t.Run("Custom", func(t *testing.T) {
+		id := [stun.TransactionIDSize]byte{0x01, 0xde, 0xad, 0xbe, 0xef, 0x13, 0x37, 0x42, 0x99, 0xa0, 0x0b, 0x7c}
+		setter := NewTransactionIDSetter(func() ([stun.TransactionIDSize]byte, error) {
+			return id, nil
+		}, true)
+
+		msg, err := stun.Build(setter, stun.BindingRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, id, msg.TransactionID)
+
+		decoded := &stun.Message{Raw: msg.Raw}
+		assert.NoError(t, decoded.Decode())
+		assert.Equal(t, id, decoded.TransactionID)
+	})
+
+	t.Run("Reject insecure", func(t *testing.T) {
+		setter := NewTransactionIDSetter(func() ([stun.TransactionIDSize]byte, error) {
+			return [stun.TransactionIDSize]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, nil
+		}, true)
+
+		_, err := stun.Build(setter, stun.BindingRequest)
+		assert.ErrorIs(t, err, errInsecureTransactionID)
+	})
+
+	t.Run("Allow insecure", func(t *testing.T) {
+		id := [stun.TransactionIDSize]byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+		setter := NewTransactionIDSetter(func() ([stun.TransactionIDSize]byte, error) {
+			return id, nil
+		}, false)
+
+		msg, err := stun.Build(setter, stun.BindingRequest)
+		assert.NoError(t, err)
+		assert.Equal(t, id, msg.TransactionID)
+	})
+
+	t.Run("Generator error", func(t *testing.T) {
+		setter := NewTransactionIDSetter(func() ([stun.TransactionIDSize]byte, error) {
+			return [stun.TransactionIDSize]byte{}, errFake
+		}, true)
+
+		_, err := stun.Build(setter, stun.BindingRequest)
+		assert.ErrorIs(t, err, errFailedToGenerateTransactionID)
+	})
+}
+
+func TestValidateTransactionID(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, ValidateTransactionID(stun.NewTransactionID()))
+	}
+
+	assert.Error(t, ValidateTransactionID([stun.TransactionIDSize]byte{}))
+	assert.Error(t, ValidateTransactionID([stun.TransactionIDSize]byte{1, 2, 3, 1, 2, 3, 1, 2, 3, 1, 2, 3}))
+}
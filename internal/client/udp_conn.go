@@ -5,7 +5,7 @@
 package client
 
 import (
-	"errors"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
@@ -19,7 +19,6 @@ import (
 const (
 	maxReadQueueSize    = 1024
 	permRefreshInterval = 120 * time.Second
-	maxRetryAttempts    = 3
 )
 
 const (
@@ -48,28 +47,31 @@ func NewUDPConn(config *AllocationConfig) *UDPConn {
 		readCh:     make(chan *inboundData, maxReadQueueSize),
 		closeCh:    make(chan struct{}),
 		allocation: allocation{
-			client:      config.Client,
-			relayedAddr: config.RelayedAddr,
-			serverAddr:  config.ServerAddr,
-			readTimer:   time.NewTimer(time.Duration(math.MaxInt64)),
-			permMap:     newPermissionMap(),
-			username:    config.Username,
-			realm:       config.Realm,
-			integrity:   config.Integrity,
-			_nonce:      config.Nonce,
-			_lifetime:   config.Lifetime,
-			net:         config.Net,
-			log:         config.Log,
+			client:            config.Client,
+			_server:           newServerState(config),
+			readTimer:         time.NewTimer(time.Duration(math.MaxInt64)),
+			permMap:           newPermissionMap(),
+			username:          config.Username,
+			integrity:         config.Integrity,
+			_nonce:            config.Nonce,
+			_lifetime:         config.Lifetime,
+			requestedLifetime: config.RequestedLifetime,
+			refreshInterval:   config.RefreshInterval,
+			net:               config.Net,
+			transactionID:     config.TransactionID,
+			accessToken:       config.AccessToken,
+			resolveAddr:       config.ResolveAddr,
+			nat64Prefix:       config.NAT64Prefix,
+			eagerChecks:       config.EagerConnectivityChecks,
+			noChannelBindings: config.DisableChannelBindings,
+			channelUpgrade:    config.ChannelUpgrade,
+			log:               config.Log,
 		},
 	}
 
 	c.log.Debugf("Initial lifetime: %d seconds", int(c.lifetime().Seconds()))
 
-	c.refreshAllocTimer = NewPeriodicTimer(
-		timerIDRefreshAlloc,
-		c.onRefreshTimers,
-		c.lifetime()/2,
-	)
+	c.refreshAllocTimer = c.newRefreshAllocTimer()
 
 	c.refreshPermsTimer = NewPeriodicTimer(
 		timerIDRefreshPerms,
@@ -141,17 +143,56 @@ func (a *allocation) createPermission(perm *permission, addr net.Addr) error {
 	return nil
 }
 
+// createPermissionInBackground creates the permission perm unless it is already being
+// created in the background
+func (a *allocation) createPermissionInBackground(perm *permission, addr net.Addr) {
+	if perm.pending.Lock() != nil {
+		return
+	}
+
+	go func() {
+		defer perm.pending.Unlock()
+
+		if err := a.createPermission(perm, addr); err != nil {
+			a.log.Warnf("Failed to create permission for %s: %s", addr, err)
+		}
+	}()
+}
+
+// isBindingRequest returns true if p is a STUN Binding request, e.g. an ICE connectivity check
+func isBindingRequest(p []byte) bool {
+	return stun.IsMessage(p) && binary.BigEndian.Uint16(p[0:2]) == stun.BindingRequest.Value()
+}
+
+// sendIndication sends p to the peer at udpAddr with a Send indication
+func (c *UDPConn) sendIndication(p []byte, udpAddr *net.UDPAddr) (int, error) {
+	msg, err := stun.Build(
+		c.newTransactionID(),
+		stun.NewType(stun.MethodSend, stun.ClassIndication),
+		proto.Data(p),
+		proto.PeerAddress{IP: udpAddr.IP, Port: udpAddr.Port},
+		stun.Fingerprint,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	// Indication has no transaction (fire-and-forget)
+
+	return c.client.WriteTo(msg.Raw, c.server().serverAddr)
+}
+
 // WriteTo writes a packet with payload p to addr.
 // WriteTo can be made to time out and return
 // an Error with Timeout() == true after a fixed time limit;
 // see SetDeadline and SetWriteDeadline.
 // On packet-oriented connections, write timeouts are rare.
 func (c *UDPConn) WriteTo(p []byte, addr net.Addr) (int, error) { //nolint: gocognit
-	var err error
-	_, ok := addr.(*net.UDPAddr)
-	if !ok {
-		return 0, errUDPAddrCast
+	udpAddr, err := c.udpAddr(addr)
+	if err != nil {
+		return 0, err
 	}
+	addr = udpAddr
 
 	// Check if we have a permission for the destination IP addr
 	perm, ok := c.permMap.find(addr)
@@ -160,30 +201,42 @@ func (c *UDPConn) WriteTo(p []byte, addr net.Addr) (int, error) { //nolint: goco
 		c.permMap.insert(addr, perm)
 	}
 
-	for i := 0; i < maxRetryAttempts; i++ {
-		// c.createPermission() would block, per destination IP (, or perm),
-		// until the perm state becomes "requested". Purpose of this is to
-		// guarantee the order of packets (within the same perm).
-		// Note that CreatePermission transaction may not be complete before
-		// all the data transmission. This is done assuming that the request
-		// will be most likely successful and we can tolerate some loss of
-		// UDP packet (or reorder), inorder to minimize the latency in most cases.
-		if err = c.createPermission(perm, addr); !errors.Is(err, errTryAgain) {
-			break
-		}
+	// Connectivity checks are retransmitted, so they don't wait for the permission
+	if c.eagerChecks && perm.state() != permStatePermitted && isBindingRequest(p) {
+		c.createPermissionInBackground(perm, addr)
+		return c.sendIndication(p, udpAddr)
 	}
-	if err != nil {
+
+	// c.createPermission() would block, per destination IP (, or perm),
+	// until the perm state becomes "requested". Purpose of this is to
+	// guarantee the order of packets (within the same perm).
+	// Note that CreatePermission transaction may not be complete before
+	// all the data transmission. This is done assuming that the request
+	// will be most likely successful and we can tolerate some loss of
+	// UDP packet (or reorder), inorder to minimize the latency in most cases.
+	if err = c.createPermission(perm, addr); err != nil {
 		return 0, err
 	}
 
+	if c.noChannelBindings {
+		return c.sendIndication(p, udpAddr)
+	}
+
 	// Bind channel
 	b, ok := c.bindingMgr.findByAddr(addr)
 	if !ok {
 		b = c.bindingMgr.create(addr)
 	}
+	now := time.Now()
+	b.markSent(now)
 
 	bindSt := b.state()
 
+	// Short flows stay on indications until they reach the channel upgrade threshold
+	if bindSt == bindingStateIdle && !c.channelUpgrade.due(b, len(p), now) {
+		return c.sendIndication(p, udpAddr)
+	}
+
 	if bindSt == bindingStateIdle || bindSt == bindingStateRequest || bindSt == bindingStateFailed {
 		func() {
 			// Block only callers with the same binding until
@@ -208,46 +261,11 @@ func (c *UDPConn) WriteTo(p []byte, addr net.Addr) (int, error) { //nolint: goco
 		}()
 
 		// Send data using SendIndication
-		peerAddr := addr2PeerAddress(addr)
-		var msg *stun.Message
-		msg, err = stun.Build(
-			stun.TransactionID,
-			stun.NewType(stun.MethodSend, stun.ClassIndication),
-			proto.Data(p),
-			peerAddr,
-			stun.Fingerprint,
-		)
-		if err != nil {
-			return 0, err
-		}
-
-		// Indication has no transaction (fire-and-forget)
-
-		return c.client.WriteTo(msg.Raw, c.serverAddr)
+		return c.sendIndication(p, udpAddr)
 	}
 
 	// Binding is either ready
-
-	// Check if the binding needs a refresh
-	func() {
-		b.muBind.Lock()
-		defer b.muBind.Unlock()
-
-		if b.state() == bindingStateReady && time.Since(b.refreshedAt()) > 5*time.Minute {
-			b.setState(bindingStateRefresh)
-			go func() {
-				err = c.bind(b)
-				if err != nil {
-					c.log.Warnf("Failed to bind() for refresh: %s", err)
-					b.setState(bindingStateFailed)
-					// Keep going...
-				} else {
-					b.setRefreshedAt(time.Now())
-					b.setState(bindingStateReady)
-				}
-			}()
-		}
-	}()
+	c.refreshBinding(b)
 
 	// Send via ChannelData
 	_, err = c.sendChannelData(p, b.number)
@@ -262,6 +280,7 @@ func (c *UDPConn) WriteTo(p []byte, addr net.Addr) (int, error) { //nolint: goco
 func (c *UDPConn) Close() error {
 	c.refreshAllocTimer.Stop()
 	c.refreshPermsTimer.Stop()
+	c.bindingMgr.stopKeepalives()
 
 	select {
 	case <-c.closeCh:
@@ -270,13 +289,13 @@ func (c *UDPConn) Close() error {
 		close(c.closeCh)
 	}
 
-	c.client.OnDeallocated(c.relayedAddr)
+	c.client.OnDeallocated(c.server().relayedAddr)
 	return c.refreshAllocation(0, true /* dontWait=true */)
 }
 
 // LocalAddr returns the local network address.
 func (c *UDPConn) LocalAddr() net.Addr {
-	return c.relayedAddr
+	return c.server().relayedAddr
 }
 
 // SetDeadline sets the read and write deadlines associated
@@ -322,45 +341,46 @@ func (c *UDPConn) SetWriteDeadline(time.Time) error {
 	return nil
 }
 
-func addr2PeerAddress(addr net.Addr) proto.PeerAddress {
-	var peerAddr proto.PeerAddress
-	switch a := addr.(type) {
-	case *net.UDPAddr:
-		peerAddr.IP = a.IP
-		peerAddr.Port = a.Port
-	case *net.TCPAddr:
-		peerAddr.IP = a.IP
-		peerAddr.Port = a.Port
+// peerAddress returns the XOR-PEER-ADDRESS of addr, which can be of any type udpAddr resolves
+func (a *allocation) peerAddress(addr net.Addr) (proto.PeerAddress, error) {
+	udpAddr, err := a.udpAddr(addr)
+	if err != nil {
+		return proto.PeerAddress{}, err
 	}
 
-	return peerAddr
+	return proto.PeerAddress{IP: udpAddr.IP, Port: udpAddr.Port}, nil
 }
 
 // CreatePermissions Issues a CreatePermission request for the supplied addresses
 // as described in https://datatracker.ietf.org/doc/html/rfc5766#section-9
 func (a *allocation) CreatePermissions(addrs ...net.Addr) error {
-	setters := []stun.Setter{
-		stun.TransactionID,
-		stun.NewType(stun.MethodCreatePermission, stun.ClassRequest),
-	}
-
+	peers := make([]net.Addr, 0, len(addrs))
+	peerAddrs := make([]stun.Setter, 0, len(addrs))
 	for _, addr := range addrs {
-		setters = append(setters, addr2PeerAddress(addr))
-	}
-
-	setters = append(setters,
-		a.username,
-		a.realm,
-		a.nonce(),
-		a.integrity,
-		stun.Fingerprint)
-
-	msg, err := stun.Build(setters...)
-	if err != nil {
-		return err
+		udpAddr, err := a.udpAddr(addr)
+		if err != nil {
+			return err
+		}
+		peers = append(peers, udpAddr)
+		peerAddrs = append(peerAddrs, proto.PeerAddress{IP: udpAddr.IP, Port: udpAddr.Port})
 	}
 
-	trRes, err := a.client.PerformTransaction(msg, a.serverAddr, false)
+	trRes, err := a.performTransaction(func() (*stun.Message, error) {
+		srv := a.server()
+		username, integrity := a.credentials()
+		setters := append([]stun.Setter{
+			a.newTransactionID(),
+			stun.NewType(stun.MethodCreatePermission, stun.ClassRequest),
+		}, peerAddrs...)
+		return stun.Build(append(setters,
+			username,
+			a.accessToken,
+			srv.affinityToken,
+			srv.realm,
+			a.nonce(),
+			integrity,
+			stun.Fingerprint)...)
+	}, false)
 	if err != nil {
 		return err
 	}
@@ -368,20 +388,32 @@ func (a *allocation) CreatePermissions(addrs ...net.Addr) error {
 	res := trRes.Msg
 
 	if res.Type.Class == stun.ClassErrorResponse {
-		var code stun.ErrorCodeAttribute
-		if err = code.GetFrom(res); err == nil {
-			if code.Code == stun.CodeStaleNonce {
-				a.setNonceFromMsg(res)
-				return errTryAgain
-			}
-			return fmt.Errorf("%s (error %s)", res.Type, code) //nolint:goerr113
+		return proto.NewResponseError(res)
+	}
+
+	// Track the permissions so they are refreshed and known to HasPermission
+	for _, addr := range peers {
+		if _, ok := a.permMap.find(addr); !ok {
+			perm := &permission{}
+			perm.setState(permStatePermitted)
+			a.permMap.insert(addr, perm)
 		}
-		return fmt.Errorf("%s", res.Type) //nolint:goerr113
 	}
 
 	return nil
 }
 
+// HasPermission returns true if a permission was successfully created for the IP address of addr
+func (a *allocation) HasPermission(addr net.Addr) bool {
+	udpAddr, err := a.udpAddr(addr)
+	if err != nil {
+		return false
+	}
+
+	perm, ok := a.permMap.find(udpAddr)
+	return ok && perm.state() == permStatePermitted
+}
+
 // HandleInbound passes inbound data in UDPConn
 func (c *UDPConn) HandleInbound(data []byte, from net.Addr) {
 	// Copy data
@@ -406,24 +438,28 @@ func (c *UDPConn) FindAddrByChannelNumber(chNum uint16) (net.Addr, bool) {
 }
 
 func (c *UDPConn) bind(b *binding) error {
-	setters := []stun.Setter{
-		stun.TransactionID,
-		stun.NewType(stun.MethodChannelBind, stun.ClassRequest),
-		addr2PeerAddress(b.addr),
-		proto.ChannelNumber(b.number),
-		c.username,
-		c.realm,
-		c.nonce(),
-		c.integrity,
-		stun.Fingerprint,
-	}
-
-	msg, err := stun.Build(setters...)
+	peerAddr, err := c.peerAddress(b.addr)
 	if err != nil {
 		return err
 	}
 
-	trRes, err := c.client.PerformTransaction(msg, c.serverAddr, false)
+	trRes, err := c.performTransaction(func() (*stun.Message, error) {
+		srv := c.server()
+		username, integrity := c.credentials()
+		return stun.Build(
+			c.newTransactionID(),
+			stun.NewType(stun.MethodChannelBind, stun.ClassRequest),
+			peerAddr,
+			proto.ChannelNumber(b.number),
+			username,
+			c.accessToken,
+			srv.affinityToken,
+			srv.realm,
+			c.nonce(),
+			integrity,
+			stun.Fingerprint,
+		)
+	}, false)
 	if err != nil {
 		c.bindingMgr.deleteByAddr(b.addr)
 		return err
@@ -431,7 +467,9 @@ func (c *UDPConn) bind(b *binding) error {
 
 	res := trRes.Msg
 
-	if res.Type != stun.NewType(stun.MethodChannelBind, stun.ClassSuccessResponse) {
+	if res.Type.Class == stun.ClassErrorResponse {
+		return proto.NewResponseError(res)
+	} else if res.Type != stun.NewType(stun.MethodChannelBind, stun.ClassSuccessResponse) {
 		return fmt.Errorf("unexpected response type %s", res.Type) //nolint:goerr113
 	}
 
@@ -441,13 +479,33 @@ func (c *UDPConn) bind(b *binding) error {
 	return nil
 }
 
+// refreshBinding refreshes the channel binding b in the background if it is due
+func (c *UDPConn) refreshBinding(b *binding) {
+	b.muBind.Lock()
+	defer b.muBind.Unlock()
+
+	if b.state() == bindingStateReady && time.Since(b.refreshedAt()) > 5*time.Minute {
+		b.setState(bindingStateRefresh)
+		go func() {
+			if err := c.bind(b); err != nil {
+				c.log.Warnf("Failed to bind() for refresh: %s", err)
+				b.setState(bindingStateFailed)
+				// Keep going...
+			} else {
+				b.setRefreshedAt(time.Now())
+				b.setState(bindingStateReady)
+			}
+		}()
+	}
+}
+
 func (c *UDPConn) sendChannelData(data []byte, chNum uint16) (int, error) {
 	chData := &proto.ChannelData{
 		Data:   data,
 		Number: proto.ChannelNumber(chNum),
 	}
 	chData.Encode()
-	_, err := c.client.WriteTo(chData.Raw, c.serverAddr)
+	_, err := c.client.WriteTo(chData.Raw, c.server().serverAddr)
 	if err != nil {
 		return 0, err
 	}
@@ -5,9 +5,13 @@ package client
 
 import (
 	"net"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/pion/logging"
 	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -75,4 +79,240 @@ func TestUDPConn(t *testing.T) {
 		assert.NoError(t, err, "should fail")
 		assert.Equal(t, len(buf), n)
 	})
+	t.Run("WriteTo() generic net.Addr", func(t *testing.T) {
+		var sent *stun.Message
+		client := &mockClient{
+			writeTo: func(data []byte, to net.Addr) (int, error) {
+				sent = &stun.Message{Raw: append([]byte{}, data...)}
+				return len(data), nil
+			},
+		}
+
+		addr := &net.UDPAddr{
+			IP:   net.ParseIP("127.0.0.1"),
+			Port: 1234,
+		}
+
+		pm := newPermissionMap()
+		assert.True(t, pm.insert(addr, &permission{
+			st: permStatePermitted,
+		}))
+
+		bm := newBindingManager()
+		bm.create(addr).setState(bindingStateFailed)
+
+		resolved := 0
+		conn := UDPConn{
+			allocation: allocation{
+				client:  client,
+				permMap: pm,
+				resolveAddr: func(network, address string) (*net.UDPAddr, error) {
+					resolved++
+					assert.Equal(t, "overlay-peer", address)
+					return addr, nil
+				},
+				log: logging.NewDefaultLoggerFactory().NewLogger("test"),
+			},
+			bindingMgr: bm,
+		}
+		overlayAddr := &genericAddr{network: "overlay", address: "overlay-peer"}
+		assert.True(t, conn.HasPermission(overlayAddr))
+
+		buf := []byte("Hello")
+		_, err := conn.WriteTo(buf, overlayAddr)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, resolved)
+
+		// The binding failed, so the data is sent in a Send indication
+		assert.NoError(t, sent.Decode())
+		var peerAddr proto.PeerAddress
+		assert.NoError(t, peerAddr.GetFrom(sent))
+		assert.True(t, peerAddr.IP.Equal(addr.IP))
+		assert.Equal(t, addr.Port, peerAddr.Port)
+	})
+	t.Run("WriteTo() eager connectivity checks", func(t *testing.T) {
+		release := make(chan struct{})
+		requested := make(chan struct{}, 10)
+		sent := make(chan *stun.Message, 10)
+		client := &mockClient{
+			performTransaction: func(msg *stun.Message, to net.Addr, dontWait bool) (TransactionResult, error) {
+				requested <- struct{}{}
+				<-release
+				res := stun.MustBuild(stun.NewType(stun.MethodCreatePermission, stun.ClassSuccessResponse))
+				return TransactionResult{Msg: res}, nil
+			},
+			writeTo: func(data []byte, to net.Addr) (int, error) {
+				sent <- &stun.Message{Raw: append([]byte{}, data...)}
+				return len(data), nil
+			},
+		}
+
+		addr := &net.UDPAddr{
+			IP:   net.ParseIP("127.0.0.1"),
+			Port: 1234,
+		}
+
+		conn := UDPConn{
+			allocation: allocation{
+				client:      client,
+				permMap:     newPermissionMap(),
+				eagerChecks: true,
+				log:         logging.NewDefaultLoggerFactory().NewLogger("test"),
+			},
+			bindingMgr: newBindingManager(),
+		}
+
+		check := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+
+		// The check is sent while the permission is still being created, once
+		for i := 0; i < 2; i++ {
+			_, err := conn.WriteTo(check.Raw, addr)
+			assert.NoError(t, err)
+
+			msg := <-sent
+			assert.NoError(t, msg.Decode())
+			assert.Equal(t, stun.NewType(stun.MethodSend, stun.ClassIndication), msg.Type)
+		}
+		<-requested
+		assert.Len(t, requested, 0)
+		assert.False(t, conn.HasPermission(addr))
+
+		close(release)
+		assert.Eventually(t, func() bool {
+			return conn.HasPermission(addr)
+		}, time.Second, time.Millisecond)
+	})
+	t.Run("Failover()", func(t *testing.T) {
+		oldServer := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 3478}
+		newServer := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 3478}
+		peer := &net.UDPAddr{IP: net.ParseIP("127.0.0.3"), Port: 1234}
+
+		var mutex sync.Mutex
+		requests := map[stun.Method]*stun.Message{}
+		client := &mockClient{
+			performTransaction: func(msg *stun.Message, to net.Addr, dontWait bool) (TransactionResult, error) {
+				assert.Equal(t, newServer, to)
+
+				mutex.Lock()
+				requests[msg.Type.Method] = msg
+				mutex.Unlock()
+
+				res := stun.MustBuild(stun.NewType(msg.Type.Method, stun.ClassSuccessResponse))
+				return TransactionResult{Msg: res}, nil
+			},
+		}
+
+		pm := newPermissionMap()
+		assert.True(t, pm.insert(peer, &permission{st: permStatePermitted}))
+		bm := newBindingManager()
+		b := bm.create(peer)
+		b.setState(bindingStateReady)
+
+		conn := UDPConn{
+			allocation: allocation{
+				client:            client,
+				_server:           serverState{serverAddr: oldServer},
+				permMap:           pm,
+				refreshAllocTimer: NewPeriodicTimer(timerIDRefreshAlloc, func(int) {}, time.Minute),
+				log:               logging.NewDefaultLoggerFactory().NewLogger("test"),
+			},
+			bindingMgr: bm,
+		}
+
+		relayedAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 50000}
+		assert.NoError(t, conn.Failover(&AllocationConfig{
+			ServerAddr:  newServer,
+			RelayedAddr: relayedAddr,
+			Realm:       stun.NewRealm("new"),
+			Nonce:       stun.NewNonce("nonce"),
+			Lifetime:    10 * time.Minute,
+		}))
+		assert.Equal(t, relayedAddr, conn.LocalAddr())
+		assert.Equal(t, 10*time.Minute, conn.GrantedLifetime())
+		assert.Equal(t, 5*time.Minute, conn.RefreshInterval())
+
+		// The permission and the channel binding are restored on the new server
+		var peerAddr proto.PeerAddress
+		assert.NoError(t, peerAddr.GetFrom(requests[stun.MethodCreatePermission]))
+		assert.True(t, peerAddr.IP.Equal(peer.IP))
+
+		var number proto.ChannelNumber
+		assert.NoError(t, number.GetFrom(requests[stun.MethodChannelBind]))
+		assert.Equal(t, proto.ChannelNumber(b.number), number)
+		assert.Equal(t, bindingStateReady, b.state())
+
+		var realm stun.Realm
+		assert.NoError(t, realm.GetFrom(requests[stun.MethodChannelBind]))
+		assert.Equal(t, "new", realm.String())
+	})
+}
+
+func TestUDPConnDelivery(t *testing.T) {
+	peer := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+	bm := newBindingManager()
+	b := bm.create(peer)
+	b.setState(bindingStateRequest)
+
+	conn := UDPConn{
+		allocation: allocation{
+			log: logging.NewDefaultLoggerFactory().NewLogger("test"),
+		},
+		readCh:     make(chan *inboundData, maxReadQueueSize),
+		bindingMgr: bm,
+	}
+
+	assert.True(t, conn.HandleDataIndication([]byte("1"), peer))
+	assert.Equal(t, []PeerStats{{Peer: peer, Delivery: DeliveryIndication, Indications: 1}}, conn.PeerStats())
+
+	// The first ChannelData arrives before the ChannelBind success response, and overtakes
+	// the last Data indication sent before the channel was bound
+	addr, ok := conn.HandleChannelData(b.number, []byte("3"))
+	assert.True(t, ok)
+	assert.Equal(t, peer, addr)
+	b.setState(bindingStateReady)
+	assert.False(t, conn.HandleDataIndication([]byte("2"), peer))
+	_, ok = conn.HandleChannelData(b.number, []byte("4"))
+	assert.True(t, ok)
+	_, ok = conn.HandleChannelData(b.number+1, []byte("5"))
+	assert.False(t, ok)
+
+	// Data from peers without a binding is delivered as is
+	other := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5678}
+	assert.True(t, conn.HandleDataIndication([]byte("6"), other))
+
+	var received []string
+	for len(conn.readCh) > 0 {
+		received = append(received, string((<-conn.readCh).data))
+	}
+	assert.Equal(t, []string{"1", "3", "4", "6"}, received)
+	assert.Equal(t, []PeerStats{{
+		Peer:          peer,
+		Delivery:      DeliveryChannel,
+		ChannelNumber: b.number,
+		Indications:   1,
+		ChannelData:   2,
+		Stale:         1,
+	}}, conn.PeerStats())
+
+	// The server relays in Data indications until the channel is bound again, e.g. after a
+	// failover
+	b.setState(bindingStateRequest)
+	assert.True(t, conn.HandleDataIndication([]byte("7"), peer))
+	stats := conn.PeerStats()
+	assert.Equal(t, DeliveryIndication, stats[0].Delivery)
+	assert.Equal(t, uint16(0), stats[0].ChannelNumber)
+	assert.Equal(t, uint64(2), stats[0].Indications)
+}
+
+type genericAddr struct {
+	network, address string
+}
+
+func (a *genericAddr) Network() string { return a.network }
+func (a *genericAddr) String() string  { return a.address }
+
+func TestDefaultRefreshInterval(t *testing.T) {
+	assert.Equal(t, 5*time.Minute, DefaultRefreshInterval(10*time.Minute))
+	assert.Equal(t, 5*time.Minute, DefaultRefreshInterval(time.Hour))
+	assert.Equal(t, 30*time.Second, DefaultRefreshInterval(time.Minute))
 }
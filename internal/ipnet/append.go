@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ipnet
+
+import (
+	"net"
+	"strconv"
+)
+
+// AppendAddr appends the string form of addr to b, like addr.String() but without
+// allocating for *net.UDPAddr and *net.TCPAddr
+func AppendAddr(b []byte, addr net.Addr) []byte {
+	var ip net.IP
+	var port int
+	var zone string
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip, port, zone = a.IP, a.Port, a.Zone
+	case *net.TCPAddr:
+		ip, port, zone = a.IP, a.Port, a.Zone
+	case nil:
+		return append(b, "<nil>"...)
+	default:
+		return append(b, addr.String()...)
+	}
+
+	// IPv6 hosts are bracketed, see net.JoinHostPort
+	bracket := ip.To4() == nil && len(ip) == net.IPv6len
+	if bracket {
+		b = append(b, '[')
+	}
+	if len(ip) != 0 {
+		b = AppendIP(b, ip)
+	}
+	if zone != "" {
+		b = append(b, '%')
+		b = append(b, zone...)
+	}
+	if bracket {
+		b = append(b, ']')
+	}
+	b = append(b, ':')
+	return strconv.AppendInt(b, int64(port), 10)
+}
+
+// AppendIP appends the string form of ip to b, like ip.String() but without allocating
+func AppendIP(b []byte, ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		for i, octet := range ip4 {
+			if i != 0 {
+				b = append(b, '.')
+			}
+			b = strconv.AppendUint(b, uint64(octet), 10)
+		}
+		return b
+	}
+	if len(ip) != net.IPv6len {
+		return append(b, ip.String()...)
+	}
+
+	// The longest run of two or more zero groups is replaced by "::", see RFC 5952
+	zeroStart, zeroEnd := -1, -1
+	for i := 0; i < net.IPv6len; i += 2 {
+		j := i
+		for j < net.IPv6len && ip[j] == 0 && ip[j+1] == 0 {
+			j += 2
+		}
+		if j-i >= 4 && j-i > zeroEnd-zeroStart {
+			zeroStart, zeroEnd = i, j
+		}
+		if j > i {
+			i = j - 2
+		}
+	}
+
+	for i := 0; i < net.IPv6len; i += 2 {
+		if i == zeroStart {
+			b = append(b, ':', ':')
+			i = zeroEnd - 2
+			continue
+		}
+		if i != 0 && i != zeroEnd {
+			b = append(b, ':')
+		}
+		b = strconv.AppendUint(b, uint64(ip[i])<<8|uint64(ip[i+1]), 16)
+	}
+	return b
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ipnet
+
+import (
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendAddr(t *testing.T) {
+	ips := []net.IP{
+		nil,
+		net.ParseIP("127.0.0.1"),
+		net.ParseIP("127.0.0.1").To4(),
+		net.ParseIP("::"),
+		net.ParseIP("::1"),
+		net.ParseIP("1::"),
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("2001:db8:0:1:0:0:0:1"),
+		net.ParseIP("2001:0:0:1:0:0:0:1"),
+		net.ParseIP("2001:db8:0:1:1:1:1:1"),
+		net.ParseIP("fe80::1"),
+		net.ParseIP("64:ff9b::192.0.2.1"),
+		{1, 2, 3},
+	}
+
+	// Random addresses with runs of zero groups
+	for i := 0; i < 1000; i++ {
+		ip := make(net.IP, net.IPv6len)
+		for j := 0; j < net.IPv6len; j += 2 {
+			if rand.Intn(2) == 0 { //nolint:gosec
+				ip[j], ip[j+1] = byte(rand.Intn(256)), byte(rand.Intn(256)) //nolint:gosec
+			}
+		}
+		ips = append(ips, ip)
+	}
+
+	for _, ip := range ips {
+		assert.Equal(t, ip.String(), string(AppendIP(nil, ip)))
+
+		for _, addr := range []net.Addr{
+			&net.UDPAddr{IP: ip, Port: 3478},
+			&net.TCPAddr{IP: ip, Port: 443, Zone: "eth0"},
+		} {
+			assert.Equal(t, addr.String(), string(AppendAddr(nil, addr)))
+		}
+	}
+
+	assert.Equal(t, "<nil>", string(AppendAddr(nil, nil)))
+	assert.Equal(t, "prefix:1.2.3.4:5", string(AppendAddr([]byte("prefix:"), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 5})))
+}
+
+func BenchmarkAppendAddr(b *testing.B) {
+	addr := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3478}
+	buf := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendAddr(buf[:0], addr)
+	}
+}
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ipnet
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+)
+
+// MaxDSCP is the largest DSCP, it has 6 bits
+const MaxDSCP = 63
+
+var (
+	// ErrDSCPUnsupported is returned by SetDSCP for connections that aren't sockets, and on
+	// platforms other than Linux
+	ErrDSCPUnsupported = errors.New("DSCP is not supported")
+	// ErrDSCPInvalid is returned by SetDSCP for a DSCP outside of 0-63
+	ErrDSCPInvalid = errors.New("DSCP must be between 0 and 63")
+)
+
+// SetDSCP marks the packets sent on conn, a socket implementing syscall.Conn such as a
+// net.PacketConn, net.Conn or net.Listener, with dscp. Sockets accepted by a marked
+// listener inherit the DSCP
+func SetDSCP(conn interface{}, dscp int) error {
+	if dscp < 0 || dscp > MaxDSCP {
+		return fmt.Errorf("%w: %d", ErrDSCPInvalid, dscp)
+	}
+
+	syscallConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return ErrDSCPUnsupported
+	}
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	return setTrafficClass(rawConn, dscp<<2)
+}
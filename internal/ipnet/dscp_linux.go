@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ipnet
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setTrafficClass sets IP_TOS on IPv4 sockets, and IPV6_TCLASS on IPv6 sockets along with
+// IP_TOS for the IPv4 packets of dual-stack sockets
+func setTrafficClass(rawConn syscall.RawConn, tos int) error {
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		var domain int
+		if domain, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_DOMAIN); sockErr != nil {
+			return
+		}
+
+		if domain == unix.AF_INET6 {
+			if sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos); sockErr != nil {
+				return
+			}
+			_ = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+			return
+		}
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+	}); err != nil {
+		return err
+	}
+
+	return sockErr
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ipnet
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func sockopt(t *testing.T, conn syscall.Conn, level, option int) int {
+	rawConn, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var value int
+	var sockErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		value, sockErr = unix.GetsockoptInt(int(fd), level, option)
+	}))
+	require.NoError(t, sockErr)
+
+	return value
+}
+
+func TestSetDSCP(t *testing.T) {
+	t.Run("IPv4", func(t *testing.T) {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer func() {
+			assert.NoError(t, conn.Close())
+		}()
+
+		assert.NoError(t, SetDSCP(conn, 46))
+		assert.Equal(t, 46<<2, sockopt(t, conn, unix.IPPROTO_IP, unix.IP_TOS))
+	})
+
+	t.Run("IPv6", func(t *testing.T) {
+		conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+		if err != nil {
+			t.Skipf("No IPv6: %v", err)
+		}
+		defer func() {
+			assert.NoError(t, conn.Close())
+		}()
+
+		assert.NoError(t, SetDSCP(conn, 34))
+		assert.Equal(t, 34<<2, sockopt(t, conn, unix.IPPROTO_IPV6, unix.IPV6_TCLASS))
+	})
+
+	t.Run("Listener", func(t *testing.T) {
+		listener, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer func() {
+			assert.NoError(t, listener.Close())
+		}()
+
+		assert.NoError(t, SetDSCP(listener, 10))
+		assert.Equal(t, 10<<2, sockopt(t, listener, unix.IPPROTO_IP, unix.IP_TOS))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer func() {
+			assert.NoError(t, conn.Close())
+		}()
+
+		assert.True(t, errors.Is(SetDSCP(conn, 64), ErrDSCPInvalid))
+		assert.True(t, errors.Is(SetDSCP(conn, -1), ErrDSCPInvalid))
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer func() {
+			assert.NoError(t, a.Close())
+			assert.NoError(t, b.Close())
+		}()
+
+		assert.Equal(t, ErrDSCPUnsupported, SetDSCP(a, 46))
+	})
+}
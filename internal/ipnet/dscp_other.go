@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package ipnet
+
+import "syscall"
+
+// setTrafficClass is only implemented on Linux
+func setTrafficClass(syscall.RawConn, int) error {
+	return ErrDSCPUnsupported
+}
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ipnet
+
+import (
+	"net"
+)
+
+// NAT64PrefixLength is the only NAT64 prefix length supported, with the IPv4 address in
+// the last 32 bits, see RFC 6052 Section 2.2
+const NAT64PrefixLength = 96
+
+// IsNAT64Prefix returns true if prefix is an IPv6 prefix of NAT64PrefixLength
+func IsNAT64Prefix(prefix *net.IPNet) bool {
+	if prefix == nil || prefix.IP.To4() != nil || len(prefix.IP) != net.IPv6len {
+		return false
+	}
+
+	ones, bits := prefix.Mask.Size()
+	return ones == NAT64PrefixLength && bits == 128
+}
+
+// SynthesizeNAT64 embeds the IPv4 address ip into prefix
+func SynthesizeNAT64(prefix *net.IPNet, ip net.IP) net.IP {
+	synthesized := make(net.IP, net.IPv6len)
+	copy(synthesized, prefix.IP.To16()[:12])
+	copy(synthesized[12:], ip.To4())
+
+	return synthesized
+}
+
+// ExtractNAT64 returns the IPv4 address embedded in ip, and false if ip isn't within prefix
+func ExtractNAT64(prefix *net.IPNet, ip net.IP) (net.IP, bool) {
+	if ip.To4() != nil || len(ip) != net.IPv6len || !prefix.Contains(ip) {
+		return nil, false
+	}
+
+	return net.IPv4(ip[12], ip[13], ip[14], ip[15]).To4(), true
+}
@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ipnet
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNAT64(t *testing.T) {
+	_, prefix, err := net.ParseCIDR("64:ff9b::/96")
+	assert.NoError(t, err)
+	assert.True(t, IsNAT64Prefix(prefix))
+
+	_, prefix64, err := net.ParseCIDR("2001:db8::/64")
+	assert.NoError(t, err)
+	assert.False(t, IsNAT64Prefix(prefix64))
+	assert.False(t, IsNAT64Prefix(&net.IPNet{IP: net.IPv4(10, 0, 0, 0), Mask: net.CIDRMask(8, 32)}))
+	assert.False(t, IsNAT64Prefix(nil))
+
+	synthesized := SynthesizeNAT64(prefix, net.ParseIP("192.0.2.33"))
+	assert.True(t, synthesized.Equal(net.ParseIP("64:ff9b::c000:221")))
+
+	ip, ok := ExtractNAT64(prefix, synthesized)
+	assert.True(t, ok)
+	assert.True(t, ip.Equal(net.ParseIP("192.0.2.33")))
+
+	_, ok = ExtractNAT64(prefix, net.ParseIP("2001:db8::1"))
+	assert.False(t, ok)
+	_, ok = ExtractNAT64(prefix, net.ParseIP("192.0.2.33"))
+	assert.False(t, ok)
+}
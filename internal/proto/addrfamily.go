@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"errors"
+
+	"github.com/pion/stun/v2"
+)
+
+const (
+	// AttrAdditionalAddressFamily is the ADDITIONAL-ADDRESS-FAMILY attribute, RFC 8656 Section 18.11
+	AttrAdditionalAddressFamily stun.AttrType = 0x8000
+
+	// AttrAddressErrorCode is the ADDRESS-ERROR-CODE attribute, RFC 8656 Section 18.12
+	AttrAddressErrorCode stun.AttrType = 0x8001
+)
+
+const addressErrorCodeHeaderSize = 4
+
+var errInvalidAddressErrorCode = errors.New("invalid ADDRESS-ERROR-CODE attribute")
+
+// AdditionalAddressFamily represents the ADDITIONAL-ADDRESS-FAMILY attribute. It
+// requests a relayed transport address of a second address family in addition to the
+// IPv4 one, the only valid family is IPv6.
+//
+// RFC 8656 Section 18.11
+type AdditionalAddressFamily RequestedAddressFamily
+
+// GetFrom decodes ADDITIONAL-ADDRESS-FAMILY from message.
+func (f *AdditionalAddressFamily) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrAdditionalAddressFamily)
+	if err != nil {
+		return err
+	}
+	if err = stun.CheckSize(AttrAdditionalAddressFamily, len(v), requestedFamilySize); err != nil {
+		return err
+	}
+	switch v[0] {
+	case byte(RequestedFamilyIPv4), byte(RequestedFamilyIPv6):
+		*f = AdditionalAddressFamily(v[0])
+	default:
+		return errInvalidRequestedFamilyValue
+	}
+	return nil
+}
+
+// AddTo adds ADDITIONAL-ADDRESS-FAMILY to message.
+func (f AdditionalAddressFamily) AddTo(m *stun.Message) error {
+	v := make([]byte, requestedFamilySize)
+	v[0] = byte(f)
+	m.Add(AttrAdditionalAddressFamily, v)
+	return nil
+}
+
+// AddressErrorCode represents the ADDRESS-ERROR-CODE attribute. It is added to Allocate
+// success responses if the relayed transport address of a requested address family
+// couldn't be allocated, while the one of the other family was.
+//
+// RFC 8656 Section 18.12
+type AddressErrorCode struct {
+	Family RequestedAddressFamily
+	Code   stun.ErrorCode
+	Reason []byte
+}
+
+// AddTo adds ADDRESS-ERROR-CODE to message. The header is encoded like ERROR-CODE,
+// with the address family in the first reserved byte.
+func (c AddressErrorCode) AddTo(m *stun.Message) error {
+	v := make([]byte, addressErrorCodeHeaderSize+len(c.Reason))
+	v[0] = byte(c.Family)
+	v[2] = byte(c.Code / 100)
+	v[3] = byte(c.Code % 100)
+	copy(v[addressErrorCodeHeaderSize:], c.Reason)
+	m.Add(AttrAddressErrorCode, v)
+	return nil
+}
+
+// GetFrom decodes ADDRESS-ERROR-CODE from message.
+func (c *AddressErrorCode) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrAddressErrorCode)
+	if err != nil {
+		return err
+	}
+	if len(v) < addressErrorCodeHeaderSize {
+		return errInvalidAddressErrorCode
+	}
+
+	c.Family = RequestedAddressFamily(v[0])
+	c.Code = stun.ErrorCode(int(v[2]&0x07)*100 + int(v[3]))
+	c.Reason = append([]byte{}, v[addressErrorCodeHeaderSize:]...)
+	return nil
+}
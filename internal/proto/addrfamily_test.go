@@ -0,0 +1,84 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pion/stun/v2"
+)
+
+func TestAdditionalAddressFamily(t *testing.T) {
+	m := new(stun.Message)
+	if err := AdditionalAddressFamily(RequestedFamilyIPv6).AddTo(m); err != nil {
+		t.Fatal(err)
+	}
+	m.WriteHeader()
+
+	decoded := new(stun.Message)
+	if _, err := decoded.Write(m.Raw); err != nil {
+		t.Fatal(err)
+	}
+	var f AdditionalAddressFamily
+	if err := f.GetFrom(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if f != AdditionalAddressFamily(RequestedFamilyIPv6) {
+		t.Errorf("decoded %v, expected IPv6", f)
+	}
+
+	t.Run("HandleErr", func(t *testing.T) {
+		m := new(stun.Message)
+		if err := f.GetFrom(m); !errors.Is(err, stun.ErrAttributeNotFound) {
+			t.Errorf("%v should be not found", err)
+		}
+		m.Add(AttrAdditionalAddressFamily, []byte{1, 2, 3})
+		if !stun.IsAttrSizeInvalid(f.GetFrom(m)) {
+			t.Error("IsAttrSizeInvalid should be true")
+		}
+		m.Reset()
+		m.Add(AttrAdditionalAddressFamily, []byte{5, 0, 0, 0})
+		if !errors.Is(f.GetFrom(m), errInvalidRequestedFamilyValue) {
+			t.Error("should error on invalid value")
+		}
+	})
+}
+
+func TestAddressErrorCode(t *testing.T) {
+	m := new(stun.Message)
+	code := AddressErrorCode{Family: RequestedFamilyIPv6, Code: stun.CodeAddrFamilyNotSupported, Reason: []byte("no IPv6")}
+	if err := code.AddTo(m); err != nil {
+		t.Fatal(err)
+	}
+	m.WriteHeader()
+
+	// Family, reserved, class 4 and number 40, as for ERROR-CODE
+	v, err := m.Get(AttrAddressErrorCode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v[:4], []byte{0x02, 0x00, 0x04, 40}) {
+		t.Errorf("unexpected header %x", v[:4])
+	}
+
+	decoded := new(stun.Message)
+	if _, err = decoded.Write(m.Raw); err != nil {
+		t.Fatal(err)
+	}
+	var c AddressErrorCode
+	if err = c.GetFrom(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if c.Family != RequestedFamilyIPv6 || c.Code != stun.CodeAddrFamilyNotSupported || string(c.Reason) != "no IPv6" {
+		t.Errorf("decoded %+v", c)
+	}
+
+	m.Reset()
+	m.Add(AttrAddressErrorCode, []byte{1, 2})
+	if !errors.Is(c.GetFrom(m), errInvalidAddressErrorCode) {
+		t.Error("should error on short value")
+	}
+}
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import "github.com/pion/stun/v2"
+
+// AttrAffinityToken is the AFFINITY-TOKEN attribute. It is a private,
+// comprehension-optional attribute that is ignored by servers and clients
+// that don't know it.
+const AttrAffinityToken stun.AttrType = 0xC0A1
+
+// AffinityToken represents AFFINITY-TOKEN attribute.
+//
+// The AFFINITY-TOKEN attribute is an opaque value identifying the server
+// that holds an allocation. The server adds it to its responses, and the
+// client echoes it in its requests, so that a load balancer in front of
+// several servers can route them to the same server without keeping state.
+//
+// An empty AffinityToken is not added to messages.
+type AffinityToken []byte
+
+// AddTo adds AFFINITY-TOKEN to message if the token isn't empty.
+func (t AffinityToken) AddTo(m *stun.Message) error {
+	if len(t) == 0 {
+		return nil
+	}
+	m.Add(AttrAffinityToken, t)
+	return nil
+}
+
+// GetFrom decodes AFFINITY-TOKEN from message.
+func (t *AffinityToken) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrAffinityToken)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pion/stun/v2"
+)
+
+func TestAffinityToken(t *testing.T) {
+	t.Run("AddTo", func(t *testing.T) {
+		m := new(stun.Message)
+		token := AffinityToken("node-1")
+		if err := token.AddTo(m); err != nil {
+			t.Error(err)
+		}
+		m.WriteHeader()
+		t.Run("GetFrom", func(t *testing.T) {
+			decoded := new(stun.Message)
+			if _, err := decoded.Write(m.Raw); err != nil {
+				t.Fatal("failed to decode message:", err)
+			}
+			var decodedToken AffinityToken
+			if err := decodedToken.GetFrom(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decodedToken, token) {
+				t.Errorf("Decoded %v, expected %v", decodedToken, token)
+			}
+			m := new(stun.Message)
+			if err := decodedToken.GetFrom(m); !errors.Is(err, stun.ErrAttributeNotFound) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	})
+	t.Run("Empty", func(t *testing.T) {
+		m := new(stun.Message)
+		if err := (AffinityToken{}).AddTo(m); err != nil {
+			t.Error(err)
+		}
+		if m.Contains(AttrAffinityToken) {
+			t.Error("Empty AFFINITY-TOKEN should not be added")
+		}
+	})
+}
@@ -69,6 +69,19 @@ func (c *ChannelData) Encode() {
 	}
 }
 
+// EncodeInPlace encodes the ChannelData Message to Raw, whose Data was already written
+// to Raw after the first ChannelDataHeaderSize bytes, e.g. by reading a datagram into
+// them. The padding is added within the capacity of Raw, so EncodeInPlace doesn't allocate
+// if Raw has ChannelDataPadding bytes of room.
+func (c *ChannelData) EncodeInPlace() {
+	c.Data = c.Raw[channelDataHeaderSize:]
+	c.WriteHeader()
+	padded := nearestPaddedValueLength(len(c.Raw))
+	for len(c.Raw) < padded {
+		c.Raw = append(c.Raw, 0)
+	}
+}
+
 const padding = 4
 
 func nearestPaddedValueLength(l int) int {
@@ -121,6 +134,14 @@ func (c *ChannelData) Decode() error {
 	return nil
 }
 
+const (
+	// ChannelDataHeaderSize is the size of the channel number and length of the
+	// ChannelData Message
+	ChannelDataHeaderSize = channelDataHeaderSize
+	// ChannelDataPadding is the most padding added to the ChannelData Message
+	ChannelDataPadding = padding - 1
+)
+
 const (
 	channelDataLengthSize = 2
 	channelDataNumberSize = channelDataLengthSize
@@ -31,6 +31,43 @@ func TestChannelData_Encode(t *testing.T) {
 	}
 }
 
+func TestChannelData_EncodeInPlace(t *testing.T) {
+	buf := make([]byte, ChannelDataHeaderSize+5+ChannelDataPadding)
+	copy(buf[ChannelDataHeaderSize:], []byte{1, 2, 3, 4, 5})
+	buf[len(buf)-1] = 0xff
+
+	d := &ChannelData{
+		Raw:    buf[:ChannelDataHeaderSize+5],
+		Number: MinChannelNumber + 1,
+	}
+	if wasAllocs(func() {
+		d.Raw = buf[:ChannelDataHeaderSize+5]
+		d.EncodeInPlace()
+	}) {
+		t.Error("unexpected allocation")
+	}
+	if len(d.Raw) != 12 || d.Raw[len(d.Raw)-1] != 0 {
+		t.Errorf("unexpected padding: %v", d.Raw)
+	}
+
+	e := &ChannelData{
+		Data:   []byte{1, 2, 3, 4, 5},
+		Number: MinChannelNumber + 1,
+	}
+	e.Encode()
+	if !bytes.Equal(d.Raw, e.Raw) {
+		t.Errorf("%v != %v", d.Raw, e.Raw)
+	}
+
+	b := &ChannelData{Raw: d.Raw}
+	if err := b.Decode(); err != nil {
+		t.Error(err)
+	}
+	if !b.Equal(e) {
+		t.Error("not equal")
+	}
+}
+
 func TestChannelData_Equal(t *testing.T) {
 	for _, tc := range []struct {
 		name  string
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"fmt"
+
+	"github.com/pion/stun/v2"
+)
+
+// ResponseError is the error for a STUN error response. It carries the
+// message type and the value of the ERROR-CODE attribute so callers can
+// act on the code instead of the error string.
+type ResponseError struct {
+	Type   stun.MessageType
+	Code   stun.ErrorCode // 0 if the response has no ERROR-CODE attribute
+	Reason string
+}
+
+// NewResponseError creates a ResponseError from an error response
+func NewResponseError(m *stun.Message) *ResponseError {
+	e := &ResponseError{Type: m.Type}
+
+	var code stun.ErrorCodeAttribute
+	if err := code.GetFrom(m); err == nil {
+		e.Code = code.Code
+		e.Reason = string(code.Reason)
+	}
+
+	return e
+}
+
+func (e *ResponseError) Error() string {
+	if e.Code == 0 {
+		return e.Type.String()
+	}
+
+	return fmt.Sprintf("%s (error %d: %s)", e.Type, int(e.Code), e.Reason)
+}
@@ -0,0 +1,40 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/pion/stun/v2"
+)
+
+func TestNewResponseError(t *testing.T) {
+	t.Run("WithCode", func(t *testing.T) {
+		m := stun.MustBuild(
+			stun.TransactionID,
+			stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse),
+			stun.CodeAllocMismatch,
+		)
+		e := NewResponseError(m)
+		if e.Code != stun.CodeAllocMismatch {
+			t.Errorf("unexpected code %d", e.Code)
+		}
+		if e.Error() != "Refresh error response (error 437: Allocation Mismatch)" {
+			t.Errorf("unexpected error string %q", e.Error())
+		}
+	})
+	t.Run("WithoutCode", func(t *testing.T) {
+		m := stun.MustBuild(
+			stun.TransactionID,
+			stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse),
+		)
+		e := NewResponseError(m)
+		if e.Code != 0 {
+			t.Errorf("unexpected code %d", e.Code)
+		}
+		if e.Error() != "Refresh error response" {
+			t.Errorf("unexpected error string %q", e.Error())
+		}
+	})
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"testing"
+
+	"github.com/pion/stun/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func integrityTestMessage(t testing.TB, integrity stun.Setter) *stun.Message {
+	m, err := stun.Build(stun.NewTransactionIDSetter([stun.TransactionIDSize]byte{1, 2, 3}), stun.NewType(stun.MethodRefresh, stun.ClassRequest),
+		stun.NewUsername("user"), stun.NewRealm("pion.ly"), stun.NewNonce("nonce"),
+		Lifetime{Duration: 600e9}, integrity, stun.Fingerprint)
+	require.NoError(t, err)
+	return m
+}
+
+func TestIntegrity(t *testing.T) {
+	key := stun.NewLongTermIntegrity("user", "pion.ly", "pass")
+	integrity := NewIntegrity(key)
+	assert.Equal(t, key, integrity.Key())
+
+	t.Run("Check", func(t *testing.T) {
+		m := integrityTestMessage(t, key)
+		assert.NoError(t, integrity.Check(m))
+		assert.NoError(t, integrity.Check(m))
+
+		assert.ErrorIs(t, NewIntegrity(stun.NewLongTermIntegrity("user", "pion.ly", "other")).Check(m), stun.ErrIntegrityMismatch)
+		assert.ErrorIs(t, integrity.Check(new(stun.Message)), stun.ErrAttributeNotFound)
+	})
+
+	t.Run("AddTo", func(t *testing.T) {
+		m := integrityTestMessage(t, integrity)
+		assert.NoError(t, key.Check(m))
+		assert.Equal(t, integrityTestMessage(t, key).Raw, m.Raw)
+
+		assert.ErrorIs(t, integrity.AddTo(m), stun.ErrFingerprintBeforeIntegrity)
+	})
+}
+
+func BenchmarkIntegrity(b *testing.B) {
+	key := stun.NewLongTermIntegrity("user", "pion.ly", "pass")
+	integrity := NewIntegrity(key)
+
+	b.Run("Check", func(b *testing.B) {
+		m := integrityTestMessage(b, key)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := integrity.Check(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("CheckMessageIntegrity", func(b *testing.B) {
+		m := integrityTestMessage(b, key)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := key.Check(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("AddTo", func(b *testing.B) {
+		m := new(stun.Message)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.Reset()
+			if err := integrity.AddTo(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("Fingerprint", func(b *testing.B) {
+		m := integrityTestMessage(b, key)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := stun.Fingerprint.Check(m); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
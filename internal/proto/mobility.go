@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import "github.com/pion/stun/v2"
+
+const (
+	// AttrMobilityTicket is the MOBILITY-TICKET attribute, RFC 8016 Section 3.6
+	AttrMobilityTicket stun.AttrType = 0x8030
+
+	// CodeMobilityForbidden is the 405 (Mobility Forbidden) error, RFC 8016 Section 3.7
+	CodeMobilityForbidden stun.ErrorCode = 405
+)
+
+// MobilityTicket represents MOBILITY-TICKET attribute.
+//
+// The MOBILITY-TICKET attribute is used to retain an allocation on the
+// TURN server. It is exchanged between the client and server to aid
+// mobility. The value of the MOBILITY-TICKET is encrypted and is of
+// variable length. A client requests mobility by including an empty
+// MOBILITY-TICKET in an Allocate request.
+//
+// RFC 8016 Section 3.6
+type MobilityTicket []byte
+
+// AddTo adds MOBILITY-TICKET to message.
+func (t MobilityTicket) AddTo(m *stun.Message) error {
+	m.Add(AttrMobilityTicket, t)
+	return nil
+}
+
+// GetFrom decodes MOBILITY-TICKET from message.
+func (t *MobilityTicket) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrMobilityTicket)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pion/stun/v2"
+)
+
+func TestMobilityTicket(t *testing.T) {
+	t.Run("AddTo", func(t *testing.T) {
+		m := new(stun.Message)
+		ticket := MobilityTicket{1, 2, 3, 4, 5}
+		if err := ticket.AddTo(m); err != nil {
+			t.Error(err)
+		}
+		m.WriteHeader()
+		t.Run("GetFrom", func(t *testing.T) {
+			decoded := new(stun.Message)
+			if _, err := decoded.Write(m.Raw); err != nil {
+				t.Fatal("failed to decode message:", err)
+			}
+			var decodedTicket MobilityTicket
+			if err := decodedTicket.GetFrom(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decodedTicket, ticket) {
+				t.Errorf("Decoded %v, expected %v", decodedTicket, ticket)
+			}
+			m := new(stun.Message)
+			if err := decodedTicket.GetFrom(m); !errors.Is(err, stun.ErrAttributeNotFound) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	})
+	t.Run("Empty", func(t *testing.T) {
+		m := new(stun.Message)
+		if err := (MobilityTicket{}).AddTo(m); err != nil {
+			t.Error(err)
+		}
+		m.WriteHeader()
+		decoded := new(stun.Message)
+		if _, err := decoded.Write(m.Raw); err != nil {
+			t.Fatal("failed to decode message:", err)
+		}
+		var ticket MobilityTicket
+		if err := ticket.GetFrom(decoded); err != nil {
+			t.Fatal(err)
+		}
+		if len(ticket) != 0 {
+			t.Errorf("Expected empty ticket, got %v", ticket)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import "github.com/pion/stun/v2"
+
+const (
+	// AttrAccessToken is the ACCESS-TOKEN attribute, RFC 7635 Section 6.2
+	AttrAccessToken stun.AttrType = 0x001B
+
+	// AttrThirdPartyAuthorization is the THIRD-PARTY-AUTHORIZATION attribute,
+	// RFC 7635 Section 6.1
+	AttrThirdPartyAuthorization stun.AttrType = 0x802E
+)
+
+// AccessToken represents ACCESS-TOKEN attribute.
+//
+// The ACCESS-TOKEN attribute contains the self-contained token issued by
+// the authorization server, encrypted with the key shared between the
+// authorization server and the STUN server. It is opaque to the client.
+//
+// An empty AccessToken is not added to messages, so it can be used
+// unconditionally by clients that authenticate with long-term
+// credentials.
+//
+// RFC 7635 Section 6.2
+type AccessToken []byte
+
+// AddTo adds ACCESS-TOKEN to message if the token isn't empty.
+func (t AccessToken) AddTo(m *stun.Message) error {
+	if len(t) == 0 {
+		return nil
+	}
+	m.Add(AttrAccessToken, t)
+	return nil
+}
+
+// GetFrom decodes ACCESS-TOKEN from message.
+func (t *AccessToken) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrAccessToken)
+	if err != nil {
+		return err
+	}
+	*t = v
+	return nil
+}
+
+// ThirdPartyAuthorization represents THIRD-PARTY-AUTHORIZATION attribute.
+//
+// The THIRD-PARTY-AUTHORIZATION attribute is sent by the STUN server in
+// 401 (Unauthorized) responses to indicate that it supports third-party
+// authorization. Its value is the STUN server name of the server.
+//
+// RFC 7635 Section 6.1
+type ThirdPartyAuthorization string
+
+// AddTo adds THIRD-PARTY-AUTHORIZATION to message.
+func (t ThirdPartyAuthorization) AddTo(m *stun.Message) error {
+	m.Add(AttrThirdPartyAuthorization, []byte(t))
+	return nil
+}
+
+// GetFrom decodes THIRD-PARTY-AUTHORIZATION from message.
+func (t *ThirdPartyAuthorization) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrThirdPartyAuthorization)
+	if err != nil {
+		return err
+	}
+	*t = ThirdPartyAuthorization(v)
+	return nil
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/pion/stun/v2"
+)
+
+func TestAccessToken(t *testing.T) {
+	t.Run("AddTo", func(t *testing.T) {
+		m := new(stun.Message)
+		token := AccessToken{1, 2, 3, 4, 5}
+		if err := token.AddTo(m); err != nil {
+			t.Error(err)
+		}
+		m.WriteHeader()
+		t.Run("GetFrom", func(t *testing.T) {
+			decoded := new(stun.Message)
+			if _, err := decoded.Write(m.Raw); err != nil {
+				t.Fatal("failed to decode message:", err)
+			}
+			var decodedToken AccessToken
+			if err := decodedToken.GetFrom(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decodedToken, token) {
+				t.Errorf("Decoded %v, expected %v", decodedToken, token)
+			}
+			m := new(stun.Message)
+			if err := decodedToken.GetFrom(m); !errors.Is(err, stun.ErrAttributeNotFound) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	})
+	t.Run("Empty", func(t *testing.T) {
+		m := new(stun.Message)
+		if err := (AccessToken{}).AddTo(m); err != nil {
+			t.Error(err)
+		}
+		if m.Contains(AttrAccessToken) {
+			t.Error("Empty ACCESS-TOKEN should not be added")
+		}
+	})
+}
+
+func TestThirdPartyAuthorization(t *testing.T) {
+	m := new(stun.Message)
+	if err := ThirdPartyAuthorization("turn.pion.ly").AddTo(m); err != nil {
+		t.Error(err)
+	}
+	m.WriteHeader()
+	decoded := new(stun.Message)
+	if _, err := decoded.Write(m.Raw); err != nil {
+		t.Fatal("failed to decode message:", err)
+	}
+	var tpa ThirdPartyAuthorization
+	if err := tpa.GetFrom(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if tpa != "turn.pion.ly" {
+		t.Errorf("Decoded %q, expected %q", tpa, "turn.pion.ly")
+	}
+}
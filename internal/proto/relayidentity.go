@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"errors"
+
+	"github.com/pion/stun/v2"
+)
+
+// AttrRelayIdentity is the RELAY-IDENTITY attribute. It is a private,
+// comprehension-optional attribute that is ignored by clients that don't know it.
+const AttrRelayIdentity stun.AttrType = 0xC0A2
+
+// maxRelayIdentityFieldLength is the longest field of RELAY-IDENTITY, the length of a
+// field is encoded in one byte
+const maxRelayIdentityFieldLength = 255
+
+// ErrBadRelayIdentity means that a field of RELAY-IDENTITY is too long, or that its
+// value is truncated.
+var ErrBadRelayIdentity = errors.New("bad RELAY-IDENTITY")
+
+// RelayIdentity represents RELAY-IDENTITY attribute.
+//
+// The RELAY-IDENTITY attribute identifies the relay that holds an allocation by its
+// region, point of presence and node, so applications can tell which relay served a
+// session, e.g. to correlate call quality by point of presence. The server adds it to
+// Allocate success responses.
+//
+// Every field is encoded as one byte of length followed by its bytes, in the order
+// Region, PoP, Node. An empty RelayIdentity is not added to messages.
+type RelayIdentity struct {
+	Region string
+	PoP    string
+	Node   string
+}
+
+// IsZero returns true if no field of the RelayIdentity is set.
+func (i RelayIdentity) IsZero() bool {
+	return i == RelayIdentity{}
+}
+
+func (i RelayIdentity) String() string {
+	return i.Region + "/" + i.PoP + "/" + i.Node
+}
+
+// Validate returns ErrBadRelayIdentity if a field is longer than 255 bytes.
+func (i RelayIdentity) Validate() error {
+	for _, field := range []string{i.Region, i.PoP, i.Node} {
+		if len(field) > maxRelayIdentityFieldLength {
+			return ErrBadRelayIdentity
+		}
+	}
+	return nil
+}
+
+// AddTo adds RELAY-IDENTITY to message if the RelayIdentity isn't empty.
+func (i RelayIdentity) AddTo(m *stun.Message) error {
+	if i.IsZero() {
+		return nil
+	}
+	if err := i.Validate(); err != nil {
+		return err
+	}
+
+	v := make([]byte, 0, 3+len(i.Region)+len(i.PoP)+len(i.Node))
+	for _, field := range []string{i.Region, i.PoP, i.Node} {
+		v = append(v, byte(len(field)))
+		v = append(v, field...)
+	}
+	m.Add(AttrRelayIdentity, v)
+	return nil
+}
+
+// GetFrom decodes RELAY-IDENTITY from message.
+func (i *RelayIdentity) GetFrom(m *stun.Message) error {
+	v, err := m.Get(AttrRelayIdentity)
+	if err != nil {
+		return err
+	}
+
+	fields := [3]string{}
+	for f := range fields {
+		if len(v) == 0 || len(v) < 1+int(v[0]) {
+			return ErrBadRelayIdentity
+		}
+		fields[f] = string(v[1 : 1+int(v[0])])
+		v = v[1+int(v[0]):]
+	}
+
+	i.Region, i.PoP, i.Node = fields[0], fields[1], fields[2]
+	return nil
+}
@@ -0,0 +1,66 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package proto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pion/stun/v2"
+)
+
+func TestRelayIdentity(t *testing.T) {
+	t.Run("AddTo", func(t *testing.T) {
+		m := new(stun.Message)
+		identity := RelayIdentity{Region: "eu-west", PoP: "ams1", Node: "turn-3"}
+		if err := identity.AddTo(m); err != nil {
+			t.Error(err)
+		}
+		m.WriteHeader()
+		t.Run("GetFrom", func(t *testing.T) {
+			decoded := new(stun.Message)
+			if _, err := decoded.Write(m.Raw); err != nil {
+				t.Fatal("failed to decode message:", err)
+			}
+			var decodedIdentity RelayIdentity
+			if err := decodedIdentity.GetFrom(decoded); err != nil {
+				t.Fatal(err)
+			}
+			if decodedIdentity != identity {
+				t.Errorf("Decoded %v, expected %v", decodedIdentity, identity)
+			}
+			m := new(stun.Message)
+			if err := decodedIdentity.GetFrom(m); !errors.Is(err, stun.ErrAttributeNotFound) {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	})
+	t.Run("Empty", func(t *testing.T) {
+		m := new(stun.Message)
+		if err := (RelayIdentity{}).AddTo(m); err != nil {
+			t.Error(err)
+		}
+		if m.Contains(AttrRelayIdentity) {
+			t.Error("Empty RELAY-IDENTITY should not be added")
+		}
+	})
+	t.Run("TooLong", func(t *testing.T) {
+		m := new(stun.Message)
+		identity := RelayIdentity{Node: strings.Repeat("a", 256)}
+		if err := identity.AddTo(m); !errors.Is(err, ErrBadRelayIdentity) {
+			t.Errorf("Unexpected error: %v", err)
+		}
+	})
+	t.Run("Truncated", func(t *testing.T) {
+		for _, v := range [][]byte{{}, {2, 'e', 'u', 0}, {2, 'e', 'u', 0, 3, 'a'}} {
+			m := new(stun.Message)
+			m.Add(AttrRelayIdentity, v)
+			var identity RelayIdentity
+			if err := identity.GetFrom(m); !errors.Is(err, ErrBadRelayIdentity) {
+				t.Errorf("Unexpected error for %v: %v", v, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// Anomaly is a deviation from the STUN wire format in a received datagram. Anomalies
+// don't stop a message from being handled, they hint at broken middleboxes or scanners
+type Anomaly int
+
+const (
+	// AnomalyMissingFingerprint is a STUN message without FINGERPRINT
+	AnomalyMissingFingerprint Anomaly = iota
+	// AnomalyBadMagicCookie is a datagram with a STUN header, but a magic cookie other
+	// than 0x2112A442
+	AnomalyBadMagicCookie
+	// AnomalyReservedBits is a STUN message with an attribute whose reserved bits are set,
+	// e.g. the RFFU bytes of REQUESTED-TRANSPORT or the first byte of an XOR address
+	AnomalyReservedBits
+	// AnomalyAttributeOrder is a STUN message with attributes after FINGERPRINT, or after
+	// MESSAGE-INTEGRITY other than MESSAGE-INTEGRITY-SHA256 and FINGERPRINT
+	AnomalyAttributeOrder
+
+	anomalyCount
+)
+
+func (a Anomaly) String() string {
+	switch a {
+	case AnomalyMissingFingerprint:
+		return "missing fingerprint"
+	case AnomalyBadMagicCookie:
+		return "bad magic cookie"
+	case AnomalyReservedBits:
+		return "reserved bits set"
+	case AnomalyAttributeOrder:
+		return "unexpected attribute order"
+	default:
+		return "unknown anomaly"
+	}
+}
+
+const (
+	stunHeaderSize          = 20
+	stunAttributeHeaderSize = 4
+	stunMagicCookie         = 0x2112A442
+)
+
+// AnomalyCounter counts the anomalies in the datagrams received on a listener
+type AnomalyCounter struct {
+	counts [anomalyCount]uint64
+}
+
+// Counts returns how often every kind of Anomaly was seen, kinds that weren't seen are
+// left out
+func (c *AnomalyCounter) Counts() map[Anomaly]uint64 {
+	counts := map[Anomaly]uint64{}
+	for a := Anomaly(0); a < anomalyCount; a++ {
+		if count := atomic.LoadUint64(&c.counts[a]); count != 0 {
+			counts[a] = count
+		}
+	}
+
+	return counts
+}
+
+// Inspect counts the anomalies of datagram and returns them. ChannelData messages and
+// datagrams that don't have a STUN header are ignored
+func (c *AnomalyCounter) Inspect(datagram []byte) []Anomaly {
+	anomalies := inspectAnomalies(datagram)
+	for _, a := range anomalies {
+		atomic.AddUint64(&c.counts[a], 1)
+	}
+
+	return anomalies
+}
+
+func inspectAnomalies(datagram []byte) []Anomaly {
+	// The first two bits of STUN messages are zero, RFC 5389 Section 6
+	if len(datagram) < stunHeaderSize || datagram[0]&0xC0 != 0 || proto.IsChannelData(datagram) {
+		return nil
+	}
+	if binary.BigEndian.Uint32(datagram[4:8]) != stunMagicCookie {
+		return []Anomaly{AnomalyBadMagicCookie}
+	}
+
+	end := stunHeaderSize + int(binary.BigEndian.Uint16(datagram[2:4]))
+	if end > len(datagram) {
+		end = len(datagram)
+	}
+
+	var anomalies []Anomaly
+	var reservedBits, order, integrity, fingerprint bool
+	for offset := stunHeaderSize; offset+stunAttributeHeaderSize <= end; {
+		t := stun.AttrType(binary.BigEndian.Uint16(datagram[offset : offset+2]))
+		length := int(binary.BigEndian.Uint16(datagram[offset+2 : offset+4]))
+		offset += stunAttributeHeaderSize
+		if offset+length > end {
+			break
+		}
+		value := datagram[offset : offset+length]
+		offset += length + (4-length%4)%4
+
+		switch {
+		case fingerprint:
+			order = true
+		case integrity && t != stun.AttrMessageIntegritySHA256 && t != stun.AttrFingerprint:
+			order = true
+		}
+		switch t {
+		case stun.AttrMessageIntegrity:
+			integrity = true
+		case stun.AttrFingerprint:
+			fingerprint = true
+		default:
+		}
+
+		if hasReservedBits(t, value) {
+			reservedBits = true
+		}
+	}
+
+	if !fingerprint {
+		anomalies = append(anomalies, AnomalyMissingFingerprint)
+	}
+	if reservedBits {
+		anomalies = append(anomalies, AnomalyReservedBits)
+	}
+	if order {
+		anomalies = append(anomalies, AnomalyAttributeOrder)
+	}
+
+	return anomalies
+}
+
+// hasReservedBits checks the reserved bits of the attributes that have some
+func hasReservedBits(t stun.AttrType, value []byte) bool {
+	switch t {
+	case stun.AttrRequestedTransport:
+		// RFC 5766 Section 14.7: protocol followed by 3 RFFU bytes
+		return len(value) == 4 && (value[1] != 0 || value[2] != 0 || value[3] != 0)
+	case stun.AttrEvenPort:
+		// RFC 5766 Section 14.6: R bit followed by 7 RFFU bits
+		return len(value) == 1 && value[0]&0x7F != 0
+	case stun.AttrXORPeerAddress, stun.AttrXORRelayedAddress, stun.AttrXORMappedAddress:
+		// RFC 5389 Section 15.2: the first byte is zero
+		return len(value) > 0 && value[0] != 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInspectAnomalies(t *testing.T) {
+	allocate := stun.NewType(stun.MethodAllocate, stun.ClassRequest)
+	build := func(t *testing.T, setters ...stun.Setter) []byte {
+		m, err := stun.Build(append([]stun.Setter{stun.TransactionID, allocate}, setters...)...)
+		require.NoError(t, err)
+		return m.Raw
+	}
+
+	t.Run("well formed", func(t *testing.T) {
+		raw := build(t, proto.RequestedTransport{Protocol: proto.ProtoUDP}, stun.NewUsername("user"),
+			stun.NewShortTermIntegrity("pass"), stun.Fingerprint)
+		assert.Empty(t, inspectAnomalies(raw))
+	})
+
+	t.Run("missing fingerprint", func(t *testing.T) {
+		raw := build(t, proto.RequestedTransport{Protocol: proto.ProtoUDP})
+		assert.Equal(t, []Anomaly{AnomalyMissingFingerprint}, inspectAnomalies(raw))
+	})
+
+	t.Run("bad magic cookie", func(t *testing.T) {
+		raw := build(t, stun.Fingerprint)
+		raw[4] ^= 0xFF
+		assert.Equal(t, []Anomaly{AnomalyBadMagicCookie}, inspectAnomalies(raw))
+	})
+
+	t.Run("reserved bits", func(t *testing.T) {
+		raw := build(t, proto.RequestedTransport{Protocol: proto.ProtoUDP}, stun.Fingerprint)
+		// The first RFFU byte of REQUESTED-TRANSPORT, after the header and attribute header
+		raw[25] = 1
+		assert.Equal(t, []Anomaly{AnomalyReservedBits}, inspectAnomalies(raw))
+	})
+
+	t.Run("attribute after fingerprint", func(t *testing.T) {
+		raw := build(t, stun.Fingerprint, stun.NewUsername("user"))
+		assert.Equal(t, []Anomaly{AnomalyAttributeOrder}, inspectAnomalies(raw))
+	})
+
+	t.Run("attribute after message integrity", func(t *testing.T) {
+		raw := build(t, stun.NewShortTermIntegrity("pass"), stun.NewUsername("user"), stun.Fingerprint)
+		assert.Equal(t, []Anomaly{AnomalyAttributeOrder}, inspectAnomalies(raw))
+	})
+
+	t.Run("ignores ChannelData and short datagrams", func(t *testing.T) {
+		c := &proto.ChannelData{Number: proto.MinChannelNumber, Data: make([]byte, 32)}
+		c.Encode()
+		assert.Empty(t, inspectAnomalies(c.Raw))
+		assert.Empty(t, inspectAnomalies([]byte{0, 1, 0, 0}))
+	})
+
+	t.Run("counts", func(t *testing.T) {
+		c := &AnomalyCounter{}
+		c.Inspect(build(t))
+		c.Inspect(build(t, &proto.PeerAddress{IP: net.IPv4(127, 0, 0, 1), Port: 5000}))
+		assert.Equal(t, map[Anomaly]uint64{AnomalyMissingFingerprint: 2}, c.Counts())
+	})
+}
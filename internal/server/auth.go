@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// AuthRequest is a request authenticated by an AuthHandlerV2
+type AuthRequest struct {
+	Username string
+	Realm    string
+	SrcAddr  net.Addr
+	// Listener is the name of the listener the request was received on
+	Listener string
+	// Message is the STUN request. It must not be modified or used after returning
+	Message *stun.Message
+}
+
+// AuthHandlerV2 returns the key of the user of a request, and the policy of the
+// allocation if the request is an Allocate request
+type AuthHandlerV2 func(ctx context.Context, req AuthRequest) (key []byte, policy allocation.SessionPolicy, ok bool)
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// BandwidthLimitConfig configures BandwidthLimits
+type BandwidthLimitConfig struct {
+	// AllocationBytesPerSecond caps the bytes every allocation relays in both directions.
+	// Unlimited if 0
+	AllocationBytesPerSecond int
+	// UserBytesPerSecond caps the bytes all allocations of a username relay together.
+	// Unlimited if 0
+	UserBytesPerSecond int
+	// Handler returns the AllocationBytesPerSecond of a new allocation, e.g. depending on
+	// the account of the user. AllocationBytesPerSecond is used if it returns false,
+	// 0 is unlimited
+	Handler func(username, realm string, srcAddr net.Addr) (bytesPerSecond int, ok bool)
+}
+
+type userBandwidth struct {
+	limiter     *allocation.BandwidthLimiter
+	allocations int
+}
+
+// BandwidthLimits applies the bandwidth limits to new allocations and tracks the limiters
+// shared by the allocations of a username
+type BandwidthLimits struct {
+	config BandwidthLimitConfig
+
+	lock  sync.Mutex
+	users map[string]*userBandwidth
+}
+
+// NewBandwidthLimits creates BandwidthLimits
+func NewBandwidthLimits(config BandwidthLimitConfig) *BandwidthLimits {
+	return &BandwidthLimits{
+		config: config,
+		users:  map[string]*userBandwidth{},
+	}
+}
+
+// Apply sets the bandwidth limiters of a, created by username. bytesPerSecond overrides
+// the limit of a if not 0, it is applied even without BandwidthLimits
+func (b *BandwidthLimits) Apply(a *allocation.Allocation, username, realm string, srcAddr net.Addr, bytesPerSecond int) {
+	if b == nil {
+		if bytesPerSecond > 0 {
+			a.SetBandwidthLimiters(nil, allocation.NewBandwidthLimiter(bytesPerSecond))
+		}
+		return
+	}
+
+	limiters := []*allocation.BandwidthLimiter{}
+
+	if bytesPerSecond == 0 {
+		bytesPerSecond = b.config.AllocationBytesPerSecond
+		if b.config.Handler != nil {
+			if limit, ok := b.config.Handler(username, realm, srcAddr); ok {
+				bytesPerSecond = limit
+			}
+		}
+	}
+	if bytesPerSecond > 0 {
+		limiters = append(limiters, allocation.NewBandwidthLimiter(bytesPerSecond))
+	}
+
+	var release func()
+	if b.config.UserBytesPerSecond > 0 {
+		limiters = append(limiters, b.acquire(username))
+		release = func() { b.release(username) }
+	}
+
+	a.SetBandwidthLimiters(release, limiters...)
+}
+
+func (b *BandwidthLimits) acquire(username string) *allocation.BandwidthLimiter {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	u, ok := b.users[username]
+	if !ok {
+		u = &userBandwidth{limiter: allocation.NewBandwidthLimiter(b.config.UserBytesPerSecond)}
+		b.users[username] = u
+	}
+	u.allocations++
+
+	return u.limiter
+}
+
+func (b *BandwidthLimits) release(username string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if u, ok := b.users[username]; ok {
+		if u.allocations--; u.allocations <= 0 {
+			delete(b.users, username)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBandwidthLimits(t *testing.T) {
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+	srcAddr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+
+	t.Run("Nil", func(t *testing.T) {
+		var b *BandwidthLimits
+		a := allocation.NewAllocation(nil, nil, log)
+		b.Apply(a, "user", "pion.ly", srcAddr, 0)
+		assert.True(t, a.AllowRelay(1<<20))
+
+		// The limit of the session policy is applied anyway
+		limited := allocation.NewAllocation(nil, nil, log)
+		b.Apply(limited, "user", "pion.ly", srcAddr, 100)
+		assert.True(t, limited.AllowRelay(100))
+		assert.False(t, limited.AllowRelay(100))
+	})
+
+	t.Run("Handler", func(t *testing.T) {
+		b := NewBandwidthLimits(BandwidthLimitConfig{
+			AllocationBytesPerSecond: 1000,
+			Handler: func(username, realm string, srcAddr net.Addr) (int, bool) {
+				return 100, username == "free"
+			},
+		})
+
+		free, paid := allocation.NewAllocation(nil, nil, log), allocation.NewAllocation(nil, nil, log)
+		b.Apply(free, "free", "pion.ly", srcAddr, 0)
+		b.Apply(paid, "paid", "pion.ly", srcAddr, 0)
+
+		assert.True(t, free.AllowRelay(100))
+		assert.False(t, free.AllowRelay(100))
+		assert.True(t, paid.AllowRelay(1000))
+		assert.False(t, paid.AllowRelay(100))
+
+		// The limit of the session policy replaces the Handler
+		override := allocation.NewAllocation(nil, nil, log)
+		b.Apply(override, "free", "pion.ly", srcAddr, 500)
+		assert.True(t, override.AllowRelay(500))
+		assert.False(t, override.AllowRelay(100))
+	})
+
+	t.Run("PerUser", func(t *testing.T) {
+		b := NewBandwidthLimits(BandwidthLimitConfig{UserBytesPerSecond: 1000})
+
+		first, second := allocation.NewAllocation(nil, nil, log), allocation.NewAllocation(nil, nil, log)
+		b.Apply(first, "user", "pion.ly", srcAddr, 0)
+		b.Apply(second, "user", "pion.ly", srcAddr, 0)
+
+		// The allocations of a user share the limit
+		assert.True(t, first.AllowRelay(600))
+		assert.False(t, second.AllowRelay(600))
+		assert.Len(t, b.users, 1)
+
+		// The limiter of the user is removed with its last allocation
+		b.release("user")
+		assert.Len(t, b.users, 1)
+		b.release("user")
+		assert.Len(t, b.users, 0)
+	})
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v3/internal/ipnet"
+)
+
+// challengeBatchSize is the number of nonces generated at once
+const challengeBatchSize = 16
+
+type challenge struct {
+	nonce   string
+	expires time.Time
+}
+
+// ChallengeCache remembers the nonce of the 401 (Unauthorized) challenge sent to a source
+// IP for ttl. Clients retrying right after the challenge get the same nonce again, and
+// the nonce they retry with is accepted without validating it with the NonceGenerator.
+// Nonces are generated in batches, so connection storms cost few calls of the
+// NonceGenerator. Nonces must stay valid for twice ttl.
+type ChallengeCache struct {
+	nonces NonceGenerator
+	ttl    time.Duration
+
+	lock      sync.Mutex
+	sources   map[string]challenge
+	batch     []string
+	batchEnd  time.Time
+	lastSweep time.Time
+}
+
+// NewChallengeCache creates a ChallengeCache of the nonces of nonces
+func NewChallengeCache(nonces NonceGenerator, ttl time.Duration) *ChallengeCache {
+	return &ChallengeCache{
+		nonces:    nonces,
+		ttl:       ttl,
+		sources:   map[string]challenge{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Nonce returns the nonce of the challenge of the IP of srcAddr
+func (c *ChallengeCache) Nonce(srcAddr net.Addr) (string, error) {
+	return c.nonce(srcAddr, time.Now())
+}
+
+func (c *ChallengeCache) nonce(srcAddr net.Addr, now time.Time) (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if now.Sub(c.lastSweep) >= c.ttl {
+		c.sweep(now)
+	}
+
+	key := ipnet.FingerprintAddr(srcAddr)
+	if cached, ok := c.sources[key]; ok && now.Before(cached.expires) {
+		return cached.nonce, nil
+	}
+
+	// Batched nonces are handed out within ttl, so they are cached until 2*ttl at most
+	if len(c.batch) == 0 || !now.Before(c.batchEnd) {
+		batch := make([]string, challengeBatchSize)
+		for i := range batch {
+			nonce, err := c.nonces.Generate()
+			if err != nil {
+				return "", err
+			}
+			batch[i] = nonce
+		}
+		c.batch, c.batchEnd = batch, now.Add(c.ttl)
+	}
+
+	nonce := c.batch[0]
+	c.batch = c.batch[1:]
+	c.sources[key] = challenge{nonce: nonce, expires: now.Add(c.ttl)}
+
+	return nonce, nil
+}
+
+// Issued returns true if nonce is the nonce of the challenge of the IP of srcAddr
+func (c *ChallengeCache) Issued(srcAddr net.Addr, nonce string) bool {
+	if c == nil {
+		return false
+	}
+
+	return c.issued(srcAddr, nonce, time.Now())
+}
+
+func (c *ChallengeCache) issued(srcAddr net.Addr, nonce string, now time.Time) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cached, ok := c.sources[ipnet.FingerprintAddr(srcAddr)]
+	return ok && cached.nonce == nonce && now.Before(cached.expires)
+}
+
+// sweep removes the expired challenges
+func (c *ChallengeCache) sweep(now time.Time) {
+	for key, cached := range c.sources {
+		if !now.Before(cached.expires) {
+			delete(c.sources, key)
+		}
+	}
+	c.lastSweep = now
+}
@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type countingNonces struct {
+	generated int
+}
+
+func (c *countingNonces) Generate() (string, error) {
+	c.generated++
+	return fmt.Sprint(c.generated), nil
+}
+
+func (c *countingNonces) Validate(string) error {
+	return nil
+}
+
+func TestChallengeCache(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		var c *ChallengeCache
+		assert.False(t, c.Issued(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, "1"))
+	})
+
+	t.Run("PerSourceIP", func(t *testing.T) {
+		nonces := &countingNonces{}
+		c := NewChallengeCache(nonces, 5*time.Second)
+		now := time.Now()
+
+		// Ports of the same IP share a nonce
+		first, err := c.nonce(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, now)
+		require.NoError(t, err)
+		second, err := c.nonce(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5001}, now)
+		require.NoError(t, err)
+		assert.Equal(t, first, second)
+		assert.True(t, c.issued(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5002}, first, now))
+
+		other, err := c.nonce(&net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000}, now)
+		require.NoError(t, err)
+		assert.NotEqual(t, first, other)
+		assert.False(t, c.issued(&net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000}, first, now))
+
+		// Challenges expire after ttl
+		now = now.Add(5 * time.Second)
+		assert.False(t, c.issued(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, first, now))
+		third, err := c.nonce(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, now)
+		require.NoError(t, err)
+		assert.NotEqual(t, first, third)
+
+		// Expired challenges are swept
+		assert.Len(t, c.sources, 1)
+	})
+
+	t.Run("Batches", func(t *testing.T) {
+		nonces := &countingNonces{}
+		c := NewChallengeCache(nonces, 5*time.Second)
+		now := time.Now()
+
+		for i := 0; i < challengeBatchSize+1; i++ {
+			_, err := c.nonce(&net.UDPAddr{IP: net.IPv4(10, 0, 0, byte(i)), Port: 5000}, now)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, 2*challengeBatchSize, nonces.generated)
+
+		// Batches aren't handed out for longer than ttl
+		_, err := c.nonce(&net.UDPAddr{IP: net.ParseIP("10.0.1.1"), Port: 5000}, now.Add(5*time.Second))
+		require.NoError(t, err)
+		assert.Equal(t, 3*challengeBatchSize, nonces.generated)
+	})
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v3/counter"
+)
+
+const (
+	circuitBreakerFailures = 3
+	circuitBreakerCooldown = 10 * time.Second
+)
+
+// CircuitBreaker is a counter.Store that stops calling its store for a cooldown after
+// consecutive failures, so a store that is down doesn't add its timeout to every call.
+// Calls fail fast during the cooldown and the callers fall back to their local counts.
+// The first call after the cooldown is tried again.
+type CircuitBreaker struct {
+	store counter.Store
+
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+// NewCircuitBreaker creates a CircuitBreaker for store
+func NewCircuitBreaker(store counter.Store) *CircuitBreaker {
+	return &CircuitBreaker{store: store}
+}
+
+// Add adds delta to the counter key of the store, unless the breaker is open
+func (b *CircuitBreaker) Add(key string, delta int64, ttl time.Duration) (int64, error) {
+	return b.call(func() (int64, error) {
+		return b.store.Add(key, delta, ttl)
+	})
+}
+
+// AddMember adds member to the set key of the store, unless the breaker is open
+func (b *CircuitBreaker) AddMember(key, member string, ttl time.Duration) (int64, error) {
+	return b.call(func() (int64, error) {
+		return b.store.AddMember(key, member, ttl)
+	})
+}
+
+// RemoveMember removes member from the set key of the store, unless the breaker is open
+func (b *CircuitBreaker) RemoveMember(key, member string) (int64, error) {
+	return b.call(func() (int64, error) {
+		return b.store.RemoveMember(key, member)
+	})
+}
+
+// Members returns the number of members of the set key of the store, unless the breaker
+// is open
+func (b *CircuitBreaker) Members(key string) (int64, error) {
+	return b.call(func() (int64, error) {
+		return b.store.Members(key)
+	})
+}
+
+func (b *CircuitBreaker) call(f func() (int64, error)) (int64, error) {
+	if b.open(time.Now()) {
+		return 0, errCircuitOpen
+	}
+
+	value, err := f()
+	b.record(err, time.Now())
+	return value, err
+}
+
+func (b *CircuitBreaker) open(now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return now.Before(b.openUntil)
+}
+
+// record counts the consecutive failures and opens the breaker at circuitBreakerFailures
+func (b *CircuitBreaker) record(err error, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= circuitBreakerFailures {
+		b.openUntil = now.Add(circuitBreakerCooldown)
+	}
+}
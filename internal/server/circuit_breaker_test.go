@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v3/counter"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyCounters fail while failing is set and count the calls
+type flakyCounters struct {
+	counter.Store
+	failing bool
+	calls   int
+}
+
+func (c *flakyCounters) Add(string, int64, time.Duration) (int64, error) {
+	c.calls++
+	if c.failing {
+		return 0, counter.ErrClosed
+	}
+
+	return 1, nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	store := &flakyCounters{failing: true}
+	b := NewCircuitBreaker(store)
+
+	// Consecutive failures open the breaker
+	for i := 0; i < circuitBreakerFailures; i++ {
+		_, err := b.Add("a", 1, 0)
+		assert.ErrorIs(t, err, counter.ErrClosed)
+	}
+	_, err := b.Add("a", 1, 0)
+	assert.ErrorIs(t, err, errCircuitOpen)
+	assert.Equal(t, circuitBreakerFailures, store.calls)
+
+	// After the cooldown a failing call opens it again
+	b.openUntil = time.Now()
+	_, err = b.Add("a", 1, 0)
+	assert.ErrorIs(t, err, counter.ErrClosed)
+	_, err = b.Add("a", 1, 0)
+	assert.ErrorIs(t, err, errCircuitOpen)
+
+	// A successful call closes it
+	b.openUntil = time.Now()
+	store.failing = false
+	value, err := b.Add("a", 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), value)
+	assert.Equal(t, 0, b.failures)
+}
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// CredentialCache lets allocations keep the key their requests were authenticated with,
+// so the requests of an allocation are verified without calling the AuthHandler again.
+// The keys are cached per username and realm, a request that fails to verify with the
+// cached key is authenticated with the AuthHandler. Invalidate drops the keys of all
+// allocations, Allocation.RequireReauthentication the key of one. The key of a
+// time-windowed credential isn't used after it expired, the AuthHandler decides then.
+type CredentialCache struct {
+	generation uint64
+	expiry     func(username string) (time.Time, bool)
+}
+
+// NewCredentialCache creates a CredentialCache. expiry returns the expiry of the credential
+// of username, see CredentialExpiryPolicy.Expiry, TimeWindowedCredentialExpiry if nil
+func NewCredentialCache(expiry func(username string) (time.Time, bool)) *CredentialCache {
+	if expiry == nil {
+		expiry = TimeWindowedCredentialExpiry
+	}
+
+	return &CredentialCache{expiry: expiry}
+}
+
+// Invalidate drops the cached keys, e.g. because the AuthHandler was replaced
+func (c *CredentialCache) Invalidate() {
+	if c == nil {
+		return
+	}
+
+	atomic.AddUint64(&c.generation, 1)
+}
+
+// lookup returns the key cached with a for username and realm, nil if there is none, and
+// the generation to store the key of the AuthHandler in
+func (c *CredentialCache) lookup(a *allocation.Allocation, username, realm string) (*proto.Integrity, uint64) {
+	if c == nil || a == nil {
+		return nil, 0
+	}
+
+	generation := atomic.LoadUint64(&c.generation)
+	return a.CachedCredentials(username, realm, generation, time.Now()), generation
+}
+
+// store caches the key of username and realm with a until the credential of username
+// expires. Keys of an older generation than the current one aren't used
+func (c *CredentialCache) store(a *allocation.Allocation, username, realm string, generation uint64, integrity *proto.Integrity) {
+	if c == nil || a == nil {
+		return
+	}
+
+	expires, _ := c.expiry(username)
+	a.CacheCredentials(username, realm, generation, integrity, expires)
+}
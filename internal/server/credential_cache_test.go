@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialCache(t *testing.T) {
+	log := logging.NewDefaultLoggerFactory().NewLogger("test")
+	integrity := proto.NewIntegrity(stun.NewLongTermIntegrity("user", "pion.ly", "pass"))
+
+	t.Run("Nil", func(t *testing.T) {
+		var c *CredentialCache
+		a := allocation.NewAllocation(nil, nil, log)
+		c.store(a, "user", "pion.ly", 0, integrity)
+		cached, _ := c.lookup(a, "user", "pion.ly")
+		assert.Nil(t, cached)
+		c.Invalidate()
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		c := NewCredentialCache(nil)
+		a := allocation.NewAllocation(nil, nil, log)
+
+		cached, generation := c.lookup(a, "user", "pion.ly")
+		assert.Nil(t, cached)
+		c.store(a, "user", "pion.ly", generation, integrity)
+
+		cached, _ = c.lookup(a, "user", "pion.ly")
+		assert.Equal(t, integrity, cached)
+		cached, _ = c.lookup(a, "other", "pion.ly")
+		assert.Nil(t, cached)
+		cached, _ = c.lookup(a, "user", "example.com")
+		assert.Nil(t, cached)
+		cached, _ = c.lookup(nil, "user", "pion.ly")
+		assert.Nil(t, cached)
+	})
+
+	t.Run("Invalidate", func(t *testing.T) {
+		c := NewCredentialCache(nil)
+		a := allocation.NewAllocation(nil, nil, log)
+
+		_, generation := c.lookup(a, "user", "pion.ly")
+		c.store(a, "user", "pion.ly", generation, integrity)
+		c.Invalidate()
+		cached, generation := c.lookup(a, "user", "pion.ly")
+		assert.Nil(t, cached)
+
+		// Keys looked up before Invalidate aren't used after it
+		c.Invalidate()
+		c.store(a, "user", "pion.ly", generation, integrity)
+		cached, generation = c.lookup(a, "user", "pion.ly")
+		assert.Nil(t, cached)
+
+		c.store(a, "user", "pion.ly", generation, integrity)
+		a.RequireReauthentication()
+		cached, _ = c.lookup(a, "user", "pion.ly")
+		assert.Nil(t, cached)
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		c := NewCredentialCache(nil)
+		a := allocation.NewAllocation(nil, nil, log)
+
+		valid := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10) + ":user"
+		_, generation := c.lookup(a, valid, "pion.ly")
+		c.store(a, valid, "pion.ly", generation, integrity)
+		cached, _ := c.lookup(a, valid, "pion.ly")
+		assert.Equal(t, integrity, cached)
+
+		expired := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10) + ":user"
+		c.store(a, expired, "pion.ly", generation, integrity)
+		cached, _ = c.lookup(a, expired, "pion.ly")
+		assert.Nil(t, cached)
+
+		// The expiry of CredentialExpiryPolicy.Expiry is used if set
+		c = NewCredentialCache(func(string) (time.Time, bool) {
+			return time.Time{}, false
+		})
+		c.store(a, expired, "pion.ly", generation, integrity)
+		cached, _ = c.lookup(a, expired, "pion.ly")
+		assert.Equal(t, integrity, cached)
+	})
+}
+
+func TestCredentialCacheExpiredUsername(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	nonce, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	// The AuthHandler rejects expired usernames like NewLongTermAuthHandler
+	authHandlerCalls := 0
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		CredentialCache:   NewCredentialCache(nil),
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			authHandlerCalls++
+			expiry, _ := TimeWindowedCredentialExpiry(username)
+			return nil, expiry.After(time.Now())
+		},
+	}
+
+	fiveTuple := &allocation.FiveTuple{SrcAddr: r.SrcAddr, DstAddr: r.Conn.LocalAddr(), Protocol: allocation.UDP}
+	a, err := allocationManager.CreateAllocation(fiveTuple, r.Conn, proto.RequestedFamilyIPv4, 0, time.Hour)
+	assert.NoError(t, err)
+
+	username := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10) + ":user"
+	key := stun.NewLongTermIntegrity(username, "pion.ly", "pass")
+	_, generation := r.CredentialCache.lookup(a, username, "pion.ly")
+	r.CredentialCache.store(a, username, "pion.ly", generation, proto.NewIntegrity(key))
+
+	m, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassRequest),
+		stun.Nonce(nonce), stun.Realm("pion.ly"), stun.Username(username), key)
+	assert.NoError(t, err)
+
+	_, hasAuth, err := authenticateRequest(r, m, stun.MethodRefresh)
+	assert.False(t, hasAuth)
+	assert.ErrorIs(t, err, errNoSuchUser)
+	assert.Equal(t, 1, authHandlerCalls)
+}
@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CredentialExpiryPolicy makes the expiry of a time-windowed credential end the allocations
+// created with it. Refresh requests are rejected once the expiry of the credential they are
+// authenticated with plus GracePeriod has passed, the allocation then expires within the
+// lifetime granted before.
+type CredentialExpiryPolicy struct {
+	// GracePeriod is how long after the expiry of the credential Refresh requests are
+	// still accepted
+	GracePeriod time.Duration
+
+	// Expiry returns the expiry of the credential of username, and false if it doesn't
+	// expire. Defaults to the timestamp of usernames formatted as timestamp or
+	// timestamp:username, as generated by GenerateLongTermCredentials and
+	// GenerateLongTermTURNRESTCredentials
+	Expiry func(username string) (time.Time, bool)
+}
+
+// Expired returns true if the credential of username expired more than GracePeriod ago
+func (p *CredentialExpiryPolicy) Expired(username string, now time.Time) bool {
+	if p == nil {
+		return false
+	}
+
+	expiryFunc := p.Expiry
+	if expiryFunc == nil {
+		expiryFunc = TimeWindowedCredentialExpiry
+	}
+
+	expiry, ok := expiryFunc(username)
+	return ok && expiry.Add(p.GracePeriod).Before(now)
+}
+
+// TimeWindowedCredentialExpiry returns the expiry timestamp of a username formatted as
+// timestamp or timestamp:username
+func TimeWindowedCredentialExpiry(username string) (time.Time, bool) {
+	t, err := strconv.ParseInt(strings.Split(username, ":")[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(t, 0), true
+}
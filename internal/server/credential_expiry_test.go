@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCredentialExpiryPolicy(t *testing.T) {
+	now := time.Now()
+	expiry := strconv.FormatInt(now.Add(-time.Minute).Unix(), 10)
+
+	var disabled *CredentialExpiryPolicy
+	assert.False(t, disabled.Expired(expiry+":user", now))
+
+	p := &CredentialExpiryPolicy{}
+	assert.True(t, p.Expired(expiry, now))
+	assert.True(t, p.Expired(expiry+":user", now))
+	assert.False(t, p.Expired(strconv.FormatInt(now.Add(time.Minute).Unix(), 10)+":user", now))
+	assert.False(t, p.Expired("user", now))
+
+	p.GracePeriod = 5 * time.Minute
+	assert.False(t, p.Expired(expiry+":user", now))
+	assert.True(t, p.Expired(expiry+":user", now.Add(5*time.Minute)))
+
+	p.Expiry = func(string) (time.Time, bool) {
+		return time.Time{}, false
+	}
+	assert.False(t, p.Expired(expiry+":user", now.Add(time.Hour)))
+}
@@ -23,7 +23,28 @@ var (
 	errRequestWithReservationTokenAndEvenPort = errors.New("Request must not contain RESERVATION-TOKEN and EVEN-PORT")
 	errNoAllocationFound                      = errors.New("no allocation found")
 	errNoPermission                           = errors.New("unable to handle send-indication, no permission added")
+	errPeerPortNotAllowed                     = errors.New("peer port not allowed")
 	errShortWrite                             = errors.New("packet write smaller than packet")
 	errNoSuchChannelBind                      = errors.New("no such channel bind")
 	errFailedWriteSocket                      = errors.New("failed writing to socket")
+	errServerInMaintenance                    = errors.New("server is in maintenance")
+	errTCPAllocationsDisabled                 = errors.New("TCP allocations are disabled")
+	errTCPAllocationOverUDP                   = errors.New("TCP allocations must be requested over TCP or TLS")
+	errTCPAllocationWithEvenPort              = errors.New("TCP allocations must not contain EVEN-PORT or RESERVATION-TOKEN")
+	errNotTCPAllocation                       = errors.New("allocation is not a TCP allocation")
+	errNotUDPAllocation                       = errors.New("allocation is not a UDP allocation")
+	errRelayNotBound                          = errors.New("failed to bind relay socket")
+	errNotStream                              = errors.New("ConnectionBind must be sent over TCP or TLS")
+	errRequestWithReservationTokenAndFamily   = errors.New("Request must not contain RESERVATION-TOKEN and REQUESTED-ADDRESS-FAMILY")
+	errUnsupportedAddressFamily               = errors.New("unsupported REQUESTED-ADDRESS-FAMILY")
+	errInvalidAdditionalAddressFamily         = errors.New("ADDITIONAL-ADDRESS-FAMILY must be IPv6 and must not be combined with REQUESTED-ADDRESS-FAMILY, RESERVATION-TOKEN or TCP allocations")
+	errPeerAddressFamilyMismatch              = errors.New("peer address family does not match the allocation")
+	errReauthenticationRequired               = errors.New("allocation requires new credentials")
+	errMobilityForbidden                      = errors.New("mobility is not enabled")
+	errCredentialExpired                      = errors.New("credential expired")
+	errInvalidAlternateServer                 = errors.New("invalid alternate server")
+	errAllocationQuotaReached                 = errors.New("allocation quota reached")
+	errDeniedByPolicy                         = errors.New("denied by policy")
+	errRateLimitExceeded                      = errors.New("rate limit exceeded")
+	errCircuitOpen                            = errors.New("counters unavailable, circuit breaker open")
 )
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// Maintenance holds the state of a scheduled maintenance window. While a window
+// is scheduled the lifetimes granted to allocations are capped so they expire by
+// the start of the window, and clients can optionally be pointed at an alternate
+// server with a 300 (Try Alternate) response.
+type Maintenance struct {
+	lock            sync.RWMutex
+	scheduled       bool
+	start           time.Time
+	alternateServer *stun.AlternateServer
+	redirected      map[string]struct{}
+}
+
+// NewMaintenance creates a Maintenance with no window scheduled
+func NewMaintenance() *Maintenance {
+	return &Maintenance{
+		redirected: map[string]struct{}{},
+	}
+}
+
+// Schedule announces a maintenance window starting at start. alternateServer may be nil
+func (m *Maintenance) Schedule(start time.Time, alternateServer *stun.AlternateServer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.scheduled = true
+	m.start = start
+	m.alternateServer = alternateServer
+	m.redirected = map[string]struct{}{}
+}
+
+// Cancel removes the scheduled maintenance window, if any
+func (m *Maintenance) Cancel() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.scheduled = false
+	m.alternateServer = nil
+	m.redirected = map[string]struct{}{}
+}
+
+// Scheduled returns the start of the scheduled maintenance window
+func (m *Maintenance) Scheduled() (time.Time, bool) {
+	if m == nil {
+		return time.Time{}, false
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.start, m.scheduled
+}
+
+// CapLifetime shortens lifetime so that it does not extend past the start of
+// the maintenance window. Once the window has started the returned lifetime is 0
+func (m *Maintenance) CapLifetime(lifetime time.Duration) time.Duration {
+	start, ok := m.Scheduled()
+	if !ok {
+		return lifetime
+	}
+
+	return capLifetime(start, lifetime)
+}
+
+// AlternateServer returns the server new allocations should be redirected to
+func (m *Maintenance) AlternateServer() (*stun.AlternateServer, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.alternateServer, m.scheduled && m.alternateServer != nil
+}
+
+// RedirectRefresh reports whether the Refresh for the allocation identified by
+// fiveTuple should be answered with ALTERNATE-SERVER. Every allocation is only
+// redirected once per maintenance window
+func (m *Maintenance) RedirectRefresh(fiveTuple *allocation.FiveTuple) (*stun.AlternateServer, bool) {
+	if m == nil {
+		return nil, false
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.scheduled || m.alternateServer == nil {
+		return nil, false
+	}
+
+	fingerprint := fiveTuple.Fingerprint()
+	if _, ok := m.redirected[fingerprint]; ok {
+		return nil, false
+	}
+	m.redirected[fingerprint] = struct{}{}
+
+	return m.alternateServer, true
+}
@@ -10,36 +10,97 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"sync"
 	"time"
 )
 
 const (
-	nonceLifetime  = time.Hour // See: https://tools.ietf.org/html/rfc5766#section-4
-	nonceLength    = 40
-	nonceKeyLength = 64
+	// DefaultNonceLifetime is the lifetime of nonces if none is configured
+	DefaultNonceLifetime = time.Hour // See: https://tools.ietf.org/html/rfc5766#section-4
+	nonceLength          = 40
+	nonceKeyLength       = 64
 )
 
-// NewNonceHash creates a NonceHash
-func NewNonceHash() (*NonceHash, error) {
-	key := make([]byte, nonceKeyLength)
-	if _, err := rand.Read(key); err != nil {
+// NonceGenerator creates the nonces sent in 401 (Unauthorized) and 438 (Stale Nonce)
+// responses and validates the nonces of requests. Requests with a nonce that isn't valid
+// are rejected with a 438 (Stale Nonce) error carrying a new one.
+type NonceGenerator interface {
+	Generate() (string, error)
+	Validate(nonce string) error
+}
+
+// NonceRotation describes a rotation of the key nonces are signed with. Nonces signed with
+// the previous key stay valid until they expire, Lifetime after they were generated
+type NonceRotation struct {
+	Time     time.Time
+	Lifetime time.Duration
+}
+
+// NewNonceHash creates a NonceHash. Its nonces expire after lifetime, DefaultNonceLifetime if 0
+func NewNonceHash(lifetime time.Duration) (*NonceHash, error) {
+	if lifetime == 0 {
+		lifetime = DefaultNonceLifetime
+	}
+
+	n := &NonceHash{lifetime: lifetime}
+	if err := n.rotate(time.Now()); err != nil {
 		return nil, err
 	}
 
-	return &NonceHash{key}, nil
+	return n, nil
 }
 
-// NonceHash is used to create and verify nonces
+// NonceHash is a NonceGenerator of nonces signed with HMAC-SHA256. The key is rotated
+// every lifetime, nonces signed with the previous key stay valid until they expire.
 type NonceHash struct {
-	key []byte
+	lifetime time.Duration
+
+	// OnRotate, if set, is called when the key is rotated. It must be set before the
+	// NonceHash is used and must not block
+	OnRotate func(NonceRotation)
+
+	lock    sync.RWMutex
+	key     []byte
+	prevKey []byte
+	rotated time.Time
+}
+
+func (n *NonceHash) rotate(now time.Time) error {
+	key := make([]byte, nonceKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	n.key, n.prevKey, n.rotated = key, n.key, now
+	return nil
 }
 
 // Generate a nonce
 func (n *NonceHash) Generate() (string, error) {
+	return n.generate(time.Now())
+}
+
+func (n *NonceHash) generate(now time.Time) (string, error) {
+	n.lock.Lock()
+	rotated := false
+	if now.Sub(n.rotated) >= n.lifetime {
+		if err := n.rotate(now); err != nil {
+			n.lock.Unlock()
+			return "", fmt.Errorf("%w: %v", errFailedToGenerateNonce, err) //nolint:errorlint
+		}
+		rotated = true
+	}
+	key := n.key
+	n.lock.Unlock()
+
+	if rotated && n.OnRotate != nil {
+		n.OnRotate(NonceRotation{Time: now, Lifetime: n.lifetime})
+	}
+
 	nonce := make([]byte, 8, nonceLength)
-	binary.BigEndian.PutUint64(nonce, uint64(time.Now().UnixMilli()))
+	binary.BigEndian.PutUint64(nonce, uint64(now.UnixMilli()))
 
-	hash := hmac.New(sha256.New, n.key)
+	hash := hmac.New(sha256.New, key)
 	if _, err := hash.Write(nonce[:8]); err != nil {
 		return "", fmt.Errorf("%w: %v", errFailedToGenerateNonce, err) //nolint:errorlint
 	}
@@ -50,22 +111,36 @@ func (n *NonceHash) Generate() (string, error) {
 
 // Validate checks that nonce is signed and is not expired
 func (n *NonceHash) Validate(nonce string) error {
+	return n.validate(nonce, time.Now())
+}
+
+func (n *NonceHash) validate(nonce string, now time.Time) error {
 	b, err := hex.DecodeString(nonce)
 	if err != nil || len(b) != nonceLength {
 		return fmt.Errorf("%w: %v", errInvalidNonce, err) //nolint:errorlint
 	}
 
-	if ts := time.UnixMilli(int64(binary.BigEndian.Uint64(b))); time.Since(ts) > nonceLifetime {
+	if ts := time.UnixMilli(int64(binary.BigEndian.Uint64(b))); now.Sub(ts) > n.lifetime {
 		return errInvalidNonce
 	}
 
-	hash := hmac.New(sha256.New, n.key)
-	if _, err = hash.Write(b[:8]); err != nil {
-		return fmt.Errorf("%w: %v", errInvalidNonce, err) //nolint:errorlint
-	}
-	if !hmac.Equal(b[8:], hash.Sum(nil)) {
-		return errInvalidNonce
+	n.lock.RLock()
+	keys := [][]byte{n.key, n.prevKey}
+	n.lock.RUnlock()
+
+	for _, key := range keys {
+		if key == nil {
+			continue
+		}
+
+		hash := hmac.New(sha256.New, key)
+		if _, err = hash.Write(b[:8]); err != nil {
+			return fmt.Errorf("%w: %v", errInvalidNonce, err) //nolint:errorlint
+		}
+		if hmac.Equal(b[8:], hash.Sum(nil)) {
+			return nil
+		}
 	}
 
-	return nil
+	return errInvalidNonce
 }
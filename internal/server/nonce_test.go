@@ -5,16 +5,68 @@ package server
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNonceHash(t *testing.T) {
 	t.Run("generated hashes validate", func(t *testing.T) {
-		h, err := NewNonceHash()
+		h, err := NewNonceHash(0)
 		assert.NoError(t, err)
 		nonce, err := h.Generate()
 		assert.NoError(t, err)
 		assert.NoError(t, h.Validate(nonce))
 	})
+
+	t.Run("nonces expire", func(t *testing.T) {
+		h, err := NewNonceHash(time.Minute)
+		require.NoError(t, err)
+
+		now := time.Now()
+		nonce, err := h.generate(now)
+		require.NoError(t, err)
+		assert.NoError(t, h.validate(nonce, now.Add(59*time.Second)))
+		assert.ErrorIs(t, h.validate(nonce, now.Add(61*time.Second)), errInvalidNonce)
+	})
+
+	t.Run("key rotation", func(t *testing.T) {
+		h, err := NewNonceHash(time.Minute)
+		require.NoError(t, err)
+		rotations := []NonceRotation{}
+		h.OnRotate = func(r NonceRotation) { rotations = append(rotations, r) }
+
+		now := time.Now()
+		old, err := h.generate(now.Add(30 * time.Second))
+		require.NoError(t, err)
+		assert.Empty(t, rotations)
+
+		// Rotated once, the old key is still accepted
+		_, err = h.generate(now.Add(90 * time.Second))
+		require.NoError(t, err)
+		assert.NoError(t, h.validate(old, now.Add(89*time.Second)))
+
+		// Rotated twice, the old key is gone
+		_, err = h.generate(now.Add(150 * time.Second))
+		require.NoError(t, err)
+		assert.ErrorIs(t, h.validate(old, now.Add(89*time.Second)), errInvalidNonce)
+
+		assert.Equal(t, []NonceRotation{
+			{Time: now.Add(90 * time.Second), Lifetime: time.Minute},
+			{Time: now.Add(150 * time.Second), Lifetime: time.Minute},
+		}, rotations)
+	})
+
+	t.Run("forged", func(t *testing.T) {
+		h, err := NewNonceHash(0)
+		require.NoError(t, err)
+		other, err := NewNonceHash(0)
+		require.NoError(t, err)
+
+		nonce, err := other.Generate()
+		require.NoError(t, err)
+		assert.ErrorIs(t, h.Validate(nonce), errInvalidNonce)
+		assert.ErrorIs(t, h.Validate("00"), errInvalidNonce)
+	})
 }
@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"net"
+
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/policy"
+)
+
+// allowedByPolicy returns true if r.Policy allows in. Requests are denied if the policy
+// fails to evaluate
+func allowedByPolicy(r Request, in policy.Input) bool {
+	if r.Policy == nil {
+		return true
+	}
+
+	allowed, err := r.Policy.Evaluate(in)
+	if err != nil {
+		r.Log.Warnf("Failed to evaluate policy for %s from %s: %v", in.Action, r.SrcAddr, err)
+		return false
+	}
+
+	return allowed
+}
+
+// allowedPeer returns true if the session policy of a and r.Policy allow action for peer
+func allowedPeer(r Request, a *allocation.Allocation, action policy.Action, peer net.Addr) bool {
+	if !a.SessionPolicy().AllowsPeer(peer) {
+		return false
+	}
+
+	return allowedByPolicy(r, peerPolicyInput(r, a, action, peer))
+}
+
+// peerPolicyInput is the policy.Input of a request of the client of a for peer
+func peerPolicyInput(r Request, a *allocation.Allocation, action policy.Action, peer net.Addr) policy.Input {
+	return policy.Input{
+		Action:     action,
+		Username:   a.Username(),
+		Realm:      r.realm(a.Username()),
+		Listener:   a.Listener,
+		ClientAddr: r.SrcAddr,
+		PeerAddr:   peer,
+	}
+}
@@ -0,0 +1,254 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/counter"
+	"github.com/pion/turn/v3/internal/ipnet"
+)
+
+const (
+	rateLimiterSweepInterval = time.Minute
+	rateLimiterSyncInterval  = 100 * time.Millisecond
+)
+
+// RateLimiterConfig configures a RateLimiter
+type RateLimiterConfig struct {
+	// Rate is the number of requests per second a source IP may send on average
+	Rate float64
+	// Burst is the number of requests a source IP may send at once
+	Burst int
+	// AllRequests limits all requests except Binding requests, instead of only Allocate
+	// requests
+	AllRequests bool
+	// Drop drops requests over the limit instead of answering them with a 508
+	// (Insufficient Capacity) error
+	Drop bool
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// sharedWindow is a window of requests of an IP counted in the shared counters
+type sharedWindow struct {
+	count   int64 // Requests across the cluster as of the last sync, plus the local ones since
+	pending int64 // Local requests not yet added to the counters
+	end     time.Time
+}
+
+// RateLimiter limits the requests per source IP with a token bucket. Requests are limited
+// before they are authenticated, so scanners can't make the server churn through nonces
+// and AuthHandler calls.
+//
+// With a counter.Store shared by a cluster the requests are counted in fixed windows of
+// Burst/Rate seconds instead, of which each allows Burst requests across the cluster.
+// Requests are decided on the counts of the last sync, the store is never called by
+// Allow: the local counts are added to the store in the background every 100ms. If the
+// store fails requests are limited by the local token bucket until a sync succeeds.
+type RateLimiter struct {
+	config   RateLimiterConfig
+	counters counter.Store
+
+	lock         sync.Mutex
+	buckets      map[string]*tokenBucket
+	lastSweep    time.Time
+	windows      map[string]*sharedWindow
+	lastSync     time.Time
+	syncing      bool
+	sharedFailed bool
+}
+
+// NewRateLimiter creates a RateLimiter, counters may be nil
+func NewRateLimiter(config RateLimiterConfig, counters counter.Store) *RateLimiter {
+	if counters != nil {
+		if _, ok := counters.(*CircuitBreaker); !ok {
+			counters = NewCircuitBreaker(counters)
+		}
+	}
+
+	return &RateLimiter{
+		config:    config,
+		counters:  counters,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+		windows:   map[string]*sharedWindow{},
+	}
+}
+
+// Limits returns true if requests of method are rate limited
+func (l *RateLimiter) Limits(method stun.Method) bool {
+	if l == nil {
+		return false
+	}
+
+	return method == stun.MethodAllocate || (l.config.AllRequests && method != stun.MethodBinding)
+}
+
+// Allow takes a token from the bucket of the IP of srcAddr and returns false if it is empty
+func (l *RateLimiter) Allow(srcAddr net.Addr) bool {
+	if l == nil {
+		return true
+	}
+
+	now := time.Now()
+	allowed, ok := l.allowShared(srcAddr, now)
+	l.startSync(now)
+	if ok {
+		return allowed
+	}
+
+	return l.allow(srcAddr, now)
+}
+
+// window returns the length of the shared windows, 0 if there are no counters
+func (l *RateLimiter) window() time.Duration {
+	if l.counters == nil {
+		return 0
+	}
+
+	return time.Duration(float64(l.config.Burst) / l.config.Rate * float64(time.Second))
+}
+
+// allowShared counts the request in the window of now, ok is false if there are no
+// counters or the last sync with them failed
+func (l *RateLimiter) allowShared(srcAddr net.Addr, now time.Time) (allowed, ok bool) {
+	window := l.window()
+	if window <= 0 {
+		return false, false
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	index := now.UnixNano() / int64(window)
+	key := fmt.Sprintf("rate/%s/%d", ipnet.FingerprintAddr(srcAddr), index)
+	w, found := l.windows[key]
+	if !found {
+		w = &sharedWindow{end: time.Unix(0, (index+1)*int64(window))}
+		l.windows[key] = w
+	}
+	w.count++
+	w.pending++
+
+	return w.count <= int64(l.config.Burst), !l.sharedFailed
+}
+
+// startSync syncs the shared windows in the background if the last sync is
+// rateLimiterSyncInterval ago and has completed
+func (l *RateLimiter) startSync(now time.Time) {
+	if l.window() <= 0 {
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.syncing || now.Sub(l.lastSync) < rateLimiterSyncInterval {
+		return
+	}
+	l.syncing = true
+	l.lastSync = now
+
+	go l.sync(now)
+}
+
+// sync adds the pending requests of the shared windows to the counters and updates the
+// windows with the counts of the cluster. Windows that ended before now are removed
+func (l *RateLimiter) sync(now time.Time) {
+	window := l.window()
+
+	l.lock.Lock()
+	pending := map[string]int64{}
+	for key, w := range l.windows {
+		if w.pending != 0 {
+			pending[key] = w.pending
+			w.pending = 0
+		}
+	}
+	l.lock.Unlock()
+
+	var err error
+	for key, delta := range pending {
+		var count int64
+		if count, err = l.counters.Add(key, delta, window); err != nil {
+			break
+		}
+		delete(pending, key)
+
+		l.lock.Lock()
+		if w, ok := l.windows[key]; ok {
+			w.count = count + w.pending
+		}
+		l.lock.Unlock()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	// Requests that failed to sync are retried with the next sync
+	for key, delta := range pending {
+		if w, ok := l.windows[key]; ok {
+			w.pending += delta
+		}
+	}
+	for key, w := range l.windows {
+		if !now.Before(w.end) {
+			delete(l.windows, key)
+		}
+	}
+	l.sharedFailed = err != nil
+	l.syncing = false
+}
+
+func (l *RateLimiter) allow(srcAddr net.Addr, now time.Time) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.lastSweep) >= rateLimiterSweepInterval {
+		l.sweep(now)
+	}
+
+	key := ipnet.FingerprintAddr(srcAddr)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.config.Burst), last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = l.refill(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+func (l *RateLimiter) refill(b *tokenBucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.config.Rate
+	if burst := float64(l.config.Burst); tokens > burst {
+		return burst
+	}
+
+	return tokens
+}
+
+// sweep removes the buckets that are full again
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= float64(l.config.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/counter"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingCounters struct {
+	counter.Store
+}
+
+func (failingCounters) Add(string, int64, time.Duration) (int64, error) {
+	return 0, counter.ErrClosed
+}
+
+// blockingCounters block until release is closed
+type blockingCounters struct {
+	counter.Store
+	release chan struct{}
+}
+
+func (c blockingCounters) Add(string, int64, time.Duration) (int64, error) {
+	<-c.release
+	return 0, counter.ErrClosed
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		var l *RateLimiter
+		assert.False(t, l.Limits(stun.MethodAllocate))
+		assert.True(t, l.Allow(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}))
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		l := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 1}, nil)
+		assert.True(t, l.Limits(stun.MethodAllocate))
+		assert.False(t, l.Limits(stun.MethodRefresh))
+
+		l = NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 1, AllRequests: true}, nil)
+		assert.True(t, l.Limits(stun.MethodRefresh))
+		assert.False(t, l.Limits(stun.MethodBinding))
+	})
+
+	t.Run("TokenBucket", func(t *testing.T) {
+		l := NewRateLimiter(RateLimiterConfig{Rate: 2, Burst: 3}, nil)
+		now := time.Now()
+
+		// Ports of the same IP share a bucket
+		for i := 0; i < 3; i++ {
+			assert.True(t, l.allow(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000 + i}, now))
+		}
+		assert.False(t, l.allow(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 6000}, now))
+		assert.True(t, l.allow(&net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000}, now))
+
+		// Tokens are refilled at Rate
+		now = now.Add(500 * time.Millisecond)
+		assert.True(t, l.allow(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, now))
+		assert.False(t, l.allow(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}, now))
+
+		// Full buckets are swept
+		now = now.Add(rateLimiterSweepInterval)
+		assert.True(t, l.allow(&net.UDPAddr{IP: net.ParseIP("10.0.0.3"), Port: 5000}, now))
+		assert.Len(t, l.buckets, 1)
+	})
+	t.Run("SharedCounters", func(t *testing.T) {
+		counters := counter.NewMemory()
+		addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+		now := time.Unix(100, 0)
+
+		// Requests are decided on the local count until it is synced
+		a := NewRateLimiter(RateLimiterConfig{Rate: 2, Burst: 3}, counters)
+		b := NewRateLimiter(RateLimiterConfig{Rate: 2, Burst: 3}, counters)
+		for i := 0; i < 3; i++ {
+			allowed, ok := a.allowShared(addr, now)
+			assert.True(t, ok)
+			assert.True(t, allowed)
+		}
+		a.sync(now)
+		allowed, ok := b.allowShared(addr, now)
+		assert.True(t, ok)
+		assert.True(t, allowed)
+
+		// Servers sharing the counters share the limit once synced
+		b.sync(now)
+		allowed, ok = b.allowShared(addr, now)
+		assert.True(t, ok)
+		assert.False(t, allowed)
+		a.sync(now)
+		allowed, _ = a.allowShared(addr, now)
+		assert.False(t, allowed)
+
+		// Every window of Burst/Rate allows Burst requests, ended windows are removed
+		allowed, _ = a.allowShared(addr, now.Add(1500*time.Millisecond))
+		assert.True(t, allowed)
+		a.sync(now.Add(1500 * time.Millisecond))
+		assert.Len(t, a.windows, 1)
+
+		// Failing counters fall back to the token bucket
+		l := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 1}, failingCounters{})
+		_, ok = l.allowShared(addr, now)
+		assert.True(t, ok)
+		l.sync(now)
+		_, ok = l.allowShared(addr, now)
+		assert.False(t, ok)
+		assert.True(t, l.Allow(addr))
+		assert.False(t, l.Allow(addr))
+	})
+
+	t.Run("NonBlocking", func(t *testing.T) {
+		counters := blockingCounters{release: make(chan struct{})}
+		defer close(counters.release)
+
+		// Allow doesn't wait for the counters
+		l := NewRateLimiter(RateLimiterConfig{Rate: 1, Burst: 2}, counters)
+		addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+		assert.True(t, l.Allow(addr))
+		assert.True(t, l.Allow(addr))
+		assert.False(t, l.Allow(addr))
+	})
+}
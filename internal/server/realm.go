@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import "strings"
+
+// RealmConfig selects the realm of the usernames ending with UsernameSuffix
+type RealmConfig struct {
+	// UsernameSuffix is matched against the end of the USERNAME, e.g. "@example.com"
+	UsernameSuffix string
+	// Realm is the realm of the matching usernames
+	Realm string
+}
+
+// realmOf returns the realm of username and true if a RealmConfig selects it, or the
+// realm of the listener and false otherwise. The longest matching suffix wins
+func (r Request) realmOf(username string) (string, bool) {
+	realm, suffixLen := r.Realm, -1
+	for _, c := range r.Realms {
+		if len(c.UsernameSuffix) > suffixLen && strings.HasSuffix(username, c.UsernameSuffix) {
+			realm, suffixLen = c.Realm, len(c.UsernameSuffix)
+		}
+	}
+
+	return realm, suffixLen >= 0
+}
+
+// realm returns the realm of username
+func (r Request) realm(username string) string {
+	realm, _ := r.realmOf(username)
+	return realm
+}
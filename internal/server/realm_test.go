@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRealmOf(t *testing.T) {
+	r := Request{
+		Realm: "pion.ly",
+		Realms: []RealmConfig{
+			{UsernameSuffix: "@example.com", Realm: "example.com"},
+			{UsernameSuffix: ".eu@example.com", Realm: "eu.example.com"},
+		},
+	}
+
+	for _, c := range []struct {
+		username string
+		realm    string
+		selected bool
+	}{
+		{"alice", "pion.ly", false},
+		{"bob@example.com", "example.com", true},
+		{"carol.eu@example.com", "eu.example.com", true},
+		{"dave@example.org", "pion.ly", false},
+	} {
+		realm, selected := r.realmOf(c.username)
+		assert.Equal(t, c.realm, realm, c.username)
+		assert.Equal(t, c.selected, selected, c.username)
+	}
+}
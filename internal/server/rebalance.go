@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// Rebalance holds the allocations selected to move to other servers of a fleet. The next
+// Refresh of every selected allocation is answered with a 300 (Try Alternate) pointing at
+// its alternate server, and the lifetimes granted to it are capped so it expires by its
+// deadline.
+type Rebalance struct {
+	lock    sync.Mutex
+	pending map[string]*rebalanced
+}
+
+type rebalanced struct {
+	alternateServer *stun.AlternateServer
+	deadline        time.Time
+	redirected      bool
+}
+
+// NewRebalance creates a Rebalance with no allocation selected
+func NewRebalance() *Rebalance {
+	return &Rebalance{
+		pending: map[string]*rebalanced{},
+	}
+}
+
+// Add selects the allocation identified by fiveTuple to move to alternateServer by deadline.
+// alternateServer may be nil
+func (r *Rebalance) Add(fiveTuple *allocation.FiveTuple, alternateServer *stun.AlternateServer, deadline time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.pending[fiveTuple.Fingerprint()] = &rebalanced{alternateServer: alternateServer, deadline: deadline}
+}
+
+// Remove deselects the allocation with the five-tuple fingerprint, it returns false if it
+// wasn't selected
+func (r *Rebalance) Remove(fingerprint string) bool {
+	if r == nil {
+		return false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, ok := r.pending[fingerprint]
+	delete(r.pending, fingerprint)
+	return ok
+}
+
+// Retain deselects the allocations whose five-tuple fingerprint isn't in fingerprints,
+// e.g. because they expired
+func (r *Rebalance) Retain(fingerprints map[string]struct{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for fingerprint := range r.pending {
+		if _, ok := fingerprints[fingerprint]; !ok {
+			delete(r.pending, fingerprint)
+		}
+	}
+}
+
+// Len returns the number of selected allocations
+func (r *Rebalance) Len() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return len(r.pending)
+}
+
+// RedirectRefresh reports whether the Refresh for the allocation identified by fiveTuple
+// should be answered with ALTERNATE-SERVER. Every allocation is only redirected once
+func (r *Rebalance) RedirectRefresh(fiveTuple *allocation.FiveTuple) (*stun.AlternateServer, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	p, ok := r.pending[fiveTuple.Fingerprint()]
+	if !ok || p.redirected || p.alternateServer == nil {
+		return nil, false
+	}
+	p.redirected = true
+
+	return p.alternateServer, true
+}
+
+// CapLifetime shortens lifetime so that the allocation identified by fiveTuple expires by
+// its deadline. Once the deadline passed the returned lifetime is 0
+func (r *Rebalance) CapLifetime(fiveTuple *allocation.FiveTuple, lifetime time.Duration) time.Duration {
+	if r == nil {
+		return lifetime
+	}
+
+	r.lock.Lock()
+	p, ok := r.pending[fiveTuple.Fingerprint()]
+	r.lock.Unlock()
+	if !ok {
+		return lifetime
+	}
+
+	return capLifetime(p.deadline, lifetime)
+}
+
+// capLifetime shortens lifetime so that it does not extend past deadline
+func capLifetime(deadline time.Time, lifetime time.Duration) time.Duration {
+	remaining := time.Until(deadline)
+	switch {
+	case remaining <= 0:
+		return 0
+	case remaining < lifetime:
+		// LIFETIME is encoded in seconds, round up so we never grant 0 by accident
+		return remaining.Truncate(time.Second) + time.Second
+	default:
+		return lifetime
+	}
+}
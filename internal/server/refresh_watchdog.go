@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v3/internal/allocation"
+)
+
+// RefreshStorm describes an allocation or username that sent more Refresh requests
+// within a window than allowed
+type RefreshStorm struct {
+	// SrcAddr is the client address of the allocation that sent the Refresh that
+	// crossed the limit
+	SrcAddr net.Addr
+	// Username is the USERNAME of that Refresh request
+	Username string
+	// PerUsername is set if the limit for all allocations of Username was crossed,
+	// instead of the limit for the single allocation
+	PerUsername bool
+	// Refreshes is the number of Refresh requests seen in the current window
+	Refreshes int
+	// Window is the duration Refresh requests are counted over
+	Window time.Duration
+	// Throttled is set if Refresh requests over the limit are dropped
+	Throttled bool
+}
+
+// RefreshWatchdogConfig configures a RefreshWatchdog
+type RefreshWatchdogConfig struct {
+	// Window is the duration Refresh requests are counted over
+	Window time.Duration
+	// MaxRefreshes is the number of Refresh requests a single allocation may send per
+	// Window. Disabled if 0
+	MaxRefreshes int
+	// MaxRefreshesPerUsername is the number of Refresh requests all allocations of a
+	// username may send per Window. Disabled if 0
+	MaxRefreshesPerUsername int
+	// RateLimit drops Refresh requests over the limits instead of only reporting them
+	RateLimit bool
+	// OnRefreshStorm is called once per Window for every allocation or username that
+	// crossed a limit. It is called from the read loop and must not block
+	OnRefreshStorm func(RefreshStorm)
+}
+
+type refreshCounter struct {
+	start    time.Time
+	count    int
+	reported bool
+}
+
+// RefreshWatchdog counts the Refresh requests of every allocation and username in fixed
+// windows. Clients that refresh far more often than the lifetime of their allocation
+// requires are reported once per window and, if RateLimit is set, throttled
+type RefreshWatchdog struct {
+	config RefreshWatchdogConfig
+
+	lock        sync.Mutex
+	allocations map[string]*refreshCounter
+	usernames   map[string]*refreshCounter
+	lastSweep   time.Time
+}
+
+// NewRefreshWatchdog creates a RefreshWatchdog
+func NewRefreshWatchdog(config RefreshWatchdogConfig) *RefreshWatchdog {
+	return &RefreshWatchdog{
+		config:      config,
+		allocations: map[string]*refreshCounter{},
+		usernames:   map[string]*refreshCounter{},
+		lastSweep:   time.Now(),
+	}
+}
+
+// Observe records a Refresh request from the allocation identified by fiveTuple and
+// returns true if the request should be dropped
+func (w *RefreshWatchdog) Observe(fiveTuple *allocation.FiveTuple, username string) bool {
+	if w == nil {
+		return false
+	}
+
+	now := time.Now()
+	storms := []RefreshStorm{}
+
+	w.lock.Lock()
+	if now.Sub(w.lastSweep) >= w.config.Window {
+		w.sweep(now)
+	}
+
+	throttle := false
+	if refreshes, exceeded, report := w.count(w.allocations, fiveTuple.Fingerprint(), w.config.MaxRefreshes, now); exceeded {
+		throttle = w.config.RateLimit
+		if report {
+			storms = append(storms, RefreshStorm{SrcAddr: fiveTuple.SrcAddr, Username: username, Refreshes: refreshes})
+		}
+	}
+	if username != "" {
+		if refreshes, exceeded, report := w.count(w.usernames, username, w.config.MaxRefreshesPerUsername, now); exceeded {
+			throttle = throttle || w.config.RateLimit
+			if report {
+				storms = append(storms, RefreshStorm{SrcAddr: fiveTuple.SrcAddr, Username: username, PerUsername: true, Refreshes: refreshes})
+			}
+		}
+	}
+	w.lock.Unlock()
+
+	if w.config.OnRefreshStorm != nil {
+		for _, storm := range storms {
+			storm.Window = w.config.Window
+			storm.Throttled = w.config.RateLimit
+			w.config.OnRefreshStorm(storm)
+		}
+	}
+
+	return throttle
+}
+
+// count increments the counter for key and returns the number of refreshes in the
+// current window, whether limit is exceeded and whether that has to be reported
+func (w *RefreshWatchdog) count(counters map[string]*refreshCounter, key string, limit int, now time.Time) (int, bool, bool) {
+	if limit <= 0 {
+		return 0, false, false
+	}
+
+	c, ok := counters[key]
+	if !ok || now.Sub(c.start) >= w.config.Window {
+		c = &refreshCounter{start: now}
+		counters[key] = c
+	}
+	c.count++
+
+	if c.count <= limit {
+		return c.count, false, false
+	}
+
+	report := !c.reported
+	c.reported = true
+	return c.count, true, report
+}
+
+// sweep removes the counters of windows that have ended
+func (w *RefreshWatchdog) sweep(now time.Time) {
+	for _, counters := range []map[string]*refreshCounter{w.allocations, w.usernames} {
+		for key, c := range counters {
+			if now.Sub(c.start) >= w.config.Window {
+				delete(counters, key)
+			}
+		}
+	}
+	w.lastSweep = now
+}
@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefreshWatchdog(t *testing.T) {
+	newFiveTuple := func(port int) *allocation.FiveTuple {
+		return &allocation.FiveTuple{
+			SrcAddr:  &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: port},
+			DstAddr:  &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 3478},
+			Protocol: allocation.UDP,
+		}
+	}
+
+	t.Run("Nil", func(t *testing.T) {
+		var w *RefreshWatchdog
+		assert.False(t, w.Observe(newFiveTuple(5000), "user"))
+	})
+
+	t.Run("PerAllocation", func(t *testing.T) {
+		storms := []RefreshStorm{}
+		w := NewRefreshWatchdog(RefreshWatchdogConfig{
+			Window:         time.Hour,
+			MaxRefreshes:   3,
+			OnRefreshStorm: func(s RefreshStorm) { storms = append(storms, s) },
+		})
+
+		for i := 0; i < 5; i++ {
+			assert.False(t, w.Observe(newFiveTuple(5000), "user"))
+		}
+		assert.False(t, w.Observe(newFiveTuple(5001), "user"))
+
+		assert.Equal(t, []RefreshStorm{{
+			SrcAddr:   newFiveTuple(5000).SrcAddr,
+			Username:  "user",
+			Refreshes: 4,
+			Window:    time.Hour,
+		}}, storms)
+	})
+
+	t.Run("PerUsername", func(t *testing.T) {
+		storms := []RefreshStorm{}
+		w := NewRefreshWatchdog(RefreshWatchdogConfig{
+			Window:                  time.Hour,
+			MaxRefreshesPerUsername: 2,
+			RateLimit:               true,
+			OnRefreshStorm:          func(s RefreshStorm) { storms = append(storms, s) },
+		})
+
+		assert.False(t, w.Observe(newFiveTuple(5000), "user"))
+		assert.False(t, w.Observe(newFiveTuple(5001), "user"))
+		assert.True(t, w.Observe(newFiveTuple(5002), "user"))
+		assert.True(t, w.Observe(newFiveTuple(5003), "user"))
+		assert.False(t, w.Observe(newFiveTuple(5004), "other"))
+
+		assert.Len(t, storms, 1)
+		assert.True(t, storms[0].PerUsername)
+		assert.True(t, storms[0].Throttled)
+		assert.Equal(t, 3, storms[0].Refreshes)
+	})
+
+	t.Run("WindowEnds", func(t *testing.T) {
+		w := NewRefreshWatchdog(RefreshWatchdogConfig{
+			Window:       50 * time.Millisecond,
+			MaxRefreshes: 1,
+			RateLimit:    true,
+		})
+
+		assert.False(t, w.Observe(newFiveTuple(5000), ""))
+		assert.True(t, w.Observe(newFiveTuple(5000), ""))
+
+		time.Sleep(100 * time.Millisecond)
+		assert.False(t, w.Observe(newFiveTuple(5000), ""))
+	})
+}
@@ -5,6 +5,7 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
@@ -13,6 +14,9 @@ import (
 	"github.com/pion/stun/v2"
 	"github.com/pion/turn/v3/internal/allocation"
 	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/pion/turn/v3/policy"
+	"github.com/pion/turn/v3/tracing"
 )
 
 // Request contains all the state needed to process a single incoming datagram
@@ -22,30 +26,112 @@ type Request struct {
 	SrcAddr net.Addr
 	Buff    []byte
 
+	// DetachConn is set if Conn frames a stream based connection. It stops the
+	// framing and returns the connection along with data that was already read
+	// from it, so a ConnectionBind can turn it into a data connection.
+	DetachConn func() (net.Conn, []byte)
+
+	// Context is passed to AuthHandlerV2, it is cancelled when the server is closed
+	Context context.Context
+
 	// Server State
 	AllocationManager *allocation.Manager
-	NonceHash         *NonceHash
+	Nonces            NonceGenerator
+	ChallengeCache    *ChallengeCache
+	CredentialCache   *CredentialCache
+	Maintenance       *Maintenance
+	Rebalance         *Rebalance
+	RefreshWatchdog   *RefreshWatchdog
+	StaleNonces       *StaleNonceWatchdog
+	RateLimiter       *RateLimiter
+	BandwidthLimits   *BandwidthLimits
+	Metrics           *metrics.Metrics
+	Tracer            tracing.Tracer
+	Events            *allocation.Events
+	Anomalies         *AnomalyCounter
+
+	// CredentialExpiry rejects Refresh requests with expired credentials. Credentials
+	// accepted by AuthHandler are valid for the whole session if nil
+	CredentialExpiry *CredentialExpiryPolicy
 
 	// User Configuration
 	AuthHandler        func(username string, realm string, srcAddr net.Addr) (key []byte, ok bool)
+	AuthHandlerV2      AuthHandlerV2
 	Log                logging.LeveledLogger
 	Realm              string
+	Realms             []RealmConfig
 	ChannelBindTimeout time.Duration
+
+	// AccessTokenHandler validates RFC 7635 ACCESS-TOKENs and returns their mac_key
+	AccessTokenHandler func(kid string, realm string, token []byte, srcAddr net.Addr) (macKey []byte, ok bool)
+	// ThirdPartyAuthorization is the server name sent in 401 (Unauthorized) responses to
+	// advertise third-party authorization
+	ThirdPartyAuthorization string
+
+	// AlternateServer returns the address of the server an Allocate request is
+	// redirected to with a 300 (Try Alternate), and false to handle it
+	AlternateServer func(username, realm string, srcAddr net.Addr) (net.Addr, bool)
+
+	// Passthrough receives the datagrams that are neither STUN nor ChannelData, they are
+	// dropped if nil
+	Passthrough func(conn net.PacketConn, srcAddr net.Addr, data []byte)
+
+	// AllocationQuota returns false if an Allocate request exceeds the allocation quota
+	// of the username, it is rejected with a 486 (Allocation Quota Reached). quota
+	// overrides the configured quota if not 0
+	AllocationQuota func(username, realm string, srcAddr net.Addr, quota int) bool
+
+	// Policy decides whether Allocate, CreatePermission, ChannelBind and Connect requests
+	// are allowed, they are rejected with a 403 (Forbidden) otherwise
+	Policy policy.Evaluator
+
+	// AffinityToken is added to 401 (Unauthorized), Allocate and Refresh responses as
+	// AFFINITY-TOKEN, identifying this server to load balancers
+	AffinityToken proto.AffinityToken
+
+	// RelayIdentity is added to Allocate responses as RELAY-IDENTITY, identifying the
+	// region, point of presence and node of this server to clients
+	RelayIdentity proto.RelayIdentity
+
+	// Mobility enables RFC 8016 MOBILITY-TICKET, allowing clients to keep allocations
+	// when their 5-tuple changes
+	Mobility bool
+
+	// PermissionCoalesceWindow is how long a repeated CreatePermission request for the
+	// identical peer set only refreshes the permissions. Disabled if 0
+	PermissionCoalesceWindow time.Duration
+
+	// AuthenticateBinding challenges Binding requests like Allocate requests and answers
+	// only authenticated ones, Binding requests are unauthenticated otherwise
+	AuthenticateBinding bool
 }
 
 // HandleRequest processes the give Request
 func HandleRequest(r Request) error {
-	r.Log.Debugf("Received %d bytes of udp from %s on %s", len(r.Buff), r.SrcAddr.String(), r.Conn.LocalAddr().String())
+	// Other protocols sharing the socket aren't inspected for anomalies
+	if r.Passthrough != nil && !proto.IsChannelData(r.Buff) && !stun.IsMessage(r.Buff) {
+		r.Passthrough(r.Conn, r.SrcAddr, r.Buff)
+		return nil
+	}
+
+	if r.Anomalies != nil {
+		for _, anomaly := range r.Anomalies.Inspect(r.Buff) {
+			r.Log.Debugf("Received datagram with %s from %s", anomaly, r.SrcAddr)
+		}
+	}
 
+	// ChannelData is relayed without logging, formatting the log arguments allocates for
+	// every packet even if they aren't logged
 	if proto.IsChannelData(r.Buff) {
 		return handleDataPacket(r)
 	}
 
+	r.Log.Debugf("Received %d bytes of udp from %s on %s", len(r.Buff), r.SrcAddr, r.Conn.LocalAddr())
+
 	return handleTURNPacket(r)
 }
 
 func handleDataPacket(r Request) error {
-	r.Log.Debugf("Received DataPacket from %s", r.SrcAddr.String())
 	c := proto.ChannelData{Raw: r.Buff}
 	if err := c.Decode(); err != nil {
 		return fmt.Errorf("%w: %v", errFailedToCreateChannelData, err) //nolint:errorlint
@@ -71,6 +157,19 @@ func handleTURNPacket(r Request) error {
 		return fmt.Errorf("%w %v-%v from %v: %v", errUnhandledSTUNPacket, m.Type.Method, m.Type.Class, r.SrcAddr, err) //nolint:errorlint
 	}
 
+	r, endSpan := traceRequest(r, m)
+	defer endSpan()
+
+	if m.Type.Class == stun.ClassRequest && r.RateLimiter.Limits(m.Type.Method) && !r.RateLimiter.Allow(r.SrcAddr) {
+		if r.RateLimiter.config.Drop {
+			r.Log.Debugf("Dropping %v from %v, rate limit exceeded", m.Type, r.SrcAddr)
+			return nil
+		}
+
+		msg := buildMsg(m.TransactionID, stun.NewType(m.Type.Method, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeInsufficientCapacity})
+		return buildAndSendErr(r.Conn, r.SrcAddr, errRateLimitExceeded, msg...)
+	}
+
 	err = h(r, m)
 	if err != nil {
 		return fmt.Errorf("%w %v-%v from %v: %v", errFailedToHandle, m.Type.Method, m.Type.Class, r.SrcAddr, err) //nolint:errorlint
@@ -101,6 +200,10 @@ func getMessageHandler(class stun.MessageClass, method stun.Method) (func(r Requ
 			return handleChannelBindRequest, nil
 		case stun.MethodBinding:
 			return handleBindingRequest, nil
+		case stun.MethodConnect:
+			return handleConnectRequest, nil
+		case stun.MethodConnectionBind:
+			return handleConnectionBindRequest, nil
 		default:
 			return nil, fmt.Errorf("%w: %s", errUnexpectedMethod, method)
 		}
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// StaleNonceStorm describes a window in which more requests than allowed were rejected
+// with a 438 (Stale Nonce) error
+type StaleNonceStorm struct {
+	// StaleNonces is the number of 438 (Stale Nonce) responses in the current window
+	StaleNonces int
+	// Clients is the number of 438 (Stale Nonce) responses per client address in the
+	// current window. After a rotation every client gets one or two, clients that get
+	// many keep sending nonces that aren't accepted
+	Clients map[string]int
+	// Window is the duration 438 (Stale Nonce) responses are counted over
+	Window time.Duration
+	// LastRotation is when the nonce key was last rotated, zero if it wasn't rotated
+	// yet or the server uses a NonceGenerator
+	LastRotation time.Time
+}
+
+// StaleNonceWatchdogConfig configures a StaleNonceWatchdog
+type StaleNonceWatchdogConfig struct {
+	// Window is the duration 438 (Stale Nonce) responses are counted over
+	Window time.Duration
+	// MaxStaleNonces is the number of 438 (Stale Nonce) responses per Window that are
+	// expected, e.g. after a rotation
+	MaxStaleNonces int
+	// OnStaleNonceStorm is called once per Window in which MaxStaleNonces was crossed. It
+	// is called from the read loop and must not block
+	OnStaleNonceStorm func(StaleNonceStorm)
+}
+
+// StaleNonceWatchdog counts the 438 (Stale Nonce) responses of the server in fixed
+// windows and reports the windows with more than expected, with the count of every
+// client, so routine rotations can be told apart from clients that fail to pick up
+// new nonces
+type StaleNonceWatchdog struct {
+	config StaleNonceWatchdogConfig
+
+	lock         sync.Mutex
+	start        time.Time
+	count        int
+	clients      map[string]int
+	reported     bool
+	lastRotation time.Time
+}
+
+// NewStaleNonceWatchdog creates a StaleNonceWatchdog
+func NewStaleNonceWatchdog(config StaleNonceWatchdogConfig) *StaleNonceWatchdog {
+	return &StaleNonceWatchdog{
+		config:  config,
+		start:   time.Now(),
+		clients: map[string]int{},
+	}
+}
+
+// Observe records a 438 (Stale Nonce) response to srcAddr
+func (w *StaleNonceWatchdog) Observe(srcAddr net.Addr) {
+	if w == nil {
+		return
+	}
+
+	now := time.Now()
+
+	w.lock.Lock()
+	if now.Sub(w.start) >= w.config.Window {
+		w.start, w.count, w.clients, w.reported = now, 0, map[string]int{}, false
+	}
+	w.count++
+	w.clients[srcAddr.String()]++
+
+	var storm *StaleNonceStorm
+	if w.count > w.config.MaxStaleNonces && !w.reported {
+		w.reported = true
+		storm = &StaleNonceStorm{
+			StaleNonces:  w.count,
+			Clients:      make(map[string]int, len(w.clients)),
+			Window:       w.config.Window,
+			LastRotation: w.lastRotation,
+		}
+		for client, count := range w.clients {
+			storm.Clients[client] = count
+		}
+	}
+	w.lock.Unlock()
+
+	if storm != nil && w.config.OnStaleNonceStorm != nil {
+		w.config.OnStaleNonceStorm(*storm)
+	}
+}
+
+// Rotated records a rotation of the nonce key
+func (w *StaleNonceWatchdog) Rotated(rotation NonceRotation) {
+	if w == nil {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.lastRotation = rotation.Time
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaleNonceWatchdog(t *testing.T) {
+	client := func(port int) net.Addr {
+		return &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: port}
+	}
+
+	t.Run("Nil", func(t *testing.T) {
+		var w *StaleNonceWatchdog
+		w.Observe(client(5000))
+		w.Rotated(NonceRotation{Time: time.Now()})
+	})
+
+	t.Run("Storm", func(t *testing.T) {
+		storms := []StaleNonceStorm{}
+		w := NewStaleNonceWatchdog(StaleNonceWatchdogConfig{
+			Window:            time.Hour,
+			MaxStaleNonces:    3,
+			OnStaleNonceStorm: func(s StaleNonceStorm) { storms = append(storms, s) },
+		})
+
+		rotated := time.Now()
+		w.Rotated(NonceRotation{Time: rotated, Lifetime: time.Hour})
+
+		w.Observe(client(5000))
+		w.Observe(client(5001))
+		w.Observe(client(5001))
+		assert.Empty(t, storms)
+
+		// Reported once per window
+		w.Observe(client(5001))
+		w.Observe(client(5002))
+		assert.Equal(t, []StaleNonceStorm{{
+			StaleNonces:  4,
+			Clients:      map[string]int{"10.0.0.1:5000": 1, "10.0.0.1:5001": 3},
+			Window:       time.Hour,
+			LastRotation: rotated,
+		}}, storms)
+	})
+
+	t.Run("Window", func(t *testing.T) {
+		storms := 0
+		w := NewStaleNonceWatchdog(StaleNonceWatchdogConfig{
+			Window:            time.Hour,
+			MaxStaleNonces:    1,
+			OnStaleNonceStorm: func(StaleNonceStorm) { storms++ },
+		})
+
+		w.Observe(client(5000))
+		w.Observe(client(5000))
+		assert.Equal(t, 1, storms)
+
+		// A new window starts counting again
+		w.start = w.start.Add(-time.Hour)
+		w.Observe(client(5000))
+		assert.Equal(t, 1, storms)
+		w.Observe(client(5000))
+		assert.Equal(t, 2, storms)
+	})
+}
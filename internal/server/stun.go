@@ -16,10 +16,19 @@ func handleBindingRequest(r Request, m *stun.Message) error {
 		return err
 	}
 
-	attrs := buildMsg(m.TransactionID, stun.BindingSuccess, &stun.XORMappedAddress{
+	setters := []stun.Setter{&stun.XORMappedAddress{
 		IP:   ip,
 		Port: port,
-	}, stun.Fingerprint)
+	}}
+	if r.AuthenticateBinding {
+		messageIntegrity, hasAuth, authErr := authenticateRequest(r, m, stun.MethodBinding)
+		if !hasAuth {
+			return authErr
+		}
+		setters = append(setters, messageIntegrity)
+	}
+
+	attrs := buildMsg(m.TransactionID, stun.BindingSuccess, append(setters, stun.Fingerprint)...)
 
 	return buildAndSend(r.Conn, r.SrcAddr, attrs...)
 }
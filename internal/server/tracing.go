@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/tracing"
+)
+
+// traceRequest starts a span for the request m if its method is traced. The returned
+// Request records the result code of the response sent for m on the span, which is
+// ended by the returned func
+func traceRequest(r Request, m *stun.Message) (Request, func()) {
+	if r.Tracer == nil || m.Type.Class != stun.ClassRequest || !tracing.Traced(m.Type.Method) {
+		return r, func() {}
+	}
+
+	_, span := r.Tracer.Start(context.Background(), tracing.SpanName("server", m.Type.Method))
+	tracing.SetRequestAttributes(span, m)
+	span.SetAttribute(tracing.AttributePeerAddr, r.SrcAddr.String())
+
+	r.Conn = &tracingConn{PacketConn: r.Conn, span: span, transactionID: m.TransactionID}
+	return r, span.End
+}
+
+// tracingConn carries the span of a request to buildAndSend, which sets the result code
+// of the response on it. It only lives as long as the request, allocations are given the
+// listener socket, see turnSocket
+type tracingConn struct {
+	net.PacketConn
+	span          tracing.Span
+	transactionID [stun.TransactionIDSize]byte
+}
+
+// record sets the result code of m on the span if m is the response to the request
+func (c *tracingConn) record(m *stun.Message) {
+	if m.TransactionID == c.transactionID {
+		tracing.SetResultAttributes(c.span, m)
+	}
+}
+
+// turnSocket returns the listener socket of conn, unwrapping the tracingConn of a traced
+// request, so allocations don't relay through it and keep its span
+func turnSocket(conn net.PacketConn) net.PacketConn {
+	if c, ok := conn.(*tracingConn); ok {
+		return c.PacketConn
+	}
+
+	return conn
+}
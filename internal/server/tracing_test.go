@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/tracing"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSpan map[string]interface{}
+
+func (s testSpan) SetAttribute(key string, value interface{}) { s[key] = value }
+
+func (s testSpan) End() {}
+
+type testTracer struct {
+	span testSpan
+}
+
+func (t *testTracer) Start(ctx context.Context, _ string) (context.Context, tracing.Span) {
+	t.span = testSpan{}
+	return ctx, t.span
+}
+
+func TestTracedAllocate(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	nonce, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	key := []byte("key")
+	tracer := &testTracer{}
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		Realm:             "pion.ly",
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return key, true
+		},
+		Tracer: tracer,
+	}
+
+	m, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest),
+		proto.RequestedTransport{Protocol: proto.ProtoUDP}, stun.Nonce(nonce), stun.Realm("pion.ly"),
+		stun.Username("user"), stun.MessageIntegrity(key))
+	assert.NoError(t, err)
+
+	tracedRequest, endSpan := traceRequest(r, m)
+	assert.NoError(t, handleAllocateRequest(tracedRequest, m))
+	endSpan()
+
+	// The result code is recorded on the span of the request
+	assert.Equal(t, "Allocate", tracer.span[tracing.AttributeMethod])
+	assert.Equal(t, 0, tracer.span[tracing.AttributeResultCode])
+
+	// The allocation relays through the listener socket, not the tracing wrapper
+	a := allocationManager.GetAllocation(&allocation.FiveTuple{
+		SrcAddr:  clientConn.LocalAddr(),
+		DstAddr:  l.LocalAddr(),
+		Protocol: allocation.UDP,
+	})
+	if !assert.NotNil(t, a) {
+		return
+	}
+	_, ok := a.TurnSocket.(*tracingConn)
+	assert.False(t, ok)
+	assert.Equal(t, l, a.TurnSocket)
+}
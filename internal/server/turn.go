@@ -4,14 +4,18 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/pion/randutil"
 	"github.com/pion/stun/v2"
 	"github.com/pion/turn/v3/internal/allocation"
 	"github.com/pion/turn/v3/internal/ipnet"
 	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/pion/turn/v3/policy"
 )
 
 const runesAlpha = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
@@ -25,7 +29,7 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 	//    mechanism of [https://tools.ietf.org/html/rfc5389#section-10.2.2]
 	//    unless the client and server agree to use another mechanism through
 	//    some procedure outside the scope of this document.
-	messageIntegrity, hasAuth, err := authenticateRequest(r, m, stun.MethodAllocate)
+	messageIntegrity, sessionPolicy, hasAuth, err := authenticate(r, m, stun.MethodAllocate)
 	if !hasAuth {
 		return err
 	}
@@ -40,6 +44,7 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 
 	badRequestMsg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeBadRequest})
 	insufficientCapacityMsg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeInsufficientCapacity})
+	addressFamilyNotSupportedMsg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeAddrFamilyNotSupported})
 
 	// 2. The server checks if the 5-tuple is currently in use by an
 	//    existing allocation.  If yes, the server rejects the request with
@@ -50,11 +55,25 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeAllocMismatch})
 			return buildAndSendErr(r.Conn, r.SrcAddr, errRelayAlreadyAllocatedForFiveTuple, msg...)
 		}
-		// A retry allocation
+		// A retry allocation. RFC 8016 Section 3.1: every success response carries a new
+		// MOBILITY-TICKET
+		attrs, err = reissueMobilityTicket(r, alloc, attrs)
+		if err != nil {
+			return buildAndSendErr(r.Conn, r.SrcAddr, err, insufficientCapacityMsg...)
+		}
+		alloc.SetResponseCache(m.TransactionID, attrs)
 		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse), append(attrs, messageIntegrity)...)
 		return buildAndSend(r.Conn, r.SrcAddr, msg...)
 	}
 
+	// RFC 8016 Section 3.1: a client requests mobility with an empty MOBILITY-TICKET.
+	// Without mobility support the request is rejected with a 405 (Mobility Forbidden).
+	requestMobility := m.Contains(proto.AttrMobilityTicket)
+	if requestMobility && !r.Mobility {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: proto.CodeMobilityForbidden})
+		return buildAndSendErr(r.Conn, r.SrcAddr, errMobilityForbidden, msg...)
+	}
+
 	// 3. The server checks if the request contains a REQUESTED-TRANSPORT
 	//    attribute.  If the REQUESTED-TRANSPORT attribute is not included
 	//    or is malformed, the server rejects the request with a 400 (Bad
@@ -69,14 +88,33 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 		return buildAndSendErr(r.Conn, r.SrcAddr, errUnsupportedTransportProtocol, msg...)
 	}
 
+	// RFC 6062 Section 5.1: TCP allocations have to be requested over TCP or TLS and
+	// can't be combined with EVEN-PORT or RESERVATION-TOKEN.
+	tcpAllocation := requestedTransport.Protocol == proto.ProtoTCP
+	if tcpAllocation {
+		switch {
+		case !r.AllocationManager.TCPAllocationsEnabled():
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeUnsupportedTransProto})
+			return buildAndSendErr(r.Conn, r.SrcAddr, errTCPAllocationsDisabled, msg...)
+		case r.DetachConn == nil:
+			return buildAndSendErr(r.Conn, r.SrcAddr, errTCPAllocationOverUDP, badRequestMsg...)
+		case m.Contains(stun.AttrEvenPort) || m.Contains(stun.AttrReservationToken):
+			return buildAndSendErr(r.Conn, r.SrcAddr, errTCPAllocationWithEvenPort, badRequestMsg...)
+		}
+	}
+
 	// 4. The request may contain a DONT-FRAGMENT attribute.  If it does,
 	//    but the server does not support sending UDP datagrams with the DF
 	//    bit set to 1 (see Section 12), then the server treats the DONT-
 	//    FRAGMENT attribute in the Allocate request as an unknown
 	//    comprehension-required attribute.
-	if m.Contains(stun.AttrDontFragment) {
-		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeUnknownAttribute}, &stun.UnknownAttributes{stun.AttrDontFragment})
-		return buildAndSendErr(r.Conn, r.SrcAddr, errNoDontFragmentSupport, msg...)
+	//    Whether the DF bit can be set depends on the relay socket, so it is
+	//    checked once the allocation is created. TCP allocations don't relay
+	//    datagrams.
+	dontFragment := m.Contains(stun.AttrDontFragment)
+	dontFragmentUnsupportedMsg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeUnknownAttribute}, &stun.UnknownAttributes{stun.AttrDontFragment})
+	if dontFragment && tcpAllocation {
+		return buildAndSendErr(r.Conn, r.SrcAddr, errNoDontFragmentSupport, dontFragmentUnsupportedMsg...)
 	}
 
 	// 5.  The server checks if the request contains a RESERVATION-TOKEN
@@ -95,6 +133,44 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 		}
 	}
 
+	// RFC 6156 Section 4.2: the REQUESTED-ADDRESS-FAMILY attribute selects the address
+	// family of the relayed transport address, IPv4 if it is absent. A request with both
+	// REQUESTED-ADDRESS-FAMILY and RESERVATION-TOKEN is rejected with a 400 (Bad Request)
+	// error, an unknown or unsupported family with a 440 (Address Family not Supported) error.
+	requestedFamily := proto.RequestedFamilyIPv4
+	if m.Contains(stun.AttrRequestedAddressFamily) {
+		if m.Contains(stun.AttrReservationToken) {
+			return buildAndSendErr(r.Conn, r.SrcAddr, errRequestWithReservationTokenAndFamily, badRequestMsg...)
+		}
+
+		if err = requestedFamily.GetFrom(m); err != nil {
+			if stun.IsAttrSizeInvalid(err) {
+				return buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+			}
+			return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w: %s", errUnsupportedAddressFamily, err.Error()), addressFamilyNotSupportedMsg...)
+		}
+	}
+
+	// RFC 8656 Section 7.2: the ADDITIONAL-ADDRESS-FAMILY attribute requests an IPv6
+	// relayed transport address in addition to the IPv4 one. A request that also contains
+	// REQUESTED-ADDRESS-FAMILY or RESERVATION-TOKEN, or another family than IPv6, is
+	// rejected with a 400 (Bad Request) error.
+	dualStack := m.Contains(proto.AttrAdditionalAddressFamily)
+	if dualStack {
+		var additionalFamily proto.AdditionalAddressFamily
+		err = additionalFamily.GetFrom(m)
+		switch {
+		case tcpAllocation || m.Contains(stun.AttrRequestedAddressFamily) || m.Contains(stun.AttrReservationToken):
+			return buildAndSendErr(r.Conn, r.SrcAddr, errInvalidAdditionalAddressFamily, badRequestMsg...)
+		case stun.IsAttrSizeInvalid(err):
+			return buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+		case err != nil:
+			return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w: %s", errUnsupportedAddressFamily, err.Error()), addressFamilyNotSupportedMsg...)
+		case additionalFamily != proto.AdditionalAddressFamily(proto.RequestedFamilyIPv6):
+			return buildAndSendErr(r.Conn, r.SrcAddr, errInvalidAdditionalAddressFamily, badRequestMsg...)
+		}
+	}
+
 	// 6. The server checks if the request contains an EVEN-PORT attribute.
 	//    If yes, then the server checks that it can satisfy the request
 	//    (i.e., can allocate a relayed transport address as described
@@ -104,8 +180,10 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 	var evenPort proto.EvenPort
 	if err = evenPort.GetFrom(m); err == nil {
 		var randomPort int
-		randomPort, err = r.AllocationManager.GetRandomEvenPort()
-		if err != nil {
+		randomPort, err = r.AllocationManager.GetRandomEvenPort(requestedFamily)
+		if errors.Is(err, allocation.ErrAddressFamilyNotSupported) {
+			return buildAndSendErr(r.Conn, r.SrcAddr, err, addressFamilyNotSupportedMsg...)
+		} else if err != nil {
 			return buildAndSendErr(r.Conn, r.SrcAddr, err, insufficientCapacityMsg...)
 		}
 		requestedPort = randomPort
@@ -121,21 +199,100 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 	//    server is free to define this allocation quota any way it wishes,
 	//    but SHOULD define it based on the username used to authenticate
 	//    the request, and not on the client's transport address.
+	var username stun.Username
+	_ = username.GetFrom(m)
+	realm := r.realm(username.String())
+	if r.AllocationQuota != nil && !r.AllocationQuota(username.String(), realm, r.SrcAddr, sessionPolicy.Quota) {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeAllocQuotaReached}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w for %q", errAllocationQuotaReached, username.String()), msg...)
+	}
+
+	if !allowedByPolicy(r, policy.Input{Action: policy.ActionAllocate, Username: username.String(), Realm: realm, Listener: r.AllocationManager.Listener(), ClientAddr: r.SrcAddr}) {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeForbidden}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("Allocate %w for %q", errDeniedByPolicy, username.String()), msg...)
+	}
 
 	// 8. Also at any point, the server MAY choose to reject the request
 	//    with a 300 (Try Alternate) error if it wishes to redirect the
 	//    client to a different server.  The use of this error code and
 	//    attribute follow the specification in [RFC5389].
-	lifetimeDuration := allocationLifeTime(m)
-	a, err := r.AllocationManager.CreateAllocation(
-		fiveTuple,
-		r.Conn,
-		requestedPort,
-		lifetimeDuration)
-	if err != nil {
+	if alternateServer, ok := r.Maintenance.AlternateServer(); ok {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeTryAlternate}, alternateServer, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, errServerInMaintenance, msg...)
+	}
+
+	if r.AlternateServer != nil {
+		if alternateAddr, ok := r.AlternateServer(username.String(), realm, r.SrcAddr); ok {
+			ip, port, addrErr := ipnet.AddrIPPort(alternateAddr)
+			if addrErr != nil {
+				return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w: %s", errInvalidAlternateServer, addrErr.Error()), buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeServerError})...)
+			}
+
+			r.Log.Debugf("Redirecting Allocate from %s to %s", r.SrcAddr, alternateAddr)
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeTryAlternate}, &stun.AlternateServer{IP: ip, Port: port}, messageIntegrity)
+			return buildAndSend(r.Conn, r.SrcAddr, msg...)
+		}
+	}
+
+	lifetimeDuration := r.Maintenance.CapLifetime(sessionPolicy.CapLifetime(allocationLifeTime(m)))
+	if lifetimeDuration == 0 {
+		return buildAndSendErr(r.Conn, r.SrcAddr, errServerInMaintenance, insufficientCapacityMsg...)
+	}
+
+	var (
+		a             *allocation.Allocation
+		additionalErr error
+	)
+	switch {
+	case tcpAllocation:
+		a, err = r.AllocationManager.CreateTCPAllocation(
+			fiveTuple,
+			turnSocket(r.Conn),
+			requestedFamily,
+			lifetimeDuration)
+	case dualStack:
+		a, additionalErr, err = r.AllocationManager.CreateDualStackAllocation(
+			fiveTuple,
+			turnSocket(r.Conn),
+			requestedPort,
+			lifetimeDuration)
+	default:
+		a, err = r.AllocationManager.CreateAllocation(
+			fiveTuple,
+			turnSocket(r.Conn),
+			requestedFamily,
+			requestedPort,
+			lifetimeDuration)
+	}
+	if errors.Is(err, allocation.ErrAddressFamilyNotSupported) {
+		return buildAndSendErr(r.Conn, r.SrcAddr, err, addressFamilyNotSupportedMsg...)
+	} else if err != nil {
 		return buildAndSendErr(r.Conn, r.SrcAddr, err, insufficientCapacityMsg...)
 	}
 
+	a.SetCredentials(username.String(), messageIntegrity.Key())
+	a.SetSessionPolicy(sessionPolicy)
+	r.Events.AllocationCreated(a)
+
+	if dontFragment {
+		if err = a.EnableDontFragment(); err != nil {
+			r.AllocationManager.DeleteAllocation(fiveTuple)
+			return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w: %s", errNoDontFragmentSupport, err.Error()), dontFragmentUnsupportedMsg...)
+		}
+	}
+
+	r.BandwidthLimits.Apply(a, username.String(), realm, r.SrcAddr, sessionPolicy.BytesPerSecond)
+
+	if sessionPolicy.MaxPacketSize != 0 {
+		a.SetMaxPacketSize(sessionPolicy.MaxPacketSize)
+	}
+
+	if sessionPolicy.DSCP != 0 {
+		if err = a.SetDSCP(sessionPolicy.DSCP); err != nil {
+			r.Log.Warnf("Failed to set DSCP %d on relay %v: %v", sessionPolicy.DSCP, a.RelayAddr, err)
+		}
+	}
+
 	// Once the allocation is created, the server replies with a success
 	// response.
 	// The success response contains:
@@ -170,6 +327,26 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 			IP:   srcIP,
 			Port: srcPort,
 		},
+		r.AffinityToken,
+		r.RelayIdentity,
+	}
+
+	// RFC 8656 Section 7.2: a dual-stack allocation has a second XOR-RELAYED-ADDRESS. If
+	// only the IPv4 one could be allocated, an ADDRESS-ERROR-CODE tells the client why.
+	switch {
+	case a.AdditionalRelayAddr != nil:
+		additionalIP, additionalPort, addrErr := ipnet.AddrIPPort(a.AdditionalRelayAddr)
+		if addrErr != nil {
+			return buildAndSendErr(r.Conn, r.SrcAddr, addrErr, badRequestMsg...)
+		}
+		responseAttrs = append(responseAttrs, &proto.RelayedAddress{IP: additionalIP, Port: additionalPort})
+	case additionalErr != nil:
+		r.Log.Debugf("Failed to allocate additional IPv6 relay for %v: %v", r.SrcAddr, additionalErr)
+		code := stun.CodeInsufficientCapacity
+		if errors.Is(additionalErr, allocation.ErrAddressFamilyNotSupported) {
+			code = stun.CodeAddrFamilyNotSupported
+		}
+		responseAttrs = append(responseAttrs, proto.AddressErrorCode{Family: proto.RequestedFamilyIPv6, Code: code})
 	}
 
 	if reservationToken != "" {
@@ -177,6 +354,14 @@ func handleAllocateRequest(r Request, m *stun.Message) error {
 		responseAttrs = append(responseAttrs, proto.ReservationToken([]byte(reservationToken)))
 	}
 
+	if requestMobility {
+		ticket, ticketErr := r.AllocationManager.CreateMobilityTicket(a)
+		if ticketErr != nil {
+			return buildAndSendErr(r.Conn, r.SrcAddr, ticketErr, insufficientCapacityMsg...)
+		}
+		responseAttrs = append(responseAttrs, ticket)
+	}
+
 	msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse), append(responseAttrs, messageIntegrity)...)
 	a.SetResponseCache(m.TransactionID, responseAttrs)
 	return buildAndSend(r.Conn, r.SrcAddr, msg...)
@@ -197,89 +382,255 @@ func handleRefreshRequest(r Request, m *stun.Message) error {
 		Protocol: allocation.UDP,
 	}
 
+	var ticket proto.MobilityTicket
+	hasTicket := ticket.GetFrom(m) == nil
+	if hasTicket && !r.Mobility {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: proto.CodeMobilityForbidden}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, errMobilityForbidden, msg...)
+	}
+
+	var username stun.Username
+	_ = username.GetFrom(m)
+
+	responseAttrs := []stun.Setter{r.AffinityToken}
 	if lifetimeDuration != 0 {
 		a := r.AllocationManager.GetAllocation(fiveTuple)
 
+		// RFC 8016 Section 3.3: a client whose 5-tuple changed refreshes from its new
+		// address with the MOBILITY-TICKET of the allocation, which is moved to the new
+		// 5-tuple. Success responses carry a new ticket.
+		if a == nil && len(ticket) != 0 {
+			if a, err = r.AllocationManager.MoveAllocation(ticket, fiveTuple, turnSocket(r.Conn), username.String()); errors.Is(err, allocation.ErrMobilityTicketInvalid) {
+				msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeBadRequest}, messageIntegrity)
+				return buildAndSendErr(r.Conn, r.SrcAddr, err, msg...)
+			} else if err == nil {
+				r.Log.Debugf("Moved allocation to %s with MOBILITY-TICKET", r.SrcAddr.String())
+			}
+		}
+
+		// A standby restores the allocation it replicated from the failed server, for the
+		// username that owned it only
 		if a == nil {
-			return fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr())
+			if a, err = getAllocation(r, username.String()); err != nil {
+				msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeWrongCredentials})
+				return buildAndSendErr(r.Conn, r.SrcAddr, err, msg...)
+			}
 		}
-		a.Refresh(lifetimeDuration)
-	} else {
+
+		if a == nil {
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeAllocMismatch}, messageIntegrity)
+			return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr()), msg...)
+		}
+
+		if hasTicket {
+			if !r.AllocationManager.HasMobilityTicket(a, ticket) {
+				msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeBadRequest}, messageIntegrity)
+				return buildAndSendErr(r.Conn, r.SrcAddr, allocation.ErrMobilityTicketInvalid, msg...)
+			}
+		}
+
+		// RFC 6156 Section 4.3: a REQUESTED-ADDRESS-FAMILY that doesn't match the family
+		// of the allocation, or of its additional relay, is rejected with a 443 (Peer
+		// Address Family Mismatch) error.
+		var requestedFamily proto.RequestedAddressFamily
+		if err = requestedFamily.GetFrom(m); err == nil && requestedFamily != a.AddressFamily &&
+			(a.AdditionalRelaySocket == nil || requestedFamily != proto.RequestedFamilyIPv6) {
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodePeerAddrFamilyMismatch}, messageIntegrity)
+			return buildAndSendErr(r.Conn, r.SrcAddr, errPeerAddressFamilyMismatch, msg...)
+		}
+
+		// After re-authentication was required, e.g. because the credential was revoked,
+		// the allocation is only refreshed with a key other than the one it holds. A
+		// rejected allocation is deleted.
+		if !reauthenticate(r, a, messageIntegrity) {
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeWrongCredentials})
+			return buildAndSendErr(r.Conn, r.SrcAddr, errReauthenticationRequired, msg...)
+		}
+
+		// The operator may require credentials to stay valid for the whole session instead
+		// of only when the allocation is created. The client is challenged for new ones.
+		if r.CredentialExpiry.Expired(username.String(), time.Now()) {
+			nonce, nonceErr := r.Nonces.Generate()
+			if nonceErr != nil {
+				return nonceErr
+			}
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeUnauthorized}, stun.NewNonce(nonce), stun.NewRealm(r.realm(username.String())))
+			return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w for %q", errCredentialExpired, username.String()), msg...)
+		}
+
+		// Clients stuck in a refresh loop are reported and optionally throttled by not
+		// answering, their retransmissions back off. Deallocations are always handled.
+		if r.RefreshWatchdog.Observe(fiveTuple, username.String()) {
+			r.Log.Debugf("Dropping Refresh from %s, too many refreshes", r.SrcAddr.String())
+			return nil
+		}
+
+		// During a maintenance window point the client at the alternate server once,
+		// and never grant a lifetime that extends past the start of the window.
+		if alternateServer, ok := r.Maintenance.RedirectRefresh(fiveTuple); ok {
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeTryAlternate}, alternateServer, messageIntegrity)
+			return buildAndSend(r.Conn, r.SrcAddr, msg...)
+		}
+
+		// Allocations selected for rebalancing are pointed at their alternate server once,
+		// and expire by the deadline of the rebalancing.
+		if alternateServer, ok := r.Rebalance.RedirectRefresh(fiveTuple); ok {
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeTryAlternate}, alternateServer, messageIntegrity)
+			return buildAndSend(r.Conn, r.SrcAddr, msg...)
+		}
+		lifetimeDuration = r.Rebalance.CapLifetime(fiveTuple, lifetimeDuration)
+
+		if lifetimeDuration = r.Maintenance.CapLifetime(a.SessionPolicy().CapLifetime(lifetimeDuration)); lifetimeDuration != 0 {
+			if hasTicket {
+				renewed, renewErr := r.AllocationManager.RenewMobilityTicket(a, ticket)
+				if renewErr != nil {
+					msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeBadRequest}, messageIntegrity)
+					return buildAndSendErr(r.Conn, r.SrcAddr, renewErr, msg...)
+				}
+				responseAttrs = append(responseAttrs, renewed)
+			}
+
+			a.Refresh(lifetimeDuration)
+			r.Events.AllocationRefreshed(a, lifetimeDuration)
+		}
+	}
+
+	if lifetimeDuration == 0 {
 		r.AllocationManager.DeleteAllocation(fiveTuple)
+		r.Rebalance.Remove(fiveTuple.Fingerprint())
 	}
 
-	return buildAndSend(r.Conn, r.SrcAddr, buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassSuccessResponse), []stun.Setter{
+	return buildAndSend(r.Conn, r.SrcAddr, buildMsg(m.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassSuccessResponse), append([]stun.Setter{
 		&proto.Lifetime{
 			Duration: lifetimeDuration,
 		},
-		messageIntegrity,
-	}...)...)
+	}, append(responseAttrs, messageIntegrity)...)...)...)
 }
 
 func handleCreatePermissionRequest(r Request, m *stun.Message) error {
 	r.Log.Debugf("Received CreatePermission from %s", r.SrcAddr.String())
 
-	a := r.AllocationManager.GetAllocation(&allocation.FiveTuple{
-		SrcAddr:  r.SrcAddr,
-		DstAddr:  r.Conn.LocalAddr(),
-		Protocol: allocation.UDP,
-	})
-	if a == nil {
-		return fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr())
-	}
-
 	messageIntegrity, hasAuth, err := authenticateRequest(r, m, stun.MethodCreatePermission)
 	if !hasAuth {
 		return err
 	}
 
-	addCount := 0
+	var username stun.Username
+	_ = username.GetFrom(m)
+
+	a, err := getAllocation(r, username.String())
+	if err != nil {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodCreatePermission, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeWrongCredentials})
+		return buildAndSendErr(r.Conn, r.SrcAddr, err, msg...)
+	} else if a == nil {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodCreatePermission, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeAllocMismatch}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr()), msg...)
+	} else if !reauthenticate(r, a, messageIntegrity) {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodCreatePermission, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeWrongCredentials})
+		return buildAndSendErr(r.Conn, r.SrcAddr, errReauthenticationRequired, msg...)
+	}
 
+	successMsg := buildMsg(m.TransactionID, stun.NewType(stun.MethodCreatePermission, stun.ClassSuccessResponse), []stun.Setter{messageIntegrity}...)
+
+	peers := []net.Addr{}
 	if err := m.ForEach(stun.AttrXORPeerAddress, func(m *stun.Message) error {
 		var peerAddress proto.PeerAddress
 		if err := peerAddress.GetFrom(m); err != nil {
 			return err
 		}
 
-		if err := r.AllocationManager.GrantPermission(r.SrcAddr, peerAddress.IP); err != nil {
+		peers = append(peers, &net.UDPAddr{
+			IP:   peerAddress.IP,
+			Port: peerAddress.Port,
+		})
+		return nil
+	}); err != nil {
+		peers = nil
+	}
+
+	// Clients that repeat the same CreatePermission request within the coalesce window
+	// only get their permissions refreshed, without consulting the PermissionHandler and
+	// Policy or installing them again
+	if len(peers) != 0 && r.PermissionCoalesceWindow > 0 && a.RefreshPermissions(peers, r.PermissionCoalesceWindow) {
+		r.Log.Tracef("Refreshed permissions of %s for duplicate CreatePermission", r.SrcAddr.String())
+		return buildAndSend(r.Conn, r.SrcAddr, successMsg...)
+	}
+
+	addCount := 0
+	errorCode := stun.CodeBadRequest
+
+	for _, peer := range peers {
+		peerIP := peer.(*net.UDPAddr).IP //nolint:forcetypeassert
+
+		if !a.MatchesAddressFamily(peerIP) {
+			errorCode = stun.CodePeerAddrFamilyMismatch
+			addCount = 0
+			break
+		}
+
+		if !r.AllocationManager.PermitsPeerPort(peer.(*net.UDPAddr).Port) { //nolint:forcetypeassert
+			r.Log.Infof("permission denied for client %s to peer %s: port not allowed", r.SrcAddr.String(),
+				peer.String())
+			errorCode = stun.CodeForbidden
+			addCount = 0
+			break
+		}
+
+		if err := r.AllocationManager.GrantPermission(r.SrcAddr, peerIP); err != nil {
 			r.Log.Infof("permission denied for client %s to peer %s", r.SrcAddr.String(),
-				peerAddress.IP.String())
-			return err
+				peerIP.String())
+			errorCode = stun.CodeForbidden
+			addCount = 0
+			break
+		}
+
+		if !allowedPeer(r, a, policy.ActionPermission, peer) {
+			r.Log.Infof("permission denied by policy for client %s to peer %s", r.SrcAddr.String(),
+				peer.String())
+			errorCode = stun.CodeForbidden
+			addCount = 0
+			break
+		}
+
+		if err := a.ActivateRelay(); err != nil {
+			r.Log.Warnf("Failed to bind relay socket of %s: %s", r.SrcAddr.String(), err)
+			errorCode = stun.CodeInsufficientCapacity
+			addCount = 0
+			break
 		}
 
-		r.Log.Debugf("Adding permission for %s", fmt.Sprintf("%s:%d",
-			peerAddress.IP.String(), peerAddress.Port))
+		r.Log.Debugf("Adding permission for %s", peer.String())
 
-		a.AddPermission(allocation.NewPermission(
-			&net.UDPAddr{
-				IP:   peerAddress.IP,
-				Port: peerAddress.Port,
-			},
-			r.Log,
-		))
+		created := a.GetPermission(peer) == nil
+		a.AddPermission(allocation.NewPermission(peer, r.Log))
+		if created {
+			r.Events.PermissionCreated(a, peer)
+		}
 		addCount++
-		return nil
-	}); err != nil {
-		addCount = 0
 	}
 
-	respClass := stun.ClassSuccessResponse
 	if addCount == 0 {
-		respClass = stun.ClassErrorResponse
+		return buildAndSend(r.Conn, r.SrcAddr, buildMsg(m.TransactionID, stun.NewType(stun.MethodCreatePermission, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: errorCode}, messageIntegrity)...)
 	}
 
-	return buildAndSend(r.Conn, r.SrcAddr, buildMsg(m.TransactionID, stun.NewType(stun.MethodCreatePermission, respClass), []stun.Setter{messageIntegrity}...)...)
+	if r.PermissionCoalesceWindow > 0 {
+		a.SetLastPermissions(peers)
+	}
+
+	return buildAndSend(r.Conn, r.SrcAddr, successMsg...)
 }
 
 func handleSendIndication(r Request, m *stun.Message) error {
 	r.Log.Debugf("Received SendIndication from %s", r.SrcAddr.String())
-	a := r.AllocationManager.GetAllocation(&allocation.FiveTuple{
-		SrcAddr:  r.SrcAddr,
-		DstAddr:  r.Conn.LocalAddr(),
-		Protocol: allocation.UDP,
-	})
+	a, _ := getAllocation(r, "")
 	if a == nil {
 		return fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr())
+	} else if a.Protocol != allocation.UDP {
+		return errNotUDPAllocation
+	} else if a.ReauthenticationRequired() {
+		// Indications aren't authenticated, data is relayed again once a request of the
+		// client presented a new key
+		return fmt.Errorf("%w: dropped Send indication", errReauthenticationRequired)
 	}
 
 	dataAttr := proto.Data{}
@@ -293,13 +644,32 @@ func handleSendIndication(r Request, m *stun.Message) error {
 	}
 
 	msgDst := &net.UDPAddr{IP: peerAddress.IP, Port: peerAddress.Port}
-	if perm := a.GetPermission(msgDst); perm == nil {
+	if !a.MatchesAddressFamily(peerAddress.IP) {
+		return fmt.Errorf("%w: %v", errPeerAddressFamilyMismatch, msgDst)
+	} else if perm := a.GetPermission(msgDst); perm == nil {
 		return fmt.Errorf("%w: %v", errNoPermission, msgDst)
+	} else if !r.AllocationManager.PermitsPeerPort(msgDst.Port) {
+		return fmt.Errorf("%w: %v", errPeerPortNotAllowed, msgDst)
+	} else if !a.AllowPacketSize(len(dataAttr), metrics.DirectionToPeer) || !a.AllowRelay(len(dataAttr)) {
+		return nil
 	}
 
-	l, err := a.RelaySocket.WriteTo(dataAttr, msgDst)
+	// RFC 5766 Section 10.2: the datagram is sent with the DF bit set if the Send
+	// indication contains DONT-FRAGMENT. It is dropped if the DF bit can't be set.
+	var l int
+	var err error
+	if m.Contains(stun.AttrDontFragment) {
+		if l, err = a.WriteToDontFragment(dataAttr, msgDst); errors.Is(err, allocation.ErrDontFragmentUnsupported) {
+			return fmt.Errorf("%w: dropped Send indication to %v", errNoDontFragmentSupport, msgDst)
+		}
+	} else {
+		l, err = a.WriteToPeer(dataAttr, msgDst)
+	}
 	if l != len(dataAttr) {
 		return fmt.Errorf("%w %d != %d (expected) err: %v", errShortWrite, l, len(dataAttr), err) //nolint:errorlint
+	} else if err == nil {
+		a.CountRelayed(metrics.DirectionToPeer, l)
+		a.CountRelayedIndication(metrics.DirectionToPeer)
 	}
 	return err
 }
@@ -307,15 +677,6 @@ func handleSendIndication(r Request, m *stun.Message) error {
 func handleChannelBindRequest(r Request, m *stun.Message) error {
 	r.Log.Debugf("Received ChannelBindRequest from %s", r.SrcAddr.String())
 
-	a := r.AllocationManager.GetAllocation(&allocation.FiveTuple{
-		SrcAddr:  r.SrcAddr,
-		DstAddr:  r.Conn.LocalAddr(),
-		Protocol: allocation.UDP,
-	})
-	if a == nil {
-		return fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr())
-	}
-
 	badRequestMsg := buildMsg(m.TransactionID, stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeBadRequest})
 
 	messageIntegrity, hasAuth, err := authenticateRequest(r, m, stun.MethodChannelBind)
@@ -323,6 +684,23 @@ func handleChannelBindRequest(r Request, m *stun.Message) error {
 		return err
 	}
 
+	var username stun.Username
+	_ = username.GetFrom(m)
+
+	a, err := getAllocation(r, username.String())
+	if err != nil {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeWrongCredentials})
+		return buildAndSendErr(r.Conn, r.SrcAddr, err, msg...)
+	} else if a == nil {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeAllocMismatch}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr()), msg...)
+	} else if a.Protocol != allocation.UDP {
+		return buildAndSendErr(r.Conn, r.SrcAddr, errNotUDPAllocation, badRequestMsg...)
+	} else if !reauthenticate(r, a, messageIntegrity) {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeWrongCredentials})
+		return buildAndSendErr(r.Conn, r.SrcAddr, errReauthenticationRequired, msg...)
+	}
+
 	var channel proto.ChannelNumber
 	if err = channel.GetFrom(m); err != nil {
 		return buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
@@ -333,54 +711,98 @@ func handleChannelBindRequest(r Request, m *stun.Message) error {
 		return buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
 	}
 
+	if !a.MatchesAddressFamily(peerAddr.IP) {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodePeerAddrFamilyMismatch}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, errPeerAddressFamilyMismatch, msg...)
+	}
+
+	if !r.AllocationManager.PermitsPeerPort(peerAddr.Port) {
+		r.Log.Infof("permission denied for client %s to peer %s: port not allowed", r.SrcAddr.String(),
+			peerAddr.String())
+
+		forbiddenRequestMsg := buildMsg(m.TransactionID,
+			stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse),
+			&stun.ErrorCodeAttribute{Code: stun.CodeForbidden},
+			messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w: %s", errPeerPortNotAllowed, peerAddr), forbiddenRequestMsg...)
+	}
+
 	if err = r.AllocationManager.GrantPermission(r.SrcAddr, peerAddr.IP); err != nil {
 		r.Log.Infof("permission denied for client %s to peer %s", r.SrcAddr.String(),
 			peerAddr.IP.String())
 
-		unauthorizedRequestMsg := buildMsg(m.TransactionID,
+		forbiddenRequestMsg := buildMsg(m.TransactionID,
+			stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse),
+			&stun.ErrorCodeAttribute{Code: stun.CodeForbidden})
+		return buildAndSendErr(r.Conn, r.SrcAddr, err, forbiddenRequestMsg...)
+	}
+
+	peer := &net.UDPAddr{IP: peerAddr.IP, Port: peerAddr.Port}
+	if !allowedPeer(r, a, policy.ActionChannelBind, peer) {
+		r.Log.Infof("permission denied by policy for client %s to peer %s", r.SrcAddr.String(), peer.String())
+
+		forbiddenRequestMsg := buildMsg(m.TransactionID,
+			stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse),
+			&stun.ErrorCodeAttribute{Code: stun.CodeForbidden},
+			messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("ChannelBind %w to %s", errDeniedByPolicy, peer), forbiddenRequestMsg...)
+	}
+
+	if err = a.ActivateRelay(); err != nil {
+		insufficientCapacityMsg := buildMsg(m.TransactionID,
 			stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse),
-			&stun.ErrorCodeAttribute{Code: stun.CodeUnauthorized})
-		return buildAndSendErr(r.Conn, r.SrcAddr, err, unauthorizedRequestMsg...)
+			&stun.ErrorCodeAttribute{Code: stun.CodeInsufficientCapacity},
+			messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w: %s", errRelayNotBound, err.Error()), insufficientCapacityMsg...)
 	}
 
 	r.Log.Debugf("Binding channel %d to %s",
 		channel,
 		fmt.Sprintf("%s:%d", peerAddr.IP.String(), peerAddr.Port))
+	newChannel, newPermission := a.GetChannelByNumber(channel) == nil, a.GetPermission(peer) == nil
 	err = a.AddChannelBind(allocation.NewChannelBind(
 		channel,
-		&net.UDPAddr{IP: peerAddr.IP, Port: peerAddr.Port},
+		peer,
 		r.Log,
 	), r.ChannelBindTimeout)
 	if err != nil {
 		return buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
 	}
+	r.Metrics.ChannelBound()
+	if newPermission {
+		r.Events.PermissionCreated(a, peer)
+	}
+	if newChannel {
+		r.Events.ChannelBound(a, peer, uint16(channel))
+	}
 
 	return buildAndSend(r.Conn, r.SrcAddr, buildMsg(m.TransactionID, stun.NewType(stun.MethodChannelBind, stun.ClassSuccessResponse), []stun.Setter{messageIntegrity}...)...)
 }
 
 func handleChannelData(r Request, c *proto.ChannelData) error {
-	r.Log.Debugf("Received ChannelData from %s", r.SrcAddr.String())
-
-	a := r.AllocationManager.GetAllocation(&allocation.FiveTuple{
-		SrcAddr:  r.SrcAddr,
-		DstAddr:  r.Conn.LocalAddr(),
-		Protocol: allocation.UDP,
-	})
+	a, _ := getAllocation(r, "")
 	if a == nil {
 		return fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr())
+	} else if a.Protocol != allocation.UDP {
+		return errNotUDPAllocation
+	} else if a.ReauthenticationRequired() {
+		return fmt.Errorf("%w: dropped ChannelData", errReauthenticationRequired)
 	}
 
 	channel := a.GetChannelByNumber(c.Number)
 	if channel == nil {
 		return fmt.Errorf("%w %x", errNoSuchChannelBind, uint16(c.Number))
+	} else if !a.AllowPacketSize(len(c.Data), metrics.DirectionToPeer) || !a.AllowRelay(len(c.Data)) {
+		return nil
 	}
 
-	l, err := a.RelaySocket.WriteTo(c.Data, channel.Peer)
+	l, err := a.WriteToPeer(c.Data, channel.Peer)
 	if err != nil {
 		return fmt.Errorf("%w: %s", errFailedWriteSocket, err.Error())
 	} else if l != len(c.Data) {
 		return fmt.Errorf("%w %d != %d (expected)", errShortWrite, l, len(c.Data))
 	}
+	a.CountRelayed(metrics.DirectionToPeer, l)
 
 	return nil
 }
@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/policy"
+)
+
+// See: https://tools.ietf.org/html/rfc6062#section-5.2
+func handleConnectRequest(r Request, m *stun.Message) error {
+	r.Log.Debugf("Received ConnectRequest from %s", r.SrcAddr.String())
+
+	messageIntegrity, hasAuth, err := authenticateRequest(r, m, stun.MethodConnect)
+	if !hasAuth {
+		return err
+	}
+
+	badRequestMsg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeBadRequest}, messageIntegrity)
+
+	// If the request is received on a connection without an allocation, the server
+	// rejects it with a 437 (Allocation Mismatch) error. A Connect for a UDP
+	// allocation is rejected with a 400 (Bad Request) error.
+	a := r.AllocationManager.GetAllocation(&allocation.FiveTuple{
+		SrcAddr:  r.SrcAddr,
+		DstAddr:  r.Conn.LocalAddr(),
+		Protocol: allocation.UDP,
+	})
+	if a == nil {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeAllocMismatch}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w %v:%v", errNoAllocationFound, r.SrcAddr, r.Conn.LocalAddr()), msg...)
+	} else if a.Protocol != allocation.TCP {
+		return buildAndSendErr(r.Conn, r.SrcAddr, errNotTCPAllocation, badRequestMsg...)
+	} else if !reauthenticate(r, a, messageIntegrity) {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeWrongCredentials})
+		return buildAndSendErr(r.Conn, r.SrcAddr, errReauthenticationRequired, msg...)
+	}
+
+	var peerAddr proto.PeerAddress
+	if err = peerAddr.GetFrom(m); err != nil {
+		return buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+	}
+
+	// RFC 6156 Section 5: peers of another address family than the relayed transport
+	// address are rejected with a 443 (Peer Address Family Mismatch) error.
+	if !a.MatchesAddressFamily(peerAddr.IP) {
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodePeerAddrFamilyMismatch}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, errPeerAddressFamilyMismatch, msg...)
+	}
+
+	if !r.AllocationManager.PermitsPeerPort(peerAddr.Port) {
+		r.Log.Infof("permission denied for client %s to peer %s: port not allowed", r.SrcAddr.String(),
+			peerAddr.String())
+
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeForbidden}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w: %s", errPeerPortNotAllowed, peerAddr), msg...)
+	}
+
+	if err = r.AllocationManager.GrantPermission(r.SrcAddr, peerAddr.IP); err != nil {
+		r.Log.Infof("permission denied for client %s to peer %s", r.SrcAddr.String(),
+			peerAddr.IP.String())
+
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeForbidden}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, err, msg...)
+	}
+
+	peer := &net.TCPAddr{IP: peerAddr.IP, Port: peerAddr.Port}
+	if !allowedPeer(r, a, policy.ActionConnect, peer) {
+		r.Log.Infof("permission denied by policy for client %s to peer %s", r.SrcAddr.String(), peer.String())
+
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeForbidden}, messageIntegrity)
+		return buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("Connect %w to %s", errDeniedByPolicy, peer), msg...)
+	}
+
+	// Establishing the connection can take a while, don't block the read loop of
+	// the control connection while it does.
+	go func() {
+		cid, err := r.AllocationManager.Connect(a, peer)
+		if err != nil {
+			code := stun.CodeConnTimeoutOrFailure
+			if errors.Is(err, allocation.ErrConnectionAlreadyExists) {
+				code = stun.CodeConnAlreadyExists
+			}
+
+			msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: code}, messageIntegrity)
+			if err = buildAndSendErr(r.Conn, r.SrcAddr, err, msg...); err != nil {
+				r.Log.Infof("Connect from %s to %s failed: %v", r.SrcAddr, peerAddr, err)
+			}
+			return
+		}
+
+		r.Log.Debugf("Connected allocation %s to %s (cid=%d)", a.RelayAddr, peerAddr, cid)
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnect, stun.ClassSuccessResponse), cid, messageIntegrity)
+		if err = buildAndSend(r.Conn, r.SrcAddr, msg...); err != nil {
+			r.Log.Errorf("Failed to send Connect response to %s: %v", r.SrcAddr, err)
+		}
+	}()
+
+	return nil
+}
+
+// See: https://tools.ietf.org/html/rfc6062#section-5.4
+func handleConnectionBindRequest(r Request, m *stun.Message) error {
+	r.Log.Debugf("Received ConnectionBindRequest from %s", r.SrcAddr.String())
+
+	messageIntegrity, hasAuth, err := authenticateRequest(r, m, stun.MethodConnectionBind)
+	if !hasAuth {
+		return err
+	}
+
+	badRequestMsg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnectionBind, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeBadRequest}, messageIntegrity)
+
+	if r.DetachConn == nil {
+		return buildAndSendErr(r.Conn, r.SrcAddr, errNotStream, badRequestMsg...)
+	}
+
+	// If the CONNECTION-ID doesn't match a pending connection, the server rejects
+	// the request with a 400 (Bad Request) error.
+	var cid proto.ConnectionID
+	if err = cid.GetFrom(m); err != nil {
+		return buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+	}
+
+	c, err := r.AllocationManager.BindTCPConnection(cid)
+	if err != nil {
+		return buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+	}
+
+	// The data connection is bound with the credential of the allocation of the peer
+	// connection, which is deleted if it has to re-authenticate
+	if !reauthenticate(r, c.Allocation(), messageIntegrity) {
+		_ = c.Close()
+		msg := buildMsg(m.TransactionID, stun.NewType(stun.MethodConnectionBind, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeWrongCredentials})
+		return buildAndSendErr(r.Conn, r.SrcAddr, errReauthenticationRequired, msg...)
+	}
+
+	if err = buildAndSend(r.Conn, r.SrcAddr, buildMsg(m.TransactionID, stun.NewType(stun.MethodConnectionBind, stun.ClassSuccessResponse), messageIntegrity)...); err != nil {
+		_ = c.Close()
+		return err
+	}
+
+	// From now on the connection carries the data of the peer connection as is
+	dataConn, buffered := r.DetachConn()
+	c.Relay(dataConn, buffered)
+
+	r.Log.Debugf("Bound data connection from %s to peer %s (cid=%d)", r.SrcAddr, c.PeerAddr, cid)
+	return nil
+}
@@ -79,14 +79,14 @@ func TestAllocationLifeTime(t *testing.T) {
 		})
 		assert.NoError(t, err)
 
-		nonceHash, err := NewNonceHash()
+		nonceHash, err := NewNonceHash(0)
 		assert.NoError(t, err)
 		staticKey, err := nonceHash.Generate()
 		assert.NoError(t, err)
 
 		r := Request{
 			AllocationManager: allocationManager,
-			NonceHash:         nonceHash,
+			Nonces:            nonceHash,
 			Conn:              l,
 			SrcAddr:           &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000},
 			Log:               logger,
@@ -97,7 +97,7 @@ func TestAllocationLifeTime(t *testing.T) {
 
 		fiveTuple := &allocation.FiveTuple{SrcAddr: r.SrcAddr, DstAddr: r.Conn.LocalAddr(), Protocol: allocation.UDP}
 
-		_, err = r.AllocationManager.CreateAllocation(fiveTuple, r.Conn, 0, time.Hour)
+		_, err = r.AllocationManager.CreateAllocation(fiveTuple, r.Conn, proto.RequestedFamilyIPv4, 0, time.Hour)
 		assert.NoError(t, err)
 
 		assert.NotNil(t, r.AllocationManager.GetAllocation(fiveTuple))
@@ -113,3 +113,1062 @@ func TestAllocationLifeTime(t *testing.T) {
 		assert.Nil(t, r.AllocationManager.GetAllocation(fiveTuple))
 	})
 }
+
+func TestRefreshDuringMaintenance(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	staticKey, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	maintenance := NewMaintenance()
+	maintenance.Schedule(time.Now().Add(30*time.Second), &stun.AlternateServer{IP: net.ParseIP("10.0.0.1"), Port: 3478})
+
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Maintenance:       maintenance,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return []byte(staticKey), true
+		},
+	}
+
+	fiveTuple := &allocation.FiveTuple{SrcAddr: r.SrcAddr, DstAddr: r.Conn.LocalAddr(), Protocol: allocation.UDP}
+	_, err = r.AllocationManager.CreateAllocation(fiveTuple, r.Conn, proto.RequestedFamilyIPv4, 0, time.Hour)
+	assert.NoError(t, err)
+
+	refresh := func() *stun.Message {
+		m := &stun.Message{}
+		assert.NoError(t, (proto.Lifetime{Duration: 10 * time.Minute}).AddTo(m))
+		assert.NoError(t, (stun.MessageIntegrity(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Nonce(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Realm(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Username(staticKey)).AddTo(m))
+		assert.NoError(t, handleRefreshRequest(r, m))
+
+		buf := make([]byte, 1500)
+		n, _, readErr := clientConn.ReadFrom(buf)
+		assert.NoError(t, readErr)
+
+		res := &stun.Message{Raw: buf[:n]}
+		assert.NoError(t, res.Decode())
+		return res
+	}
+
+	// First refresh is redirected to the alternate server
+	res := refresh()
+	assert.Equal(t, stun.ClassErrorResponse, res.Type.Class)
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(res))
+	assert.Equal(t, stun.CodeTryAlternate, code.Code)
+	var alternateServer stun.AlternateServer
+	assert.NoError(t, alternateServer.GetFrom(res))
+	assert.True(t, alternateServer.IP.Equal(net.ParseIP("10.0.0.1")))
+
+	// Following refreshes succeed but the lifetime ends with the maintenance window
+	res = refresh()
+	assert.Equal(t, stun.ClassSuccessResponse, res.Type.Class)
+	var lifetime proto.Lifetime
+	assert.NoError(t, lifetime.GetFrom(res))
+	assert.LessOrEqual(t, lifetime.Duration, 30*time.Second)
+	assert.NotNil(t, r.AllocationManager.GetAllocation(fiveTuple))
+
+	// Once the window has started the allocation is released
+	maintenance.Schedule(time.Now(), nil)
+	res = refresh()
+	assert.Equal(t, stun.ClassSuccessResponse, res.Type.Class)
+	assert.NoError(t, lifetime.GetFrom(res))
+	assert.Equal(t, time.Duration(0), lifetime.Duration)
+	assert.Nil(t, r.AllocationManager.GetAllocation(fiveTuple))
+}
+
+func TestRefreshDuringRebalance(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	staticKey, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	rebalance := NewRebalance()
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Rebalance:         rebalance,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return []byte(staticKey), true
+		},
+	}
+
+	fiveTuple := &allocation.FiveTuple{SrcAddr: r.SrcAddr, DstAddr: r.Conn.LocalAddr(), Protocol: allocation.UDP}
+	_, err = r.AllocationManager.CreateAllocation(fiveTuple, r.Conn, proto.RequestedFamilyIPv4, 0, time.Hour)
+	assert.NoError(t, err)
+
+	refresh := func() *stun.Message {
+		m := &stun.Message{}
+		assert.NoError(t, (proto.Lifetime{Duration: 10 * time.Minute}).AddTo(m))
+		assert.NoError(t, (stun.MessageIntegrity(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Nonce(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Realm(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Username(staticKey)).AddTo(m))
+		assert.NoError(t, handleRefreshRequest(r, m))
+
+		buf := make([]byte, 1500)
+		n, _, readErr := clientConn.ReadFrom(buf)
+		assert.NoError(t, readErr)
+
+		res := &stun.Message{Raw: buf[:n]}
+		assert.NoError(t, res.Decode())
+		return res
+	}
+
+	// Allocations that aren't selected are refreshed as usual
+	other := &allocation.FiveTuple{SrcAddr: r.Conn.LocalAddr(), DstAddr: r.SrcAddr, Protocol: allocation.UDP}
+	rebalance.Add(other, &stun.AlternateServer{IP: net.ParseIP("10.0.0.2"), Port: 3478}, time.Now())
+	res := refresh()
+	assert.Equal(t, stun.ClassSuccessResponse, res.Type.Class)
+	var lifetime proto.Lifetime
+	assert.NoError(t, lifetime.GetFrom(res))
+	assert.Equal(t, 10*time.Minute, lifetime.Duration)
+
+	// The first refresh of the selected allocation is redirected to the alternate server
+	rebalance.Add(fiveTuple, &stun.AlternateServer{IP: net.ParseIP("10.0.0.1"), Port: 3478}, time.Now().Add(30*time.Second))
+	res = refresh()
+	assert.Equal(t, stun.ClassErrorResponse, res.Type.Class)
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(res))
+	assert.Equal(t, stun.CodeTryAlternate, code.Code)
+	var alternateServer stun.AlternateServer
+	assert.NoError(t, alternateServer.GetFrom(res))
+	assert.True(t, alternateServer.IP.Equal(net.ParseIP("10.0.0.1")))
+
+	// Following refreshes succeed but the lifetime ends with the deadline
+	res = refresh()
+	assert.Equal(t, stun.ClassSuccessResponse, res.Type.Class)
+	assert.NoError(t, lifetime.GetFrom(res))
+	assert.LessOrEqual(t, lifetime.Duration, 30*time.Second)
+	assert.NotNil(t, r.AllocationManager.GetAllocation(fiveTuple))
+
+	// Once the deadline passed the allocation is released
+	rebalance.Add(fiveTuple, nil, time.Now())
+	res = refresh()
+	assert.Equal(t, stun.ClassSuccessResponse, res.Type.Class)
+	assert.NoError(t, lifetime.GetFrom(res))
+	assert.Equal(t, time.Duration(0), lifetime.Duration)
+	assert.Nil(t, r.AllocationManager.GetAllocation(fiveTuple))
+	assert.Equal(t, 1, rebalance.Len())
+	assert.True(t, rebalance.Remove(other.Fingerprint()))
+	assert.False(t, rebalance.Remove(fiveTuple.Fingerprint()))
+}
+
+func TestCreatePermissionCoalescing(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	permissionChecks := 0
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		PermissionHandler: func(net.Addr, net.IP) bool {
+			permissionChecks++
+			return true
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	staticKey, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return []byte(staticKey), true
+		},
+		PermissionCoalesceWindow: time.Minute,
+	}
+
+	fiveTuple := &allocation.FiveTuple{SrcAddr: r.SrcAddr, DstAddr: r.Conn.LocalAddr(), Protocol: allocation.UDP}
+	_, err = r.AllocationManager.CreateAllocation(fiveTuple, r.Conn, proto.RequestedFamilyIPv4, 0, time.Hour)
+	assert.NoError(t, err)
+
+	createPermission := func(peers ...*net.UDPAddr) stun.MessageType {
+		m := &stun.Message{}
+		for _, peer := range peers {
+			assert.NoError(t, (&proto.PeerAddress{IP: peer.IP, Port: peer.Port}).AddTo(m))
+		}
+		assert.NoError(t, (stun.MessageIntegrity(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Nonce(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Realm(staticKey)).AddTo(m))
+		assert.NoError(t, (stun.Username(staticKey)).AddTo(m))
+		assert.NoError(t, handleCreatePermissionRequest(r, m))
+
+		buf := make([]byte, 1500)
+		n, _, readErr := clientConn.ReadFrom(buf)
+		assert.NoError(t, readErr)
+
+		res := &stun.Message{Raw: buf[:n]}
+		assert.NoError(t, res.Decode())
+		return res.Type
+	}
+
+	peer1 := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000}
+	peer2 := &net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 5000}
+	success := stun.NewType(stun.MethodCreatePermission, stun.ClassSuccessResponse)
+
+	assert.Equal(t, success, createPermission(peer1, peer2))
+	assert.Equal(t, 2, permissionChecks)
+
+	// The identical peer set in any order only refreshes the permissions
+	assert.Equal(t, success, createPermission(peer2, peer1))
+	assert.Equal(t, 2, permissionChecks)
+
+	// A different peer set is handled in full
+	assert.Equal(t, success, createPermission(peer1))
+	assert.Equal(t, 3, permissionChecks)
+}
+
+func TestReauthentication(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	nonce, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	key := []byte("old key")
+	refreshed := []time.Duration{}
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return key, true
+		},
+		Events: &allocation.Events{
+			OnAllocationRefreshed: func(info allocation.Info, lifetime time.Duration) {
+				assert.Equal(t, "user", info.Username)
+				refreshed = append(refreshed, lifetime)
+			},
+		},
+	}
+
+	fiveTuple := &allocation.FiveTuple{SrcAddr: r.SrcAddr, DstAddr: r.Conn.LocalAddr(), Protocol: allocation.UDP}
+	allocate := func() {
+		a, allocateErr := r.AllocationManager.CreateAllocation(fiveTuple, r.Conn, proto.RequestedFamilyIPv4, 0, time.Hour)
+		assert.NoError(t, allocateErr)
+		a.SetCredentials("user", key)
+	}
+	allocate()
+
+	request := func(handle func(Request, *stun.Message) error, setters ...stun.Setter) stun.ErrorCode {
+		m, buildErr := stun.Build(append(append([]stun.Setter{stun.TransactionID}, setters...),
+			stun.Nonce(nonce), stun.Realm("pion.ly"), stun.Username("user"), stun.MessageIntegrity(key))...)
+		assert.NoError(t, buildErr)
+		_ = handle(r, m)
+
+		buf := make([]byte, 1500)
+		n, _, readErr := clientConn.ReadFrom(buf)
+		assert.NoError(t, readErr)
+
+		res := &stun.Message{Raw: buf[:n]}
+		assert.NoError(t, res.Decode())
+		if res.Type.Class == stun.ClassSuccessResponse {
+			return 0
+		}
+
+		var code stun.ErrorCodeAttribute
+		assert.NoError(t, code.GetFrom(res))
+		return code.Code
+	}
+	refresh := func() stun.ErrorCode {
+		return request(handleRefreshRequest, stun.NewType(stun.MethodRefresh, stun.ClassRequest), proto.Lifetime{Duration: 10 * time.Minute})
+	}
+	peer := proto.PeerAddress{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	createPermission := func() stun.ErrorCode {
+		return request(handleCreatePermissionRequest, stun.NewType(stun.MethodCreatePermission, stun.ClassRequest), peer)
+	}
+	channelBind := func() stun.ErrorCode {
+		return request(handleChannelBindRequest, stun.NewType(stun.MethodChannelBind, stun.ClassRequest), proto.ChannelNumber(proto.MinChannelNumber), peer)
+	}
+
+	assert.Equal(t, stun.ErrorCode(0), refresh())
+
+	// Other usernames are not affected
+	assert.Equal(t, 0, allocationManager.RequireReauthentication("other"))
+	assert.Equal(t, stun.ErrorCode(0), refresh())
+
+	// The old key is rejected and the allocation deleted
+	assert.Equal(t, 1, allocationManager.RequireReauthentication("user"))
+	assert.Equal(t, stun.CodeWrongCredentials, refresh())
+	assert.Equal(t, 0, allocationManager.AllocationCount())
+	assert.Equal(t, stun.CodeAllocMismatch, refresh())
+
+	// Requests with a new key re-authenticate the allocation
+	allocate()
+	assert.Equal(t, 1, allocationManager.RequireReauthentication("user"))
+	key = []byte("new key")
+	assert.Equal(t, stun.ErrorCode(0), refresh())
+	assert.Equal(t, stun.ErrorCode(0), refresh())
+
+	// Rejected refreshes aren't reported
+	assert.Equal(t, []time.Duration{10 * time.Minute, 10 * time.Minute, 10 * time.Minute, 10 * time.Minute}, refreshed)
+
+	// CreatePermission and ChannelBind re-authenticate like Refresh
+	for name, handle := range map[string]func() stun.ErrorCode{"CreatePermission": createPermission, "ChannelBind": channelBind} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, 1, allocationManager.RequireReauthentication("user"))
+			assert.Equal(t, stun.CodeWrongCredentials, handle())
+			assert.Equal(t, 0, allocationManager.AllocationCount())
+
+			allocate()
+			assert.Equal(t, 1, allocationManager.RequireReauthentication("user"))
+			key = []byte("key of " + name)
+			assert.Equal(t, stun.ErrorCode(0), handle())
+			assert.Equal(t, 1, allocationManager.AllocationCount())
+		})
+	}
+
+	// Send indications are dropped until the client re-authenticated
+	assert.Equal(t, 1, allocationManager.RequireReauthentication("user"))
+	indication, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodSend, stun.ClassIndication), proto.Data("data"), peer)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, handleSendIndication(r, indication), errReauthenticationRequired)
+	a, err := getAllocation(r, "")
+	assert.NoError(t, err)
+	assert.True(t, a.ReauthenticationRequired())
+}
+
+func TestReauthenticationTCP(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	peerListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, peerListener.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		AllocateListener: func(network string, requestedPort int) (net.Listener, net.Addr, error) {
+			listener, listenErr := net.Listen(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return listener, listener.Addr(), nil
+		},
+		DialPeer: func(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+			return net.Dial(network, peerAddr.String())
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	nonce, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	key := []byte("old key")
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return key, true
+		},
+	}
+
+	fiveTuple := &allocation.FiveTuple{SrcAddr: r.SrcAddr, DstAddr: r.Conn.LocalAddr(), Protocol: allocation.UDP}
+	allocate := func() *allocation.Allocation {
+		a, allocateErr := r.AllocationManager.CreateTCPAllocation(fiveTuple, r.Conn, proto.RequestedFamilyIPv4, time.Hour)
+		assert.NoError(t, allocateErr)
+		a.SetCredentials("user", key)
+		return a
+	}
+
+	request := func(r Request, from net.PacketConn, handle func(Request, *stun.Message) error, setters ...stun.Setter) stun.ErrorCode {
+		m, buildErr := stun.Build(append(append([]stun.Setter{stun.TransactionID}, setters...),
+			stun.Nonce(nonce), stun.Realm("pion.ly"), stun.Username("user"), stun.MessageIntegrity(key))...)
+		assert.NoError(t, buildErr)
+		_ = handle(r, m)
+
+		buf := make([]byte, 1500)
+		n, _, readErr := from.ReadFrom(buf)
+		assert.NoError(t, readErr)
+
+		res := &stun.Message{Raw: buf[:n]}
+		assert.NoError(t, res.Decode())
+		if res.Type.Class == stun.ClassSuccessResponse {
+			return 0
+		}
+
+		var code stun.ErrorCodeAttribute
+		assert.NoError(t, code.GetFrom(res))
+		return code.Code
+	}
+
+	peerAddr := peerListener.Addr().(*net.TCPAddr) //nolint:forcetypeassert
+	peer := proto.PeerAddress{IP: peerAddr.IP, Port: peerAddr.Port}
+
+	t.Run("Connect", func(t *testing.T) {
+		allocate()
+		assert.Equal(t, 1, allocationManager.RequireReauthentication("user"))
+		assert.Equal(t, stun.CodeWrongCredentials, request(r, clientConn, handleConnectRequest, stun.NewType(stun.MethodConnect, stun.ClassRequest), peer))
+		assert.Equal(t, 0, allocationManager.AllocationCount())
+	})
+
+	t.Run("ConnectionBind", func(t *testing.T) {
+		a := allocate()
+		cid, connectErr := allocationManager.Connect(a, peerAddr)
+		assert.NoError(t, connectErr)
+		assert.Equal(t, 1, allocationManager.RequireReauthentication("user"))
+
+		// The data connection is bound from another source than the control connection
+		dataConn, listenErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, listenErr)
+		defer func() {
+			assert.NoError(t, dataConn.Close())
+		}()
+
+		bindRequest := r
+		bindRequest.SrcAddr = dataConn.LocalAddr()
+		bindRequest.DetachConn = func() (net.Conn, []byte) {
+			t.Fatal("DetachConn called for a rejected ConnectionBind")
+			return nil, nil
+		}
+		assert.Equal(t, stun.CodeWrongCredentials, request(bindRequest, dataConn, handleConnectionBindRequest, stun.NewType(stun.MethodConnectionBind, stun.ClassRequest), cid))
+		assert.Equal(t, 0, allocationManager.AllocationCount())
+
+		_, bindErr := allocationManager.BindTCPConnection(cid)
+		assert.ErrorIs(t, bindErr, allocation.ErrNoSuchTCPConnection)
+	})
+}
+
+func TestRestoreAllocationUsernameMismatch(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	standby := allocation.NewStandby()
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+		Standby:       standby,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	nonce, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	key := []byte("key")
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return key, true
+		},
+	}
+
+	// The replicated allocation of the 5-tuple belongs to another user
+	standby.Created(allocation.Replica{
+		Username:  "owner",
+		FiveTuple: allocation.FiveTuple{SrcAddr: r.SrcAddr, DstAddr: r.Conn.LocalAddr(), Protocol: allocation.UDP},
+		RelayAddr: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000},
+		Expires:   time.Now().Add(time.Minute),
+	})
+
+	m, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodCreatePermission, stun.ClassRequest),
+		proto.PeerAddress{IP: net.ParseIP("127.0.0.1"), Port: 6000},
+		stun.Nonce(nonce), stun.Realm("pion.ly"), stun.Username("user"), stun.MessageIntegrity(key))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, handleCreatePermissionRequest(r, m), allocation.ErrUsernameMismatch)
+
+	buf := make([]byte, 1500)
+	n, _, err := clientConn.ReadFrom(buf)
+	assert.NoError(t, err)
+	res := &stun.Message{Raw: buf[:n]}
+	assert.NoError(t, res.Decode())
+
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(res))
+	assert.Equal(t, stun.CodeWrongCredentials, code.Code)
+	assert.Equal(t, 0, allocationManager.AllocationCount())
+	assert.Equal(t, 1, standby.Len())
+}
+
+func TestAllocateAlternateServer(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	nonce, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	var alternateAddr net.Addr
+	key := []byte("key")
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		Realm:             "pion.ly",
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return key, true
+		},
+		AlternateServer: func(username, realm string, srcAddr net.Addr) (net.Addr, bool) {
+			assert.Equal(t, "user", username)
+			assert.Equal(t, "pion.ly", realm)
+			return alternateAddr, alternateAddr != nil
+		},
+	}
+
+	allocate := func() *stun.Message {
+		m := &stun.Message{}
+		assert.NoError(t, (proto.RequestedTransport{Protocol: proto.ProtoUDP}).AddTo(m))
+		assert.NoError(t, (stun.Nonce(nonce)).AddTo(m))
+		assert.NoError(t, (stun.Realm("pion.ly")).AddTo(m))
+		assert.NoError(t, (stun.Username("user")).AddTo(m))
+		assert.NoError(t, (stun.MessageIntegrity(key)).AddTo(m))
+		_ = handleAllocateRequest(r, m)
+
+		buf := make([]byte, 1500)
+		n, _, readErr := clientConn.ReadFrom(buf)
+		assert.NoError(t, readErr)
+
+		res := &stun.Message{Raw: buf[:n]}
+		assert.NoError(t, res.Decode())
+		return res
+	}
+
+	// The allocation is redirected to the alternate server
+	alternateAddr = &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3478}
+	res := allocate()
+	assert.Equal(t, stun.ClassErrorResponse, res.Type.Class)
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(res))
+	assert.Equal(t, stun.CodeTryAlternate, code.Code)
+	var alternateServer stun.AlternateServer
+	assert.NoError(t, alternateServer.GetFrom(res))
+	assert.True(t, alternateServer.IP.Equal(net.ParseIP("10.0.0.1")))
+	assert.Equal(t, 3478, alternateServer.Port)
+	assert.NoError(t, stun.MessageIntegrity(key).Check(res))
+	assert.Equal(t, 0, allocationManager.AllocationCount())
+
+	// Otherwise the allocation is created
+	alternateAddr = nil
+	res = allocate()
+	assert.Equal(t, stun.ClassSuccessResponse, res.Type.Class)
+	assert.Equal(t, 1, allocationManager.AllocationCount())
+}
+
+func TestMobilityTicketReissue(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	clientConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	nonce, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	key := []byte("key")
+	r := Request{
+		AllocationManager: allocationManager,
+		Nonces:            nonceHash,
+		Conn:              l,
+		SrcAddr:           clientConn.LocalAddr(),
+		Log:               logger,
+		Realm:             "pion.ly",
+		AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return key, true
+		},
+		Mobility: true,
+	}
+
+	request := func(handle func(Request, *stun.Message) error, setters ...stun.Setter) (stun.MessageType, proto.MobilityTicket) {
+		m, buildErr := stun.Build(append(setters, stun.Nonce(nonce), stun.Realm("pion.ly"), stun.Username("user"), stun.MessageIntegrity(key))...)
+		assert.NoError(t, buildErr)
+		_ = handle(r, m)
+
+		buf := make([]byte, 1500)
+		n, _, readErr := clientConn.ReadFrom(buf)
+		assert.NoError(t, readErr)
+
+		res := &stun.Message{Raw: buf[:n]}
+		assert.NoError(t, res.Decode())
+		var ticket proto.MobilityTicket
+		_ = ticket.GetFrom(res)
+		return res.Type, append(proto.MobilityTicket{}, ticket...)
+	}
+
+	// Retransmitted Allocate requests get a new ticket
+	var transactionID [stun.TransactionIDSize]byte
+	copy(transactionID[:], "allocate-txn")
+	allocate := []stun.Setter{
+		&stun.Message{TransactionID: transactionID}, stun.NewType(stun.MethodAllocate, stun.ClassRequest),
+		proto.RequestedTransport{Protocol: proto.ProtoUDP}, proto.MobilityTicket{},
+	}
+	typ, allocated := request(handleAllocateRequest, allocate...)
+	assert.Equal(t, stun.ClassSuccessResponse, typ.Class)
+	assert.NotEmpty(t, allocated)
+	typ, reissued := request(handleAllocateRequest, allocate...)
+	assert.Equal(t, stun.ClassSuccessResponse, typ.Class)
+	assert.NotEmpty(t, reissued)
+	assert.NotEqual(t, allocated, reissued)
+
+	// Every Refresh response carries a new ticket, the old one is invalid once the new
+	// one was presented
+	refresh := func(ticket proto.MobilityTicket) (stun.MessageType, proto.MobilityTicket) {
+		return request(handleRefreshRequest, stun.TransactionID, stun.NewType(stun.MethodRefresh, stun.ClassRequest),
+			proto.Lifetime{Duration: 10 * time.Minute}, ticket)
+	}
+	typ, renewed := refresh(reissued)
+	assert.Equal(t, stun.ClassSuccessResponse, typ.Class)
+	assert.NotEmpty(t, renewed)
+	assert.NotEqual(t, reissued, renewed)
+
+	typ, _ = refresh(renewed)
+	assert.Equal(t, stun.ClassSuccessResponse, typ.Class)
+	typ, _ = refresh(reissued)
+	assert.Equal(t, stun.ClassErrorResponse, typ.Class)
+}
+
+type discardPacketConn struct {
+	net.PacketConn
+}
+
+func (c *discardPacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	return len(p), nil
+}
+
+func TestChannelDataAllocations(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	// The relay discards what it sends, so only the handling of ChannelData is measured
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			conn, listenErr := net.ListenPacket(network, "127.0.0.1:0")
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return &discardPacketConn{PacketConn: conn}, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	clientAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	peerAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5001}
+	fiveTuple := &allocation.FiveTuple{SrcAddr: clientAddr, DstAddr: l.LocalAddr(), Protocol: allocation.UDP}
+	a, err := allocationManager.CreateAllocation(fiveTuple, l, proto.RequestedFamilyIPv4, 0, time.Hour)
+	assert.NoError(t, err)
+	a.AddPermission(allocation.NewPermission(peerAddr, logger))
+	assert.NoError(t, a.AddChannelBind(allocation.NewChannelBind(proto.MinChannelNumber, peerAddr, logger), time.Hour))
+
+	channelData := &proto.ChannelData{Number: proto.MinChannelNumber, Data: make([]byte, 1000)}
+	channelData.Encode()
+	r := Request{
+		AllocationManager: allocationManager,
+		Conn:              l,
+		SrcAddr:           clientAddr,
+		Buff:              channelData.Raw,
+		Log:               logger,
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		assert.NoError(t, HandleRequest(r))
+	})
+	assert.Zero(t, allocs)
+}
+
+func TestAllocateAdditionalAddressFamily(t *testing.T) {
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, l.Close())
+	}()
+
+	logger := logging.NewDefaultLoggerFactory().NewLogger("turn")
+
+	ipv6 := true
+	allocationManager, err := allocation.NewManager(allocation.ManagerConfig{
+		AllocatePacketConn: func(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+			address := "127.0.0.1:0"
+			if network == "udp6" {
+				if !ipv6 {
+					return nil, nil, allocation.ErrAddressFamilyNotSupported
+				}
+				address = "[::1]:0"
+			}
+
+			conn, listenErr := net.ListenPacket(network, address)
+			if listenErr != nil {
+				return nil, nil, listenErr
+			}
+
+			return conn, conn.LocalAddr(), nil
+		},
+		AllocateConn: func(network string, requestedPort int) (net.Conn, net.Addr, error) {
+			return nil, nil, nil
+		},
+		LeveledLogger: logger,
+	})
+	assert.NoError(t, err)
+	defer func() {
+		assert.NoError(t, allocationManager.Close())
+	}()
+
+	nonceHash, err := NewNonceHash(0)
+	assert.NoError(t, err)
+	nonce, err := nonceHash.Generate()
+	assert.NoError(t, err)
+
+	key := []byte("key")
+	allocate := func(setters ...stun.Setter) *stun.Message {
+		clientConn, listenErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, listenErr)
+		defer func() {
+			assert.NoError(t, clientConn.Close())
+		}()
+
+		r := Request{
+			AllocationManager: allocationManager,
+			Nonces:            nonceHash,
+			Conn:              l,
+			SrcAddr:           clientConn.LocalAddr(),
+			Log:               logger,
+			Realm:             "pion.ly",
+			AuthHandler: func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+				return key, true
+			},
+		}
+
+		m := &stun.Message{}
+		assert.NoError(t, (proto.RequestedTransport{Protocol: proto.ProtoUDP}).AddTo(m))
+		for _, setter := range setters {
+			assert.NoError(t, setter.AddTo(m))
+		}
+		assert.NoError(t, (stun.Nonce(nonce)).AddTo(m))
+		assert.NoError(t, (stun.Realm("pion.ly")).AddTo(m))
+		assert.NoError(t, (stun.Username("user")).AddTo(m))
+		assert.NoError(t, (stun.MessageIntegrity(key)).AddTo(m))
+		_ = handleAllocateRequest(r, m)
+
+		buf := make([]byte, 1500)
+		n, _, readErr := clientConn.ReadFrom(buf)
+		assert.NoError(t, readErr)
+
+		res := &stun.Message{Raw: buf[:n]}
+		assert.NoError(t, res.Decode())
+		return res
+	}
+
+	// relayedAddresses returns the XOR-RELAYED-ADDRESS attributes of res in order
+	relayedAddresses := func(res *stun.Message) []proto.RelayedAddress {
+		addrs := []proto.RelayedAddress{}
+		for _, attr := range res.Attributes {
+			if attr.Type != stun.AttrXORRelayedAddress {
+				continue
+			}
+
+			single := &stun.Message{TransactionID: res.TransactionID}
+			single.Add(attr.Type, attr.Value)
+			var addr proto.RelayedAddress
+			assert.NoError(t, addr.GetFrom(single))
+			addrs = append(addrs, addr)
+		}
+		return addrs
+	}
+
+	additionalFamily := proto.AdditionalAddressFamily(proto.RequestedFamilyIPv6)
+	errorCode := func(res *stun.Message) stun.ErrorCode {
+		var code stun.ErrorCodeAttribute
+		assert.NoError(t, code.GetFrom(res))
+		return code.Code
+	}
+
+	// The allocation gets an IPv4 and an IPv6 relayed transport address
+	res := allocate(additionalFamily)
+	assert.Equal(t, stun.ClassSuccessResponse, res.Type.Class)
+	addrs := relayedAddresses(res)
+	if assert.Len(t, addrs, 2) {
+		assert.NotNil(t, addrs[0].IP.To4())
+		assert.True(t, addrs[1].IP.Equal(net.IPv6loopback))
+	}
+	assert.False(t, res.Contains(proto.AttrAddressErrorCode))
+
+	// Without IPv6 relays, the allocation only gets the IPv4 one
+	ipv6 = false
+	res = allocate(additionalFamily)
+	assert.Equal(t, stun.ClassSuccessResponse, res.Type.Class)
+	assert.Len(t, relayedAddresses(res), 1)
+	var addressErrorCode proto.AddressErrorCode
+	assert.NoError(t, addressErrorCode.GetFrom(res))
+	assert.Equal(t, proto.RequestedFamilyIPv6, addressErrorCode.Family)
+	assert.Equal(t, stun.CodeAddrFamilyNotSupported, addressErrorCode.Code)
+	assert.Equal(t, 2, allocationManager.AllocationCount())
+
+	// Invalid combinations are rejected
+	res = allocate(proto.AdditionalAddressFamily(proto.RequestedFamilyIPv4))
+	assert.Equal(t, stun.CodeBadRequest, errorCode(res))
+	res = allocate(additionalFamily, proto.RequestedFamilyIPv4)
+	assert.Equal(t, stun.CodeBadRequest, errorCode(res))
+	assert.Equal(t, 2, allocationManager.AllocationCount())
+}
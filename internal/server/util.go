@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
 	"github.com/pion/turn/v3/internal/proto"
 )
 
@@ -22,10 +23,13 @@ func buildAndSend(conn net.PacketConn, dst net.Addr, attrs ...stun.Setter) error
 	if err != nil {
 		return err
 	}
-	_, err = conn.WriteTo(msg.Raw, dst)
+	_, err = turnSocket(conn).WriteTo(msg.Raw, dst)
 	if errors.Is(err, net.ErrClosed) {
 		return nil
 	}
+	if c, ok := conn.(*tracingConn); ok && err == nil {
+		c.record(msg)
+	}
 
 	return err
 }
@@ -42,18 +46,45 @@ func buildMsg(transactionID [stun.TransactionIDSize]byte, msgType stun.MessageTy
 	return append([]stun.Setter{&stun.Message{TransactionID: transactionID}, msgType}, additional...)
 }
 
-func authenticateRequest(r Request, m *stun.Message, callingMethod stun.Method) (stun.MessageIntegrity, bool, error) {
-	respondWithNonce := func(responseCode stun.ErrorCode) (stun.MessageIntegrity, bool, error) {
-		nonce, err := r.NonceHash.Generate()
+func authenticateRequest(r Request, m *stun.Message, callingMethod stun.Method) (*proto.Integrity, bool, error) {
+	messageIntegrity, _, hasAuth, err := authenticate(r, m, callingMethod)
+	return messageIntegrity, hasAuth, err
+}
+
+// authenticate is authenticateRequest that also returns the SessionPolicy of AuthHandlerV2
+func authenticate(r Request, m *stun.Message, callingMethod stun.Method) (*proto.Integrity, allocation.SessionPolicy, bool, error) {
+	var sessionPolicy allocation.SessionPolicy
+
+	// The USERNAME selects the realm, it may be sent before the client knows the realm
+	usernameAttr := &stun.Username{}
+	_ = usernameAttr.GetFrom(m)
+	realm, realmSelected := r.realmOf(usernameAttr.String())
+
+	respondWithNonce := func(responseCode stun.ErrorCode) (*proto.Integrity, allocation.SessionPolicy, bool, error) {
+		var nonce string
+		var err error
+		if responseCode == stun.CodeUnauthorized && r.ChallengeCache != nil {
+			nonce, err = r.ChallengeCache.Nonce(r.SrcAddr)
+		} else {
+			nonce, err = r.Nonces.Generate()
+		}
 		if err != nil {
-			return nil, false, err
+			return nil, sessionPolicy, false, err
 		}
 
-		return nil, false, buildAndSend(r.Conn, r.SrcAddr, buildMsg(m.TransactionID,
-			stun.NewType(callingMethod, stun.ClassErrorResponse),
+		setters := []stun.Setter{
 			&stun.ErrorCodeAttribute{Code: responseCode},
 			stun.NewNonce(nonce),
-			stun.NewRealm(r.Realm),
+			stun.NewRealm(realm),
+		}
+		if r.ThirdPartyAuthorization != "" {
+			setters = append(setters, proto.ThirdPartyAuthorization(r.ThirdPartyAuthorization))
+		}
+		setters = append(setters, r.AffinityToken)
+
+		return nil, sessionPolicy, false, buildAndSend(r.Conn, r.SrcAddr, buildMsg(m.TransactionID,
+			stun.NewType(callingMethod, stun.ClassErrorResponse),
+			setters...,
 		)...)
 	}
 
@@ -62,35 +93,90 @@ func authenticateRequest(r Request, m *stun.Message, callingMethod stun.Method)
 	}
 
 	nonceAttr := &stun.Nonce{}
-	usernameAttr := &stun.Username{}
 	realmAttr := &stun.Realm{}
 	badRequestMsg := buildMsg(m.TransactionID, stun.NewType(callingMethod, stun.ClassErrorResponse), &stun.ErrorCodeAttribute{Code: stun.CodeBadRequest})
 
 	if err := nonceAttr.GetFrom(m); err != nil {
-		return nil, false, buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+		return nil, sessionPolicy, false, buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
 	}
 
-	// Assert Nonce is signed and is not expired
-	if err := r.NonceHash.Validate(nonceAttr.String()); err != nil {
-		return respondWithNonce(stun.CodeStaleNonce)
+	// Assert Nonce is signed and is not expired, unless it is the one just challenged with
+	if !r.ChallengeCache.Issued(r.SrcAddr, nonceAttr.String()) {
+		if err := r.Nonces.Validate(nonceAttr.String()); err != nil {
+			r.Metrics.StaleNonce()
+			r.StaleNonces.Observe(r.SrcAddr)
+			return respondWithNonce(stun.CodeStaleNonce)
+		}
 	}
 
 	if err := realmAttr.GetFrom(m); err != nil {
-		return nil, false, buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+		return nil, sessionPolicy, false, buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
 	} else if err := usernameAttr.GetFrom(m); err != nil {
-		return nil, false, buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+		return nil, sessionPolicy, false, buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+	}
+
+	// Clients of a username with its own realm are challenged again with the right one
+	if realmSelected && realmAttr.String() != realm {
+		return respondWithNonce(stun.CodeUnauthorized)
 	}
 
-	ourKey, ok := r.AuthHandler(usernameAttr.String(), realmAttr.String(), r.SrcAddr)
+	// The requests of an allocation are verified with the key cached with it first
+	a := r.AllocationManager.GetAllocation(&allocation.FiveTuple{
+		SrcAddr:  r.SrcAddr,
+		DstAddr:  r.Conn.LocalAddr(),
+		Protocol: allocation.UDP,
+	})
+	cached, generation := r.CredentialCache.lookup(a, usernameAttr.String(), realmAttr.String())
+	if cached != nil && !m.Contains(proto.AttrAccessToken) && cached.Check(m) == nil {
+		return cached, sessionPolicy, true, nil
+	}
+
+	// RFC 7635 Section 9: with third-party authorization the USERNAME is the key ID of
+	// the ACCESS-TOKEN, and the mac_key it carries is the key for MESSAGE-INTEGRITY
+	var ourKey []byte
+	var ok bool
+	var accessToken proto.AccessToken
+	switch {
+	case r.AccessTokenHandler != nil && accessToken.GetFrom(m) == nil:
+		if ourKey, ok = r.AccessTokenHandler(usernameAttr.String(), realmAttr.String(), accessToken, r.SrcAddr); !ok {
+			r.Log.Debugf("Rejected ACCESS-TOKEN with kid %q from %s", usernameAttr.String(), r.SrcAddr)
+			r.Metrics.AuthFailure()
+			return respondWithNonce(stun.CodeUnauthorized)
+		}
+	case r.AuthHandlerV2 != nil:
+		ourKey, sessionPolicy, ok = r.AuthHandlerV2(r.Context, AuthRequest{
+			Username: usernameAttr.String(),
+			Realm:    realmAttr.String(),
+			SrcAddr:  r.SrcAddr,
+			Listener: r.AllocationManager.Listener(),
+			Message:  m,
+		})
+	case r.AuthHandler != nil:
+		ourKey, ok = r.AuthHandler(usernameAttr.String(), realmAttr.String(), r.SrcAddr)
+	}
 	if !ok {
-		return nil, false, buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w %s", errNoSuchUser, usernameAttr.String()), badRequestMsg...)
+		r.Metrics.AuthFailure()
+		return nil, sessionPolicy, false, buildAndSendErr(r.Conn, r.SrcAddr, fmt.Errorf("%w %s", errNoSuchUser, usernameAttr.String()), badRequestMsg...)
+	}
+
+	// The HMAC key of an allocation is precomputed once, see proto.Integrity
+	var integrity *proto.Integrity
+	if a != nil {
+		integrity = a.Integrity(ourKey)
+	} else {
+		integrity = proto.NewIntegrity(ourKey)
 	}
 
-	if err := stun.MessageIntegrity(ourKey).Check(m); err != nil {
-		return nil, false, buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
+	if err := integrity.Check(m); err != nil {
+		r.Metrics.AuthFailure()
+		return nil, sessionPolicy, false, buildAndSendErr(r.Conn, r.SrcAddr, err, badRequestMsg...)
 	}
 
-	return stun.MessageIntegrity(ourKey), true, nil
+	if !m.Contains(proto.AttrAccessToken) {
+		r.CredentialCache.store(a, usernameAttr.String(), realmAttr.String(), generation, integrity)
+	}
+
+	return integrity, sessionPolicy, true, nil
 }
 
 func allocationLifeTime(m *stun.Message) time.Duration {
@@ -105,3 +191,72 @@ func allocationLifeTime(m *stun.Message) time.Duration {
 
 	return lifetimeDuration
 }
+
+// getAllocation returns the allocation of the client of r. An allocation replicated from
+// another server is restored if this server is its standby, for authenticated requests
+// only if username owns it, see Manager.RestoreAllocation. Unauthenticated indications and
+// ChannelData pass an empty username. The error is allocation.ErrUsernameMismatch
+func getAllocation(r Request, username string) (*allocation.Allocation, error) {
+	// The 5-tuple of the lookup doesn't escape, ChannelData is relayed without allocating
+	if a := r.AllocationManager.GetAllocation(&allocation.FiveTuple{
+		SrcAddr:  r.SrcAddr,
+		DstAddr:  r.Conn.LocalAddr(),
+		Protocol: allocation.UDP,
+	}); a != nil {
+		return a, nil
+	}
+
+	fiveTuple := &allocation.FiveTuple{
+		SrcAddr:  r.SrcAddr,
+		DstAddr:  r.Conn.LocalAddr(),
+		Protocol: allocation.UDP,
+	}
+	a, err := r.AllocationManager.RestoreAllocation(fiveTuple, username, turnSocket(r.Conn), r.ChannelBindTimeout)
+	if errors.Is(err, allocation.ErrUsernameMismatch) {
+		return nil, err
+	} else if err != nil {
+		r.Log.Warnf("Failed to restore replicated allocation %v: %v", fiveTuple, err)
+		return nil, nil
+	}
+
+	return a, nil
+}
+
+// reissueMobilityTicket replaces the MOBILITY-TICKET in the cached attrs of the Allocate
+// response of a with a new ticket, if it has one
+func reissueMobilityTicket(r Request, a *allocation.Allocation, attrs []stun.Setter) ([]stun.Setter, error) {
+	for i, attr := range attrs {
+		if _, ok := attr.(proto.MobilityTicket); !ok {
+			continue
+		}
+
+		ticket, err := r.AllocationManager.CreateMobilityTicket(a)
+		if err != nil {
+			return nil, err
+		}
+		reissued := append([]stun.Setter{}, attrs...)
+		reissued[i] = ticket
+
+		return reissued, nil
+	}
+
+	return attrs, nil
+}
+
+// reauthenticate checks the key of an authenticated request on the allocation a of its
+// client, see Allocation.Reauthenticate. An allocation that fails the check is deleted,
+// its client has to allocate again with the new credential. The request may come from
+// another connection than the allocation, e.g. a ConnectionBind on a data connection
+func reauthenticate(r Request, a *allocation.Allocation, messageIntegrity *proto.Integrity) bool {
+	if a.Reauthenticate(messageIntegrity.Key()) {
+		return true
+	}
+
+	info := a.Info()
+	fiveTuple := &info.FiveTuple
+	r.Log.Infof("Deleting allocation %v, re-authentication required for %q", fiveTuple, a.Username())
+	r.AllocationManager.DeleteAllocation(fiveTuple)
+	r.Rebalance.Remove(fiveTuple.Fingerprint())
+
+	return false
+}
@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// closeTimeout is how long Close waits for the resources of the server to be released
+	closeTimeout      = 5 * time.Second
+	closePollInterval = 10 * time.Millisecond
+)
+
+// LeakError is returned by Server.CloseAndVerify with the resources of the server that
+// were still in use after it was closed
+type LeakError struct {
+	// Readers are the goroutines reading from listeners and client connections
+	Readers int
+	// Allocations that weren't deleted, including expired ones in their grace period
+	Allocations int
+	// TCPConnections are the peer connections of TCP allocations
+	TCPConnections int
+	// Handlers are the goroutines relaying from relay sockets and TCP connections, they
+	// return once the relay sockets are closed
+	Handlers int
+	// Timers are the pending reservation and grace period timers
+	Timers int
+}
+
+func (e *LeakError) Error() string {
+	leaks := []string{}
+	for _, l := range []struct {
+		n    int
+		name string
+	}{
+		{e.Readers, "readers"},
+		{e.Allocations, "allocations"},
+		{e.TCPConnections, "TCP connections"},
+		{e.Handlers, "relay handlers"},
+		{e.Timers, "timers"},
+	} {
+		if l.n != 0 {
+			leaks = append(leaks, fmt.Sprintf("%d %s", l.n, l.name))
+		}
+	}
+
+	return "turn: leaked after Close: " + strings.Join(leaks, ", ")
+}
+
+// CloseAndVerify closes the server like Close, and returns a *LeakError if goroutines,
+// allocations, relay sockets or timers of the server are still in use afterwards, e.g. in
+// tests that must not leak
+func (s *Server) CloseAndVerify() error {
+	if err := s.Close(); err != nil {
+		return err
+	}
+
+	if leaks := s.leaks(); leaks != nil {
+		return leaks
+	}
+	return nil
+}
+
+// goReader runs f in a goroutine counted as reader
+func (s *Server) goReader(f func()) {
+	atomic.AddInt64(&s.readers, 1)
+	go func() {
+		defer atomic.AddInt64(&s.readers, -1)
+		f()
+	}()
+}
+
+// leaks returns the resources of the server in use, nil if there are none
+func (s *Server) leaks() *LeakError {
+	leaks := LeakError{Readers: int(atomic.LoadInt64(&s.readers))}
+	for _, am := range s.managers() {
+		r := am.Resources()
+		leaks.Allocations += r.Allocations
+		leaks.TCPConnections += r.TCPConnections
+		leaks.Handlers += r.Handlers
+		leaks.Timers += r.Timers
+	}
+
+	if leaks == (LeakError{}) {
+		return nil
+	}
+	return &leaks
+}
+
+// waitReaders waits up to timeout for the readers to return
+func (s *Server) waitReaders(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt64(&s.readers) != 0 && time.Now().Before(deadline) {
+		time.Sleep(closePollInterval)
+	}
+}
+
+// waitReleased waits up to timeout for the resources of the server to be released, and
+// returns those still in use
+func (s *Server) waitReleased(timeout time.Duration) *LeakError {
+	deadline := time.Now().Add(timeout)
+	for {
+		leaks := s.leaks()
+		if leaks == nil || time.Now().After(deadline) {
+			return leaks
+		}
+		time.Sleep(closePollInterval)
+	}
+}
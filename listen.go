@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+
+	"github.com/pion/turn/v3/internal/ipnet"
+)
+
+// ListenOptions tunes the sockets opened by ListenUDP and ListenTCP
+type ListenOptions struct {
+	// ReadBufferSize and WriteBufferSize set the receive and send buffers of the socket,
+	// SO_RCVBUF and SO_SNDBUF. Large buffers absorb bursts of a busy UDP listener. The
+	// connections accepted by ListenTCP inherit them. The defaults of the OS are kept if 0.
+	// They are only supported on Linux by ListenTCP.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// ReusePort sets SO_REUSEPORT, so several sockets can be bound to the same port, see
+	// ReusePortPacketConnConfigs. Only supported on Linux.
+	ReusePort bool
+
+	// DSCP marks the packets sent on the socket, like ServerConfig.DSCP. Not marked if 0.
+	// Only supported on Linux.
+	DSCP int
+
+	// Control is called after the other options are set and before the socket is bound,
+	// like the Control of a net.ListenConfig, e.g. to set further socket options
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+func (o *ListenOptions) validate() error {
+	if o.ReadBufferSize < 0 || o.WriteBufferSize < 0 {
+		return fmt.Errorf("%w: %d/%d", errSocketBufferSizeInvalid, o.ReadBufferSize, o.WriteBufferSize)
+	}
+
+	if o.ReusePort && !reusePortSupported {
+		return errReusePortUnsupported
+	}
+
+	if o.DSCP < 0 || o.DSCP > ipnet.MaxDSCP {
+		return fmt.Errorf("%w: %d", errDSCPInvalid, o.DSCP)
+	}
+
+	return nil
+}
+
+// control applies the options to the socket before it is bound. Socket buffers are only set
+// if setBuffers, ListenUDP sets them on the bound socket
+func (o *ListenOptions) control(setBuffers bool) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		if o.ReusePort {
+			if err := reusePortControl(network, address, c); err != nil {
+				return err
+			}
+		}
+
+		if setBuffers && (o.ReadBufferSize != 0 || o.WriteBufferSize != 0) {
+			if err := setSocketBuffers(c, o.ReadBufferSize, o.WriteBufferSize); err != nil {
+				return err
+			}
+		}
+
+		if o.Control != nil {
+			return o.Control(network, address, c)
+		}
+		return nil
+	}
+}
+
+// ListenUDP opens a UDP socket on address with options, to be served with a
+// PacketConnConfig. network must be "udp", "udp4" or "udp6"
+func ListenUDP(network, address string, options ListenOptions) (net.PacketConn, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	listenConfig := &net.ListenConfig{Control: options.control(false)}
+	conn, err := listenConfig.ListenPacket(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, errNilConn
+	}
+
+	if options.ReadBufferSize != 0 {
+		err = udpConn.SetReadBuffer(options.ReadBufferSize)
+	}
+	if err == nil && options.WriteBufferSize != 0 {
+		err = udpConn.SetWriteBuffer(options.WriteBufferSize)
+	}
+	if err == nil && options.DSCP != 0 {
+		err = ipnet.SetDSCP(udpConn, options.DSCP)
+	}
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
+	}
+
+	return udpConn, nil
+}
+
+// ListenTCP opens a TCP listener on address with options, to be served with a
+// ListenerConfig. network must be "tcp", "tcp4" or "tcp6"
+func ListenTCP(network, address string, options ListenOptions) (net.Listener, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	listenConfig := &net.ListenConfig{Control: options.control(true)}
+	listener, err := listenConfig.Listen(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.DSCP != 0 {
+		if err = ipnet.SetDSCP(listener, options.DSCP); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setSocketBuffers sets SO_RCVBUF and SO_SNDBUF before a listener is bound, so the
+// connections it accepts scale their TCP window to them
+func setSocketBuffers(c syscall.RawConn, readBufferSize, writeBufferSize int) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		if readBufferSize != 0 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF, readBufferSize)
+		}
+		if sockErr == nil && writeBufferSize != 0 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF, writeBufferSize)
+		}
+	}); err != nil {
+		return err
+	}
+
+	return sockErr
+}
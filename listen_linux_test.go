@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func getsockopt(t *testing.T, conn syscall.Conn, level, opt int) int {
+	rawConn, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var value int
+	var sockErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		value, sockErr = unix.GetsockoptInt(int(fd), level, opt)
+	}))
+	require.NoError(t, sockErr)
+
+	return value
+}
+
+func TestListenUDP(t *testing.T) {
+	controlled := 0
+	options := ListenOptions{
+		ReadBufferSize:  64 << 10,
+		WriteBufferSize: 32 << 10,
+		ReusePort:       true,
+		DSCP:            46,
+		Control: func(network, address string, c syscall.RawConn) error {
+			controlled++
+			return nil
+		},
+	}
+
+	conn, err := ListenUDP("udp4", "127.0.0.1:0", options)
+	require.NoError(t, err)
+	udpConn := conn.(*net.UDPConn) //nolint:forcetypeassert
+
+	// Linux doubles the buffer sizes for its bookkeeping
+	assert.GreaterOrEqual(t, getsockopt(t, udpConn, unix.SOL_SOCKET, unix.SO_RCVBUF), 64<<10)
+	assert.GreaterOrEqual(t, getsockopt(t, udpConn, unix.SOL_SOCKET, unix.SO_SNDBUF), 32<<10)
+	assert.Equal(t, 1, getsockopt(t, udpConn, unix.SOL_SOCKET, unix.SO_REUSEPORT))
+	assert.Equal(t, 46<<2, getsockopt(t, udpConn, unix.IPPROTO_IP, unix.IP_TOS))
+	assert.Equal(t, 1, controlled)
+
+	// Another socket with SO_REUSEPORT shares the port
+	shared, err := ListenUDP("udp4", conn.LocalAddr().String(), options)
+	require.NoError(t, err)
+	assert.NoError(t, shared.Close())
+	assert.NoError(t, conn.Close())
+
+	errControl := errors.New("control failed")
+	_, err = ListenUDP("udp4", "127.0.0.1:0", ListenOptions{
+		Control: func(string, string, syscall.RawConn) error {
+			return errControl
+		},
+	})
+	assert.True(t, errors.Is(err, errControl), "unexpected error: %v", err)
+}
+
+func TestListenTCP(t *testing.T) {
+	listener, err := ListenTCP("tcp4", "127.0.0.1:0", ListenOptions{
+		ReadBufferSize:  64 << 10,
+		WriteBufferSize: 32 << 10,
+		DSCP:            34,
+	})
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, listener.Close())
+	}()
+
+	clientConn, err := net.Dial("tcp4", listener.Addr().String())
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, clientConn.Close())
+	}()
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+
+	// Accepted connections inherit the options of the listener
+	tcpConn := conn.(*net.TCPConn) //nolint:forcetypeassert
+	assert.GreaterOrEqual(t, getsockopt(t, tcpConn, unix.SOL_SOCKET, unix.SO_RCVBUF), 64<<10)
+	assert.GreaterOrEqual(t, getsockopt(t, tcpConn, unix.SOL_SOCKET, unix.SO_SNDBUF), 32<<10)
+	assert.Equal(t, 34<<2, getsockopt(t, tcpConn, unix.IPPROTO_IP, unix.IP_TOS))
+}
+
+func TestListenOptionsValidate(t *testing.T) {
+	_, err := ListenUDP("udp4", "127.0.0.1:0", ListenOptions{ReadBufferSize: -1})
+	assert.True(t, errors.Is(err, errSocketBufferSizeInvalid), "unexpected error: %v", err)
+
+	_, err = ListenTCP("tcp4", "127.0.0.1:0", ListenOptions{DSCP: 64})
+	assert.True(t, errors.Is(err, errDSCPInvalid), "unexpected error: %v", err)
+}
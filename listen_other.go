@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package turn
+
+import (
+	"syscall"
+)
+
+// setSocketBuffers is Linux only, listeners of other platforms keep the default buffers
+func setSocketBuffers(syscall.RawConn, int, int) error {
+	return errSocketBuffersUnsupported
+}
@@ -10,6 +10,7 @@ import ( //nolint:gci
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pion/logging"
@@ -42,30 +43,39 @@ func longTermCredentials(username string, sharedSecret string) (string, error) {
 	return base64.StdEncoding.EncodeToString(password), nil
 }
 
+// CredentialExpiryConfig configures how the AuthHandlers for time-windowed credentials
+// check the expiry timestamp embedded in the username. The zero value rejects credentials
+// as soon as the timestamp has passed according to the clock of the server.
+type CredentialExpiryConfig struct {
+	// PastSkew accepts credentials up to PastSkew after their expiry, to tolerate
+	// clients and credential issuers with clocks behind the server
+	PastSkew time.Duration
+
+	// MaxLifetime rejects credentials that expire more than MaxLifetime plus FutureSkew
+	// from now. Disabled if 0
+	MaxLifetime time.Duration
+	// FutureSkew is added to MaxLifetime, to tolerate credential issuers with clocks
+	// ahead of the server
+	FutureSkew time.Duration
+
+	// GracePeriod keeps accepting credentials for GracePeriod after their expiry, plus
+	// PastSkew, if they were accepted before they expired. This lets in-progress
+	// allocations refresh, create permissions and bind channels while the client fetches
+	// new credentials, but also admits new allocations with the same username
+	GracePeriod time.Duration
+}
+
 // NewLongTermAuthHandler returns a turn.AuthAuthHandler used with Long Term (or Time Windowed) Credentials.
 // See: https://datatracker.ietf.org/doc/html/rfc8489#section-9.2
 func NewLongTermAuthHandler(sharedSecret string, l logging.LeveledLogger) AuthHandler {
-	if l == nil {
-		l = logging.NewDefaultLoggerFactory().NewLogger("turn")
-	}
-	return func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
-		l.Tracef("Authentication username=%q realm=%q srcAddr=%v", username, realm, srcAddr)
-		t, err := strconv.Atoi(username)
-		if err != nil {
-			l.Errorf("Invalid time-windowed username %q", username)
-			return nil, false
-		}
-		if int64(t) < time.Now().Unix() {
-			l.Errorf("Expired time-windowed username %q", username)
-			return nil, false
-		}
-		password, err := longTermCredentials(username, sharedSecret)
-		if err != nil {
-			l.Error(err.Error())
-			return nil, false
-		}
-		return GenerateAuthKey(username, realm, password), true
-	}
+	return NewLongTermAuthHandlerWithExpiry(sharedSecret, l, CredentialExpiryConfig{})
+}
+
+// NewLongTermAuthHandlerWithExpiry is NewLongTermAuthHandler with a configurable expiry check
+func NewLongTermAuthHandlerWithExpiry(sharedSecret string, l logging.LeveledLogger, config CredentialExpiryConfig) AuthHandler {
+	return newTimeWindowedAuthHandler(sharedSecret, l, config, func(username string) string {
+		return username
+	})
 }
 
 // LongTermTURNRESTAuthHandler returns a turn.AuthAuthHandler that can be used to authenticate
@@ -75,19 +85,32 @@ func NewLongTermAuthHandler(sharedSecret string, l logging.LeveledLogger) AuthHa
 // The supported format of is timestamp:username, where username is an arbitrary user id and the
 // timestamp specifies the expiry of the credential.
 func LongTermTURNRESTAuthHandler(sharedSecret string, l logging.LeveledLogger) AuthHandler {
+	return LongTermTURNRESTAuthHandlerWithExpiry(sharedSecret, l, CredentialExpiryConfig{})
+}
+
+// LongTermTURNRESTAuthHandlerWithExpiry is LongTermTURNRESTAuthHandler with a configurable
+// expiry check
+func LongTermTURNRESTAuthHandlerWithExpiry(sharedSecret string, l logging.LeveledLogger, config CredentialExpiryConfig) AuthHandler {
+	return newTimeWindowedAuthHandler(sharedSecret, l, config, func(username string) string {
+		return strings.Split(username, ":")[0]
+	})
+}
+
+func newTimeWindowedAuthHandler(sharedSecret string, l logging.LeveledLogger, config CredentialExpiryConfig, timestamp func(string) string) AuthHandler {
 	if l == nil {
 		l = logging.NewDefaultLoggerFactory().NewLogger("turn")
 	}
+	checker := newCredentialExpiryChecker(config)
+
 	return func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
-		l.Tracef("Authentication username=%q realm=%q srcAddr=%v\n", username, realm, srcAddr)
-		timestamp := strings.Split(username, ":")[0]
-		t, err := strconv.Atoi(timestamp)
+		l.Tracef("Authentication username=%q realm=%q srcAddr=%v", username, realm, srcAddr)
+		t, err := strconv.Atoi(timestamp(username))
 		if err != nil {
 			l.Errorf("Invalid time-windowed username %q", username)
 			return nil, false
 		}
-		if int64(t) < time.Now().Unix() {
-			l.Errorf("Expired time-windowed username %q", username)
+		if err := checker.check(username, time.Unix(int64(t), 0), time.Now()); err != nil {
+			l.Errorf("Rejected time-windowed username %q: %s", username, err)
 			return nil, false
 		}
 		password, err := longTermCredentials(username, sharedSecret)
@@ -98,3 +121,55 @@ func LongTermTURNRESTAuthHandler(sharedSecret string, l logging.LeveledLogger) A
 		return GenerateAuthKey(username, realm, password), true
 	}
 }
+
+// credentialExpiryChecker implements CredentialExpiryConfig. It remembers the usernames
+// accepted before their expiry for the GracePeriod
+type credentialExpiryChecker struct {
+	config CredentialExpiryConfig
+
+	lock      sync.Mutex
+	accepted  map[string]time.Time
+	lastSweep time.Time
+}
+
+func newCredentialExpiryChecker(config CredentialExpiryConfig) *credentialExpiryChecker {
+	return &credentialExpiryChecker{
+		config:   config,
+		accepted: map[string]time.Time{},
+	}
+}
+
+func (c *credentialExpiryChecker) check(username string, expiry, now time.Time) error {
+	if c.config.MaxLifetime > 0 && expiry.After(now.Add(c.config.MaxLifetime+c.config.FutureSkew)) {
+		return errCredentialLifetimeTooLong
+	}
+
+	expiry = expiry.Add(c.config.PastSkew)
+	if c.config.GracePeriod <= 0 {
+		if expiry.Before(now) {
+			return errCredentialExpired
+		}
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if now.Sub(c.lastSweep) >= c.config.GracePeriod {
+		for u, e := range c.accepted {
+			if e.Add(c.config.GracePeriod).Before(now) {
+				delete(c.accepted, u)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if !expiry.Before(now) {
+		c.accepted[username] = expiry
+		return nil
+	}
+	if _, ok := c.accepted[username]; ok && !expiry.Add(c.config.GracePeriod).Before(now) {
+		return nil
+	}
+	return errCredentialExpired
+}
@@ -123,3 +123,39 @@ func TestLongTermTURNRESTAuthHandler(t *testing.T) {
 	assert.NoError(t, conn.Close())
 	assert.NoError(t, server.Close())
 }
+
+func TestCredentialExpiryChecker(t *testing.T) {
+	now := time.Now()
+
+	t.Run("Default", func(t *testing.T) {
+		c := newCredentialExpiryChecker(CredentialExpiryConfig{})
+		assert.NoError(t, c.check("user", now.Add(time.Minute), now))
+		assert.NoError(t, c.check("user", now.Add(24*time.Hour), now))
+		assert.ErrorIs(t, c.check("user", now.Add(-time.Second), now), errCredentialExpired)
+	})
+
+	t.Run("Skew", func(t *testing.T) {
+		c := newCredentialExpiryChecker(CredentialExpiryConfig{
+			PastSkew:    5 * time.Minute,
+			MaxLifetime: time.Hour,
+			FutureSkew:  5 * time.Minute,
+		})
+		assert.NoError(t, c.check("user", now.Add(-4*time.Minute), now))
+		assert.ErrorIs(t, c.check("user", now.Add(-6*time.Minute), now), errCredentialExpired)
+		assert.NoError(t, c.check("user", now.Add(time.Hour+4*time.Minute), now))
+		assert.ErrorIs(t, c.check("user", now.Add(time.Hour+6*time.Minute), now), errCredentialLifetimeTooLong)
+	})
+
+	t.Run("GracePeriod", func(t *testing.T) {
+		c := newCredentialExpiryChecker(CredentialExpiryConfig{GracePeriod: 10 * time.Minute})
+		expiry := now.Add(time.Minute)
+
+		// Accepted before the expiry, so in-progress allocations keep working for the grace period
+		assert.NoError(t, c.check("user", expiry, now))
+		assert.NoError(t, c.check("user", expiry, now.Add(5*time.Minute)))
+		assert.ErrorIs(t, c.check("user", expiry, now.Add(12*time.Minute)), errCredentialExpired)
+
+		// Never seen before its expiry
+		assert.ErrorIs(t, c.check("other", expiry, now.Add(5*time.Minute)), errCredentialExpired)
+	})
+}
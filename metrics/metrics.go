@@ -0,0 +1,307 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package metrics counts the allocations, relayed traffic and responses of a TURN server
+// and exposes them in the Prometheus text format. Pass a Metrics as ServerConfig.Metrics
+// and serve it on the scrape endpoint, or wrap the values returned by Snapshot in
+// prometheus.NewCounterFunc and prometheus.NewGaugeFunc to register them on an existing
+// registry.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Direction is the direction relayed traffic is sent in
+type Direction int
+
+const (
+	// DirectionToPeer is traffic from clients sent to peers
+	DirectionToPeer Direction = iota
+	// DirectionToClient is traffic from peers sent to clients
+	DirectionToClient
+)
+
+func (d Direction) String() string {
+	if d == DirectionToClient {
+		return "to_client"
+	}
+
+	return "to_peer"
+}
+
+// Snapshot is the state of a Metrics at one point in time
+type Snapshot struct {
+	AllocationsCreated uint64
+	AllocationsActive  int64
+	// RelayedBytes and RelayedPackets are indexed by Direction. TCP connections of RFC 6062
+	// allocations only count bytes
+	RelayedBytes   [2]uint64
+	RelayedPackets [2]uint64
+	AuthFailures   uint64
+	ChannelBinds   uint64
+	// NonceRotations is the number of rotations of the nonce key, StaleNonces the number
+	// of requests rejected with a 438 (Stale Nonce) error
+	NonceRotations uint64
+	StaleNonces    uint64
+	// ErrorResponses is the number of error responses sent per error code
+	ErrorResponses map[int]uint64
+	// SendBufferDrops, indexed by Direction, is the number of relayed datagrams dropped
+	// because the send buffer of the socket was full
+	SendBufferDrops [2]uint64
+	// OversizedDrops, indexed by Direction, is the number of relayed datagrams dropped
+	// because they exceeded the maximum packet size of their allocation
+	OversizedDrops [2]uint64
+	// RelayedIndications, indexed by Direction, is the number of the RelayedPackets that
+	// were carried in Send or Data indications, the rest in ChannelData. Indications cost
+	// at least 32 bytes more per datagram
+	RelayedIndications [2]uint64
+}
+
+// Metrics counts the events of a TURN server. It is safe for concurrent use, and all
+// methods of a nil Metrics do nothing
+type Metrics struct {
+	// Accessed atomically, first for 64-bit alignment
+	allocationsCreated uint64
+	allocationsActive  int64
+	relayedBytes       [2]uint64
+	relayedPackets     [2]uint64
+	authFailures       uint64
+	channelBinds       uint64
+	nonceRotations     uint64
+	staleNonces        uint64
+	sendBufferDrops    [2]uint64
+	oversizedDrops     [2]uint64
+	relayedIndications [2]uint64
+
+	lock           sync.Mutex
+	errorResponses map[int]uint64
+}
+
+// New creates a Metrics
+func New() *Metrics {
+	return &Metrics{errorResponses: map[int]uint64{}}
+}
+
+// AllocationCreated counts a new allocation
+func (m *Metrics) AllocationCreated() {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.allocationsCreated, 1)
+	atomic.AddInt64(&m.allocationsActive, 1)
+}
+
+// AllocationDeleted counts the end of an allocation
+func (m *Metrics) AllocationDeleted() {
+	if m == nil {
+		return
+	}
+
+	atomic.AddInt64(&m.allocationsActive, -1)
+}
+
+// Relayed counts a packet of n bytes relayed in direction
+func (m *Metrics) Relayed(direction Direction, n int) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.relayedBytes[direction], uint64(n))
+	atomic.AddUint64(&m.relayedPackets[direction], 1)
+}
+
+// RelayedStream counts n bytes relayed in direction on a TCP connection
+func (m *Metrics) RelayedStream(direction Direction, n int) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.relayedBytes[direction], uint64(n))
+}
+
+// RelayedIndication counts a packet relayed in direction that was carried in a Send or Data
+// indication, in addition to Relayed
+func (m *Metrics) RelayedIndication(direction Direction) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.relayedIndications[direction], 1)
+}
+
+// AuthFailure counts a request that failed authentication
+func (m *Metrics) AuthFailure() {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.authFailures, 1)
+}
+
+// ChannelBound counts a successful ChannelBind request
+func (m *Metrics) ChannelBound() {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.channelBinds, 1)
+}
+
+// NonceRotated counts a rotation of the nonce key
+func (m *Metrics) NonceRotated() {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.nonceRotations, 1)
+}
+
+// StaleNonce counts a request rejected because its nonce expired
+func (m *Metrics) StaleNonce() {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.staleNonces, 1)
+}
+
+// ErrorResponse counts an error response with code
+func (m *Metrics) ErrorResponse(code int) {
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.errorResponses[code]++
+}
+
+// SendBufferDrop counts a datagram relayed in direction that was dropped because the send
+// buffer of the socket was full
+func (m *Metrics) SendBufferDrop(direction Direction) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.sendBufferDrops[direction], 1)
+}
+
+// OversizedDrop counts a datagram relayed in direction that was dropped because it exceeded
+// the maximum packet size of its allocation
+func (m *Metrics) OversizedDrop(direction Direction) {
+	if m == nil {
+		return
+	}
+
+	atomic.AddUint64(&m.oversizedDrops[direction], 1)
+}
+
+// Snapshot returns the current values
+func (m *Metrics) Snapshot() Snapshot {
+	s := Snapshot{ErrorResponses: map[int]uint64{}}
+	if m == nil {
+		return s
+	}
+
+	s.AllocationsCreated = atomic.LoadUint64(&m.allocationsCreated)
+	s.AllocationsActive = atomic.LoadInt64(&m.allocationsActive)
+	for _, d := range []Direction{DirectionToPeer, DirectionToClient} {
+		s.RelayedBytes[d] = atomic.LoadUint64(&m.relayedBytes[d])
+		s.RelayedPackets[d] = atomic.LoadUint64(&m.relayedPackets[d])
+		s.SendBufferDrops[d] = atomic.LoadUint64(&m.sendBufferDrops[d])
+		s.OversizedDrops[d] = atomic.LoadUint64(&m.oversizedDrops[d])
+		s.RelayedIndications[d] = atomic.LoadUint64(&m.relayedIndications[d])
+	}
+	s.AuthFailures = atomic.LoadUint64(&m.authFailures)
+	s.ChannelBinds = atomic.LoadUint64(&m.channelBinds)
+	s.NonceRotations = atomic.LoadUint64(&m.nonceRotations)
+	s.StaleNonces = atomic.LoadUint64(&m.staleNonces)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for code, count := range m.errorResponses {
+		s.ErrorResponses[code] = count
+	}
+
+	return s
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	s := m.Snapshot()
+	b := bufio.NewWriter(w)
+
+	writeHeader := func(name, help, kind string) {
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	writeHeader("turn_allocations_created_total", "Number of allocations created.", "counter")
+	fmt.Fprintf(b, "turn_allocations_created_total %d\n", s.AllocationsCreated)
+
+	writeHeader("turn_allocations_active", "Number of existing allocations.", "gauge")
+	fmt.Fprintf(b, "turn_allocations_active %d\n", s.AllocationsActive)
+
+	writeHeader("turn_relayed_bytes_total", "Number of bytes relayed.", "counter")
+	for _, d := range []Direction{DirectionToPeer, DirectionToClient} {
+		fmt.Fprintf(b, "turn_relayed_bytes_total{direction=%q} %d\n", d, s.RelayedBytes[d])
+	}
+
+	writeHeader("turn_relayed_packets_total", "Number of datagrams relayed.", "counter")
+	for _, d := range []Direction{DirectionToPeer, DirectionToClient} {
+		fmt.Fprintf(b, "turn_relayed_packets_total{direction=%q} %d\n", d, s.RelayedPackets[d])
+	}
+
+	writeHeader("turn_relayed_indications_total", "Number of datagrams relayed in Send or Data indications instead of ChannelData.", "counter")
+	for _, d := range []Direction{DirectionToPeer, DirectionToClient} {
+		fmt.Fprintf(b, "turn_relayed_indications_total{direction=%q} %d\n", d, s.RelayedIndications[d])
+	}
+
+	writeHeader("turn_send_buffer_drops_total", "Number of relayed datagrams dropped because the send buffer was full.", "counter")
+	for _, d := range []Direction{DirectionToPeer, DirectionToClient} {
+		fmt.Fprintf(b, "turn_send_buffer_drops_total{direction=%q} %d\n", d, s.SendBufferDrops[d])
+	}
+
+	writeHeader("turn_oversized_drops_total", "Number of relayed datagrams dropped because they exceeded the maximum packet size.", "counter")
+	for _, d := range []Direction{DirectionToPeer, DirectionToClient} {
+		fmt.Fprintf(b, "turn_oversized_drops_total{direction=%q} %d\n", d, s.OversizedDrops[d])
+	}
+
+	writeHeader("turn_auth_failures_total", "Number of requests that failed authentication.", "counter")
+	fmt.Fprintf(b, "turn_auth_failures_total %d\n", s.AuthFailures)
+
+	writeHeader("turn_channel_binds_total", "Number of successful ChannelBind requests.", "counter")
+	fmt.Fprintf(b, "turn_channel_binds_total %d\n", s.ChannelBinds)
+
+	writeHeader("turn_nonce_rotations_total", "Number of rotations of the nonce key.", "counter")
+	fmt.Fprintf(b, "turn_nonce_rotations_total %d\n", s.NonceRotations)
+
+	writeHeader("turn_stale_nonces_total", "Number of requests rejected with a stale nonce.", "counter")
+	fmt.Fprintf(b, "turn_stale_nonces_total %d\n", s.StaleNonces)
+
+	writeHeader("turn_error_responses_total", "Number of error responses sent by error code.", "counter")
+	codes := make([]int, 0, len(s.ErrorResponses))
+	for code := range s.ErrorResponses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(b, "turn_error_responses_total{code=\"%d\"} %d\n", code, s.ErrorResponses[code])
+	}
+
+	return b.Flush()
+}
+
+// ServeHTTP serves the metrics to Prometheus scrapes
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = m.WritePrometheus(w)
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Nil", func(t *testing.T) {
+		var m *Metrics
+		m.AllocationCreated()
+		m.Relayed(DirectionToPeer, 100)
+		m.ErrorResponse(401)
+		assert.Equal(t, Snapshot{ErrorResponses: map[int]uint64{}}, m.Snapshot())
+	})
+
+	t.Run("Snapshot", func(t *testing.T) {
+		m := New()
+		m.AllocationCreated()
+		m.AllocationCreated()
+		m.AllocationDeleted()
+		m.Relayed(DirectionToPeer, 100)
+		m.Relayed(DirectionToClient, 50)
+		m.RelayedIndication(DirectionToClient)
+		m.RelayedStream(DirectionToClient, 10)
+		m.AuthFailure()
+		m.ChannelBound()
+		m.NonceRotated()
+		m.StaleNonce()
+		m.StaleNonce()
+		m.ErrorResponse(401)
+		m.ErrorResponse(401)
+		m.ErrorResponse(437)
+		m.SendBufferDrop(DirectionToPeer)
+		m.OversizedDrop(DirectionToClient)
+
+		assert.Equal(t, Snapshot{
+			AllocationsCreated: 2,
+			AllocationsActive:  1,
+			RelayedBytes:       [2]uint64{100, 60},
+			RelayedPackets:     [2]uint64{1, 1},
+			AuthFailures:       1,
+			ChannelBinds:       1,
+			NonceRotations:     1,
+			StaleNonces:        2,
+			ErrorResponses:     map[int]uint64{401: 2, 437: 1},
+			SendBufferDrops:    [2]uint64{1, 0},
+			OversizedDrops:     [2]uint64{0, 1},
+			RelayedIndications: [2]uint64{0, 1},
+		}, m.Snapshot())
+	})
+
+	t.Run("Prometheus", func(t *testing.T) {
+		m := New()
+		m.AllocationCreated()
+		m.Relayed(DirectionToClient, 50)
+		m.ErrorResponse(437)
+		m.ErrorResponse(401)
+
+		var b bytes.Buffer
+		assert.NoError(t, m.WritePrometheus(&b))
+		for _, line := range []string{
+			"# TYPE turn_allocations_created_total counter",
+			"turn_allocations_created_total 1",
+			"# TYPE turn_allocations_active gauge",
+			"turn_allocations_active 1",
+			`turn_relayed_bytes_total{direction="to_peer"} 0`,
+			`turn_relayed_bytes_total{direction="to_client"} 50`,
+			`turn_relayed_packets_total{direction="to_client"} 1`,
+			`turn_relayed_indications_total{direction="to_peer"} 0`,
+			`turn_send_buffer_drops_total{direction="to_peer"} 0`,
+			`turn_oversized_drops_total{direction="to_client"} 0`,
+			"turn_auth_failures_total 0",
+			"turn_channel_binds_total 0",
+			"turn_nonce_rotations_total 0",
+			"turn_stale_nonces_total 0",
+			"turn_error_responses_total{code=\"401\"} 1\nturn_error_responses_total{code=\"437\"} 1",
+		} {
+			assert.Contains(t, b.String(), line)
+		}
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		assert.True(t, strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain"))
+		assert.Equal(t, b.String(), rec.Body.String())
+	})
+}
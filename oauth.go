@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+// accessTokenFractions is the resolution of the fraction of a second in the timestamp of
+// an access token, RFC 7635 Section 6.2
+const accessTokenFractions = 64000
+
+// AccessTokenHandler validates the RFC 7635 ACCESS-TOKEN of a request and returns the
+// mac_key it carries. kid is the USERNAME of the request, which identifies the key the
+// token is encrypted with. See NewAccessTokenHandler for tokens encrypted with AES-GCM.
+type AccessTokenHandler func(kid, realm string, token []byte, srcAddr net.Addr) (macKey []byte, ok bool)
+
+// AccessToken is the content of an RFC 7635 self-contained access token, as issued by the
+// authorization server to a client along with the mac_key and kid
+type AccessToken struct {
+	// MACKey is the key the client computes MESSAGE-INTEGRITY with
+	MACKey []byte
+	// Timestamp is the time the token was issued at
+	Timestamp time.Time
+	// Lifetime is how long after Timestamp the token is valid
+	Lifetime time.Duration
+}
+
+// OAuthCredentials are third-party authorization credentials for the Client, see
+// ClientConfig.OAuth
+type OAuthCredentials struct {
+	// KeyID is the kid of the token, sent as USERNAME
+	KeyID string
+	// AccessToken is the encrypted token, sent as ACCESS-TOKEN
+	AccessToken []byte
+	// MACKey is the mac_key of the token
+	MACKey []byte
+}
+
+// EncryptAccessToken encrypts token with AES-GCM for a STUN server named serverName. key is
+// the long-term key the authorization server shares with the STUN server and must be 16 or
+// 32 bytes long, for AES-128-GCM and AES-256-GCM. The result is the value of ACCESS-TOKEN.
+func EncryptAccessToken(key []byte, serverName string, token AccessToken) ([]byte, error) {
+	aead, err := newAccessTokenAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	seconds := uint64(token.Timestamp.Unix())
+	fraction := uint64(token.Timestamp.Nanosecond()) * accessTokenFractions / uint64(time.Second)
+
+	plaintext := make([]byte, 2, 2+len(token.MACKey)+12)
+	binary.BigEndian.PutUint16(plaintext, uint16(len(token.MACKey)))
+	plaintext = append(plaintext, token.MACKey...)
+	plaintext = append(plaintext, make([]byte, 12)...)
+	binary.BigEndian.PutUint64(plaintext[2+len(token.MACKey):], seconds<<16|fraction)
+	binary.BigEndian.PutUint32(plaintext[10+len(token.MACKey):], uint32(token.Lifetime/time.Second))
+
+	out := make([]byte, 2, 2+len(nonce)+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(nonce)))
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, plaintext, []byte(serverName)), nil
+}
+
+// DecryptAccessToken decrypts an ACCESS-TOKEN encrypted with EncryptAccessToken
+func DecryptAccessToken(key []byte, serverName string, b []byte) (AccessToken, error) {
+	aead, err := newAccessTokenAEAD(key)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	if len(b) < 2 {
+		return AccessToken{}, errAccessTokenInvalid
+	}
+	nonceLength := int(binary.BigEndian.Uint16(b))
+	if nonceLength != aead.NonceSize() || len(b) < 2+nonceLength {
+		return AccessToken{}, errAccessTokenInvalid
+	}
+
+	plaintext, err := aead.Open(nil, b[2:2+nonceLength], b[2+nonceLength:], []byte(serverName))
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	if len(plaintext) < 2 {
+		return AccessToken{}, errAccessTokenInvalid
+	}
+	keyLength := int(binary.BigEndian.Uint16(plaintext))
+	if len(plaintext) != 2+keyLength+12 {
+		return AccessToken{}, errAccessTokenInvalid
+	}
+
+	timestamp := binary.BigEndian.Uint64(plaintext[2+keyLength:])
+	fraction := time.Duration(timestamp&0xffff) * time.Second / accessTokenFractions
+
+	return AccessToken{
+		MACKey:    append([]byte{}, plaintext[2:2+keyLength]...),
+		Timestamp: time.Unix(int64(timestamp>>16), 0).Add(fraction),
+		Lifetime:  time.Duration(binary.BigEndian.Uint32(plaintext[10+keyLength:])) * time.Second,
+	}, nil
+}
+
+// NewAccessTokenHandler returns an AccessTokenHandler for tokens encrypted with
+// EncryptAccessToken. keys returns the key shared with the authorization server for a kid.
+// Tokens are accepted until their Lifetime ends.
+func NewAccessTokenHandler(keys func(kid string) (key []byte, ok bool), serverName string, l logging.LeveledLogger) AccessTokenHandler {
+	if l == nil {
+		l = logging.NewDefaultLoggerFactory().NewLogger("turn")
+	}
+	return func(kid, realm string, b []byte, srcAddr net.Addr) (macKey []byte, ok bool) {
+		l.Tracef("Access token authentication kid=%q realm=%q srcAddr=%v", kid, realm, srcAddr)
+		key, ok := keys(kid)
+		if !ok {
+			l.Errorf("Unknown access token kid %q", kid)
+			return nil, false
+		}
+		token, err := DecryptAccessToken(key, serverName, b)
+		if err != nil {
+			l.Errorf("Invalid access token with kid %q: %s", kid, err)
+			return nil, false
+		}
+		if !time.Now().Before(token.Timestamp.Add(token.Lifetime)) {
+			l.Errorf("Expired access token with kid %q", kid)
+			return nil, false
+		}
+		return token.MACKey, true
+	}
+}
+
+func newAccessTokenAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, errAccessTokenKeyInvalid
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
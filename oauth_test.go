@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessTokenEncryption(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	token := AccessToken{
+		MACKey:    []byte("mac key"),
+		Timestamp: time.Unix(1700000000, 500000000),
+		Lifetime:  time.Hour,
+	}
+
+	encrypted, err := EncryptAccessToken(key, "turn.pion.ly", token)
+	require.NoError(t, err)
+
+	decrypted, err := DecryptAccessToken(key, "turn.pion.ly", encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, token.MACKey, decrypted.MACKey)
+	assert.True(t, token.Timestamp.Equal(decrypted.Timestamp))
+	assert.Equal(t, token.Lifetime, decrypted.Lifetime)
+
+	_, err = DecryptAccessToken(key, "other.pion.ly", encrypted)
+	assert.Error(t, err)
+	_, err = DecryptAccessToken([]byte("fedcba9876543210"), "turn.pion.ly", encrypted)
+	assert.Error(t, err)
+	_, err = DecryptAccessToken(key, "turn.pion.ly", encrypted[:10])
+	assert.Error(t, err)
+	_, err = EncryptAccessToken([]byte("short"), "turn.pion.ly", token)
+	assert.ErrorIs(t, err, errAccessTokenKeyInvalid)
+}
+
+func TestServerOAuth(t *testing.T) {
+	const serverName = "turn.pion.ly"
+	sharedKey := []byte("0123456789abcdef0123456789abcdef")
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+	server, err := NewServer(ServerConfig{
+		AccessTokenHandler: NewAccessTokenHandler(func(kid string) ([]byte, bool) {
+			return sharedKey, kid == "kid"
+		}, serverName, nil),
+		ThirdPartyAuthorization: serverName,
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:         "pion.ly",
+		LoggerFactory: loggerFactory,
+	})
+	require.NoError(t, err)
+
+	newClient := func(oauth *OAuthCredentials) (*Client, net.PacketConn) {
+		conn, listenErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, listenErr)
+
+		client, clientErr := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			OAuth:          oauth,
+			LoggerFactory:  loggerFactory,
+		})
+		require.NoError(t, clientErr)
+		require.NoError(t, client.Listen())
+
+		return client, conn
+	}
+
+	macKey := []byte("mac key")
+	accessToken, err := EncryptAccessToken(sharedKey, serverName, AccessToken{
+		MACKey:    macKey,
+		Timestamp: time.Now(),
+		Lifetime:  time.Hour,
+	})
+	require.NoError(t, err)
+
+	t.Run("Valid", func(t *testing.T) {
+		client, conn := newClient(&OAuthCredentials{KeyID: "kid", AccessToken: accessToken, MACKey: macKey})
+		relayConn, allocErr := client.Allocate()
+		require.NoError(t, allocErr)
+
+		peer, listenErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, listenErr)
+
+		_, allocErr = relayConn.WriteTo([]byte("hello"), peer.LocalAddr())
+		require.NoError(t, allocErr)
+
+		buf := make([]byte, 64)
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, "hello", string(buf[:n]))
+
+		assert.NoError(t, relayConn.Close())
+		client.Close()
+		assert.NoError(t, conn.Close())
+		assert.NoError(t, peer.Close())
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expiredToken, encryptErr := EncryptAccessToken(sharedKey, serverName, AccessToken{
+			MACKey:    macKey,
+			Timestamp: time.Now().Add(-2 * time.Hour),
+			Lifetime:  time.Hour,
+		})
+		require.NoError(t, encryptErr)
+
+		client, conn := newClient(&OAuthCredentials{KeyID: "kid", AccessToken: expiredToken, MACKey: macKey})
+		_, allocErr := client.Allocate()
+		assert.True(t, HasErrorCode(allocErr, CodeUnauthorized))
+
+		client.Close()
+		assert.NoError(t, conn.Close())
+	})
+
+	t.Run("UnknownKeyID", func(t *testing.T) {
+		client, conn := newClient(&OAuthCredentials{KeyID: "other", AccessToken: accessToken, MACKey: macKey})
+		_, allocErr := client.Allocate()
+		assert.True(t, HasErrorCode(allocErr, CodeUnauthorized))
+
+		client.Close()
+		assert.NoError(t, conn.Close())
+	})
+
+	assert.NoError(t, server.Close())
+}
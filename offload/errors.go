@@ -0,0 +1,16 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package offload
+
+import "errors"
+
+var (
+	// ErrRouteUnsupported is returned by Offloader.Add for routes that can't be forwarded,
+	// e.g. of IPv6 or unspecified addresses for XDP
+	ErrRouteUnsupported = errors.New("offload: route unsupported")
+	// ErrXDPUnsupported is returned by NewXDP on other platforms than Linux
+	ErrXDPUnsupported = errors.New("offload: XDP is only supported on Linux")
+	// ErrClosed is returned by XDP.Add and XDP.Remove after Close
+	ErrClosed = errors.New("offload: offloader closed")
+)
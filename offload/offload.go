@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package offload forwards the ChannelData of established channel bindings outside of the
+// TURN server, e.g. in the kernel with XDP, so the server only handles control traffic
+// and the flows without a channel. Set ServerConfig.ChannelOffload to an Offloader, the
+// server adds a Route when a UDP allocation binds a channel and removes it when the
+// binding expires or the allocation is deleted. A Table exports the routes to forwarders
+// in other processes, e.g. DPDK or eBPF forwarders.
+//
+// Offloaded traffic bypasses the server: it isn't counted by Metrics and isn't subject to
+// BandwidthLimit and FairScheduler.
+package offload
+
+import (
+	"fmt"
+	"net"
+)
+
+// Route is the forwarding of a channel binding of a UDP allocation. ChannelData sent by
+// Client to Server on Channel is sent to Peer from Relay without the ChannelData header,
+// and datagrams sent by Peer to Relay are sent to Client from Server as ChannelData.
+type Route struct {
+	// Client is the address of the client, Server the address of the listener it sends to
+	Client *net.UDPAddr
+	Server *net.UDPAddr
+
+	// Relay is the local address of the relay socket, Peer the address of the peer the
+	// channel is bound to
+	Relay *net.UDPAddr
+	Peer  *net.UDPAddr
+
+	// Channel is the number of the channel
+	Channel uint16
+}
+
+func (r Route) String() string {
+	return fmt.Sprintf("%v->%v channel 0x%x %v->%v", r.Client, r.Server, r.Channel, r.Relay, r.Peer)
+}
+
+// Offloader forwards the ChannelData of routes outside of the server
+type Offloader interface {
+	// Add starts forwarding route. Routes the Offloader can't forward are rejected with
+	// an error wrapping ErrRouteUnsupported, the server relays their traffic itself
+	Add(route Route) error
+
+	// Remove stops forwarding route
+	Remove(route Route) error
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package offload
+
+import (
+	"sync"
+)
+
+// RouteEvent is a Route added to or removed from a Table
+type RouteEvent struct {
+	Route   Route
+	Removed bool
+}
+
+// routeKey identifies the Route of a channel of an allocation, as a forwarder sees its
+// ChannelData from the client
+type routeKey struct {
+	client, server string
+	channel        uint16
+}
+
+func newRouteKey(r Route) routeKey {
+	return routeKey{client: r.Client.String(), server: r.Server.String(), channel: r.Channel}
+}
+
+// Table is an Offloader that exports the channel bindings of the server, so processes
+// outside of it, e.g. DPDK or eBPF forwarders, can forward ChannelData without asking the
+// server per packet. It only keeps the routes, the server relays their traffic as long as
+// no forwarder takes over. Its methods are safe for concurrent use.
+type Table struct {
+	next Offloader
+
+	lock        sync.RWMutex
+	routes      map[routeKey]Route
+	subscribers map[int]func(RouteEvent)
+	nextID      int
+}
+
+// NewTable returns a Table that adds routes to next, if not nil, and only keeps the routes
+// next accepted, e.g. to export the routes offloaded with XDP
+func NewTable(next Offloader) *Table {
+	return &Table{
+		next:        next,
+		routes:      map[routeKey]Route{},
+		subscribers: map[int]func(RouteEvent){},
+	}
+}
+
+// Add adds route to the table and notifies the subscribers
+func (t *Table) Add(route Route) error {
+	if t.next != nil {
+		if err := t.next.Add(route); err != nil {
+			return err
+		}
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.routes[newRouteKey(route)] = route
+	t.notify(RouteEvent{Route: route})
+
+	return nil
+}
+
+// Remove removes route from the table and notifies the subscribers
+func (t *Table) Remove(route Route) error {
+	t.lock.Lock()
+	key := newRouteKey(route)
+	if _, ok := t.routes[key]; ok {
+		delete(t.routes, key)
+		t.notify(RouteEvent{Route: route, Removed: true})
+	}
+	t.lock.Unlock()
+
+	if t.next != nil {
+		return t.next.Remove(route)
+	}
+	return nil
+}
+
+// notify calls the subscribers with the table locked, so they see the events in order
+func (t *Table) notify(event RouteEvent) {
+	for _, f := range t.subscribers {
+		f(event)
+	}
+}
+
+// Routes returns a snapshot of the routes of all channel bindings
+func (t *Table) Routes() []Route {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	routes := make([]Route, 0, len(t.routes))
+	for _, route := range t.routes {
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// Lookup returns the route of the ChannelData on channel sent by client to server
+func (t *Table) Lookup(client, server string, channel uint16) (Route, bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	route, ok := t.routes[routeKey{client: client, server: server, channel: channel}]
+	return route, ok
+}
+
+// Subscribe calls f with an event for every route in the table, and then for every route
+// added or removed until unsubscribe is called. f is called with the table locked, it must
+// not block or call the Table
+func (t *Table) Subscribe(f func(RouteEvent)) (unsubscribe func()) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, route := range t.routes {
+		f(RouteEvent{Route: route})
+	}
+
+	id := t.nextID
+	t.nextID++
+	t.subscribers[id] = f
+
+	return func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+
+		delete(t.subscribers, id)
+	}
+}
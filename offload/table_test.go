@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package offload
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type rejectingOffloader struct {
+	removed int
+}
+
+func (o *rejectingOffloader) Add(Route) error {
+	return ErrRouteUnsupported
+}
+
+func (o *rejectingOffloader) Remove(Route) error {
+	o.removed++
+	return nil
+}
+
+func TestTable(t *testing.T) {
+	table := NewTable(nil)
+	route := testRoute()
+	if err := table.Add(route); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribers get the routes of the table first
+	events := []RouteEvent{}
+	unsubscribe := table.Subscribe(func(event RouteEvent) {
+		events = append(events, event)
+	})
+
+	other := testRoute()
+	other.Channel = 0x4002
+	if err := table.Add(other); err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Routes()) != 2 {
+		t.Fatalf("expected 2 routes, got %v", table.Routes())
+	}
+
+	found, ok := table.Lookup(route.Client.String(), route.Server.String(), route.Channel)
+	if !ok || !reflect.DeepEqual(found, route) {
+		t.Fatalf("unexpected route %v", found)
+	}
+	if _, ok = table.Lookup(route.Client.String(), route.Server.String(), 0x4003); ok {
+		t.Fatal("found route of unbound channel")
+	}
+
+	if err := table.Remove(route); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok = table.Lookup(route.Client.String(), route.Server.String(), route.Channel); ok {
+		t.Fatal("found removed route")
+	}
+
+	unsubscribe()
+	if err := table.Remove(other); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []RouteEvent{{Route: route}, {Route: other}, {Route: route, Removed: true}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected events %v, got %v", expected, events)
+	}
+	if len(table.Routes()) != 0 {
+		t.Fatalf("expected no routes, got %v", table.Routes())
+	}
+}
+
+func TestTableNext(t *testing.T) {
+	next := &rejectingOffloader{}
+	table := NewTable(next)
+
+	// Routes next rejects aren't kept
+	if err := table.Add(testRoute()); !errors.Is(err, ErrRouteUnsupported) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(table.Routes()) != 0 {
+		t.Fatalf("expected no routes, got %v", table.Routes())
+	}
+
+	if err := table.Remove(testRoute()); err != nil {
+		t.Fatal(err)
+	}
+	if next.removed != 1 {
+		t.Fatalf("expected 1 removal, got %d", next.removed)
+	}
+}
@@ -0,0 +1,63 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package offload
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// DefaultXDPPinPath is the directory iproute2 pins the maps of XDP programs to
+const DefaultXDPPinPath = "/sys/fs/bpf/xdp/globals"
+
+// The maps of xdp/turn_channel.c, their keys and values are the structs of the same name
+const (
+	xdpToPeerMap   = "turn_to_peer"
+	xdpToClientMap = "turn_to_client"
+
+	xdpToPeerKeySize     = 16
+	xdpToPeerValueSize   = 12
+	xdpToClientKeySize   = 12
+	xdpToClientValueSize = 16
+)
+
+// xdpEntries are the keys and values of a Route in the maps of the XDP program, with the
+// addresses, ports and channel numbers in network byte order
+type xdpEntries struct {
+	toPeerKey     [xdpToPeerKeySize]byte
+	toPeerValue   [xdpToPeerValueSize]byte
+	toClientKey   [xdpToClientKeySize]byte
+	toClientValue [xdpToClientValueSize]byte
+}
+
+// putAddrs puts the IPs x and y followed by their ports, like the structs of the maps
+func putAddrs(b []byte, x, y net.IP, xPort, yPort int) {
+	copy(b[0:4], x)
+	copy(b[4:8], y)
+	binary.BigEndian.PutUint16(b[8:10], uint16(xPort))
+	binary.BigEndian.PutUint16(b[10:12], uint16(yPort))
+}
+
+func newXDPEntries(r Route) (*xdpEntries, error) {
+	ips := [4]net.IP{}
+	for i, addr := range []*net.UDPAddr{r.Client, r.Server, r.Relay, r.Peer} {
+		if addr == nil {
+			return nil, fmt.Errorf("%w: %v", ErrRouteUnsupported, r)
+		}
+		if ips[i] = addr.IP.To4(); ips[i] == nil || ips[i].IsUnspecified() {
+			return nil, fmt.Errorf("%w: %v", ErrRouteUnsupported, r)
+		}
+	}
+	client, server, relay, peer := ips[0], ips[1], ips[2], ips[3]
+
+	e := &xdpEntries{}
+	putAddrs(e.toPeerKey[:], client, server, r.Client.Port, r.Server.Port)
+	binary.BigEndian.PutUint16(e.toPeerKey[12:14], r.Channel)
+	putAddrs(e.toPeerValue[:], relay, peer, r.Relay.Port, r.Peer.Port)
+	putAddrs(e.toClientKey[:], peer, relay, r.Peer.Port, r.Relay.Port)
+	putAddrs(e.toClientValue[:], server, client, r.Server.Port, r.Client.Port)
+	binary.BigEndian.PutUint16(e.toClientValue[12:14], r.Channel)
+	return e, nil
+}
@@ -0,0 +1,271 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// turn_channel forwards the ChannelData of the channel bindings installed by offload.XDP
+// in its maps. ChannelData from a client to the server is sent to the peer from the relay
+// without the ChannelData header, and datagrams from a peer to the relay are sent to the
+// client from the server as ChannelData. Everything else, e.g. STUN, IPv6, IP options and
+// fragments, is passed to the stack and handled by the server.
+//
+// Build it with libbpf headers and attach it to the interfaces of the listeners and
+// relays with iproute2, which pins its maps to /sys/fs/bpf/xdp/globals:
+//
+//   clang -O2 -g -target bpf -c turn_channel.c -o turn_channel.o
+//   ip link set dev eth0 xdp obj turn_channel.o sec xdp
+
+#include <linux/bpf.h>
+#include <linux/if_ether.h>
+#include <linux/in.h>
+#include <linux/ip.h>
+#include <linux/udp.h>
+
+#include <bpf/bpf_endian.h>
+#include <bpf/bpf_helpers.h>
+
+#ifndef AF_INET
+#define AF_INET 2
+#endif
+
+#define MAX_ROUTES 65536
+#define CHANNEL_DATA_HEADER_SIZE 4
+#define RELAY_TTL 64
+
+// The keys and values of the maps, see offload/xdp.go. Everything is in network byte
+// order.
+struct to_peer_key {
+	__be32 client_ip;
+	__be32 server_ip;
+	__be16 client_port;
+	__be16 server_port;
+	__be16 channel;
+	__u16 pad;
+};
+
+struct to_peer_value {
+	__be32 relay_ip;
+	__be32 peer_ip;
+	__be16 relay_port;
+	__be16 peer_port;
+};
+
+struct to_client_key {
+	__be32 peer_ip;
+	__be32 relay_ip;
+	__be16 peer_port;
+	__be16 relay_port;
+};
+
+struct to_client_value {
+	__be32 server_ip;
+	__be32 client_ip;
+	__be16 server_port;
+	__be16 client_port;
+	__be16 channel;
+	__u16 pad;
+};
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, MAX_ROUTES);
+	__type(key, struct to_peer_key);
+	__type(value, struct to_peer_value);
+	__uint(pinning, LIBBPF_PIN_BY_NAME);
+} turn_to_peer SEC(".maps");
+
+struct {
+	__uint(type, BPF_MAP_TYPE_HASH);
+	__uint(max_entries, MAX_ROUTES);
+	__type(key, struct to_client_key);
+	__type(value, struct to_client_value);
+	__uint(pinning, LIBBPF_PIN_BY_NAME);
+} turn_to_client SEC(".maps");
+
+// headers are the headers of the datagrams, moved when the ChannelData header is
+// removed or inserted
+struct headers {
+	struct ethhdr eth;
+	struct iphdr ip;
+	struct udphdr udp;
+} __attribute__((packed));
+
+static __always_inline __u16 csum_fold(__u32 csum)
+{
+	csum = (csum & 0xffff) + (csum >> 16);
+	csum = (csum & 0xffff) + (csum >> 16);
+	return (__u16)~csum;
+}
+
+// rewrite sets the addresses of the headers, UDP checksums are optional over IPv4
+static __always_inline void rewrite(struct headers *h, __be32 saddr, __be32 daddr,
+				    __be16 source, __be16 dest, __u16 payload)
+{
+	__u32 csum = 0;
+	__u16 *words = (__u16 *)&h->ip;
+
+	h->ip.saddr = saddr;
+	h->ip.daddr = daddr;
+	h->ip.ttl = RELAY_TTL;
+	h->ip.tot_len = bpf_htons(sizeof(struct iphdr) + sizeof(struct udphdr) + payload);
+	h->ip.check = 0;
+#pragma unroll
+	for (int i = 0; i < sizeof(struct iphdr) / 2; i++)
+		csum += words[i];
+	h->ip.check = csum_fold(csum);
+
+	h->udp.source = source;
+	h->udp.dest = dest;
+	h->udp.len = bpf_htons(sizeof(struct udphdr) + payload);
+	h->udp.check = 0;
+}
+
+// route looks up the next hop of daddr and sets the MAC addresses of the headers to it. It
+// returns the interface of the next hop, or -1 if the stack has to resolve it
+static __always_inline int route(struct xdp_md *ctx, struct headers *h, __be32 saddr,
+				 __be32 daddr, __u16 payload)
+{
+	struct bpf_fib_lookup fib = {};
+	int ret;
+
+	fib.family = AF_INET;
+	fib.l4_protocol = IPPROTO_UDP;
+	fib.tot_len = sizeof(struct iphdr) + sizeof(struct udphdr) + payload;
+	fib.ipv4_src = saddr;
+	fib.ipv4_dst = daddr;
+	fib.ifindex = ctx->ingress_ifindex;
+
+	ret = bpf_fib_lookup(ctx, &fib, sizeof(fib), 0);
+	if (ret != BPF_FIB_LKUP_RET_SUCCESS)
+		return -1;
+
+	__builtin_memcpy(h->eth.h_dest, fib.dmac, ETH_ALEN);
+	__builtin_memcpy(h->eth.h_source, fib.smac, ETH_ALEN);
+	return fib.ifindex;
+}
+
+static __always_inline int redirect(struct xdp_md *ctx, int ifindex)
+{
+	if (ifindex == ctx->ingress_ifindex)
+		return XDP_TX;
+	return bpf_redirect(ifindex, 0);
+}
+
+static __always_inline int to_peer(struct xdp_md *ctx, struct headers *h, __u8 *payload,
+				   void *data_end)
+{
+	struct to_peer_key key = {};
+	struct to_peer_value *value;
+	__u16 length;
+	int ifindex;
+
+	if ((void *)(payload + CHANNEL_DATA_HEADER_SIZE) > data_end)
+		return XDP_PASS;
+
+	key.client_ip = h->ip.saddr;
+	key.server_ip = h->ip.daddr;
+	key.client_port = h->udp.source;
+	key.server_port = h->udp.dest;
+	key.channel = *(__be16 *)payload;
+	value = bpf_map_lookup_elem(&turn_to_peer, &key);
+	if (!value)
+		return XDP_PASS;
+
+	length = bpf_ntohs(*(__be16 *)(payload + 2));
+	if ((void *)(payload + CHANNEL_DATA_HEADER_SIZE + length) > data_end)
+		return XDP_PASS;
+
+	struct headers moved = *h;
+	ifindex = route(ctx, &moved, value->relay_ip, value->peer_ip, length);
+	if (ifindex < 0)
+		return XDP_PASS;
+	rewrite(&moved, value->relay_ip, value->peer_ip, value->relay_port, value->peer_port,
+		length);
+
+	// The headers are moved over the ChannelData header. The padding is left after the
+	// datagram, it is trimmed by the IP length like the padding of Ethernet frames
+	if (bpf_xdp_adjust_head(ctx, CHANNEL_DATA_HEADER_SIZE))
+		return XDP_DROP;
+
+	void *data = (void *)(long)ctx->data;
+	if (data + sizeof(struct headers) > (void *)(long)ctx->data_end)
+		return XDP_DROP;
+	__builtin_memcpy(data, &moved, sizeof(struct headers));
+
+	return redirect(ctx, ifindex);
+}
+
+static __always_inline int to_client(struct xdp_md *ctx, struct headers *h)
+{
+	struct to_client_key key = {};
+	struct to_client_value *value;
+	__u16 length;
+	int ifindex;
+
+	key.peer_ip = h->ip.saddr;
+	key.relay_ip = h->ip.daddr;
+	key.peer_port = h->udp.source;
+	key.relay_port = h->udp.dest;
+	value = bpf_map_lookup_elem(&turn_to_client, &key);
+	if (!value)
+		return XDP_PASS;
+
+	length = bpf_ntohs(h->udp.len);
+	if (length < sizeof(struct udphdr))
+		return XDP_PASS;
+	length -= sizeof(struct udphdr);
+
+	struct headers moved = *h;
+	__be16 channel = value->channel;
+	ifindex = route(ctx, &moved, value->server_ip, value->client_ip,
+			CHANNEL_DATA_HEADER_SIZE + length);
+	if (ifindex < 0)
+		return XDP_PASS;
+	rewrite(&moved, value->server_ip, value->client_ip, value->server_port,
+		value->client_port, CHANNEL_DATA_HEADER_SIZE + length);
+
+	// The headers are moved to make room for the ChannelData header. Padding is optional
+	// over UDP, RFC 8656 Section 12.5
+	if (bpf_xdp_adjust_head(ctx, -CHANNEL_DATA_HEADER_SIZE))
+		return XDP_DROP;
+
+	void *data = (void *)(long)ctx->data;
+	if (data + sizeof(struct headers) + CHANNEL_DATA_HEADER_SIZE > (void *)(long)ctx->data_end)
+		return XDP_DROP;
+	__builtin_memcpy(data, &moved, sizeof(struct headers));
+
+	__be16 *header = data + sizeof(struct headers);
+	header[0] = channel;
+	header[1] = bpf_htons(length);
+
+	return redirect(ctx, ifindex);
+}
+
+SEC("xdp")
+int turn_channel(struct xdp_md *ctx)
+{
+	void *data = (void *)(long)ctx->data;
+	void *data_end = (void *)(long)ctx->data_end;
+	struct headers *h = data;
+
+	if ((void *)(h + 1) > data_end)
+		return XDP_PASS;
+	if (h->eth.h_proto != bpf_htons(ETH_P_IP) || h->ip.ihl != 5 ||
+	    h->ip.protocol != IPPROTO_UDP)
+		return XDP_PASS;
+	// Fragments are reassembled by the stack
+	if (h->ip.frag_off & bpf_htons(0x3fff))
+		return XDP_PASS;
+
+	__u8 *payload = (__u8 *)(h + 1);
+	if ((void *)(payload + CHANNEL_DATA_HEADER_SIZE) <= data_end &&
+	    (payload[0] & 0xc0) == 0x40) {
+		int action = to_peer(ctx, h, payload, data_end);
+		if (action != XDP_PASS)
+			return action;
+
+		// to_peer only adjusts the head if it doesn't pass, so h is still valid
+	}
+
+	return to_client(ctx, h);
+}
+
+char _license[] SEC("license") = "Dual MIT/GPL";
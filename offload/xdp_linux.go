@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build linux
+// +build linux
+
+package offload
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// XDP is an Offloader forwarding ChannelData with the XDP program xdp/turn_channel.c, see
+// the comment at its top on building and attaching it. Routes are installed in the maps
+// the program is pinned with. Only routes of IPv4 addresses are forwarded, so listeners
+// and relays must be bound to specific IPv4 addresses to be offloaded.
+type XDP struct {
+	lock     sync.Mutex
+	toPeer   int
+	toClient int
+	closed   bool
+}
+
+// NewXDP opens the maps of the XDP program pinned in the directory pinPath,
+// DefaultXDPPinPath if empty. It needs CAP_BPF or CAP_SYS_ADMIN
+func NewXDP(pinPath string) (*XDP, error) {
+	if pinPath == "" {
+		pinPath = DefaultXDPPinPath
+	}
+
+	toPeer, err := bpfObjGet(filepath.Join(pinPath, xdpToPeerMap))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s map: %w", xdpToPeerMap, err)
+	}
+	toClient, err := bpfObjGet(filepath.Join(pinPath, xdpToClientMap))
+	if err != nil {
+		_ = unix.Close(toPeer)
+		return nil, fmt.Errorf("failed to open %s map: %w", xdpToClientMap, err)
+	}
+
+	return &XDP{toPeer: toPeer, toClient: toClient}, nil
+}
+
+// Add installs route in both maps
+func (x *XDP) Add(route Route) error {
+	e, err := newXDPEntries(route)
+	if err != nil {
+		return err
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if x.closed {
+		return ErrClosed
+	}
+	if err = bpfMapUpdate(x.toPeer, e.toPeerKey[:], e.toPeerValue[:]); err != nil {
+		return fmt.Errorf("failed to add %v: %w", route, err)
+	}
+	if err = bpfMapUpdate(x.toClient, e.toClientKey[:], e.toClientValue[:]); err != nil {
+		_ = bpfMapDelete(x.toPeer, e.toPeerKey[:])
+		return fmt.Errorf("failed to add %v: %w", route, err)
+	}
+	return nil
+}
+
+// Remove deletes route from both maps
+func (x *XDP) Remove(route Route) error {
+	e, err := newXDPEntries(route)
+	if err != nil {
+		return err
+	}
+
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if x.closed {
+		return ErrClosed
+	}
+	for _, entry := range []struct {
+		fd  int
+		key []byte
+	}{{x.toPeer, e.toPeerKey[:]}, {x.toClient, e.toClientKey[:]}} {
+		if err = bpfMapDelete(entry.fd, entry.key); err != nil && !errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("failed to remove %v: %w", route, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the maps. The routes stay installed until the program is detached
+func (x *XDP) Close() error {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+
+	if x.closed {
+		return nil
+	}
+	x.closed = true
+
+	err := unix.Close(x.toPeer)
+	if closeErr := unix.Close(x.toClient); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// bpfMapElemAttr is the union bpf_attr of the BPF_MAP_*_ELEM commands
+type bpfMapElemAttr struct {
+	mapFD uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+// bpfObjGetAttr is the union bpf_attr of BPF_OBJ_GET
+type bpfObjGetAttr struct {
+	pathname  uint64
+	bpfFD     uint32
+	fileFlags uint32
+}
+
+func bpf(cmd uintptr, attr unsafe.Pointer, size uintptr) (int, error) {
+	r, _, errno := unix.Syscall(unix.SYS_BPF, cmd, uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(r), nil
+}
+
+func bpfObjGet(path string) (int, error) {
+	pathname, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	attr := bpfObjGetAttr{pathname: uint64(uintptr(unsafe.Pointer(pathname)))}
+	fd, err := bpf(unix.BPF_OBJ_GET, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(pathname)
+	return fd, err
+}
+
+func bpfMapUpdate(fd int, key, value []byte) error {
+	attr := bpfMapElemAttr{
+		mapFD: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+		flags: unix.BPF_ANY,
+	}
+	_, err := bpf(unix.BPF_MAP_UPDATE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	return err
+}
+
+func bpfMapDelete(fd int, key []byte) error {
+	attr := bpfMapElemAttr{
+		mapFD: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+	}
+	_, err := bpf(unix.BPF_MAP_DELETE_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	return err
+}
@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build linux
+// +build linux
+
+package offload
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpfMapCreateAttr is the union bpf_attr of BPF_MAP_CREATE
+type bpfMapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+}
+
+func createHashMap(t *testing.T, keySize, valueSize int) int {
+	attr := bpfMapCreateAttr{
+		mapType:    unix.BPF_MAP_TYPE_HASH,
+		keySize:    uint32(keySize),
+		valueSize:  uint32(valueSize),
+		maxEntries: 16,
+	}
+	fd, err := bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		t.Skipf("Failed to create BPF map, missing CAP_BPF? %v", err)
+	}
+	return fd
+}
+
+func lookup(fd int, key []byte, valueSize int) ([]byte, error) {
+	value := make([]byte, valueSize)
+	attr := bpfMapElemAttr{
+		mapFD: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+	}
+	_, err := bpf(unix.BPF_MAP_LOOKUP_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	return value, err
+}
+
+func TestXDP(t *testing.T) {
+	if _, err := NewXDP(t.TempDir()); err == nil {
+		t.Error("Expected an error without pinned maps")
+	}
+
+	x := &XDP{
+		toPeer:   createHashMap(t, xdpToPeerKeySize, xdpToPeerValueSize),
+		toClient: createHashMap(t, xdpToClientKeySize, xdpToClientValueSize),
+	}
+	route := testRoute()
+	e, err := newXDPEntries(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = x.Add(route); err != nil {
+		t.Fatal(err)
+	}
+	if value, lookupErr := lookup(x.toPeer, e.toPeerKey[:], xdpToPeerValueSize); lookupErr != nil || !bytes.Equal(value, e.toPeerValue[:]) {
+		t.Errorf("Unexpected to_peer value %x: %v", value, lookupErr)
+	}
+	if value, lookupErr := lookup(x.toClient, e.toClientKey[:], xdpToClientValueSize); lookupErr != nil || !bytes.Equal(value, e.toClientValue[:]) {
+		t.Errorf("Unexpected to_client value %x: %v", value, lookupErr)
+	}
+
+	// Removing is idempotent
+	for i := 0; i < 2; i++ {
+		if err = x.Remove(route); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = lookup(x.toPeer, e.toPeerKey[:], xdpToPeerValueSize); !errors.Is(err, unix.ENOENT) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	if err = x.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = x.Add(route); !errors.Is(err, ErrClosed) {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package offload
+
+// XDP is an Offloader forwarding ChannelData with XDP, it is only supported on Linux
+type XDP struct{}
+
+// NewXDP returns ErrXDPUnsupported
+func NewXDP(string) (*XDP, error) {
+	return nil, ErrXDPUnsupported
+}
+
+// Add returns ErrXDPUnsupported
+func (x *XDP) Add(Route) error {
+	return ErrXDPUnsupported
+}
+
+// Remove returns ErrXDPUnsupported
+func (x *XDP) Remove(Route) error {
+	return ErrXDPUnsupported
+}
+
+// Close does nothing
+func (x *XDP) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package offload
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+)
+
+func testRoute() Route {
+	return Route{
+		Client:  &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000},
+		Server:  &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3478},
+		Relay:   &net.UDPAddr{IP: net.ParseIP("198.51.100.1"), Port: 50000},
+		Peer:    &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 2000},
+		Channel: 0x4001,
+	}
+}
+
+func TestXDPEntries(t *testing.T) {
+	e, err := newXDPEntries(testRoute())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The structs of xdp/turn_channel.c, in network byte order
+	for _, tc := range []struct {
+		name     string
+		entry    []byte
+		expected string
+	}{
+		{"to_peer_key", e.toPeerKey[:], "c0000201" + "c6336401" + "03e8" + "0d96" + "4001" + "0000"},
+		{"to_peer_value", e.toPeerValue[:], "c6336401" + "cb007101" + "c350" + "07d0"},
+		{"to_client_key", e.toClientKey[:], "cb007101" + "c6336401" + "07d0" + "c350"},
+		{"to_client_value", e.toClientValue[:], "c6336401" + "c0000201" + "0d96" + "03e8" + "4001" + "0000"},
+	} {
+		if actual := hex.EncodeToString(tc.entry); actual != tc.expected {
+			t.Errorf("%s: %s, expected %s", tc.name, actual, tc.expected)
+		}
+	}
+}
+
+func TestXDPEntriesUnsupported(t *testing.T) {
+	ipv6 := testRoute()
+	ipv6.Peer = &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 2000}
+	unspecified := testRoute()
+	unspecified.Server = &net.UDPAddr{IP: net.IPv4zero, Port: 3478}
+	missing := testRoute()
+	missing.Relay = nil
+
+	for _, route := range []Route{ipv6, unspecified, missing} {
+		if _, err := newXDPEntries(route); !errors.Is(err, ErrRouteUnsupported) {
+			t.Errorf("Unexpected error for %v: %v", route, err)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPingTimeout = time.Second
+	pingHeaderLength   = 8
+	pingLength         = pingHeaderLength + 1 + 8
+
+	pingRequest byte = 0
+	pingReply   byte = 1
+)
+
+// pingMagic starts every probe, so probes are told apart from other datagrams
+var pingMagic = []byte("TURNPING") //nolint:gochecknoglobals
+
+// PingerConfig configures a Pinger
+type PingerConfig struct {
+	// Timeout is how long Ping waits for the echo of a probe. Defaults to 1 second
+	Timeout time.Duration
+}
+
+// RTTStats are the rolling round-trip time statistics of a peer. Mean and Jitter are
+// smoothed like the SRTT of TCP (RFC 6298) and the interarrival jitter of RTP (RFC 3550)
+type RTTStats struct {
+	// Last is the round-trip time of the last answered probe
+	Last time.Duration
+	// Min is the lowest round-trip time seen
+	Min time.Duration
+	// Mean is the smoothed round-trip time
+	Mean time.Duration
+	// Jitter is the smoothed difference of consecutive round-trip times
+	Jitter time.Duration
+	// Sent is the number of probes sent
+	Sent uint64
+	// Lost is the number of probes that weren't answered within the timeout
+	Lost uint64
+}
+
+// Pinger measures the round-trip time to cooperating peers through a relayed conn, e.g.
+// to select the fastest of several TURN servers. The peers echo the probes with Echo or
+// a Pinger of their own. The Pinger owns conn and drops the datagrams that aren't probes.
+type Pinger struct {
+	conn    net.PacketConn
+	timeout time.Duration
+
+	lock    sync.Mutex
+	seq     uint64
+	pending map[uint64]chan time.Time
+	stats   map[string]*RTTStats
+	closed  chan struct{}
+}
+
+// NewPinger creates a Pinger reading from conn, e.g. the relayed conn of Client.Allocate
+func NewPinger(conn net.PacketConn, config PingerConfig) *Pinger {
+	if config.Timeout == 0 {
+		config.Timeout = defaultPingTimeout
+	}
+
+	p := &Pinger{
+		conn:    conn,
+		timeout: config.Timeout,
+		pending: map[uint64]chan time.Time{},
+		stats:   map[string]*RTTStats{},
+		closed:  make(chan struct{}),
+	}
+	go p.readLoop()
+
+	return p
+}
+
+// Ping sends a probe to peer and returns the round-trip time once it is echoed. The
+// statistics of peer are updated, a probe that isn't echoed within the timeout is lost.
+func (p *Pinger) Ping(peer net.Addr) (time.Duration, error) {
+	p.lock.Lock()
+	p.seq++
+	seq := p.seq
+	echoed := make(chan time.Time, 1)
+	p.pending[seq] = echoed
+	p.peerStats(peer).Sent++
+	p.lock.Unlock()
+
+	defer func() {
+		p.lock.Lock()
+		delete(p.pending, seq)
+		p.lock.Unlock()
+	}()
+
+	sent := time.Now()
+	if _, err := p.conn.WriteTo(buildPing(pingRequest, seq), peer); err != nil {
+		return 0, err
+	}
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case received := <-echoed:
+		rtt := received.Sub(sent)
+		p.lock.Lock()
+		p.peerStats(peer).update(rtt)
+		p.lock.Unlock()
+		return rtt, nil
+	case <-timer.C:
+		p.lock.Lock()
+		p.peerStats(peer).Lost++
+		p.lock.Unlock()
+		return 0, errPingTimeout
+	case <-p.closed:
+		return 0, errPingerClosed
+	}
+}
+
+// Stats returns the statistics of peer
+func (p *Pinger) Stats(peer net.Addr) RTTStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if stats, ok := p.stats[peer.String()]; ok {
+		return *stats
+	}
+	return RTTStats{}
+}
+
+// Close stops the Pinger and closes its conn
+func (p *Pinger) Close() error {
+	p.lock.Lock()
+	select {
+	case <-p.closed:
+		p.lock.Unlock()
+		return nil
+	default:
+		close(p.closed)
+	}
+	p.lock.Unlock()
+
+	return p.conn.Close()
+}
+
+func (p *Pinger) peerStats(peer net.Addr) *RTTStats {
+	stats, ok := p.stats[peer.String()]
+	if !ok {
+		stats = &RTTStats{}
+		p.stats[peer.String()] = stats
+	}
+	return stats
+}
+
+func (p *Pinger) readLoop() {
+	buf := make([]byte, maxDataBufferSize)
+	for {
+		n, from, err := p.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		received := time.Now()
+
+		kind, seq, ok := parsePing(buf[:n])
+		switch {
+		case !ok:
+		case kind == pingRequest:
+			_, _ = p.conn.WriteTo(buildPing(pingReply, seq), from)
+		default:
+			p.lock.Lock()
+			if echoed, ok := p.pending[seq]; ok {
+				echoed <- received
+				delete(p.pending, seq)
+			}
+			p.lock.Unlock()
+		}
+	}
+}
+
+func (s *RTTStats) update(rtt time.Duration) {
+	if s.Mean == 0 {
+		s.Min, s.Mean = rtt, rtt
+	} else {
+		diff := rtt - s.Last
+		if diff < 0 {
+			diff = -diff
+		}
+		s.Jitter += (diff - s.Jitter) / 16
+		s.Mean += (rtt - s.Mean) / 8
+	}
+	if rtt < s.Min {
+		s.Min = rtt
+	}
+	s.Last = rtt
+}
+
+// Echo answers the probes of Pingers received on conn, until reading from conn fails.
+// Other datagrams are dropped
+func Echo(conn net.PacketConn) error {
+	buf := make([]byte, maxDataBufferSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		if kind, seq, ok := parsePing(buf[:n]); ok && kind == pingRequest {
+			if _, err = conn.WriteTo(buildPing(pingReply, seq), from); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func buildPing(kind byte, seq uint64) []byte {
+	b := make([]byte, pingLength)
+	copy(b, pingMagic)
+	b[pingHeaderLength] = kind
+	binary.BigEndian.PutUint64(b[pingHeaderLength+1:], seq)
+	return b
+}
+
+func parsePing(b []byte) (kind byte, seq uint64, ok bool) {
+	if len(b) != pingLength || !bytes.Equal(b[:pingHeaderLength], pingMagic) {
+		return 0, 0, false
+	}
+	return b[pingHeaderLength], binary.BigEndian.Uint64(b[pingHeaderLength+1:]), true
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/transport/v3/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRTTStats(t *testing.T) {
+	stats := RTTStats{}
+	stats.update(10 * time.Millisecond)
+	assert.Equal(t, RTTStats{Last: 10 * time.Millisecond, Min: 10 * time.Millisecond, Mean: 10 * time.Millisecond}, stats)
+
+	stats.update(26 * time.Millisecond)
+	assert.Equal(t, 26*time.Millisecond, stats.Last)
+	assert.Equal(t, 10*time.Millisecond, stats.Min)
+	assert.Equal(t, 12*time.Millisecond, stats.Mean)
+	assert.Equal(t, time.Millisecond, stats.Jitter)
+}
+
+func TestPinger(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("Echo", func(t *testing.T) {
+		peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		echoed := make(chan error)
+		go func() { echoed <- Echo(peer) }()
+
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		pinger := NewPinger(conn, PingerConfig{})
+
+		// Other datagrams are dropped
+		_, err = conn.WriteTo([]byte("hello"), peer.LocalAddr())
+		require.NoError(t, err)
+
+		for i := 0; i < 5; i++ {
+			rtt, err := pinger.Ping(peer.LocalAddr())
+			require.NoError(t, err)
+			assert.Greater(t, rtt, time.Duration(0))
+		}
+
+		stats := pinger.Stats(peer.LocalAddr())
+		assert.Equal(t, uint64(5), stats.Sent)
+		assert.Equal(t, uint64(0), stats.Lost)
+		assert.LessOrEqual(t, stats.Min, stats.Mean)
+		assert.Equal(t, RTTStats{}, pinger.Stats(conn.LocalAddr()))
+
+		assert.NoError(t, pinger.Close())
+		assert.NoError(t, pinger.Close())
+		_, err = pinger.Ping(peer.LocalAddr())
+		assert.Error(t, err)
+
+		assert.NoError(t, peer.Close())
+		assert.Error(t, <-echoed)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		pinger := NewPinger(conn, PingerConfig{Timeout: 50 * time.Millisecond})
+
+		_, err = pinger.Ping(peer.LocalAddr())
+		assert.ErrorIs(t, err, errPingTimeout)
+		assert.Equal(t, RTTStats{Sent: 1, Lost: 1}, pinger.Stats(peer.LocalAddr()))
+
+		assert.NoError(t, pinger.Close())
+		assert.NoError(t, peer.Close())
+	})
+
+	t.Run("Relay", func(t *testing.T) {
+		udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		serverAddr := udpListener.LocalAddr().String()
+
+		server, err := NewServer(ServerConfig{
+			AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+				return GenerateAuthKey(username, realm, "pass"), true
+			},
+			PacketConnConfigs: []PacketConnConfig{
+				{
+					PacketConn: udpListener,
+					RelayAddressGenerator: &RelayAddressGeneratorStatic{
+						RelayAddress: net.ParseIP("127.0.0.1"),
+						Address:      "127.0.0.1",
+					},
+				},
+			},
+			Realm: "pion.ly",
+		})
+		require.NoError(t, err)
+
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       "user",
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		relayConn, err := client.Allocate()
+		require.NoError(t, err)
+
+		peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		go Echo(peer) //nolint:errcheck
+
+		pinger := NewPinger(relayConn, PingerConfig{})
+		_, err = pinger.Ping(peer.LocalAddr())
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), pinger.Stats(peer.LocalAddr()).Sent)
+
+		assert.NoError(t, pinger.Close())
+		assert.NoError(t, peer.Close())
+		client.Close()
+		assert.NoError(t, conn.Close())
+		assert.NoError(t, server.Close())
+	})
+}
@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package policy
+
+import "errors"
+
+var (
+	// ErrSyntax is returned by Compile for expressions that can't be parsed
+	ErrSyntax = errors.New("policy: syntax error")
+	// ErrUnknownVariable is returned by Compile for expressions that use a variable
+	// that doesn't exist
+	ErrUnknownVariable = errors.New("policy: unknown variable")
+	// ErrTypeMismatch is returned by Compile for expressions that compare values of
+	// different types, or that use an operator a type doesn't support
+	ErrTypeMismatch = errors.New("policy: type mismatch")
+	// ErrNoPolicy is returned by Engine.Evaluate if no policy was loaded
+	ErrNoPolicy = errors.New("policy: no policy loaded")
+)
@@ -0,0 +1,508 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package policy
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pion/turn/v3/internal/ipnet"
+)
+
+// Expression is a compiled policy expression, see the package documentation for the syntax
+type Expression struct {
+	source string
+	eval   func(in *Input) bool
+}
+
+// Compile parses and type checks source
+func Compile(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	o, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("%w: unexpected %q at offset %d", ErrSyntax, t.text, t.offset)
+	}
+	if o.kind != kindBool {
+		return nil, fmt.Errorf("%w: expression is a %s, not a bool", ErrTypeMismatch, o.kind)
+	}
+
+	return &Expression{source: source, eval: o.boolFn}, nil
+}
+
+// Evaluate returns the result of the expression for in. Compiled expressions can't fail,
+// the error is returned to implement Evaluator
+func (e *Expression) Evaluate(in Input) (bool, error) {
+	return e.eval(&in), nil
+}
+
+func (e *Expression) String() string {
+	return e.source
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenInt
+	tokenOperator
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func tokenize(source string) ([]token, error) {
+	// Longer operators first, so "<=" isn't read as "<"
+	operators := []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","}
+
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '_' || unicode.IsLetter(c):
+			start := i
+			for i < len(source) && (source[i] == '_' || unicode.IsLetter(rune(source[i])) || unicode.IsDigit(rune(source[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[start:i], offset: start})
+		case unicode.IsDigit(c):
+			start := i
+			for i < len(source) && unicode.IsDigit(rune(source[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenInt, text: source[start:i], offset: start})
+		case c == '"':
+			start := i
+			for i++; i < len(source) && source[i] != '"'; i++ {
+				if source[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(source) {
+				return nil, fmt.Errorf("%w: unterminated string at offset %d", ErrSyntax, start)
+			}
+			i++
+			text, err := strconv.Unquote(source[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid string at offset %d", ErrSyntax, start)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, offset: start})
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, offset: i})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("%w: unexpected %q at offset %d", ErrSyntax, c, i)
+			}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, offset: len(source)}), nil
+}
+
+type kind int
+
+const (
+	kindBool kind = iota
+	kindString
+	kindInt
+	kindIP
+	kindList
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindBool:
+		return "bool"
+	case kindString:
+		return "string"
+	case kindInt:
+		return "int"
+	case kindIP:
+		return "ip"
+	default:
+		return "list"
+	}
+}
+
+// operand is a typed, compiled subexpression. Literals also keep their value, the
+// operands of IP comparisons and matches have to be literals
+type operand struct {
+	kind    kind
+	literal bool
+	text    string
+	list    []string
+
+	boolFn   func(in *Input) bool
+	stringFn func(in *Input) string
+	intFn    func(in *Input) int
+	ipFn     func(in *Input) net.IP
+}
+
+func addrIP(addr net.Addr) net.IP {
+	ip, _, _ := ipnet.AddrIPPort(addr)
+	return ip
+}
+
+func addrPort(addr net.Addr) int {
+	_, port, _ := ipnet.AddrIPPort(addr)
+	return port
+}
+
+func variable(name string) (operand, bool) {
+	switch name {
+	case "action":
+		return operand{kind: kindString, stringFn: func(in *Input) string { return string(in.Action) }}, true
+	case "username":
+		return operand{kind: kindString, stringFn: func(in *Input) string { return in.Username }}, true
+	case "realm":
+		return operand{kind: kindString, stringFn: func(in *Input) string { return in.Realm }}, true
+	case "listener":
+		return operand{kind: kindString, stringFn: func(in *Input) string { return in.Listener }}, true
+	case "client_ip":
+		return operand{kind: kindIP, ipFn: func(in *Input) net.IP { return addrIP(in.ClientAddr) }}, true
+	case "client_port":
+		return operand{kind: kindInt, intFn: func(in *Input) int { return addrPort(in.ClientAddr) }}, true
+	case "peer_ip":
+		return operand{kind: kindIP, ipFn: func(in *Input) net.IP { return addrIP(in.PeerAddr) }}, true
+	case "peer_port":
+		return operand{kind: kindInt, intFn: func(in *Input) int { return addrPort(in.PeerAddr) }}, true
+	default:
+		return operand{}, false
+	}
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(kind tokenKind, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(tokenOperator, text) {
+		t := p.peek()
+		return fmt.Errorf("%w: expected %q at offset %d", ErrSyntax, text, t.offset)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (operand, error) {
+	return p.parseLogical("||", p.parseAnd, func(l, r func(*Input) bool) func(*Input) bool {
+		return func(in *Input) bool { return l(in) || r(in) }
+	})
+}
+
+func (p *parser) parseAnd() (operand, error) {
+	return p.parseLogical("&&", p.parseNot, func(l, r func(*Input) bool) func(*Input) bool {
+		return func(in *Input) bool { return l(in) && r(in) }
+	})
+}
+
+func (p *parser) parseLogical(op string, parseOperand func() (operand, error), combine func(l, r func(*Input) bool) func(*Input) bool) (operand, error) {
+	l, err := parseOperand()
+	if err != nil {
+		return operand{}, err
+	}
+
+	for p.accept(tokenOperator, op) {
+		r, err := parseOperand()
+		if err != nil {
+			return operand{}, err
+		}
+		if l.kind != kindBool || r.kind != kindBool {
+			return operand{}, fmt.Errorf("%w: %s %s %s", ErrTypeMismatch, l.kind, op, r.kind)
+		}
+		l = operand{kind: kindBool, boolFn: combine(l.boolFn, r.boolFn)}
+	}
+
+	return l, nil
+}
+
+func (p *parser) parseNot() (operand, error) {
+	if !p.accept(tokenOperator, "!") {
+		return p.parseComparison()
+	}
+
+	o, err := p.parseNot()
+	if err != nil {
+		return operand{}, err
+	}
+	if o.kind != kindBool {
+		return operand{}, fmt.Errorf("%w: !%s", ErrTypeMismatch, o.kind)
+	}
+
+	return operand{kind: kindBool, boolFn: func(in *Input) bool { return !o.boolFn(in) }}, nil
+}
+
+func (p *parser) parseComparison() (operand, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return operand{}, err
+	}
+
+	t := p.peek()
+	if !isComparison(t) {
+		return l, nil
+	}
+	p.next()
+
+	r, err := p.parsePrimary()
+	if err != nil {
+		return operand{}, err
+	}
+
+	fn, err := compare(l, t.text, r)
+	if err != nil {
+		return operand{}, err
+	}
+
+	return operand{kind: kindBool, boolFn: fn}, nil
+}
+
+func isComparison(t token) bool {
+	switch {
+	case t.kind == tokenOperator:
+		switch t.text {
+		case "==", "!=", "<", "<=", ">", ">=":
+			return true
+		}
+	case t.kind == tokenIdent:
+		return t.text == "in" || t.text == "matches"
+	}
+
+	return false
+}
+
+func (p *parser) parsePrimary() (operand, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		text := t.text
+		return operand{kind: kindString, literal: true, text: text, stringFn: func(*Input) string { return text }}, nil
+	case tokenInt:
+		n, err := strconv.Atoi(t.text)
+		if err != nil {
+			return operand{}, fmt.Errorf("%w: invalid int %q at offset %d", ErrSyntax, t.text, t.offset)
+		}
+		return operand{kind: kindInt, literal: true, text: t.text, intFn: func(*Input) int { return n }}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			b := t.text == "true"
+			return operand{kind: kindBool, literal: true, text: t.text, boolFn: func(*Input) bool { return b }}, nil
+		}
+		o, ok := variable(t.text)
+		if !ok {
+			return operand{}, fmt.Errorf("%w: %q at offset %d", ErrUnknownVariable, t.text, t.offset)
+		}
+		return o, nil
+	case tokenOperator:
+		switch t.text {
+		case "(":
+			o, err := p.parseOr()
+			if err != nil {
+				return operand{}, err
+			}
+			return o, p.expect(")")
+		case "[":
+			return p.parseList()
+		}
+	case tokenEOF:
+		return operand{}, fmt.Errorf("%w: unexpected end of expression", ErrSyntax)
+	}
+
+	return operand{}, fmt.Errorf("%w: unexpected %q at offset %d", ErrSyntax, t.text, t.offset)
+}
+
+// parseList parses a list of string literals, after the opening bracket
+func (p *parser) parseList() (operand, error) {
+	list := operand{kind: kindList, literal: true}
+	if p.accept(tokenOperator, "]") {
+		return list, nil
+	}
+
+	for {
+		t := p.next()
+		if t.kind != tokenString {
+			return operand{}, fmt.Errorf("%w: lists contain only strings, found %q at offset %d", ErrSyntax, t.text, t.offset)
+		}
+		list.list = append(list.list, t.text)
+
+		if p.accept(tokenOperator, "]") {
+			return list, nil
+		}
+		if err := p.expect(","); err != nil {
+			return operand{}, err
+		}
+	}
+}
+
+func compare(l operand, op string, r operand) (func(*Input) bool, error) {
+	mismatch := fmt.Errorf("%w: %s %s %s", ErrTypeMismatch, l.kind, op, r.kind)
+
+	switch l.kind {
+	case kindString:
+		return compareString(l, op, r, mismatch)
+	case kindInt:
+		return compareInt(l, op, r, mismatch)
+	case kindIP:
+		return compareIP(l, op, r, mismatch)
+	case kindBool:
+		if r.kind != kindBool {
+			return nil, mismatch
+		}
+		switch op {
+		case "==":
+			return func(in *Input) bool { return l.boolFn(in) == r.boolFn(in) }, nil
+		case "!=":
+			return func(in *Input) bool { return l.boolFn(in) != r.boolFn(in) }, nil
+		}
+	case kindList:
+	}
+
+	return nil, mismatch
+}
+
+func compareString(l operand, op string, r operand, mismatch error) (func(*Input) bool, error) {
+	switch {
+	case op == "in" && r.kind == kindList:
+		set := map[string]bool{}
+		for _, s := range r.list {
+			set[s] = true
+		}
+		return func(in *Input) bool { return set[l.stringFn(in)] }, nil
+	case op == "matches" && r.kind == kindString && r.literal:
+		re, err := regexp.Compile(r.text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid regular expression %q: %v", ErrSyntax, r.text, err) //nolint:errorlint
+		}
+		return func(in *Input) bool { return re.MatchString(l.stringFn(in)) }, nil
+	case r.kind != kindString:
+	case op == "==":
+		return func(in *Input) bool { return l.stringFn(in) == r.stringFn(in) }, nil
+	case op == "!=":
+		return func(in *Input) bool { return l.stringFn(in) != r.stringFn(in) }, nil
+	}
+
+	return nil, mismatch
+}
+
+func compareInt(l operand, op string, r operand, mismatch error) (func(*Input) bool, error) {
+	if r.kind != kindInt {
+		return nil, mismatch
+	}
+
+	switch op {
+	case "==":
+		return func(in *Input) bool { return l.intFn(in) == r.intFn(in) }, nil
+	case "!=":
+		return func(in *Input) bool { return l.intFn(in) != r.intFn(in) }, nil
+	case "<":
+		return func(in *Input) bool { return l.intFn(in) < r.intFn(in) }, nil
+	case "<=":
+		return func(in *Input) bool { return l.intFn(in) <= r.intFn(in) }, nil
+	case ">":
+		return func(in *Input) bool { return l.intFn(in) > r.intFn(in) }, nil
+	case ">=":
+		return func(in *Input) bool { return l.intFn(in) >= r.intFn(in) }, nil
+	}
+
+	return nil, mismatch
+}
+
+func compareIP(l operand, op string, r operand, mismatch error) (func(*Input) bool, error) {
+	switch {
+	case (op == "==" || op == "!=") && r.kind == kindString && r.literal:
+		ip := net.ParseIP(r.text)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: invalid IP %q", ErrSyntax, r.text)
+		}
+		equal := op == "=="
+		return func(in *Input) bool { return ip.Equal(l.ipFn(in)) == equal }, nil
+	case op == "in" && r.kind == kindString && r.literal:
+		return compareNetworks(l, []string{r.text})
+	case op == "in" && r.kind == kindList:
+		return compareNetworks(l, r.list)
+	}
+
+	return nil, mismatch
+}
+
+// compareNetworks returns true if the IP of l is in one of networks, which are CIDRs or
+// single IPs
+func compareNetworks(l operand, networks []string) (func(*Input) bool, error) {
+	parsed := make([]*net.IPNet, 0, len(networks))
+	for _, network := range networks {
+		if ip := net.ParseIP(network); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			parsed = append(parsed, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(network)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid network %q", ErrSyntax, network)
+		}
+		parsed = append(parsed, n)
+	}
+
+	return func(in *Input) bool {
+		ip := l.ipFn(in)
+		if ip == nil {
+			return false
+		}
+		for _, n := range parsed {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
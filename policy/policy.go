@@ -0,0 +1,127 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package policy decides whether a TURN server allows allocations, permissions and
+// channel bindings. Set ServerConfig.Policy to an Evaluator, either an Engine running
+// an expression that can be replaced at runtime:
+//
+//	engine, err := policy.NewEngine(`action != "allocate" || realm == "pion.ly"`)
+//	...
+//	err = engine.LoadFile("/etc/turn/policy.expr") // e.g. on SIGHUP
+//
+// or an adapter for another policy engine, e.g. a WASM module:
+//
+//	policy.EvaluatorFunc(func(in policy.Input) (bool, error) {
+//		return module.Call("allow", in.Action, in.Username)
+//	})
+//
+// Expressions combine comparisons of the variables below with &&, || and !:
+//
+//	action       string  "allocate", "permission", "channel_bind" or "connect"
+//	username     string  USERNAME of the request
+//	realm        string  REALM of the request
+//	listener     string  name of the listener the client connected to
+//	client_ip    ip      address of the client
+//	client_port  int     port of the client
+//	peer_ip      ip      address of the peer, unset for "allocate"
+//	peer_port    int     port of the peer, 0 for "allocate"
+//
+// Strings and ints support == and !=, ints also <, <=, > and >=. Strings can be tested
+// against a list with `username in ["alice", "bob"]` and against a regular expression
+// with `username matches "^guest-"`. IPs are compared with string literals, either with
+// `peer_ip == "192.0.2.1"` or against networks with `peer_ip in "10.0.0.0/8"` or
+// `peer_ip in ["10.0.0.0/8", "fd00::/8"]`.
+package policy
+
+import (
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+)
+
+// Action is the kind of request a policy decides on
+type Action string
+
+const (
+	// ActionAllocate is an Allocate request
+	ActionAllocate Action = "allocate"
+	// ActionPermission is a CreatePermission request, for every XOR-PEER-ADDRESS
+	ActionPermission Action = "permission"
+	// ActionChannelBind is a ChannelBind request
+	ActionChannelBind Action = "channel_bind"
+	// ActionConnect is an RFC 6062 Connect request
+	ActionConnect Action = "connect"
+)
+
+// Input is the request a policy decides on
+type Input struct {
+	Action   Action
+	Username string
+	Realm    string
+	Listener string
+	// ClientAddr is the address of the client
+	ClientAddr net.Addr
+	// PeerAddr is the address of the peer, nil for ActionAllocate
+	PeerAddr net.Addr
+}
+
+// Evaluator decides whether a request is allowed. Requests are rejected with a 403
+// (Forbidden) error if Evaluate returns false or an error
+type Evaluator interface {
+	Evaluate(in Input) (bool, error)
+}
+
+// EvaluatorFunc is an Evaluator backed by a function
+type EvaluatorFunc func(in Input) (bool, error)
+
+// Evaluate calls f
+func (f EvaluatorFunc) Evaluate(in Input) (bool, error) {
+	return f(in)
+}
+
+// Engine evaluates an Expression that can be replaced while the server is running
+type Engine struct {
+	expression atomic.Value
+}
+
+// NewEngine creates an Engine that evaluates the expression source
+func NewEngine(source string) (*Engine, error) {
+	e := &Engine{}
+	if err := e.Load(source); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// Load compiles source and replaces the evaluated expression with it. If source
+// doesn't compile the previous expression is kept
+func (e *Engine) Load(source string) error {
+	expression, err := Compile(source)
+	if err != nil {
+		return err
+	}
+	e.expression.Store(expression)
+
+	return nil
+}
+
+// LoadFile loads the expression in the file at path, like Load
+func (e *Engine) LoadFile(path string) error {
+	source, err := ioutil.ReadFile(path) //nolint:gosec
+	if err != nil {
+		return err
+	}
+
+	return e.Load(string(source))
+}
+
+// Evaluate evaluates the current expression
+func (e *Engine) Evaluate(in Input) (bool, error) {
+	expression, ok := e.expression.Load().(*Expression)
+	if !ok {
+		return false, ErrNoPolicy
+	}
+
+	return expression.Evaluate(in)
+}
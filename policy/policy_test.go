@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package policy
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpression(t *testing.T) {
+	in := Input{
+		Action:     ActionChannelBind,
+		Username:   "guest-1",
+		Realm:      "pion.ly",
+		Listener:   "edge",
+		ClientAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000},
+		PeerAddr:   &net.UDPAddr{IP: net.ParseIP("10.1.2.3"), Port: 6000},
+	}
+
+	for _, test := range []struct {
+		source string
+		result bool
+	}{
+		{`true`, true},
+		{`!false && !(true && false)`, true},
+		{`action == "channel_bind"`, true},
+		{`username != "guest-1" || realm == "pion.ly"`, true},
+		{`username in ["alice", "bob"]`, false},
+		{`username matches "^guest-[0-9]+$"`, true},
+		{`listener == "edge" && client_port >= 5000 && client_port < 5001`, true},
+		{`peer_port > 6000`, false},
+		{`client_ip == "192.0.2.1"`, true},
+		{`peer_ip in "10.0.0.0/8"`, true},
+		{`peer_ip in ["192.168.0.0/16", "fd00::/8"]`, false},
+		{`peer_ip in ["10.1.2.3"]`, true},
+	} {
+		e, err := Compile(test.source)
+		require.NoError(t, err, test.source)
+		result, err := e.Evaluate(in)
+		assert.NoError(t, err)
+		assert.Equal(t, test.result, result, test.source)
+	}
+
+	t.Run("unset peer", func(t *testing.T) {
+		e, err := Compile(`peer_ip in "0.0.0.0/0" || peer_port != 0`)
+		require.NoError(t, err)
+		result, err := e.Evaluate(Input{Action: ActionAllocate})
+		assert.NoError(t, err)
+		assert.False(t, result)
+	})
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, test := range []struct {
+		source string
+		err    error
+	}{
+		{``, ErrSyntax},
+		{`username ==`, ErrSyntax},
+		{`(true`, ErrSyntax},
+		{`"unterminated`, ErrSyntax},
+		{`true true`, ErrSyntax},
+		{`username == "a" # comment`, ErrSyntax},
+		{`username matches "("`, ErrSyntax},
+		{`peer_ip in "10.0.0.0/33"`, ErrSyntax},
+		{`password == "secret"`, ErrUnknownVariable},
+		{`username`, ErrTypeMismatch},
+		{`username == 1`, ErrTypeMismatch},
+		{`username < "b"`, ErrTypeMismatch},
+		{`peer_ip == username`, ErrTypeMismatch},
+		{`!peer_port`, ErrTypeMismatch},
+		{`peer_port && true`, ErrTypeMismatch},
+	} {
+		_, err := Compile(test.source)
+		assert.ErrorIs(t, err, test.err, test.source)
+	}
+}
+
+func TestEngine(t *testing.T) {
+	engine, err := NewEngine(`username == "alice"`)
+	require.NoError(t, err)
+
+	allowed, err := engine.Evaluate(Input{Username: "alice"})
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	// Invalid policies keep the current one
+	assert.ErrorIs(t, engine.Load(`username ==`), ErrSyntax)
+	allowed, err = engine.Evaluate(Input{Username: "alice"})
+	assert.NoError(t, err)
+	assert.True(t, allowed)
+
+	path := filepath.Join(t.TempDir(), "policy.expr")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`username == "bob"`), 0o600))
+	require.NoError(t, engine.LoadFile(path))
+	allowed, err = engine.Evaluate(Input{Username: "alice"})
+	assert.NoError(t, err)
+	assert.False(t, allowed)
+
+	_, err = (&Engine{}).Evaluate(Input{})
+	assert.ErrorIs(t, err, ErrNoPolicy)
+}
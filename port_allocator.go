@@ -0,0 +1,282 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pion/randutil"
+	"github.com/pion/transport/v3"
+)
+
+const (
+	defaultMinRelayPort = 49152
+	defaultMaxRelayPort = 65535
+
+	// portReservationTimeout is how long the port after an even port is kept free for the
+	// relay of a RESERVATION-TOKEN, see RFC 5766 Section 6.2
+	portReservationTimeout = 30 * time.Second
+)
+
+// PortAllocator selects the ports of the relays of a RelayAddressGenerator, e.g. to assign
+// ports deterministically, or coordinated with other processes. The generator binds the
+// relay to the port it acquired, and releases the port once the relay is closed or
+// binding it failed.
+type PortAllocator interface {
+	// Acquire returns a port for a relay of network (e.g. "udp4" or "tcp6") on ip.
+	// requestedPort is the port the client asked for, 0 for any. If even is set the port
+	// must be even, and the port after it is kept free for the RESERVATION-TOKEN of the
+	// allocation for 30 seconds
+	Acquire(network string, ip net.IP, requestedPort int, even bool) (int, error)
+
+	// Release frees a port returned by Acquire
+	Release(network string, ip net.IP, port int)
+}
+
+// EvenPortRelayAddressGenerator is a RelayAddressGenerator that selects the even ports of
+// Allocate requests with EVEN-PORT itself, e.g. with its PortAllocator. The Server tries
+// random ports until it finds an even one otherwise.
+type EvenPortRelayAddressGenerator interface {
+	RelayAddressGenerator
+
+	// AllocateEvenPacketConn generates a new PacketConn on an even port, keeping the port
+	// after it free for the RESERVATION-TOKEN of the allocation
+	AllocateEvenPacketConn(network string) (net.PacketConn, net.Addr, error)
+}
+
+// PortRangeAllocator is a PortAllocator of random ports of a range. It keeps track of the
+// ports in use per IP, so it doesn't hand out a port twice.
+type PortRangeAllocator struct {
+	// MinPort and MaxPort (inclusive) are the range of ports. Defaults to 49152-65535
+	MinPort uint16
+	MaxPort uint16
+
+	// Rand the random source of numbers
+	Rand randutil.MathRandomGenerator
+
+	lock  sync.Mutex
+	ports map[string]map[int]time.Time
+}
+
+// Acquire returns a random free port of the range, or requestedPort if it is free
+func (p *PortRangeAllocator) Acquire(network string, ip net.IP, requestedPort int, even bool) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := time.Now()
+	ports := p.portsOf(network, ip)
+
+	if requestedPort != 0 {
+		// A port reserved after an even port is handed out on request
+		if reserved, ok := ports[requestedPort]; ok && reserved.IsZero() {
+			return 0, errPortInUse
+		}
+		ports[requestedPort] = time.Time{}
+		return requestedPort, nil
+	}
+
+	minPort, maxPort := p.portRange()
+	count := maxPort - minPort + 1
+	start := minPort + p.Rand.Intn(count)
+	for i := 0; i < count; i++ {
+		port := minPort + (start-minPort+i)%count
+		if !p.free(ports, port, now) {
+			continue
+		}
+		if even && (port%2 != 0 || port == maxPort || !p.free(ports, port+1, now)) {
+			continue
+		}
+
+		ports[port] = time.Time{}
+		if even {
+			ports[port+1] = now.Add(portReservationTimeout)
+		}
+		return port, nil
+	}
+
+	return 0, errPortsExhausted
+}
+
+// Release frees port
+func (p *PortRangeAllocator) Release(network string, ip net.IP, port int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ports := p.portsOf(network, ip)
+	if reserved, ok := ports[port]; ok && reserved.IsZero() {
+		delete(ports, port)
+	}
+}
+
+// InUse returns the number of ports in use on ip for relays of network
+func (p *PortRangeAllocator) InUse(network string, ip net.IP) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	inUse := 0
+	for _, reserved := range p.portsOf(network, ip) {
+		if reserved.IsZero() {
+			inUse++
+		}
+	}
+	return inUse
+}
+
+func (p *PortRangeAllocator) portRange() (int, int) {
+	if p.MinPort == 0 && p.MaxPort == 0 {
+		return defaultMinRelayPort, defaultMaxRelayPort
+	}
+	return int(p.MinPort), int(p.MaxPort)
+}
+
+// portsOf returns the ports of the protocol of network on ip, mapped to the time their
+// reservation ends, or the zero time if they are in use
+func (p *PortRangeAllocator) portsOf(network string, ip net.IP) map[int]time.Time {
+	if p.ports == nil {
+		p.ports = map[string]map[int]time.Time{}
+	}
+	if p.Rand == nil {
+		p.Rand = randutil.NewMathRandomGenerator()
+	}
+
+	key := strings.TrimRight(network, "46") + "/" + ip.String()
+	ports, ok := p.ports[key]
+	if !ok {
+		ports = map[int]time.Time{}
+		p.ports[key] = ports
+	}
+	return ports
+}
+
+func (p *PortRangeAllocator) free(ports map[int]time.Time, port int, now time.Time) bool {
+	reserved, ok := ports[port]
+	if ok && now.After(reserved) && !reserved.IsZero() {
+		delete(ports, port)
+		return true
+	}
+	return !ok
+}
+
+// listenPacketOnPort binds a relay on address to a port of allocator. Ports that fail to
+// bind are released and another one is acquired, up to maxRetries times
+func listenPacketOnPort(n transport.Net, allocator PortAllocator, network, address string, requestedPort int, even bool, maxRetries int) (net.PacketConn, *net.UDPAddr, error) {
+	ip := net.ParseIP(address)
+
+	err := errMaxRetriesExceeded
+	for try := 0; try < maxRetries; try++ {
+		port, acquireErr := allocator.Acquire(network, ip, requestedPort, even)
+		if acquireErr != nil {
+			return nil, nil, acquireErr
+		}
+
+		conn, listenErr := n.ListenPacket(network, net.JoinHostPort(address, strconv.Itoa(port)))
+		if listenErr != nil {
+			allocator.Release(network, ip, port)
+			if err = listenErr; requestedPort != 0 {
+				break
+			}
+			continue
+		}
+
+		udpAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			_ = conn.Close()
+			allocator.Release(network, ip, port)
+			return nil, nil, errNilConn
+		}
+
+		release := func() { allocator.Release(network, ip, port) }
+		return &releasingPacketConn{PacketConn: conn, release: onlyOnce(release)}, &net.UDPAddr{IP: udpAddr.IP, Port: udpAddr.Port, Zone: udpAddr.Zone}, nil
+	}
+
+	return nil, nil, err
+}
+
+// listenTCPOnPort is listenPacketOnPort for the listeners of TCP allocations
+func listenTCPOnPort(n transport.Net, allocator PortAllocator, network, address string, requestedPort int, maxRetries int) (net.Listener, *net.TCPAddr, error) {
+	ip := net.ParseIP(address)
+
+	err := errMaxRetriesExceeded
+	for try := 0; try < maxRetries; try++ {
+		port, acquireErr := allocator.Acquire(network, ip, requestedPort, false)
+		if acquireErr != nil {
+			return nil, nil, acquireErr
+		}
+
+		listener, relayAddr, listenErr := listenTCPRelay(n, network, address, port)
+		if listenErr != nil {
+			allocator.Release(network, ip, port)
+			if err = listenErr; requestedPort != 0 {
+				break
+			}
+			continue
+		}
+
+		release := func() { allocator.Release(network, ip, port) }
+		return &releasingListener{Listener: listener, release: onlyOnce(release)}, relayAddr, nil
+	}
+
+	return nil, nil, err
+}
+
+// listenEvenPacket binds relays with allocate until one is on an even port, for
+// generators without a PortAllocator
+func listenEvenPacket(allocate func(network string, requestedPort int) (net.PacketConn, net.Addr, error), network string) (net.PacketConn, net.Addr, error) {
+	for try := 0; try < 128; try++ {
+		conn, relayAddr, err := allocate(network, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if udpAddr, ok := relayAddr.(*net.UDPAddr); ok && udpAddr.Port%2 == 0 {
+			return conn, relayAddr, nil
+		}
+		if err = conn.Close(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return nil, nil, errMaxRetriesExceeded
+}
+
+func onlyOnce(f func()) func() {
+	var once sync.Once
+	return func() { once.Do(f) }
+}
+
+// releasingPacketConn is a relay socket that releases its port when it is closed
+type releasingPacketConn struct {
+	net.PacketConn
+	release func()
+}
+
+func (c *releasingPacketConn) Close() error {
+	c.release()
+	return c.PacketConn.Close()
+}
+
+// SyscallConn returns the raw conn of the socket, so DONT-FRAGMENT can be set on it
+func (c *releasingPacketConn) SyscallConn() (syscall.RawConn, error) {
+	conn, ok := c.PacketConn.(syscall.Conn)
+	if !ok {
+		return nil, errNoSyscallConn
+	}
+	return conn.SyscallConn()
+}
+
+// releasingListener is a relay listener that releases its port when it is closed
+type releasingListener struct {
+	net.Listener
+	release func()
+}
+
+func (l *releasingListener) Close() error {
+	l.release()
+	return l.Listener.Close()
+}
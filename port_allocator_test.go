@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sequentialPorts is a deterministic PortAllocator
+type sequentialPorts struct {
+	lock     sync.Mutex
+	next     int
+	released []int
+}
+
+func (s *sequentialPorts) Acquire(_ string, _ net.IP, requestedPort int, even bool) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if requestedPort != 0 {
+		return requestedPort, nil
+	}
+	if even && s.next%2 != 0 {
+		s.next++
+	}
+	s.next++
+	return s.next - 1, nil
+}
+
+func (s *sequentialPorts) Release(_ string, _ net.IP, port int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.released = append(s.released, port)
+}
+
+func TestPortRangeAllocator(t *testing.T) {
+	ip := net.ParseIP("127.0.0.1")
+
+	t.Run("Range", func(t *testing.T) {
+		p := &PortRangeAllocator{MinPort: 5000, MaxPort: 5003}
+
+		ports := map[int]bool{}
+		for i := 0; i < 4; i++ {
+			port, err := p.Acquire("udp4", ip, 0, false)
+			require.NoError(t, err)
+			assert.True(t, port >= 5000 && port <= 5003)
+			ports[port] = true
+		}
+		assert.Len(t, ports, 4)
+		assert.Equal(t, 4, p.InUse("udp4", ip))
+
+		_, err := p.Acquire("udp4", ip, 0, false)
+		assert.ErrorIs(t, err, errPortsExhausted)
+
+		// Ports are counted per protocol and IP
+		_, err = p.Acquire("tcp4", ip, 0, false)
+		assert.NoError(t, err)
+		_, err = p.Acquire("udp4", net.ParseIP("127.0.0.2"), 0, false)
+		assert.NoError(t, err)
+
+		p.Release("udp4", ip, 5001)
+		assert.Equal(t, 3, p.InUse("udp4", ip))
+		port, err := p.Acquire("udp4", ip, 0, false)
+		require.NoError(t, err)
+		assert.Equal(t, 5001, port)
+	})
+
+	t.Run("RequestedPort", func(t *testing.T) {
+		p := &PortRangeAllocator{MinPort: 5000, MaxPort: 5003}
+
+		port, err := p.Acquire("udp4", ip, 6000, false)
+		require.NoError(t, err)
+		assert.Equal(t, 6000, port)
+
+		_, err = p.Acquire("udp4", ip, 6000, false)
+		assert.ErrorIs(t, err, errPortInUse)
+	})
+
+	t.Run("Even", func(t *testing.T) {
+		p := &PortRangeAllocator{MinPort: 5000, MaxPort: 5003}
+
+		port, err := p.Acquire("udp4", ip, 0, true)
+		require.NoError(t, err)
+		assert.Equal(t, 0, port%2)
+
+		// The port after it is reserved, but handed out on request
+		other, err := p.Acquire("udp4", ip, 0, true)
+		require.NoError(t, err)
+		assert.NotEqual(t, port+1, other)
+		_, err = p.Acquire("udp4", ip, 0, true)
+		assert.ErrorIs(t, err, errPortsExhausted)
+
+		reserved, err := p.Acquire("udp4", ip, port+1, false)
+		require.NoError(t, err)
+		assert.Equal(t, port+1, reserved)
+	})
+}
+
+func TestRelayAddressGeneratorPortAllocator(t *testing.T) {
+	t.Run("PortRange", func(t *testing.T) {
+		generator := &RelayAddressGeneratorPortRange{
+			HostName: "localhost",
+			PublicIP: "127.0.0.1",
+			MinPort:  40000,
+			MaxPort:  40100,
+			Address:  "127.0.0.1",
+		}
+		require.NoError(t, generator.Validate())
+		allocator, ok := generator.PortAllocator.(*PortRangeAllocator)
+		require.True(t, ok)
+
+		conn, relayAddr, err := generator.AllocateEvenPacketConn("udp4")
+		require.NoError(t, err)
+		udpAddr, ok := relayAddr.(*net.UDPAddr)
+		require.True(t, ok)
+		assert.Equal(t, 0, udpAddr.Port%2)
+		assert.Equal(t, 1, allocator.InUse("udp4", net.ParseIP("127.0.0.1")))
+
+		// Closing the relay releases its port
+		assert.NoError(t, conn.Close())
+		assert.Equal(t, 0, allocator.InUse("udp4", net.ParseIP("127.0.0.1")))
+
+		listener, _, err := generator.AllocateListener("tcp4", 0)
+		require.NoError(t, err)
+		assert.Equal(t, 1, allocator.InUse("tcp4", net.ParseIP("127.0.0.1")))
+		assert.NoError(t, listener.Close())
+		assert.Equal(t, 0, allocator.InUse("tcp4", net.ParseIP("127.0.0.1")))
+	})
+
+	t.Run("Static", func(t *testing.T) {
+		// A free port for the deterministic allocator to start at
+		free, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+		start := free.LocalAddr().(*net.UDPAddr).Port //nolint:forcetypeassert
+		require.NoError(t, free.Close())
+
+		ports := &sequentialPorts{next: start}
+		generator := &RelayAddressGeneratorStatic{
+			RelayAddress:  net.ParseIP("127.0.0.1"),
+			Address:       "127.0.0.1",
+			PortAllocator: ports,
+		}
+		require.NoError(t, generator.Validate())
+
+		conn, relayAddr, err := generator.AllocatePacketConn("udp4", 0)
+		require.NoError(t, err)
+		assert.Equal(t, start, relayAddr.(*net.UDPAddr).Port) //nolint:forcetypeassert
+		assert.NoError(t, conn.Close())
+		assert.Error(t, conn.Close())
+		assert.Equal(t, []int{start}, ports.released)
+	})
+}
@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+	proxyHeaderLen     = 16
+
+	proxyVersion2     = 0x20
+	proxyCommandLocal = 0x00
+	proxyCommandProxy = 0x01
+
+	proxyFamilyInet  = 0x10
+	proxyFamilyInet6 = 0x20
+	proxyStream      = 0x01
+)
+
+var proxySignature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A} //nolint:gochecknoglobals
+
+// proxyListener accepts connections that start with a PROXY protocol v2 header
+type proxyListener struct {
+	net.Listener
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConn{Conn: conn}, nil
+}
+
+// proxyConn reports the addresses conveyed by the PROXY protocol v2 header of Conn. The
+// header is read by the first Read, RemoteAddr or LocalAddr, so a slow client doesn't block
+// the accept loop
+type proxyConn struct {
+	net.Conn
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.remoteAddr, c.localAddr = c.Conn.RemoteAddr(), c.Conn.LocalAddr()
+
+		if c.err = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout)); c.err != nil {
+			return
+		}
+
+		var remoteAddr, localAddr net.Addr
+		if remoteAddr, localAddr, c.err = readProxyHeader(c.Conn); c.err != nil {
+			return
+		}
+		if remoteAddr != nil {
+			c.remoteAddr, c.localAddr = remoteAddr, localAddr
+		}
+
+		c.err = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	if c.readHeader(); c.err != nil {
+		return 0, c.err
+	}
+
+	return c.Conn.Read(b)
+}
+
+// RemoteAddr returns the source address of the PROXY header
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	return c.remoteAddr
+}
+
+// LocalAddr returns the destination address of the PROXY header
+func (c *proxyConn) LocalAddr() net.Addr {
+	c.readHeader()
+	return c.localAddr
+}
+
+// readProxyHeader reads a PROXY protocol v2 header from r and returns the source and
+// destination addresses it conveys. They are nil for LOCAL commands, e.g. health checks of
+// the load balancer, and for other address families than TCP over IPv4 and IPv6
+func readProxyHeader(r io.Reader) (remoteAddr, localAddr net.Addr, err error) {
+	header := make([]byte, proxyHeaderLen)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errProxyHeaderInvalid, err) //nolint:errorlint
+	}
+	if !bytes.Equal(header[:len(proxySignature)], proxySignature) || header[12]&0xF0 != proxyVersion2 {
+		return nil, nil, errProxyHeaderInvalid
+	}
+
+	// The addresses are followed by TLVs, which are skipped
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", errProxyHeaderInvalid, err) //nolint:errorlint
+	}
+
+	switch header[12] & 0x0F {
+	case proxyCommandLocal:
+		return nil, nil, nil
+	case proxyCommandProxy:
+	default:
+		return nil, nil, fmt.Errorf("%w: command %#x", errProxyHeaderInvalid, header[12]&0x0F)
+	}
+
+	family, protocol := header[13]&0xF0, header[13]&0x0F
+	if protocol != proxyStream {
+		return nil, nil, nil
+	}
+
+	ipLen := 0
+	switch family {
+	case proxyFamilyInet:
+		ipLen = net.IPv4len
+	case proxyFamilyInet6:
+		ipLen = net.IPv6len
+	default:
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, fmt.Errorf("%w: %d bytes of addresses", errProxyHeaderInvalid, len(payload))
+	}
+
+	remoteAddr = &net.TCPAddr{
+		IP:   append(net.IP{}, payload[:ipLen]...),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	localAddr = &net.TCPAddr{
+		IP:   append(net.IP{}, payload[ipLen:2*ipLen]...),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+
+	return remoteAddr, localAddr, nil
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/transport/v3/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyHeader returns a PROXY protocol v2 header for a TCP connection from src to dst,
+// followed by a TLV
+func proxyHeader(command byte, src, dst *net.TCPAddr) []byte {
+	family, srcIP, dstIP := byte(proxyFamilyInet), src.IP.To4(), dst.IP.To4()
+	if srcIP == nil {
+		family, srcIP, dstIP = proxyFamilyInet6, src.IP.To16(), dst.IP.To16()
+	}
+
+	payload := append(append([]byte{}, srcIP...), dstIP...)
+	payload = binary.BigEndian.AppendUint16(payload, uint16(src.Port))
+	payload = binary.BigEndian.AppendUint16(payload, uint16(dst.Port))
+	payload = append(payload, 0x04, 0x00, 0x01, 0xFF) // PP2_TYPE_NOOP
+
+	header := append([]byte{}, proxySignature...)
+	header = append(header, proxyVersion2|command, family|proxyStream)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+
+	return append(header, payload...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3478}
+	src6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}
+	dst6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 3478}
+
+	t.Run("IPv4", func(t *testing.T) {
+		r := bytes.NewReader(append(proxyHeader(proxyCommandProxy, src, dst), "stun"...))
+		remoteAddr, localAddr, err := readProxyHeader(r)
+		require.NoError(t, err)
+		assert.Equal(t, src.String(), remoteAddr.String())
+		assert.Equal(t, dst.String(), localAddr.String())
+		assert.Equal(t, 4, r.Len(), "read past the header")
+	})
+
+	t.Run("IPv6", func(t *testing.T) {
+		remoteAddr, localAddr, err := readProxyHeader(bytes.NewReader(proxyHeader(proxyCommandProxy, src6, dst6)))
+		require.NoError(t, err)
+		assert.Equal(t, src6.String(), remoteAddr.String())
+		assert.Equal(t, dst6.String(), localAddr.String())
+	})
+
+	t.Run("Local", func(t *testing.T) {
+		remoteAddr, localAddr, err := readProxyHeader(bytes.NewReader(proxyHeader(proxyCommandLocal, src, dst)))
+		require.NoError(t, err)
+		assert.Nil(t, remoteAddr)
+		assert.Nil(t, localAddr)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		valid := proxyHeader(proxyCommandProxy, src, dst)
+
+		badSignature := append([]byte{}, valid...)
+		badSignature[0] = 'G'
+		badVersion := append([]byte{}, valid...)
+		badVersion[12] = 0x11
+		badCommand := append([]byte{}, valid...)
+		badCommand[12] = proxyVersion2 | 0x0F
+		shortAddresses := append([]byte{}, valid[:proxyHeaderLen]...)
+		shortAddresses[15] = 4
+		shortAddresses = append(shortAddresses, 192, 0, 2, 1)
+
+		for name, header := range map[string][]byte{
+			"Signature": badSignature,
+			"Version":   badVersion,
+			"Command":   badCommand,
+			"Addresses": shortAddresses,
+			"Truncated": valid[:len(valid)-1],
+			"Empty":     {},
+		} {
+			_, _, err := readProxyHeader(bytes.NewReader(header))
+			assert.True(t, errors.Is(err, errProxyHeaderInvalid), "%s: unexpected error: %v", name, err)
+		}
+	})
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	srcAddrs := []string{}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			lock.Lock()
+			defer lock.Unlock()
+			srcAddrs = append(srcAddrs, srcAddr.String())
+
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener: tcpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+				ProxyProtocol: true,
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5000}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 3478}
+
+	conn, err := net.Dial("tcp4", tcpListener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write(proxyHeader(proxyCommandProxy, src, dst))
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           NewSTUNConn(conn),
+		STUNServerAddr: tcpListener.Addr().String(),
+		TURNServerAddr: tcpListener.Addr().String(),
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// The AuthHandler and the 5-tuple of the allocation see the conveyed addresses
+	lock.Lock()
+	assert.Equal(t, []string{src.String()}, srcAddrs)
+	lock.Unlock()
+	allocations := server.Allocations()
+	require.Len(t, allocations, 1)
+	assert.Equal(t, src.String(), allocations[0].FiveTuple.SrcAddr.String())
+	assert.Equal(t, dst.String(), allocations[0].FiveTuple.DstAddr.String())
+
+	// Connections without a header are closed
+	plainConn, err := net.Dial("tcp4", tcpListener.Addr().String())
+	require.NoError(t, err)
+	_, err = plainConn.Write(bytes.Repeat([]byte{0x00}, proxyHeaderLen))
+	require.NoError(t, err)
+	require.NoError(t, plainConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = plainConn.Read(make([]byte, 1))
+	assert.Error(t, err)
+	assert.NoError(t, plainConn.Close())
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestListenerConfigProxyProtocol(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, listener.Close())
+	}()
+
+	cfg := ListenerConfig{
+		Listener:              listener,
+		RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"},
+		Datagram:              true,
+		ProxyProtocol:         true,
+	}
+	assert.Equal(t, errProxyProtocolOverDatagram, cfg.validate())
+}
@@ -0,0 +1,350 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/transport/v3/packetio"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+const (
+	quicMaxMessageSize = 65535 + channelDataHeaderSize
+	quicMaxBufferSize  = 1024 * 1024
+	quicMaxVarint      = 1<<62 - 1
+)
+
+var (
+	errQUICStreamClosed  = errors.New("turn: QUIC stream closed")
+	errQUICVarintInvalid = errors.New("turn: invalid QUIC variable-length integer")
+)
+
+// QUICStream is a bidirectional QUIC stream
+type QUICStream interface {
+	io.ReadWriteCloser
+
+	// StreamID returns the QUIC stream ID
+	StreamID() uint64
+}
+
+// QUICConnection is a QUIC connection that supports unreliable datagrams (RFC 9221).
+// pion/turn doesn't implement QUIC itself, adapt the connection of a QUIC implementation
+// like github.com/quic-go/quic-go to this interface. Blocking calls must return an error
+// once the connection is closed.
+type QUICConnection interface {
+	// AcceptStream returns the next bidirectional stream opened by the peer
+	AcceptStream() (QUICStream, error)
+	// OpenStream opens a bidirectional stream
+	OpenStream() (QUICStream, error)
+	// SendDatagram sends an unreliable datagram
+	SendDatagram(b []byte) error
+	// ReceiveDatagram returns the next unreliable datagram
+	ReceiveDatagram() ([]byte, error)
+
+	LocalAddr() net.Addr
+	RemoteAddr() net.Addr
+	Close() error
+}
+
+// QUICListener accepts QUIC connections
+type QUICListener interface {
+	Accept() (QUICConnection, error)
+	Close() error
+	Addr() net.Addr
+}
+
+// QUICListenerConfig is a single QUICListener to accept TURN over QUIC connections on,
+// and the configuration for the allocations created through it.
+//
+// TURN over QUIC is experimental. Every allocation is controlled through its own
+// bidirectional stream, which carries STUN and ChannelData messages framed as for TCP.
+// ChannelData is preferably sent as QUIC datagrams, prefixed with the ID of the stream of
+// the allocation as a QUIC variable-length integer.
+type QUICListenerConfig struct {
+	Listener QUICListener
+
+	// When an allocation is generated the RelayAddressGenerator
+	// creates the net.PacketConn and returns the IP/Port it is available at
+	RelayAddressGenerator RelayAddressGenerator
+
+	// PermissionHandler is a callback to filter peer addresses. Can be set as nil, in which
+	// case the DefaultPermissionHandler is automatically instantiated to admit all peer
+	// connections
+	PermissionHandler PermissionHandler
+
+	// Name identifies the listener in AllocationStats. Defaults to its local address
+	Name string
+
+	// Realm overrides ServerConfig.Realm for the clients of this listener
+	Realm string
+}
+
+func (c *QUICListenerConfig) validate() error {
+	if c.Listener == nil {
+		return errListenerUnset
+	}
+
+	if c.RelayAddressGenerator == nil {
+		return errRelayAddressGeneratorUnset
+	}
+
+	return c.RelayAddressGenerator.Validate()
+}
+
+// NewQUICClientConn opens a stream on conn and returns a net.PacketConn to use as
+// ClientConfig.Conn. ChannelData is sent and received as QUIC datagrams, everything else
+// on the stream. The returned net.PacketConn reads all datagrams of conn, so only one
+// can be created per QUICConnection.
+func NewQUICClientConn(conn QUICConnection) (net.PacketConn, error) {
+	stream, err := conn.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+
+	mux := newQUICMux(conn)
+	c := mux.newStreamConn(stream, conn.LocalAddr())
+	go mux.readDatagrams()
+
+	return &quicClientConn{quicStreamConn: c}, nil
+}
+
+// quicClientConn closes the QUIC connection with its only stream
+type quicClientConn struct {
+	*quicStreamConn
+}
+
+func (c *quicClientConn) Close() error {
+	err := c.quicStreamConn.Close()
+	if closeErr := c.mux.conn.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// quicStreamAddr is the local address of a stream on the server. Allocations are identified
+// by the 5-tuple, so every stream of a QUIC connection needs a distinct local address
+type quicStreamAddr struct {
+	addr     net.Addr
+	streamID uint64
+}
+
+func (a *quicStreamAddr) Network() string {
+	return "quic"
+}
+
+func (a *quicStreamAddr) String() string {
+	return fmt.Sprintf("%s/%d", a.addr, a.streamID)
+}
+
+// quicMux routes the datagrams of a QUIC connection to its streams
+type quicMux struct {
+	conn QUICConnection
+
+	lock    sync.Mutex
+	streams map[uint64]*quicStreamConn
+}
+
+func newQUICMux(conn QUICConnection) *quicMux {
+	return &quicMux{
+		conn:    conn,
+		streams: map[uint64]*quicStreamConn{},
+	}
+}
+
+func (m *quicMux) newStreamConn(stream QUICStream, localAddr net.Addr) *quicStreamConn {
+	c := &quicStreamConn{
+		mux:       m,
+		stream:    stream,
+		localAddr: localAddr,
+		buffer:    packetio.NewBuffer(),
+	}
+	c.buffer.SetLimitSize(quicMaxBufferSize)
+	c.stunConn = NewSTUNConn(&quicStreamNetConn{c})
+
+	m.lock.Lock()
+	m.streams[stream.StreamID()] = c
+	m.lock.Unlock()
+
+	go c.readStream()
+
+	return c
+}
+
+func (m *quicMux) removeStream(streamID uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.streams, streamID)
+}
+
+// readDatagrams runs until the QUIC connection is closed
+func (m *quicMux) readDatagrams() {
+	for {
+		datagram, err := m.conn.ReceiveDatagram()
+		if err != nil {
+			return
+		}
+
+		streamID, n, err := readQUICVarint(datagram)
+		if err != nil || !proto.IsChannelData(datagram[n:]) {
+			continue
+		}
+
+		m.lock.Lock()
+		c, ok := m.streams[streamID]
+		m.lock.Unlock()
+		if ok {
+			_, _ = c.buffer.Write(datagram[n:])
+		}
+	}
+}
+
+// quicStreamConn implements net.PacketConn for the messages of one stream and the
+// datagrams carrying its ChannelData
+type quicStreamConn struct {
+	mux       *quicMux
+	stream    QUICStream
+	stunConn  *STUNConn
+	localAddr net.Addr
+	buffer    *packetio.Buffer
+}
+
+func (c *quicStreamConn) readStream() {
+	buf := make([]byte, quicMaxMessageSize)
+	for {
+		n, _, err := c.stunConn.ReadFrom(buf)
+		if err != nil {
+			_ = c.buffer.Close()
+			return
+		}
+
+		if _, err = c.buffer.Write(buf[:n]); err != nil && !errors.Is(err, packetio.ErrFull) {
+			return
+		}
+	}
+}
+
+func (c *quicStreamConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.buffer.Read(p)
+	if errors.Is(err, io.EOF) {
+		return 0, nil, errQUICStreamClosed
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	return n, c.mux.conn.RemoteAddr(), nil
+}
+
+func (c *quicStreamConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if proto.IsChannelData(p) {
+		datagram := appendQUICVarint(make([]byte, 0, len(p)+8), c.stream.StreamID())
+		if err := c.mux.conn.SendDatagram(append(datagram, p...)); err == nil {
+			return len(p), nil
+		}
+		// Too large for a datagram, send it on the stream instead
+	}
+
+	return c.stunConn.WriteTo(p, addr)
+}
+
+func (c *quicStreamConn) Close() error {
+	c.mux.removeStream(c.stream.StreamID())
+	_ = c.buffer.Close()
+
+	return c.stream.Close()
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *quicStreamConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *quicStreamConn) SetReadDeadline(t time.Time) error {
+	return c.buffer.SetReadDeadline(t)
+}
+
+func (c *quicStreamConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// quicStreamNetConn adapts a QUICStream to the net.Conn a STUNConn frames
+type quicStreamNetConn struct {
+	c *quicStreamConn
+}
+
+func (s *quicStreamNetConn) Read(b []byte) (int, error) {
+	return s.c.stream.Read(b)
+}
+
+func (s *quicStreamNetConn) Write(b []byte) (int, error) {
+	return s.c.stream.Write(b)
+}
+
+func (s *quicStreamNetConn) Close() error {
+	return s.c.stream.Close()
+}
+
+func (s *quicStreamNetConn) LocalAddr() net.Addr {
+	return s.c.localAddr
+}
+
+func (s *quicStreamNetConn) RemoteAddr() net.Addr {
+	return s.c.mux.conn.RemoteAddr()
+}
+
+func (s *quicStreamNetConn) SetDeadline(time.Time) error {
+	return nil
+}
+
+func (s *quicStreamNetConn) SetReadDeadline(time.Time) error {
+	return nil
+}
+
+func (s *quicStreamNetConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// appendQUICVarint appends v as QUIC variable-length integer, see RFC 9000 Section 16
+func appendQUICVarint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, byte(v>>8)|0x40, byte(v))
+	case v < 1<<30:
+		return append(b, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	default:
+		v &= quicMaxVarint
+		return append(b, byte(v>>56)|0xc0, byte(v>>48), byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// readQUICVarint reads a QUIC variable-length integer and returns it with its length
+func readQUICVarint(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, errQUICVarintInvalid
+	}
+
+	length := 1 << (b[0] >> 6)
+	if len(b) < length {
+		return 0, 0, errQUICVarintInvalid
+	}
+
+	v := uint64(b[0] & 0x3f)
+	for i := 1; i < length; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+
+	return v, length, nil
+}
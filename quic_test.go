@@ -0,0 +1,267 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/v3/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errFakeQUICClosed = errors.New("fake QUIC connection closed")
+
+type fakeQUICStream struct {
+	net.Conn
+	id uint64
+}
+
+func (s *fakeQUICStream) StreamID() uint64 {
+	return s.id
+}
+
+// fakeQUICConnection is one end of an in-memory QUIC connection
+type fakeQUICConnection struct {
+	localAddr, remoteAddr net.Addr
+	peer                  *fakeQUICConnection
+
+	acceptCh      chan *fakeQUICStream
+	datagrams     chan []byte
+	datagramsSent uint64
+	nextStreamID  uint64
+
+	lock      sync.Mutex
+	streams   []*fakeQUICStream
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newFakeQUICConnectionPair(clientAddr, serverAddr net.Addr) (*fakeQUICConnection, *fakeQUICConnection) {
+	newConn := func(localAddr, remoteAddr net.Addr) *fakeQUICConnection {
+		return &fakeQUICConnection{
+			localAddr:  localAddr,
+			remoteAddr: remoteAddr,
+			acceptCh:   make(chan *fakeQUICStream, 8),
+			datagrams:  make(chan []byte, 64),
+			closed:     make(chan struct{}),
+		}
+	}
+
+	client, server := newConn(clientAddr, serverAddr), newConn(serverAddr, clientAddr)
+	client.peer, server.peer = server, client
+
+	return client, server
+}
+
+func (c *fakeQUICConnection) addStream(s *fakeQUICStream) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.streams = append(c.streams, s)
+}
+
+func (c *fakeQUICConnection) AcceptStream() (QUICStream, error) {
+	select {
+	case s := <-c.acceptCh:
+		c.addStream(s)
+		return s, nil
+	case <-c.closed:
+		return nil, errFakeQUICClosed
+	}
+}
+
+func (c *fakeQUICConnection) OpenStream() (QUICStream, error) {
+	local, remote := net.Pipe()
+	id := atomic.AddUint64(&c.nextStreamID, 4) - 4
+
+	s := &fakeQUICStream{Conn: local, id: id}
+	c.addStream(s)
+	c.peer.acceptCh <- &fakeQUICStream{Conn: remote, id: id}
+
+	return s, nil
+}
+
+func (c *fakeQUICConnection) SendDatagram(b []byte) error {
+	atomic.AddUint64(&c.datagramsSent, 1)
+	select {
+	case c.peer.datagrams <- append([]byte{}, b...):
+	default: // Datagrams are unreliable
+	}
+
+	return nil
+}
+
+func (c *fakeQUICConnection) ReceiveDatagram() ([]byte, error) {
+	select {
+	case b := <-c.datagrams:
+		return b, nil
+	case <-c.closed:
+		return nil, errFakeQUICClosed
+	}
+}
+
+func (c *fakeQUICConnection) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *fakeQUICConnection) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// Close closes both ends and all streams, like a QUIC CONNECTION_CLOSE
+func (c *fakeQUICConnection) Close() error {
+	c.closeLocal()
+	c.peer.closeLocal()
+
+	return nil
+}
+
+func (c *fakeQUICConnection) closeLocal() {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		for _, s := range c.streams {
+			_ = s.Close()
+		}
+	})
+}
+
+type fakeQUICListener struct {
+	addr      net.Addr
+	conns     chan QUICConnection
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *fakeQUICListener) Accept() (QUICConnection, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, errFakeQUICClosed
+	}
+}
+
+func (l *fakeQUICListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *fakeQUICListener) Addr() net.Addr {
+	return l.addr
+}
+
+func TestQUICVarint(t *testing.T) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30, quicMaxVarint} {
+		b := appendQUICVarint(nil, v)
+		decoded, n, err := readQUICVarint(b)
+		assert.NoError(t, err)
+		assert.Equal(t, len(b), n)
+		assert.Equal(t, v, decoded)
+	}
+
+	_, _, err := readQUICVarint([]byte{0x80, 0x01})
+	assert.ErrorIs(t, err, errQUICVarintInvalid)
+}
+
+func TestServerQUIC(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	serverAddr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 443}
+	listener := &fakeQUICListener{
+		addr:   serverAddr,
+		conns:  make(chan QUICConnection, 1),
+		closed: make(chan struct{}),
+	}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		QUICListenerConfigs: []QUICListenerConfig{
+			{
+				Listener: listener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:         "pion.ly",
+		LoggerFactory: loggerFactory,
+	})
+	require.NoError(t, err)
+
+	clientQUIC, serverQUIC := newFakeQUICConnectionPair(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50000}, serverAddr)
+	listener.conns <- serverQUIC
+
+	conn, err := NewQUICClientConn(clientQUIC)
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr.String(),
+		TURNServerAddr: serverAddr.String(),
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+		LoggerFactory:  loggerFactory,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// The second write is sent as ChannelData in a datagram
+	for _, msg := range []string{"hello", "world"} {
+		_, err = relayConn.WriteTo([]byte(msg), peer.LocalAddr())
+		require.NoError(t, err)
+
+		buf := make([]byte, 64)
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, msg, string(buf[:n]))
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.NotZero(t, atomic.LoadUint64(&clientQUIC.datagramsSent))
+
+	// The channel is bound, so the server relays back in a datagram too
+	_, err = peer.WriteTo([]byte("back"), relayConn.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 64)
+	require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := relayConn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "back", string(buf[:n]))
+	assert.NotZero(t, atomic.LoadUint64(&serverQUIC.datagramsSent))
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// RelayAddressGeneratorDevice binds the relay sockets of RelayAddressGenerator to Device with
+// SO_BINDTODEVICE, so relayed traffic leaves through a dedicated interface, or the routing
+// table of a VRF, while the listeners serve clients on another one. UDP relays and the
+// listeners of RFC 6062 TCP allocations are bound once RelayAddressGenerator created them,
+// peer connections are dialed with the net package and bound before they connect. Linux
+// only. Binding requires CAP_NET_RAW on kernels before 5.7, allocations whose relay can't
+// be bound fail with a 508 (Insufficient Capacity) error
+type RelayAddressGeneratorDevice struct {
+	RelayAddressGenerator
+
+	// Device is the name of the interface or VRF, e.g. "eth1" or "vrf-relay"
+	Device string
+}
+
+// Validate is called on server startup and confirms the RelayAddressGenerator is properly configured
+func (r *RelayAddressGeneratorDevice) Validate() error {
+	switch {
+	case bindToDeviceControl(r.Device) == nil:
+		return errBindToDeviceUnsupported
+	case r.Device == "":
+		return errRelayDeviceUnset
+	case r.RelayAddressGenerator == nil:
+		return errRelayAddressGeneratorUnset
+	}
+
+	return r.RelayAddressGenerator.Validate()
+}
+
+// AllocatePacketConn generates a new PacketConn bound to Device to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorDevice) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, relayAddr, err := r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = r.bind(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, relayAddr, nil
+}
+
+// AllocateEvenPacketConn generates a new PacketConn bound to Device on an even port
+func (r *RelayAddressGeneratorDevice) AllocateEvenPacketConn(network string) (net.PacketConn, net.Addr, error) {
+	evenGenerator, ok := r.RelayAddressGenerator.(EvenPortRelayAddressGenerator)
+	if !ok {
+		return listenEvenPacket(r.AllocatePacketConn, network)
+	}
+
+	conn, relayAddr, err := evenGenerator.AllocateEvenPacketConn(network)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = r.bind(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+
+	return conn, relayAddr, nil
+}
+
+// AllocateListener generates a new Listener bound to Device to accept the peer connections of
+// a TCP allocation on, if RelayAddressGenerator implements TCPRelayAddressGenerator
+func (r *RelayAddressGeneratorDevice) AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error) {
+	tcpGenerator, ok := r.RelayAddressGenerator.(TCPRelayAddressGenerator)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", errTCPRelayAddressGeneratorUnsupported, network)
+	}
+
+	listener, relayAddr, err := tcpGenerator.AllocateListener(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = r.bind(listener); err != nil {
+		_ = listener.Close()
+		return nil, nil, err
+	}
+
+	return listener, relayAddr, nil
+}
+
+// DialPeer connects to a peer on behalf of a TCP allocation from a socket bound to Device
+func (r *RelayAddressGeneratorDevice) DialPeer(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: tcpConnectTimeout,
+		Control: bindToDeviceControl(r.Device),
+	}
+	if tcpAddr, ok := localAddr.(*net.TCPAddr); ok {
+		dialer.LocalAddr = &net.TCPAddr{IP: tcpAddr.IP, Zone: tcpAddr.Zone}
+	}
+
+	return dialer.Dial(network, peerAddr.String())
+}
+
+// bind binds conn, a socket implementing syscall.Conn, to Device
+func (r *RelayAddressGeneratorDevice) bind(conn interface{}) error {
+	control := bindToDeviceControl(r.Device)
+	syscallConn, ok := conn.(syscall.Conn)
+	if !ok || control == nil {
+		return fmt.Errorf("%w: %T", errBindToDeviceUnsupported, conn)
+	}
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	if err = control("", "", rawConn); err != nil {
+		return fmt.Errorf("failed to bind relay to %s: %w", r.Device, err)
+	}
+	return nil
+}
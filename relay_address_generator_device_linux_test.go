@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func boundDevice(t *testing.T, conn syscall.Conn) string {
+	rawConn, err := conn.SyscallConn()
+	require.NoError(t, err)
+
+	var device string
+	var sockErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		device, sockErr = unix.GetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+	}))
+	require.NoError(t, sockErr)
+
+	return device
+}
+
+func TestRelayAddressGeneratorDevice(t *testing.T) {
+	static := &RelayAddressGeneratorStatic{RelayAddress: net.ParseIP("127.0.0.1"), Address: "127.0.0.1"}
+	assert.ErrorIs(t, (&RelayAddressGeneratorDevice{RelayAddressGenerator: static}).Validate(), errRelayDeviceUnset)
+	assert.ErrorIs(t, (&RelayAddressGeneratorDevice{Device: "lo"}).Validate(), errRelayAddressGeneratorUnset)
+
+	generator := &RelayAddressGeneratorDevice{RelayAddressGenerator: static, Device: "lo"}
+	require.NoError(t, generator.Validate())
+
+	conn, relayAddr, err := generator.AllocatePacketConn("udp4", 0)
+	if errors.Is(err, unix.EPERM) {
+		t.Skip("binding sockets to devices requires CAP_NET_RAW")
+	}
+	require.NoError(t, err)
+	assert.Equal(t, conn.LocalAddr(), relayAddr)
+	assert.Equal(t, "lo", boundDevice(t, conn.(*net.UDPConn))) //nolint:forcetypeassert
+	assert.NoError(t, conn.Close())
+
+	conn, relayAddr, err = generator.AllocateEvenPacketConn("udp4")
+	require.NoError(t, err)
+	assert.Equal(t, 0, relayAddr.(*net.UDPAddr).Port%2)        //nolint:forcetypeassert
+	assert.Equal(t, "lo", boundDevice(t, conn.(*net.UDPConn))) //nolint:forcetypeassert
+	assert.NoError(t, conn.Close())
+
+	listener, relayAddr, err := generator.AllocateListener("tcp4", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "lo", boundDevice(t, listener.(syscall.Conn))) //nolint:forcetypeassert
+
+	// Peer connections are bound before they connect
+	peerConn, err := generator.DialPeer("tcp4", relayAddr, listener.Addr())
+	require.NoError(t, err)
+	assert.Equal(t, "lo", boundDevice(t, peerConn.(*net.TCPConn))) //nolint:forcetypeassert
+	accepted, err := listener.Accept()
+	require.NoError(t, err)
+
+	assert.NoError(t, accepted.Close())
+	assert.NoError(t, peerConn.Close())
+	assert.NoError(t, listener.Close())
+
+	// Devices that don't exist fail the allocation
+	generator.Device = "turn-missing0"
+	_, _, err = generator.AllocatePacketConn("udp4", 0)
+	assert.ErrorIs(t, err, unix.ENODEV)
+}
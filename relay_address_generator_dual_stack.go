@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"fmt"
+	"net"
+)
+
+// RelayAddressGeneratorDualStack creates the IPv4 relays with IPv4 and the IPv6 relays with
+// IPv6, so each address family has its own addresses and port range, e.g. two
+// RelayAddressGeneratorStatic with their own PortRangeAllocator. Allocations requested with
+// ADDITIONAL-ADDRESS-FAMILY (RFC 8656 Section 7.2) get an IPv4 relay from IPv4 and an IPv6
+// relay from IPv6.
+type RelayAddressGeneratorDualStack struct {
+	IPv4 RelayAddressGenerator
+	IPv6 RelayAddressGenerator
+}
+
+// Validate is called on server startup and confirms the RelayAddressGenerator is properly configured
+func (r *RelayAddressGeneratorDualStack) Validate() error {
+	if r.IPv4 == nil || r.IPv6 == nil {
+		return errDualStackGeneratorUnset
+	}
+
+	if err := r.IPv4.Validate(); err != nil {
+		return fmt.Errorf("IPv4: %w", err)
+	}
+	if err := r.IPv6.Validate(); err != nil {
+		return fmt.Errorf("IPv6: %w", err)
+	}
+
+	return nil
+}
+
+// generator returns the generator of the address family of network
+func (r *RelayAddressGeneratorDualStack) generator(network string) RelayAddressGenerator {
+	if isIPv6Network(network) {
+		return r.IPv6
+	}
+	return r.IPv4
+}
+
+// AllocatePacketConn generates a new PacketConn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorDualStack) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	return r.generator(network).AllocatePacketConn(network, requestedPort)
+}
+
+// AllocateConn generates a new Conn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorDualStack) AllocateConn(network string, requestedPort int) (net.Conn, net.Addr, error) {
+	return r.generator(network).AllocateConn(network, requestedPort)
+}
+
+// AllocateEvenPacketConn generates a new PacketConn on an even port, if the generator of the
+// address family of network implements EvenPortRelayAddressGenerator
+func (r *RelayAddressGeneratorDualStack) AllocateEvenPacketConn(network string) (net.PacketConn, net.Addr, error) {
+	evenGenerator, ok := r.generator(network).(EvenPortRelayAddressGenerator)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", errEvenPortUnsupported, network)
+	}
+
+	return evenGenerator.AllocateEvenPacketConn(network)
+}
+
+// AllocateListener generates a new Listener to accept the peer connections of a TCP
+// allocation on, if the generator of the address family of network implements
+// TCPRelayAddressGenerator
+func (r *RelayAddressGeneratorDualStack) AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error) {
+	tcpGenerator, ok := r.generator(network).(TCPRelayAddressGenerator)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", errTCPRelayAddressGeneratorUnsupported, network)
+	}
+
+	return tcpGenerator.AllocateListener(network, requestedPort)
+}
+
+// DialPeer connects to a peer on behalf of a TCP allocation
+func (r *RelayAddressGeneratorDualStack) DialPeer(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+	tcpGenerator, ok := r.generator(network).(TCPRelayAddressGenerator)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errTCPRelayAddressGeneratorUnsupported, network)
+	}
+
+	return tcpGenerator.DialPeer(network, localAddr, peerAddr)
+}
@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// isIPv6Network returns true for the networks of IPv6 relays, e.g. "udp6"
+func isIPv6Network(network string) bool {
+	return strings.HasSuffix(network, "6")
+}
+
+// checkAddressFamily returns ErrAddressFamilyNotSupported if ip can't be used for a relay
+// on network. A nil ip, e.g. a hostname that failed to parse, and the unspecified IPv6
+// address "::", which listens on both families, are accepted for any network
+func checkAddressFamily(network string, ip net.IP) error {
+	if ip == nil || ip.Equal(net.IPv6unspecified) {
+		return nil
+	}
+
+	if (ip.To4() == nil) != isIPv6Network(network) {
+		return fmt.Errorf("%w: %s relay on %s", ErrAddressFamilyNotSupported, network, ip)
+	}
+
+	return nil
+}
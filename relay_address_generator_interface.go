@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/stdnet"
+)
+
+// RelayAddressGeneratorInterface creates relays on the address of a network interface, e.g.
+// "eth1" of a multi-homed host. The address is looked up for every relay, so relays follow
+// address changes of the interface. Relays are only handed out for the address families the
+// interface has an address of. With the real network stack, relay sockets are also bound to
+// the interface with SO_BINDTODEVICE on Linux, which requires CAP_NET_RAW.
+type RelayAddressGeneratorInterface struct {
+	// Interface is the name of the network interface
+	Interface string
+
+	// RelayAddress is returned to the user instead of the address of the interface if
+	// set, e.g. the public address of a 1:1 NAT in front of it
+	RelayAddress net.IP
+
+	Net transport.Net
+}
+
+// Validate is called on server startup and confirms the RelayAddressGenerator is properly configured
+func (r *RelayAddressGeneratorInterface) Validate() error {
+	if r.Net == nil {
+		var err error
+		r.Net, err = stdnet.NewNet()
+		if err != nil {
+			return fmt.Errorf("failed to create network: %w", err)
+		}
+	}
+
+	if r.Interface == "" {
+		return errInterfaceUnset
+	}
+
+	return nil
+}
+
+// AllocatePacketConn generates a new PacketConn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorInterface) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	ip, err := r.interfaceIP(network)
+	if err != nil {
+		return nil, nil, err
+	}
+	address := net.JoinHostPort(ip.String(), strconv.Itoa(requestedPort))
+
+	var conn net.PacketConn
+	if _, ok := r.Net.(*stdnet.Net); ok {
+		listenConfig := net.ListenConfig{Control: bindToDeviceControl(r.Interface)}
+		conn, err = listenConfig.ListenPacket(context.Background(), network, address)
+	} else {
+		conn, err = r.Net.ListenPacket(network, address)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relayAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		_ = conn.Close()
+		return nil, nil, errNilConn
+	}
+
+	return conn, &net.UDPAddr{IP: r.relayIP(relayAddr.IP), Port: relayAddr.Port, Zone: relayAddr.Zone}, nil
+}
+
+// AllocateConn generates a new Conn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorInterface) AllocateConn(string, int) (net.Conn, net.Addr, error) {
+	return nil, nil, errTODO
+}
+
+// AllocateListener generates a new Listener to accept the peer connections of a TCP allocation on
+func (r *RelayAddressGeneratorInterface) AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error) {
+	ip, err := r.interfaceIP(network)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, ok := r.Net.(*stdnet.Net); !ok {
+		listener, relayAddr, listenErr := listenTCPRelay(r.Net, network, ip.String(), requestedPort)
+		if listenErr != nil {
+			return nil, nil, listenErr
+		}
+		relayAddr.IP = r.relayIP(relayAddr.IP)
+
+		return listener, relayAddr, nil
+	}
+
+	listenConfig := net.ListenConfig{Control: bindToDeviceControl(r.Interface)}
+	listener, err := listenConfig.Listen(context.Background(), network, net.JoinHostPort(ip.String(), strconv.Itoa(requestedPort)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relayAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		_ = listener.Close()
+		return nil, nil, errNilConn
+	}
+
+	return listener, &net.TCPAddr{IP: r.relayIP(relayAddr.IP), Port: relayAddr.Port, Zone: relayAddr.Zone}, nil
+}
+
+// DialPeer connects to a peer on behalf of a TCP allocation
+func (r *RelayAddressGeneratorInterface) DialPeer(network string, _, peerAddr net.Addr) (net.Conn, error) {
+	ip, err := r.interfaceIP(network)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := r.Net.(*stdnet.Net); !ok {
+		return dialTCPPeer(r.Net, network, &net.TCPAddr{IP: ip}, peerAddr)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   tcpConnectTimeout,
+		LocalAddr: &net.TCPAddr{IP: ip},
+		Control:   bindToDeviceControl(r.Interface),
+	}
+	return dialer.Dial(network, peerAddr.String())
+}
+
+// interfaceIP returns the first address of the interface of the address family of network
+func (r *RelayAddressGeneratorInterface) interfaceIP(network string) (net.IP, error) {
+	ips, err := interfaceIPs(r.Net, r.Interface)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		if checkAddressFamily(network, ip) == nil {
+			return ip, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s relay on %s", ErrAddressFamilyNotSupported, network, r.Interface)
+}
+
+func (r *RelayAddressGeneratorInterface) relayIP(ip net.IP) net.IP {
+	if r.RelayAddress != nil {
+		return r.RelayAddress
+	}
+	return ip
+}
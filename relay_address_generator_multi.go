@@ -0,0 +1,231 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/pion/randutil"
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/stdnet"
+)
+
+// RelayAddressPair is an address RelayAddressGeneratorMulti creates relays on
+type RelayAddressPair struct {
+	// RelayAddress is the IP returned to the user when a relay is created on the pair
+	RelayAddress net.IP
+
+	// Address is passed to Listen/ListenPacket when creating a relay on the pair
+	Address string
+
+	// MinPort and MaxPort (inclusive) restrict the ports of the relays on the pair. Any
+	// port is used if both are 0
+	MinPort uint16
+	MaxPort uint16
+}
+
+// ports returns the number of ports of the range of the pair, 0 if it has none
+func (p RelayAddressPair) ports() int {
+	if p.MinPort == 0 {
+		return 0
+	}
+	return int(p.MaxPort-p.MinPort) + 1
+}
+
+// RelayAddressGeneratorMulti spreads relays over several addresses, e.g. the public IPs of
+// a server, to spread port consumption and bandwidth over them. Allocations take turns on
+// the addresses of their address family. The ports in use are counted per address, an
+// address whose port range is used up is skipped until one of its relays is closed.
+type RelayAddressGeneratorMulti struct {
+	// Addresses are the addresses relays are created on
+	Addresses []RelayAddressPair
+
+	// MaxRetries the amount of tries to allocate a random port in the range of an address
+	MaxRetries int
+
+	// Rand the random source of numbers
+	Rand randutil.MathRandomGenerator
+
+	Net transport.Net
+
+	lock  sync.Mutex
+	next  int
+	inUse []int
+}
+
+// Validate is called on server startup and confirms the RelayAddressGenerator is properly configured
+func (r *RelayAddressGeneratorMulti) Validate() error {
+	if r.Net == nil {
+		var err error
+		r.Net, err = stdnet.NewNet()
+		if err != nil {
+			return fmt.Errorf("failed to create network: %w", err)
+		}
+	}
+
+	if r.Rand == nil {
+		r.Rand = randutil.NewMathRandomGenerator()
+	}
+
+	if r.MaxRetries == 0 {
+		r.MaxRetries = 10
+	}
+
+	if len(r.Addresses) == 0 {
+		return errRelayAddressesUnset
+	}
+	for _, pair := range r.Addresses {
+		switch {
+		case pair.RelayAddress == nil:
+			return errRelayAddressInvalid
+		case pair.Address == "":
+			return errListeningAddressInvalid
+		case (pair.MinPort == 0) != (pair.MaxPort == 0) || pair.MinPort > pair.MaxPort:
+			return fmt.Errorf("%w: %d-%d", errRelayPortRangeInvalid, pair.MinPort, pair.MaxPort)
+		}
+	}
+
+	r.lock.Lock()
+	r.inUse = make([]int, len(r.Addresses))
+	r.lock.Unlock()
+
+	return nil
+}
+
+// PortsInUse returns the number of relays open on each of Addresses
+func (r *RelayAddressGeneratorMulti) PortsInUse() []int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return append([]int{}, r.inUse...)
+}
+
+// AllocatePacketConn generates a new PacketConn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorMulti) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	var conn net.PacketConn
+	relayAddr, err := r.allocate(network, requestedPort, func(index int, pair RelayAddressPair, port int) (net.Addr, error) {
+		c, err := r.Net.ListenPacket(network, net.JoinHostPort(pair.Address, strconv.Itoa(port)))
+		if err != nil {
+			return nil, err
+		}
+
+		udpAddr, ok := c.LocalAddr().(*net.UDPAddr)
+		if !ok {
+			_ = c.Close()
+			return nil, errNilConn
+		}
+
+		conn = &releasingPacketConn{PacketConn: c, release: r.releaser(index)}
+		return &net.UDPAddr{IP: pair.RelayAddress, Port: udpAddr.Port}, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return conn, relayAddr, nil
+}
+
+// AllocateConn generates a new Conn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorMulti) AllocateConn(string, int) (net.Conn, net.Addr, error) {
+	return nil, nil, errTODO
+}
+
+// AllocateListener generates a new Listener to accept the peer connections of a TCP allocation on
+func (r *RelayAddressGeneratorMulti) AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error) {
+	var listener net.Listener
+	relayAddr, err := r.allocate(network, requestedPort, func(index int, pair RelayAddressPair, port int) (net.Addr, error) {
+		l, tcpAddr, err := listenTCPRelay(r.Net, network, pair.Address, port)
+		if err != nil {
+			return nil, err
+		}
+
+		listener = &releasingListener{Listener: l, release: r.releaser(index)}
+		tcpAddr.IP = pair.RelayAddress
+		return tcpAddr, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return listener, relayAddr, nil
+}
+
+// DialPeer connects to a peer on behalf of a TCP allocation
+func (r *RelayAddressGeneratorMulti) DialPeer(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+	return dialTCPPeer(r.Net, network, localAddr, peerAddr)
+}
+
+// allocate calls listen on the addresses of network in turn until it succeeds, with
+// requestedPort or with random ports of the range of an address
+func (r *RelayAddressGeneratorMulti) allocate(network string, requestedPort int, listen func(index int, pair RelayAddressPair, port int) (net.Addr, error)) (net.Addr, error) {
+	candidates := r.candidates(network)
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: %s relay", ErrAddressFamilyNotSupported, network)
+	}
+
+	err := errMaxRetriesExceeded
+	for _, index := range candidates {
+		pair := r.Addresses[index]
+
+		ports := []int{requestedPort}
+		if requestedPort == 0 && pair.ports() != 0 {
+			ports = make([]int, r.MaxRetries)
+			for i := range ports {
+				ports[i] = int(pair.MinPort) + r.Rand.Intn(pair.ports())
+			}
+		}
+
+		for _, port := range ports {
+			relayAddr, listenErr := listen(index, pair, port)
+			if listenErr != nil {
+				err = listenErr
+				continue
+			}
+
+			return relayAddr, nil
+		}
+	}
+
+	return nil, err
+}
+
+// candidates returns the indexes of the addresses of network that have free ports,
+// starting with the one in turn
+func (r *RelayAddressGeneratorMulti) candidates(network string) []int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	candidates := []int{}
+	for i := range r.Addresses {
+		index := (r.next + i) % len(r.Addresses)
+		pair := r.Addresses[index]
+
+		if checkAddressFamily(network, pair.RelayAddress) != nil || checkAddressFamily(network, net.ParseIP(pair.Address)) != nil {
+			continue
+		}
+		if ports := pair.ports(); ports != 0 && r.inUse[index] >= ports {
+			continue
+		}
+		candidates = append(candidates, index)
+	}
+	r.next = (r.next + 1) % len(r.Addresses)
+
+	return candidates
+}
+
+// releaser counts a relay on the address of index, and returns the func that uncounts it
+func (r *RelayAddressGeneratorMulti) releaser(index int) func() {
+	r.lock.Lock()
+	r.inUse[index]++
+	r.lock.Unlock()
+
+	return onlyOnce(func() {
+		r.lock.Lock()
+		r.inUse[index]--
+		r.lock.Unlock()
+	})
+}
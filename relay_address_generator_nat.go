@@ -0,0 +1,124 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pion/transport/v3"
+	"github.com/pion/transport/v3/stdnet"
+)
+
+// AddressMapping maps the public IP of a 1:1 NAT to the private IP of the host behind it
+type AddressMapping struct {
+	// PublicIP is the IP returned to the user when a relay is created on the mapping
+	PublicIP net.IP
+
+	// PrivateIP is the IP of the host the relays are bound to
+	PrivateIP net.IP
+}
+
+// RelayAddressGeneratorNAT creates relays behind 1:1 NATs, like the external-ip option of
+// coturn, e.g. on EC2 or GCP where the host never sees its public IP. Relays are bound to
+// the private IP of a mapping and its public IP is advertised. The mapping is selected by
+// the listener the Allocate request arrived on: the one whose PrivateIP the listener is
+// bound to, or the first one of the address family of the relay for listeners bound to
+// an unspecified address.
+type RelayAddressGeneratorNAT struct {
+	// Mappings are the public/private IP pairs of the host
+	Mappings []AddressMapping
+
+	Net transport.Net
+}
+
+// Validate is called on server startup and confirms the RelayAddressGenerator is properly configured
+func (r *RelayAddressGeneratorNAT) Validate() error {
+	if r.Net == nil {
+		var err error
+		r.Net, err = stdnet.NewNet()
+		if err != nil {
+			return fmt.Errorf("failed to create network: %w", err)
+		}
+	}
+
+	if len(r.Mappings) == 0 {
+		return errAddressMappingsUnset
+	}
+	for _, mapping := range r.Mappings {
+		if mapping.PublicIP == nil || mapping.PrivateIP == nil {
+			return errAddressMappingInvalid
+		}
+	}
+
+	return nil
+}
+
+// ForListener returns the RelayAddressGenerator of the mapping of the IP of listenerAddr
+func (r *RelayAddressGeneratorNAT) ForListener(listenerAddr net.Addr) (RelayAddressGenerator, error) {
+	host, _, err := net.SplitHostPort(listenerAddr.String())
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		return r, nil
+	}
+
+	for _, mapping := range r.Mappings {
+		if mapping.PrivateIP.Equal(ip) {
+			return r.static(mapping), nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s", errNoAddressMapping, ip)
+}
+
+// AllocatePacketConn generates a new PacketConn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorNAT) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	mapping, err := r.mapping(network)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.static(mapping).AllocatePacketConn(network, requestedPort)
+}
+
+// AllocateConn generates a new Conn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorNAT) AllocateConn(string, int) (net.Conn, net.Addr, error) {
+	return nil, nil, errTODO
+}
+
+// AllocateListener generates a new Listener to accept the peer connections of a TCP allocation on
+func (r *RelayAddressGeneratorNAT) AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error) {
+	mapping, err := r.mapping(network)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.static(mapping).AllocateListener(network, requestedPort)
+}
+
+// DialPeer connects to a peer on behalf of a TCP allocation
+func (r *RelayAddressGeneratorNAT) DialPeer(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+	return dialTCPPeer(r.Net, network, localAddr, peerAddr)
+}
+
+// mapping returns the first mapping of the address family of network
+func (r *RelayAddressGeneratorNAT) mapping(network string) (AddressMapping, error) {
+	for _, mapping := range r.Mappings {
+		if checkAddressFamily(network, mapping.PublicIP) == nil && checkAddressFamily(network, mapping.PrivateIP) == nil {
+			return mapping, nil
+		}
+	}
+
+	return AddressMapping{}, fmt.Errorf("%w: %s relay", ErrAddressFamilyNotSupported, network)
+}
+
+func (r *RelayAddressGeneratorNAT) static(mapping AddressMapping) *RelayAddressGeneratorStatic {
+	return &RelayAddressGeneratorStatic{
+		RelayAddress: mapping.PublicIP,
+		Address:      mapping.PrivateIP.String(),
+		Net:          r.Net,
+	}
+}
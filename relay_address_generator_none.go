@@ -12,7 +12,8 @@ import (
 	"github.com/pion/transport/v3/stdnet"
 )
 
-// RelayAddressGeneratorNone returns the listener with no modifications
+// RelayAddressGeneratorNone returns the listener with no modifications. Relays are handed out
+// for the address family of Address, or for both families if it is "::"
 type RelayAddressGeneratorNone struct {
 	// Address is passed to Listen/ListenPacket when creating the Relay
 	Address string
@@ -40,7 +41,11 @@ func (r *RelayAddressGeneratorNone) Validate() error {
 
 // AllocatePacketConn generates a new PacketConn to receive traffic on and the IP/Port to populate the allocation response with
 func (r *RelayAddressGeneratorNone) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
-	conn, err := r.Net.ListenPacket(network, r.Address+":"+strconv.Itoa(requestedPort))
+	if err := checkAddressFamily(network, net.ParseIP(r.Address)); err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := r.Net.ListenPacket(network, net.JoinHostPort(r.Address, strconv.Itoa(requestedPort)))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -52,3 +57,22 @@ func (r *RelayAddressGeneratorNone) AllocatePacketConn(network string, requested
 func (r *RelayAddressGeneratorNone) AllocateConn(string, int) (net.Conn, net.Addr, error) {
 	return nil, nil, errTODO
 }
+
+// AllocateListener generates a new Listener to accept the peer connections of a TCP allocation on
+func (r *RelayAddressGeneratorNone) AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error) {
+	if err := checkAddressFamily(network, net.ParseIP(r.Address)); err != nil {
+		return nil, nil, err
+	}
+
+	listener, relayAddr, err := listenTCPRelay(r.Net, network, r.Address, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return listener, relayAddr, nil
+}
+
+// DialPeer connects to a peer on behalf of a TCP allocation
+func (r *RelayAddressGeneratorNone) DialPeer(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+	return dialTCPPeer(r.Net, network, localAddr, peerAddr)
+}
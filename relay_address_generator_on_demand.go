@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// RelayAddressGeneratorOnDemand defers binding the relay sockets of RelayAddressGenerator
+// until the first CreatePermission or ChannelBind request of an allocation, so allocations
+// that ICE agents create speculatively and never use don't keep a port open. The port is
+// picked by binding and closing a socket when the allocation is created, and bound again
+// on demand. If another socket took the port in between, the request is rejected with a
+// 508 (Insufficient Capacity) error. Only UDP relays are deferred, and DONT-FRAGMENT isn't
+// supported on them.
+type RelayAddressGeneratorOnDemand struct {
+	RelayAddressGenerator
+}
+
+// AllocatePacketConn returns a PacketConn that is bound on the first permission of the
+// allocation, and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorOnDemand) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, relayAddr, err := r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	localAddr := conn.LocalAddr()
+	if err = conn.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	udpAddr, ok := localAddr.(*net.UDPAddr)
+	if !ok {
+		return nil, nil, errNilConn
+	}
+
+	return &onDemandPacketConn{
+		generator: r.RelayAddressGenerator,
+		network:   network,
+		port:      udpAddr.Port,
+		localAddr: localAddr,
+		bound:     make(chan struct{}),
+		closed:    make(chan struct{}),
+	}, relayAddr, nil
+}
+
+// onDemandPacketConn is a relay socket that is bound by Activate
+type onDemandPacketConn struct {
+	generator RelayAddressGenerator
+	network   string
+	port      int
+	localAddr net.Addr
+
+	lock          sync.Mutex
+	conn          net.PacketConn
+	readDeadline  time.Time
+	writeDeadline time.Time
+	bound         chan struct{}
+	closed        chan struct{}
+}
+
+// Activate binds the socket, it is called before the allocation gets its first permission
+func (c *onDemandPacketConn) Activate() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.closed:
+		return errOnDemandConnClosed
+	case <-c.bound:
+		return nil
+	default:
+	}
+
+	conn, _, err := c.generator.AllocatePacketConn(c.network, c.port)
+	if err != nil {
+		return err
+	}
+	if err = conn.SetReadDeadline(c.readDeadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if err = conn.SetWriteDeadline(c.writeDeadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	c.conn = conn
+	close(c.bound)
+	return nil
+}
+
+func (c *onDemandPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-c.bound:
+		return c.conn.ReadFrom(p)
+	case <-c.closed:
+		return 0, nil, errOnDemandConnClosed
+	}
+}
+
+func (c *onDemandPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if err := c.Activate(); err != nil {
+		return 0, err
+	}
+	return c.conn.WriteTo(p, addr)
+}
+
+func (c *onDemandPacketConn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	select {
+	case <-c.closed:
+		return nil
+	default:
+		close(c.closed)
+	}
+
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.Close()
+}
+
+func (c *onDemandPacketConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *onDemandPacketConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *onDemandPacketConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.readDeadline = t
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *onDemandPacketConn) SetWriteDeadline(t time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.writeDeadline = t
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.SetWriteDeadline(t)
+}
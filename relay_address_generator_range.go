@@ -31,6 +31,10 @@ type RelayAddressGeneratorPortRange struct {
 	// Rand the random source of numbers
 	Rand randutil.MathRandomGenerator
 
+	// PortAllocator selects the ports of relays. Defaults to a PortRangeAllocator of
+	// MinPort-MaxPort, MinPort and MaxPort are ignored if set
+	PortAllocator PortAllocator
+
 	// Address is passed to Listen/ListenPacket when creating the Relay
 	Address string
 
@@ -55,11 +59,17 @@ func (r *RelayAddressGeneratorPortRange) Validate() error {
 		r.MaxRetries = 10
 	}
 
+	if r.PortAllocator == nil {
+		switch {
+		case r.MinPort == 0:
+			return errMinPortNotZero
+		case r.MaxPort == 0:
+			return errMaxPortNotZero
+		}
+		r.PortAllocator = &PortRangeAllocator{MinPort: r.MinPort, MaxPort: r.MaxPort, Rand: r.Rand}
+	}
+
 	switch {
-	case r.MinPort == 0:
-		return errMinPortNotZero
-	case r.MaxPort == 0:
-		return errMaxPortNotZero
 	case len(r.HostName) == 0 || len(r.PublicIP) == 0:
 		return errRelayAddressInvalid
 	case r.Address == "":
@@ -69,55 +79,79 @@ func (r *RelayAddressGeneratorPortRange) Validate() error {
 	}
 }
 
-func resolveHostName(hostName string, ipaddress string) net.IP {	
-    ips, _ := net.LookupIP(hostName)
-    
-    for _, ip := range ips {
-        if ipv4 := ip.To4(); ipv4 != nil {
+func resolveHostName(hostName string, ipaddress string, network string) net.IP {
+	ips, _ := net.LookupIP(hostName)
+
+	for _, ip := range ips {
+		if checkAddressFamily(network, ip) == nil {
 			fmt.Printf("resolveHostName - lookup: %s = %s \n", hostName, ip.String())
 			return ip
-        }
-    }
-	fmt.Printf("resolveHostName - default: %s = %s \n", hostName, ipaddress)	
+		}
+	}
+	fmt.Printf("resolveHostName - default: %s = %s \n", hostName, ipaddress)
 	return net.ParseIP(ipaddress)
 }
 
-// AllocatePacketConn generates a new PacketConn to receive traffic on and the IP/Port to populate the allocation response with
-func (r *RelayAddressGeneratorPortRange) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
-	if requestedPort != 0 {
-		conn, err := r.Net.ListenPacket(network, fmt.Sprintf("%s:%d", r.Address, requestedPort))
-		if err != nil {
-			return nil, nil, err
-		}
-		relayAddr, ok := conn.LocalAddr().(*net.UDPAddr)
-		if !ok {
-			return nil, nil, errNilConn
-		}
+// relayIP returns the IP returned to the user for a relay on network
+func (r *RelayAddressGeneratorPortRange) relayIP(network string) (net.IP, error) {
+	if err := checkAddressFamily(network, net.ParseIP(r.Address)); err != nil {
+		return nil, err
+	}
 
-		relayAddr.IP = resolveHostName(r.HostName, r.PublicIP)
-		return conn, relayAddr, nil
+	ip := resolveHostName(r.HostName, r.PublicIP, network)
+	if err := checkAddressFamily(network, ip); err != nil {
+		return nil, err
 	}
+	return ip, nil
+}
 
-	for try := 0; try < r.MaxRetries; try++ {
-		port := r.MinPort + uint16(r.Rand.Intn(int((r.MaxPort+1)-r.MinPort)))
-		conn, err := r.Net.ListenPacket(network, fmt.Sprintf("%s:%d", r.Address, port))
-		if err != nil {
-			continue
-		}
+// AllocatePacketConn generates a new PacketConn to receive traffic on and the IP/Port to populate the allocation response with
+func (r *RelayAddressGeneratorPortRange) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	return r.allocatePacketConn(network, requestedPort, false)
+}
 
-		relayAddr, ok := conn.LocalAddr().(*net.UDPAddr)
-		if !ok {
-			return nil, nil, errNilConn
-		}
+// AllocateEvenPacketConn generates a new PacketConn on an even port inside the port range
+func (r *RelayAddressGeneratorPortRange) AllocateEvenPacketConn(network string) (net.PacketConn, net.Addr, error) {
+	return r.allocatePacketConn(network, 0, true)
+}
+
+func (r *RelayAddressGeneratorPortRange) allocatePacketConn(network string, requestedPort int, even bool) (net.PacketConn, net.Addr, error) {
+	ip, err := r.relayIP(network)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		relayAddr.IP = resolveHostName(r.HostName, r.PublicIP)
-		return conn, relayAddr, nil
+	conn, relayAddr, err := listenPacketOnPort(r.Net, r.PortAllocator, network, r.Address, requestedPort, even, r.MaxRetries)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil, nil, errMaxRetriesExceeded
+	relayAddr.IP = ip
+	return conn, relayAddr, nil
 }
 
 // AllocateConn generates a new Conn to receive traffic on and the IP/Port to populate the allocation response with
 func (r *RelayAddressGeneratorPortRange) AllocateConn(string, int) (net.Conn, net.Addr, error) {
 	return nil, nil, errTODO
 }
+
+// AllocateListener generates a new Listener inside the port range to accept the peer connections of a TCP allocation on
+func (r *RelayAddressGeneratorPortRange) AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error) {
+	ip, err := r.relayIP(network)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listener, relayAddr, err := listenTCPOnPort(r.Net, r.PortAllocator, network, r.Address, requestedPort, r.MaxRetries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relayAddr.IP = ip
+	return listener, relayAddr, nil
+}
+
+// DialPeer connects to a peer on behalf of a TCP allocation
+func (r *RelayAddressGeneratorPortRange) DialPeer(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+	return dialTCPPeer(r.Net, network, localAddr, peerAddr)
+}
@@ -13,7 +13,9 @@ import (
 )
 
 // RelayAddressGeneratorStatic can be used to return static IP address each time a relay is created.
-// This can be used when you have a single static IP address that you want to use
+// This can be used when you have a single static IP address that you want to use. Relays are
+// only handed out for the address family of RelayAddress, so set an IPv6 RelayAddress and
+// listen on an IPv6 Address (or "::") to serve RFC 6156 IPv6 allocations
 type RelayAddressGeneratorStatic struct {
 	// RelayAddress is the IP returned to the user when the relay is created
 	RelayAddress net.IP
@@ -21,6 +23,12 @@ type RelayAddressGeneratorStatic struct {
 	// Address is passed to Listen/ListenPacket when creating the Relay
 	Address string
 
+	// PortAllocator selects the ports of relays. The operating system selects them if nil
+	PortAllocator PortAllocator
+
+	// MaxRetries the amount of tries to bind a port of PortAllocator. Defaults to 10
+	MaxRetries int
+
 	Net transport.Net
 }
 
@@ -34,6 +42,10 @@ func (r *RelayAddressGeneratorStatic) Validate() error {
 		}
 	}
 
+	if r.MaxRetries == 0 {
+		r.MaxRetries = 10
+	}
+
 	switch {
 	case r.RelayAddress == nil:
 		return errRelayAddressInvalid
@@ -46,7 +58,21 @@ func (r *RelayAddressGeneratorStatic) Validate() error {
 
 // AllocatePacketConn generates a new PacketConn to receive traffic on and the IP/Port to populate the allocation response with
 func (r *RelayAddressGeneratorStatic) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
-	conn, err := r.Net.ListenPacket(network, r.Address+":"+strconv.Itoa(requestedPort))
+	if err := r.checkAddressFamily(network); err != nil {
+		return nil, nil, err
+	}
+
+	if r.PortAllocator != nil {
+		conn, relayAddr, err := listenPacketOnPort(r.Net, r.PortAllocator, network, r.Address, requestedPort, false, r.MaxRetries)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		relayAddr.IP = r.RelayAddress
+		return conn, relayAddr, nil
+	}
+
+	conn, err := r.Net.ListenPacket(network, net.JoinHostPort(r.Address, strconv.Itoa(requestedPort)))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -62,7 +88,66 @@ func (r *RelayAddressGeneratorStatic) AllocatePacketConn(network string, request
 	return conn, relayAddr, nil
 }
 
+// AllocateEvenPacketConn generates a new PacketConn on an even port
+func (r *RelayAddressGeneratorStatic) AllocateEvenPacketConn(network string) (net.PacketConn, net.Addr, error) {
+	if r.PortAllocator == nil {
+		return listenEvenPacket(r.AllocatePacketConn, network)
+	}
+
+	if err := r.checkAddressFamily(network); err != nil {
+		return nil, nil, err
+	}
+
+	conn, relayAddr, err := listenPacketOnPort(r.Net, r.PortAllocator, network, r.Address, 0, true, r.MaxRetries)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relayAddr.IP = r.RelayAddress
+	return conn, relayAddr, nil
+}
+
 // AllocateConn generates a new Conn to receive traffic on and the IP/Port to populate the allocation response with
 func (r *RelayAddressGeneratorStatic) AllocateConn(string, int) (net.Conn, net.Addr, error) {
 	return nil, nil, errTODO
 }
+
+// AllocateListener generates a new Listener to accept the peer connections of a TCP allocation on
+func (r *RelayAddressGeneratorStatic) AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error) {
+	if err := r.checkAddressFamily(network); err != nil {
+		return nil, nil, err
+	}
+
+	var listener net.Listener
+	var relayAddr *net.TCPAddr
+	var err error
+	if r.PortAllocator != nil {
+		listener, relayAddr, err = listenTCPOnPort(r.Net, r.PortAllocator, network, r.Address, requestedPort, r.MaxRetries)
+	} else {
+		listener, relayAddr, err = listenTCPRelay(r.Net, network, r.Address, requestedPort)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Replace actual listening IP with the user requested one of RelayAddressGeneratorStatic
+	relayAddr.IP = r.RelayAddress
+
+	return listener, relayAddr, nil
+}
+
+// DialPeer connects to a peer on behalf of a TCP allocation
+func (r *RelayAddressGeneratorStatic) DialPeer(network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+	return dialTCPPeer(r.Net, network, localAddr, peerAddr)
+}
+
+func (r *RelayAddressGeneratorStatic) checkAddressFamily(network string) error {
+	if r.RelayAddress.Equal(net.IPv6unspecified) {
+		return fmt.Errorf("%w: %s relay on %s", ErrAddressFamilyNotSupported, network, r.RelayAddress)
+	}
+
+	if err := checkAddressFamily(network, r.RelayAddress); err != nil {
+		return err
+	}
+	return checkAddressFamily(network, net.ParseIP(r.Address))
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/transport/v3"
+)
+
+// tcpConnectTimeout is how long DialPeer tries to connect to a peer, see RFC 6062 Section 5.2
+const tcpConnectTimeout = 30 * time.Second
+
+// listenTCPRelay creates the listener of a TCP allocation
+func listenTCPRelay(n transport.Net, network, address string, port int) (net.Listener, *net.TCPAddr, error) {
+	addr, err := n.ResolveTCPAddr(network, net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	listener, err := n.ListenTCP(network, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	relayAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok {
+		_ = listener.Close()
+		return nil, nil, errNilConn
+	}
+
+	return listener, &net.TCPAddr{IP: relayAddr.IP, Port: relayAddr.Port, Zone: relayAddr.Zone}, nil
+}
+
+// dialTCPPeer connects to peerAddr from the IP address of localAddr
+func dialTCPPeer(n transport.Net, network string, localAddr, peerAddr net.Addr) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: tcpConnectTimeout}
+	if tcpAddr, ok := localAddr.(*net.TCPAddr); ok {
+		dialer.LocalAddr = &net.TCPAddr{IP: tcpAddr.IP, Zone: tcpAddr.Zone}
+	}
+
+	return n.CreateDialer(dialer).Dial(network, peerAddr.String())
+}
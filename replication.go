@@ -0,0 +1,322 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/proto"
+)
+
+// ReplicationEventType is the change of the allocations of a server a ReplicationEvent
+// describes
+type ReplicationEventType byte
+
+const (
+	// ReplicationAllocationCreated is an allocation created with RelayAddr and Lifetime
+	ReplicationAllocationCreated ReplicationEventType = iota + 1
+	// ReplicationAllocationRefreshed is an allocation refreshed to Lifetime
+	ReplicationAllocationRefreshed
+	// ReplicationAllocationDeleted is an allocation deleted by its client or expired
+	ReplicationAllocationDeleted
+	// ReplicationPermissionCreated is a permission installed for Peer
+	ReplicationPermissionCreated
+	// ReplicationChannelBound is Channel bound to Peer
+	ReplicationChannelBound
+)
+
+// replicationEventVersion is the first byte of a marshaled ReplicationEvent
+const replicationEventVersion = 1
+
+// ReplicationEvent is a change of the UDP allocations of a server, streamed to a warm
+// standby server, see ServerConfig.OnReplicationEvent and Server.ApplyReplicationEvent.
+// The allocation is identified by the address of its client and the local address of the
+// listener the client reaches, which the standby must share, e.g. with VRRP or anycast
+type ReplicationEvent struct {
+	Type       ReplicationEventType
+	Username   string
+	ClientAddr *net.UDPAddr
+	ServerAddr *net.UDPAddr
+	RelayAddr  *net.UDPAddr
+	Lifetime   time.Duration
+	Peer       *net.UDPAddr
+	Channel    uint16
+}
+
+// MarshalBinary encodes the event to be sent to the standby server
+func (e ReplicationEvent) MarshalBinary() ([]byte, error) {
+	if len(e.Username) > 0xffff {
+		return nil, fmt.Errorf("%w: username too long", errReplicationEventInvalid)
+	}
+
+	b := []byte{replicationEventVersion, byte(e.Type), byte(e.Channel >> 8), byte(e.Channel)}
+	lifetime := uint32(e.Lifetime / time.Millisecond)
+	b = append(b, byte(lifetime>>24), byte(lifetime>>16), byte(lifetime>>8), byte(lifetime))
+	b = append(b, byte(len(e.Username)>>8), byte(len(e.Username)))
+	b = append(b, e.Username...)
+	for _, addr := range []*net.UDPAddr{e.ClientAddr, e.ServerAddr, e.RelayAddr, e.Peer} {
+		b = appendReplicationAddr(b, addr)
+	}
+
+	return b, nil
+}
+
+// UnmarshalBinary decodes an event encoded by MarshalBinary
+func (e *ReplicationEvent) UnmarshalBinary(b []byte) error {
+	if len(b) < 10 || b[0] != replicationEventVersion {
+		return errReplicationEventInvalid
+	}
+
+	event := ReplicationEvent{
+		Type:     ReplicationEventType(b[1]),
+		Channel:  binary.BigEndian.Uint16(b[2:4]),
+		Lifetime: time.Duration(binary.BigEndian.Uint32(b[4:8])) * time.Millisecond,
+	}
+	usernameLen := int(binary.BigEndian.Uint16(b[8:10]))
+	if len(b) < 10+usernameLen {
+		return errReplicationEventInvalid
+	}
+	event.Username = string(b[10 : 10+usernameLen])
+	b = b[10+usernameLen:]
+
+	for _, addr := range []**net.UDPAddr{&event.ClientAddr, &event.ServerAddr, &event.RelayAddr, &event.Peer} {
+		var err error
+		if *addr, b, err = readReplicationAddr(b); err != nil {
+			return err
+		}
+	}
+
+	*e = event
+	return nil
+}
+
+// appendReplicationAddr appends the length of the IP of addr, the IP and the port to b, a
+// nil addr as an empty IP
+func appendReplicationAddr(b []byte, addr *net.UDPAddr) []byte {
+	if addr == nil {
+		return append(b, 0)
+	}
+
+	ip := addr.IP.To4()
+	if ip == nil {
+		ip = addr.IP.To16()
+	}
+	b = append(append(b, byte(len(ip))), ip...)
+
+	return append(b, byte(addr.Port>>8), byte(addr.Port))
+}
+
+// readReplicationAddr reads an address appended by appendReplicationAddr from b, and
+// returns the rest of b
+func readReplicationAddr(b []byte) (*net.UDPAddr, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errReplicationEventInvalid
+	}
+
+	ipLen := int(b[0])
+	switch {
+	case ipLen == 0:
+		return nil, b[1:], nil
+	case ipLen != net.IPv4len && ipLen != net.IPv6len, len(b) < 1+ipLen+2:
+		return nil, nil, errReplicationEventInvalid
+	}
+
+	addr := &net.UDPAddr{
+		IP:   append(net.IP{}, b[1:1+ipLen]...),
+		Port: int(binary.BigEndian.Uint16(b[1+ipLen:])),
+	}
+
+	return addr, b[1+ipLen+2:], nil
+}
+
+// ApplyReplicationEvent applies an event streamed from the active server to this standby
+// server. Once the client of a replicated allocation reaches this server, e.g. after a
+// failover, the allocation is restored with its permissions and channels on a relay socket
+// bound to the same relayed address. Additional relays of dual-stack allocations aren't
+// restored
+func (s *Server) ApplyReplicationEvent(e ReplicationEvent) error {
+	if e.ClientAddr == nil || e.ServerAddr == nil {
+		return fmt.Errorf("%w: no 5-tuple", errReplicationEventInvalid)
+	}
+	fiveTuple := &allocation.FiveTuple{
+		Protocol: allocation.UDP,
+		SrcAddr:  e.ClientAddr,
+		DstAddr:  e.ServerAddr,
+	}
+
+	switch e.Type {
+	case ReplicationAllocationCreated:
+		if e.RelayAddr == nil {
+			return fmt.Errorf("%w: no relayed address", errReplicationEventInvalid)
+		}
+		s.standby.Created(allocation.Replica{
+			Username:  e.Username,
+			FiveTuple: *fiveTuple,
+			RelayAddr: e.RelayAddr,
+			Expires:   time.Now().Add(e.Lifetime),
+		})
+	case ReplicationAllocationRefreshed:
+		s.standby.Refreshed(fiveTuple, e.Lifetime)
+	case ReplicationAllocationDeleted:
+		s.standby.Deleted(fiveTuple)
+	case ReplicationPermissionCreated, ReplicationChannelBound:
+		if e.Peer == nil {
+			return fmt.Errorf("%w: no peer", errReplicationEventInvalid)
+		}
+		if e.Type == ReplicationPermissionCreated {
+			s.standby.PermissionCreated(fiveTuple, e.Peer)
+		} else if channel := proto.ChannelNumber(e.Channel); channel.Valid() {
+			s.standby.ChannelBound(fiveTuple, e.Peer, channel)
+		} else {
+			return fmt.Errorf("%w: channel %d", errReplicationEventInvalid, e.Channel)
+		}
+	default:
+		return fmt.Errorf("%w: type %d", errReplicationEventInvalid, e.Type)
+	}
+
+	return nil
+}
+
+// ReplicationSnapshot returns the events that replicate the current UDP allocations of the
+// server, to bring a standby server that connected after they were created up to date
+// before streaming further events to it
+func (s *Server) ReplicationSnapshot() []ReplicationEvent {
+	now := time.Now()
+	events := []ReplicationEvent{}
+	for _, am := range s.managers() {
+		for _, a := range am.Allocations() {
+			replica, ok := a.Replica()
+			if !ok {
+				continue
+			}
+			created, ok := replicationEvent(ReplicationAllocationCreated, a.Info())
+			if !ok {
+				continue
+			}
+			created.Lifetime = replica.Expires.Sub(now)
+			if created.Lifetime <= 0 {
+				continue
+			}
+			events = append(events, created)
+
+			for _, peer := range replica.Permissions {
+				if peer, ok := peer.(*net.UDPAddr); ok {
+					e := created
+					e.Type, e.Peer = ReplicationPermissionCreated, peer
+					events = append(events, e)
+				}
+			}
+			for channel, peer := range replica.Channels {
+				if peer, ok := peer.(*net.UDPAddr); ok {
+					e := created
+					e.Type, e.Peer, e.Channel = ReplicationChannelBound, peer, uint16(channel)
+					events = append(events, e)
+				}
+			}
+		}
+	}
+
+	return events
+}
+
+// replicationEvent returns the event of type for the allocation of info, false if the
+// allocation has no UDP client or relay and can't be restored by a standby
+func replicationEvent(eventType ReplicationEventType, info AllocationInfo) (ReplicationEvent, bool) {
+	clientAddr, clientOK := info.FiveTuple.SrcAddr.(*net.UDPAddr)
+	serverAddr, serverOK := info.FiveTuple.DstAddr.(*net.UDPAddr)
+	relayAddr, relayOK := info.RelayAddr.(*net.UDPAddr)
+	if !clientOK || !serverOK || !relayOK {
+		return ReplicationEvent{}, false
+	}
+
+	return ReplicationEvent{
+		Type:       eventType,
+		Username:   info.Username,
+		ClientAddr: clientAddr,
+		ServerAddr: serverAddr,
+		RelayAddr:  relayAddr,
+	}, true
+}
+
+// replicateAllocations wraps events to stream the changes of the UDP allocations to
+// onEvent. The deletions of a closing server aren't streamed, so the standby keeps the
+// allocations when the active server is shut down for a failover
+func (s *Server) replicateAllocations(events *allocation.Events, onEvent func(ReplicationEvent)) *allocation.Events {
+	replicated := &allocation.Events{}
+	if events != nil {
+		*replicated = *events
+	}
+
+	replicate := func(eventType ReplicationEventType, info AllocationInfo, update func(e *ReplicationEvent)) {
+		if e, ok := replicationEvent(eventType, info); ok {
+			update(&e)
+			onEvent(e)
+		}
+	}
+
+	onCreated, onRefreshed, onDeleted := replicated.OnAllocationCreated, replicated.OnAllocationRefreshed, replicated.OnAllocationDeleted
+	onPermissionCreated, onChannelBound := replicated.OnPermissionCreated, replicated.OnChannelBound
+	replicated.OnAllocationCreated = func(info AllocationInfo) {
+		replicate(ReplicationAllocationCreated, info, func(e *ReplicationEvent) {
+			e.Lifetime = s.allocationLifetime(&info.FiveTuple)
+		})
+		if onCreated != nil {
+			onCreated(info)
+		}
+	}
+	replicated.OnAllocationRefreshed = func(info AllocationInfo, lifetime time.Duration) {
+		replicate(ReplicationAllocationRefreshed, info, func(e *ReplicationEvent) {
+			e.Lifetime = lifetime
+		})
+		if onRefreshed != nil {
+			onRefreshed(info, lifetime)
+		}
+	}
+	replicated.OnAllocationDeleted = func(info AllocationInfo) {
+		if s.ctx.Err() == nil {
+			replicate(ReplicationAllocationDeleted, info, func(*ReplicationEvent) {})
+		}
+		if onDeleted != nil {
+			onDeleted(info)
+		}
+	}
+	replicated.OnPermissionCreated = func(info AllocationInfo, peer net.Addr) {
+		if peer, ok := peer.(*net.UDPAddr); ok {
+			replicate(ReplicationPermissionCreated, info, func(e *ReplicationEvent) {
+				e.Peer = peer
+			})
+		}
+		if onPermissionCreated != nil {
+			onPermissionCreated(info, peer)
+		}
+	}
+	replicated.OnChannelBound = func(info AllocationInfo, peer net.Addr, channel uint16) {
+		if peer, ok := peer.(*net.UDPAddr); ok {
+			replicate(ReplicationChannelBound, info, func(e *ReplicationEvent) {
+				e.Peer, e.Channel = peer, channel
+			})
+		}
+		if onChannelBound != nil {
+			onChannelBound(info, peer, channel)
+		}
+	}
+
+	return replicated
+}
+
+// allocationLifetime returns the time until the allocation of fiveTuple expires, the
+// default lifetime if it was deleted meanwhile
+func (s *Server) allocationLifetime(fiveTuple *allocation.FiveTuple) time.Duration {
+	for _, am := range s.managers() {
+		if a := am.GetAllocation(fiveTuple); a != nil {
+			return time.Until(a.Expires())
+		}
+	}
+
+	return proto.DefaultLifetime
+}
@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package turn
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicationEventMarshal(t *testing.T) {
+	for _, event := range []ReplicationEvent{
+		{
+			Type:       ReplicationAllocationCreated,
+			Username:   "foo",
+			ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 5000},
+			ServerAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2).To4(), Port: 3478},
+			RelayAddr:  &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2).To4(), Port: 50000},
+			Lifetime:   10 * time.Minute,
+		},
+		{
+			Type:       ReplicationChannelBound,
+			ClientAddr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000},
+			ServerAddr: &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 3478},
+			Peer:       &net.UDPAddr{IP: net.ParseIP("2001:db8::3"), Port: 6000},
+			Channel:    0x4000,
+		},
+	} {
+		b, err := event.MarshalBinary()
+		require.NoError(t, err)
+
+		var decoded ReplicationEvent
+		require.NoError(t, decoded.UnmarshalBinary(b))
+		assert.Equal(t, event, decoded)
+
+		assert.ErrorIs(t, decoded.UnmarshalBinary(b[:len(b)-1]), errReplicationEventInvalid)
+	}
+}
+
+func TestServerReplication(t *testing.T) {
+	newServer := func(addr string, onEvent func(ReplicationEvent)) (*Server, net.Addr) {
+		udpListener, err := net.ListenPacket("udp4", addr)
+		require.NoError(t, err)
+
+		server, err := NewServer(ServerConfig{
+			AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+				return GenerateAuthKey(username, realm, "pass"), true
+			},
+			PacketConnConfigs: []PacketConnConfig{
+				{
+					PacketConn: udpListener,
+					RelayAddressGenerator: &RelayAddressGeneratorStatic{
+						RelayAddress: net.ParseIP("127.0.0.1"),
+						Address:      "127.0.0.1",
+					},
+				},
+			},
+			Realm:              "pion.ly",
+			OnReplicationEvent: onEvent,
+		})
+		require.NoError(t, err)
+
+		return server, udpListener.LocalAddr()
+	}
+
+	// The stream to the standby is buffered until the active server failed, the standby
+	// takes over its address
+	var eventsLock sync.Mutex
+	var stream [][]byte
+	active, serverAddr := newServer("127.0.0.1:0", func(e ReplicationEvent) {
+		b, err := e.MarshalBinary()
+		assert.NoError(t, err)
+
+		eventsLock.Lock()
+		stream = append(stream, b)
+		eventsLock.Unlock()
+	})
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		TURNServerAddr: serverAddr.String(),
+		RTO:            10 * time.Millisecond,
+		Username:       "foo",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Data is relayed both ways through the relayed address
+	relay := func(t *testing.T, data string) {
+		t.Helper()
+
+		_, err := relayConn.WriteTo([]byte(data), peer.LocalAddr())
+		require.NoError(t, err)
+
+		buf := make([]byte, 64)
+		require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, from, err := peer.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, data, string(buf[:n]))
+		assert.Equal(t, relayConn.LocalAddr().String(), from.String())
+
+		_, err = peer.WriteTo(buf[:n], from)
+		require.NoError(t, err)
+		require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, from, err = relayConn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, data, string(buf[:n]))
+		assert.Equal(t, peer.LocalAddr().String(), from.String())
+	}
+	relay(t, "Hello")
+
+	snapshot := active.ReplicationSnapshot()
+	require.NotEmpty(t, snapshot)
+	assert.Equal(t, ReplicationAllocationCreated, snapshot[0].Type)
+	assert.Equal(t, "foo", snapshot[0].Username)
+	assert.Equal(t, relayConn.LocalAddr().String(), snapshot[0].RelayAddr.String())
+
+	// Closing the active server doesn't delete the replicated allocations
+	require.NoError(t, active.Close())
+
+	standby, _ := newServer(serverAddr.String(), nil)
+	eventsLock.Lock()
+	for _, b := range stream {
+		var e ReplicationEvent
+		require.NoError(t, e.UnmarshalBinary(b))
+		assert.NotEqual(t, ReplicationAllocationDeleted, e.Type)
+		require.NoError(t, standby.ApplyReplicationEvent(e))
+	}
+	eventsLock.Unlock()
+	assert.Equal(t, 1, standby.standby.Len())
+	assert.Empty(t, standby.Allocations())
+
+	// The allocation is restored on the same relayed address once the client sends
+	relay(t, "World")
+	assert.Len(t, standby.Allocations(), 1)
+	assert.Equal(t, 0, standby.standby.Len())
+
+	assert.ErrorIs(t, standby.ApplyReplicationEvent(ReplicationEvent{Type: ReplicationAllocationCreated}), errReplicationEventInvalid)
+
+	require.NoError(t, relayConn.Close())
+	client.Close()
+	require.NoError(t, conn.Close())
+	require.NoError(t, peer.Close())
+	require.NoError(t, standby.Close())
+}
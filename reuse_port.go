@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"strconv"
+)
+
+// ReusePortPacketConnConfigs opens shards UDP sockets on address sharing the port with
+// SO_REUSEPORT, one per CPU if shards is 0, and returns a copy of config for each of them.
+// The kernel spreads the clients over the sockets by their address, so every client keeps
+// talking to the same shard. Each PacketConnConfig gets its own read loop and allocation
+// manager, so the shards don't contend for a socket or a lock, combine it with
+// LockOSThread or CPUs. The shards are named after config.Name, or their address, and
+// their index, e.g. "0.0.0.0:3478#2". Only supported on Linux.
+func ReusePortPacketConnConfigs(network, address string, shards int, config PacketConnConfig) ([]PacketConnConfig, error) {
+	if !reusePortSupported {
+		return nil, errReusePortUnsupported
+	}
+
+	if shards == 0 {
+		shards = runtime.NumCPU()
+	} else if shards < 0 {
+		return nil, fmt.Errorf("%w: %d", errShardsInvalid, shards)
+	}
+
+	listenConfig := &net.ListenConfig{Control: reusePortControl}
+
+	configs := make([]PacketConnConfig, 0, shards)
+	closeAll := func() {
+		for _, cfg := range configs {
+			_ = cfg.PacketConn.Close()
+		}
+	}
+
+	for i := 0; i < shards; i++ {
+		conn, err := listenConfig.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		// The following shards bind to the port the kernel picked for the first one
+		if i == 0 {
+			udpAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+			if !ok {
+				_ = conn.Close()
+				return nil, errNilConn
+			}
+			address = net.JoinHostPort(udpAddr.IP.String(), strconv.Itoa(udpAddr.Port))
+		}
+
+		shard := config
+		shard.PacketConn = conn
+		shard.Name = listenerName(config.Name, conn.LocalAddr()) + "#" + strconv.Itoa(i)
+		configs = append(configs, shard)
+	}
+
+	return configs, nil
+}
@@ -0,0 +1,18 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package turn
+
+import (
+	"syscall"
+)
+
+const reusePortSupported = false
+
+// reusePortControl is Linux only, where SO_REUSEPORT balances datagrams over the sockets
+func reusePortControl(string, string, syscall.RawConn) error {
+	return errReusePortUnsupported
+}
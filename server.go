@@ -5,33 +5,104 @@
 package turn
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pion/logging"
+	"github.com/pion/randutil"
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/counter"
 	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/ipnet"
 	"github.com/pion/turn/v3/internal/proto"
 	"github.com/pion/turn/v3/internal/server"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/pion/turn/v3/offload"
+	"github.com/pion/turn/v3/policy"
+	"github.com/pion/turn/v3/tracing"
 )
 
 const (
 	defaultInboundMTU = 1600
+
+	defaultRefreshWatchdogWindow       = time.Minute
+	defaultRefreshWatchdogMaxRefreshes = 10
+	defaultStaleNonceWatchdogWindow    = time.Minute
+	defaultStaleNonceWatchdogMax       = 100
+	defaultRateLimiterRate             = 1
+	defaultRateLimiterBurst            = 10
+	defaultSendBufferRetryAttempts     = 3
+	defaultSendBufferRetryInterval     = time.Millisecond
+
+	// userQuotaTTL is how long an allocation is counted for the quota of its username in
+	// Counters after it was created or last refreshed. Allocations are refreshed at least
+	// hourly, so only the allocations of servers that crashed expire
+	userQuotaTTL = 2 * time.Hour
+
+	nodeIDLength = 16
+	nodeIDRunes  = "0123456789abcdef"
 )
 
 // Server is an instance of the Pion TURN Server
 type Server struct {
+	readers int64 // Accessed atomically, first for 64-bit alignment
+
 	log                logging.LeveledLogger
-	authHandler        AuthHandler
-	realm              string
+	auth               atomic.Value // *AuthConfig
 	channelBindTimeout time.Duration
-	nonceHash          *server.NonceHash
-
-	packetConnConfigs  []PacketConnConfig
-	listenerConfigs    []ListenerConfig
+	permissionTimeout  time.Duration
+	allowedPeerPorts   []PeerPortRange
+	nonces             NonceGenerator
+	challengeCache     *server.ChallengeCache
+	credentialCache    *server.CredentialCache
+	maintenance        *server.Maintenance
+	rebalance          *server.Rebalance
+
+	listenersLock      sync.RWMutex
 	allocationManagers []*allocation.Manager
+	listeners          map[*allocation.Manager]*listenerState
 	inboundMTU         int
+
+	permissionMode               PermissionMode
+	permissionCoalesceWindow     time.Duration
+	expiredAllocationPolicy      ExpiredAllocationPolicy
+	expiredAllocationGracePeriod time.Duration
+	refreshWatchdog              *server.RefreshWatchdog
+	staleNonceWatchdog           *server.StaleNonceWatchdog
+	rateLimiter                  *server.RateLimiter
+	bandwidthLimits              *server.BandwidthLimits
+	metrics                      *metrics.Metrics
+	tracer                       tracing.Tracer
+	scheduler                    *allocation.Scheduler
+	sendBufferRetry              allocation.SendBufferRetry
+	bufferPool                   *allocation.BufferPool
+	channelOffload               offload.Offloader
+	dscp                         int
+	maxRelayedPacketSize         int
+	events                       *allocation.Events
+	standby                      *allocation.Standby
+	mobility                     bool
+	credentialExpiryPolicy       *CredentialExpiryPolicy
+	accessTokenHandler           AccessTokenHandler
+	thirdPartyAuthorization      string
+	alternateServerHandler       AlternateServerHandler
+	passthroughHandler           PassthroughHandler
+	echoPeer                     *net.UDPAddr
+	userQuota                    int
+	quotaHandler                 QuotaHandler
+	counters                     counter.Store
+	nodeID                       string
+	policy                       policy.Evaluator
+	affinityToken                []byte
+	relayIdentity                RelayIdentity
+	realms                       []RealmConfig
+	ctx                          context.Context
+	cancel                       context.CancelFunc
 }
 
 // NewServer creates the Pion TURN server
@@ -52,54 +123,189 @@ func NewServer(config ServerConfig) (*Server, error) {
 		mtu = config.InboundMTU
 	}
 
-	nonceHash, err := server.NewNonceHash()
-	if err != nil {
-		return nil, err
+	var staleNonceWatchdog *server.StaleNonceWatchdog
+	if config.StaleNonceWatchdog != nil {
+		watchdogConfig := *config.StaleNonceWatchdog
+		if watchdogConfig.Window == 0 {
+			watchdogConfig.Window = defaultStaleNonceWatchdogWindow
+		}
+		if watchdogConfig.MaxStaleNonces == 0 {
+			watchdogConfig.MaxStaleNonces = defaultStaleNonceWatchdogMax
+		}
+		staleNonceWatchdog = server.NewStaleNonceWatchdog(watchdogConfig)
+	}
+
+	nonces := config.NonceGenerator
+	if nonces == nil {
+		nonceHash, err := server.NewNonceHash(config.NonceLifetime)
+		if err != nil {
+			return nil, err
+		}
+		nonceHash.OnRotate = func(rotation server.NonceRotation) {
+			config.Metrics.NonceRotated()
+			staleNonceWatchdog.Rotated(rotation)
+			if config.OnNonceRotated != nil {
+				config.OnNonceRotated(rotation)
+			}
+		}
+		nonces = nonceHash
 	}
 
 	s := &Server{
 		log:                loggerFactory.NewLogger("turn"),
-		authHandler:        config.AuthHandler,
-		realm:              config.Realm,
 		channelBindTimeout: config.ChannelBindTimeout,
-		packetConnConfigs:  config.PacketConnConfigs,
-		listenerConfigs:    config.ListenerConfigs,
-		nonceHash:          nonceHash,
+		standby:            allocation.NewStandby(),
+		permissionTimeout:  config.PermissionTimeout,
+		allowedPeerPorts:   append([]PeerPortRange{}, config.AllowedPeerPorts...),
+		nonces:             nonces,
+		listeners:          map[*allocation.Manager]*listenerState{},
+		maintenance:        server.NewMaintenance(),
+		rebalance:          server.NewRebalance(),
 		inboundMTU:         mtu,
+
+		permissionMode:               config.PermissionMode,
+		permissionCoalesceWindow:     config.PermissionCoalesceWindow,
+		expiredAllocationPolicy:      config.ExpiredAllocationPolicy,
+		expiredAllocationGracePeriod: config.ExpiredAllocationGracePeriod,
+		mobility:                     config.Mobility,
+		credentialExpiryPolicy:       config.CredentialExpiryPolicy,
+		accessTokenHandler:           config.AccessTokenHandler,
+		thirdPartyAuthorization:      config.ThirdPartyAuthorization,
+		alternateServerHandler:       config.AlternateServerHandler,
+		passthroughHandler:           config.PassthroughHandler,
+		echoPeer:                     config.EchoPeer,
+		userQuota:                    config.UserQuota,
+		quotaHandler:                 config.QuotaHandler,
+		policy:                       config.Policy,
+		affinityToken:                config.AffinityToken,
+		relayIdentity:                config.RelayIdentity,
+		channelOffload:               config.ChannelOffload,
+		dscp:                         config.DSCP,
+		maxRelayedPacketSize:         config.MaxRelayedPacketSize,
+		realms:                       append([]RealmConfig{}, config.Realms...),
+		staleNonceWatchdog:           staleNonceWatchdog,
+		metrics:                      config.Metrics,
+		tracer:                       config.Tracer,
 	}
 
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.UpdateAuthConfig(AuthConfig{Realm: config.Realm, AuthHandler: config.AuthHandler, AuthHandlerV2: config.AuthHandlerV2})
+
 	if s.channelBindTimeout == 0 {
 		s.channelBindTimeout = proto.DefaultLifetime
 	}
 
-	for _, cfg := range s.packetConnConfigs {
-		am, err := s.createAllocationManager(cfg.RelayAddressGenerator, cfg.PermissionHandler)
+	if config.CacheCredentials {
+		var expiry func(username string) (time.Time, bool)
+		if config.CredentialExpiryPolicy != nil {
+			expiry = config.CredentialExpiryPolicy.Expiry
+		}
+		s.credentialCache = server.NewCredentialCache(expiry)
+	}
+
+	if config.ChallengeCacheTTL != 0 {
+		s.challengeCache = server.NewChallengeCache(nonces, config.ChallengeCacheTTL)
+	}
+
+	if config.RefreshWatchdog != nil {
+		watchdogConfig := *config.RefreshWatchdog
+		if watchdogConfig.Window == 0 {
+			watchdogConfig.Window = defaultRefreshWatchdogWindow
+		}
+		if watchdogConfig.MaxRefreshes == 0 && watchdogConfig.MaxRefreshesPerUsername == 0 {
+			watchdogConfig.MaxRefreshes = defaultRefreshWatchdogMaxRefreshes
+		}
+		s.refreshWatchdog = server.NewRefreshWatchdog(watchdogConfig)
+	}
+
+	if config.Counters != nil {
+		s.counters = server.NewCircuitBreaker(config.Counters)
+		nodeID, err := randutil.GenerateCryptoRandomString(nodeIDLength, nodeIDRunes)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create AllocationManager: %w", err)
+			return nil, err
 		}
+		s.nodeID = nodeID
+	}
 
-		go func(cfg PacketConnConfig, am *allocation.Manager) {
-			s.readLoop(cfg.PacketConn, am)
+	if config.RateLimiter != nil {
+		rateLimiterConfig := *config.RateLimiter
+		if rateLimiterConfig.Rate == 0 {
+			rateLimiterConfig.Rate = defaultRateLimiterRate
+		}
+		if rateLimiterConfig.Burst == 0 {
+			rateLimiterConfig.Burst = defaultRateLimiterBurst
+		}
+		s.rateLimiter = server.NewRateLimiter(rateLimiterConfig, s.counters)
+	}
 
-			if err := am.Close(); err != nil {
-				s.log.Errorf("Failed to close AllocationManager: %s", err)
-			}
-		}(cfg, am)
+	if config.BandwidthLimit != nil {
+		s.bandwidthLimits = server.NewBandwidthLimits(*config.BandwidthLimit)
 	}
 
-	for _, cfg := range s.listenerConfigs {
-		am, err := s.createAllocationManager(cfg.RelayAddressGenerator, cfg.PermissionHandler)
+	if config.OnAllocationCreated != nil || config.OnAllocationRefreshed != nil || config.OnAllocationDeleted != nil ||
+		config.OnPermissionCreated != nil || config.OnChannelBound != nil {
+		s.events = &allocation.Events{
+			OnAllocationCreated:   config.OnAllocationCreated,
+			OnAllocationRefreshed: config.OnAllocationRefreshed,
+			OnAllocationDeleted:   config.OnAllocationDeleted,
+			OnPermissionCreated:   config.OnPermissionCreated,
+			OnChannelBound:        config.OnChannelBound,
+		}
+	}
+
+	if s.counters != nil && s.userQuota > 0 {
+		s.events = s.countAllocations(s.events)
+	}
+
+	if config.OnReplicationEvent != nil {
+		s.events = s.replicateAllocations(s.events, config.OnReplicationEvent)
+	}
+
+	s.bufferPool = allocation.NewBufferPool(config.RelayBufferSize)
+	if config.FairScheduler != nil {
+		s.scheduler = allocation.NewScheduler(*config.FairScheduler, s.bufferPool, s.log)
+	}
+
+	if config.SendBufferRetry != nil {
+		s.sendBufferRetry = *config.SendBufferRetry
+		if s.sendBufferRetry.Attempts == 0 {
+			s.sendBufferRetry.Attempts = defaultSendBufferRetryAttempts
+		}
+		if s.sendBufferRetry.Interval == 0 {
+			s.sendBufferRetry.Interval = defaultSendBufferRetryInterval
+		}
+	}
+
+	// The read loops are started once all listeners are added, so none is left running if
+	// one fails
+	readLoops := []func(){}
+
+	for _, cfg := range config.PacketConnConfigs {
+		readLoop, err := s.addPacketConn(cfg)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create AllocationManager: %w", err)
+			return nil, err
 		}
+		readLoops = append(readLoops, readLoop)
+	}
 
-		go func(cfg ListenerConfig, am *allocation.Manager) {
-			s.readListener(cfg.Listener, am)
+	for _, cfg := range config.ListenerConfigs {
+		readLoop, err := s.addListener(cfg)
+		if err != nil {
+			return nil, err
+		}
+		readLoops = append(readLoops, readLoop)
+	}
 
-			if err := am.Close(); err != nil {
-				s.log.Errorf("Failed to close AllocationManager: %s", err)
-			}
-		}(cfg, am)
+	for _, cfg := range config.QUICListenerConfigs {
+		readLoop, err := s.addQUICListener(cfg)
+		if err != nil {
+			return nil, err
+		}
+		readLoops = append(readLoops, readLoop)
+	}
+
+	for _, readLoop := range readLoops {
+		s.goReader(readLoop)
 	}
 
 	return s, nil
@@ -108,41 +314,341 @@ func NewServer(config ServerConfig) (*Server, error) {
 // AllocationCount returns the number of active allocations. It can be used to drain the server before closing
 func (s *Server) AllocationCount() int {
 	allocs := 0
-	for _, am := range s.allocationManagers {
+	for _, am := range s.managers() {
 		allocs += am.AllocationCount()
 	}
 	return allocs
 }
 
-// Close stops the TURN Server. It cleans up any associated state and closes all connections it is managing
-func (s *Server) Close() error {
-	var errors []error
+// ExpiredAllocationPackets returns the number of peer packets that arrived on the relay port of
+// an expired allocation. Packets are only counted with ExpiredAllocationCount
+func (s *Server) ExpiredAllocationPackets() uint64 {
+	var packets uint64
+	for _, am := range s.managers() {
+		packets += am.ExpiredPackets()
+	}
+	return packets
+}
 
-	for _, cfg := range s.packetConnConfigs {
-		if err := cfg.PacketConn.Close(); err != nil {
-			errors = append(errors, err)
+// AllocationStats returns the number of active allocations per listener, client transport,
+// relay protocol and relay address family, e.g. to tell cheap UDP relays from costly TLS ones
+func (s *Server) AllocationStats() []AllocationStats {
+	stats := []AllocationStats{}
+	index := map[AllocationStats]int{}
+	for _, am := range s.managers() {
+		for _, a := range am.Allocations() {
+			key := AllocationStats{
+				Listener:        a.Listener,
+				ClientTransport: a.ClientTransport,
+				RelayProtocol:   a.Protocol,
+				AddressFamily:   a.AddressFamily,
+			}
+
+			i, ok := index[key]
+			if !ok {
+				i = len(stats)
+				index[key] = i
+				stats = append(stats, key)
+			}
+			stats[i].Allocations++
 		}
 	}
 
-	for _, cfg := range s.listenerConfigs {
-		if err := cfg.Listener.Close(); err != nil {
-			errors = append(errors, err)
+	return stats
+}
+
+// Allocations returns a snapshot of every active allocation, e.g. for an admin service to
+// find abusive sessions
+func (s *Server) Allocations() []AllocationSnapshot {
+	now := time.Now()
+	snapshots := []AllocationSnapshot{}
+	for _, am := range s.managers() {
+		for _, a := range am.Allocations() {
+			info := a.Info()
+			traffic := a.Traffic()
+			snapshot := AllocationSnapshot{
+				AllocationInfo:  info,
+				Key:             info.FiveTuple.Fingerprint(),
+				Lifetime:        a.Expires().Sub(now),
+				BytesToPeer:     traffic.Bytes[metrics.DirectionToPeer],
+				BytesToClient:   traffic.Bytes[metrics.DirectionToClient],
+				PacketsToPeer:   traffic.Packets[metrics.DirectionToPeer],
+				PacketsToClient: traffic.Packets[metrics.DirectionToClient],
+
+				SendBufferDropsToPeer:   traffic.SendBufferDrops[metrics.DirectionToPeer],
+				SendBufferDropsToClient: traffic.SendBufferDrops[metrics.DirectionToClient],
+				OversizedDropsToPeer:    traffic.OversizedDrops[metrics.DirectionToPeer],
+				OversizedDropsToClient:  traffic.OversizedDrops[metrics.DirectionToClient],
+			}
+			if snapshot.Lifetime < 0 {
+				snapshot.Lifetime = 0
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	return snapshots
+}
+
+// DeleteAllocation terminates the allocation with the key of its AllocationSnapshot. The
+// relay is closed immediately and further requests of the client are answered as if the
+// allocation expired. It returns ErrAllocationNotFound if the allocation doesn't exist
+func (s *Server) DeleteAllocation(key string) error {
+	for _, am := range s.managers() {
+		for _, a := range am.Allocations() {
+			if fiveTuple := a.Info().FiveTuple; fiveTuple.Fingerprint() == key {
+				am.DeleteAllocation(&fiveTuple)
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("%w: %s", ErrAllocationNotFound, key)
+}
+
+// UpdateAuthConfig replaces the realm and AuthHandler, e.g. to reload the users of the
+// AuthHandler. Requests received afterwards are authenticated with them, allocations stay
+// active. Clients keep sending the realm they authenticated with, which is passed to the
+// AuthHandler, until they get a 401 (Unauthorized) or 438 (Stale Nonce) error with the
+// new realm. Keys cached with CacheCredentials are dropped
+func (s *Server) UpdateAuthConfig(config AuthConfig) {
+	s.auth.Store(&config)
+	s.credentialCache.Invalidate()
+}
+
+func (s *Server) authConfig() *AuthConfig {
+	return s.auth.Load().(*AuthConfig) //nolint:forcetypeassert
+}
+
+// AnomalyStats returns the number of received datagrams per listener and kind of protocol
+// anomaly, e.g. to detect broken middleboxes or scanners. Kinds that weren't seen on a
+// listener are left out
+func (s *Server) AnomalyStats() []AnomalyStats {
+	stats := []AnomalyStats{}
+	for _, am := range s.managers() {
+		l := s.listenerState(am)
+		if l == nil {
+			continue
+		}
+
+		counts := l.anomalies.Counts()
+		for _, anomaly := range []Anomaly{AnomalyMissingFingerprint, AnomalyBadMagicCookie, AnomalyReservedBits, AnomalyAttributeOrder} {
+			if count, ok := counts[anomaly]; ok {
+				stats = append(stats, AnomalyStats{Listener: l.name, Anomaly: anomaly, Count: count})
+			}
+		}
+	}
+
+	return stats
+}
+
+// SendBufferStats returns the number of datagrams dropped per listener because the send
+// buffer of its socket was full, e.g. to tell which listeners need larger buffers. Datagrams
+// dropped on relay sockets are counted per allocation, see Allocations
+func (s *Server) SendBufferStats() []SendBufferStats {
+	stats := []SendBufferStats{}
+	for _, am := range s.managers() {
+		if l := s.listenerState(am); l != nil {
+			stats = append(stats, SendBufferStats{Listener: l.name, Drops: atomic.LoadUint64(&l.sendBufferDrops)})
+		}
+	}
+
+	return stats
+}
+
+// PermissionPortMismatchPackets returns the number of inbound peer packets and TCP connections
+// from a permitted IP address but a port no permission was requested for. They are relayed with
+// PermissionModeIP and dropped with PermissionModeIPPort
+func (s *Server) PermissionPortMismatchPackets() uint64 {
+	var packets uint64
+	for _, am := range s.managers() {
+		packets += am.PortMismatchPackets()
+	}
+	return packets
+}
+
+// RequireReauthentication forces the existing allocations of username to re-authenticate.
+// Their next Refresh, CreatePermission or ChannelBind request must be signed with a key
+// other than the one they were created or last refreshed with, as returned by the
+// AuthHandler once the credential was changed, or it is rejected with a 441 (Wrong
+// Credentials) error and the allocation is deleted. Until then their Send indications and
+// ChannelData are dropped. Revoking a credential and calling RequireReauthentication
+// terminates its allocations by their next request. It returns the number of allocations
+// affected
+func (s *Server) RequireReauthentication(username string) int {
+	count := 0
+	for _, am := range s.managers() {
+		count += am.RequireReauthentication(username)
+	}
+
+	return count
+}
+
+// ScheduleMaintenance announces a maintenance window starting at start to connected clients.
+// Until CancelMaintenance is called, lifetimes granted on Allocate and Refresh are shortened so
+// that allocations expire by start, and once the window has started Refresh requests delete the
+// allocation. If alternateServer is not nil, new Allocate requests and the next Refresh of every
+// existing allocation are answered with 300 (Try Alternate) carrying it as ALTERNATE-SERVER, so
+// clients can migrate ahead of the shutdown.
+func (s *Server) ScheduleMaintenance(start time.Time, alternateServer net.Addr) error {
+	var alternate *stun.AlternateServer
+	if alternateServer != nil {
+		ip, port, err := ipnet.AddrIPPort(alternateServer)
+		if err != nil {
+			return err
 		}
+		alternate = &stun.AlternateServer{IP: ip, Port: port}
 	}
 
-	if len(errors) == 0 {
-		return nil
+	s.maintenance.Schedule(start, alternate)
+	return nil
+}
+
+// CancelMaintenance cancels the maintenance window announced by ScheduleMaintenance
+func (s *Server) CancelMaintenance() {
+	s.maintenance.Cancel()
+}
+
+// RebalanceAllocations moves the allocations with the keys of their AllocationSnapshot to
+// alternateServer, e.g. for an orchestrator to shift load gradually across a fleet a few
+// allocations at a time. The next Refresh of every allocation is answered with a 300 (Try
+// Alternate) carrying alternateServer as ALTERNATE-SERVER, and the allocation expires by
+// deadline: its current lifetime and those granted to later Refresh requests are shortened.
+// Clients refresh well before their allocation expires, usually at half its lifetime, so
+// a deadline past the next refresh of the clients lets them migrate before it expires. If
+// alternateServer is nil the allocations are only shortened. Keys of allocations that don't
+// exist are skipped, the number of allocations selected is returned
+func (s *Server) RebalanceAllocations(keys []string, alternateServer net.Addr, deadline time.Time) (int, error) {
+	var alternate *stun.AlternateServer
+	if alternateServer != nil {
+		ip, port, err := ipnet.AddrIPPort(alternateServer)
+		if err != nil {
+			return 0, err
+		}
+		alternate = &stun.AlternateServer{IP: ip, Port: port}
+	}
+
+	selected := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		selected[key] = struct{}{}
+	}
+
+	count := 0
+	alive := map[string]struct{}{}
+	for _, am := range s.managers() {
+		for _, a := range am.Allocations() {
+			fiveTuple := a.Info().FiveTuple
+			key := fiveTuple.Fingerprint()
+			alive[key] = struct{}{}
+			if _, ok := selected[key]; !ok {
+				continue
+			}
+
+			count++
+			s.rebalance.Add(&fiveTuple, alternate, deadline)
+			if remaining := time.Until(deadline); remaining <= 0 {
+				am.DeleteAllocation(&fiveTuple)
+				delete(alive, key)
+			} else if a.Expires().After(deadline) {
+				a.Refresh(remaining)
+				s.events.AllocationRefreshed(a, remaining)
+			}
+		}
 	}
 
-	err := errFailedToClose
-	for _, e := range errors {
-		err = fmt.Errorf("%s; close error (%w) ", err, e) //nolint:errorlint
+	// Forget the allocations selected earlier that expired in the meantime
+	s.rebalance.Retain(alive)
+
+	return count, nil
+}
+
+// CancelRebalance stops moving the allocations with the keys of their AllocationSnapshot
+// selected by RebalanceAllocations. Their next Refresh is granted the usual lifetime. It
+// returns the number of allocations that were selected
+func (s *Server) CancelRebalance(keys ...string) int {
+	count := 0
+	for _, key := range keys {
+		if s.rebalance.Remove(key) {
+			count++
+		}
 	}
 
-	return err
+	return count
 }
 
-func (s *Server) readListener(l net.Listener, am *allocation.Manager) {
+// Close stops the TURN Server. It closes the listeners first, then deletes the allocations of all
+// listeners, emitting their deletion events, and then closes their relay sockets. It waits up to 5
+// seconds for its goroutines to return, see CloseAndVerify to check they did. The errors of all
+// sockets are returned joined
+func (s *Server) Close() error {
+	errs := []error{}
+
+	// No listeners are added once the context is canceled
+	s.cancel()
+	managers := s.managers()
+
+	// Listeners first, so no requests are handled while the allocations are deleted
+	for _, am := range managers {
+		if l := s.listenerState(am); l != nil {
+			errs = append(errs, l.socket.Close())
+		}
+	}
+
+	s.waitReaders(closeTimeout)
+
+	// Then the allocations of all listeners, emitting their deletion events, and only then
+	// their relay sockets
+	for _, am := range managers {
+		am.DeleteAllocations()
+	}
+	for _, am := range managers {
+		errs = append(errs, am.CloseRelaySockets())
+	}
+
+	if leaks := s.waitReleased(closeTimeout); leaks != nil {
+		s.log.Warnf("Resources still in use %v after closing: %v", closeTimeout, leaks)
+	}
+
+	if s.scheduler != nil {
+		s.scheduler.Close()
+	}
+
+	if err := allocation.JoinErrors(errs...); err != nil {
+		return allocation.JoinErrors(errFailedToClose, err)
+	}
+	return nil
+}
+
+// closeAllocationManager closes am once the reader of its listener returned on its own,
+// e.g. because its socket was closed elsewhere. Close closes the managers of a closing server
+// in order
+func (s *Server) closeAllocationManager(am *allocation.Manager) {
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	if err := am.Close(); err != nil {
+		s.log.Errorf("Failed to close AllocationManager: %s", err)
+	}
+}
+
+func (s *Server) readListener(l net.Listener, am *allocation.Manager, datagram bool) {
+	var wg sync.WaitGroup
+	conns := map[net.Conn]struct{}{}
+	connsLock := sync.Mutex{}
+
+	defer func() {
+		// Closing the listener leaves the accepted connections open
+		connsLock.Lock()
+		for conn := range conns {
+			if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.log.Errorf("Failed to close conn: %s", err)
+			}
+		}
+		connsLock.Unlock()
+		wg.Wait()
+	}()
+
 	for {
 		conn, err := l.Accept()
 		if err != nil {
@@ -150,8 +656,30 @@ func (s *Server) readListener(l net.Listener, am *allocation.Manager) {
 			return
 		}
 
-		go func() {
-			s.readLoop(NewSTUNConn(conn), am)
+		connsLock.Lock()
+		conns[conn] = struct{}{}
+		connsLock.Unlock()
+
+		wg.Add(1)
+		s.goReader(func() {
+			defer wg.Done()
+			defer func() {
+				connsLock.Lock()
+				delete(conns, conn)
+				connsLock.Unlock()
+			}()
+
+			if datagram {
+				s.readLoop(NewDatagramConn(conn), am)
+			} else {
+				stunConn := NewSTUNConn(conn)
+				s.readLoop(stunConn, am)
+
+				// The connection was bound to a peer connection and is owned by the allocation now
+				if stunConn.detached {
+					return
+				}
+			}
 
 			// Delete allocation
 			am.DeleteAllocation(&allocation.FiveTuple{
@@ -163,31 +691,258 @@ func (s *Server) readListener(l net.Listener, am *allocation.Manager) {
 			if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
 				s.log.Errorf("Failed to close conn: %s", err)
 			}
-		}()
+		})
+	}
+}
+
+func (s *Server) readQUICListener(l QUICListener, am *allocation.Manager) {
+	var wg sync.WaitGroup
+	conns := map[QUICConnection]struct{}{}
+	connsLock := sync.Mutex{}
+
+	defer func() {
+		// Closing the listener leaves the accepted connections open
+		connsLock.Lock()
+		for conn := range conns {
+			if err := conn.Close(); err != nil {
+				s.log.Errorf("Failed to close QUIC connection: %s", err)
+			}
+		}
+		connsLock.Unlock()
+		wg.Wait()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.log.Debugf("Failed to accept: %s", err)
+			return
+		}
+
+		connsLock.Lock()
+		conns[conn] = struct{}{}
+		connsLock.Unlock()
+
+		wg.Add(1)
+		s.goReader(func() {
+			defer wg.Done()
+			s.readQUICConnection(conn, am)
+
+			connsLock.Lock()
+			delete(conns, conn)
+			connsLock.Unlock()
+		})
+	}
+}
+
+// readQUICConnection serves every stream of conn as its own allocation
+func (s *Server) readQUICConnection(conn QUICConnection, am *allocation.Manager) {
+	mux := newQUICMux(conn)
+	s.goReader(mux.readDatagrams)
+
+	for {
+		stream, err := conn.AcceptStream()
+		if err != nil {
+			s.log.Debugf("Failed to accept QUIC stream: %s", err)
+			break
+		}
+
+		s.goReader(func() {
+			streamConn := mux.newStreamConn(stream, &quicStreamAddr{addr: conn.LocalAddr(), streamID: stream.StreamID()})
+			s.readLoop(streamConn, am)
+
+			am.DeleteAllocation(&allocation.FiveTuple{
+				Protocol: allocation.UDP, // fixed UDP
+				SrcAddr:  conn.RemoteAddr(),
+				DstAddr:  streamConn.LocalAddr(),
+			})
+
+			if err := streamConn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.log.Debugf("Failed to close QUIC stream: %s", err)
+			}
+		})
+	}
+
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		s.log.Debugf("Failed to close QUIC connection: %s", err)
+	}
+}
+
+// setListenerDSCP marks the packets sent on a listener socket with ServerConfig.DSCP
+func (s *Server) setListenerDSCP(conn interface{}, addr net.Addr) {
+	if s.dscp == 0 {
+		return
+	}
+
+	if err := ipnet.SetDSCP(conn, s.dscp); err != nil {
+		s.log.Warnf("Failed to set DSCP %d on listener %s: %v", s.dscp, addr, err)
 	}
 }
 
-func (s *Server) createAllocationManager(addrGenerator RelayAddressGenerator, handler PermissionHandler) (*allocation.Manager, error) {
+func (s *Server) createAllocationManager(addrGenerator RelayAddressGenerator, handler PermissionHandler, tcpAllocations bool, listenerAddr net.Addr, name string, transport allocation.Transport) (*allocation.Manager, error) {
 	if handler == nil {
 		handler = DefaultPermissionHandler
 	}
 
-	am, err := allocation.NewManager(allocation.ManagerConfig{
+	if listenerGenerator, ok := addrGenerator.(ListenerRelayAddressGenerator); ok {
+		var err error
+		if addrGenerator, err = listenerGenerator.ForListener(listenerAddr); err != nil {
+			return nil, err
+		}
+		if err = addrGenerator.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	config := allocation.ManagerConfig{
 		AllocatePacketConn: addrGenerator.AllocatePacketConn,
 		AllocateConn:       addrGenerator.AllocateConn,
 		PermissionHandler:  handler,
+		PermissionMode:     s.permissionMode,
 		LeveledLogger:      s.log,
-	})
-	if err != nil {
-		return am, err
+		ExpiredPolicy:      s.expiredAllocationPolicy,
+		ExpiredGracePeriod: s.expiredAllocationGracePeriod,
+		PermissionTimeout:  s.permissionTimeout,
+		PeerPorts:          s.allowedPeerPorts,
+		Metrics:            s.metrics,
+		Scheduler:          s.scheduler,
+		BufferPool:         s.bufferPool,
+		ChannelOffload:     s.channelOffload,
+		DSCP:               s.dscp,
+		EchoPeer:           s.echoPeer,
+		Events:             s.events,
+		SendBufferRetry:    s.sendBufferRetry,
+		MaxPacketSize:      s.maxRelayedPacketSize,
+		Standby:            s.standby,
+		Listener:           name,
+		ClientTransport:    transport,
+	}
+
+	if evenGenerator, ok := addrGenerator.(EvenPortRelayAddressGenerator); ok {
+		config.AllocateEvenPacketConn = evenGenerator.AllocateEvenPacketConn
+	}
+
+	if tcpGenerator, ok := addrGenerator.(TCPRelayAddressGenerator); ok && tcpAllocations {
+		config.AllocateListener = tcpGenerator.AllocateListener
+		config.DialPeer = tcpGenerator.DialPeer
+	}
+
+	return allocation.NewManager(config)
+}
+
+// allocationQuota returns false if username has reached its quota, UserQuota unless
+// overridden by quota, or QuotaHandler rejects the new allocation
+func (s *Server) allocationQuota(username, realm string, srcAddr net.Addr, quota int) bool {
+	if quota == 0 {
+		quota = s.userQuota
+	}
+	if quota > 0 && s.userAllocationCount(username) >= quota {
+		return false
+	}
+
+	return s.quotaHandler == nil || s.quotaHandler(username, realm, srcAddr)
+}
+
+// userAllocationCount returns the number of allocations of username, across the cluster
+// if there are Counters
+func (s *Server) userAllocationCount(username string) int {
+	if s.counters != nil {
+		count, err := s.counters.Members(userQuotaKey(username))
+		if err == nil {
+			return int(count)
+		}
+		s.log.Warnf("Failed to read allocation count of %q, using the local count: %v", username, err)
+	}
+
+	allocations := 0
+	for _, am := range s.managers() {
+		allocations += am.UsernameAllocationCount(username)
+	}
+
+	return allocations
+}
+
+// countAllocations wraps events to count the allocations per username in Counters. Every
+// allocation is a member of the set of its username that expires unless it is refreshed,
+// so the allocations of a server that crashed stop counting
+func (s *Server) countAllocations(events *allocation.Events) *allocation.Events {
+	counted := &allocation.Events{}
+	if events != nil {
+		*counted = *events
+	}
+
+	add := func(info AllocationInfo) {
+		if _, err := s.counters.AddMember(userQuotaKey(info.Username), s.quotaMember(info), userQuotaTTL); err != nil {
+			s.log.Warnf("Failed to count allocation of %q: %v", info.Username, err)
+		}
 	}
 
-	s.allocationManagers = append(s.allocationManagers, am)
+	onCreated, onRefreshed, onDeleted := counted.OnAllocationCreated, counted.OnAllocationRefreshed, counted.OnAllocationDeleted
+	counted.OnAllocationCreated = func(info AllocationInfo) {
+		add(info)
+		if onCreated != nil {
+			onCreated(info)
+		}
+	}
+	counted.OnAllocationRefreshed = func(info AllocationInfo, lifetime time.Duration) {
+		add(info)
+		if onRefreshed != nil {
+			onRefreshed(info, lifetime)
+		}
+	}
+	counted.OnAllocationDeleted = func(info AllocationInfo) {
+		if _, err := s.counters.RemoveMember(userQuotaKey(info.Username), s.quotaMember(info)); err != nil {
+			s.log.Warnf("Failed to uncount allocation of %q: %v", info.Username, err)
+		}
+		if onDeleted != nil {
+			onDeleted(info)
+		}
+	}
+
+	return counted
+}
 
-	return am, err
+func userQuotaKey(username string) string {
+	return "quota/" + username
+}
+
+// quotaMember identifies the allocation of info across the cluster. Relay addresses may
+// be private and reused by other servers, so they are qualified with the ID of the server
+func (s *Server) quotaMember(info AllocationInfo) string {
+	return s.nodeID + "/" + info.RelayAddr.String()
+}
+
+// listenerName returns name, or addr if name is empty
+func listenerName(name string, addr net.Addr) string {
+	if name == "" && addr != nil {
+		return addr.String()
+	}
+
+	return name
 }
 
 func (s *Server) readLoop(p net.PacketConn, allocationManager *allocation.Manager) {
+	var detachConn func() (net.Conn, []byte)
+	if stunConn, ok := p.(*STUNConn); ok {
+		detachConn = stunConn.detach
+	}
+
+	var anomalies *server.AnomalyCounter
+	var listenerRealm string
+	var authenticateBinding bool
+	sendBufferDrops := new(uint64)
+	if l := s.listenerState(allocationManager); l != nil {
+		anomalies = l.anomalies
+		listenerRealm = l.realm
+		authenticateBinding = l.authenticateBinding
+		sendBufferDrops = &l.sendBufferDrops
+	}
+
+	var conn net.PacketConn = &sendBufferConn{PacketConn: p, retry: s.sendBufferRetry, drops: sendBufferDrops}
+	if s.metrics != nil {
+		conn = &metricsConn{PacketConn: conn, metrics: s.metrics}
+	}
+
 	buf := make([]byte, s.inboundMTU)
 	for {
 		n, addr, err := p.ReadFrom(buf)
@@ -200,16 +955,50 @@ func (s *Server) readLoop(p net.PacketConn, allocationManager *allocation.Manage
 			continue
 		}
 
+		auth := s.authConfig()
+		realm := auth.Realm
+		if listenerRealm != "" {
+			realm = listenerRealm
+		}
+
 		if err := server.HandleRequest(server.Request{
-			Conn:               p,
-			SrcAddr:            addr,
-			Buff:               buf[:n],
-			Log:                s.log,
-			AuthHandler:        s.authHandler,
-			Realm:              s.realm,
-			AllocationManager:  allocationManager,
-			ChannelBindTimeout: s.channelBindTimeout,
-			NonceHash:          s.nonceHash,
+			Conn:                     conn,
+			DetachConn:               detachConn,
+			SrcAddr:                  addr,
+			Buff:                     buf[:n],
+			Log:                      s.log,
+			AuthHandler:              auth.AuthHandler,
+			AuthHandlerV2:            auth.AuthHandlerV2,
+			Context:                  s.ctx,
+			Realm:                    realm,
+			Realms:                   s.realms,
+			AllocationManager:        allocationManager,
+			ChannelBindTimeout:       s.channelBindTimeout,
+			PermissionCoalesceWindow: s.permissionCoalesceWindow,
+			Nonces:                   s.nonces,
+			ChallengeCache:           s.challengeCache,
+			CredentialCache:          s.credentialCache,
+			Maintenance:              s.maintenance,
+			Rebalance:                s.rebalance,
+			RefreshWatchdog:          s.refreshWatchdog,
+			StaleNonces:              s.staleNonceWatchdog,
+			RateLimiter:              s.rateLimiter,
+			BandwidthLimits:          s.bandwidthLimits,
+			Metrics:                  s.metrics,
+			Tracer:                   s.tracer,
+			Events:                   s.events,
+			Anomalies:                anomalies,
+			Mobility:                 s.mobility,
+			CredentialExpiry:         s.credentialExpiryPolicy,
+			AccessTokenHandler:       s.accessTokenHandler,
+			ThirdPartyAuthorization:  s.thirdPartyAuthorization,
+			AlternateServer:          s.alternateServerHandler,
+			Passthrough:              s.passthroughHandler,
+			AllocationQuota:          s.allocationQuota,
+			Policy:                   s.policy,
+			AffinityToken:            s.affinityToken,
+			RelayIdentity:            s.relayIdentity,
+			AuthenticateBinding:      authenticateBinding,
 		}); err != nil {
 			s.log.Errorf("Failed to handle datagram: %v", err)
 		}
@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const cpuAffinitySupported = true
+
+// setCPUAffinity pins the calling OS thread to cpus
+func setCPUAffinity(cpus []int) error {
+	var set unix.CPUSet
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+
+	return unix.SchedSetaffinity(0, &set)
+}
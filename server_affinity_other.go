@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package turn
+
+const cpuAffinitySupported = false
+
+// setCPUAffinity is Linux only, PacketConnConfig.validate rejects CPUs elsewhere
+func setCPUAffinity([]int) error {
+	return errCPUAffinityUnsupported
+}
@@ -5,12 +5,22 @@ package turn
 
 import (
 	"crypto/md5" //nolint:gosec,gci
+	"crypto/tls"
 	"fmt"
 	"net"
 	"strings"
 	"time"
 
 	"github.com/pion/logging"
+	"github.com/pion/turn/v3/counter"
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/ipnet"
+	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/internal/server"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/pion/turn/v3/offload"
+	"github.com/pion/turn/v3/policy"
+	"github.com/pion/turn/v3/tracing"
 )
 
 // RelayAddressGenerator is used to generate a RelayAddress when creating an allocation.
@@ -26,6 +36,32 @@ type RelayAddressGenerator interface {
 	AllocateConn(network string, requestedPort int) (net.Conn, net.Addr, error)
 }
 
+// TCPRelayAddressGenerator is a RelayAddressGenerator that can also create the relays of
+// RFC 6062 TCP allocations. The RelayAddressGenerator of a ListenerConfig with TCPAllocations
+// enabled must implement it.
+type TCPRelayAddressGenerator interface {
+	RelayAddressGenerator
+
+	// AllocateListener creates the listener that accepts peer connections to a TCP allocation
+	// and returns the IP/Port it is available at
+	AllocateListener(network string, requestedPort int) (net.Listener, net.Addr, error)
+
+	// DialPeer connects to a peer on behalf of a TCP allocation. localAddr is the address of the
+	// allocation's listener. Dialing should give up after 30 seconds, see RFC 6062 Section 5.2
+	DialPeer(network string, localAddr, peerAddr net.Addr) (net.Conn, error)
+}
+
+// ListenerRelayAddressGenerator is a RelayAddressGenerator that creates different relays
+// depending on the listener the Allocate request arrived on. The Server calls ForListener
+// once for every PacketConnConfig, ListenerConfig and QUICListenerConfig it is used by.
+type ListenerRelayAddressGenerator interface {
+	RelayAddressGenerator
+
+	// ForListener returns the RelayAddressGenerator of the allocations of the listener
+	// at listenerAddr
+	ForListener(listenerAddr net.Addr) (RelayAddressGenerator, error)
+}
+
 // PermissionHandler is a callback to filter incoming CreatePermission and ChannelBindRequest
 // requests based on the client IP address and port and the peer IP address the client intends to
 // connect to. If the client is behind a NAT then the filter acts on the server reflexive
@@ -51,6 +87,31 @@ type PacketConnConfig struct {
 	// case the DefaultPermissionHandler is automatically instantiated to admit all peer
 	// connections
 	PermissionHandler PermissionHandler
+
+	// Name identifies the listener in AllocationStats and AnomalyStats. Defaults to its local address
+	Name string
+
+	// Realm overrides ServerConfig.Realm for the clients of this listener
+	Realm string
+
+	// LockOSThread dedicates an OS thread to the goroutine reading from PacketConn, so the
+	// busy read path isn't moved between threads by the Go scheduler. Combine it with one
+	// PacketConn per core sharing the port with SO_REUSEPORT, see ReusePortPacketConnConfigs
+	// and examples/turn-server/simple-multithreaded, and compare with BenchmarkServer.
+	// The locked threads count against GOMAXPROCS, which the application may raise with
+	// runtime.GOMAXPROCS to leave processors for the rest of the process.
+	LockOSThread bool
+
+	// CPUs pins the OS thread reading from PacketConn to these CPUs, implies LockOSThread.
+	// Only supported on Linux.
+	CPUs []int
+
+	// AuthenticateBinding challenges Binding requests with a 401 (Unauthorized) error and a
+	// nonce like Allocate requests, and answers only those authenticated with the credentials
+	// of AuthHandler. Binding requests are unauthenticated in RFC 8489, enable it only for
+	// deployments that require it, e.g. enterprise SBCs multiplexing STUN and TURN. Client
+	// answers the challenge with its credentials
+	AuthenticateBinding bool
 }
 
 func (c *PacketConnConfig) validate() error {
@@ -60,6 +121,14 @@ func (c *PacketConnConfig) validate() error {
 	if c.RelayAddressGenerator == nil {
 		return errRelayAddressGeneratorUnset
 	}
+	if len(c.CPUs) != 0 && !cpuAffinitySupported {
+		return errCPUAffinityUnsupported
+	}
+	for _, cpu := range c.CPUs {
+		if cpu < 0 {
+			return fmt.Errorf("%w: %d", errCPUInvalid, cpu)
+		}
+	}
 
 	return c.RelayAddressGenerator.Validate()
 }
@@ -76,6 +145,73 @@ type ListenerConfig struct {
 	// case the DefaultPermissionHandler is automatically instantiated to admit all peer
 	// connections
 	PermissionHandler PermissionHandler
+
+	// TCPAllocations enables RFC 6062 TCP allocations for clients connected through this
+	// listener. The RelayAddressGenerator must implement TCPRelayAddressGenerator
+	TCPAllocations bool
+
+	// Datagram must be set if the connections accepted by Listener preserve message
+	// boundaries, like the DTLS connections of a DTLS listener. Every read is then handled
+	// as one STUN or ChannelData message, instead of framing a stream as for TCP and TLS.
+	// Use DemuxDTLS to share a UDP port between TURN over UDP and TURN over DTLS
+	Datagram bool
+
+	// TLSConfig enables TURN over TLS. The connections accepted by Listener, a plain TCP
+	// listener, are then wrapped with tls.Server. TLSConfig must carry a certificate unless
+	// CertFile and KeyFile are set
+	TLSConfig *tls.Config
+
+	// CertFile and KeyFile enable TURN over TLS with the PEM encoded certificate and key
+	// from these files. They are loaded again when they change, so certificates can be
+	// rotated without restarting the server or dropping allocations. Other TLS settings
+	// are taken from TLSConfig if set
+	CertFile string
+	KeyFile  string
+
+	// SessionResumption configures TLS session resumption for TURN over TLS. Resumption
+	// with session tickets is enabled by default, with ticket keys generated and rotated by
+	// crypto/tls for every listener
+	SessionResumption *TLSSessionResumption
+
+	// ProxyProtocol expects every connection to start with a PROXY protocol v2 header, as
+	// sent by HAProxy and other L4 load balancers ahead of TLS. The addresses it conveys
+	// replace the addresses of the connection, for the AuthHandler and the 5-tuples of the
+	// allocations. Only enable it behind a load balancer, clients could spoof their address
+	// otherwise. Connections without a valid header are closed
+	ProxyProtocol bool
+
+	// Name identifies the listener in AllocationStats and AnomalyStats. Defaults to its local address. The
+	// allocations of its clients are reported with ClientTransportDTLS if Datagram is set,
+	// ClientTransportTLS if TLS is enabled and ClientTransportTCP otherwise
+	Name string
+
+	// Realm overrides ServerConfig.Realm for the clients of this listener
+	Realm string
+
+	// AuthenticateBinding challenges Binding requests with a 401 (Unauthorized) error and a
+	// nonce like Allocate requests, and answers only those authenticated with the credentials
+	// of AuthHandler. Binding requests are unauthenticated in RFC 8489, enable it only for
+	// deployments that require it, e.g. enterprise SBCs multiplexing STUN and TURN. Client
+	// answers the challenge with its credentials
+	AuthenticateBinding bool
+}
+
+// TLSSessionResumption configures the session tickets of a TURN over TLS listener. Resumed
+// sessions skip the certificate exchange, which cuts the reconnect latency of mobile clients
+// that frequently lose their TCP connection.
+//
+// TLS 1.3 0-RTT early data is never accepted: crypto/tls doesn't allow it in the session
+// tickets it issues, so clients can't send requests before the handshake completes and a
+// replayed Allocate or ChannelBind can't create state.
+type TLSSessionResumption struct {
+	// Disabled disables session tickets, every connection does a full handshake
+	Disabled bool
+
+	// TicketKeys are the keys session tickets are encrypted with, the first one is used for
+	// new tickets and all of them to decrypt tickets. Share them between the servers of a
+	// fleet to resume sessions on any of them, and rotate them by prepending new keys.
+	// Defaults to keys generated and rotated by crypto/tls
+	TicketKeys [][32]byte
 }
 
 func (c *ListenerConfig) validate() error {
@@ -87,12 +223,311 @@ func (c *ListenerConfig) validate() error {
 		return errRelayAddressGeneratorUnset
 	}
 
+	if _, ok := c.RelayAddressGenerator.(TCPRelayAddressGenerator); c.TCPAllocations && !ok {
+		return errTCPRelayAddressGeneratorUnsupported
+	}
+
+	if c.TCPAllocations && c.Datagram {
+		return errTCPAllocationsOverDatagram
+	}
+
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errTLSKeyPairIncomplete
+	}
+
+	if c.Datagram && (c.TLSConfig != nil || c.CertFile != "") {
+		return errTLSOverDatagram
+	}
+
+	if c.SessionResumption != nil && c.TLSConfig == nil && c.CertFile == "" {
+		return errSessionResumptionWithoutTLS
+	}
+
+	if c.ProxyProtocol && c.Datagram {
+		return errProxyProtocolOverDatagram
+	}
+
 	return c.RelayAddressGenerator.Validate()
 }
 
+// tlsConfig returns the tls.Config to wrap Listener with, or nil if TLS isn't enabled
+func (c *ListenerConfig) tlsConfig() (*tls.Config, error) {
+	if c.CertFile == "" {
+		if c.TLSConfig == nil || c.SessionResumption == nil {
+			return c.TLSConfig, nil
+		}
+
+		tlsConfig := c.TLSConfig.Clone()
+		c.SessionResumption.apply(tlsConfig)
+		return tlsConfig, nil
+	}
+
+	reloader, err := NewCertificateReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.TLSConfig != nil {
+		tlsConfig = c.TLSConfig.Clone()
+	}
+	tlsConfig.Certificates = nil
+	tlsConfig.GetCertificate = reloader.GetCertificate
+	c.SessionResumption.apply(tlsConfig)
+
+	return tlsConfig, nil
+}
+
+func (r *TLSSessionResumption) apply(tlsConfig *tls.Config) {
+	if r == nil {
+		return
+	}
+
+	tlsConfig.SessionTicketsDisabled = r.Disabled
+	if !r.Disabled && len(r.TicketKeys) > 0 {
+		tlsConfig.SetSessionTicketKeys(r.TicketKeys)
+	}
+}
+
+// ClientTransport is the transport a client reached the server over
+type ClientTransport = allocation.Transport
+
+const (
+	// ClientTransportUDP is TURN over UDP
+	ClientTransportUDP = allocation.TransportUDP
+	// ClientTransportTCP is TURN over TCP
+	ClientTransportTCP = allocation.TransportTCP
+	// ClientTransportTLS is TURN over TLS-over-TCP
+	ClientTransportTLS = allocation.TransportTLS
+	// ClientTransportDTLS is TURN over DTLS-over-UDP
+	ClientTransportDTLS = allocation.TransportDTLS
+	// ClientTransportQUIC is the experimental TURN over QUIC
+	ClientTransportQUIC = allocation.TransportQUIC
+)
+
+// RelayProtocol is the transport protocol of a relayed transport address
+type RelayProtocol = allocation.Protocol
+
+const (
+	// RelayProtocolUDP is a UDP allocation
+	RelayProtocolUDP = allocation.UDP
+	// RelayProtocolTCP is an RFC 6062 TCP allocation
+	RelayProtocolTCP = allocation.TCP
+)
+
+// AllocationStats is the number of active allocations of a listener with the same client
+// transport, relay protocol and relay address family
+type AllocationStats struct {
+	Listener        string
+	ClientTransport ClientTransport
+	RelayProtocol   RelayProtocol
+	AddressFamily   RequestedAddressFamily
+	Allocations     int
+}
+
+// AllocationSnapshot is the state of an allocation at the time Server.Allocations was
+// called
+type AllocationSnapshot struct {
+	AllocationInfo
+	// Key identifies the allocation in Server.DeleteAllocation
+	Key string
+	// Lifetime is the time until the allocation expires unless it is refreshed
+	Lifetime time.Duration
+	// BytesToPeer, BytesToClient, PacketsToPeer and PacketsToClient count the data relayed
+	// so far. TCP connections of RFC 6062 allocations only count bytes, once the
+	// connection is closed
+	BytesToPeer     uint64
+	BytesToClient   uint64
+	PacketsToPeer   uint64
+	PacketsToClient uint64
+	// SendBufferDropsToPeer and SendBufferDropsToClient count the relayed datagrams dropped
+	// because the send buffer of the socket was full
+	SendBufferDropsToPeer   uint64
+	SendBufferDropsToClient uint64
+	// OversizedDropsToPeer and OversizedDropsToClient count the relayed datagrams dropped
+	// because they exceeded the maximum packet size, see ServerConfig.MaxRelayedPacketSize
+	OversizedDropsToPeer   uint64
+	OversizedDropsToClient uint64
+}
+
+// Anomaly is a deviation from the STUN wire format in a received datagram, see
+// Server.AnomalyStats
+type Anomaly = server.Anomaly
+
+const (
+	// AnomalyMissingFingerprint is a STUN message without FINGERPRINT
+	AnomalyMissingFingerprint = server.AnomalyMissingFingerprint
+	// AnomalyBadMagicCookie is a datagram with a STUN header, but a magic cookie other
+	// than 0x2112A442
+	AnomalyBadMagicCookie = server.AnomalyBadMagicCookie
+	// AnomalyReservedBits is a STUN message with an attribute whose reserved bits are set,
+	// e.g. the RFFU bytes of REQUESTED-TRANSPORT or the first byte of an XOR address
+	AnomalyReservedBits = server.AnomalyReservedBits
+	// AnomalyAttributeOrder is a STUN message with attributes after FINGERPRINT, or after
+	// MESSAGE-INTEGRITY other than MESSAGE-INTEGRITY-SHA256 and FINGERPRINT
+	AnomalyAttributeOrder = server.AnomalyAttributeOrder
+)
+
+// AnomalyStats is the number of datagrams received on a listener with an Anomaly
+type AnomalyStats struct {
+	Listener string
+	Anomaly  Anomaly
+	Count    uint64
+}
+
+// SendBufferStats is the number of datagrams the server sent on a listener, responses and
+// data relayed to clients, that were dropped because the send buffer of the socket was full
+type SendBufferStats struct {
+	Listener string
+	Drops    uint64
+}
+
+const (
+	minPermissionTimeout = time.Second
+	maxPermissionTimeout = time.Hour
+	minNonceLifetime     = time.Second
+)
+
+// PermissionMode controls how the server matches inbound peer traffic against the permissions
+// of an allocation
+type PermissionMode = allocation.PermissionMode
+
+const (
+	// PermissionModeIP relays traffic from any port of a permitted peer IP address. This is the
+	// behavior required by RFC 5766 Section 8 and the default
+	PermissionModeIP = allocation.PermissionModeIP
+	// PermissionModeIPPort only relays traffic from the peer IP address and port pairs that
+	// CreatePermission and ChannelBind requests were sent for. This is not standard: it limits
+	// what a compromised peer host can reach through the relay, but breaks peers behind NATs
+	// that change the port, and incoming RFC 6062 TCP connections from ephemeral ports
+	PermissionModeIPPort = allocation.PermissionModeIPPort
+)
+
+// RefreshStorm is passed to RefreshWatchdogConfig.OnRefreshStorm when an allocation or
+// username refreshes far more often than needed
+type RefreshStorm = server.RefreshStorm
+
+// RefreshWatchdogConfig configures detection of refresh storms, see ServerConfig.RefreshWatchdog
+type RefreshWatchdogConfig = server.RefreshWatchdogConfig
+
+// NonceRotation is passed to ServerConfig.OnNonceRotated when the key nonces are signed
+// with is rotated
+type NonceRotation = server.NonceRotation
+
+// StaleNonceStorm is passed to StaleNonceWatchdogConfig.OnStaleNonceStorm when more requests
+// than expected are rejected with a 438 (Stale Nonce) error
+type StaleNonceStorm = server.StaleNonceStorm
+
+// StaleNonceWatchdogConfig configures detection of stale nonce storms, see
+// ServerConfig.StaleNonceWatchdog
+type StaleNonceWatchdogConfig = server.StaleNonceWatchdogConfig
+
+// RateLimiterConfig configures the rate limiting of requests per source IP, see
+// ServerConfig.RateLimiter
+type RateLimiterConfig = server.RateLimiterConfig
+
+// BandwidthLimitConfig configures the bandwidth caps of allocations and users, see
+// ServerConfig.BandwidthLimit
+type BandwidthLimitConfig = server.BandwidthLimitConfig
+
+// FairSchedulerConfig configures the fair scheduling of relayed datagrams, see
+// ServerConfig.FairScheduler
+type FairSchedulerConfig = allocation.SchedulerConfig
+
+// SendBufferRetryConfig configures the retries of datagrams dropped because the send buffer
+// of a socket was full, see ServerConfig.SendBufferRetry
+type SendBufferRetryConfig = allocation.SendBufferRetry
+
+// RelayIdentity identifies the region, point of presence and node of a server, see
+// ServerConfig.RelayIdentity
+type RelayIdentity = proto.RelayIdentity
+
+// AllocationInfo identifies the allocation passed to the lifecycle callbacks of
+// ServerConfig, e.g. OnAllocationCreated
+type AllocationInfo = allocation.Info
+
+// PeerPortRange is an inclusive range of peer ports, see ServerConfig.AllowedPeerPorts
+type PeerPortRange = allocation.PortRange
+
+// CredentialExpiryPolicy ends allocations once the time-windowed credential they were
+// created with expires, see ServerConfig.CredentialExpiryPolicy
+type CredentialExpiryPolicy = server.CredentialExpiryPolicy
+
+// ExpiredAllocationPolicy controls what the server does with peer packets that arrive on
+// the relay port of an allocation after its lifetime has expired. Late packets are common
+// when the client and the peer race the expiry, and how they are handled helps to tell a
+// missed refresh apart from other causes of one-way media.
+type ExpiredAllocationPolicy = allocation.ExpiredPolicy
+
+const (
+	// ExpiredAllocationUnreachable closes the relay port as soon as the allocation expires,
+	// the operating system answers late peer packets with an ICMP port unreachable. This is the default
+	ExpiredAllocationUnreachable = allocation.ExpiredPolicyUnreachable
+	// ExpiredAllocationDrop keeps the relay port open for ExpiredAllocationGracePeriod and
+	// silently drops late peer packets
+	ExpiredAllocationDrop = allocation.ExpiredPolicyDrop
+	// ExpiredAllocationCount is like ExpiredAllocationDrop, but late peer packets are logged
+	// and counted in Server.ExpiredAllocationPackets
+	ExpiredAllocationCount = allocation.ExpiredPolicyCount
+)
+
+// AlternateServerHandler is called for every authenticated Allocate request. Returning true
+// rejects the request with a 300 (Try Alternate) error pointing the client at alternateServer,
+// which must be a *net.UDPAddr or *net.TCPAddr. It is called from the read loop and must not
+// block.
+type AlternateServerHandler func(username, realm string, srcAddr net.Addr) (alternateServer net.Addr, ok bool)
+
+// PassthroughHandler is called with the datagrams of a PacketConn that are neither STUN
+// messages nor ChannelData, conn is the PacketConn to answer srcAddr on. data is only valid
+// until PassthroughHandler returns. It is called from the read loop and must not block.
+type PassthroughHandler func(conn net.PacketConn, srcAddr net.Addr, data []byte)
+
+// QuotaHandler is called for every authenticated Allocate request and returns false if the
+// new allocation would exceed the quota of username, the request is then rejected with a
+// 486 (Allocation Quota Reached) error. It is called from the read loop and must not block.
+type QuotaHandler func(username, realm string, srcAddr net.Addr) (ok bool)
+
+// AuthConfig is the authentication config that can be changed while the server is
+// running, see Server.UpdateAuthConfig
+type AuthConfig struct {
+	// Realm is sent in 401 (Unauthorized) responses
+	Realm string
+	// AuthHandler returns the keys of the users
+	AuthHandler AuthHandler
+	// AuthHandlerV2 replaces AuthHandler if set
+	AuthHandlerV2 AuthHandlerV2
+}
+
+// NonceGenerator creates and validates the nonces of the long-term credential mechanism,
+// see ServerConfig.NonceGenerator. Both methods are called from the read loops and must
+// be safe for concurrent use.
+type NonceGenerator = server.NonceGenerator
+
+// RealmConfig selects the realm of the usernames with a suffix, see ServerConfig.Realms
+type RealmConfig = server.RealmConfig
+
 // AuthHandler is a callback used to handle incoming auth requests, allowing users to customize Pion TURN with custom behavior
 type AuthHandler func(username, realm string, srcAddr net.Addr) (key []byte, ok bool)
 
+// AuthRequest is a request authenticated by an AuthHandlerV2. Message is the STUN request,
+// it must not be modified or used after the AuthHandlerV2 returned
+type AuthRequest = server.AuthRequest
+
+// AuthHandlerV2 is an AuthHandler that gets the whole request and returns the key of the
+// user along with the SessionPolicy of the allocation. ctx is cancelled when the server is
+// closed. It is called from the read loop like AuthHandler, for every authenticated request,
+// but the SessionPolicy is only applied to the allocations of Allocate requests.
+type AuthHandlerV2 = server.AuthHandlerV2
+
+// SessionPolicy is the policy of an allocation returned by an AuthHandlerV2. MaxLifetime
+// caps the lifetime of Allocate and Refresh requests. Quota replaces UserQuota for the
+// user. BytesPerSecond replaces the bandwidth limit of the allocation, see
+// BandwidthLimitConfig. CreatePermission, ChannelBind and Connect requests for peers outside
+// AllowedPeers are rejected with a 403 (Forbidden) error. DSCP replaces ServerConfig.DSCP on
+// the relay sockets of the allocation. MaxPacketSize replaces ServerConfig.MaxRelayedPacketSize,
+// e.g. for a class of users. Zero values keep the defaults.
+type SessionPolicy = allocation.SessionPolicy
+
 // GenerateAuthKey is a convenience function to easily generate keys in the format used by AuthHandler
 func GenerateAuthKey(username, realm, password string) []byte {
 	// #nosec
@@ -108,27 +543,332 @@ type ServerConfig struct {
 	PacketConnConfigs []PacketConnConfig
 	ListenerConfigs   []ListenerConfig
 
+	// QUICListenerConfigs is a list of experimental TURN over QUIC listeners
+	QUICListenerConfigs []QUICListenerConfig
+
 	// LoggerFactory must be set for logging from this server.
 	LoggerFactory logging.LoggerFactory
 
-	// Realm sets the realm for this server
+	// Realm sets the realm for this server. It can be changed with Server.UpdateAuthConfig
 	Realm string
 
-	// AuthHandler is a callback used to handle incoming auth requests, allowing users to customize Pion TURN with custom behavior
+	// Realms selects the realm by the suffix of the USERNAME, e.g. "example.com" for
+	// "alice@example.com", so one server can serve several realms. The longest matching
+	// suffix wins. Users without a matching suffix are in the realm of their listener or
+	// Realm. A client that sent another realm is challenged again with the selected one
+	Realms []RealmConfig
+
+	// AuthHandler is a callback used to handle incoming auth requests, allowing users to customize Pion TURN with custom behavior.
+	// It can be replaced with Server.UpdateAuthConfig
 	AuthHandler AuthHandler
 
+	// AuthHandlerV2 replaces AuthHandler if set. It can return a SessionPolicy for every
+	// allocation, and can be replaced with Server.UpdateAuthConfig
+	AuthHandlerV2 AuthHandlerV2
+
+	// AccessTokenHandler enables RFC 7635 third-party authorization. Requests with an
+	// ACCESS-TOKEN are authenticated with it instead of AuthHandler, and rejected with a
+	// 401 (Unauthorized) error if the token isn't valid. AuthHandler may be nil if all
+	// clients use access tokens.
+	AccessTokenHandler AccessTokenHandler
+
+	// ThirdPartyAuthorization is the STUN server name sent in THIRD-PARTY-AUTHORIZATION
+	// with 401 (Unauthorized) responses, announcing that access tokens are accepted. Not
+	// sent if empty.
+	ThirdPartyAuthorization string
+
+	// AlternateServerHandler redirects Allocate requests to other servers, e.g. to shed load
+	// or to steer clients to their region. Allocations are never redirected if nil.
+	AlternateServerHandler AlternateServerHandler
+
+	// UserQuota is the maximum number of concurrent allocations per username, further
+	// Allocate requests are rejected with a 486 (Allocation Quota Reached) error. Disabled
+	// if 0.
+	UserQuota int
+
+	// QuotaHandler enforces custom allocation quotas, e.g. per account tier. It is called
+	// for requests within UserQuota.
+	QuotaHandler QuotaHandler
+
+	// Counters stores the allocations per username counted for UserQuota and the requests
+	// counted by RateLimiter. Set it to a store shared by all servers of a cluster, e.g.
+	// counter.Redis, to enforce the limits across the cluster. The counts of servers that
+	// crash expire within 2 hours. RateLimiter decides on the counts of its last sync and
+	// syncs in the background. If the store fails, the limits are enforced per server, and
+	// it isn't called for 10 seconds after 3 consecutive failures. Every server counts on
+	// its own if nil.
+	Counters counter.Store
+
+	// Policy decides whether Allocate, CreatePermission, ChannelBind and Connect requests
+	// are allowed after the PermissionHandler and quotas, denied requests are rejected with
+	// a 403 (Forbidden) error. Use a policy.Engine to load policies at runtime, or adapt
+	// another policy engine with policy.EvaluatorFunc. Everything is allowed if nil.
+	Policy policy.Evaluator
+
+	// AffinityToken is an opaque value identifying this server, e.g. its node ID. If set it
+	// is sent as AFFINITY-TOKEN attribute in 401 (Unauthorized), Allocate and Refresh
+	// responses. Clients echo it in their requests, so a load balancer in front of several
+	// servers can route a reconnecting client to the server holding its allocation without
+	// keeping state of its own.
+	AffinityToken []byte
+
+	// RelayIdentity, if set, is sent as RELAY-IDENTITY attribute in Allocate responses, so
+	// applications can log which relay served a call with Client.RelayIdentity and
+	// correlate quality by point of presence. Every field is at most 255 bytes.
+	RelayIdentity RelayIdentity
+
 	// ChannelBindTimeout sets the lifetime of channel binding. Defaults to 10 minutes.
 	ChannelBindTimeout time.Duration
 
+	// PermissionTimeout sets the lifetime of permissions, between 1 second and 1 hour.
+	// Defaults to 5 minutes as required by RFC 8656. Longer lifetimes keep quiet flows of
+	// clients that refresh late working. Clients refresh permissions every 2 minutes or
+	// so, shorter lifetimes are mostly useful for testing.
+	PermissionTimeout time.Duration
+
+	// NonceLifetime sets how long a nonce is accepted, at least 1 second. Requests with an
+	// expired nonce are rejected with a 438 (Stale Nonce) error carrying a new one, so
+	// captured requests can't be replayed after it. Defaults to 1 hour.
+	NonceLifetime time.Duration
+
+	// NonceGenerator replaces the generator of nonces, e.g. to share nonces between the
+	// servers of a cluster. NonceLifetime is ignored if set.
+	NonceGenerator NonceGenerator
+
+	// OnNonceRotated is called when the key nonces are signed with is rotated, at most once
+	// per NonceLifetime. It is called from the read loops and must not block. Not called if
+	// NonceGenerator is set.
+	OnNonceRotated func(NonceRotation)
+
+	// ChallengeCacheTTL enables caching the nonce of the 401 (Unauthorized) challenge of a
+	// source IP for this long. The retry of a client right after the challenge is then
+	// accepted without validating its nonce, which cuts the CPU spent on Allocate requests
+	// in connection storms. It must be less than half of NonceLifetime, a few seconds
+	// suffice. Disabled if 0.
+	ChallengeCacheTTL time.Duration
+
+	// CacheCredentials keeps the key the requests of an allocation were authenticated with
+	// with the allocation, so its Refresh, CreatePermission and ChannelBind requests are
+	// verified without calling the AuthHandler. Requests that fail to verify with the
+	// cached key are authenticated with the AuthHandler. UpdateAuthConfig drops all cached
+	// keys and RequireReauthentication those of a username, so call them when credentials
+	// change. The key of a time-windowed credential is only cached until it expires, see
+	// CredentialExpiryPolicy.Expiry, after that the AuthHandler authenticates the requests
+	// again.
+	CacheCredentials bool
+
+	// AllowedPeerPorts restricts the peer ports relayed traffic may be sent to, e.g. to
+	// {Min: 1024, Max: 65535} to keep clients away from well-known service ports. CreatePermission,
+	// ChannelBind and Connect requests for other ports are rejected with a 403 (Forbidden)
+	// error and Send indications to them are dropped. All ports are allowed if empty.
+	AllowedPeerPorts []PeerPortRange
+
+	// PermissionCoalesceWindow enables coalescing of duplicate CreatePermission requests. A
+	// request from an allocation for the identical set of peer addresses as its previous one,
+	// made less than this long after that one was handled, only refreshes the existing
+	// permissions: the PermissionHandler isn't called again and nothing is reinstalled. Useful
+	// for chatty clients that re-permit every second. Disabled if 0.
+	PermissionCoalesceWindow time.Duration
+
 	// Sets the server inbound MTU(Maximum transmition unit). Defaults to 1600 bytes.
 	InboundMTU int
+
+	// EchoPeer is a peer address the allocations echo datagrams on, instead of relaying
+	// them, so clients can measure the throughput and latency of the relays without
+	// deploying a peer. Datagrams the client sends to EchoPeer are sent back to the client
+	// as if EchoPeer relayed them. The client needs a permission for EchoPeer as for any
+	// peer, pick an address PermissionHandler and AllowedPeerPorts allow, e.g. from
+	// 192.0.2.0/24 (TEST-NET-1). Disabled if nil.
+	EchoPeer *net.UDPAddr
+
+	// PassthroughHandler receives the datagrams of PacketConns that are neither STUN nor
+	// ChannelData, e.g. to serve a proprietary protocol on the TURN socket. They are
+	// dropped if nil.
+	PassthroughHandler PassthroughHandler
+
+	// RelayBufferSize is the largest datagram relayed from peers to clients, larger ones are
+	// truncated. Relays read datagrams into buffers of a pool shared by all allocations,
+	// so relaying doesn't allocate per datagram. Defaults to 1600 bytes.
+	RelayBufferSize int
+
+	// PermissionMode sets how inbound peer traffic is matched against permissions. Defaults to
+	// PermissionModeIP. Server.PermissionPortMismatchPackets counts the traffic that is handled
+	// differently by the two modes.
+	PermissionMode PermissionMode
+
+	// ExpiredAllocationPolicy sets how peer packets for expired allocations are handled.
+	// Defaults to ExpiredAllocationUnreachable.
+	ExpiredAllocationPolicy ExpiredAllocationPolicy
+
+	// ExpiredAllocationGracePeriod sets for how long the relay port of an expired allocation
+	// is kept open with ExpiredAllocationDrop and ExpiredAllocationCount. Defaults to 30 seconds.
+	ExpiredAllocationGracePeriod time.Duration
+
+	// Mobility enables RFC 8016 mobility. Clients that request it with a MOBILITY-TICKET keep
+	// their allocation when their address changes, e.g. when moving from Wi-Fi to LTE. Without
+	// it such requests are rejected with a 405 (Mobility Forbidden) error.
+	Mobility bool
+
+	// RefreshWatchdog enables detection of clients that send Refresh requests far faster than
+	// their allocation lifetime requires. Window defaults to 1 minute and, if neither limit is
+	// set, MaxRefreshes defaults to 10. Disabled if nil.
+	RefreshWatchdog *RefreshWatchdogConfig
+
+	// StaleNonceWatchdog enables detection of spikes of 438 (Stale Nonce) responses, with the
+	// count of every client, to tell routine nonce rotations from clients that keep failing
+	// to authenticate after them. Window defaults to 1 minute and MaxStaleNonces to 100.
+	// Disabled if nil.
+	StaleNonceWatchdog *StaleNonceWatchdogConfig
+
+	// RateLimiter limits the Allocate requests, or all requests except Binding requests,
+	// of every source IP. Requests over the limit are answered with a 508 (Insufficient
+	// Capacity) error or dropped. Rate defaults to 1 request per second and Burst to 10.
+	// Disabled if nil.
+	RateLimiter *RateLimiterConfig
+
+	// BandwidthLimit caps the bytes per second relayed by every allocation, and optionally by
+	// all allocations of a username, in both directions combined. UDP datagrams over the
+	// limit are dropped, TCP connections are slowed down. Unlimited if nil.
+	BandwidthLimit *BandwidthLimitConfig
+
+	// FairScheduler queues the datagrams relayed by UDP allocations in both directions and
+	// sends them with deficit round robin across allocations, so an allocation relaying a
+	// lot can't starve the others when the egress of the host is saturated. Set
+	// BytesPerSecond to the egress capacity to pace the datagrams. Datagrams are sent
+	// immediately by the goroutine that relays them if nil.
+	FairScheduler *FairSchedulerConfig
+
+	// SendBufferRetry sends the datagrams the OS refuses with EAGAIN or ENOBUFS because the
+	// send buffer of a listener or relay socket is full, e.g. under bursts, again after a
+	// pause instead of dropping them right away. The goroutine sending the datagram blocks
+	// while retrying, keep it short. Attempts defaults to 3 and Interval to 1 millisecond.
+	// Dropped datagrams are counted with and without it, see Server.SendBufferStats,
+	// AllocationSnapshot and Metrics. Not retried if nil.
+	SendBufferRetry *SendBufferRetryConfig
+
+	// ChannelOffload, if set, forwards the ChannelData of the channels of UDP clients
+	// outside of the server once they are bound, e.g. in the kernel with offload.XDP, so the
+	// server only handles control traffic and the flows without a channel. Offloaded traffic
+	// isn't counted by Metrics and isn't subject to BandwidthLimit and FairScheduler. Set
+	// it to an offload.Table to export the channel bindings to forwarders in other processes.
+	ChannelOffload offload.Offloader
+
+	// DSCP, between 0 and 63, marks the packets the server sends on the sockets of
+	// PacketConnConfigs and ListenerConfigs and on relay sockets, so relayed voice and video
+	// get the QoS treatment of the class, e.g. 46 (Expedited Forwarding). The DSCP of the
+	// relay sockets of an allocation can be set with SessionPolicy.DSCP. Sockets that can't
+	// be marked, e.g. on platforms other than Linux, are logged and left unmarked. Unmarked
+	// if 0.
+	DSCP int
+
+	// MaxRelayedPacketSize caps the size of the datagrams UDP allocations relay in both
+	// directions, e.g. 1200 bytes to keep media within the path MTU and to stop abuse with
+	// fragmented datagrams. Larger datagrams are dropped and counted, see
+	// AllocationSnapshot and Metrics. The cap of the allocations of a user can be set with
+	// SessionPolicy.MaxPacketSize. Offloaded channels aren't capped. Unlimited if 0.
+	MaxRelayedPacketSize int
+
+	// Metrics, if set, counts allocations, relayed traffic, authentication failures,
+	// channel bindings and error responses. See package metrics for exporting them to
+	// Prometheus.
+	Metrics *metrics.Metrics
+
+	// Tracer, if set, traces Allocate, Refresh, CreatePermission and ChannelBind requests
+	// with their username, realm and result code. See package tracing.
+	Tracer tracing.Tracer
+
+	// OnAllocationCreated, OnAllocationRefreshed, OnAllocationDeleted, OnPermissionCreated
+	// and OnChannelBound, if set, are called on the lifecycle events of allocations, e.g. for
+	// billing and session tracking. They are called from the read loops and timers of the
+	// server and must not block.
+	//
+	// OnAllocationCreated is called when an Allocate request created an allocation.
+	OnAllocationCreated func(info AllocationInfo)
+	// OnAllocationRefreshed is called when a Refresh request extended the lifetime of an
+	// allocation to lifetime.
+	OnAllocationRefreshed func(info AllocationInfo, lifetime time.Duration)
+	// OnAllocationDeleted is called when an allocation is deleted by the client, expires or
+	// is closed with the server.
+	OnAllocationDeleted func(info AllocationInfo)
+	// OnPermissionCreated is called when a CreatePermission or ChannelBind request installed
+	// a permission for a peer the allocation had none for. Refreshes aren't reported.
+	OnPermissionCreated func(info AllocationInfo, peer net.Addr)
+	// OnChannelBound is called when a ChannelBind request bound a new channel. Refreshes
+	// aren't reported.
+	OnChannelBound func(info AllocationInfo, peer net.Addr, channel uint16)
+
+	// OnReplicationEvent, if set, is called with the changes of the UDP allocations, to be
+	// streamed to a warm standby server that applies them with Server.ApplyReplicationEvent,
+	// e.g. encoded with ReplicationEvent.MarshalBinary. Combined with VRRP or anycast the
+	// standby keeps the allocations alive when this server fails. It is called from the
+	// read loops and timers of the server and must not block.
+	OnReplicationEvent func(ReplicationEvent)
+
+	// CredentialExpiryPolicy rejects Refresh requests with a 401 (Unauthorized) error once
+	// the credential expired. If nil credentials only need to be accepted by AuthHandler,
+	// which lets an allocation outlive its credential if the AuthHandler doesn't check
+	// the expiry.
+	CredentialExpiryPolicy *CredentialExpiryPolicy
 }
 
 func (s *ServerConfig) validate() error {
-	if len(s.PacketConnConfigs) == 0 && len(s.ListenerConfigs) == 0 {
+	if len(s.PacketConnConfigs) == 0 && len(s.ListenerConfigs) == 0 && len(s.QUICListenerConfigs) == 0 {
 		return errNoAvailableConns
 	}
 
+	if s.PermissionTimeout < 0 || s.PermissionTimeout > maxPermissionTimeout ||
+		(s.PermissionTimeout != 0 && s.PermissionTimeout < minPermissionTimeout) {
+		return fmt.Errorf("%w: %s", errPermissionTimeoutInvalid, s.PermissionTimeout)
+	}
+
+	if s.RelayIdentity.Validate() != nil {
+		return fmt.Errorf("%w: %s", errRelayIdentityInvalid, s.RelayIdentity)
+	}
+
+	if s.DSCP < 0 || s.DSCP > ipnet.MaxDSCP {
+		return fmt.Errorf("%w: %d", errDSCPInvalid, s.DSCP)
+	}
+
+	if s.EchoPeer != nil && (s.EchoPeer.IP == nil || s.EchoPeer.Port == 0) {
+		return fmt.Errorf("%w: %v", errEchoPeerInvalid, s.EchoPeer)
+	}
+
+	if s.RelayBufferSize < 0 {
+		return fmt.Errorf("%w: %d", errRelayBufferSizeInvalid, s.RelayBufferSize)
+	}
+
+	if s.MaxRelayedPacketSize < 0 {
+		return fmt.Errorf("%w: %d", errMaxRelayedPacketSizeInvalid, s.MaxRelayedPacketSize)
+	}
+
+	if r := s.SendBufferRetry; r != nil && (r.Attempts < 0 || r.Interval < 0) {
+		return fmt.Errorf("%w: %d, %s", errSendBufferRetryInvalid, r.Attempts, r.Interval)
+	}
+
+	if s.NonceLifetime < 0 || (s.NonceLifetime != 0 && s.NonceLifetime < minNonceLifetime) {
+		return fmt.Errorf("%w: %s", errNonceLifetimeInvalid, s.NonceLifetime)
+	}
+
+	nonceLifetime := s.NonceLifetime
+	if nonceLifetime == 0 {
+		nonceLifetime = server.DefaultNonceLifetime
+	}
+	if s.ChallengeCacheTTL < 0 || (s.NonceGenerator == nil && 2*s.ChallengeCacheTTL >= nonceLifetime) {
+		return fmt.Errorf("%w: %s", errChallengeCacheTTLInvalid, s.ChallengeCacheTTL)
+	}
+
+	for _, r := range s.AllowedPeerPorts {
+		if r.Min == 0 || r.Min > r.Max {
+			return fmt.Errorf("%w: %d-%d", errPeerPortRangeInvalid, r.Min, r.Max)
+		}
+	}
+
+	for _, r := range s.Realms {
+		if r.UsernameSuffix == "" || r.Realm == "" {
+			return fmt.Errorf("%w: %q", errRealmConfigInvalid, r.UsernameSuffix)
+		}
+	}
+
 	for _, s := range s.PacketConnConfigs {
 		if err := s.validate(); err != nil {
 			return err
@@ -141,5 +881,11 @@ func (s *ServerConfig) validate() error {
 		}
 	}
 
+	for _, s := range s.QUICListenerConfigs {
+		if err := s.validate(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/server"
+)
+
+// listenerState is the state of a listener that is shared by its read loops
+type listenerState struct {
+	// Accessed atomically, first for 64-bit alignment
+	sendBufferDrops uint64
+
+	name      string
+	realm     string
+	anomalies *server.AnomalyCounter
+
+	authenticateBinding bool
+
+	addr   net.Addr
+	socket io.Closer
+	// done is closed once the read loop returned and closed the allocation manager
+	done chan struct{}
+}
+
+// AddPacketConn starts serving on the PacketConn of cfg while the server runs, e.g. on a
+// network interface that appeared. Its allocations are deleted with RemoveListener or Close
+func (s *Server) AddPacketConn(cfg PacketConnConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	readLoop, err := s.addPacketConn(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.goReader(readLoop)
+	return nil
+}
+
+// AddListener starts accepting connections on the Listener of cfg while the server runs.
+// Its allocations are deleted with RemoveListener or Close
+func (s *Server) AddListener(cfg ListenerConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	readLoop, err := s.addListener(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.goReader(readLoop)
+	return nil
+}
+
+// RemoveListener stops serving on the PacketConn, Listener or QUICListener with the local
+// address addr, e.g. to retire a port. Like Close, it closes the socket, then deletes the
+// allocations created through it and closes their relay sockets. It returns
+// ErrListenerNotFound if the server has no listener on addr
+func (s *Server) RemoveListener(addr net.Addr) error {
+	s.listenersLock.RLock()
+	var (
+		am *allocation.Manager
+		l  *listenerState
+	)
+	for _, m := range s.allocationManagers {
+		state := s.listeners[m]
+		if state.addr.Network() == addr.Network() && state.addr.String() == addr.String() {
+			am, l = m, state
+			break
+		}
+	}
+	s.listenersLock.RUnlock()
+
+	if am == nil {
+		return fmt.Errorf("%w: %s %s", ErrListenerNotFound, addr.Network(), addr)
+	}
+
+	closeErr := l.socket.Close()
+	<-l.done
+
+	// The read loop doesn't close the manager if Close is closing the server
+	managerErr := am.Close()
+
+	s.listenersLock.Lock()
+	for i, m := range s.allocationManagers {
+		if m == am {
+			s.allocationManagers = append(s.allocationManagers[:i:i], s.allocationManagers[i+1:]...)
+			break
+		}
+	}
+	delete(s.listeners, am)
+	s.listenersLock.Unlock()
+
+	return allocation.JoinErrors(closeErr, managerErr)
+}
+
+func (s *Server) addPacketConn(cfg PacketConnConfig) (func(), error) {
+	s.setListenerDSCP(cfg.PacketConn, cfg.PacketConn.LocalAddr())
+
+	name := listenerName(cfg.Name, cfg.PacketConn.LocalAddr())
+	am, err := s.createAllocationManager(cfg.RelayAddressGenerator, cfg.PermissionHandler, false,
+		cfg.PacketConn.LocalAddr(), name, allocation.TransportUDP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AllocationManager: %w", err)
+	}
+
+	return s.registerListener(am, name, cfg.Realm, cfg.AuthenticateBinding, cfg.PacketConn.LocalAddr(), cfg.PacketConn, func() {
+		// The thread isn't unlocked, so it exits with the goroutine instead of
+		// returning to the scheduler with its CPU affinity
+		if cfg.LockOSThread || len(cfg.CPUs) != 0 {
+			runtime.LockOSThread()
+		}
+		if len(cfg.CPUs) != 0 {
+			if err := setCPUAffinity(cfg.CPUs); err != nil {
+				s.log.Errorf("Failed to pin the read loop of %s to CPUs %v: %s", cfg.PacketConn.LocalAddr(), cfg.CPUs, err)
+			}
+		}
+
+		s.readLoop(cfg.PacketConn, am)
+	})
+}
+
+func (s *Server) addListener(cfg ListenerConfig) (func(), error) {
+	s.setListenerDSCP(cfg.Listener, cfg.Listener.Addr())
+
+	// The PROXY header precedes the TLS handshake
+	if cfg.ProxyProtocol {
+		cfg.Listener = &proxyListener{Listener: cfg.Listener}
+	}
+
+	tlsConfig, err := cfg.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	} else if tlsConfig != nil {
+		cfg.Listener = tls.NewListener(cfg.Listener, tlsConfig)
+	}
+
+	transport := allocation.TransportTCP
+	switch {
+	case cfg.Datagram:
+		transport = allocation.TransportDTLS
+	case tlsConfig != nil:
+		transport = allocation.TransportTLS
+	}
+
+	name := listenerName(cfg.Name, cfg.Listener.Addr())
+	am, err := s.createAllocationManager(cfg.RelayAddressGenerator, cfg.PermissionHandler, cfg.TCPAllocations,
+		cfg.Listener.Addr(), name, transport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AllocationManager: %w", err)
+	}
+
+	return s.registerListener(am, name, cfg.Realm, cfg.AuthenticateBinding, cfg.Listener.Addr(), cfg.Listener, func() {
+		s.readListener(cfg.Listener, am, cfg.Datagram)
+	})
+}
+
+func (s *Server) addQUICListener(cfg QUICListenerConfig) (func(), error) {
+	name := listenerName(cfg.Name, cfg.Listener.Addr())
+	am, err := s.createAllocationManager(cfg.RelayAddressGenerator, cfg.PermissionHandler, false,
+		cfg.Listener.Addr(), name, allocation.TransportQUIC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AllocationManager: %w", err)
+	}
+
+	return s.registerListener(am, name, cfg.Realm, false, cfg.Listener.Addr(), cfg.Listener, func() {
+		s.readQUICListener(cfg.Listener, am)
+	})
+}
+
+// registerListener adds the state of the listener of am, and returns its read loop, which
+// closes am once read returned. It fails if the server is closed
+func (s *Server) registerListener(am *allocation.Manager, name, realm string, authenticateBinding bool, addr net.Addr, socket io.Closer, read func()) (func(), error) {
+	l := &listenerState{
+		name:      name,
+		realm:     realm,
+		anomalies: &server.AnomalyCounter{},
+		addr:      addr,
+		socket:    socket,
+		done:      make(chan struct{}),
+
+		authenticateBinding: authenticateBinding,
+	}
+
+	s.listenersLock.Lock()
+	defer s.listenersLock.Unlock()
+
+	// Close cancels the context before it closes the registered listeners
+	if s.ctx.Err() != nil {
+		_ = am.Close()
+		return nil, errServerClosed
+	}
+	s.allocationManagers = append(s.allocationManagers, am)
+	s.listeners[am] = l
+
+	return func() {
+		defer close(l.done)
+
+		read()
+		s.closeAllocationManager(am)
+	}, nil
+}
+
+// managers returns the allocation managers of all listeners
+func (s *Server) managers() []*allocation.Manager {
+	s.listenersLock.RLock()
+	defer s.listenersLock.RUnlock()
+
+	return append([]*allocation.Manager{}, s.allocationManagers...)
+}
+
+// listenerState returns the state of the listener of am, nil if it was removed
+func (s *Server) listenerState(am *allocation.Manager) *listenerState {
+	s.listenersLock.RLock()
+	defer s.listenersLock.RUnlock()
+
+	return s.listeners[am]
+}
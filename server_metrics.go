@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package turn
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/pion/stun/v2"
+	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/metrics"
+)
+
+// metricsConn counts the error responses the server sends on a listener
+type metricsConn struct {
+	net.PacketConn
+	metrics *metrics.Metrics
+}
+
+func (c *metricsConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(p, addr)
+	if err == nil && isErrorResponse(p) {
+		m := &stun.Message{Raw: append([]byte{}, p...)}
+		var code stun.ErrorCodeAttribute
+		if m.Decode() == nil && code.GetFrom(m) == nil {
+			c.metrics.ErrorResponse(int(code.Code))
+		}
+	}
+
+	return n, err
+}
+
+// sendBufferConn retries the datagrams the server sends on a listener while the send buffer
+// of the socket is full, and counts those dropped anyway
+type sendBufferConn struct {
+	net.PacketConn
+	retry allocation.SendBufferRetry
+	drops *uint64
+}
+
+func (c *sendBufferConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.retry.WriteTo(c.PacketConn, p, addr)
+	if allocation.IsSendBufferFull(err) {
+		atomic.AddUint64(c.drops, 1)
+	}
+
+	return n, err
+}
+
+// isErrorResponse checks the class bits of the message type of a STUN message,
+// without decoding it, see RFC 5389 Section 6
+func isErrorResponse(p []byte) bool {
+	return stun.IsMessage(p) && p[0]&0x01 != 0 && p[1]&0x10 != 0
+}
@@ -7,18 +7,32 @@
 package turn
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/pion/logging"
+	"github.com/pion/stun/v2"
 	"github.com/pion/transport/v3/test"
 	"github.com/pion/transport/v3/vnet"
+	"github.com/pion/turn/v3/counter"
 	"github.com/pion/turn/v3/internal/allocation"
+	"github.com/pion/turn/v3/internal/client"
 	"github.com/pion/turn/v3/internal/proto"
+	"github.com/pion/turn/v3/metrics"
+	"github.com/pion/turn/v3/offload"
+	"github.com/pion/turn/v3/policy"
+	"github.com/pion/turn/v3/tracing"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestServer(t *testing.T) {
@@ -269,6 +283,7 @@ func TestServer(t *testing.T) {
 
 		err = client.CreatePermission(blackAddr)
 		assert.ErrorContains(t, err, "error", "deny permission for blacklisted peer address")
+		assert.True(t, IsForbidden(err), "denied permission is reported as 403 Forbidden")
 
 		err = client.CreatePermission(whiteAddr, whiteAddr)
 		assert.NoError(t, err, "grant permission for repeated whitelisted peer addresses")
@@ -553,6 +568,8 @@ func TestConsumeSingleTURNFrame(t *testing.T) {
 	}
 	cases := map[string]testCase{
 		"channel data":                          {data: []byte{0x40, 0x01, 0x00, 0x08, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0, 0x0}, err: nil},
+		"short channel data":                    {data: []byte{0x40, 0x01, 0x00, 0x02, 0x48, 0x69, 0x0, 0x0}, err: nil},
+		"partial short channel data":            {data: []byte{0x40, 0x01, 0x00, 0x02, 0x48, 0x69}, err: errIncompleteTURNFrame},
 		"partial data less than channel header": {data: []byte{1}, err: errIncompleteTURNFrame},
 		"partial stun message":                  {data: []byte{0x0, 0x16, 0x02, 0xDC, 0x21, 0x12, 0xA4, 0x42, 0x0, 0x0, 0x0}, err: errIncompleteTURNFrame},
 		"stun message":                          {data: []byte{0x0, 0x16, 0x00, 0x02, 0x21, 0x12, 0xA4, 0x42, 0xf7, 0x43, 0x81, 0xa3, 0xc9, 0xcd, 0x88, 0x89, 0x70, 0x58, 0xac, 0x73, 0x0, 0x0}},
@@ -570,7 +587,53 @@ func TestConsumeSingleTURNFrame(t *testing.T) {
 	}
 }
 
+func TestSTUNConnFraming(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	conn := NewSTUNConn(clientConn)
+	defer func() {
+		assert.NoError(t, conn.Close())
+	}()
+
+	binding, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	require.NoError(t, err)
+	channelData := func(n int) []byte {
+		c := &proto.ChannelData{Number: proto.MinChannelNumber, Data: make([]byte, n)}
+		c.Encode()
+		return c.Raw
+	}
+
+	// Frames are split across writes, coalesced into one, or longer than the read buffer,
+	// like the longest ChannelData, whose padding makes it longer than 65535 bytes
+	frames := [][]byte{binding.Raw, channelData(1), channelData(0), channelData(5), channelData(0xffff), binding.Raw}
+	go func() {
+		stream := []byte{}
+		for _, frame := range frames {
+			stream = append(stream, frame...)
+		}
+		for _, chunk := range [][]byte{stream[:3], stream[3:30], stream[30:]} {
+			if _, writeErr := serverConn.Write(chunk); writeErr != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 0xffff)
+	for _, frame := range frames {
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		if len(frame) > len(buf) {
+			assert.Equal(t, len(buf), n)
+			frame = frame[:len(buf)]
+		}
+		assert.Equal(t, frame, buf[:n])
+	}
+}
+
 func RunBenchmarkServer(b *testing.B, clientNum int) {
+	runBenchmarkServer(b, clientNum, false)
+}
+
+func runBenchmarkServer(b *testing.B, clientNum int, lockOSThread bool) {
 	loggerFactory := logging.NewDefaultLoggerFactory()
 	credMap := map[string][]byte{
 		"user": GenerateAuthKey("user", "pion.ly", "pass"),
@@ -603,6 +666,7 @@ func RunBenchmarkServer(b *testing.B, clientNum int) {
 				RelayAddress: net.ParseIP("127.0.0.1"),
 				Address:      "0.0.0.0",
 			},
+			LockOSThread: lockOSThread,
 		}},
 		Realm:         "pion.ly",
 		LoggerFactory: loggerFactory,
@@ -683,11 +747,3089 @@ func RunBenchmarkServer(b *testing.B, clientNum int) {
 	}
 }
 
-// BenchmarkServer will benchmark the server with multiple simultaneous client connections
+// BenchmarkServer will benchmark the server with multiple simultaneous client connections,
+// with the read loop of the server on a plain goroutine and locked to an OS thread
 func BenchmarkServer(b *testing.B) {
 	for i := 1; i <= 4; i++ {
 		b.Run(fmt.Sprintf("client_num_%d", i), func(b *testing.B) {
 			RunBenchmarkServer(b, i)
 		})
+		b.Run(fmt.Sprintf("client_num_%d_locked", i), func(b *testing.B) {
+			runBenchmarkServer(b, i, true)
+		})
+	}
+}
+
+func TestServerTCPAllocation(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	serverAddr := tcpListener.Addr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener: tcpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+				TCPAllocations: true,
+			},
+		},
+		Realm:         "pion.ly",
+		LoggerFactory: loggerFactory,
+	})
+	require.NoError(t, err)
+
+	controlConn, err := net.Dial("tcp4", serverAddr)
+	require.NoError(t, err)
+
+	turnClient, err := NewClient(&ClientConfig{
+		Conn:           NewSTUNConn(controlConn),
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       "user",
+		Password:       "pass",
+		LoggerFactory:  loggerFactory,
+	})
+	require.NoError(t, err)
+	require.NoError(t, turnClient.Listen())
+
+	alloc, err := turnClient.AllocateTCP()
+	require.NoError(t, err)
+
+	peerListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	peerAddr := peerListener.Addr().(*net.TCPAddr) //nolint:forcetypeassert
+
+	t.Run("Connect", func(t *testing.T) {
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, acceptErr := peerListener.Accept()
+			assert.NoError(t, acceptErr)
+			accepted <- conn
+		}()
+
+		dataConn, err := net.Dial("tcp4", serverAddr)
+		require.NoError(t, err)
+
+		relayConn, err := alloc.DialWithConn(dataConn, "tcp4", peerAddr.String())
+		require.NoError(t, err)
+
+		peerConn := <-accepted
+
+		_, err = relayConn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(peerConn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+
+		_, err = peerConn.Write([]byte("pong"))
+		require.NoError(t, err)
+
+		_, err = io.ReadFull(relayConn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(buf))
+
+		// A second connection to the same peer is rejected with 446
+		_, err = alloc.Connect(peerAddr)
+		assert.True(t, HasErrorCode(err, CodeConnectionAlreadyExists), "unexpected error %v", err)
+
+		// Closing the peer side tears down the data connection
+		assert.NoError(t, peerConn.Close())
+		_, err = relayConn.Read(buf)
+		assert.Error(t, err)
+		assert.NoError(t, relayConn.Close())
+	})
+
+	t.Run("DialTCP", func(t *testing.T) {
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, acceptErr := peerListener.Accept()
+			assert.NoError(t, acceptErr)
+			accepted <- conn
+		}()
+
+		relayConn, err := turnClient.DialTCP(peerAddr)
+		require.NoError(t, err)
+
+		peerConn := <-accepted
+
+		_, err = relayConn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(peerConn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+
+		assert.NoError(t, relayConn.Close())
+		assert.NoError(t, peerConn.Close())
+	})
+
+	t.Run("ConnectionAttempt", func(t *testing.T) {
+		// Peers can only connect once a permission is installed
+		require.NoError(t, alloc.CreatePermissions(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}))
+
+		peerConn, err := net.Dial("tcp4", alloc.Addr().String())
+		require.NoError(t, err)
+
+		dataConn, err := net.Dial("tcp4", serverAddr)
+		require.NoError(t, err)
+
+		relayConn, err := alloc.AcceptTCPWithConn(dataConn)
+		require.NoError(t, err)
+
+		_, err = peerConn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(relayConn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf))
+
+		assert.NoError(t, relayConn.Close())
+		assert.NoError(t, peerConn.Close())
+	})
+
+	t.Run("ConnectionBindUnknownID", func(t *testing.T) {
+		dataConn, err := net.Dial("tcp4", serverAddr)
+		require.NoError(t, err)
+
+		err = alloc.BindConnection(&client.TCPConn{TCPConn: dataConn.(*net.TCPConn)}, 1) //nolint:forcetypeassert
+		assert.True(t, HasErrorCode(err, CodeBadRequest), "unexpected error %v", err)
+		assert.NoError(t, dataConn.Close())
+	})
+
+	assert.NoError(t, peerListener.Close())
+	assert.NoError(t, alloc.Close())
+	turnClient.Close()
+	assert.NoError(t, controlConn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerIPv6Allocation(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	loggerFactory := logging.NewDefaultLoggerFactory()
+
+	newServer := func(relayAddress string) (*Server, string) {
+		udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		server, err := NewServer(ServerConfig{
+			AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+				return GenerateAuthKey(username, realm, "pass"), true
+			},
+			PacketConnConfigs: []PacketConnConfig{
+				{
+					PacketConn: udpListener,
+					RelayAddressGenerator: &RelayAddressGeneratorStatic{
+						RelayAddress: net.ParseIP(relayAddress),
+						Address:      relayAddress,
+					},
+				},
+			},
+			Realm:         "pion.ly",
+			LoggerFactory: loggerFactory,
+		})
+		require.NoError(t, err)
+
+		return server, udpListener.LocalAddr().String()
+	}
+
+	newClient := func(serverAddr string) (*Client, net.PacketConn) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		turnClient, err := NewClient(&ClientConfig{
+			Conn:                   conn,
+			STUNServerAddr:         serverAddr,
+			TURNServerAddr:         serverAddr,
+			Username:               "user",
+			Password:               "pass",
+			LoggerFactory:          loggerFactory,
+			RequestedAddressFamily: RequestedAddressFamilyIPv6,
+		})
+		require.NoError(t, err)
+		require.NoError(t, turnClient.Listen())
+
+		return turnClient, conn
+	}
+
+	t.Run("Relay", func(t *testing.T) {
+		server, serverAddr := newServer("::1")
+		turnClient, conn := newClient(serverAddr)
+
+		relayConn, err := turnClient.Allocate()
+		require.NoError(t, err)
+
+		relayAddr := relayConn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+		assert.True(t, relayAddr.IP.Equal(net.IPv6loopback), "unexpected relayed address %s", relayAddr)
+
+		peer, err := net.ListenPacket("udp6", "[::1]:0")
+		require.NoError(t, err)
+
+		_, err = relayConn.WriteTo([]byte("ping"), peer.LocalAddr())
+		require.NoError(t, err)
+
+		buf := make([]byte, 64)
+		n, from, err := peer.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf[:n]))
+
+		_, err = peer.WriteTo([]byte("pong"), from)
+		require.NoError(t, err)
+
+		n, _, err = relayConn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "pong", string(buf[:n]))
+
+		err = turnClient.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000})
+		assert.True(t, HasErrorCode(err, CodePeerAddressFamilyMismatch), "unexpected error %v", err)
+
+		assert.NoError(t, peer.Close())
+		assert.NoError(t, relayConn.Close())
+		turnClient.Close()
+		assert.NoError(t, conn.Close())
+		assert.NoError(t, server.Close())
+	})
+
+	t.Run("AddressFamilyNotSupported", func(t *testing.T) {
+		server, serverAddr := newServer("127.0.0.1")
+		turnClient, conn := newClient(serverAddr)
+
+		_, err := turnClient.Allocate()
+		assert.True(t, HasErrorCode(err, CodeAddressFamilyNotSupported), "unexpected error %v", err)
+
+		turnClient.Close()
+		assert.NoError(t, conn.Close())
+		assert.NoError(t, server.Close())
+	})
+}
+
+func TestServerConfigPermissionTimeout(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer func() {
+		assert.NoError(t, udpListener.Close())
+	}()
+
+	for timeout, valid := range map[time.Duration]bool{
+		0:                true,
+		time.Second:      true,
+		30 * time.Minute: true,
+		time.Hour:        true,
+		-time.Second:     false,
+		time.Millisecond: false,
+		2 * time.Hour:    false,
+	} {
+		err := (&ServerConfig{
+			PacketConnConfigs: []PacketConnConfig{
+				{
+					PacketConn:            udpListener,
+					RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"},
+				},
+			},
+			PermissionTimeout: timeout,
+		}).validate()
+		if valid {
+			assert.NoError(t, err, timeout)
+		} else {
+			assert.ErrorIs(t, err, errPermissionTimeoutInvalid, timeout)
+		}
+	}
+}
+
+func TestServerAllocationStats(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	relayAddressGenerator := &RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP("127.0.0.1"),
+		Address:      "127.0.0.1",
+	}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn:            udpListener,
+				RelayAddressGenerator: relayAddressGenerator,
+				Name:                  "media",
+			},
+		},
+		ListenerConfigs: []ListenerConfig{
+			{
+				Listener:              tcpListener,
+				RelayAddressGenerator: relayAddressGenerator,
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, server.AllocationStats())
+
+	allocate := func(conn net.PacketConn, serverAddr string) (*Client, net.PacketConn) {
+		client, clientErr := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       "user",
+			Password:       "pass",
+		})
+		require.NoError(t, clientErr)
+		require.NoError(t, client.Listen())
+
+		relayConn, clientErr := client.Allocate()
+		require.NoError(t, clientErr)
+
+		return client, relayConn
+	}
+
+	udpConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	udpClient, udpRelayConn := allocate(udpConn, udpListener.LocalAddr().String())
+
+	tcpConn, err := net.Dial("tcp4", tcpListener.Addr().String())
+	require.NoError(t, err)
+	tcpClient, tcpRelayConn := allocate(NewSTUNConn(tcpConn), tcpListener.Addr().String())
+
+	assert.ElementsMatch(t, []AllocationStats{
+		{
+			Listener:        "media",
+			ClientTransport: ClientTransportUDP,
+			RelayProtocol:   RelayProtocolUDP,
+			AddressFamily:   RequestedAddressFamilyIPv4,
+			Allocations:     1,
+		},
+		{
+			Listener:        tcpListener.Addr().String(),
+			ClientTransport: ClientTransportTCP,
+			RelayProtocol:   RelayProtocolUDP,
+			AddressFamily:   RequestedAddressFamilyIPv4,
+			Allocations:     1,
+		},
+	}, server.AllocationStats())
+	assert.Equal(t, "TCP", ClientTransportTCP.String())
+
+	assert.NoError(t, udpRelayConn.Close())
+	assert.NoError(t, tcpRelayConn.Close())
+	udpClient.Close()
+	tcpClient.Close()
+	assert.NoError(t, udpConn.Close())
+	assert.NoError(t, tcpConn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerUserQuota(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:     "pion.ly",
+		UserQuota: 1,
+		QuotaHandler: func(username, realm string, srcAddr net.Addr) bool {
+			return username != "blocked"
+		},
+	})
+	require.NoError(t, err)
+
+	allocate := func(username string) (net.PacketConn, func(), error) {
+		conn, connErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, connErr)
+
+		client, connErr := NewClient(&ClientConfig{
+			STUNServerAddr: udpListener.LocalAddr().String(),
+			TURNServerAddr: udpListener.LocalAddr().String(),
+			Conn:           conn,
+			Username:       username,
+			Password:       "pass",
+		})
+		require.NoError(t, connErr)
+		require.NoError(t, client.Listen())
+
+		relayConn, allocateErr := client.Allocate()
+		return relayConn, func() {
+			if relayConn != nil {
+				assert.NoError(t, relayConn.Close())
+			}
+			client.Close()
+			assert.NoError(t, conn.Close())
+		}, allocateErr
+	}
+
+	_, closeFirst, err := allocate("user")
+	assert.NoError(t, err)
+
+	// The quota of user is exhausted, other users are not affected
+	_, closeSecond, err := allocate("user")
+	assert.True(t, IsAllocationQuotaReached(err), err)
+	_, closeOther, err := allocate("other")
+	assert.NoError(t, err)
+
+	_, closeBlocked, err := allocate("blocked")
+	assert.True(t, IsAllocationQuotaReached(err), err)
+
+	// Closing the first allocation frees the quota
+	closeFirst()
+	_, closeThird, err := allocate("user")
+	assert.NoError(t, err)
+
+	closeSecond()
+	closeOther()
+	closeBlocked()
+	closeThird()
+	assert.NoError(t, server.Close())
+}
+
+func TestServerClusterUserQuota(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// Two servers sharing their counters enforce a single quota
+	counters := counter.NewMemory()
+	newServer := func() (*Server, string) {
+		udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		server, err := NewServer(ServerConfig{
+			AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+				return GenerateAuthKey(username, realm, "pass"), true
+			},
+			PacketConnConfigs: []PacketConnConfig{
+				{
+					PacketConn: udpListener,
+					RelayAddressGenerator: &RelayAddressGeneratorStatic{
+						RelayAddress: net.ParseIP("127.0.0.1"),
+						Address:      "127.0.0.1",
+					},
+				},
+			},
+			Realm:     "pion.ly",
+			UserQuota: 1,
+			Counters:  counters,
+		})
+		require.NoError(t, err)
+
+		return server, udpListener.LocalAddr().String()
+	}
+	serverA, addrA := newServer()
+	serverB, addrB := newServer()
+
+	allocate := func(serverAddr string) (func(), error) {
+		conn, connErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, connErr)
+
+		client, connErr := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       "user",
+			Password:       "pass",
+		})
+		require.NoError(t, connErr)
+		require.NoError(t, client.Listen())
+
+		relayConn, allocateErr := client.Allocate()
+		return func() {
+			if relayConn != nil {
+				assert.NoError(t, relayConn.Close())
+			}
+			client.Close()
+			assert.NoError(t, conn.Close())
+		}, allocateErr
 	}
+
+	closeFirst, err := allocate(addrA)
+	assert.NoError(t, err)
+
+	closeSecond, err := allocate(addrB)
+	assert.True(t, IsAllocationQuotaReached(err), err)
+
+	// Deleting the allocation on the first server frees the quota on the second
+	require.Len(t, serverA.Allocations(), 1)
+	assert.NoError(t, serverA.DeleteAllocation(serverA.Allocations()[0].Key))
+	closeThird, err := allocate(addrB)
+	assert.NoError(t, err)
+
+	closeFirst()
+	closeSecond()
+	closeThird()
+	assert.NoError(t, serverA.Close())
+	assert.NoError(t, serverB.Close())
+}
+
+func TestServerAllowedPeerPorts(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn:            udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"},
+			},
+		},
+		AllowedPeerPorts: []PeerPortRange{{Min: 2000, Max: 1000}},
+	})
+	assert.ErrorIs(t, err, errPeerPortRangeInvalid)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn:            udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"},
+			},
+		},
+		Realm:            "pion.ly",
+		AllowedPeerPorts: []PeerPortRange{{Min: 1024, Max: 65535}},
+		LoggerFactory:    logging.NewDefaultLoggerFactory(),
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	assert.Error(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}))
+	assert.NoError(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}))
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerMetrics(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	m := metrics.New()
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), username == "user"
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:   "pion.ly",
+		Metrics: m,
+	})
+	require.NoError(t, err)
+
+	allocate := func(username string) (*Client, net.PacketConn, net.PacketConn, error) {
+		conn, connErr := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, connErr)
+
+		client, connErr := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       username,
+			Password:       "pass",
+		})
+		require.NoError(t, connErr)
+		require.NoError(t, client.Listen())
+
+		relayConn, allocateErr := client.Allocate()
+		return client, conn, relayConn, allocateErr
+	}
+
+	client, conn, relayConn, err := allocate("user")
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// The first write binds a channel, so both Send indications and ChannelData are counted
+	for i := 0; i < 2; i++ {
+		_, err = relayConn.WriteTo([]byte("ping"), peer.LocalAddr())
+		assert.NoError(t, err)
+
+		buf := make([]byte, 1500)
+		n, from, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, "ping", string(buf[:n]))
+
+		_, err = peer.WriteTo([]byte("pong"), from)
+		assert.NoError(t, err)
+		n, _, readErr = relayConn.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, "pong", string(buf[:n]))
+	}
+
+	rejectedClient, rejectedConn, _, err := allocate("unknown")
+	assert.Error(t, err)
+	rejectedClient.Close()
+	assert.NoError(t, rejectedConn.Close())
+
+	s := m.Snapshot()
+	assert.Equal(t, uint64(1), s.AllocationsCreated)
+	assert.Equal(t, int64(1), s.AllocationsActive)
+	assert.Equal(t, [2]uint64{8, 8}, s.RelayedBytes)
+	assert.Equal(t, [2]uint64{2, 2}, s.RelayedPackets)
+	assert.NotZero(t, s.RelayedIndications[metrics.DirectionToPeer])
+	assert.Equal(t, uint64(1), s.AuthFailures)
+	assert.Equal(t, uint64(1), s.ChannelBinds)
+	assert.NotZero(t, s.ErrorResponses[int(stun.CodeUnauthorized)])
+	assert.NotZero(t, s.ErrorResponses[int(stun.CodeBadRequest)])
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+
+	// Allocations are closed once the read loop of the listener returns
+	assert.Eventually(t, func() bool {
+		return m.Snapshot().AllocationsActive == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+type testTracer struct {
+	lock  sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	tracer     *testTracer
+	name       string
+	attributes map[string]interface{}
+	ended      bool
+}
+
+func (t *testTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	span := &testSpan{tracer: t, name: name, attributes: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func (s *testSpan) SetAttribute(key string, value interface{}) {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+
+	s.attributes[key] = value
+}
+
+func (s *testSpan) End() {
+	s.tracer.lock.Lock()
+	defer s.tracer.lock.Unlock()
+
+	s.ended = true
+}
+
+// results returns the result codes of the ended spans named name
+func (t *testTracer) results(name string) []interface{} {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	results := []interface{}{}
+	for _, span := range t.spans {
+		if span.name == name && span.ended {
+			results = append(results, span.attributes[tracing.AttributeResultCode])
+		}
+	}
+	return results
+}
+
+func TestServerTracing(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	serverTracer := &testTracer{}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:  "pion.ly",
+		Tracer: serverTracer,
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	clientTracer := &testTracer{}
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+		Tracer:         clientTracer,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	_, err = client.SendBindingRequest()
+	assert.NoError(t, err)
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+	assert.NoError(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}))
+
+	// The first Allocate is challenged for credentials, Binding requests aren't traced
+	for _, tracer := range []*testTracer{clientTracer, serverTracer} {
+		side := "client"
+		if tracer == serverTracer {
+			side = "server"
+		}
+
+		assert.Equal(t, []interface{}{int(stun.CodeUnauthorized), 0}, tracer.results("turn."+side+".Allocate"))
+		assert.Equal(t, []interface{}{0}, tracer.results("turn."+side+".CreatePermission"))
+		assert.Empty(t, tracer.results("turn."+side+".Binding"))
+
+		tracer.lock.Lock()
+		last := tracer.spans[len(tracer.spans)-1]
+		assert.Equal(t, "CreatePermission", last.attributes[tracing.AttributeMethod])
+		assert.Equal(t, "user", last.attributes[tracing.AttributeUsername])
+		assert.Equal(t, "pion.ly", last.attributes[tracing.AttributeRealm])
+		assert.NotEmpty(t, last.attributes[tracing.AttributePeerAddr])
+		tracer.lock.Unlock()
+	}
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerFairScheduler(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:         "pion.ly",
+		FairScheduler: &FairSchedulerConfig{BytesPerSecond: 1 << 20},
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Send indications, then ChannelData once the channel is bound, in both directions
+	for i := 0; i < 2; i++ {
+		_, err = relayConn.WriteTo([]byte("ping"), peer.LocalAddr())
+		assert.NoError(t, err)
+
+		buf := make([]byte, 1500)
+		n, from, readErr := peer.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, "ping", string(buf[:n]))
+
+		_, err = peer.WriteTo([]byte("pong"), from)
+		assert.NoError(t, err)
+		n, _, readErr = relayConn.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, "pong", string(buf[:n]))
+	}
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerLockOSThread(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	packetConnConfig := PacketConnConfig{
+		PacketConn:            udpListener,
+		RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"},
+		CPUs:                  []int{-1},
+	}
+	_, err = NewServer(ServerConfig{PacketConnConfigs: []PacketConnConfig{packetConnConfig}})
+	if runtime.GOOS == "linux" {
+		assert.ErrorIs(t, err, errCPUInvalid)
+		packetConnConfig.CPUs = []int{0}
+	} else {
+		assert.ErrorIs(t, err, errCPUAffinityUnsupported)
+		packetConnConfig.CPUs, packetConnConfig.LockOSThread = nil, true
+	}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{packetConnConfig},
+		Realm:             "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerReusePort(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	template := PacketConnConfig{
+		RelayAddressGenerator: &RelayAddressGeneratorStatic{
+			RelayAddress: net.ParseIP("127.0.0.1"),
+			Address:      "127.0.0.1",
+		},
+	}
+
+	packetConnConfigs, err := ReusePortPacketConnConfigs("udp4", "127.0.0.1:0", 4, template)
+	if runtime.GOOS != "linux" {
+		assert.ErrorIs(t, err, errReusePortUnsupported)
+		return
+	}
+	require.NoError(t, err)
+	require.Len(t, packetConnConfigs, 4)
+
+	_, err = ReusePortPacketConnConfigs("udp4", "127.0.0.1:0", -1, template)
+	assert.ErrorIs(t, err, errShardsInvalid)
+
+	// The shards share the port the first one was bound to
+	serverAddr := packetConnConfigs[0].PacketConn.LocalAddr().String()
+	for i, cfg := range packetConnConfigs {
+		assert.Equal(t, serverAddr, cfg.PacketConn.LocalAddr().String())
+		assert.Equal(t, fmt.Sprintf("%s#%d", serverAddr, i), cfg.Name)
+	}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: packetConnConfigs,
+		Realm:             "pion.ly",
+	})
+	require.NoError(t, err)
+
+	// Every client is served by one shard, which holds its allocation
+	const clients = 8
+	closers := []func(){}
+	for i := 0; i < clients; i++ {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       "user",
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		relayConn, err := client.Allocate()
+		require.NoError(t, err)
+		require.NoError(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}))
+
+		closers = append(closers, func() {
+			assert.NoError(t, relayConn.Close())
+			client.Close()
+			assert.NoError(t, conn.Close())
+		})
+	}
+
+	assert.Equal(t, clients, server.AllocationCount())
+	allocations := 0
+	for _, stats := range server.AllocationStats() {
+		assert.Contains(t, stats.Listener, serverAddr+"#")
+		allocations += stats.Allocations
+	}
+	assert.Equal(t, clients, allocations)
+
+	for _, closer := range closers {
+		closer()
+	}
+	assert.NoError(t, server.Close())
+}
+
+func TestServerRelayBufferSize(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs: []PacketConnConfig{{PacketConn: udpListener, RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"}}},
+		RelayBufferSize:   -1,
+	})
+	assert.ErrorIs(t, err, errRelayBufferSizeInvalid)
+
+	// The buffers of the pool are shared by the relays and the scheduler
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:           "pion.ly",
+		RelayBufferSize: 200,
+		FairScheduler:   &FairSchedulerConfig{},
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: udpListener.LocalAddr().String(),
+		TURNServerAddr: udpListener.LocalAddr().String(),
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Sending to the peer creates the permission
+	_, err = relayConn.WriteTo([]byte("hello"), peer.LocalAddr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 1500)
+	_, _, err = peer.ReadFrom(buf)
+	require.NoError(t, err)
+
+	// Datagrams up to RelayBufferSize are relayed whole, larger ones are truncated
+	for size, relayed := range map[int]int{100: 100, 200: 200, 300: 200} {
+		_, err = peer.WriteTo(make([]byte, size), relayConn.LocalAddr())
+		require.NoError(t, err)
+
+		n, _, err := relayConn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, relayed, n)
+	}
+
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+type recordingOffloader struct {
+	lock   sync.Mutex
+	routes map[offload.Route]bool
+	events []string
+}
+
+func (o *recordingOffloader) Add(route offload.Route) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.routes[route] = true
+	o.events = append(o.events, "add "+route.String())
+	return nil
+}
+
+func (o *recordingOffloader) Remove(route offload.Route) error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	delete(o.routes, route)
+	o.events = append(o.events, "remove "+route.String())
+	return nil
+}
+
+func (o *recordingOffloader) recorded() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return append([]string{}, o.events...)
+}
+
+func TestServerChannelOffload(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+
+	offloader := &recordingOffloader{routes: map[offload.Route]bool{}}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:          "pion.ly",
+		ChannelOffload: offloader,
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr.String(),
+		TURNServerAddr: serverAddr.String(),
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peerConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// Sending to the peer binds a channel, which is offloaded
+	_, err = relayConn.WriteTo([]byte("data"), peerConn.LocalAddr())
+	assert.NoError(t, err)
+	_, _, err = peerConn.ReadFrom(make([]byte, 1500))
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(offloader.recorded()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	route := offload.Route{}
+	offloader.lock.Lock()
+	for r := range offloader.routes {
+		route = r
+	}
+	offloader.lock.Unlock()
+	assert.Equal(t, conn.LocalAddr().String(), route.Client.String())
+	assert.Equal(t, serverAddr.String(), route.Server.String())
+	assert.Equal(t, relayConn.LocalAddr().(*net.UDPAddr).Port, route.Relay.Port) //nolint:forcetypeassert
+	assert.Equal(t, peerConn.LocalAddr().String(), route.Peer.String())
+	assert.Equal(t, uint16(proto.MinChannelNumber), route.Channel)
+
+	// Deleting the allocation removes the route
+	assert.NoError(t, relayConn.Close())
+	assert.Eventually(t, func() bool {
+		return len(offloader.recorded()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"add " + route.String(), "remove " + route.String()}, offloader.recorded())
+
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peerConn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerLifecycleEvents(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	var lock sync.Mutex
+	events := []string{}
+	record := func(event string, info AllocationInfo) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		assert.Equal(t, "user", info.Username)
+		assert.Equal(t, serverAddr, info.FiveTuple.DstAddr.String())
+		assert.NotNil(t, info.RelayAddr)
+		events = append(events, event)
+	}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+		OnAllocationCreated: func(info AllocationInfo) {
+			record("created", info)
+		},
+		OnAllocationDeleted: func(info AllocationInfo) {
+			record("deleted", info)
+		},
+		OnPermissionCreated: func(info AllocationInfo, peer net.Addr) {
+			record("permission "+peer.String(), info)
+		},
+		OnChannelBound: func(info AllocationInfo, peer net.Addr, channel uint16) {
+			record(fmt.Sprintf("channel %d %s", channel, peer), info)
+		},
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// Refreshing the permission isn't reported
+	peer := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	assert.NoError(t, client.CreatePermission(peer))
+	assert.NoError(t, client.CreatePermission(peer))
+
+	peerConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	peerAddr := peerConn.LocalAddr().(*net.UDPAddr) //nolint:forcetypeassert
+
+	// The first write binds a channel to the peer, whose IP already has a permission
+	_, err = relayConn.WriteTo([]byte("data"), peerAddr)
+	assert.NoError(t, err)
+	_, _, err = peerConn.ReadFrom(make([]byte, 1500))
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return len(events) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, relayConn.Close())
+	assert.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+
+		return len(events) == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	assert.Equal(t, []string{
+		"created",
+		"permission 127.0.0.1:5000",
+		fmt.Sprintf("channel %d %s", proto.MinChannelNumber, peerAddr),
+		"deleted",
+	}, events)
+	lock.Unlock()
+
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peerConn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerAllocationsAdmin(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	_, err = relayConn.WriteTo([]byte("ping"), peer.LocalAddr())
+	assert.NoError(t, err)
+	buf := make([]byte, 1500)
+	_, from, err := peer.ReadFrom(buf)
+	require.NoError(t, err)
+	_, err = peer.WriteTo([]byte("pong!"), from)
+	assert.NoError(t, err)
+	_, _, err = relayConn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	allocations := server.Allocations()
+	require.Len(t, allocations, 1)
+	snapshot := allocations[0]
+	assert.Equal(t, "user", snapshot.Username)
+	assert.Equal(t, conn.LocalAddr().String(), snapshot.FiveTuple.SrcAddr.String())
+	assert.Equal(t, relayConn.LocalAddr().String(), snapshot.RelayAddr.String())
+	assert.InDelta(t, proto.DefaultLifetime, snapshot.Lifetime, float64(time.Minute))
+	assert.Equal(t, uint64(4), snapshot.BytesToPeer)
+	assert.Equal(t, uint64(5), snapshot.BytesToClient)
+	assert.Equal(t, uint64(1), snapshot.PacketsToPeer)
+	assert.Equal(t, uint64(1), snapshot.PacketsToClient)
+
+	assert.ErrorIs(t, server.DeleteAllocation("unknown"), ErrAllocationNotFound)
+	assert.NoError(t, server.DeleteAllocation(snapshot.Key))
+	assert.Empty(t, server.Allocations())
+	assert.ErrorIs(t, server.DeleteAllocation(snapshot.Key), ErrAllocationNotFound)
+
+	// The client can't refresh the deleted allocation
+	assert.Error(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}))
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerRebalanceAllocations(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	turnClient, err := NewClient(&ClientConfig{
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, turnClient.Listen())
+
+	relayConn, err := turnClient.Allocate()
+	require.NoError(t, err)
+
+	allocations := server.Allocations()
+	require.Len(t, allocations, 1)
+	key := allocations[0].Key
+
+	alternate := &net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 3478}
+	_, err = server.RebalanceAllocations([]string{key}, &net.IPAddr{IP: alternate.IP}, time.Now().Add(time.Minute))
+	assert.Error(t, err)
+	count, err := server.RebalanceAllocations([]string{key, "unknown"}, alternate, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// The current lifetime is shortened to the deadline
+	assert.LessOrEqual(t, server.Allocations()[0].Lifetime, time.Minute)
+
+	// The next Refresh is redirected, later ones are granted until the deadline
+	udpConn, ok := relayConn.(*client.UDPConn)
+	require.True(t, ok)
+	assert.Error(t, udpConn.Refresh())
+	assert.NoError(t, udpConn.Refresh())
+	assert.LessOrEqual(t, udpConn.GrantedLifetime(), time.Minute)
+
+	assert.Equal(t, 1, server.CancelRebalance(key, "unknown"))
+	assert.Equal(t, 0, server.CancelRebalance(key))
+	assert.NoError(t, udpConn.Refresh())
+
+	// A deadline that passed releases the allocation right away
+	count, err = server.RebalanceAllocations([]string{key}, nil, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Empty(t, server.Allocations())
+
+	assert.NoError(t, relayConn.Close())
+	turnClient.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerAnomalyStats(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				Name:       "edge",
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, server.AnomalyStats())
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	// A Binding request without FINGERPRINT is still answered
+	m, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	require.NoError(t, err)
+	_, err = conn.WriteTo(m.Raw, udpListener.LocalAddr())
+	require.NoError(t, err)
+	buf := make([]byte, 1500)
+	_, _, err = conn.ReadFrom(buf)
+	require.NoError(t, err)
+
+	assert.Equal(t, []AnomalyStats{
+		{Listener: "edge", Anomaly: AnomalyMissingFingerprint, Count: 1},
+	}, server.AnomalyStats())
+
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerPolicy(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	engine, err := policy.NewEngine(`username == "user" && (action != "permission" || peer_port != 6000)`)
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:  "pion.ly",
+		Policy: engine,
+	})
+	require.NoError(t, err)
+
+	newClient := func(username string) (*Client, net.PacketConn) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       username,
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		return client, conn
+	}
+
+	client, conn := newClient("user")
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	assert.NoError(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}))
+	assert.Error(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 6000}))
+
+	other, otherConn := newClient("other")
+	_, err = other.Allocate()
+	assert.Error(t, err)
+
+	// Reloaded policies apply to the next request
+	require.NoError(t, engine.Load(`true`))
+	otherRelayConn, err := other.Allocate()
+	assert.NoError(t, err)
+
+	assert.NoError(t, otherRelayConn.Close())
+	assert.NoError(t, relayConn.Close())
+	other.Close()
+	client.Close()
+	assert.NoError(t, otherConn.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerUpdateAuthConfig(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	users := func(passwords map[string]string, realms chan string) AuthHandler {
+		return func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			password, ok := passwords[username]
+			if realms != nil {
+				realms <- realm
+			}
+			return GenerateAuthKey(username, realm, password), ok
+		}
+	}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: users(map[string]string{"user": "pass"}, nil),
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	newClient := func(username, password string) (*Client, net.PacketConn) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       username,
+			Password:       password,
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		return client, conn
+	}
+
+	client, conn := newClient("user", "pass")
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	realms := make(chan string, 16)
+	server.UpdateAuthConfig(AuthConfig{
+		Realm:       "example.org",
+		AuthHandler: users(map[string]string{"user": "pass", "new": "secret"}, realms),
+	})
+
+	// The allocation survives and keeps authenticating with the realm it learned
+	assert.NoError(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}))
+	assert.Equal(t, "pion.ly", <-realms)
+
+	// New clients learn the new realm and are authenticated by the new AuthHandler
+	newUser, newConn := newClient("new", "secret")
+	newRelayConn, err := newUser.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, "example.org", <-realms)
+
+	assert.NoError(t, newRelayConn.Close())
+	assert.NoError(t, relayConn.Close())
+	newUser.Close()
+	client.Close()
+	assert.NoError(t, newConn.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerRealms(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	var lock sync.Mutex
+	realms := map[string][]string{}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			lock.Lock()
+			realms[username] = append(realms[username], realm)
+			lock.Unlock()
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+				Realm: "listener.pion.ly",
+			},
+		},
+		Realm:  "pion.ly",
+		Realms: []RealmConfig{{UsernameSuffix: "@example.com", Realm: "example.com"}},
+	})
+	require.NoError(t, err)
+
+	allocate := func(username string) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       username,
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		relayConn, err := client.Allocate()
+		require.NoError(t, err)
+
+		assert.NoError(t, relayConn.Close())
+		client.Close()
+		assert.NoError(t, conn.Close())
+	}
+
+	// The realm of the listener replaces ServerConfig.Realm
+	allocate("alice")
+
+	// The client is challenged again with the realm selected by the username suffix
+	allocate("bob@example.com")
+
+	assert.NoError(t, server.Close())
+
+	lock.Lock()
+	assert.Subset(t, []string{"listener.pion.ly"}, realms["alice"])
+	assert.Subset(t, []string{"example.com"}, realms["bob@example.com"])
+	assert.NotEmpty(t, realms["alice"])
+	assert.NotEmpty(t, realms["bob@example.com"])
+	lock.Unlock()
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs: []PacketConnConfig{{PacketConn: udpListener, RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"}}},
+		Realms:            []RealmConfig{{UsernameSuffix: "@example.com"}},
+	})
+	assert.ErrorIs(t, err, errRealmConfigInvalid)
+}
+
+// staleNonces is a NonceGenerator whose nonces are stale after their first use
+type staleNonces struct {
+	lock  sync.Mutex
+	next  int
+	valid map[string]bool
+}
+
+func (s *staleNonces) Generate() (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.next++
+	nonce := fmt.Sprint(s.next)
+	s.valid[nonce] = true
+	return nonce, nil
+}
+
+func (s *staleNonces) Validate(nonce string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.valid[nonce] {
+		return fmt.Errorf("stale nonce %q", nonce) //nolint:goerr113
+	}
+	delete(s.valid, nonce)
+	return nil
+}
+
+func TestServerNonceGenerator(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	serverMetrics := metrics.New()
+	storms := make(chan StaleNonceStorm, 1)
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:          "pion.ly",
+		NonceGenerator: &staleNonces{valid: map[string]bool{}},
+		Metrics:        serverMetrics,
+		StaleNonceWatchdog: &StaleNonceWatchdogConfig{
+			MaxStaleNonces:    1,
+			OnStaleNonceStorm: func(storm StaleNonceStorm) { storms <- storm },
+		},
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// The nonce learned with the allocation is stale, the client is challenged with a
+	// new one that is accepted once, and retries with it
+	peer := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	assert.NoError(t, client.CreatePermission(peer))
+	assert.Equal(t, uint64(1), serverMetrics.Snapshot().StaleNonces)
+
+	// The second stale nonce of the client crosses the limit of the watchdog
+	assert.NoError(t, client.CreatePermission(peer))
+	select {
+	case storm := <-storms:
+		assert.Equal(t, 2, storm.StaleNonces)
+		assert.Equal(t, map[string]int{conn.LocalAddr().String(): 2}, storm.Clients)
+		assert.Equal(t, time.Minute, storm.Window)
+		assert.True(t, storm.LastRotation.IsZero())
+	default:
+		assert.Fail(t, "stale nonce storm not reported")
+	}
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs: []PacketConnConfig{{PacketConn: udpListener, RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"}}},
+		NonceLifetime:     time.Millisecond,
+	})
+	assert.ErrorIs(t, err, errNonceLifetimeInvalid)
+}
+
+func TestServerChallengeCache(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	nonces := &staleNonces{valid: map[string]bool{}}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:             "pion.ly",
+		NonceGenerator:    nonces,
+		ChallengeCacheTTL: 5 * time.Second,
+	})
+	require.NoError(t, err)
+
+	// Clients of the same IP are challenged with the same nonce, which is accepted
+	// without validating it again
+	for i := 0; i < 2; i++ {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       "user",
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		relayConn, err := client.Allocate()
+		require.NoError(t, err)
+
+		assert.NoError(t, relayConn.Close())
+		client.Close()
+		assert.NoError(t, conn.Close())
+	}
+
+	nonces.lock.Lock()
+	assert.Equal(t, 16, nonces.next) // One batch of nonces
+	nonces.lock.Unlock()
+
+	assert.NoError(t, server.Close())
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs: []PacketConnConfig{{PacketConn: udpListener, RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"}}},
+		NonceLifetime:     time.Minute,
+		ChallengeCacheTTL: time.Minute,
+	})
+	assert.ErrorIs(t, err, errChallengeCacheTTLInvalid)
+}
+
+func TestServerCacheCredentials(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	var calls int32
+	authHandler := func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+		atomic.AddInt32(&calls, 1)
+		return GenerateAuthKey(username, realm, "pass"), true
+	}
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: authHandler,
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:            "pion.ly",
+		CacheCredentials: true,
+	})
+	require.NoError(t, err)
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// The key is cached with the allocation by the first request after the Allocate
+	peer := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}
+	require.NoError(t, client.CreatePermission(peer))
+	require.NoError(t, client.CreatePermission(peer))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// Replacing the AuthHandler drops the cached keys
+	server.UpdateAuthConfig(AuthConfig{Realm: "pion.ly", AuthHandler: authHandler})
+	require.NoError(t, client.CreatePermission(peer))
+	require.NoError(t, client.CreatePermission(peer))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerAuthHandlerV2(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	_, network, err := net.ParseCIDR("127.0.0.0/24")
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	methods := []stun.Method{}
+	server, err := NewServer(ServerConfig{
+		AuthHandlerV2: func(ctx context.Context, req AuthRequest) ([]byte, SessionPolicy, bool) {
+			assert.NoError(t, ctx.Err())
+			lock.Lock()
+			methods = append(methods, req.Message.Type.Method)
+			lock.Unlock()
+
+			return GenerateAuthKey(req.Username, req.Realm, "pass"), SessionPolicy{
+				MaxLifetime:  time.Minute,
+				Quota:        1,
+				AllowedPeers: []*net.IPNet{network},
+			}, req.Username == "user"
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	newClient := func() (*Client, net.PacketConn) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       "user",
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		return client, conn
+	}
+
+	client, conn := newClient()
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// Permissions are only created for AllowedPeers
+	assert.NoError(t, client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("127.0.0.2"), Port: 5000}))
+	assert.True(t, IsForbidden(client.CreatePermission(&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5000})))
+
+	// The Quota of the user replaces the unlimited UserQuota
+	other, otherConn := newClient()
+	_, err = other.Allocate()
+	assert.True(t, IsAllocationQuotaReached(err))
+
+	lock.Lock()
+	assert.Equal(t, stun.MethodAllocate, methods[0])
+	assert.Contains(t, methods, stun.MethodCreatePermission)
+	lock.Unlock()
+
+	assert.NoError(t, relayConn.Close())
+	other.Close()
+	client.Close()
+	assert.NoError(t, otherConn.Close())
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestRelayAddressGeneratorInterface(t *testing.T) {
+	assert.ErrorIs(t, (&RelayAddressGeneratorInterface{}).Validate(), errInterfaceUnset)
+
+	loopback := ""
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+	if loopback == "" {
+		t.Skip("no loopback interface")
+	}
+
+	generator := &RelayAddressGeneratorInterface{Interface: loopback}
+	require.NoError(t, generator.Validate())
+
+	conn, relayAddr, err := generator.AllocatePacketConn("udp4", 0)
+	if err != nil {
+		t.Skipf("can't bind to %s: %s", loopback, err)
+	}
+	udpAddr, ok := relayAddr.(*net.UDPAddr)
+	require.True(t, ok)
+	assert.True(t, udpAddr.IP.Equal(net.ParseIP("127.0.0.1")))
+	assert.NotZero(t, udpAddr.Port)
+	assert.NoError(t, conn.Close())
+
+	listener, relayAddr, err := generator.AllocateListener("tcp4", 0)
+	require.NoError(t, err)
+	tcpAddr, ok := relayAddr.(*net.TCPAddr)
+	require.True(t, ok)
+	assert.True(t, tcpAddr.IP.Equal(net.ParseIP("127.0.0.1")))
+	assert.NoError(t, listener.Close())
+
+	// RelayAddress replaces the address of the interface in responses
+	generator.RelayAddress = net.ParseIP("203.0.113.1")
+	conn, relayAddr, err = generator.AllocatePacketConn("udp4", 0)
+	require.NoError(t, err)
+	udpAddr, ok = relayAddr.(*net.UDPAddr)
+	require.True(t, ok)
+	assert.True(t, udpAddr.IP.Equal(generator.RelayAddress))
+	assert.NoError(t, conn.Close())
+
+	_, _, err = (&RelayAddressGeneratorInterface{Interface: "turn-test-missing", Net: generator.Net}).AllocatePacketConn("udp4", 0)
+	assert.Error(t, err)
+}
+
+func TestRelayAddressGeneratorMulti(t *testing.T) {
+	assert.ErrorIs(t, (&RelayAddressGeneratorMulti{}).Validate(), errRelayAddressesUnset)
+	assert.ErrorIs(t, (&RelayAddressGeneratorMulti{Addresses: []RelayAddressPair{
+		{RelayAddress: net.ParseIP("127.0.0.1"), Address: "127.0.0.1", MinPort: 5000},
+	}}).Validate(), errRelayPortRangeInvalid)
+
+	// A port that is free, for an address with a range of one port
+	free, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	port := uint16(free.LocalAddr().(*net.UDPAddr).Port) //nolint:forcetypeassert
+	require.NoError(t, free.Close())
+
+	generator := &RelayAddressGeneratorMulti{Addresses: []RelayAddressPair{
+		{RelayAddress: net.ParseIP("203.0.113.1"), Address: "127.0.0.1"},
+		{RelayAddress: net.ParseIP("203.0.113.2"), Address: "127.0.0.1", MinPort: port, MaxPort: port},
+	}}
+	require.NoError(t, generator.Validate())
+
+	allocate := func() (net.PacketConn, *net.UDPAddr) {
+		conn, relayAddr, allocErr := generator.AllocatePacketConn("udp4", 0)
+		require.NoError(t, allocErr)
+		udpAddr, ok := relayAddr.(*net.UDPAddr)
+		require.True(t, ok)
+		return conn, udpAddr
+	}
+
+	// Allocations take turns on the addresses
+	first, firstAddr := allocate()
+	assert.True(t, firstAddr.IP.Equal(net.ParseIP("203.0.113.1")))
+	second, secondAddr := allocate()
+	assert.True(t, secondAddr.IP.Equal(net.ParseIP("203.0.113.2")))
+	assert.Equal(t, int(port), secondAddr.Port)
+	assert.Equal(t, []int{1, 1}, generator.PortsInUse())
+
+	// The second address has no free port left
+	third, thirdAddr := allocate()
+	assert.True(t, thirdAddr.IP.Equal(net.ParseIP("203.0.113.1")))
+	fourth, fourthAddr := allocate()
+	assert.True(t, fourthAddr.IP.Equal(net.ParseIP("203.0.113.1")))
+	assert.Equal(t, []int{3, 1}, generator.PortsInUse())
+
+	// Closing a relay frees its port
+	assert.NoError(t, second.Close())
+	assert.Equal(t, []int{3, 0}, generator.PortsInUse())
+	fifth, fifthAddr := allocate()
+	assert.True(t, fifthAddr.IP.Equal(net.ParseIP("203.0.113.1")))
+	second, secondAddr = allocate()
+	assert.True(t, secondAddr.IP.Equal(net.ParseIP("203.0.113.2")))
+
+	listener, relayAddr, err := generator.AllocateListener("tcp4", 0)
+	require.NoError(t, err)
+	assert.IsType(t, &net.TCPAddr{}, relayAddr)
+	assert.Equal(t, []int{5, 1}, generator.PortsInUse())
+	assert.NoError(t, listener.Close())
+
+	_, _, err = generator.AllocatePacketConn("udp6", 0)
+	assert.ErrorIs(t, err, ErrAddressFamilyNotSupported)
+
+	for _, conn := range []net.PacketConn{first, second, third, fourth, fifth} {
+		assert.NoError(t, conn.Close())
+	}
+	assert.Equal(t, []int{0, 0}, generator.PortsInUse())
+}
+
+func TestRelayAddressGeneratorDualStack(t *testing.T) {
+	assert.ErrorIs(t, (&RelayAddressGeneratorDualStack{}).Validate(), errDualStackGeneratorUnset)
+	assert.ErrorIs(t, (&RelayAddressGeneratorDualStack{
+		IPv4: &RelayAddressGeneratorStatic{RelayAddress: net.ParseIP("127.0.0.1"), Address: "127.0.0.1"},
+		IPv6: &RelayAddressGeneratorStatic{Address: "::1"},
+	}).Validate(), errRelayAddressInvalid)
+
+	generator := &RelayAddressGeneratorDualStack{
+		IPv4: &RelayAddressGeneratorStatic{RelayAddress: net.ParseIP("127.0.0.1"), Address: "127.0.0.1"},
+		IPv6: &RelayAddressGeneratorStatic{RelayAddress: net.ParseIP("::1"), Address: "::1"},
+	}
+	require.NoError(t, generator.Validate())
+
+	// Each address family is relayed by its own generator
+	for network, ip := range map[string]net.IP{"udp4": net.ParseIP("127.0.0.1"), "udp6": net.IPv6loopback} {
+		conn, relayAddr, err := generator.AllocatePacketConn(network, 0)
+		require.NoError(t, err)
+		udpAddr, ok := relayAddr.(*net.UDPAddr)
+		require.True(t, ok)
+		assert.True(t, udpAddr.IP.Equal(ip), "unexpected relayed address %s for %s", udpAddr, network)
+		assert.NoError(t, conn.Close())
+	}
+
+	conn, relayAddr, err := generator.AllocateEvenPacketConn("udp6")
+	require.NoError(t, err)
+	assert.Equal(t, 0, relayAddr.(*net.UDPAddr).Port%2) //nolint:forcetypeassert
+	assert.NoError(t, conn.Close())
+
+	generator.IPv6 = &RelayAddressGeneratorNone{Address: "::1"}
+	_, _, err = generator.AllocateEvenPacketConn("udp6")
+	assert.ErrorIs(t, err, errEvenPortUnsupported)
+}
+
+func TestRelayAddressGeneratorNAT(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	assert.ErrorIs(t, (&RelayAddressGeneratorNAT{}).Validate(), errAddressMappingsUnset)
+	assert.ErrorIs(t, (&RelayAddressGeneratorNAT{Mappings: []AddressMapping{{PublicIP: net.ParseIP("203.0.113.1")}}}).Validate(), errAddressMappingInvalid)
+
+	generator := &RelayAddressGeneratorNAT{Mappings: []AddressMapping{
+		{PublicIP: net.ParseIP("203.0.113.1"), PrivateIP: net.ParseIP("127.0.0.1")},
+		{PublicIP: net.ParseIP("203.0.113.2"), PrivateIP: net.ParseIP("127.0.0.2")},
+	}}
+	require.NoError(t, generator.Validate())
+
+	_, err := generator.ForListener(&net.UDPAddr{IP: net.ParseIP("127.0.0.3"), Port: 3478})
+	assert.ErrorIs(t, err, errNoAddressMapping)
+
+	// Listeners on an unspecified address get the first mapping
+	forAny, err := generator.ForListener(&net.UDPAddr{IP: net.IPv4zero, Port: 3478})
+	require.NoError(t, err)
+	conn, relayAddr, err := forAny.AllocatePacketConn("udp4", 0)
+	require.NoError(t, err)
+	assert.True(t, relayAddr.(*net.UDPAddr).IP.Equal(net.ParseIP("203.0.113.1"))) //nolint:forcetypeassert
+	assert.NoError(t, conn.Close())
+
+	_, _, err = forAny.AllocatePacketConn("udp6", 0)
+	assert.ErrorIs(t, err, ErrAddressFamilyNotSupported)
+
+	// Allocations get the mapping of the listener they arrive on
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("can't listen on 127.0.0.2: %s", err)
+	}
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{PacketConn: udpListener, RelayAddressGenerator: generator},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	conn, err = net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: serverAddr,
+		TURNServerAddr: serverAddr,
+		Conn:           conn,
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+	relayUDPAddr, ok := relayConn.LocalAddr().(*net.UDPAddr)
+	require.True(t, ok)
+	assert.True(t, relayUDPAddr.IP.Equal(net.ParseIP("203.0.113.2")))
+
+	// The relay is bound to the private IP of the mapping
+	_, err = net.ListenPacket("udp4", fmt.Sprintf("127.0.0.2:%d", relayUDPAddr.Port))
+	assert.Error(t, err)
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestRelayAddressGeneratorOnDemand(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr().String()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorOnDemand{&RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				}},
+			},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	newClient := func() (*Client, net.PacketConn, net.PacketConn) {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr,
+			TURNServerAddr: serverAddr,
+			Conn:           conn,
+			Username:       "user",
+			Password:       "pass",
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		relayConn, err := client.Allocate()
+		require.NoError(t, err)
+
+		return client, conn, relayConn
+	}
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Run("Bound on first permission", func(t *testing.T) {
+		client, conn, relayConn := newClient()
+
+		// The relay port is free until the first permission
+		squatter, err := net.ListenPacket("udp4", relayConn.LocalAddr().String())
+		require.NoError(t, err)
+		require.NoError(t, squatter.Close())
+
+		require.NoError(t, client.CreatePermission(peer.LocalAddr()))
+		_, err = net.ListenPacket("udp4", relayConn.LocalAddr().String())
+		assert.Error(t, err)
+
+		_, err = peer.WriteTo([]byte("hello"), relayConn.LocalAddr())
+		require.NoError(t, err)
+		buf := make([]byte, 100)
+		n, from, err := relayConn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(buf[:n]))
+		assert.Equal(t, peer.LocalAddr().String(), from.String())
+
+		assert.NoError(t, relayConn.Close())
+		client.Close()
+		assert.NoError(t, conn.Close())
+	})
+
+	t.Run("Port taken", func(t *testing.T) {
+		client, conn, relayConn := newClient()
+
+		squatter, err := net.ListenPacket("udp4", relayConn.LocalAddr().String())
+		require.NoError(t, err)
+		assert.True(t, IsInsufficientCapacity(client.CreatePermission(peer.LocalAddr())))
+		require.NoError(t, squatter.Close())
+
+		assert.NoError(t, relayConn.Close())
+		client.Close()
+		assert.NoError(t, conn.Close())
+	})
+
+	assert.NoError(t, peer.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerDSCP(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, config := range []ServerConfig{{DSCP: -1}, {DSCP: 64}} {
+		config.PacketConnConfigs = []PacketConnConfig{{}}
+		_, err := NewServer(config)
+		assert.True(t, errors.Is(err, errDSCPInvalid), "unexpected error: %v", err)
+	}
+	_, err := NewClient(&ClientConfig{LocalAddr: "127.0.0.1", DSCP: 64})
+	assert.True(t, errors.Is(err, errDSCPInvalid), "unexpected error: %v", err)
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandlerV2: func(ctx context.Context, req AuthRequest) ([]byte, SessionPolicy, bool) {
+			policy := SessionPolicy{}
+			if req.Username == "voice" {
+				policy.DSCP = 46
+			}
+			return GenerateAuthKey(req.Username, req.Realm, "pass"), policy, true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+		DSCP:  34,
+	})
+	require.NoError(t, err)
+
+	// Only Linux marks sockets, elsewhere they are left unmarked
+	marked := func(dscp int) int {
+		if runtime.GOOS != "linux" {
+			return 0
+		}
+		return dscp
+	}
+
+	for username, dscp := range map[string]int{"video": 34, "voice": 46} {
+		client, err := NewClient(&ClientConfig{
+			STUNServerAddr: serverAddr.String(),
+			TURNServerAddr: serverAddr.String(),
+			LocalAddr:      "127.0.0.1",
+			Username:       username,
+			Password:       "pass",
+			DSCP:           dscp,
+		})
+		require.NoError(t, err)
+		require.NoError(t, client.Listen())
+
+		relayConn, err := client.Allocate()
+		require.NoError(t, err)
+
+		a := server.allocationManagers[0].GetAllocation(&allocation.FiveTuple{
+			Protocol: allocation.UDP,
+			SrcAddr:  client.packetConn().LocalAddr(),
+			DstAddr:  serverAddr,
+		})
+		if assert.NotNil(t, a, username) {
+			assert.Equal(t, marked(dscp), a.DSCP(), username)
+		}
+
+		assert.NoError(t, relayConn.Close())
+		client.Close()
+	}
+
+	assert.NoError(t, server.Close())
+}
+
+func TestServerCloseAndVerify(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	tcpListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	relayAddressGenerator := &RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP("127.0.0.1"),
+		Address:      "127.0.0.1",
+	}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{PacketConn: udpListener, RelayAddressGenerator: relayAddressGenerator},
+		},
+		ListenerConfigs: []ListenerConfig{
+			{Listener: tcpListener, RelayAddressGenerator: relayAddressGenerator, TCPAllocations: true},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	// Allocations are left open over UDP and TCP, the control connection of the TCP
+	// client is closed by the server
+	udpClient, err := NewClient(&ClientConfig{
+		STUNServerAddr: udpListener.LocalAddr().String(),
+		TURNServerAddr: udpListener.LocalAddr().String(),
+		LocalAddr:      "127.0.0.1",
+		Username:       "user",
+		Password:       "pass",
+		RTO:            10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, udpClient.Listen())
+	udpRelayConn, err := udpClient.Allocate()
+	require.NoError(t, err)
+
+	controlConn, err := net.Dial("tcp4", tcpListener.Addr().String())
+	require.NoError(t, err)
+	tcpClient, err := NewClient(&ClientConfig{
+		Conn:           NewSTUNConn(controlConn),
+		STUNServerAddr: tcpListener.Addr().String(),
+		TURNServerAddr: tcpListener.Addr().String(),
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, tcpClient.Listen())
+	tcpAllocation, err := tcpClient.AllocateTCP()
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, server.AllocationCount())
+	assert.NotNil(t, server.leaks())
+
+	assert.NoError(t, server.CloseAndVerify())
+	assert.Nil(t, server.leaks())
+
+	// The allocations are gone already, only the clients stop refreshing them
+	assert.NoError(t, udpRelayConn.Close())
+	assert.NoError(t, tcpAllocation.Close())
+	udpClient.Close()
+	tcpClient.Close()
+	assert.NoError(t, controlConn.Close())
+}
+
+func TestLeakError(t *testing.T) {
+	err := &LeakError{Readers: 1, Handlers: 2}
+	assert.Equal(t, "turn: leaked after Close: 1 readers, 2 relay handlers", err.Error())
+}
+
+type closeErrorConn struct {
+	net.PacketConn
+	closed int32
+	err    error
+}
+
+func (c *closeErrorConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	_ = c.PacketConn.Close()
+	return c.err
+}
+
+func TestServerCloseOrder(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	errClose := errors.New("close failed")
+	listeners := []*closeErrorConn{}
+	packetConnConfigs := []PacketConnConfig{}
+	for i := 0; i < 2; i++ {
+		udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		listener := &closeErrorConn{PacketConn: udpListener}
+		listeners = append(listeners, listener)
+		packetConnConfigs = append(packetConnConfigs, PacketConnConfig{
+			PacketConn: listener,
+			RelayAddressGenerator: &RelayAddressGeneratorStatic{
+				RelayAddress: net.ParseIP("127.0.0.1"),
+				Address:      "127.0.0.1",
+			},
+		})
+	}
+	listeners[1].err = errClose
+
+	var lock sync.Mutex
+	deleted := 0
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: packetConnConfigs,
+		Realm:             "pion.ly",
+		OnAllocationDeleted: func(info AllocationInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			deleted++
+
+			// All listeners are closed, the relay socket is not yet
+			for _, listener := range listeners {
+				assert.Equal(t, int32(1), atomic.LoadInt32(&listener.closed))
+			}
+			conn, listenErr := net.ListenPacket("udp4", info.RelayAddr.String())
+			if !assert.Error(t, listenErr, "relay socket closed before its deletion event") {
+				_ = conn.Close()
+			}
+		},
+	})
+	require.NoError(t, err)
+
+	clients := []*Client{}
+	relayConns := []net.PacketConn{}
+	for _, listener := range listeners {
+		client, clientErr := NewClient(&ClientConfig{
+			STUNServerAddr: listener.LocalAddr().String(),
+			TURNServerAddr: listener.LocalAddr().String(),
+			LocalAddr:      "127.0.0.1",
+			Username:       "user",
+			Password:       "pass",
+			RTO:            10 * time.Millisecond,
+		})
+		require.NoError(t, clientErr)
+		require.NoError(t, client.Listen())
+		relayConn, allocErr := client.Allocate()
+		require.NoError(t, allocErr)
+
+		clients = append(clients, client)
+		relayConns = append(relayConns, relayConn)
+	}
+
+	err = server.Close()
+	assert.True(t, errors.Is(err, errFailedToClose), "unexpected error: %v", err)
+	assert.True(t, errors.Is(err, errClose), "unexpected error: %v", err)
+	assert.Equal(t, 2, deleted)
+
+	// The relay sockets are closed once Close returned
+	for _, relayConn := range relayConns {
+		conn, listenErr := net.ListenPacket("udp4", relayConn.LocalAddr().String())
+		if assert.NoError(t, listenErr) {
+			assert.NoError(t, conn.Close())
+		}
+	}
+
+	for i, client := range clients {
+		assert.NoError(t, relayConns[i].Close())
+		client.Close()
+	}
+}
+
+func TestServerAddRemoveListener(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	relayAddressGenerator := &RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP("127.0.0.1"),
+		Address:      "127.0.0.1",
+	}
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{PacketConn: udpListener, RelayAddressGenerator: relayAddressGenerator},
+		},
+		Realm: "pion.ly",
+	})
+	require.NoError(t, err)
+
+	addedPacketConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, server.AddPacketConn(PacketConnConfig{
+		PacketConn:            addedPacketConn,
+		RelayAddressGenerator: relayAddressGenerator,
+	}))
+	addedListener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	require.NoError(t, server.AddListener(ListenerConfig{
+		Listener:              addedListener,
+		RelayAddressGenerator: relayAddressGenerator,
+	}))
+	assert.Equal(t, errListenerUnset, server.AddListener(ListenerConfig{RelayAddressGenerator: relayAddressGenerator}))
+
+	// Allocate through the added listeners
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: addedPacketConn.LocalAddr().String(),
+		TURNServerAddr: addedPacketConn.LocalAddr().String(),
+		LocalAddr:      "127.0.0.1",
+		Username:       "user",
+		Password:       "pass",
+		RTO:            10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	controlConn, err := net.Dial("tcp4", addedListener.Addr().String())
+	require.NoError(t, err)
+	tcpClient, err := NewClient(&ClientConfig{
+		Conn:           NewSTUNConn(controlConn),
+		STUNServerAddr: addedListener.Addr().String(),
+		TURNServerAddr: addedListener.Addr().String(),
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, tcpClient.Listen())
+	tcpRelayConn, err := tcpClient.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, 2, server.AllocationCount())
+
+	// Removing a listener closes it and deletes its allocations
+	require.NoError(t, server.RemoveListener(addedPacketConn.LocalAddr()))
+	assert.Equal(t, 1, server.AllocationCount())
+	_, err = addedPacketConn.WriteTo([]byte("closed"), udpListener.LocalAddr())
+	assert.True(t, errors.Is(err, net.ErrClosed), "unexpected error: %v", err)
+	assert.Len(t, server.managers(), 2)
+
+	require.NoError(t, server.RemoveListener(addedListener.Addr()))
+	assert.Equal(t, 0, server.AllocationCount())
+	assert.Len(t, server.managers(), 1)
+
+	err = server.RemoveListener(addedPacketConn.LocalAddr())
+	assert.True(t, errors.Is(err, ErrListenerNotFound), "unexpected error: %v", err)
+
+	// The addresses of UDP and TCP listeners are told apart by their network
+	tcpAddr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: udpListener.LocalAddr().(*net.UDPAddr).Port} //nolint:forcetypeassert
+	err = server.RemoveListener(tcpAddr)
+	assert.True(t, errors.Is(err, ErrListenerNotFound), "unexpected error: %v", err)
+
+	assert.NoError(t, server.Close())
+
+	closedPacketConn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Equal(t, errServerClosed, server.AddPacketConn(PacketConnConfig{
+		PacketConn:            closedPacketConn,
+		RelayAddressGenerator: relayAddressGenerator,
+	}))
+	assert.NoError(t, closedPacketConn.Close())
+
+	assert.NoError(t, relayConn.Close())
+	assert.NoError(t, tcpRelayConn.Close())
+	client.Close()
+	tcpClient.Close()
+	assert.NoError(t, controlConn.Close())
+}
+
+func TestServerPassthrough(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := udpListener.LocalAddr()
+
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm: "pion.ly",
+		PassthroughHandler: func(conn net.PacketConn, srcAddr net.Addr, data []byte) {
+			_, writeErr := conn.WriteTo(append([]byte("echo "), data...), srcAddr)
+			assert.NoError(t, writeErr)
+		},
+	})
+	require.NoError(t, err)
+
+	// Datagrams of other protocols are passed through
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, err = conn.WriteTo([]byte("hello"), serverAddr)
+	require.NoError(t, err)
+
+	buf := make([]byte, 1500)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "echo hello", string(buf[:n]))
+
+	// TURN is still served on the same socket
+	client, err := NewClient(&ClientConfig{
+		Conn:           conn,
+		STUNServerAddr: serverAddr.String(),
+		TURNServerAddr: serverAddr.String(),
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+	assert.Equal(t, 1, server.AllocationCount())
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, conn.Close())
+	assert.NoError(t, server.Close())
+}
+
+func TestServerEchoPeer(t *testing.T) {
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	echoPeer := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 9}
+	server, err := NewServer(ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) (key []byte, ok bool) {
+			return GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &RelayAddressGeneratorStatic{
+					RelayAddress: net.ParseIP("127.0.0.1"),
+					Address:      "127.0.0.1",
+				},
+			},
+		},
+		Realm:    "pion.ly",
+		EchoPeer: echoPeer,
+	})
+	require.NoError(t, err)
+
+	client, err := NewClient(&ClientConfig{
+		STUNServerAddr: udpListener.LocalAddr().String(),
+		TURNServerAddr: udpListener.LocalAddr().String(),
+		LocalAddr:      "127.0.0.1",
+		Username:       "user",
+		Password:       "pass",
+	})
+	require.NoError(t, err)
+	require.NoError(t, client.Listen())
+	relayConn, err := client.Allocate()
+	require.NoError(t, err)
+
+	// The first datagrams are sent in Send indications, the following ones as ChannelData
+	// once the client bound a channel
+	buf := make([]byte, 1500)
+	for i := 0; i < 5; i++ {
+		payload := []byte(fmt.Sprintf("echo %d", i))
+		_, err = relayConn.WriteTo(payload, echoPeer)
+		require.NoError(t, err)
+
+		require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, from, readErr := relayConn.ReadFrom(buf)
+		require.NoError(t, readErr)
+		assert.Equal(t, payload, buf[:n])
+		assert.Equal(t, echoPeer.String(), from.String())
+	}
+
+	assert.NoError(t, relayConn.Close())
+	client.Close()
+	assert.NoError(t, server.Close())
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs: []PacketConnConfig{{PacketConn: udpListener, RelayAddressGenerator: &RelayAddressGeneratorNone{Address: "127.0.0.1"}}},
+		EchoPeer:          &net.UDPAddr{IP: net.ParseIP("192.0.2.1")},
+	})
+	assert.True(t, errors.Is(err, errEchoPeerInvalid), "unexpected error: %v", err)
+}
+
+func TestServerSendBufferStats(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	packetConnConfigs := []PacketConnConfig{
+		{
+			Name:       "edge",
+			PacketConn: udpListener,
+			RelayAddressGenerator: &RelayAddressGeneratorStatic{
+				RelayAddress: net.ParseIP("127.0.0.1"),
+				Address:      "127.0.0.1",
+			},
+		},
+	}
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs: packetConnConfigs,
+		SendBufferRetry:   &SendBufferRetryConfig{Attempts: -1},
+	})
+	assert.ErrorIs(t, err, errSendBufferRetryInvalid)
+
+	server, err := NewServer(ServerConfig{
+		PacketConnConfigs: packetConnConfigs,
+		SendBufferRetry:   &SendBufferRetryConfig{},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, allocation.SendBufferRetry{Attempts: 3, Interval: time.Millisecond}, server.sendBufferRetry)
+	assert.Equal(t, []SendBufferStats{{Listener: "edge"}}, server.SendBufferStats())
+
+	assert.NoError(t, server.Close())
+}
+
+func TestServerMaxRelayedPacketSize(t *testing.T) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	packetConnConfigs := []PacketConnConfig{
+		{
+			PacketConn: udpListener,
+			RelayAddressGenerator: &RelayAddressGeneratorStatic{
+				RelayAddress: net.ParseIP("127.0.0.1"),
+				Address:      "127.0.0.1",
+			},
+		},
+	}
+
+	_, err = NewServer(ServerConfig{
+		PacketConnConfigs:    packetConnConfigs,
+		MaxRelayedPacketSize: -1,
+	})
+	assert.ErrorIs(t, err, errMaxRelayedPacketSizeInvalid)
+
+	// The session policy of "small" caps its allocations below MaxRelayedPacketSize
+	m := metrics.New()
+	server, err := NewServer(ServerConfig{
+		AuthHandlerV2: func(ctx context.Context, req AuthRequest) ([]byte, SessionPolicy, bool) {
+			var sessionPolicy SessionPolicy
+			if req.Username == "small" {
+				sessionPolicy.MaxPacketSize = 50
+			}
+			return GenerateAuthKey(req.Username, req.Realm, "pass"), sessionPolicy, true
+		},
+		PacketConnConfigs:    packetConnConfigs,
+		Realm:                "pion.ly",
+		MaxRelayedPacketSize: 100,
+		Metrics:              m,
+	})
+	require.NoError(t, err)
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		username string
+		maxSize  int
+	}{
+		{"default", 100},
+		{"small", 50},
+	} {
+		t.Run(test.username, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			require.NoError(t, err)
+			client, err := NewClient(&ClientConfig{
+				Conn:           conn,
+				TURNServerAddr: udpListener.LocalAddr().String(),
+				Username:       test.username,
+				Password:       "pass",
+			})
+			require.NoError(t, err)
+			require.NoError(t, client.Listen())
+
+			relayConn, err := client.Allocate()
+			require.NoError(t, err)
+
+			// The oversized datagram is dropped in both directions, the following one relayed
+			buf := make([]byte, 1500)
+			for _, size := range []int{test.maxSize + 1, test.maxSize} {
+				_, err = relayConn.WriteTo(make([]byte, size), peer.LocalAddr())
+				require.NoError(t, err)
+			}
+			require.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, from, err := peer.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, test.maxSize, n)
+
+			for _, size := range []int{test.maxSize + 1, test.maxSize} {
+				_, err = peer.WriteTo(make([]byte, size), from)
+				require.NoError(t, err)
+			}
+			require.NoError(t, relayConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+			n, _, err = relayConn.ReadFrom(buf)
+			require.NoError(t, err)
+			assert.Equal(t, test.maxSize, n)
+
+			allocations := server.Allocations()
+			require.Len(t, allocations, 1)
+			assert.Equal(t, uint64(1), allocations[0].OversizedDropsToPeer)
+			assert.Equal(t, uint64(1), allocations[0].OversizedDropsToClient)
+
+			require.NoError(t, relayConn.Close())
+			client.Close()
+			require.NoError(t, conn.Close())
+		})
+	}
+	assert.Equal(t, [2]uint64{2, 2}, m.Snapshot().OversizedDrops)
+
+	require.NoError(t, peer.Close())
+	require.NoError(t, server.Close())
 }
@@ -0,0 +1,141 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package soak
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v3"
+)
+
+const roundTripTimeout = 5 * time.Second
+
+// Env is passed to the iterations of scenarios, it creates clients of the server and
+// relays datagrams to an echo peer
+type Env struct {
+	config   *Config
+	peer     net.PacketConn
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newEnv(config *Config) (*Env, error) {
+	peer, err := net.ListenPacket("udp", config.PeerAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the echo peer: %w", err)
+	}
+
+	e := &Env{
+		config: config,
+		peer:   peer,
+		done:   make(chan struct{}),
+	}
+	go e.echo()
+
+	return e, nil
+}
+
+func (e *Env) echo() {
+	buf := make([]byte, 1600)
+	for {
+		n, from, err := e.peer.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if _, err = e.peer.WriteTo(buf[:n], from); err != nil {
+			return
+		}
+	}
+}
+
+func (e *Env) stop() {
+	e.stopOnce.Do(func() {
+		close(e.done)
+	})
+}
+
+func (e *Env) close() {
+	e.stop()
+	_ = e.peer.Close()
+}
+
+// Done is closed when the soak ends
+func (e *Env) Done() <-chan struct{} {
+	return e.done
+}
+
+// Sleep waits for d, it returns false if the soak ended before
+func (e *Env) Sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-e.done:
+		return false
+	}
+}
+
+// PeerAddr returns the address of the echo peer, which sends every datagram back to
+// where it came from
+func (e *Env) PeerAddr() net.Addr {
+	return e.peer.LocalAddr()
+}
+
+// NewClient returns a listening client of the server, configure changes its config
+// before it is created. Close it with its allocations when the iteration ends
+func (e *Env) NewClient(configure func(*turn.ClientConfig)) (*turn.Client, error) {
+	config := turn.ClientConfig{
+		STUNServerAddr: e.config.ServerAddr,
+		TURNServerAddr: e.config.ServerAddr,
+		Username:       e.config.Username,
+		Password:       e.config.Password,
+		LocalAddr:      "0.0.0.0",
+		LoggerFactory:  e.config.LoggerFactory,
+	}
+	if configure != nil {
+		configure(&config)
+	}
+
+	client, err := turn.NewClient(&config)
+	if err != nil {
+		return nil, err
+	}
+	if err = client.Listen(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// RoundTrip relays payload from relayConn to the echo peer and waits for its echo
+func (e *Env) RoundTrip(relayConn net.PacketConn, payload []byte) error {
+	if _, err := relayConn.WriteTo(payload, e.PeerAddr()); err != nil {
+		return err
+	}
+
+	if err := relayConn.SetReadDeadline(time.Now().Add(roundTripTimeout)); err != nil {
+		return err
+	}
+	defer func() {
+		_ = relayConn.SetReadDeadline(time.Time{})
+	}()
+
+	buf := make([]byte, len(payload)+1)
+	n, _, err := relayConn.ReadFrom(buf)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(buf[:n], payload) {
+		return fmt.Errorf("%w: %d bytes", ErrEchoMismatch, n)
+	}
+
+	return nil
+}
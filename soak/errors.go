@@ -0,0 +1,27 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package soak
+
+import "errors"
+
+var (
+	// ErrServerAddrUnset is returned by Run without Config.ServerAddr
+	ErrServerAddrUnset = errors.New("soak: ServerAddr must be set")
+	// ErrNoScenarios is returned by Run without Config.Scenarios
+	ErrNoScenarios = errors.New("soak: no scenarios")
+	// ErrInvariantViolated is returned by Run if an invariant didn't hold
+	ErrInvariantViolated = errors.New("soak: invariant violated")
+	// ErrTooManyErrors is returned by Run if more iterations failed than Config.MaxErrors
+	ErrTooManyErrors = errors.New("soak: too many failed iterations")
+	// ErrEchoMismatch is returned by Env.RoundTrip if the echo of the peer differs
+	ErrEchoMismatch = errors.New("soak: echo mismatch")
+	// ErrLeakedAllocations is returned by the NoLeakedAllocations invariant
+	ErrLeakedAllocations = errors.New("soak: leaked allocations")
+	// ErrLeakedPorts is returned by the NoLeakedPorts invariant
+	ErrLeakedPorts = errors.New("soak: leaked ports")
+	// ErrMemoryGrowth is returned by the StableMemory invariant
+	ErrMemoryGrowth = errors.New("soak: memory grew")
+	// ErrGoroutineGrowth is returned by the StableGoroutines invariant
+	ErrGoroutineGrowth = errors.New("soak: goroutines grew")
+)
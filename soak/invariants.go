@@ -0,0 +1,96 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package soak
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// NoLeakedAllocations checks that count, e.g. Server.AllocationCount, returns to 0 once
+// the scenarios stopped
+func NoLeakedAllocations(count func() int) Invariant {
+	return Invariant{
+		Name: "no-leaked-allocations",
+		Check: func(final bool) error {
+			if !final {
+				return nil
+			}
+			if n := count(); n != 0 {
+				return fmt.Errorf("%w: %d", ErrLeakedAllocations, n)
+			}
+			return nil
+		},
+	}
+}
+
+// NoLeakedPorts checks that inUse, e.g. the InUse of a PortRangeAllocator for the relay
+// address, returns to its value when NoLeakedPorts was called once the scenarios stopped
+func NoLeakedPorts(inUse func() int) Invariant {
+	baseline := inUse()
+
+	return Invariant{
+		Name: "no-leaked-ports",
+		Check: func(final bool) error {
+			if !final {
+				return nil
+			}
+			if n := inUse(); n > baseline {
+				return fmt.Errorf("%w: %d", ErrLeakedPorts, n-baseline)
+			}
+			return nil
+		},
+	}
+}
+
+// StableMemory checks that the heap in use of the process grows by at most maxGrowth bytes
+// over its size at the first check, after the scenarios warmed up. The server must run in
+// the process of the soak
+func StableMemory(maxGrowth uint64) Invariant {
+	var (
+		lock     sync.Mutex
+		baseline uint64
+	)
+
+	return Invariant{
+		Name: "stable-memory",
+		Check: func(bool) error {
+			runtime.GC()
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+
+			lock.Lock()
+			defer lock.Unlock()
+			if baseline == 0 {
+				baseline = stats.HeapInuse
+				return nil
+			}
+			if stats.HeapInuse > baseline+maxGrowth {
+				return fmt.Errorf("%w: from %d to %d bytes", ErrMemoryGrowth, baseline, stats.HeapInuse)
+			}
+			return nil
+		},
+	}
+}
+
+// StableGoroutines checks that the number of goroutines of the process grew by at most
+// maxGrowth over its number when StableGoroutines was called once the scenarios stopped.
+// The server must run in the process of the soak
+func StableGoroutines(maxGrowth int) Invariant {
+	baseline := runtime.NumGoroutine()
+
+	return Invariant{
+		Name: "stable-goroutines",
+		Check: func(final bool) error {
+			if !final {
+				return nil
+			}
+			if n := runtime.NumGoroutine(); n > baseline+maxGrowth {
+				return fmt.Errorf("%w: from %d to %d", ErrGoroutineGrowth, baseline, n)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package soak
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/turn/v3"
+)
+
+// withAllocation runs f with the allocation of a new client, and deletes it afterwards
+func withAllocation(env *Env, configure func(*turn.ClientConfig), f func(relayConn net.PacketConn) error) error {
+	client, err := env.NewClient(configure)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	relayConn, err := client.Allocate()
+	if err != nil {
+		return fmt.Errorf("failed to allocate: %w", err)
+	}
+
+	err = f(relayConn)
+	if closeErr := relayConn.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to delete allocation: %w", closeErr)
+	}
+	return err
+}
+
+// AllocationChurn creates allocations with workers clients, relays a datagram through
+// each and deletes it after hold. It exercises the allocation and release of relay ports
+func AllocationChurn(workers int, hold time.Duration) Scenario {
+	return Scenario{
+		Name:    "allocation-churn",
+		Workers: workers,
+		Iterate: func(env *Env) error {
+			return withAllocation(env, nil, func(relayConn net.PacketConn) error {
+				if err := env.RoundTrip(relayConn, []byte("churn")); err != nil {
+					return err
+				}
+				env.Sleep(hold)
+				return nil
+			})
+		},
+	}
+}
+
+// RefreshStorm holds allocations of workers clients for hold, refreshing them every
+// interval, and relays a datagram through each before deleting it
+func RefreshStorm(workers int, interval, hold time.Duration) Scenario {
+	configure := func(config *turn.ClientConfig) {
+		config.RefreshInterval = func(time.Duration) time.Duration {
+			return interval
+		}
+	}
+
+	return Scenario{
+		Name:    "refresh-storm",
+		Workers: workers,
+		Iterate: func(env *Env) error {
+			return withAllocation(env, configure, func(relayConn net.PacketConn) error {
+				if !env.Sleep(hold) {
+					return nil
+				}
+				return env.RoundTrip(relayConn, []byte("storm"))
+			})
+		},
+	}
+}
+
+// BindingExpiry relays datagrams to a peer after idling for just under and just over
+// lifetime, the lifetime of the permissions or channel bindings of the server, e.g. 5
+// minutes for the default PermissionTimeout. The datagrams must be relayed on either side
+// of the boundary, the client refreshing what would expire
+func BindingExpiry(lifetime, margin time.Duration) Scenario {
+	return Scenario{
+		Name: "binding-expiry",
+		Iterate: func(env *Env) error {
+			return withAllocation(env, nil, func(relayConn net.PacketConn) error {
+				if err := env.RoundTrip(relayConn, []byte("bind")); err != nil {
+					return err
+				}
+				for _, idle := range []time.Duration{lifetime - margin, lifetime + margin} {
+					if !env.Sleep(idle) {
+						return nil
+					}
+					if err := env.RoundTrip(relayConn, []byte("expiry")); err != nil {
+						return fmt.Errorf("after idling %v: %w", idle, err)
+					}
+				}
+				return nil
+			})
+		},
+	}
+}
@@ -0,0 +1,260 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package soak runs long-running scenarios against a TURN server and checks invariants
+// while they run and once they stopped, e.g. that no relay ports leaked and that the
+// memory of the server is stable. Packagers validate patches with multi-hour runs of the
+// built-in scenarios:
+//
+//	report, err := soak.Run(soak.Config{
+//		ServerAddr: "127.0.0.1:3478",
+//		Username:   "user",
+//		Password:   "pass",
+//		Duration:   4 * time.Hour,
+//		Scenarios: []soak.Scenario{
+//			soak.AllocationChurn(50, time.Second),
+//			soak.RefreshStorm(10, time.Second, time.Minute),
+//			soak.BindingExpiry(10*time.Minute, 5*time.Second),
+//		},
+//		Invariants: []soak.Invariant{
+//			soak.NoLeakedAllocations(server.AllocationCount),
+//			soak.StableMemory(64 << 20),
+//		},
+//	})
+package soak
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+const (
+	defaultDuration      = time.Hour
+	defaultCheckInterval = time.Minute
+	defaultDrainTimeout  = 30 * time.Second
+	drainPollInterval    = 100 * time.Millisecond
+)
+
+// Config configures a soak run
+type Config struct {
+	// ServerAddr is the UDP address of the TURN server the clients connect to
+	ServerAddr string
+
+	// Username and Password are the long-term credentials of the clients
+	Username string
+	Password string
+
+	// PeerAddr is the address the echo peer the clients relay to listens on, it must be
+	// reachable from the relays of the server. Defaults to 127.0.0.1:0
+	PeerAddr string
+
+	// Duration is how long the scenarios run. Defaults to 1 hour
+	Duration time.Duration
+
+	// Scenarios run concurrently for Duration
+	Scenarios []Scenario
+
+	// Invariants are checked every CheckInterval while the scenarios run, and once they
+	// stopped. The final checks are retried for up to DrainTimeout, e.g. until the server
+	// deleted the allocations of the closed clients. CheckInterval defaults to 1 minute and
+	// DrainTimeout to 30 seconds
+	Invariants    []Invariant
+	CheckInterval time.Duration
+	DrainTimeout  time.Duration
+
+	// MaxErrors is the number of failed iterations tolerated, e.g. of datagrams lost on
+	// the way. None are tolerated if 0
+	MaxErrors int
+
+	LoggerFactory logging.LoggerFactory
+}
+
+// Scenario is a workload run for the duration of the soak
+type Scenario struct {
+	// Name identifies the scenario in the Report
+	Name string
+
+	// Workers is the number of goroutines calling Iterate. Defaults to 1
+	Workers int
+
+	// Iterate runs one iteration of the scenario, e.g. allocates, relays a datagram and
+	// deletes the allocation. It is called in a loop until the soak ends, long iterations
+	// wait with Env.Sleep to end with it
+	Iterate func(env *Env) error
+}
+
+// Invariant is a property of the server that must hold during the soak
+type Invariant struct {
+	// Name identifies the invariant in violations
+	Name string
+
+	// Check returns an error if the invariant doesn't hold. It is called every
+	// CheckInterval while the scenarios run with final false, and after they stopped
+	// with final true
+	Check func(final bool) error
+}
+
+// ScenarioReport is the outcome of a Scenario
+type ScenarioReport struct {
+	Iterations int
+	Errors     int
+	LastError  error
+}
+
+// Report is the outcome of a soak run
+type Report struct {
+	Duration   time.Duration
+	Scenarios  map[string]*ScenarioReport
+	Violations []error
+}
+
+// Errors returns the number of failed iterations of all scenarios
+func (r *Report) Errors() int {
+	errors := 0
+	for _, s := range r.Scenarios {
+		errors += s.Errors
+	}
+	return errors
+}
+
+// Run runs the scenarios of config and checks its invariants. It returns an error
+// wrapping ErrInvariantViolated if an invariant didn't hold, and ErrTooManyErrors if more
+// than MaxErrors iterations failed. The Report is returned in both cases
+func Run(config Config) (*Report, error) {
+	switch {
+	case config.ServerAddr == "":
+		return nil, ErrServerAddrUnset
+	case len(config.Scenarios) == 0:
+		return nil, ErrNoScenarios
+	}
+	if config.Duration == 0 {
+		config.Duration = defaultDuration
+	}
+	if config.CheckInterval == 0 {
+		config.CheckInterval = defaultCheckInterval
+	}
+	if config.DrainTimeout == 0 {
+		config.DrainTimeout = defaultDrainTimeout
+	}
+	if config.PeerAddr == "" {
+		config.PeerAddr = "127.0.0.1:0"
+	}
+	if config.LoggerFactory == nil {
+		config.LoggerFactory = logging.NewDefaultLoggerFactory()
+	}
+	log := config.LoggerFactory.NewLogger("soak")
+
+	env, err := newEnv(&config)
+	if err != nil {
+		return nil, err
+	}
+	defer env.close()
+
+	r := &runner{
+		log:    log,
+		report: &Report{Scenarios: map[string]*ScenarioReport{}},
+	}
+
+	start := time.Now()
+	timer := time.AfterFunc(config.Duration, env.stop)
+	defer timer.Stop()
+
+	var wg sync.WaitGroup
+	for _, scenario := range config.Scenarios {
+		r.report.Scenarios[scenario.Name] = &ScenarioReport{}
+
+		workers := scenario.Workers
+		if workers <= 0 {
+			workers = 1
+		}
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(scenario Scenario) {
+				defer wg.Done()
+				r.iterate(env, scenario)
+			}(scenario)
+		}
+	}
+
+	ticker := time.NewTicker(config.CheckInterval)
+	scenariosDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(scenariosDone)
+	}()
+checks:
+	for {
+		select {
+		case <-ticker.C:
+			for _, invariant := range config.Invariants {
+				r.check(invariant, false)
+			}
+		case <-scenariosDone:
+			break checks
+		}
+	}
+	ticker.Stop()
+
+	for _, invariant := range config.Invariants {
+		deadline := time.Now().Add(config.DrainTimeout)
+		for invariant.Check(true) != nil && time.Now().Before(deadline) {
+			time.Sleep(drainPollInterval)
+		}
+		r.check(invariant, true)
+	}
+
+	r.report.Duration = time.Since(start)
+	switch {
+	case len(r.report.Violations) != 0:
+		return r.report, fmt.Errorf("%w: %v", ErrInvariantViolated, r.report.Violations[0])
+	case r.report.Errors() > config.MaxErrors:
+		return r.report, fmt.Errorf("%w: %d", ErrTooManyErrors, r.report.Errors())
+	}
+	return r.report, nil
+}
+
+type runner struct {
+	log    logging.LeveledLogger
+	lock   sync.Mutex
+	report *Report
+}
+
+func (r *runner) iterate(env *Env, scenario Scenario) {
+	for {
+		select {
+		case <-env.Done():
+			return
+		default:
+		}
+
+		err := scenario.Iterate(env)
+
+		r.lock.Lock()
+		s := r.report.Scenarios[scenario.Name]
+		s.Iterations++
+		if err != nil {
+			s.Errors++
+			s.LastError = err
+		}
+		r.lock.Unlock()
+
+		if err != nil {
+			r.log.Warnf("Iteration of %s failed: %v", scenario.Name, err)
+		}
+	}
+}
+
+func (r *runner) check(invariant Invariant, final bool) {
+	err := invariant.Check(final)
+	if err == nil {
+		return
+	}
+
+	r.log.Warnf("Invariant %s violated: %v", invariant.Name, err)
+	r.lock.Lock()
+	r.report.Violations = append(r.report.Violations, fmt.Errorf("%s: %w", invariant.Name, err))
+	r.lock.Unlock()
+}
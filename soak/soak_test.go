@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package soak
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/randutil"
+	"github.com/pion/transport/v3/test"
+	"github.com/pion/turn/v3"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T, ports *turn.PortRangeAllocator) (*turn.Server, string) {
+	udpListener, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		AuthHandler: func(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+			return turn.GenerateAuthKey(username, realm, "pass"), true
+		},
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &turn.RelayAddressGeneratorStatic{
+					RelayAddress:  net.ParseIP("127.0.0.1"),
+					Address:       "127.0.0.1",
+					PortAllocator: ports,
+				},
+			},
+		},
+		Realm:         "pion.ly",
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+	})
+	assert.NoError(t, err)
+
+	return server, udpListener.LocalAddr().String()
+}
+
+func TestRun(t *testing.T) {
+	lim := test.TimeOut(30 * time.Second)
+	defer lim.Stop()
+
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ports := &turn.PortRangeAllocator{MinPort: 40000, MaxPort: 45000, Rand: randutil.NewMathRandomGenerator()}
+	server, serverAddr := newServer(t, ports)
+	defer func() {
+		assert.NoError(t, server.Close())
+	}()
+
+	soakReport, err := Run(Config{
+		ServerAddr: serverAddr,
+		Username:   "user",
+		Password:   "pass",
+		Duration:   3 * time.Second,
+		Scenarios: []Scenario{
+			AllocationChurn(4, 10*time.Millisecond),
+			RefreshStorm(2, 50*time.Millisecond, 500*time.Millisecond),
+			BindingExpiry(time.Second, 300*time.Millisecond),
+		},
+		Invariants: []Invariant{
+			NoLeakedAllocations(server.AllocationCount),
+			NoLeakedPorts(func() int {
+				return ports.InUse("udp4", net.ParseIP("127.0.0.1"))
+			}),
+			StableMemory(64 << 20),
+		},
+		CheckInterval: 500 * time.Millisecond,
+		DrainTimeout:  5 * time.Second,
+	})
+	assert.NoError(t, err)
+
+	for _, name := range []string{"allocation-churn", "refresh-storm", "binding-expiry"} {
+		s := soakReport.Scenarios[name]
+		if assert.NotNil(t, s, name) {
+			assert.NotZero(t, s.Iterations, name)
+			assert.Zero(t, s.Errors, "%s: %v", name, s.LastError)
+		}
+	}
+	assert.Empty(t, soakReport.Violations)
+}
+
+func TestRunViolation(t *testing.T) {
+	lim := test.TimeOut(30 * time.Second)
+	defer lim.Stop()
+
+	errBroken := errors.New("broken")
+	soakReport, err := Run(Config{
+		ServerAddr: "127.0.0.1:1",
+		Duration:   100 * time.Millisecond,
+		Scenarios: []Scenario{
+			{
+				Name: "idle",
+				Iterate: func(env *Env) error {
+					env.Sleep(time.Second)
+					return nil
+				},
+			},
+		},
+		Invariants: []Invariant{
+			{
+				Name: "broken",
+				Check: func(final bool) error {
+					return errBroken
+				},
+			},
+		},
+		DrainTimeout: 200 * time.Millisecond,
+	})
+	assert.True(t, errors.Is(err, ErrInvariantViolated), "unexpected error: %v", err)
+	assert.Len(t, soakReport.Violations, 1)
+	assert.True(t, errors.Is(soakReport.Violations[0], errBroken))
+}
+
+func TestRunConfig(t *testing.T) {
+	_, err := Run(Config{})
+	assert.Equal(t, ErrServerAddrUnset, err)
+
+	_, err = Run(Config{ServerAddr: "127.0.0.1:3478"})
+	assert.Equal(t, ErrNoScenarios, err)
+}
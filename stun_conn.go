@@ -16,6 +16,7 @@ import (
 var (
 	errInvalidTURNFrame    = errors.New("data is not a valid TURN frame, no STUN or ChannelData found")
 	errIncompleteTURNFrame = errors.New("data contains incomplete STUN or TURN frame")
+	errSTUNConnDetached    = errors.New("connection was detached for use as a data connection")
 )
 
 // STUNConn wraps a net.Conn and implements
@@ -24,6 +25,7 @@ var (
 type STUNConn struct {
 	nextConn net.Conn
 	buff     []byte
+	detached bool
 }
 
 const (
@@ -39,56 +41,65 @@ const (
 // If the buffer isn't a valid STUN or ChannelData packet
 // or the length doesn't match return false
 func consumeSingleTURNFrame(p []byte) (int, error) {
-	// Too short to determine if ChannelData or STUN
-	if len(p) < 9 {
+	// Too short to determine if ChannelData or STUN, ChannelData with up to 4 bytes of
+	// data is shorter than a STUN header
+	if len(p) < channelDataHeaderSize {
 		return 0, errIncompleteTURNFrame
 	}
 
-	var datagramSize uint16
+	// The sizes are computed as int, the length fields plus header and padding overflow
+	// uint16
+	var datagramSize int
 	switch {
-	case stun.IsMessage(p):
-		datagramSize = binary.BigEndian.Uint16(p[2:4]) + stunHeaderSize
 	case proto.ChannelNumber(binary.BigEndian.Uint16(p[0:2])).Valid():
-		datagramSize = binary.BigEndian.Uint16(p[channelDataNumberSize:channelDataHeaderSize])
-		if paddingOverflow := (datagramSize + channelDataPadding) % channelDataPadding; paddingOverflow != 0 {
-			datagramSize = (datagramSize + channelDataPadding) - paddingOverflow
+		datagramSize = int(binary.BigEndian.Uint16(p[channelDataNumberSize:channelDataHeaderSize]))
+		if paddingOverflow := datagramSize % channelDataPadding; paddingOverflow != 0 {
+			datagramSize += channelDataPadding - paddingOverflow
 		}
 
 		datagramSize += channelDataHeaderSize
 	case len(p) < stunHeaderSize:
 		return 0, errIncompleteTURNFrame
+	case stun.IsMessage(p):
+		datagramSize = int(binary.BigEndian.Uint16(p[2:4])) + stunHeaderSize
 	default:
 		return 0, errInvalidTURNFrame
 	}
 
-	if len(p) < int(datagramSize) {
+	if len(p) < datagramSize {
 		return 0, errIncompleteTURNFrame
 	}
 
-	return int(datagramSize), nil
+	return datagramSize, nil
 }
 
-// ReadFrom implements ReadFrom from net.PacketConn
+// ReadFrom implements ReadFrom from net.PacketConn. It returns one STUN message or
+// ChannelData message, including its padding, at a time, however the stream was split into
+// reads. Like with UDP sockets, the excess of frames longer than p is discarded
 func (s *STUNConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
-	// First pass any buffered data from previous reads
-	n, err = consumeSingleTURNFrame(s.buff)
-	if errors.Is(err, errInvalidTURNFrame) {
-		return 0, nil, err
-	} else if err == nil {
-		copy(p, s.buff[:n])
-		s.buff = s.buff[n:]
-
-		return n, s.nextConn.RemoteAddr(), nil
+	if s.detached {
+		return 0, nil, errSTUNConnDetached
 	}
 
-	// Then read from the nextConn, appending to our buff
-	n, err = s.nextConn.Read(p)
-	if err != nil {
-		return 0, nil, err
-	}
+	for {
+		// First pass any buffered data from previous reads, e.g. coalesced frames
+		n, err = consumeSingleTURNFrame(s.buff)
+		if errors.Is(err, errInvalidTURNFrame) {
+			return 0, nil, err
+		} else if err == nil {
+			frame := s.buff[:n]
+			s.buff = s.buff[n:]
+
+			return copy(p, frame), s.nextConn.RemoteAddr(), nil
+		}
 
-	s.buff = append(s.buff, append([]byte{}, p[:n]...)...)
-	return s.ReadFrom(p)
+		// Then read from the nextConn, appending to our buff until a frame is complete
+		n, err = s.nextConn.Read(p)
+		if err != nil {
+			return 0, nil, err
+		}
+		s.buff = append(s.buff, p[:n]...)
+	}
 }
 
 // WriteTo implements WriteTo from net.PacketConn
@@ -121,6 +132,17 @@ func (s *STUNConn) SetWriteDeadline(t time.Time) error {
 	return s.nextConn.SetWriteDeadline(t)
 }
 
+// detach stops the framing of the stream and returns the underlying connection and
+// any data that was read from it but not returned by ReadFrom yet. Used by the server
+// to turn a connection into an RFC 6062 data connection after a ConnectionBind
+func (s *STUNConn) detach() (net.Conn, []byte) {
+	s.detached = true
+	buff := s.buff
+	s.buff = nil
+
+	return s.nextConn, buff
+}
+
 // NewSTUNConn creates a STUNConn
 func NewSTUNConn(nextConn net.Conn) *STUNConn {
 	return &STUNConn{nextConn: nextConn}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package tracing traces the Allocate, Refresh, CreatePermission and ChannelBind
+// transactions of TURN clients and servers. Set ServerConfig.Tracer or ClientConfig.Tracer
+// to a Tracer backed by your tracing library, e.g. OpenTelemetry:
+//
+//	type otelTracer struct{ trace.Tracer }
+//
+//	func (t otelTracer) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+//		ctx, span := t.Tracer.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+//	type otelSpan struct{ trace.Span }
+//
+//	func (s otelSpan) SetAttribute(key string, value interface{}) {
+//		switch v := value.(type) {
+//		case int:
+//			s.SetAttributes(attribute.Int(key, v))
+//		default:
+//			s.SetAttributes(attribute.String(key, fmt.Sprint(v)))
+//		}
+//	}
+//
+//	func (s otelSpan) End() { s.Span.End() }
+package tracing
+
+import (
+	"context"
+
+	"github.com/pion/stun/v2"
+)
+
+// Attribute keys set on spans
+const (
+	// AttributeMethod is the STUN method of the transaction, e.g. "Allocate"
+	AttributeMethod = "turn.method"
+	// AttributeUsername is the USERNAME of the request, if it has one
+	AttributeUsername = "turn.username"
+	// AttributeRealm is the REALM of the request, if it has one
+	AttributeRealm = "turn.realm"
+	// AttributeResultCode is 0 for success responses and the error code of error
+	// responses. Not set if no response was received or sent
+	AttributeResultCode = "turn.result_code"
+	// AttributePeerAddr is the address of the server on clients, and of the client on
+	// servers
+	AttributePeerAddr = "net.peer.addr"
+)
+
+// Tracer starts spans
+type Tracer interface {
+	// Start starts a span named name as child of the span in ctx, if any
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced transaction
+type Span interface {
+	// SetAttribute sets an attribute of the span. value is a string or an int
+	SetAttribute(key string, value interface{})
+	// End ends the span
+	End()
+}
+
+// Traced returns true if transactions of method are traced
+func Traced(method stun.Method) bool {
+	switch method {
+	case stun.MethodAllocate, stun.MethodRefresh, stun.MethodCreatePermission, stun.MethodChannelBind:
+		return true
+	default:
+		return false
+	}
+}
+
+// SpanName returns the name of the spans of transactions of method, prefixed with side,
+// e.g. "turn.server.Allocate"
+func SpanName(side string, method stun.Method) string {
+	return "turn." + side + "." + method.String()
+}
+
+// SetRequestAttributes sets the method, USERNAME and REALM of the request m on span
+func SetRequestAttributes(span Span, m *stun.Message) {
+	span.SetAttribute(AttributeMethod, m.Type.Method.String())
+
+	var username stun.Username
+	if username.GetFrom(m) == nil {
+		span.SetAttribute(AttributeUsername, username.String())
+	}
+
+	var realm stun.Realm
+	if realm.GetFrom(m) == nil {
+		span.SetAttribute(AttributeRealm, realm.String())
+	}
+}
+
+// SetResultAttributes sets the result code of the response m on span
+func SetResultAttributes(span Span, m *stun.Message) {
+	switch m.Type.Class {
+	case stun.ClassSuccessResponse:
+		span.SetAttribute(AttributeResultCode, 0)
+	case stun.ClassErrorResponse:
+		var code stun.ErrorCodeAttribute
+		if code.GetFrom(m) == nil {
+			span.SetAttribute(AttributeResultCode, int(code.Code))
+		}
+	default:
+	}
+}
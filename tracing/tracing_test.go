@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package tracing
+
+import (
+	"testing"
+
+	"github.com/pion/stun/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSpan map[string]interface{}
+
+func (s testSpan) SetAttribute(key string, value interface{}) { s[key] = value }
+
+func (s testSpan) End() {}
+
+func TestAttributes(t *testing.T) {
+	assert.True(t, Traced(stun.MethodAllocate))
+	assert.True(t, Traced(stun.MethodChannelBind))
+	assert.False(t, Traced(stun.MethodBinding))
+	assert.Equal(t, "turn.server.Allocate", SpanName("server", stun.MethodAllocate))
+
+	request, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest),
+		stun.NewUsername("user"), stun.NewRealm("pion.ly"))
+	assert.NoError(t, err)
+
+	span := testSpan{}
+	SetRequestAttributes(span, request)
+	assert.Equal(t, testSpan{AttributeMethod: "Allocate", AttributeUsername: "user", AttributeRealm: "pion.ly"}, span)
+
+	span = testSpan{}
+	SetRequestAttributes(span, &stun.Message{Type: stun.NewType(stun.MethodRefresh, stun.ClassRequest)})
+	assert.Equal(t, testSpan{AttributeMethod: "Refresh"}, span)
+
+	success, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse))
+	assert.NoError(t, err)
+	SetResultAttributes(span, success)
+	assert.Equal(t, 0, span[AttributeResultCode])
+
+	failure, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), stun.CodeUnauthorized)
+	assert.NoError(t, err)
+	SetResultAttributes(span, failure)
+	assert.Equal(t, 401, span[AttributeResultCode])
+}